@@ -0,0 +1,74 @@
+// Package browser defines the interactive browser surface shared by the headless browser backends - PhantomJS,
+// SlimerJS, and Chromium.
+package browser
+
+// RemotePageInfo describes the title and URL of the browser page.
+type RemotePageInfo struct {
+	Title string `json:"title"`
+	URL   string `json:"page_url"`
+}
+
+// ElementInfo tells about an element encountered while navigating around DOM in line-oriented browser.
+type ElementInfo struct {
+	TagName   string      `json:"tag"`   // TagName is the HTML tag name.
+	ID        string      `json:"id"`    // ID is DOM element's ID.
+	Name      string      `json:"name"`  // Name is DOM element's name.
+	Value     interface{} `json:"value"` // Value is DOM element's value.
+	InnerHTML string      `json:"inner"` // InnerHTML is DOM element's inner HTML.
+}
+
+// Renderer is a single headless browser instance that is remotely controlled by interactive and line-oriented commands.
+type Renderer interface {
+	// GetIndex returns the instance number assigned by renderer lifecycle management.
+	GetIndex() int
+	// GetTag returns the string that uniquely identifies the browser instance after it is started.
+	GetTag() string
+	// GetDebugOutput retrieves the latest debug output from the browser.
+	GetDebugOutput() string
+	// GetRenderPageFilePath returns the absolute path to web page screenshot.
+	GetRenderPageFilePath() string
+	// SetRenderArea sets the rectangular area for the next captured page screen shot.
+	SetRenderArea(top, left, width, height int) error
+	// RenderPage captures page screenshot and saves it into the file at GetRenderPageFilePath.
+	RenderPage() error
+	// Kill stops the browser and cleans up after its temporary files.
+	Kill()
+	// GoBack navigates browser backward in history.
+	GoBack() error
+	// GoForward navigates browser forward in history.
+	GoForward() error
+	// Reload reloads the current page.
+	Reload() error
+	// GoTo navigates to a new URL.
+	GoTo(userAgent, pageURL string, width, height int) error
+	// Pointer sends pointer to move/click at a coordinate.
+	Pointer(actionType, button string, x, y int) error
+	// SendKey either sends a key string or a key code into the currently focused element on page.
+	SendKey(aString string, aCode int64) error
+	// GetPageInfo returns title and URL of the current page.
+	GetPageInfo() (RemotePageInfo, error)
+	// LOResetNavigation resets line-oriented navigation so that the next element is the first element on page.
+	LOResetNavigation() error
+	// LONextElement navigates to the next element in DOM, and returns the previous, current, and next element.
+	LONextElement() ([]ElementInfo, error)
+	// LOPreviousElement navigates to the previous element in DOM, and returns the previous, current, and next element.
+	LOPreviousElement() ([]ElementInfo, error)
+	// LONextNElements navigates across the next N elements in DOM and returns them.
+	LONextNElements(n int) ([]ElementInfo, error)
+	// LOPointer sends pointer to click/move to at coordinate of the currently focused element.
+	LOPointer(actionType, button string) error
+	// LOSetValue sets the value of currently focused element.
+	LOSetValue(value string) error
+}
+
+// Renderers manage lifecycle of a fixed number of browser instances of a backend.
+type Renderers interface {
+	// Initialise checks configuration and initialises internal states.
+	Initialise() error
+	// AcquireRenderer starts a new browser instance, killing an existing instance if necessary to make room for it.
+	AcquireRenderer() (int, Renderer, error)
+	// RetrieveRenderer returns the browser instance of the index and tag, or nil if it no longer exists.
+	RetrieveRenderer(index int, expectedTag string) Renderer
+	// KillAll forcibly stops all browser instances.
+	KillAll()
+}
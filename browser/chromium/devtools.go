@@ -0,0 +1,132 @@
+package chromium
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DevToolsError is the error responded by Chromium to a DevTools protocol command.
+type DevToolsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *DevToolsError) Error() string {
+	return fmt.Sprintf("%s (%d)", err.Message, err.Code)
+}
+
+// devToolsMessage is a DevTools protocol command response or event sent by Chromium.
+type devToolsMessage struct {
+	ID     int64           `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *DevToolsError  `json:"error"`
+}
+
+/*
+DevToolsClient sends DevTools protocol commands to a Chromium page target over websocket and waits for their
+responses. Events sent by Chromium are discarded.
+*/
+type DevToolsClient struct {
+	ws      *WebSocketConn
+	mutex   *sync.Mutex
+	lastID  int64
+	pending map[int64]chan devToolsMessage
+	closed  chan struct{}
+	readErr error
+}
+
+// NewDevToolsClient connects to the DevTools websocket URL of a page target.
+func NewDevToolsClient(wsURL string, timeoutSec int) (*DevToolsClient, error) {
+	ws, err := DialWebSocket(wsURL, timeoutSec)
+	if err != nil {
+		return nil, err
+	}
+	client := &DevToolsClient{
+		ws:      ws,
+		mutex:   new(sync.Mutex),
+		pending: make(map[int64]chan devToolsMessage),
+		closed:  make(chan struct{}),
+	}
+	go client.readLoop()
+	return client, nil
+}
+
+// readLoop delivers command responses to their callers until the connection is closed.
+func (client *DevToolsClient) readLoop() {
+	for {
+		raw, err := client.ws.ReadMessage()
+		if err != nil {
+			client.mutex.Lock()
+			client.readErr = err
+			client.mutex.Unlock()
+			close(client.closed)
+			return
+		}
+		var msg devToolsMessage
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.ID == 0 {
+			// Ignore events and malformed messages
+			continue
+		}
+		client.mutex.Lock()
+		if receiver, exists := client.pending[msg.ID]; exists {
+			receiver <- msg
+			delete(client.pending, msg.ID)
+		}
+		client.mutex.Unlock()
+	}
+}
+
+// Call sends a command with parameters and waits up to the timeout for its response, which is optionally deserialised into the receiver.
+func (client *DevToolsClient) Call(method string, params map[string]interface{}, jsonReceiver interface{}, timeoutSec int) error {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	receiver := make(chan devToolsMessage, 1)
+	client.mutex.Lock()
+	if client.readErr != nil {
+		client.mutex.Unlock()
+		return fmt.Errorf("chromium.DevToolsClient.Call: connection is closed - %v", client.readErr)
+	}
+	client.lastID++
+	id := client.lastID
+	client.pending[id] = receiver
+	client.mutex.Unlock()
+	defer func() {
+		client.mutex.Lock()
+		delete(client.pending, id)
+		client.mutex.Unlock()
+	}()
+
+	cmd, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if err != nil {
+		return fmt.Errorf("chromium.DevToolsClient.Call: failed to serialise command - %v", err)
+	}
+	if err := client.ws.WriteText(cmd); err != nil {
+		return fmt.Errorf("chromium.DevToolsClient.Call: failed to send command - %v", err)
+	}
+	select {
+	case msg := <-receiver:
+		if msg.Error != nil {
+			return fmt.Errorf("chromium.DevToolsClient.Call: %s - %v", method, msg.Error)
+		}
+		if jsonReceiver != nil {
+			if err := json.Unmarshal(msg.Result, jsonReceiver); err != nil {
+				return fmt.Errorf("chromium.DevToolsClient.Call: failed to deserialise result of %s - %v", method, err)
+			}
+		}
+		return nil
+	case <-client.closed:
+		return errors.New("chromium.DevToolsClient.Call: connection is closed")
+	case <-time.After(time.Duration(timeoutSec) * time.Second):
+		return fmt.Errorf("chromium.DevToolsClient.Call: %s timed out", method)
+	}
+}
+
+// Close closes the connection to DevTools.
+func (client *DevToolsClient) Close() error {
+	return client.ws.Close()
+}
@@ -0,0 +1,119 @@
+package chromium
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+// Instances manage lifecycle of a fixed number of headless Chromium instances.
+type Instances struct {
+	MaxInstances   int    `json:"MaxInstances"`   // Maximum number of instances
+	MaxLifetimeSec int    `json:"MaxLifetimeSec"` // Unconditionally kill instance after this number of seconds elapse
+	BasePortNumber int    `json:"BasePortNumber"` // Browser instances listen for DevTools connections on a port number beginning from this one
+	ExecutablePath string `json:"ExecutablePath"` // Absolute or relative path to Chromium executable, it is looked up from PATH if left empty.
+
+	browserMutex   *sync.Mutex // Protect against concurrent modification to browsers
+	browsers       []*Instance // All browsers
+	browserCounter int         // Increment only counter
+	logger         lalog.Logger
+}
+
+// Check configuration and initialise internal states.
+func (instances *Instances) Initialise() error {
+	instances.logger = lalog.Logger{
+		ComponentName: "chromium.Instances",
+		ComponentID:   []lalog.LoggerIDField{{Key: "MaxInst", Value: instances.MaxInstances}, {Key: "MaxLifetime", Value: instances.MaxLifetimeSec}},
+	}
+	if instances.MaxInstances < 1 {
+		instances.MaxInstances = 5 // reasonable for a few consumers
+	}
+	if instances.MaxLifetimeSec < 1 {
+		instances.MaxLifetimeSec = 1800 // half hour is quite reasonable
+	}
+	if instances.BasePortNumber < 1024 {
+		return errors.New("chromium.Instances.Initialise: BasePortNumber must be greater than 1023")
+	}
+	if instances.ExecutablePath == "" {
+		// Chromium may be installed later, in which case Acquire will look for it again.
+		if execPath, err := FindExecutable(); err == nil {
+			instances.ExecutablePath = execPath
+		} else {
+			instances.logger.Warning("Initialise", "", err, "Chromium is not yet available")
+		}
+	}
+
+	instances.browserMutex = new(sync.Mutex)
+	instances.browsers = make([]*Instance, instances.MaxInstances)
+	instances.browserCounter = -1
+	return nil
+}
+
+// Acquire a new browser instance. If necessary, kill an existing instance to free up the space for the new instance.
+func (instances *Instances) Acquire() (index int, instance *Instance, err error) {
+	instances.browserMutex.Lock()
+	defer instances.browserMutex.Unlock()
+	instances.browserCounter++
+	index = instances.browserCounter % len(instances.browsers)
+	if existing := instances.browsers[index]; existing != nil {
+		existing.Kill()
+	}
+	instance = &Instance{
+		ExecutablePath:     instances.ExecutablePath,
+		RenderImageDir:     filepath.Join(os.TempDir(), fmt.Sprintf("laitos-browser-instance-render-chromium-%d-%d", time.Now().Unix(), index)),
+		Port:               instances.BasePortNumber + index,
+		AutoKillTimeoutSec: instances.MaxLifetimeSec,
+		Index:              index,
+	}
+	instances.browsers[index] = instance
+	err = instance.Start()
+	return
+}
+
+/*
+Return browser instance of the specified index and match its tag against expectation.
+If the instance does not exist or tag does not match, return nil.
+*/
+func (instances *Instances) Retrieve(index int, expectedTag string) *Instance {
+	instances.browserMutex.Lock()
+	defer instances.browserMutex.Unlock()
+	if index < 0 || index >= len(instances.browsers) {
+		return nil
+	}
+	instance := instances.browsers[index]
+	if instance == nil || instance.Tag != expectedTag {
+		return nil
+	}
+	return instance
+}
+
+// AcquireRenderer is identical to Acquire, it offers the browser instance as a generic browser.Renderer.
+func (instances *Instances) AcquireRenderer() (int, browser.Renderer, error) {
+	index, instance, err := instances.Acquire()
+	return index, instance, err
+}
+
+// RetrieveRenderer is identical to Retrieve, it offers the browser instance as a generic browser.Renderer.
+func (instances *Instances) RetrieveRenderer(index int, expectedTag string) browser.Renderer {
+	if instance := instances.Retrieve(index, expectedTag); instance != nil {
+		return instance
+	}
+	return nil
+}
+
+// Forcibly stop all browser instances.
+func (instances *Instances) KillAll() {
+	instances.browserMutex.Lock()
+	defer instances.browserMutex.Unlock()
+	for _, instance := range instances.browsers {
+		if instance != nil {
+			instance.Kill()
+		}
+	}
+}
@@ -0,0 +1,753 @@
+package chromium
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+const (
+	// RenderFileName is the file name of page screenshot written underneath the render image directory.
+	RenderFileName = "render.jpg"
+
+	// DevToolsTimeoutSec is the timeout of connecting to DevTools and waiting for a command response.
+	DevToolsTimeoutSec = 30
+
+	// KeyCodeBackspace is the keyboard key code for the backspace key, identical to PhantomJS and SlimerJS.
+	KeyCodeBackspace = 16777219
+	// KeyCodeEnter is the keyboard key code for Enter key. Both PhantomJS's Enter and SlimerJS's Return key codes are understood.
+	KeyCodeEnter = 16777221
+	// KeyCodeReturn is the SlimerJS keyboard key code for Return key, it works the same as Enter key.
+	KeyCodeReturn = 16777220
+
+	/*
+		LOFunctionsJS installs functions that help line-oriented browsing into window object. The functions walk the
+		DOM in the same way as those of PhantomJS and SlimerJS.
+	*/
+	LOFunctionsJS = `if (!window.laitos_lo_find_before_after) {
+    window.laitos_lo_current_elem = null;
+
+    // Walk through DOM elements.
+    window.laitos_lo_walk = function (elem, walk_fun) {
+        if (!elem) {
+            return true;
+        }
+        for (var child = elem.childNodes, t = 0; t < child.length; t++) {
+            if (!laitos_lo_walk(child[t], walk_fun)) {
+                return false;
+            }
+        }
+        return walk_fun(elem);
+    };
+
+    // Turn a DOM element into an object that describes several of its details.
+    window.laitos_lo_elem_to_obj = function (elem) {
+        return {
+            "tag": elem.tagName,
+            "id": elem.id || "",
+            "name": elem.name || "",
+            "value": elem.value === undefined ? null : elem.value,
+            "inner": elem.innerHTML || ""
+        };
+    };
+
+    // Only consider elements that are at least 9 square pixels large and content does not look exceedingly long.
+    window.laitos_lo_is_candidate = function (elem, max_inner_len) {
+        var elem_inner = elem.innerHTML;
+        return elem.offsetHeight > 3 && elem.offsetWidth > 3 && (!elem_inner || elem_inner.length < max_inner_len);
+    };
+
+    window.laitos_lo_matches = function (elem, tag, id, name, inner) {
+        return elem.tagName === tag && (elem.id || "") === id && (elem.name || "") === name && (elem.innerHTML || "") === inner;
+    };
+
+    // Look for an element, and return brief details of the element along with its previous and next element. Give the exact match the focus.
+    window.laitos_lo_find_before_after = function (tag, id, name, inner, stop_at_first) {
+        var before = null, exact = null, after = null, stop_next = false;
+        laitos_lo_walk(document.documentElement, function (elem) {
+            if (!elem || !laitos_lo_is_candidate(elem, 1000)) {
+                return true;
+            }
+            if (stop_next) {
+                after = elem;
+                return false;
+            }
+            if (stop_at_first || laitos_lo_matches(elem, tag, id, name, inner)) {
+                exact = elem;
+                window.laitos_lo_current_elem = elem;
+                elem.focus();
+                stop_next = true;
+            } else {
+                before = elem;
+            }
+            return true;
+        });
+        return [
+            before === null ? null : laitos_lo_elem_to_obj(before),
+            exact === null ? null : laitos_lo_elem_to_obj(exact),
+            after === null ? null : laitos_lo_elem_to_obj(after)
+        ];
+    };
+
+    // Find elements that are immediately adjacent to the one described in parameters. Give the very last one to focus.
+    window.laitos_lo_find_after = function (tag, id, name, inner, num) {
+        var ret = [], matched = false;
+        laitos_lo_walk(document.documentElement, function (elem) {
+            if (!elem || !laitos_lo_is_candidate(elem, 8192)) {
+                return true;
+            }
+            if (!matched) {
+                matched = laitos_lo_matches(elem, tag, id, name, inner);
+                return true;
+            }
+            if (ret.length >= num) {
+                return false;
+            }
+            window.laitos_lo_current_elem = elem;
+            elem.focus();
+            ret.push(laitos_lo_elem_to_obj(elem));
+            return true;
+        });
+        return ret;
+    };
+}`
+)
+
+var TagCounter = int64(0) // TagCounter increases for each started browser. Value 0 is the initial value, not a valid tag.
+
+// ExecutableNames are the program names of Chromium and its variants, which are looked up from PATH in order.
+var ExecutableNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless_shell", "chrome"}
+
+// FindExecutable returns the absolute path to the first Chromium executable found in PATH.
+func FindExecutable() (string, error) {
+	for _, name := range ExecutableNames {
+		if execPath, err := exec.LookPath(name); err == nil {
+			return execPath, nil
+		}
+	}
+	return "", fmt.Errorf("chromium.FindExecutable: cannot find any of %v in PATH", ExecutableNames)
+}
+
+// Instance is a single headless Chromium browser that is remotely controlled via DevTools protocol.
+type Instance struct {
+	ExecutablePath     string // ExecutablePath is the absolute or relative path to Chromium executable.
+	RenderImageDir     string // RenderImageDir is the directory for storing rendered web page image ("render.jpg").
+	Port               int    // Port number for Chromium to listen for DevTools connections on
+	AutoKillTimeoutSec int    // Process is unconditionally killed after the time elapses
+	Tag                string // Uniquely identifies this browser after it is started
+	Index              int    // index is the instance number assigned by renderer lifecycle management.
+
+	userDataDir string               // userDataDir is the temporary browser profile directory.
+	devTools    *DevToolsClient      // devTools is connected to the one and only page of the browser.
+	debugOutput *lalog.ByteLogWriter // Store standard output and error from Chromium executable
+	procCmd     *exec.Cmd            // Chromium process
+	mutex       *sync.Mutex          // Protect against concurrent modification of instance states
+
+	viewWidth, viewHeight                            int // viewWidth and viewHeight are the page view port dimension.
+	renderTop, renderLeft, renderWidth, renderHeight int // render* describe the area of the next screenshot.
+	loBeforeInfo, loExactInfo, loAfterInfo           *browser.ElementInfo
+	logger                                           lalog.Logger
+}
+
+// devToolsTarget describes a DevTools debugging target, such as a page.
+type devToolsTarget struct {
+	Type                 string `json:"type"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// Start launches Chromium in background and connects to its page via DevTools protocol.
+func (instance *Instance) Start() error {
+	// Instance is an internal API, hence its parameters are not validated before use.
+	instance.mutex = new(sync.Mutex)
+	// Keep latest 512 bytes of standard error and standard output from Chromium
+	instance.debugOutput = lalog.NewByteLogWriter(ioutil.Discard, 512)
+	instance.Tag = strconv.FormatInt(atomic.AddInt64(&TagCounter, 1), 10)
+	instance.logger = lalog.Logger{
+		ComponentName: "chromium",
+		ComponentID:   []lalog.LoggerIDField{{Key: "Created", Value: time.Now().Format(time.Kitchen)}, {Key: "Tag", Value: instance.Tag}},
+	}
+	instance.viewWidth, instance.viewHeight = 1024, 1024
+	instance.renderWidth, instance.renderHeight = 1024, 1024
+	if instance.ExecutablePath == "" {
+		var err error
+		if instance.ExecutablePath, err = FindExecutable(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(instance.RenderImageDir, 0700); err != nil {
+		return fmt.Errorf("chromium.Instance.Start: failed to create render image directory - %v", err)
+	}
+	var err error
+	if instance.userDataDir, err = ioutil.TempDir("", "laitos-chromium-profile"); err != nil {
+		return fmt.Errorf("chromium.Instance.Start: failed to create profile directory - %v", err)
+	}
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--disable-extensions",
+		"--disable-background-networking",
+		"--mute-audio",
+		"--hide-scrollbars",
+		"--remote-debugging-address=127.0.0.1",
+		fmt.Sprintf("--remote-debugging-port=%d", instance.Port),
+		"--user-data-dir=" + instance.userDataDir,
+		fmt.Sprintf("--window-size=%d,%d", instance.viewWidth, instance.viewHeight),
+	}
+	if !misc.HostIsWindows() && os.Getuid() == 0 {
+		// Chromium refuses to run as root unless its sandbox is turned off
+		args = append(args, "--no-sandbox")
+	}
+	args = append(args, "about:blank")
+	instance.logger.Info("Start", "", nil, "going to run %s with args %v", instance.ExecutablePath, args)
+	instance.procCmd = exec.Command(instance.ExecutablePath, args...)
+	instance.procCmd.Stdout = instance.debugOutput
+	instance.procCmd.Stderr = instance.debugOutput
+	if err := instance.procCmd.Start(); err != nil {
+		instance.Kill()
+		return fmt.Errorf("chromium.Instance.Start: Chromium process failed - %v", err)
+	}
+	processErrChan := make(chan error, 1)
+	go func(procCmd *exec.Cmd) {
+		processErrChan <- procCmd.Wait()
+	}(instance.procCmd)
+	// Unconditionally kill the browser after a period of time
+	go func() {
+		select {
+		case err := <-processErrChan:
+			instance.logger.Warning("Start", "", err, "Chromium process has quit")
+		case <-time.After(time.Duration(instance.AutoKillTimeoutSec) * time.Second):
+		}
+		instance.Kill()
+	}()
+	// Wait for DevTools to become ready and then connect to the page
+	var pageWSURL string
+	for i := 0; i < 20 && pageWSURL == ""; i++ {
+		time.Sleep(500 * time.Millisecond)
+		resp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{TimeoutSec: 3}, "http://127.0.0.1:%d/json/list", instance.Port)
+		if err != nil || resp.Non2xxToError() != nil {
+			continue
+		}
+		var targets []devToolsTarget
+		if err := json.Unmarshal(resp.Body, &targets); err != nil {
+			continue
+		}
+		for _, target := range targets {
+			if target.Type == "page" && target.WebSocketDebuggerURL != "" {
+				pageWSURL = target.WebSocketDebuggerURL
+				break
+			}
+		}
+	}
+	if pageWSURL == "" {
+		instance.Kill()
+		return errors.New("chromium.Instance.Start: DevTools of the browser is not ready - " + instance.GetDebugOutput())
+	}
+	devTools, err := NewDevToolsClient(pageWSURL, DevToolsTimeoutSec)
+	if err != nil {
+		instance.Kill()
+		return err
+	}
+	instance.mutex.Lock()
+	instance.devTools = devTools
+	instance.mutex.Unlock()
+	return nil
+}
+
+// GetIndex returns the instance number assigned by renderer lifecycle management.
+func (instance *Instance) GetIndex() int {
+	return instance.Index
+}
+
+// GetTag returns the string that uniquely identifies this browser after it is started.
+func (instance *Instance) GetTag() string {
+	return instance.Tag
+}
+
+// GetDebugOutput retrieves the latest standard output and standard error content from Chromium.
+func (instance *Instance) GetDebugOutput() string {
+	if instance.debugOutput == nil {
+		return ""
+	}
+	return string(instance.debugOutput.Retrieve(true))
+}
+
+// SendRequest sends a DevTools protocol command to the page, optionally deserialise the response into receiver.
+func (instance *Instance) SendRequest(method string, params map[string]interface{}, jsonReceiver interface{}) error {
+	err := instance.sendRequestQuietly(method, params, jsonReceiver)
+	instance.logger.Info("SendRequest", "", err, "%s", method)
+	return err
+}
+
+/*
+sendRequestQuietly sends a DevTools protocol command to the page without logging it. It is used by the frequent
+polling of page conditions, which would otherwise flood the log.
+*/
+func (instance *Instance) sendRequestQuietly(method string, params map[string]interface{}, jsonReceiver interface{}) error {
+	instance.mutex.Lock()
+	devTools := instance.devTools
+	instance.mutex.Unlock()
+	if devTools == nil {
+		return errors.New("chromium.Instance.SendRequest: browser is not running")
+	}
+	return devTools.Call(method, params, jsonReceiver, DevToolsTimeoutSec)
+}
+
+// Evaluate runs javascript expression on the page and deserialises the expression value into receiver.
+func (instance *Instance) Evaluate(expression string, jsonReceiver interface{}) error {
+	return instance.evaluate(instance.SendRequest, expression, jsonReceiver)
+}
+
+// evaluate runs javascript expression on the page via the request function and deserialises the expression value.
+func (instance *Instance) evaluate(sendRequest func(string, map[string]interface{}, interface{}) error, expression string, jsonReceiver interface{}) error {
+	var result struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text      string `json:"text"`
+			Exception struct {
+				Description string `json:"description"`
+			} `json:"exception"`
+		} `json:"exceptionDetails"`
+	}
+	if err := sendRequest("Runtime.evaluate", map[string]interface{}{
+		"expression":    expression,
+		"returnByValue": true,
+	}, &result); err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("chromium.Instance.Evaluate: %s %s", result.ExceptionDetails.Text, result.ExceptionDetails.Exception.Description)
+	}
+	if jsonReceiver != nil && len(result.Result.Value) > 0 {
+		if err := json.Unmarshal(result.Result.Value, jsonReceiver); err != nil {
+			return fmt.Errorf("chromium.Instance.Evaluate: failed to deserialise value - %v", err)
+		}
+	}
+	return nil
+}
+
+// GetRenderPageFilePath returns the absolute path to web page screenshot.
+func (instance *Instance) GetRenderPageFilePath() string {
+	return filepath.Join(instance.RenderImageDir, RenderFileName)
+}
+
+// SetRenderArea sets the rectangular area (within or out of view port) for the next captured page screen shot.
+func (instance *Instance) SetRenderArea(top, left, width, height int) error {
+	// Ensure input parameters are in the valid range
+	if top < 0 {
+		top = 0
+	}
+	if left < 0 {
+		left = 0
+	}
+	if width <= 0 {
+		width = 10
+	}
+	if height <= 0 {
+		height = 10
+	}
+	instance.mutex.Lock()
+	instance.renderTop, instance.renderLeft, instance.renderWidth, instance.renderHeight = top, left, width, height
+	instance.mutex.Unlock()
+	// Scroll to the area so that pointer coordinates are relative to the area
+	return instance.Evaluate(fmt.Sprintf("window.scrollTo(%d, %d)", left, top), nil)
+}
+
+// RenderPage captures a screenshot of the render area and saves it into the render image file.
+func (instance *Instance) RenderPage() error {
+	if err := os.Remove(instance.GetRenderPageFilePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	instance.mutex.Lock()
+	clip := map[string]interface{}{
+		"x":      instance.renderLeft,
+		"y":      instance.renderTop,
+		"width":  instance.renderWidth,
+		"height": instance.renderHeight,
+		"scale":  1,
+	}
+	instance.mutex.Unlock()
+	var result struct {
+		Data string `json:"data"`
+	}
+	if err := instance.SendRequest("Page.captureScreenshot", map[string]interface{}{
+		"format":                "jpeg",
+		"quality":               80,
+		"clip":                  clip,
+		"captureBeyondViewport": true,
+	}, &result); err != nil {
+		return err
+	}
+	image, err := base64.StdEncoding.DecodeString(result.Data)
+	if err != nil {
+		return fmt.Errorf("chromium.Instance.RenderPage: failed to decode screenshot - %v", err)
+	}
+	return ioutil.WriteFile(instance.GetRenderPageFilePath(), image, 0600)
+}
+
+// Kill browser process and delete rendered web page image and browser profile.
+func (instance *Instance) Kill() {
+	if instance.mutex == nil {
+		return
+	}
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if instance.devTools != nil {
+		_ = instance.devTools.Close()
+		instance.devTools = nil
+	}
+	if procCmd := instance.procCmd; procCmd != nil {
+		if proc := procCmd.Process; proc != nil {
+			instance.logger.Info("Kill", "", nil, "killing process PID %d", proc.Pid)
+			if !platform.KillProcess(proc) {
+				instance.logger.Warning("Kill", "", nil, "failed to kill process")
+			}
+		}
+		// Clean up after temporary files and directories
+		if err := os.RemoveAll(instance.RenderImageDir); err != nil && !os.IsNotExist(err) {
+			instance.logger.Warning("Kill", "", err, "failed to delete rendered web page at \"%s\"", instance.RenderImageDir)
+		}
+	}
+	if instance.userDataDir != "" {
+		if err := os.RemoveAll(instance.userDataDir); err != nil && !os.IsNotExist(err) {
+			instance.logger.Warning("Kill", "", err, "failed to delete browser profile at \"%s\"", instance.userDataDir)
+		}
+		instance.userDataDir = ""
+	}
+	instance.procCmd = nil
+}
+
+// navigateHistory navigates backward (negative offset) or forward (positive offset) in history.
+func (instance *Instance) navigateHistory(offset int) error {
+	_ = instance.LOResetNavigation()
+	var history struct {
+		CurrentIndex int `json:"currentIndex"`
+		Entries      []struct {
+			ID int `json:"id"`
+		} `json:"entries"`
+	}
+	if err := instance.SendRequest("Page.getNavigationHistory", nil, &history); err != nil {
+		return err
+	}
+	newIndex := history.CurrentIndex + offset
+	if newIndex < 0 || newIndex >= len(history.Entries) {
+		// Like other browsers, going beyond the history does nothing.
+		return nil
+	}
+	return instance.SendRequest("Page.navigateToHistoryEntry", map[string]interface{}{"entryId": history.Entries[newIndex].ID}, nil)
+}
+
+// GoBack navigates browser backward in history.
+func (instance *Instance) GoBack() error {
+	return instance.navigateHistory(-1)
+}
+
+// GoForward navigates browser forward in history.
+func (instance *Instance) GoForward() error {
+	return instance.navigateHistory(1)
+}
+
+// Reload reloads the current page.
+func (instance *Instance) Reload() error {
+	_ = instance.LOResetNavigation()
+	return instance.SendRequest("Page.reload", nil, nil)
+}
+
+// GoTo navigates to a new URL.
+func (instance *Instance) GoTo(userAgent, pageURL string, width, height int) error {
+	if !strings.HasPrefix(pageURL, "http://") && !strings.HasPrefix(pageURL, "https://") {
+		return errors.New("Instance.GoTo: input URL must begin with http or https scheme")
+	}
+	_ = instance.LOResetNavigation()
+	if width <= 0 || height <= 0 {
+		width, height = 1024, 1024
+	}
+	if err := instance.SendRequest("Emulation.setDeviceMetricsOverride", map[string]interface{}{
+		"width":             width,
+		"height":            height,
+		"deviceScaleFactor": 1,
+		"mobile":            false,
+	}, nil); err != nil {
+		return err
+	}
+	if userAgent != "" {
+		if err := instance.SendRequest("Network.setUserAgentOverride", map[string]interface{}{"userAgent": userAgent}, nil); err != nil {
+			return err
+		}
+	}
+	instance.mutex.Lock()
+	instance.viewWidth, instance.viewHeight = width, height
+	instance.renderTop, instance.renderLeft, instance.renderWidth, instance.renderHeight = 0, 0, width, height
+	instance.mutex.Unlock()
+	var result struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := instance.SendRequest("Page.navigate", map[string]interface{}{"url": pageURL}, &result); err != nil {
+		return err
+	}
+	if result.ErrorText != "" {
+		return fmt.Errorf("Instance.GoTo: %s", result.ErrorText)
+	}
+	return nil
+}
+
+// dispatchMouseEvent sends a single mouse event at the view port coordinate.
+func (instance *Instance) dispatchMouseEvent(eventType, button string, x, y, clickCount int) error {
+	return instance.SendRequest("Input.dispatchMouseEvent", map[string]interface{}{
+		"type":       eventType,
+		"button":     button,
+		"x":          x,
+		"y":          y,
+		"clickCount": clickCount,
+	}, nil)
+}
+
+/*
+Pointer sends pointer to move/click at a coordinate relative to the render area. The action types and buttons are
+identical to those of PhantomJS - "click", "doubleclick", "mousedown", "mouseup", "mousemove", and "left", "right",
+"middle".
+*/
+func (instance *Instance) Pointer(actionType, button string, x, y int) error {
+	switch button {
+	case "left", "right", "middle":
+	default:
+		button = "left"
+	}
+	if err := instance.dispatchMouseEvent("mouseMoved", "none", x, y, 0); err != nil {
+		return err
+	}
+	switch actionType {
+	case "mousemove":
+		return nil
+	case "mousedown":
+		return instance.dispatchMouseEvent("mousePressed", button, x, y, 1)
+	case "mouseup":
+		return instance.dispatchMouseEvent("mouseReleased", button, x, y, 1)
+	case "click", "doubleclick":
+		clicks := 1
+		if actionType == "doubleclick" {
+			clicks = 2
+		}
+		for count := 1; count <= clicks; count++ {
+			if err := instance.dispatchMouseEvent("mousePressed", button, x, y, count); err != nil {
+				return err
+			}
+			if err := instance.dispatchMouseEvent("mouseReleased", button, x, y, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("chromium.Instance.Pointer: unknown action type \"%s\"", actionType)
+}
+
+// sendSpecialKey presses and releases a key that does not insert text by itself.
+func (instance *Instance) sendSpecialKey(key string, virtualKeyCode int, text string) error {
+	downType := "rawKeyDown"
+	if text != "" {
+		downType = "keyDown"
+	}
+	if err := instance.SendRequest("Input.dispatchKeyEvent", map[string]interface{}{
+		"type":                  downType,
+		"key":                   key,
+		"code":                  key,
+		"windowsVirtualKeyCode": virtualKeyCode,
+		"text":                  text,
+	}, nil); err != nil {
+		return err
+	}
+	return instance.SendRequest("Input.dispatchKeyEvent", map[string]interface{}{
+		"type":                  "keyUp",
+		"key":                   key,
+		"code":                  key,
+		"windowsVirtualKeyCode": virtualKeyCode,
+	}, nil)
+}
+
+/*
+SendKey either sends a key string or a key code into the currently focused element on page. Key codes of backspace
+and enter keys are identical to those of PhantomJS and SlimerJS, other key codes are treated as unicode characters.
+*/
+func (instance *Instance) SendKey(aString string, aCode int64) error {
+	if aString != "" {
+		return instance.SendRequest("Input.insertText", map[string]interface{}{"text": aString}, nil)
+	}
+	switch aCode {
+	case 0:
+		return nil
+	case KeyCodeBackspace, 8:
+		return instance.sendSpecialKey("Backspace", 8, "")
+	case KeyCodeEnter, KeyCodeReturn, 13:
+		return instance.sendSpecialKey("Enter", 13, "\r")
+	}
+	if aCode < 32 || aCode > 0x10ffff {
+		return fmt.Errorf("chromium.Instance.SendKey: unsupported key code %d", aCode)
+	}
+	return instance.SendRequest("Input.insertText", map[string]interface{}{"text": string(rune(aCode))}, nil)
+}
+
+// GetPageInfo returns title and URL of the current page.
+func (instance *Instance) GetPageInfo() (info browser.RemotePageInfo, err error) {
+	err = instance.Evaluate(`({"title": document.title, "page_url": window.location.href})`, &info)
+	return
+}
+
+// LOResetNavigation (line-oriented browser) resets recorded element information so that next DOM navigation will find the first element on page.
+func (instance *Instance) LOResetNavigation() error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.loBeforeInfo, instance.loExactInfo, instance.loAfterInfo = nil, nil, nil
+	return nil
+}
+
+// loElementArgs returns javascript function arguments that identify the element.
+func loElementArgs(elem *browser.ElementInfo) string {
+	if elem == nil {
+		elem = &browser.ElementInfo{}
+	}
+	args := make([]string, 0, 4)
+	for _, arg := range []string{elem.TagName, elem.ID, elem.Name, elem.InnerHTML} {
+		quoted, _ := json.Marshal(arg)
+		args = append(args, string(quoted))
+	}
+	return strings.Join(args, ", ")
+}
+
+// loFindBeforeAfter focuses on the element (or the first element if it is nil), and returns its previous, itself, and its next element.
+func (instance *Instance) loFindBeforeAfter(elem *browser.ElementInfo) ([]browser.ElementInfo, error) {
+	var found []*browser.ElementInfo
+	if err := instance.Evaluate(fmt.Sprintf("%s\nlaitos_lo_find_before_after(%s, %t)", LOFunctionsJS, loElementArgs(elem), elem == nil), &found); err != nil {
+		return nil, err
+	}
+	if len(found) != 3 {
+		return nil, fmt.Errorf("chromium.Instance.loFindBeforeAfter: unexpected result of %d elements", len(found))
+	}
+	instance.mutex.Lock()
+	instance.loBeforeInfo, instance.loExactInfo, instance.loAfterInfo = found[0], found[1], found[2]
+	instance.mutex.Unlock()
+	elements := make([]browser.ElementInfo, 3)
+	for i, info := range found {
+		if info != nil {
+			elements[i] = *info
+		}
+	}
+	return elements, nil
+}
+
+// LONextElement (line-oriented browser) navigates to the next element in DOM. Return information of previous, current, and next element after the action.
+func (instance *Instance) LONextElement() ([]browser.ElementInfo, error) {
+	instance.mutex.Lock()
+	target := instance.loAfterInfo
+	if target == nil {
+		// Visit the first element, or stay at the last element if already there.
+		target = instance.loExactInfo
+	}
+	instance.mutex.Unlock()
+	return instance.loFindBeforeAfter(target)
+}
+
+// LOPreviousElement (line-oriented browser) navigates to the previous element in DOM. Return information of previous, current, and next element after the action.
+func (instance *Instance) LOPreviousElement() ([]browser.ElementInfo, error) {
+	instance.mutex.Lock()
+	// If there is no previous element, it will naturally visit the first element of the page.
+	target := instance.loBeforeInfo
+	instance.mutex.Unlock()
+	return instance.loFindBeforeAfter(target)
+}
+
+// LONextNElements (line-oriented browser) navigates across the next N elements in DOM. Return information of next N elements.
+func (instance *Instance) LONextNElements(n int) ([]browser.ElementInfo, error) {
+	instance.mutex.Lock()
+	exact := instance.loExactInfo
+	instance.mutex.Unlock()
+	// If no element has ever been navigated into, go to the first element.
+	if exact == nil {
+		if _, err := instance.LONextElement(); err != nil {
+			return nil, err
+		}
+		instance.mutex.Lock()
+		exact = instance.loExactInfo
+		instance.mutex.Unlock()
+	}
+	elements := make([]browser.ElementInfo, 0, n)
+	if err := instance.Evaluate(fmt.Sprintf("%s\nlaitos_lo_find_after(%s, %d)", LOFunctionsJS, loElementArgs(exact), n), &elements); err != nil {
+		return nil, err
+	}
+	if len(elements) > 0 {
+		last := elements[len(elements)-1]
+		instance.mutex.Lock()
+		instance.loBeforeInfo = exact
+		// Intentionally set both exact and after element information to that belonging to very last element
+		instance.loExactInfo, instance.loAfterInfo = &last, &last
+		instance.mutex.Unlock()
+	}
+	return elements, nil
+}
+
+// LOPointer (line-oriented browser) sends pointer to click/move to at coordinate of the currently focused element.
+func (instance *Instance) LOPointer(actionType, button string) error {
+	var coord struct {
+		X *float64 `json:"x"`
+		Y *float64 `json:"y"`
+	}
+	if err := instance.Evaluate(LOFunctionsJS+`
+(function () {
+    var elem = window.laitos_lo_current_elem;
+    if (!elem) {
+        return {};
+    }
+    elem.scrollIntoView({block: "center", inline: "center"});
+    var rect = elem.getBoundingClientRect();
+    return {"x": rect.left, "y": rect.top};
+})()`, &coord); err != nil {
+		return err
+	}
+	if coord.X == nil || coord.Y == nil {
+		return errors.New("chromium.Instance.LOPointer: there is not a focused element")
+	}
+	// Instead of pointing exactly on its boarder, point a bit into the element.
+	return instance.Pointer(actionType, button, int(*coord.X)+1, int(*coord.Y)+1)
+}
+
+// LOSetValue (line-oriented browser) sets the value of currently focused element.
+func (instance *Instance) LOSetValue(value string) error {
+	jsValue, _ := json.Marshal(value)
+	var ok bool
+	if err := instance.Evaluate(fmt.Sprintf(`%s
+(function (value) {
+    var elem = window.laitos_lo_current_elem;
+    if (!elem) {
+        return false;
+    }
+    elem.value = value;
+    elem.dispatchEvent(new Event("input", {bubbles: true}));
+    elem.dispatchEvent(new Event("change", {bubbles: true}));
+    return true;
+})(%s)`, LOFunctionsJS, jsValue), &ok); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("chromium.Instance.LOSetValue: there is not a focused element")
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package chromium
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/browser/phantomjs"
+	"github.com/HouzuoGuo/laitos/browser/slimerjs"
+)
+
+var (
+	_ browser.Renderer  = &Instance{}
+	_ browser.Renderers = &Instances{}
+	_ browser.Renderer  = &slimerjs.Instance{}
+	_ browser.Renderers = &slimerjs.Instances{}
+	_ browser.Renderer  = &phantomjs.Instance{}
+	_ browser.Renderers = &phantomjs.Instances{}
+)
+
+// testDevToolsCommand is a DevTools command received by the fake DevTools server.
+type testDevToolsCommand struct {
+	ID     int64                  `json:"id"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+/*
+newFakeDevToolsInstance returns a browser instance connected to a fake DevTools server, which answers each command
+using the respond function.
+*/
+func newFakeDevToolsInstance(t *testing.T, respond func(cmd testDevToolsCommand) (result interface{}, errMessage string)) *Instance {
+	server := newTestWebSocketServer(t, func(srv *testServerConn) {
+		for {
+			opcode, payload, err := srv.readFrame()
+			if err != nil || opcode == wsOpClose {
+				return
+			}
+			var cmd testDevToolsCommand
+			if err := json.Unmarshal(payload, &cmd); err != nil {
+				t.Error(err)
+				return
+			}
+			// An event comes before the response, it should be ignored by client.
+			_ = srv.writeFrame(true, wsOpText, []byte(`{"method":"Page.loadEventFired","params":{}}`))
+			result, errMessage := respond(cmd)
+			resp := map[string]interface{}{"id": cmd.ID, "result": result}
+			if errMessage != "" {
+				resp = map[string]interface{}{"id": cmd.ID, "error": map[string]interface{}{"code": -32000, "message": errMessage}}
+			}
+			respJSON, _ := json.Marshal(resp)
+			_ = srv.writeFrame(true, wsOpText, respJSON)
+		}
+	})
+	t.Cleanup(server.Close)
+	renderDir, err := ioutil.TempDir("", "laitos-TestChromium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(renderDir)
+	})
+	devTools, err := NewDevToolsClient("ws"+strings.TrimPrefix(server.URL, "http"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = devTools.Close()
+	})
+	return &Instance{RenderImageDir: renderDir, devTools: devTools, mutex: new(sync.Mutex), renderWidth: 1024, renderHeight: 1024}
+}
+
+// evalResult returns the Runtime.evaluate result that carries the value.
+func evalResult(value interface{}) interface{} {
+	return map[string]interface{}{"result": map[string]interface{}{"type": "object", "value": value}}
+}
+
+func TestInstance_FakeDevTools(t *testing.T) {
+	var commands []testDevToolsCommand
+	var commandsMutex sync.Mutex
+	elemA := map[string]interface{}{"tag": "A", "id": "a", "name": "", "value": nil, "inner": "link"}
+	elemB := map[string]interface{}{"tag": "INPUT", "id": "", "name": "q", "value": "v", "inner": ""}
+	elemC := map[string]interface{}{"tag": "BUTTON", "id": "", "name": "", "value": "", "inner": "Go"}
+	instance := newFakeDevToolsInstance(t, func(cmd testDevToolsCommand) (interface{}, string) {
+		commandsMutex.Lock()
+		commands = append(commands, cmd)
+		commandsMutex.Unlock()
+		switch cmd.Method {
+		case "Page.navigate":
+			if cmd.Params["url"] == "http://bad.example" {
+				return map[string]interface{}{"errorText": "net::ERR_NAME_NOT_RESOLVED"}, ""
+			}
+		case "Page.captureScreenshot":
+			return map[string]interface{}{"data": base64.StdEncoding.EncodeToString([]byte("jpeg"))}, ""
+		case "Page.getNavigationHistory":
+			return map[string]interface{}{"currentIndex": 1, "entries": []interface{}{map[string]interface{}{"id": 7}, map[string]interface{}{"id": 8}}}, ""
+		case "Page.reload":
+			return nil, "reload failed"
+		case "Runtime.evaluate":
+			expression := cmd.Params["expression"].(string)
+			switch {
+			case strings.Contains(expression, "document.title"):
+				return evalResult(map[string]interface{}{"title": "Home", "page_url": "http://example.com/"}), ""
+			case strings.Contains(expression, "laitos_lo_find_before_after(\"\", \"\", \"\", \"\", true)"):
+				return evalResult([]interface{}{nil, elemA, elemB}), ""
+			case strings.Contains(expression, "laitos_lo_find_before_after(\"INPUT\", \"\", \"q\", \"\", false)"):
+				return evalResult([]interface{}{elemA, elemB, elemC}), ""
+			case strings.Contains(expression, "laitos_lo_find_after(\"INPUT\", \"\", \"q\", \"\", 5)"):
+				return evalResult([]interface{}{elemC}), ""
+			case strings.Contains(expression, "getBoundingClientRect"):
+				return evalResult(map[string]interface{}{"x": 10.5, "y": 20}), ""
+			case strings.Contains(expression, "elem.value = value"):
+				return evalResult(strings.Contains(expression, `("new value")`)), ""
+			case strings.Contains(expression, "throw"):
+				return map[string]interface{}{"result": map[string]interface{}{}, "exceptionDetails": map[string]interface{}{"text": "Uncaught", "exception": map[string]interface{}{"description": "Error: oops"}}}, ""
+			}
+		}
+		return map[string]interface{}{}, ""
+	})
+	takeCommands := func() (methods []string, params []map[string]interface{}) {
+		commandsMutex.Lock()
+		defer commandsMutex.Unlock()
+		for _, cmd := range commands {
+			methods = append(methods, cmd.Method)
+			params = append(params, cmd.Params)
+		}
+		commands = nil
+		return
+	}
+
+	// Navigation
+	if err := instance.GoTo("ua", "ftp://example.com", 800, 600); err == nil {
+		t.Fatal("should not accept ftp")
+	}
+	if err := instance.GoTo("ua", "http://bad.example", 800, 600); err == nil || !strings.Contains(err.Error(), "ERR_NAME_NOT_RESOLVED") {
+		t.Fatal(err)
+	}
+	takeCommands()
+	if err := instance.GoTo("ua", "http://example.com", 800, 600); err != nil {
+		t.Fatal(err)
+	}
+	if methods, params := takeCommands(); !reflect.DeepEqual(methods, []string{"Emulation.setDeviceMetricsOverride", "Network.setUserAgentOverride", "Page.navigate"}) ||
+		params[0]["width"] != 800.0 || params[1]["userAgent"] != "ua" {
+		t.Fatal(methods, params)
+	}
+	if err := instance.GoBack(); err != nil {
+		t.Fatal(err)
+	}
+	if methods, params := takeCommands(); len(methods) != 2 || params[1]["entryId"] != 7.0 {
+		t.Fatal(methods, params)
+	}
+	// Going forward from the last history entry does nothing
+	if err := instance.GoForward(); err != nil {
+		t.Fatal(err)
+	}
+	if methods, _ := takeCommands(); len(methods) != 1 {
+		t.Fatal(methods)
+	}
+	if err := instance.Reload(); err == nil || !strings.Contains(err.Error(), "reload failed") {
+		t.Fatal(err)
+	}
+	if info, err := instance.GetPageInfo(); err != nil || info.Title != "Home" || info.URL != "http://example.com/" {
+		t.Fatal(info, err)
+	}
+	if err := instance.Evaluate("throw 1", nil); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatal(err)
+	}
+
+	// Screenshot of the render area
+	takeCommands()
+	if err := instance.SetRenderArea(-1, 5, 300, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.RenderPage(); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(instance.GetRenderPageFilePath()); err != nil || string(content) != "jpeg" {
+		t.Fatal(err, string(content))
+	}
+	if _, params := takeCommands(); params[0]["expression"] != "window.scrollTo(5, 0)" ||
+		!reflect.DeepEqual(params[1]["clip"], map[string]interface{}{"x": 5.0, "y": 0.0, "width": 300.0, "height": 10.0, "scale": 1.0}) {
+		t.Fatal(params)
+	}
+
+	// Pointer and keyboard
+	if err := instance.Pointer("click", "right", 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, params := takeCommands(); len(params) != 3 || params[0]["type"] != "mouseMoved" ||
+		params[1]["type"] != "mousePressed" || params[1]["button"] != "right" || params[2]["type"] != "mouseReleased" {
+		t.Fatal(params)
+	}
+	if err := instance.Pointer("wiggle", "left", 3, 4); err == nil {
+		t.Fatal("should not accept unknown action")
+	}
+	takeCommands()
+	if err := instance.SendKey("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.SendKey("", slimerjs.KeyCodeEnter); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.SendKey("", phantomjs.KeyCodeBackspace); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.SendKey("", 5); err == nil {
+		t.Fatal("should not accept control character")
+	}
+	if methods, params := takeCommands(); len(methods) != 5 || params[0]["text"] != "hello" ||
+		params[1]["key"] != "Enter" || params[1]["type"] != "keyDown" || params[3]["key"] != "Backspace" || params[3]["type"] != "rawKeyDown" {
+		t.Fatal(methods, params)
+	}
+
+	// Line-oriented navigation
+	if elements, err := instance.LONextElement(); err != nil || len(elements) != 3 || elements[0].TagName != "" || elements[1].TagName != "A" || elements[2].Name != "q" {
+		t.Fatal(elements, err)
+	}
+	if elements, err := instance.LONextElement(); err != nil || elements[1].TagName != "INPUT" || elements[1].Value != "v" || elements[2].TagName != "BUTTON" {
+		t.Fatal(elements, err)
+	}
+	// Previous element of the input is the link, which is found by starting from the first element.
+	if err := instance.LOResetNavigation(); err != nil {
+		t.Fatal(err)
+	}
+	if elements, err := instance.LOPreviousElement(); err != nil || elements[1].TagName != "A" {
+		t.Fatal(elements, err)
+	}
+	if _, err := instance.LONextElement(); err != nil {
+		t.Fatal(err)
+	}
+	if elements, err := instance.LONextNElements(5); err != nil || len(elements) != 1 || elements[0].TagName != "BUTTON" {
+		t.Fatal(elements, err)
+	}
+	if instance.loBeforeInfo.TagName != "INPUT" || instance.loExactInfo.TagName != "BUTTON" || instance.loAfterInfo.TagName != "BUTTON" {
+		t.Fatal(instance.loBeforeInfo, instance.loExactInfo, instance.loAfterInfo)
+	}
+	takeCommands()
+	if err := instance.LOPointer("click", "left"); err != nil {
+		t.Fatal(err)
+	}
+	if _, params := takeCommands(); len(params) != 4 || params[1]["x"] != 11.0 || params[1]["y"] != 21.0 {
+		t.Fatal(params)
+	}
+	if err := instance.LOSetValue("new value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.LOSetValue("other value"); err == nil {
+		t.Fatal("did not error")
+	}
+
+	// A killed instance refuses further commands
+	instance.Kill()
+	if _, err := instance.GetPageInfo(); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestInstance_Chromium(t *testing.T) {
+	if _, err := FindExecutable(); err != nil {
+		t.Skip(err)
+	}
+	instances := Instances{MaxInstances: 1, BasePortNumber: 31429, MaxLifetimeSec: 120}
+	if err := instances.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	defer instances.KillAll()
+	index, instance, err := instances.Acquire()
+	if err != nil {
+		t.Fatal(err, instance.GetDebugOutput())
+	}
+	if retrieved := instances.RetrieveRenderer(index, instance.Tag); retrieved == nil {
+		t.Fatal("did not retrieve")
+	}
+	if retrieved := instances.RetrieveRenderer(index, "wrong tag"); retrieved != nil {
+		t.Fatal("did not reject")
+	}
+	if err := instance.GoTo(phantomjs.GoodUserAgent, "https://www.google.com", 1024, 1024); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * time.Second)
+	if info, err := instance.GetPageInfo(); err != nil || !strings.Contains(info.URL, "google") {
+		t.Fatal(info, err)
+	}
+	if err := instance.RenderPage(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(instance.GetRenderPageFilePath()); err != nil || stat.Size() < 1024 {
+		t.Fatal(err)
+	}
+	if elements, err := instance.LONextNElements(10); err != nil || len(elements) == 0 {
+		t.Fatal(elements, err)
+	}
+	// Repeatedly stopping instance should have no negative consequence
+	instances.KillAll()
+	instances.KillAll()
+}
@@ -0,0 +1,113 @@
+package chromium
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WaitPollIntervalMilli is the interval between checks of a condition the browser is waiting for.
+const WaitPollIntervalMilli = 200
+
+// jsString returns the javascript string literal of the text.
+func jsString(text string) string {
+	literal, _ := json.Marshal(text)
+	return string(literal)
+}
+
+/*
+WaitFor evaluates the javascript condition repeatedly until it becomes true, the timeout elapses, or the context is
+cancelled. The individual evaluations are not logged, only the outcome of the wait is.
+*/
+func (instance *Instance) WaitFor(ctx context.Context, condition string, timeoutSec int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+	defer cancel()
+	for numChecks := 1; ; numChecks++ {
+		var satisfied bool
+		// Evaluation may fail while the page is navigating away, try again later.
+		if err := instance.evaluate(instance.sendRequestQuietly, "!!("+condition+")", &satisfied); err == nil && satisfied {
+			instance.logger.Info("WaitFor", "", nil, "condition satisfied after %d checks", numChecks)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			err := fmt.Errorf("chromium.Instance.WaitFor: gave up after %d checks - %v", numChecks, ctx.Err())
+			instance.logger.Info("WaitFor", "", err, "")
+			return err
+		case <-time.After(WaitPollIntervalMilli * time.Millisecond):
+		}
+	}
+}
+
+// WaitForLoad waits for the current page to completely load.
+func (instance *Instance) WaitForLoad(ctx context.Context, timeoutSec int) error {
+	return instance.WaitFor(ctx, `document.readyState === "complete"`, timeoutSec)
+}
+
+// WaitForSelector waits for an element matching the CSS selector to appear on the page.
+func (instance *Instance) WaitForSelector(ctx context.Context, selector string, timeoutSec int) error {
+	if err := instance.WaitFor(ctx, "document.querySelector("+jsString(selector)+")", timeoutSec); err != nil {
+		return fmt.Errorf("chromium.Instance.WaitForSelector: \"%s\" did not appear - %v", selector, err)
+	}
+	return nil
+}
+
+// SetValueBySelector focuses on the first element matching the CSS selector and gives it a new value.
+func (instance *Instance) SetValueBySelector(selector, value string) error {
+	var found bool
+	if err := instance.Evaluate(fmt.Sprintf(`(function (elem, value) {
+    if (!elem) {
+        return false;
+    }
+    elem.focus();
+    elem.value = value;
+    elem.dispatchEvent(new Event("input", {bubbles: true}));
+    elem.dispatchEvent(new Event("change", {bubbles: true}));
+    return true;
+})(document.querySelector(%s), %s)`, jsString(selector), jsString(value)), &found); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("chromium.Instance.SetValueBySelector: cannot find \"%s\"", selector)
+	}
+	return nil
+}
+
+// ClickSelector scrolls the first element matching the CSS selector into view and clicks at its centre.
+func (instance *Instance) ClickSelector(selector string) error {
+	var coord struct {
+		X *float64 `json:"x"`
+		Y *float64 `json:"y"`
+	}
+	if err := instance.Evaluate(fmt.Sprintf(`(function (elem) {
+    if (!elem) {
+        return {};
+    }
+    elem.scrollIntoView({block: "center", inline: "center"});
+    var rect = elem.getBoundingClientRect();
+    return {"x": rect.left + rect.width / 2, "y": rect.top + rect.height / 2};
+})(document.querySelector(%s))`, jsString(selector)), &coord); err != nil {
+		return err
+	}
+	if coord.X == nil || coord.Y == nil {
+		return fmt.Errorf("chromium.Instance.ClickSelector: cannot find \"%s\"", selector)
+	}
+	return instance.Pointer("click", "left", int(*coord.X), int(*coord.Y))
+}
+
+// GetTextBySelector returns the visible text of all elements matching the CSS selector, one element per line.
+func (instance *Instance) GetTextBySelector(selector string) (string, error) {
+	var texts []string
+	if err := instance.Evaluate(fmt.Sprintf(`Array.prototype.map.call(document.querySelectorAll(%s), function (elem) {
+    return (elem.innerText || elem.textContent || elem.value || "").trim();
+})`, jsString(selector)), &texts); err != nil {
+		return "", err
+	}
+	if len(texts) == 0 {
+		return "", errors.New("chromium.Instance.GetTextBySelector: cannot find \"" + selector + "\"")
+	}
+	return strings.Join(texts, "\n"), nil
+}
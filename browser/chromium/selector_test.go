@@ -0,0 +1,72 @@
+package chromium
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstance_Selector(t *testing.T) {
+	var waitCount int
+	var clicked []string
+	instance := newFakeDevToolsInstance(t, func(cmd testDevToolsCommand) (interface{}, string) {
+		if cmd.Method == "Input.dispatchMouseEvent" {
+			clicked = append(clicked, cmd.Params["type"].(string))
+			return map[string]interface{}{}, ""
+		}
+		expression, _ := cmd.Params["expression"].(string)
+		switch {
+		case strings.Contains(expression, `document.querySelector("#late")`) && strings.HasPrefix(expression, "!!("):
+			// The element appears upon the third check
+			waitCount++
+			return evalResult(waitCount >= 3), ""
+		case strings.HasPrefix(expression, "!!("):
+			return evalResult(false), ""
+		case strings.Contains(expression, `elem.value = value`):
+			return evalResult(strings.Contains(expression, `(document.querySelector("input[name=\"q\"]"), "a \"quoted\" value")`)), ""
+		case strings.Contains(expression, "getBoundingClientRect"):
+			if strings.Contains(expression, `"#missing"`) {
+				return evalResult(map[string]interface{}{}), ""
+			}
+			return evalResult(map[string]interface{}{"x": 50.5, "y": 20}), ""
+		case strings.Contains(expression, "querySelectorAll"):
+			if strings.Contains(expression, `".price"`) {
+				return evalResult([]string{"$1", "$2"}), ""
+			}
+			return evalResult([]string{}), ""
+		}
+		return evalResult(nil), ""
+	})
+	if err := instance.WaitForSelector(context.Background(), "#late", 3); err != nil || waitCount != 3 {
+		t.Fatal(err, waitCount)
+	}
+	if err := instance.WaitForSelector(context.Background(), "#never", 0); err == nil || !strings.Contains(err.Error(), "#never") {
+		t.Fatal(err)
+	}
+	// Cancelling the context stops the wait well before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(WaitPollIntervalMilli*2*time.Millisecond, cancel)
+	start := time.Now()
+	if err := instance.WaitForSelector(ctx, "#never", 10); err == nil || !strings.Contains(err.Error(), "canceled") || time.Since(start) > 2*time.Second {
+		t.Fatal(err, time.Since(start))
+	}
+	if err := instance.SetValueBySelector(`input[name="q"]`, `a "quoted" value`); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.SetValueBySelector("#missing", "value"); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := instance.ClickSelector("#button"); err != nil || strings.Join(clicked, ",") != "mouseMoved,mousePressed,mouseReleased" {
+		t.Fatal(err, clicked)
+	}
+	if err := instance.ClickSelector("#missing"); err == nil {
+		t.Fatal("did not error")
+	}
+	if text, err := instance.GetTextBySelector(".price"); err != nil || text != "$1\n$2" {
+		t.Fatal(text, err)
+	}
+	if _, err := instance.GetTextBySelector(".nothing"); err == nil {
+		t.Fatal("did not error")
+	}
+}
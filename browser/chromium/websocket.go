@@ -0,0 +1,219 @@
+package chromium
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebSocketAcceptGUID is the magic string that server concatenates with client's key to form the accept key (RFC 6455).
+	WebSocketAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// WebSocketMaxMessageLen is the maximum size of a message received from DevTools, enough for a large screenshot.
+	WebSocketMaxMessageLen = 64 * 1048576
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+/*
+WebSocketConn is a minimal websocket client (RFC 6455) that is just enough for exchanging DevTools protocol messages
+with Chromium. It does not support extensions or sub-protocols.
+*/
+type WebSocketConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex *sync.Mutex
+}
+
+// webSocketAcceptKey calculates the accept key that server should respond with for the client key.
+func webSocketAcceptKey(clientKey string) string {
+	digest := sha1.Sum([]byte(clientKey + WebSocketAcceptGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// DialWebSocket connects to a websocket server at the "ws://" URL and completes the handshake within the timeout.
+func DialWebSocket(wsURL string, timeoutSec int) (*WebSocketConn, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, fmt.Errorf("chromium.DialWebSocket: failed to parse URL - %v", err)
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("chromium.DialWebSocket: unsupported URL scheme \"%s\"", u.Scheme)
+	}
+	timeout := time.Duration(timeoutSec) * time.Second
+	conn, err := net.DialTimeout("tcp", u.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("chromium.DialWebSocket: failed to connect - %v", err)
+	}
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chromium.DialWebSocket: failed to generate key - %v", err)
+	}
+	clientKey := base64.StdEncoding.EncodeToString(keyBytes)
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	handshake := "GET " + u.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + clientKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chromium.DialWebSocket: failed to send handshake - %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chromium.DialWebSocket: failed to read handshake response - %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != webSocketAcceptKey(clientKey) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chromium.DialWebSocket: server refused to upgrade - %s", resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &WebSocketConn{conn: conn, reader: reader, writeMutex: new(sync.Mutex)}, nil
+}
+
+// writeFrame sends a single masked frame that carries the entire payload.
+func (ws *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	// Client must mask all frames sent to server
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame := make([]byte, 0, len(header)+len(mask)+len(payload))
+	frame = append(frame, header...)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// WriteText sends a text message.
+func (ws *WebSocketConn) WriteText(message []byte) error {
+	return ws.writeFrame(wsOpText, message)
+}
+
+// readFrame reads a single frame and returns its fin bit, opcode, and unmasked payload.
+func (ws *WebSocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(ws.reader, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err = io.ReadFull(ws.reader, extended); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err = io.ReadFull(ws.reader, extended); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > WebSocketMaxMessageLen {
+		err = fmt.Errorf("chromium.WebSocketConn.readFrame: frame of %d bytes is too large", length)
+		return
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(ws.reader, mask); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+/*
+ReadMessage blocks until a complete text or binary message arrives, and returns the message. Ping is answered
+automatically. When server closes the connection, the function returns io.EOF.
+*/
+func (ws *WebSocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpClose:
+			_ = ws.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpText, wsOpBinary, wsOpContinuation:
+		default:
+			return nil, fmt.Errorf("chromium.WebSocketConn.ReadMessage: unknown opcode %d", opcode)
+		}
+		if len(message)+len(payload) > WebSocketMaxMessageLen {
+			return nil, errors.New("chromium.WebSocketConn.ReadMessage: message is too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// Close sends a close frame and then closes the connection.
+func (ws *WebSocketConn) Close() error {
+	_ = ws.writeFrame(wsOpClose, nil)
+	return ws.conn.Close()
+}
@@ -0,0 +1,129 @@
+package chromium
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testServerConn is the server side of a websocket connection used by test cases.
+type testServerConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// writeFrame sends an unmasked frame.
+func (srv *testServerConn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	header := []byte{first}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	_, err := srv.conn.Write(append(header, payload...))
+	return err
+}
+
+// readFrame reads a masked client frame.
+func (srv *testServerConn) readFrame() (opcode byte, payload []byte, err error) {
+	ws := &WebSocketConn{reader: srv.reader}
+	_, opcode, payload, err = ws.readFrame()
+	return
+}
+
+// newTestWebSocketServer starts a websocket server that hands each connection over to the handler.
+func newTestWebSocketServer(t *testing.T, handler func(*testServerConn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Sec-WebSocket-Version") != "13" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "not websocket", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+			webSocketAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		_ = rw.Flush()
+		handler(&testServerConn{conn: conn, reader: rw.Reader})
+	}))
+}
+
+func TestWebSocketConn(t *testing.T) {
+	// Known answer from RFC 6455
+	if key := webSocketAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal(key)
+	}
+	pong := make(chan string, 1)
+	server := newTestWebSocketServer(t, func(srv *testServerConn) {
+		for {
+			opcode, payload, err := srv.readFrame()
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsOpPong:
+				pong <- string(payload)
+			case wsOpClose:
+				return
+			case wsOpText:
+				// Ping the client, and then echo the message in two fragments.
+				_ = srv.writeFrame(true, wsOpPing, []byte("ping"))
+				half := len(payload) / 2
+				_ = srv.writeFrame(false, wsOpText, payload[:half])
+				_ = srv.writeFrame(true, wsOpContinuation, payload[half:])
+				if string(payload) == "bye" {
+					_ = srv.writeFrame(true, wsOpClose, nil)
+				}
+			}
+		}
+	})
+	defer server.Close()
+
+	if _, err := DialWebSocket(server.URL, 3); err == nil {
+		t.Fatal("should not accept http scheme")
+	}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, err := DialWebSocket(wsURL, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for _, message := range []string{"hello", strings.Repeat("a", 200), strings.Repeat("b", 70000)} {
+		if err := ws.WriteText([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		echo, err := ws.ReadMessage()
+		if err != nil || string(echo) != message {
+			t.Fatal(err, len(echo))
+		}
+		if answer := <-pong; answer != "ping" {
+			t.Fatal(answer)
+		}
+	}
+	if err := ws.WriteText([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if echo, err := ws.ReadMessage(); err != nil || string(echo) != "bye" {
+		t.Fatal(err, string(echo))
+	}
+	if _, err := ws.ReadMessage(); err != io.EOF {
+		t.Fatal(err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/browser"
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/platform"
@@ -137,6 +138,20 @@ func (instances *Instances) Retrieve(index int, expectedTag string) *Instance {
 	return browser
 }
 
+// AcquireRenderer is identical to Acquire, it offers the browser instance as a generic browser.Renderer.
+func (instances *Instances) AcquireRenderer() (int, browser.Renderer, error) {
+	index, instance, err := instances.Acquire()
+	return index, instance, err
+}
+
+// RetrieveRenderer is identical to Retrieve, it offers the browser instance as a generic browser.Renderer.
+func (instances *Instances) RetrieveRenderer(index int, expectedTag string) browser.Renderer {
+	if instance := instances.Retrieve(index, expectedTag); instance != nil {
+		return instance
+	}
+	return nil
+}
+
 /*
 Forcibly stop all browser instances.
 Be aware that, laitos does not use a persistent record of containers spawned, hence if laitos crashes, it will not be
@@ -17,6 +17,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/browser"
 	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/misc"
@@ -679,6 +680,16 @@ Please try again soon.`
 	return nil
 }
 
+// GetIndex returns the instance number assigned by renderer lifecycle management.
+func (instance *Instance) GetIndex() int {
+	return instance.Index
+}
+
+// GetTag returns the string that uniquely identifies this browser server after it is started.
+func (instance *Instance) GetTag() string {
+	return instance.Tag
+}
+
 // GetDebugOutput retrieves the latest standard output and standard error content from javascript server.
 func (instance *Instance) GetDebugOutput() string {
 	if instance.jsDebugOutput == nil {
@@ -867,11 +878,8 @@ func (instance *Instance) SendKey(aString string, aCode int64) error {
 	return nil
 }
 
-// RemotePageInfo describes the title and URL of the phantomJS page.
-type RemotePageInfo struct {
-	Title string `json:"title"`
-	URL   string `json:"page_url"`
-}
+// RemotePageInfo describes the title and URL of the browser page.
+type RemotePageInfo = browser.RemotePageInfo
 
 // GetPageInfo returns title and URL of the current page.
 func (instance *Instance) GetPageInfo() (info RemotePageInfo, err error) {
@@ -885,13 +893,7 @@ func (instance *Instance) LOResetNavigation() error {
 }
 
 // ElementInfo tells about an element encountered while navigating around DOM in line-oriented browser.
-type ElementInfo struct {
-	TagName   string      `json:"tag"`   // TagName is the HTML tag name.
-	ID        string      `json:"id"`    // ID is DOM element's ID.
-	Name      string      `json:"name"`  // Name is DOM element's name.
-	Value     interface{} `json:"value"` // Value is DOM element's value.
-	InnerHTML string      `json:"inner"` // InnerHTML is DOM element's inner HTML.
-}
+type ElementInfo = browser.ElementInfo
 
 // LONext (line-oriented browser) navigates to the next element in DOM. Return information of previous, current, and next element after the action.
 func (instance *Instance) LONextElement() (elements []ElementInfo, err error) {
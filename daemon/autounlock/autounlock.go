@@ -2,6 +2,11 @@ package autounlock
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -36,11 +41,23 @@ const (
 /*
 Daemon periodically probes URLs where laitos password input servers ("passwdserver") are located in order to unlock
 their program data, and submits stored passwords to those laitos URLs to unlock their data.
+The password of a URL may also be a share of the decryption password, in which case the password input server waits for
+shares from other peers, and unlocks program data when enough shares have arrived.
+
+Each URL must come with the fingerprint of its encrypted program data. The password is only released via unlock
+handshake, in which the server proves possession of the encrypted data and the daemon proves its identity using its
+private key. Therefore, the password never reaches an impostor of the URL.
 */
 type Daemon struct {
 	URLAndPassword map[string]string `json:"URLAndPassword"` // URLAndPassword is a mapping between URL and corresponding password.
-	IntervalSec    int               `json:"IntervalSec"`    // IntervalSec is the interval at which URLs are checked.
+	// URLAndDataFingerprint is a mapping between URL and hex fingerprint of the server's encrypted program data.
+	URLAndDataFingerprint map[string]string `json:"URLAndDataFingerprint"`
+	// PrivateKey is the base64 Ed25519 private key that proves the identity of this daemon in unlock handshakes.
+	PrivateKey  string `json:"PrivateKey"`
+	IntervalSec int    `json:"IntervalSec"` // IntervalSec is the interval at which URLs are checked.
 
+	peerKey       ed25519.PrivateKey
+	fingerprints  map[string][]byte
 	loopIsRunning int32     // loopIsRunning has value 1 only when the daemon loop is running.
 	stop          chan bool // stop signals daemon loop to stop
 	logger        lalog.Logger
@@ -59,11 +76,87 @@ func (daemon *Daemon) Initialise() error {
 		if _, err := url.Parse(aURL); err != nil {
 			return fmt.Errorf("autounlock.Initialise: failed to parse URL \"%s\" - %v", aURL, err)
 		}
+		if misc.IsSecretShare(passwd) {
+			if _, err := misc.ParseSecretShare(passwd); err != nil {
+				return fmt.Errorf("autounlock.Initialise: malformed share of password for URL \"%s\" - %v", aURL, err)
+			}
+		}
+	}
+	// Decode the fingerprints and private key used in unlock handshakes
+	daemon.fingerprints = make(map[string][]byte)
+	for aURL, hexFingerprint := range daemon.URLAndDataFingerprint {
+		if _, exists := daemon.URLAndPassword[aURL]; !exists {
+			return fmt.Errorf("autounlock.Initialise: URL \"%s\" has a fingerprint but no password", aURL)
+		}
+		fingerprint, err := hex.DecodeString(hexFingerprint)
+		if err != nil || len(fingerprint) != sha256.Size {
+			return fmt.Errorf("autounlock.Initialise: malformed fingerprint for URL \"%s\"", aURL)
+		}
+		daemon.fingerprints[aURL] = fingerprint
+	}
+	daemon.peerKey = nil
+	if daemon.PrivateKey != "" {
+		var err error
+		if daemon.peerKey, err = misc.ParseUnlockPeerPrivateKey(daemon.PrivateKey); err != nil {
+			return fmt.Errorf("autounlock.Initialise: %v", err)
+		}
+	} else if len(daemon.fingerprints) > 0 {
+		return errors.New("autounlock.Initialise: PrivateKey must be present to carry out unlock handshakes")
+	}
+	for aURL := range daemon.URLAndPassword {
+		if _, exists := daemon.fingerprints[aURL]; !exists {
+			return fmt.Errorf("autounlock.Initialise: URL \"%s\" must have a data fingerprint, the password is never submitted without verifying the server", aURL)
+		}
 	}
 	daemon.stop = make(chan bool)
 	return nil
 }
 
+/*
+submitPassword submits the password to the password input server at the URL via unlock handshake, and returns the
+server response.
+*/
+func (daemon *Daemon) submitPassword(aURL, passwd string) (inet.HTTPResponse, error) {
+	urlTemplate := strings.Replace(aURL, "%", "%%", -1)
+	fingerprint := daemon.fingerprints[aURL]
+	// Ask the server to prove possession of the encrypted data
+	clientNonce, err := misc.NewUnlockClientNonce()
+	if err != nil {
+		return inet.HTTPResponse{}, err
+	}
+	challengeResp, err := inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		// While unlocking is going on, the system is often freshly booted and quite busy, hence giving it plenty of time to respond.
+		TimeoutSec:  30,
+		Method:      http.MethodPost,
+		ContentType: "application/x-www-form-urlencoded",
+		Body:        strings.NewReader(url.Values{misc.UnlockChallengeInputName: []string{base64.StdEncoding.EncodeToString(clientNonce)}}.Encode()),
+	}, urlTemplate)
+	if err != nil {
+		return challengeResp, err
+	} else if err := challengeResp.Non2xxToError(); err != nil {
+		return challengeResp, err
+	}
+	var challenge misc.UnlockChallenge
+	if err := json.Unmarshal(challengeResp.Body, &challenge); err != nil {
+		return challengeResp, fmt.Errorf("malformed challenge - %v", err)
+	}
+	// Seal the password only for the genuine server
+	unlockResp, err := misc.RespondToUnlockChallenge(daemon.peerKey, fingerprint, clientNonce, &challenge, passwd)
+	if err != nil {
+		return challengeResp, err
+	}
+	unlockRespJSON, err := json.Marshal(unlockResp)
+	if err != nil {
+		return challengeResp, err
+	}
+	return inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		TimeoutSec:  30,
+		Method:      http.MethodPost,
+		ContentType: "application/x-www-form-urlencoded",
+		Body:        strings.NewReader(url.Values{misc.UnlockResponseInputName: []string{string(unlockRespJSON)}}.Encode()),
+	}, urlTemplate)
+}
+
 // StartAndBlock starts the loop that probes URLs.
 func (daemon *Daemon) StartAndBlock() error {
 	daemon.logger.Info("StartAndBlock", "", nil, "going to probe %d URLs", len(daemon.URLAndPassword))
@@ -82,14 +175,7 @@ func (daemon *Daemon) StartAndBlock() error {
 					// The URL is responding successfully and is indeed a password input web server
 					begin := time.Now().UnixNano()
 					daemon.logger.Warning("StartAndBlock", "", nil, "trying to unlock data on domain %s", parsedURL.Host)
-					// Use form submission to input password
-					submitResp, submitErr := inet.DoHTTP(context.Background(), inet.HTTPRequest{
-						// While unlocking is going on, the system is often freshly booted and quite busy, hence giving it plenty of time to respond.
-						TimeoutSec:  30,
-						Method:      http.MethodPost,
-						ContentType: "application/x-www-form-urlencoded",
-						Body:        strings.NewReader(url.Values{PasswordInputName: []string{passwd}}.Encode()),
-					}, strings.Replace(aURL, "%", "%%", -1))
+					submitResp, submitErr := daemon.submitPassword(aURL, passwd)
 					if submitErr != nil {
 						daemon.logger.Warning("StartAndBlock", "", submitErr, "failed to submit password to domain %s", parsedURL.Host)
 					} else if submitHTTPErr := submitResp.Non2xxToError(); submitHTTPErr != nil {
@@ -119,21 +205,70 @@ func (daemon *Daemon) Stop() {
 }
 
 func TestAutoUnlock(daemon *Daemon, t testingstub.T) {
-	var unlocked bool
+	var unlockedViaHandshake, plainReceivedPassword, spoofReceivedPassword bool
 	// Start a web server that behaves somewhat similar to the real password input server
 	pwdMatch := "this is a sample password"
 	pwdURL := "/password-input"
+	handshakeURL := "/password-input-handshake"
+	spoofURL := "/password-input-spoof"
+	// The genuine server possesses the encrypted data, whereas the spoof server does not.
+	fingerprint := sha256.Sum256([]byte("encrypted data"))
+	privateKey, publicKey, err := misc.GenerateUnlockPeerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockServer, err := misc.NewUnlockServer(fingerprint[:], []string{publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofServer, err := misc.NewUnlockServer([]byte("spoof"), []string{publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshakeHandler := func(server *misc.UnlockServer, onPassword func(string)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Location", ContentLocationMagic)
+			if r.Method != http.MethodPost {
+				return
+			}
+			if clientNonce, err := base64.StdEncoding.DecodeString(r.FormValue(misc.UnlockChallengeInputName)); err == nil && len(clientNonce) > 0 {
+				challenge, err := server.Challenge(r.RemoteAddr, clientNonce)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(challenge)
+			} else if r.FormValue(misc.UnlockResponseInputName) != "" {
+				var resp misc.UnlockResponse
+				if err := json.Unmarshal([]byte(r.FormValue(misc.UnlockResponseInputName)), &resp); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				password, err := server.Open(&resp)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				onPassword(password)
+			} else if r.FormValue(PasswordInputName) != "" {
+				onPassword(r.FormValue(PasswordInputName))
+			}
+		}
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc(pwdURL, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			w.Header().Set("Content-Location", ContentLocationMagic)
-		} else if r.Method == http.MethodPost {
-			if r.FormValue(PasswordInputName) == pwdMatch {
-				unlocked = true
-				_, _ = w.Write([]byte("very good!"))
-			}
+		} else if r.Method == http.MethodPost && r.FormValue(PasswordInputName) != "" {
+			plainReceivedPassword = true
 		}
 	})
+	mux.HandleFunc(handshakeURL, handshakeHandler(unlockServer, func(password string) {
+		unlockedViaHandshake = password == pwdMatch
+	}))
+	mux.HandleFunc(spoofURL, handshakeHandler(spoofServer, func(string) {
+		spoofReceivedPassword = true
+	}))
 	l, err := net.Listen("tcp", "0.0.0.0:0")
 	if err != nil {
 		t.Fatal(err)
@@ -151,7 +286,21 @@ func TestAutoUnlock(daemon *Daemon, t testingstub.T) {
 		Usually, the daemon configuration is made by the caller of this function, however, in this case it is not
 		possible for caller to find out the port of the HTTP server above, therefore craft the configuration right here.
 	*/
-	daemon.URLAndPassword[fmt.Sprintf("http://localhost:%d%s", l.Addr().(*net.TCPAddr).Port, pwdURL)] = pwdMatch
+	urlPrefix := fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	// A URL without data fingerprint is refused
+	daemon.URLAndPassword[urlPrefix+pwdURL] = pwdMatch
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "fingerprint") {
+		t.Fatal(err)
+	}
+	delete(daemon.URLAndPassword, urlPrefix+pwdURL)
+	daemon.URLAndPassword[urlPrefix+handshakeURL] = pwdMatch
+	daemon.URLAndPassword[urlPrefix+spoofURL] = pwdMatch
+	if daemon.URLAndDataFingerprint == nil {
+		daemon.URLAndDataFingerprint = make(map[string]string)
+	}
+	daemon.URLAndDataFingerprint[urlPrefix+handshakeURL] = hex.EncodeToString(fingerprint[:])
+	daemon.URLAndDataFingerprint[urlPrefix+spoofURL] = hex.EncodeToString(fingerprint[:])
+	daemon.PrivateKey = privateKey
 	if err := daemon.Initialise(); err != nil {
 		t.Fatal(err)
 	}
@@ -164,9 +313,12 @@ func TestAutoUnlock(daemon *Daemon, t testingstub.T) {
 	}()
 	// Expect the daemon loop to unlock the server in couple of seconds
 	time.Sleep(10 * time.Second)
-	if !unlocked {
+	if !unlockedViaHandshake {
 		t.Fatal("did not unlock")
 	}
+	if plainReceivedPassword || spoofReceivedPassword {
+		t.Fatal("the password should not have been submitted without verifying the server")
+	}
 	// Expect daemon to stop in a second once it is told to stop
 	daemon.Stop()
 	time.Sleep(1 * time.Second)
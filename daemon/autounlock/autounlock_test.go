@@ -1,9 +1,57 @@
 package autounlock
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
+func TestDaemon_Initialise(t *testing.T) {
+	d := &Daemon{URLAndPassword: map[string]string{"http://localhost/a": ""}}
+	if err := d.Initialise(); err == nil {
+		t.Fatal("did not error")
+	}
+	d.URLAndPassword = map[string]string{"http://localhost/a": "laitosshare-2-1-a1b2c3d4-ab-00000000"}
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatal(err)
+	}
+	shares, err := misc.SplitSecret([]byte("password"), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.URLAndPassword = map[string]string{"http://localhost/a": shares[0], "http://localhost/b": "password"}
+	// Each URL requires a data fingerprint
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "must have a data fingerprint") || d.IntervalSec != 10*60 {
+		t.Fatal(err, d.IntervalSec)
+	}
+	// Unlock handshake requires a well-formed fingerprint and private key
+	d.URLAndDataFingerprint = map[string]string{"http://localhost/a": strings.Repeat("a", 64)}
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "PrivateKey") {
+		t.Fatal(err)
+	}
+	privateKey, _, err := misc.GenerateUnlockPeerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.PrivateKey = privateKey
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "http://localhost/b") {
+		t.Fatal(err)
+	}
+	d.URLAndDataFingerprint["http://localhost/b"] = strings.Repeat("b", 64)
+	if err := d.Initialise(); err != nil || len(d.fingerprints) != 2 || d.peerKey == nil {
+		t.Fatal(err)
+	}
+	d.URLAndDataFingerprint = map[string]string{"http://localhost/a": "aa"}
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "malformed fingerprint") {
+		t.Fatal(err)
+	}
+	d.URLAndDataFingerprint = map[string]string{"http://localhost/c": strings.Repeat("a", 64)}
+	if err := d.Initialise(); err == nil || !strings.Contains(err.Error(), "no password") {
+		t.Fatal(err)
+	}
+}
+
 func TestDaemon_StartAndBlock(t *testing.T) {
 	d := &Daemon{URLAndPassword: map[string]string{}, IntervalSec: 1}
 	TestAutoUnlock(d, t)
@@ -0,0 +1,304 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+const (
+	// ConsolePINPrompt asks the user to enter password PIN at the beginning of a console session.
+	ConsolePINPrompt = "PIN: "
+	// ConsoleCommandPrompt asks the logged-in user to enter a toolbox command.
+	ConsoleCommandPrompt = "> "
+	// ConsoleMaxLoginAttempts is the number of incorrect PIN entries after which a console session is terminated.
+	ConsoleMaxLoginAttempts = 3
+	// ConsoleDefaultMaxLineLength is the default maximum length of an input line, characters beyond the length are discarded.
+	ConsoleDefaultMaxLineLength = 4096
+
+	consoleBackspace = 0x08 // ^H
+	consoleDelete    = 0x7f // DEL, sent by most terminals for the backspace key
+	consoleKillLine  = 0x15 // ^U erases the entire line
+	consoleEndOfText = 0x04 // ^D on an empty line ends the session
+
+	telnetIAC                = 255 // "interpret as command"
+	telnetSB                 = 250 // begins sub-negotiation
+	telnetSE                 = 240 // ends sub-negotiation
+	telnetWill               = 251
+	telnetDont               = 254
+	telnetOptEcho            = 1
+	telnetOptSuppressGoAhead = 3
+)
+
+var (
+	// ErrConsoleLoginFailed is returned when the user of a console session repeatedly enters incorrect PIN.
+	ErrConsoleLoginFailed = errors.New("too many incorrect PIN entries")
+	// ErrConsoleRateLimitExceeded is returned when the console session exceeds the rate limit.
+	ErrConsoleRateLimitExceeded = errors.New("rate limit exceeded")
+
+	/*
+		TelnetCharacterMode is the sequence of telnet negotiation commands that ask a telnet client to send each
+		character as soon as it is typed and let the server echo the input.
+	*/
+	TelnetCharacterMode = []byte{telnetIAC, telnetWill, telnetOptEcho, telnetIAC, telnetWill, telnetOptSuppressGoAhead}
+)
+
+/*
+ConsoleSession carries out an interactive console conversation with a terminal connected over serial line or network.
+Unlike the one-command-per-line conversation, the user enters password PIN only once upon login, and then enters
+toolbox commands without the PIN. The console handles echo and line editing (backspace, ^U) on behalf of terminals
+that do not edit input lines by themselves, and discards telnet negotiation commands from the input.
+*/
+type ConsoleSession struct {
+	// DaemonName and ClientID identify the session in toolbox commands.
+	DaemonName string
+	ClientID   string
+	// Processor is the toolbox command processor that checks the PIN and runs commands.
+	Processor *toolbox.CommandProcessor
+	// Reader is the source of terminal input, and Writer delivers output to the terminal.
+	Reader io.Reader
+	Writer io.Writer
+	// Echo writes the input characters back to the terminal, each PIN character is echoed as an asterisk.
+	Echo bool
+	// CommandTimeoutSec is the timeout of each toolbox command.
+	CommandTimeoutSec int
+	/*
+		IdleTimeoutSec ends a logged-in session after the terminal has been silent for this many seconds, after which
+		the user has to enter the PIN again. If SetReadDeadline is also given, an idle terminal is disconnected instead.
+	*/
+	IdleTimeoutSec int
+	// SetReadDeadline is an optional function that sets the read deadline of the underlying connection.
+	SetReadDeadline func(time.Time) error
+	// SetWriteDeadline is an optional function that sets the write deadline of the underlying connection.
+	SetWriteDeadline func(time.Time) error
+	// AddAndCheckRateLimit is an optional function that is called for each login attempt and command.
+	AddAndCheckRateLimit func() bool
+	// MaxLineLength is the maximum length of an input line.
+	MaxLineLength int
+	Logger        lalog.Logger
+
+	reader       *bufio.Reader
+	lastActivity time.Time
+	previousByte byte
+}
+
+// Converse converses with the terminal until the input ends, IO error occurs, or user fails to log in.
+func (session *ConsoleSession) Converse() error {
+	if session.MaxLineLength < 1 {
+		session.MaxLineLength = ConsoleDefaultMaxLineLength
+	}
+	session.reader = bufio.NewReader(session.Reader)
+	session.lastActivity = time.Now()
+	for {
+		pin, err := session.login()
+		if err != nil {
+			return err
+		}
+		if err := session.runCommands(pin); err != nil {
+			return err
+		}
+		// The session has become idle, user has to log in again.
+		if err := session.write("\r\nsession expired due to inactivity\r\n"); err != nil {
+			return err
+		}
+	}
+}
+
+// login asks the user to enter a PIN, and returns the correct PIN.
+func (session *ConsoleSession) login() (string, error) {
+	for attempt := 0; attempt < ConsoleMaxLoginAttempts; attempt++ {
+		if err := session.write(ConsolePINPrompt); err != nil {
+			return "", err
+		}
+		pin, _, err := session.readLine(true)
+		if err != nil {
+			return "", err
+		}
+		if session.AddAndCheckRateLimit != nil && !session.AddAndCheckRateLimit() {
+			return "", ErrConsoleRateLimitExceeded
+		}
+		if session.Processor.MatchPIN(strings.TrimSpace(pin)) {
+			session.Logger.Info("login", session.ClientID, nil, "user has logged in")
+			return strings.TrimSpace(pin), nil
+		}
+		session.Logger.Info("login", session.ClientID, nil, "user entered an incorrect PIN")
+		if err := session.write(toolbox.ErrPINAndShortcutNotFound.Error() + "\r\n"); err != nil {
+			return "", err
+		}
+	}
+	_ = session.write(ErrConsoleLoginFailed.Error() + "\r\n")
+	return "", ErrConsoleLoginFailed
+}
+
+// runCommands reads and runs toolbox commands until the session becomes idle, or returns an error if conversation fails.
+func (session *ConsoleSession) runCommands(pin string) error {
+	for {
+		if misc.EmergencyLockDown {
+			session.Logger.Warning("runCommands", session.ClientID, misc.ErrEmergencyLockDown, "")
+			return misc.ErrEmergencyLockDown
+		}
+		if err := session.write(ConsoleCommandPrompt); err != nil {
+			return err
+		}
+		line, idle, err := session.readLine(false)
+		if err != nil {
+			return err
+		}
+		if idle {
+			return nil
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if session.AddAndCheckRateLimit != nil && !session.AddAndCheckRateLimit() {
+			return ErrConsoleRateLimitExceeded
+		}
+		result := session.Processor.Process(context.Background(), toolbox.Command{
+			DaemonName: session.DaemonName,
+			ClientID:   session.ClientID,
+			Content:    pin + " " + line,
+			TimeoutSec: session.CommandTimeoutSec,
+		}, true)
+		// Raw terminals need both carriage return and line feed to begin a new line
+		output := strings.ReplaceAll(strings.ReplaceAll(result.CombinedOutput, "\r\n", "\n"), "\n", "\r\n")
+		if err := session.write(output + "\r\n"); err != nil {
+			return err
+		}
+		session.lastActivity = time.Now()
+	}
+}
+
+// write writes the text to the terminal.
+func (session *ConsoleSession) write(text string) error {
+	if session.SetWriteDeadline != nil {
+		if err := session.SetWriteDeadline(time.Now().Add(ServerDefaultIOTimeoutSec * time.Second)); err != nil {
+			return err
+		}
+	}
+	_, err := session.Writer.Write([]byte(text))
+	return err
+}
+
+// readByte reads the next byte of input, telnet negotiation commands are discarded and an escaped IAC (IAC IAC) becomes a 0xff byte.
+func (session *ConsoleSession) readByte() (byte, error) {
+	for {
+		if session.SetReadDeadline != nil {
+			timeoutSec := session.IdleTimeoutSec
+			if timeoutSec < 1 {
+				timeoutSec = ServerDefaultIOTimeoutSec
+			}
+			if err := session.SetReadDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second)); err != nil {
+				return 0, err
+			}
+		}
+		b, err := session.reader.ReadByte()
+		if err != nil || b != telnetIAC {
+			return b, err
+		}
+		cmd, err := session.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case cmd == telnetIAC:
+			return telnetIAC, nil
+		case cmd == telnetSB:
+			// Skip sub-negotiation until IAC SE
+			for previous := byte(0); ; previous = b {
+				if b, err = session.reader.ReadByte(); err != nil {
+					return 0, err
+				} else if previous == telnetIAC && b == telnetSE {
+					break
+				}
+			}
+		case cmd >= telnetWill && cmd <= telnetDont:
+			// WILL, WONT, DO, and DONT are followed by an option code
+			if _, err := session.reader.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+/*
+readLine reads an input line while handling echo and line editing. If the session has been idle for longer than the
+idle timeout before the line begins, the function returns true for idle.
+*/
+func (session *ConsoleSession) readLine(maskEcho bool) (line string, idle bool, err error) {
+	buf := make([]byte, 0, 64)
+	echo := func(text string) {
+		if session.Echo && err == nil {
+			err = session.write(text)
+		}
+	}
+	for {
+		var b byte
+		b, err = session.readByte()
+		if err != nil {
+			return
+		}
+		if len(buf) == 0 && session.IdleTimeoutSec > 0 && time.Since(session.lastActivity) > time.Duration(session.IdleTimeoutSec)*time.Second {
+			idle = true
+		}
+		session.lastActivity = time.Now()
+		previousByte := session.previousByte
+		session.previousByte = b
+		switch {
+		case b == '\r' || b == '\n':
+			// Terminals may end a line with CR, LF, CRLF, or CR NUL
+			if b == '\n' && previousByte == '\r' {
+				continue
+			}
+			echo("\r\n")
+			return string(buf), idle, err
+		case b == consoleBackspace || b == consoleDelete:
+			if len(buf) > 0 {
+				_, size := utf8.DecodeLastRune(buf)
+				buf = buf[:len(buf)-size]
+				echo("\b \b")
+			}
+		case b == consoleKillLine:
+			echo(strings.Repeat("\b \b", utf8.RuneCount(buf)))
+			buf = buf[:0]
+		case b == consoleEndOfText:
+			if len(buf) == 0 {
+				return "", idle, io.EOF
+			}
+		case b < 0x20:
+			// Ignore the other control characters
+		case len(buf) >= session.MaxLineLength:
+			// Discard characters beyond the maximum length
+		default:
+			buf = append(buf, b)
+			// Echo a multi-byte character after all of its bytes have arrived
+			if maskEcho {
+				if utf8.RuneStart(b) {
+					echo("*")
+				}
+			} else if utf8.FullRune(buf[len(buf)-1-lastRuneStart(buf):]) {
+				echo(string(buf[len(buf)-1-lastRuneStart(buf):]))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lastRuneStart returns the number of continuation bytes at the end of the buffer that follow the latest rune start.
+func lastRuneStart(buf []byte) int {
+	n := 0
+	for i := len(buf) - 1; i > 0 && !utf8.RuneStart(buf[i]); i-- {
+		n++
+	}
+	return n
+}
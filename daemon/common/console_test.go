@@ -0,0 +1,103 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+func TestConsoleSession(t *testing.T) {
+	input := "wrong\r\n" +
+		// PIN with a typo corrected by backspace, and telnet negotiation in between
+		"verysecreX\x7ft\xff\xfd\x01\xff\xfa\x18\x01\xff\xf0\r\n" +
+		// Command with a line erased by ^U and a backspace
+		"garbage\x15.s echo hii\x08\r\n" +
+		"\n\r\n" +
+		".s echo -e 'a\\nb'\r\x00" +
+		"\x04"
+	output := new(bytes.Buffer)
+	session := ConsoleSession{
+		DaemonName:        "test",
+		ClientID:          "test",
+		Processor:         toolbox.GetTestCommandProcessor(),
+		Reader:            strings.NewReader(input),
+		Writer:            output,
+		Echo:              true,
+		CommandTimeoutSec: 10,
+	}
+	if err := session.Converse(); err != io.EOF {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"PIN: *****\r\n" + toolbox.ErrPINAndShortcutNotFound.Error() + "\r\n",
+		"PIN: **********\b \b*\r\n> ",
+		"garbage" + strings.Repeat("\b \b", 7) + ".s echo hii\b \b\r\nhi\r\n> ",
+		"\r\na\r\nb\r\n> ",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("missing %q in %q", expected, output.String())
+		}
+	}
+
+	// Too many incorrect PIN entries
+	output.Reset()
+	session.Reader = strings.NewReader("a\rb\rc\rverysecret\r")
+	session.Echo = false
+	if err := session.Converse(); err != ErrConsoleLoginFailed || strings.Count(output.String(), ConsolePINPrompt) != 3 || strings.Contains(output.String(), ConsoleCommandPrompt) {
+		t.Fatal(err, output.String())
+	}
+
+	// Idle session asks for PIN again
+	output.Reset()
+	reader, writer := io.Pipe()
+	session.Reader = reader
+	session.IdleTimeoutSec = 1
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Converse()
+	}()
+	_, _ = writer.Write([]byte("verysecret\r.s echo hi\r"))
+	time.Sleep(2 * time.Second)
+	_, _ = writer.Write([]byte(".s echo ho\r"))
+	_ = writer.Close()
+	if err := <-done; err != io.EOF {
+		t.Fatal(err)
+	}
+	if out := output.String(); !strings.Contains(out, "hi\r\n") || strings.Contains(out, "ho") || strings.Count(out, ConsolePINPrompt) != 2 {
+		t.Fatalf("%q", out)
+	}
+}
+
+func TestConsoleSession_ReadByte(t *testing.T) {
+	var writeDeadlines int
+	session := ConsoleSession{
+		Writer: new(bytes.Buffer),
+		SetWriteDeadline: func(time.Time) error {
+			writeDeadlines++
+			return nil
+		},
+	}
+	// Telnet negotiation is discarded, whereas the escaped IAC byte is passed through.
+	session.reader = bufio.NewReader(strings.NewReader("a\xff\xffb\xff\xfb\x01\xff\xfa\x18\x01\xff\xf0c"))
+	var input []byte
+	for {
+		b, err := session.readByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		input = append(input, b)
+	}
+	if !bytes.Equal(input, []byte("a\xffbc")) {
+		t.Fatalf("%q", input)
+	}
+	if err := session.write("hi"); err != nil || writeDeadlines != 1 {
+		t.Fatal(err, writeDeadlines)
+	}
+}
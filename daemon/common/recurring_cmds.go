@@ -167,3 +167,10 @@ func (cmds *RecurringCommands) GetResults() []string {
 	cmds.results.Clear()
 	return ret
 }
+
+// GetLatestResults returns the latest command execution results and text messages without clearing the result buffer.
+func (cmds *RecurringCommands) GetLatestResults() []string {
+	cmds.mutex.Lock()
+	defer cmds.mutex.Unlock()
+	return cmds.results.GetAll()
+}
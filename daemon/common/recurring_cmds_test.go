@@ -65,6 +65,10 @@ func TestRecurringCommands(t *testing.T) {
 	// Chuck in some arbitrary strings
 	cmds.AddArbitraryTextToResult("arbitrary 1")
 	cmds.AddArbitraryTextToResult("arbitrary 2")
+	// Peeking at the results does not clear them
+	if a := cmds.GetLatestResults(); !reflect.DeepEqual(a, []string{"arbitrary 1", "arbitrary 2"}) {
+		t.Fatal(a)
+	}
 	if a := cmds.GetResults(); !reflect.DeepEqual(a, []string{"arbitrary 1", "arbitrary 2"}) {
 		t.Fatal(a)
 	}
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/browser/chromium"
 	"github.com/HouzuoGuo/laitos/browser/phantomjs"
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/toolbox"
@@ -90,14 +92,24 @@ const (
 
 // Render web page in a server-side javascript-capable browser, and respond with rendered page image.
 type HandleBrowserPhantomJS struct {
-	ImageEndpoint              string              `json:"-"`
-	Browsers                   phantomjs.Instances `json:"Browsers"`
+	ImageEndpoint string              `json:"-"`
+	Browsers      phantomjs.Instances `json:"Browsers"`
+	// Chromium replaces PhantomJS by headless Chromium when it is configured.
+	Chromium                   *chromium.Instances `json:"Chromium"`
 	stripURLPrefixFromResponse string
 }
 
 func (remoteBrowser *HandleBrowserPhantomJS) Initialise(_ lalog.Logger, _ *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
 	remoteBrowser.stripURLPrefixFromResponse = stripURLPrefixFromResponse
-	return remoteBrowser.Browsers.Initialise()
+	return remoteBrowser.Renderers().Initialise()
+}
+
+// Renderers returns the browser instances in use, they are either Chromium (if configured) or PhantomJS.
+func (remoteBrowser *HandleBrowserPhantomJS) Renderers() browser.Renderers {
+	if remoteBrowser.Chromium != nil && remoteBrowser.Chromium.BasePortNumber != 0 {
+		return remoteBrowser.Chromium
+	}
+	return &remoteBrowser.Browsers
 }
 
 // RenderControlPage returns string text of the web page that offers remote browser controls.
@@ -158,14 +170,14 @@ func (remoteBrowser *HandleBrowserPhantomJS) Handle(w http.ResponseWriter, r *ht
 	NoCache(w)
 	if r.Method == http.MethodGet {
 		// Start a new browser instance
-		index, instance, err := remoteBrowser.Browsers.Acquire()
+		index, instance, err := remoteBrowser.Renderers().AcquireRenderer()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to acquire browser instance: %v", err), http.StatusInternalServerError)
 			return
 		}
 		_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 			"Empty Browser", r.RequestURI,
-			index, instance.Tag,
+			index, instance.GetTag(),
 			nil, instance.GetDebugOutput(),
 			800, 800, phantomjs.GoodUserAgent,
 			0, 0, 800, 800,
@@ -174,17 +186,17 @@ func (remoteBrowser *HandleBrowserPhantomJS) Handle(w http.ResponseWriter, r *ht
 			"", remoteBrowser.ImageEndpoint))
 	} else if r.Method == http.MethodPost {
 		index, tag, viewWidth, viewHeight, userAgent, drawTop, drawLeft, drawWidth, drawHeight, pageUrl, pointerX, pointerY, typeText := remoteBrowser.parseSubmission(r)
-		instance := remoteBrowser.Browsers.Retrieve(index, tag)
+		instance := remoteBrowser.Renderers().RetrieveRenderer(index, tag)
 		if instance == nil {
 			// Old instance is no longer there, so start a new browser instance
-			index, instance, err := remoteBrowser.Browsers.Acquire()
+			index, instance, err := remoteBrowser.Renderers().AcquireRenderer()
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Failed to acquire browser instance: %v", err), http.StatusInternalServerError)
 				return
 			}
 			_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 				"Empty Browser", r.RequestURI,
-				index, instance.Tag,
+				index, instance.GetTag(),
 				nil, instance.GetDebugOutput(),
 				800, 800, phantomjs.GoodUserAgent,
 				drawTop, drawLeft, drawWidth, drawHeight,
@@ -199,7 +211,7 @@ func (remoteBrowser *HandleBrowserPhantomJS) Handle(w http.ResponseWriter, r *ht
 			// Set draw region, and the image responded by its own dedicated endpoint will pick it up.
 			actionErr = instance.SetRenderArea(drawTop, drawLeft, drawWidth, drawHeight)
 		case "Kill All":
-			remoteBrowser.Browsers.KillAll()
+			remoteBrowser.Renderers().KillAll()
 			actionErr = errors.New("All browser sessions are gone. Please nagivate back to this browser page by re-entering the URL, do not refresh the page.")
 		case "Back":
 			actionErr = instance.GoBack()
@@ -229,7 +241,7 @@ func (remoteBrowser *HandleBrowserPhantomJS) Handle(w http.ResponseWriter, r *ht
 		}
 		_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 			pageInfo.Title, r.RequestURI,
-			index, instance.Tag,
+			index, instance.GetTag(),
 			actionErr, instance.GetDebugOutput(),
 			viewWidth, viewHeight, userAgent,
 			drawTop, drawLeft, drawWidth, drawHeight,
@@ -248,7 +260,7 @@ func (_ *HandleBrowserPhantomJS) SelfTest() error {
 }
 
 type HandleBrowserPhantomJSImage struct {
-	Browsers *phantomjs.Instances `json:"-"` // Reference to browser instances constructed in HandleBrowser handler
+	Browsers browser.Renderers `json:"-"` // Reference to browser instances constructed in HandleBrowser handler
 }
 
 func (_ *HandleBrowserPhantomJSImage) Initialise(lalog.Logger, *toolbox.CommandProcessor, string) error {
@@ -266,7 +278,7 @@ func (remoteBrowserImage *HandleBrowserPhantomJSImage) Handle(w http.ResponseWri
 		http.Error(w, "Bad instance_index", http.StatusBadRequest)
 		return
 	}
-	instance := remoteBrowserImage.Browsers.Retrieve(index, r.FormValue("instance_tag"))
+	instance := remoteBrowserImage.Browsers.RetrieveRenderer(index, r.FormValue("instance_tag"))
 	if instance == nil {
 		http.Error(w, "That browser session expired", http.StatusBadRequest)
 		return
@@ -275,7 +287,7 @@ func (remoteBrowserImage *HandleBrowserPhantomJSImage) Handle(w http.ResponseWri
 		http.Error(w, "Render error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	pngFile, err := ioutil.ReadFile(instance.RenderImagePath)
+	pngFile, err := ioutil.ReadFile(instance.GetRenderPageFilePath())
 	if err != nil {
 		http.Error(w, "File IO error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/browser/chromium"
 	"github.com/HouzuoGuo/laitos/browser/phantomjs"
 	"github.com/HouzuoGuo/laitos/browser/slimerjs"
 	"github.com/HouzuoGuo/laitos/lalog"
@@ -15,14 +17,24 @@ import (
 
 // Render web page in a server-side javascript-capable browser, and respond with rendered page image.
 type HandleBrowserSlimerJS struct {
-	ImageEndpoint              string             `json:"-"`
-	Browsers                   slimerjs.Instances `json:"Browsers"`
+	ImageEndpoint string             `json:"-"`
+	Browsers      slimerjs.Instances `json:"Browsers"`
+	// Chromium replaces SlimerJS by headless Chromium when it is configured.
+	Chromium                   *chromium.Instances `json:"Chromium"`
 	stripURLPrefixFromResponse string
 }
 
 func (remoteBrowser *HandleBrowserSlimerJS) Initialise(_ lalog.Logger, _ *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
 	remoteBrowser.stripURLPrefixFromResponse = stripURLPrefixFromResponse
-	return remoteBrowser.Browsers.Initialise()
+	return remoteBrowser.Renderers().Initialise()
+}
+
+// Renderers returns the browser instances in use, they are either Chromium (if configured) or SlimerJS.
+func (remoteBrowser *HandleBrowserSlimerJS) Renderers() browser.Renderers {
+	if remoteBrowser.Chromium != nil && remoteBrowser.Chromium.BasePortNumber != 0 {
+		return remoteBrowser.Chromium
+	}
+	return &remoteBrowser.Browsers
 }
 
 func (remoteBrowser *HandleBrowserSlimerJS) parseSubmission(r *http.Request) (instanceIndex int, instanceTag string,
@@ -56,14 +68,14 @@ func (remoteBrowser *HandleBrowserSlimerJS) Handle(w http.ResponseWriter, r *htt
 	NoCache(w)
 	if r.Method == http.MethodGet {
 		// Start a new browser instance
-		index, instance, err := remoteBrowser.Browsers.Acquire()
+		index, instance, err := remoteBrowser.Renderers().AcquireRenderer()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to acquire browser instance: %v", err), http.StatusInternalServerError)
 			return
 		}
 		_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 			"Empty Browser", r.RequestURI,
-			index, instance.Tag,
+			index, instance.GetTag(),
 			nil, instance.GetDebugOutput(),
 			800, 800, phantomjs.GoodUserAgent,
 			0, 0, 800, 800,
@@ -72,17 +84,17 @@ func (remoteBrowser *HandleBrowserSlimerJS) Handle(w http.ResponseWriter, r *htt
 			"", remoteBrowser.ImageEndpoint))
 	} else if r.Method == http.MethodPost {
 		index, tag, viewWidth, viewHeight, userAgent, drawTop, drawLeft, drawWidth, drawHeight, pageUrl, pointerX, pointerY, typeText := remoteBrowser.parseSubmission(r)
-		instance := remoteBrowser.Browsers.Retrieve(index, tag)
+		instance := remoteBrowser.Renderers().RetrieveRenderer(index, tag)
 		if instance == nil {
 			// Old instance is no longer there, so start a new browser instance
-			index, instance, err := remoteBrowser.Browsers.Acquire()
+			index, instance, err := remoteBrowser.Renderers().AcquireRenderer()
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Failed to acquire browser instance: %v", err), http.StatusInternalServerError)
 				return
 			}
 			_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 				"Empty Browser", r.RequestURI,
-				index, instance.Tag,
+				index, instance.GetTag(),
 				nil, instance.GetDebugOutput(),
 				800, 800, phantomjs.GoodUserAgent,
 				drawTop, drawLeft, drawWidth, drawHeight,
@@ -97,7 +109,7 @@ func (remoteBrowser *HandleBrowserSlimerJS) Handle(w http.ResponseWriter, r *htt
 			// Set draw region, and the image responded by its own dedicated endpoint will pick it up.
 			actionErr = instance.SetRenderArea(drawTop, drawLeft, drawWidth, drawHeight)
 		case "Kill All":
-			remoteBrowser.Browsers.KillAll()
+			remoteBrowser.Renderers().KillAll()
 			actionErr = errors.New("All browser sessions are gone. Please nagivate back to this browser page by re-entering the URL, do not refresh the page.")
 		case "Back":
 			actionErr = instance.GoBack()
@@ -127,7 +139,7 @@ func (remoteBrowser *HandleBrowserSlimerJS) Handle(w http.ResponseWriter, r *htt
 		}
 		_, _ = w.Write(RenderControlPage(remoteBrowser.stripURLPrefixFromResponse,
 			pageInfo.Title, r.RequestURI,
-			index, instance.Tag,
+			index, instance.GetTag(),
 			actionErr, instance.GetDebugOutput(),
 			viewWidth, viewHeight, userAgent,
 			drawTop, drawLeft, drawWidth, drawHeight,
@@ -146,7 +158,7 @@ func (_ *HandleBrowserSlimerJS) SelfTest() error {
 }
 
 type HandleBrowserSlimerJSImage struct {
-	Browsers *slimerjs.Instances `json:"-"` // Reference to browser instances constructed in HandleBrowser handler
+	Browsers browser.Renderers `json:"-"` // Reference to browser instances constructed in HandleBrowser handler
 }
 
 func (_ *HandleBrowserSlimerJSImage) Initialise(lalog.Logger, *toolbox.CommandProcessor, string) error {
@@ -164,7 +176,7 @@ func (remoteBrowserImage *HandleBrowserSlimerJSImage) Handle(w http.ResponseWrit
 		http.Error(w, "Bad instance_index", http.StatusBadRequest)
 		return
 	}
-	instance := remoteBrowserImage.Browsers.Retrieve(index, r.FormValue("instance_tag"))
+	instance := remoteBrowserImage.Browsers.RetrieveRenderer(index, r.FormValue("instance_tag"))
 	if instance == nil {
 		http.Error(w, "That browser session expired", http.StatusBadRequest)
 		return
@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/filestore"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+const (
+	// HandleFileStorePage is the HTML source code template of the file store page.
+	HandleFileStorePage = `<html>
+<head>
+	<title>laitos file store</title>
+</head>
+<body>
+	<form action="%s" method="post" enctype="multipart/form-data">
+		<p>
+			<input type="file" name="upload" />
+			Expire in hours: <input type="text" name="expire_hours" value="" size="4" />
+			Maximum downloads: <input type="text" name="max_downloads" value="" size="4" />
+			<input type="submit" name="action" value="Upload"/>
+		</p>
+	</form>
+	<pre>%s</pre>
+	<table>
+		<tr><th>Name</th><th>Size</th><th>Uploaded</th><th>Expires</th><th>Downloads</th><th></th></tr>
+		%s
+	</table>
+</body>
+</html>
+`
+	// handleFileStoreRow is the HTML source code template of a file in the file store page.
+	handleFileStoreRow = `<tr><td>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%s</td><td>
+<form action="%s" method="post"><input type="hidden" name="id" value="%s"/>
+Share for hours: <input type="text" name="share_hours" value="24" size="4"/>
+<input type="submit" name="action" value="Share"/> <input type="submit" name="action" value="Delete"/>
+</form></td></tr>
+`
+	// HandleFileStoreTusVersion is the version of tus resumable upload protocol supported by the file store.
+	HandleFileStoreTusVersion = "1.0.0"
+	// HandleFileStoreDefaultMaxFileSizeMB is the default maximum size of an individual file.
+	HandleFileStoreDefaultMaxFileSizeMB = 1024
+)
+
+/*
+HandleFileStore is an authenticated file store. The owner uploads files via the web page or a tus resumable upload
+client, manages the files on the web page, and shares them with others via signed links that work without a password.
+*/
+type HandleFileStore struct {
+	Dir                string `json:"Dir"`                // Dir is the directory where files are kept.
+	Password           string `json:"Password"`           // Password authenticates the owner via HTTP basic authentication, user name is ignored.
+	EncryptionPassword string `json:"EncryptionPassword"` // EncryptionPassword optionally encrypts files at rest.
+	QuotaMB            int    `json:"QuotaMB"`            // QuotaMB is the maximum total size of all files, 0 means unlimited.
+	MaxFileSizeMB      int    `json:"MaxFileSizeMB"`      // MaxFileSizeMB is the maximum size of an individual file.
+	DefaultExpireHours int    `json:"DefaultExpireHours"` // DefaultExpireHours is the expiration of files that do not specify one.
+
+	store                      *filestore.Store
+	logger                     lalog.Logger
+	stripURLPrefixFromResponse string
+	// countedDownloads are the share link and client combinations that have already used up a download, and the time their link expires.
+	countedDownloads      map[string]time.Time
+	countedDownloadsMutex *sync.Mutex
+}
+
+// Initialise prepares the file store.
+func (fs *HandleFileStore) Initialise(logger lalog.Logger, _ *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
+	fs.logger = logger
+	fs.stripURLPrefixFromResponse = stripURLPrefixFromResponse
+	if fs.Dir == "" || fs.Password == "" {
+		return errors.New("HandleFileStore.Initialise: Dir and Password must not be empty")
+	}
+	if fs.MaxFileSizeMB < 1 {
+		fs.MaxFileSizeMB = HandleFileStoreDefaultMaxFileSizeMB
+	}
+	fs.store = &filestore.Store{
+		Dir:                fs.Dir,
+		EncryptionPassword: fs.EncryptionPassword,
+		QuotaBytes:         int64(fs.QuotaMB) * 1048576,
+		MaxFileSizeBytes:   int64(fs.MaxFileSizeMB) * 1048576,
+		DefaultExpireSec:   fs.DefaultExpireHours * 3600,
+	}
+	if err := fs.store.Initialise(); err != nil {
+		return fmt.Errorf("HandleFileStore.Initialise: %v", err)
+	}
+	fs.countedDownloads = make(map[string]time.Time)
+	fs.countedDownloadsMutex = new(sync.Mutex)
+	return nil
+}
+
+// isOwner returns true if the request carries the owner's password.
+func (fs *HandleFileStore) isOwner(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(fs.Password)) == 1
+}
+
+// writeStoreError responds to the client with an HTTP status code that corresponds to the file store error.
+func (fs *HandleFileStore) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case filestore.ErrFileNotFound, filestore.ErrDownloadsExhausted:
+		status = http.StatusNotFound
+	case filestore.ErrFileTooLarge, filestore.ErrQuotaExceeded:
+		status = http.StatusRequestEntityTooLarge
+	case filestore.ErrOffsetMismatch, filestore.ErrUploadInProgress, filestore.ErrUploadIncomplete:
+		status = http.StatusConflict
+	case filestore.ErrBadLinkSignature, filestore.ErrLinkExpired:
+		status = http.StatusForbidden
+	default:
+		fs.logger.Warning("HandleFileStore", GetRealClientIP(r), err, "failed to handle %s request", r.Method)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func (fs *HandleFileStore) Handle(w http.ResponseWriter, r *http.Request) {
+	NoCache(w)
+	query := r.URL.Query()
+	id := query.Get("id")
+	// Anyone may download a file via a share link
+	if sig := query.Get("sig"); sig != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if err := fs.store.VerifyLink(id, query.Get("exp"), sig); err != nil {
+			fs.writeStoreError(w, r, err)
+			return
+		}
+		linkExpires, _ := strconv.ParseInt(query.Get("exp"), 10, 64)
+		fs.download(w, r, id, id+"/"+sig+"/"+GetRealClientIP(r), time.Unix(linkExpires, 0))
+		return
+	}
+	if !fs.isOwner(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="laitos file store"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodOptions || r.Header.Get("Tus-Resumable") != "":
+		fs.handleTus(w, r, id)
+	case r.Method == http.MethodGet && id != "":
+		fs.download(w, r, id, "", time.Time{})
+	case r.Method == http.MethodPost:
+		fs.handleForm(w, r)
+	default:
+		fs.render(w, r, "")
+	}
+}
+
+/*
+download responds with the file content. A download made by a client via a share link (identified by the downloader
+string) counts towards the file's download limit only once, as soon as the first transfer begins. The client may
+request the file again via the same link, e.g. to resume a broken transfer, without using up another download. The
+download made by the owner (empty downloader string) never counts.
+*/
+func (fs *HandleFileStore) download(w http.ResponseWriter, r *http.Request, id, downloader string, linkExpires time.Time) {
+	countDownload := downloader != "" && r.Method != http.MethodHead
+	if countDownload {
+		fs.countedDownloadsMutex.Lock()
+		now := time.Now()
+		for key, expires := range fs.countedDownloads {
+			if now.After(expires) {
+				delete(fs.countedDownloads, key)
+			}
+		}
+		_, counted := fs.countedDownloads[downloader]
+		fs.countedDownloadsMutex.Unlock()
+		countDownload = !counted
+	}
+	info, content, err := fs.store.Open(id, countDownload)
+	if err != nil {
+		fs.writeStoreError(w, r, err)
+		return
+	}
+	defer content.Close()
+	if countDownload {
+		fs.logger.Info("HandleFileStore", GetRealClientIP(r), nil, "file \"%s\" is downloaded via share link (%d of %d)", info.Name, info.Downloads, info.MaxDownloads)
+		// Files without a download limit do not need to remember the clients, which also bounds the memory usage.
+		if info.MaxDownloads > 0 {
+			fs.countedDownloadsMutex.Lock()
+			fs.countedDownloads[downloader] = linkExpires
+			fs.countedDownloadsMutex.Unlock()
+		}
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(info.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	if file, ok := content.(*os.File); ok {
+		// Plain files support partial downloads
+		http.ServeContent(w, r, info.Name, info.CreatedAt, file)
+		return
+	}
+	// Encrypted content cannot seek, it is always sent in entirety regardless of the requested range.
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, content); err != nil {
+		fs.logger.Warning("HandleFileStore", GetRealClientIP(r), err, "failed to send file \"%s\"", info.Name)
+	}
+}
+
+// parseTusMetadata decodes the value of tus Upload-Metadata header, which consists of comma separated key and base64 value pairs.
+func parseTusMetadata(header string) map[string]string {
+	ret := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			value, _ = base64.StdEncoding.DecodeString(fields[1])
+		}
+		ret[fields[0]] = string(value)
+	}
+	return ret
+}
+
+/*
+handleTus implements the core, creation, expiration, and termination of tus resumable upload protocol. The location of
+an upload is the handler's own location with the file ID in the query string.
+*/
+func (fs *HandleFileStore) handleTus(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Tus-Resumable", HandleFileStoreTusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", HandleFileStoreTusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(fs.store.MaxFileSizeBytes, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != HandleFileStoreTusVersion {
+		w.Header().Set("Tus-Version", HandleFileStoreTusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			http.Error(w, "Upload-Length is required", http.StatusBadRequest)
+			return
+		}
+		metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		expireHours, _ := strconv.Atoi(metadata["expire_hours"])
+		maxDownloads, _ := strconv.Atoi(metadata["max_downloads"])
+		info, err := fs.store.Create(metadata["filename"], size, expireHours*3600, maxDownloads)
+		if err != nil {
+			fs.writeStoreError(w, r, err)
+			return
+		}
+		fs.logger.Info("HandleFileStore", GetRealClientIP(r), nil, "started upload of file \"%s\" (%d bytes)", info.Name, info.Size)
+		w.Header().Set("Location", strings.TrimPrefix(r.URL.Path, fs.stripURLPrefixFromResponse)+"?id="+info.ID)
+		w.Header().Set("Upload-Expires", info.GetUploadExpiresAt().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead:
+		info, err := fs.store.Get(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+		w.Header().Set("Upload-Expires", info.GetUploadExpiresAt().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "Upload-Offset is required", http.StatusBadRequest)
+			return
+		}
+		newOffset, err := fs.store.Append(id, offset, r.Body)
+		// An interrupted upload still makes progress, the client resumes from the new offset.
+		if err == filestore.ErrOffsetMismatch || err != nil && newOffset <= offset {
+			fs.writeStoreError(w, r, err)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := fs.store.Delete(id); err != nil {
+			fs.writeStoreError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleForm carries out the upload, share, and delete actions submitted from the file store page.
+func (fs *HandleFileStore) handleForm(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 * 1048576); err != nil && err != http.ErrNotMultipart {
+		fs.render(w, r, "Failed to read the form")
+		return
+	}
+	id := r.FormValue("id")
+	switch r.FormValue("action") {
+	case "Upload":
+		uploadFile, fileHeader, err := r.FormFile("upload")
+		if err != nil {
+			fs.render(w, r, "Please choose a file to upload")
+			return
+		}
+		defer uploadFile.Close()
+		expireHours, _ := strconv.Atoi(r.FormValue("expire_hours"))
+		maxDownloads, _ := strconv.Atoi(r.FormValue("max_downloads"))
+		info, err := fs.store.Create(fileHeader.Filename, fileHeader.Size, expireHours*3600, maxDownloads)
+		if err == nil {
+			_, err = fs.store.Append(info.ID, 0, uploadFile)
+		}
+		if err != nil {
+			fs.render(w, r, "Failed to upload: "+err.Error())
+			return
+		}
+		fs.logger.Info("HandleFileStore", GetRealClientIP(r), nil, "uploaded file \"%s\" (%d bytes)", info.Name, info.Size)
+		fs.render(w, r, "Uploaded "+info.Name)
+	case "Share":
+		hours, err := strconv.Atoi(r.FormValue("share_hours"))
+		if err != nil || hours < 1 {
+			fs.render(w, r, "Please enter the number of hours to share the file for")
+			return
+		}
+		query, err := fs.store.SignLink(id, time.Duration(hours)*time.Hour)
+		if err != nil {
+			fs.render(w, r, "Failed to share: "+err.Error())
+			return
+		}
+		fs.render(w, r, fmt.Sprintf("Share link: %s?%s", strings.TrimPrefix(r.URL.Path, fs.stripURLPrefixFromResponse), query))
+	case "Delete":
+		if err := fs.store.Delete(id); err != nil {
+			fs.render(w, r, "Failed to delete: "+err.Error())
+			return
+		}
+		fs.render(w, r, "Deleted")
+	default:
+		fs.render(w, r, "")
+	}
+}
+
+// render renders the file store page that lists all files.
+func (fs *HandleFileStore) render(w http.ResponseWriter, r *http.Request, message string) {
+	ownLocation := html.EscapeString(strings.TrimPrefix(r.URL.Path, fs.stripURLPrefixFromResponse))
+	files, err := fs.store.List()
+	if err != nil {
+		message += "\nFailed to list files: " + err.Error()
+	}
+	var rows bytes.Buffer
+	for _, file := range files {
+		name := html.EscapeString(file.Name)
+		if file.Complete() {
+			name = fmt.Sprintf(`<a href="%s?id=%s">%s</a>`, ownLocation, file.ID, name)
+		}
+		downloads := strconv.Itoa(file.Downloads)
+		if file.MaxDownloads > 0 {
+			downloads += "/" + strconv.Itoa(file.MaxDownloads)
+		}
+		progress := int64(100)
+		if !file.Complete() {
+			progress = file.Offset * 100 / file.Size
+		}
+		rows.WriteString(fmt.Sprintf(handleFileStoreRow, name, file.Size, strconv.FormatInt(progress, 10)+"%",
+			file.ExpiresAt.Format(time.RFC3339), downloads, ownLocation, file.ID))
+	}
+	w.Header().Set("Content-Type", "text/html")
+	_, _ = w.Write([]byte(fmt.Sprintf(HandleFileStorePage, ownLocation, html.EscapeString(message), rows.String())))
+}
+
+func (_ *HandleFileStore) GetRateLimitFactor() int {
+	// Resumable upload clients send several requests per file
+	return 3
+}
+
+func (fs *HandleFileStore) SelfTest() error {
+	if _, err := fs.store.List(); err != nil {
+		return fmt.Errorf("HandleFileStore.SelfTest: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+func TestHandleFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-handle-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := HandleFileStore{Dir: dir}
+	if err := fs.Initialise(lalog.Logger{}, nil, ""); err == nil {
+		t.Fatal("should have required a password")
+	}
+	fs.Password = "pass"
+	fs.EncryptionPassword = "enc"
+	if err := fs.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, target string, body []byte, header map[string]string, owner bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		// Clients are told apart by the X-Real-Ip header, which is trusted from a local reverse proxy
+		req.RemoteAddr = "127.0.0.1:12345"
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		if owner {
+			req.SetBasicAuth("", "pass")
+		}
+		rec := httptest.NewRecorder()
+		fs.Handle(rec, req)
+		return rec
+	}
+
+	// Visitors must authenticate
+	if rec := serve(http.MethodGet, "/files", nil, nil, false); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal(rec.Code)
+	}
+	// tus discovery, creation, and chunked upload
+	if rec := serve(http.MethodOptions, "/files", nil, nil, true); rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Tus-Extension"), "creation") {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	tusHeader := map[string]string{
+		"Tus-Resumable":   HandleFileStoreTusVersion,
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("<b>hello.txt")) + ",max_downloads " + base64.StdEncoding.EncodeToString([]byte("2")),
+	}
+	rec := serve(http.MethodPost, "/files", nil, tusHeader, true)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(location, "/files?id=") {
+		t.Fatal(rec.Code, location)
+	}
+	patchHeader := map[string]string{"Tus-Resumable": HandleFileStoreTusVersion, "Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if rec := serve(http.MethodPatch, location, []byte("hello"), patchHeader, true); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	if rec := serve(http.MethodPatch, location, []byte("hello"), patchHeader, true); rec.Code != http.StatusConflict {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodHead, location, nil, map[string]string{"Tus-Resumable": HandleFileStoreTusVersion}, true); rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	patchHeader["Upload-Offset"] = "5"
+	if rec := serve(http.MethodPatch, location, []byte(" world"), patchHeader, true); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "11" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	// The owner downloads the file any number of times
+	for i := 0; i < 2; i++ {
+		if rec := serve(http.MethodGet, location, nil, nil, true); rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+	}
+
+	// Upload another file via the form
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	_ = writer.WriteField("action", "Upload")
+	part, _ := writer.CreateFormFile("upload", "form.txt")
+	_, _ = part.Write([]byte("form content"))
+	_ = writer.Close()
+	rec = serve(http.MethodPost, "/files", form.Bytes(), map[string]string{"Content-Type": writer.FormDataContentType()}, true)
+	if page := rec.Body.String(); !strings.Contains(page, "Uploaded form.txt") || !strings.Contains(page, "&lt;b&gt;hello.txt") || strings.Contains(page, "<b>") {
+		t.Fatal(page)
+	}
+
+	// Share the first file, the link works without a password until reaching the download limit
+	id := strings.TrimPrefix(location, "/files?id=")
+	rec = serve(http.MethodPost, "/files", []byte("action=Share&share_hours=1&id="+id), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, true)
+	link := regexp.MustCompile(`Share link: ([^\s<]+)`).FindStringSubmatch(rec.Body.String())
+	if len(link) != 2 {
+		t.Fatal(rec.Body.String())
+	}
+	shareLink := strings.Replace(link[1], "&amp;", "&", -1)
+	if rec := serve(http.MethodGet, shareLink+"0", nil, nil, false); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+	// Each client uses up one download regardless of the requested range, and may download again via the same link.
+	// Encrypted content is sent in entirety regardless of the requested range.
+	clientA := map[string]string{"X-Real-Ip": "192.0.2.1", "Range": "bytes=1-"}
+	for i := 0; i < 3; i++ {
+		if rec := serve(http.MethodGet, shareLink, nil, clientA, false); rec.Code != http.StatusOK || rec.Body.String() != "hello world" ||
+			rec.Header().Get("Content-Disposition") != `attachment; filename="<b>hello.txt"` {
+			t.Fatal(rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+	if info, err := fs.store.Get(id); err != nil || info.Downloads != 1 {
+		t.Fatal(info, err)
+	}
+	if rec := serve(http.MethodGet, shareLink, nil, map[string]string{"X-Real-Ip": "192.0.2.2"}, false); rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec := serve(http.MethodGet, shareLink, nil, map[string]string{"X-Real-Ip": "192.0.2.3"}, false); rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodGet, shareLink, nil, clientA, false); rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+
+	// The file that reached its download limit is gone, delete the other one via tus termination.
+	files, err := fs.store.List()
+	if err != nil || len(files) != 1 || files[0].Name != "form.txt" {
+		t.Fatal(files, err)
+	}
+	if rec := serve(http.MethodDelete, "/files?id="+files[0].ID, nil, map[string]string{"Tus-Resumable": HandleFileStoreTusVersion}, true); rec.Code != http.StatusNoContent {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodGet, location, nil, nil, true); rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+	if files, err := fs.store.List(); err != nil || len(files) != 0 {
+		t.Fatal(files, err)
+	}
+}
@@ -1,13 +1,23 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html/template"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
 
@@ -17,34 +27,159 @@ const (
 
 	// HTMLClientAddress it the string anchor to be replaced by HTTP client IP address in rendered HTML output.
 	HTMLClientAddress = "#LAITOS_CLIENTADDR"
+
+	// HTMLReloadIntervalSec is the minimum interval between two checks for modification of the HTML file.
+	HTMLReloadIntervalSec = 3
+
+	// HTMLRSSRefreshIntervalSec is the interval at which RSS headlines presented to HTML template are refreshed.
+	HTMLRSSRefreshIntervalSec = 10 * 60
+
+	// HTMLMaxSubjects is the maximum number of message processor subjects presented to HTML template.
+	HTMLMaxSubjects = 100
 )
 
-// HandleHTMLDocument renders an HTML page with client IP and current system time injected inside.
+/*
+HandleHTMLDocument renders an HTML page with client IP and current system time injected inside. Optionally, the page is
+a Go html/template that has access to read-only data such as server uptime and latest recurring command results. The
+page is reloaded automatically when its file changes.
+*/
 type HandleHTMLDocument struct {
 	HTMLFilePath string `json:"HTMLFilePath"`
+	// Template enables rendering of the HTML file as Go html/template. See HTMLDocumentData for available data.
+	Template bool `json:"Template"`
+	// RecurringCommands are the recurring command channels whose latest results are available to the template.
+	RecurringCommands map[string]*common.RecurringCommands `json:"-"`
+
+	contentString   string             // contentString is the HTML document file's content in string
+	tmpl            *template.Template // tmpl is the HTML document parsed as template, it is nil if template is not enabled.
+	fileModTime     time.Time          // fileModTime is the modification time of the HTML file when it was last loaded.
+	fileSize        int64              // fileSize is the size of the HTML file when it was last loaded.
+	lastReloadCheck time.Time          // lastReloadCheck is the time the HTML file was last checked for modification.
+	rssItems        []toolbox.RSSItem  // rssItems are the latest RSS headlines presented to template.
+	rssRetrievedAt  time.Time          // rssRetrievedAt is the time RSS headlines were last retrieved.
+	rssRefreshing   bool               // rssRefreshing is true while RSS headlines are being retrieved in background.
+	mutex           *sync.Mutex
+	cmdProc         *toolbox.CommandProcessor
+	logger          lalog.Logger
+}
 
-	contentBytes  []byte // contentBytes is the HTML document file's content in bytes
-	contentString string // contentString is the HTML document file's content in string
+func (doc *HandleHTMLDocument) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, _ string) error {
+	doc.logger = logger
+	doc.cmdProc = cmdProc
+	if doc.mutex == nil {
+		doc.mutex = new(sync.Mutex)
+	}
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+	if err := doc.load(); err != nil {
+		return fmt.Errorf("HandleHTMLDocument.Initialise: %v", err)
+	}
+	return nil
 }
 
-func (doc *HandleHTMLDocument) Initialise(lalog.Logger, *toolbox.CommandProcessor, string) error {
-	var err error
-	if doc.contentBytes, err = ioutil.ReadFile(doc.HTMLFilePath); err != nil {
-		return fmt.Errorf("HandleHTMLDocument.Initialise: failed to open HTML file at %s - %v", doc.HTMLFilePath, err)
+// load reads the HTML file and parses it as template if template is enabled. The caller must hold the mutex.
+func (doc *HandleHTMLDocument) load() error {
+	info, err := os.Stat(doc.HTMLFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open HTML file at %s - %v", doc.HTMLFilePath, err)
+	}
+	content, err := ioutil.ReadFile(doc.HTMLFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open HTML file at %s - %v", doc.HTMLFilePath, err)
+	}
+	var tmpl *template.Template
+	if doc.Template {
+		if tmpl, err = template.New(filepath.Base(doc.HTMLFilePath)).Parse(string(content)); err != nil {
+			return fmt.Errorf("failed to parse HTML template at %s - %v", doc.HTMLFilePath, err)
+		}
 	}
-	doc.contentString = string(doc.contentBytes)
+	doc.contentString = string(content)
+	doc.tmpl = tmpl
+	doc.fileModTime = info.ModTime()
+	doc.fileSize = info.Size()
+	doc.lastReloadCheck = time.Now()
 	return nil
 }
 
+/*
+reloadIfModified loads the HTML file again if it has changed since it was last loaded. Should the new content fail to
+load, the previous content will continue to be served.
+*/
+func (doc *HandleHTMLDocument) reloadIfModified() {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+	if time.Since(doc.lastReloadCheck) < HTMLReloadIntervalSec*time.Second {
+		return
+	}
+	doc.lastReloadCheck = time.Now()
+	info, err := os.Stat(doc.HTMLFilePath)
+	if err != nil || info.ModTime().Equal(doc.fileModTime) && info.Size() == doc.fileSize {
+		return
+	}
+	if err := doc.load(); err == nil {
+		doc.logger.Info("HandleHTMLDocument", doc.HTMLFilePath, nil, "reloaded the modified HTML file")
+	} else {
+		doc.logger.Warning("HandleHTMLDocument", doc.HTMLFilePath, err, "continue to serve the previous content")
+	}
+}
+
 func (doc *HandleHTMLDocument) Handle(w http.ResponseWriter, r *http.Request) {
+	doc.reloadIfModified()
+	doc.mutex.Lock()
+	page := doc.contentString
+	tmpl := doc.tmpl
+	doc.mutex.Unlock()
+	clientIP := GetRealClientIP(r)
+	if tmpl != nil {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, &HTMLDocumentData{ClientAddress: clientIP, Now: time.Now(), doc: doc}); err != nil {
+			doc.logger.Warning("HandleHTMLDocument", clientIP, err, "failed to render HTML template")
+			http.Error(w, "failed to render the page", http.StatusInternalServerError)
+			return
+		}
+		page = out.String()
+	}
 	// Inject browser client IP and current time into index document and return.
 	w.Header().Set("Content-Type", "text/html")
 	NoCache(w)
-	page := strings.Replace(doc.contentString, HTMLCurrentDateTime, time.Now().Format(time.RFC3339), -1)
-	page = strings.Replace(page, HTMLClientAddress, GetRealClientIP(r), -1)
+	page = strings.Replace(page, HTMLCurrentDateTime, time.Now().Format(time.RFC3339), -1)
+	page = strings.Replace(page, HTMLClientAddress, clientIP, -1)
 	_, _ = w.Write([]byte(page))
 }
 
+/*
+getRSSHeadlines returns the RSS headlines retrieved most recently. If they are out of date, a background retrieval
+will refresh them for the upcoming visits.
+*/
+func (doc *HandleHTMLDocument) getRSSHeadlines() []toolbox.RSSItem {
+	doc.mutex.Lock()
+	defer doc.mutex.Unlock()
+	if doc.cmdProc == nil || doc.cmdProc.Features == nil {
+		return nil
+	}
+	if !doc.rssRefreshing && time.Since(doc.rssRetrievedAt) > HTMLRSSRefreshIntervalSec*time.Second {
+		doc.rssRefreshing = true
+		sources := doc.cmdProc.Features.RSS.Sources
+		if len(sources) == 0 {
+			sources = toolbox.DefaultRSSSources
+		}
+		go func() {
+			items, err := toolbox.DownloadRSSFeeds(context.Background(), toolbox.RSSDownloadTimeoutSec, sources...)
+			if err != nil {
+				doc.logger.Warning("HandleHTMLDocument", "", err, "failed to download some of the RSS feeds")
+			}
+			doc.mutex.Lock()
+			defer doc.mutex.Unlock()
+			if len(items) > 0 {
+				doc.rssItems = items
+			}
+			doc.rssRetrievedAt = time.Now()
+			doc.rssRefreshing = false
+		}()
+	}
+	return doc.rssItems
+}
+
 func (_ *HandleHTMLDocument) GetRateLimitFactor() int {
 	/*
 		Usually nobody visits the index page (or plain HTML document) this often, but on Elastic Beanstalk the nginx
@@ -58,3 +193,82 @@ func (_ *HandleHTMLDocument) GetRateLimitFactor() int {
 func (_ *HandleHTMLDocument) SelfTest() error {
 	return nil
 }
+
+// HTMLDocumentSubject summarises a subject that reported to the message processor.
+type HTMLDocumentSubject struct {
+	HostName   string    // HostName is the subject's host name.
+	Platform   string    // Platform is the subject's operating system and architecture.
+	LastReport time.Time // LastReport is the time the latest report arrived from the subject.
+}
+
+/*
+HTMLDocumentData is the data available to an HTML document template. Its functions are evaluated only when the template
+uses them, e.g. {{.PublicIP}}, {{.RecurringCommandResults "channel-id"}}, {{range .RSSHeadlines 5}}{{.Title}}{{end}}.
+*/
+type HTMLDocumentData struct {
+	ClientAddress string    // ClientAddress is the IP address of the visitor.
+	Now           time.Time // Now is the current system time.
+
+	doc *HandleHTMLDocument
+}
+
+// PublicIP returns the public IP address of laitos server.
+func (data *HTMLDocumentData) PublicIP() string {
+	return inet.GetPublicIP()
+}
+
+// Uptime returns the duration since laitos program started, rounded to seconds.
+func (data *HTMLDocumentData) Uptime() time.Duration {
+	return time.Since(misc.StartupTime).Truncate(time.Second)
+}
+
+// RecurringCommandResults returns the latest results of the recurring command channel, oldest to the latest.
+func (data *HTMLDocumentData) RecurringCommandResults(channel string) []string {
+	if cmds, exists := data.doc.RecurringCommands[channel]; exists {
+		return cmds.GetLatestResults()
+	}
+	return []string{}
+}
+
+// Subjects returns the subjects that recently reported to the message processor, sorted by host name.
+func (data *HTMLDocumentData) Subjects() []HTMLDocumentSubject {
+	ret := make([]HTMLDocumentSubject, 0)
+	cmdProc := data.doc.cmdProc
+	if cmdProc == nil || cmdProc.Features == nil {
+		return ret
+	}
+	// The message processor app is only usable after it has been initialised and installed
+	if _, initialised := cmdProc.Features.LookupByTrigger[toolbox.StoreAndForwardMessageProcessorTrigger]; !initialised {
+		return ret
+	}
+	seen := make(map[string]bool)
+	// The reports are sorted from latest to oldest, the first report of each subject is its latest.
+	for _, report := range cmdProc.Features.MessageProcessor.GetLatestReports(HTMLMaxSubjects) {
+		hostName := report.OriginalRequest.SubjectHostName
+		if seen[hostName] {
+			continue
+		}
+		seen[hostName] = true
+		ret = append(ret, HTMLDocumentSubject{
+			HostName:   hostName,
+			Platform:   report.OriginalRequest.SubjectPlatform,
+			LastReport: report.ServerTime,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].HostName < ret[j].HostName
+	})
+	return ret
+}
+
+/*
+RSSHeadlines returns up to the specified number of latest RSS headlines from the sources configured for RSS app. The
+headlines are retrieved in background and refreshed every 10 minutes, hence the very first visit sees none.
+*/
+func (data *HTMLDocumentData) RSSHeadlines(count int) []toolbox.RSSItem {
+	items := data.doc.getRSSHeadlines()
+	if count >= 0 && count < len(items) {
+		items = items[:count]
+	}
+	return items
+}
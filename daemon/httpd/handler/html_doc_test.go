@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+func TestHandleHTMLDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-handle-html-doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	htmlFile := filepath.Join(dir, "index.html")
+	serve := func(doc *HandleHTMLDocument) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		doc.Handle(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	// Plain HTML document is not a template
+	if err := ioutil.WriteFile(htmlFile, []byte("{{.Now}} #LAITOS_CLIENTADDR"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := &HandleHTMLDocument{HTMLFilePath: htmlFile}
+	if err := doc.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(doc); rec.Body.String() != "{{.Now}} 192.0.2.1" {
+		t.Fatal(rec.Body.String())
+	}
+	// Hot reload picks up the modified content
+	if err := ioutil.WriteFile(htmlFile, []byte("changed content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc.lastReloadCheck = time.Time{}
+	if rec := serve(doc); rec.Body.String() != "changed content" {
+		t.Fatal(rec.Body.String())
+	}
+
+	// Prepare the data presented to template
+	rssServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<rss><channel><item><title>first &lt;headline&gt;</title><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item><item><title>second headline</title><pubDate>Mon, 01 Jan 2006 15:04:05 GMT</pubDate></item></channel></rss>`))
+	}))
+	defer rssServer.Close()
+	features := &toolbox.FeatureSet{RSS: toolbox.RSS{Sources: []string{rssServer.URL}}, LookupByTrigger: map[toolbox.Trigger]toolbox.Feature{}}
+	if err := features.MessageProcessor.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	features.LookupByTrigger[toolbox.StoreAndForwardMessageProcessorTrigger] = &features.MessageProcessor
+	for _, hostName := range []string{"subject-b", "subject-a", "subject-b"} {
+		features.MessageProcessor.StoreReport(context.Background(), toolbox.SubjectReportRequest{SubjectHostName: hostName, SubjectPlatform: "linux/amd64"}, "192.0.2.2", "test")
+	}
+	recurringCmds := &common.RecurringCommands{IntervalSec: 1, MaxResults: 3}
+	if err := recurringCmds.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	recurringCmds.AddArbitraryTextToResult("<b>result 1</b>")
+	recurringCmds.AddArbitraryTextToResult("result 2")
+
+	// Render template
+	if err := ioutil.WriteFile(htmlFile, []byte(`{{.ClientAddress}} {{if gt .Uptime 0}}up{{end}}
+{{range .RecurringCommandResults "channel"}}[{{.}}]{{end}} {{len (.RecurringCommandResults "does-not-exist")}}
+{{range .Subjects}}{{.HostName}} {{.Platform}};{{end}}
+{{range .RSSHeadlines 1}}{{.Title}}{{end}} #LAITOS_CLIENTADDR`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc = &HandleHTMLDocument{HTMLFilePath: htmlFile, Template: true, RecurringCommands: map[string]*common.RecurringCommands{"channel": recurringCmds}}
+	if err := doc.Initialise(lalog.Logger{}, &toolbox.CommandProcessor{Features: features}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// The first visit starts retrieving RSS headlines in background
+	serve(doc)
+	time.Sleep(2 * time.Second)
+	expected := `192.0.2.1 up
+[&lt;b&gt;result 1&lt;/b&gt;][result 2] 0
+subject-a linux/amd64;subject-b linux/amd64;
+first &lt;headline&gt; 192.0.2.1`
+	if rec := serve(doc); rec.Code != http.StatusOK || rec.Body.String() != expected {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+
+	// A broken template does not replace the working version, and the recurring command results are not cleared by
+	// the previous visit.
+	if err := ioutil.WriteFile(htmlFile, []byte("{{.ClientAddress"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc.lastReloadCheck = time.Time{}
+	if rec := serve(doc); rec.Body.String() != expected {
+		t.Fatal(rec.Body.String())
+	}
+	if err := doc.Initialise(lalog.Logger{}, nil, ""); err == nil || !strings.Contains(err.Error(), "parse") {
+		t.Fatal(err)
+	}
+	// A template that fails to execute results in an error response
+	if err := ioutil.WriteFile(htmlFile, []byte("{{.DoesNotExist}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(doc); rec.Code != http.StatusInternalServerError {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
@@ -1,16 +1,24 @@
 package handler
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"html"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/toolbox"
+	"golang.org/x/net/publicsuffix"
 )
 
 const (
@@ -128,9 +136,33 @@ window.onload = laitos_replace_many;
 </script>
 `
 	ProxyTargetTimeoutSec = 120 // ProxyTimeoutSec is the IO timeout for downloading proxy's target URL.
+
+	ProxySessionCookieName     = "laitos-proxy-session" // ProxySessionCookieName is the name of cookie that carries visitor's session token.
+	ProxySessionIdleTimeoutSec = 3600                   // ProxySessionIdleTimeoutSec is the number of seconds after which an idle session and its cookies are discarded.
+	ProxyMaxSessions           = 1000                   // ProxyMaxSessions is the maximum number of sessions to keep, the least recently used session is discarded first.
+	ProxyMaxSessionsPerIP      = 10                     // ProxyMaxSessionsPerIP is the maximum number of sessions to keep for each client IP.
+)
+
+var (
+	// RegexProxyFormTag matches the start tag of form elements in an HTML document.
+	RegexProxyFormTag = regexp.MustCompile(`(?is)<form\b[^>]*>`)
+	// RegexProxyFormAction matches the action attribute of a form start tag.
+	RegexProxyFormAction = regexp.MustCompile(`(?is)\saction\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	// RegexProxyFormMethod matches the method attribute of a form start tag.
+	RegexProxyFormMethod = regexp.MustCompile(`(?is)\smethod\s*=\s*["']?\s*(\w+)`)
 )
 
-// HandleWebProxy is a pretty dumb client-side rendering web proxy, it does not support anonymity.
+// proxySession is a visitor's browsing session, it keeps cookies of the proxy targets on the server side.
+type proxySession struct {
+	jar      http.CookieJar
+	clientIP string
+	lastUsed time.Time
+}
+
+/*
+HandleWebProxy is a pretty dumb client-side rendering web proxy, it does not support anonymity. Each visitor gets a
+browsing session, which keeps the cookies of proxy targets on the server side.
+*/
 type HandleWebProxy struct {
 	/*
 		OwnEndpoint is the URL endpoint to visit the proxy itself. This is configured by user in HTTP server endpoint
@@ -138,11 +170,24 @@ type HandleWebProxy struct {
 	*/
 	OwnEndpoint string `json:"-"`
 
+	/*
+		LiteMode reduces the amount of data delivered to visitor for use on metered network links. It compresses
+		text responses, shrinks images, and strips scripts, fonts, and video.
+	*/
+	LiteMode bool `json:"LiteMode"`
+	// LiteModeMaxImageDimension is the maximum width and height of images in lite mode.
+	LiteModeMaxImageDimension int `json:"LiteModeMaxImageDimension"`
+	// LiteModeImageQuality is the JPEG quality (1-100) of images in lite mode.
+	LiteModeImageQuality int `json:"LiteModeImageQuality"`
+
 	stripURLPrefixFromResponse string
+	sessions                   map[string]*proxySession // sessions are visitors' browsing sessions keyed by session token.
+	sessionsMutex              *sync.Mutex
+	lastSessionCleanup         time.Time
 	logger                     lalog.Logger
 }
 
-var ProxyRemoveRequestHeaders = []string{"Host", "Content-Length", "Accept-Encoding", "Content-Security-Policy", "Set-Cookie"}
+var ProxyRemoveRequestHeaders = []string{"Host", "Content-Length", "Accept-Encoding", "Content-Security-Policy", "Set-Cookie", "Cookie"}
 var ProxyRemoveResponseHeaders = []string{"Host", "Content-Length", "Transfer-Encoding", "Content-Security-Policy", "Set-Cookie"}
 
 func (xy *HandleWebProxy) Initialise(logger lalog.Logger, _ *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
@@ -151,9 +196,121 @@ func (xy *HandleWebProxy) Initialise(logger lalog.Logger, _ *toolbox.CommandProc
 		return errors.New("HandleWebProxy.Initialise: MyEndpoint must not be empty")
 	}
 	xy.stripURLPrefixFromResponse = stripURLPrefixFromResponse
+	if xy.LiteModeMaxImageDimension < 1 {
+		xy.LiteModeMaxImageDimension = ProxyLiteDefaultMaxImageDimension
+	}
+	if xy.LiteModeImageQuality < 1 || xy.LiteModeImageQuality > 100 {
+		xy.LiteModeImageQuality = ProxyLiteDefaultImageQuality
+	}
+	xy.sessions = make(map[string]*proxySession)
+	xy.sessionsMutex = new(sync.Mutex)
 	return nil
 }
 
+/*
+getSession returns the browsing session identified by the session cookie of the request. If the visitor does not yet
+have a session or the session has expired, a new session will be created and isNew will be true. The function also
+discards sessions that have been idle for too long.
+*/
+func (xy *HandleWebProxy) getSession(r *http.Request) (token string, session *proxySession, isNew bool) {
+	xy.sessionsMutex.Lock()
+	defer xy.sessionsMutex.Unlock()
+	now := time.Now()
+	idleTimeout := ProxySessionIdleTimeoutSec * time.Second
+	if now.Sub(xy.lastSessionCleanup) > idleTimeout/60 {
+		for token, session := range xy.sessions {
+			if now.Sub(session.lastUsed) > idleTimeout {
+				delete(xy.sessions, token)
+			}
+		}
+		xy.lastSessionCleanup = now
+	}
+	if cookie, err := r.Cookie(ProxySessionCookieName); err == nil {
+		if session, exists := xy.sessions[cookie.Value]; exists && now.Sub(session.lastUsed) <= idleTimeout {
+			session.lastUsed = now
+			return cookie.Value, session, false
+		}
+	}
+	/*
+		Make room for the new session by discarding the least recently used one. A client that does not present its
+		session cookie only ever discards its own sessions, unless the sessions of all clients together reach the limit.
+	*/
+	clientIP := GetRealClientIP(r)
+	var oldestToken, oldestTokenOfIP string
+	var oldestTime, oldestTimeOfIP time.Time
+	var sessionsOfIP int
+	for token, session := range xy.sessions {
+		if oldestToken == "" || session.lastUsed.Before(oldestTime) {
+			oldestToken = token
+			oldestTime = session.lastUsed
+		}
+		if session.clientIP == clientIP {
+			sessionsOfIP++
+			if oldestTokenOfIP == "" || session.lastUsed.Before(oldestTimeOfIP) {
+				oldestTokenOfIP = token
+				oldestTimeOfIP = session.lastUsed
+			}
+		}
+	}
+	if sessionsOfIP >= ProxyMaxSessionsPerIP {
+		delete(xy.sessions, oldestTokenOfIP)
+	} else if len(xy.sessions) >= ProxyMaxSessions {
+		delete(xy.sessions, oldestToken)
+	}
+	randBytes := make([]byte, 16)
+	if _, err := rand.Read(randBytes); err != nil {
+		panic(fmt.Errorf("HandleWebProxy.getSession: failed to read random bytes - %v", err))
+	}
+	token = hex.EncodeToString(randBytes)
+	// The public suffix list prevents a domain from setting cookie for all sites under a suffix such as ".co.uk"
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		panic(fmt.Errorf("HandleWebProxy.getSession: failed to create cookie jar - %v", err))
+	}
+	session = &proxySession{jar: jar, clientIP: clientIP, lastUsed: now}
+	xy.sessions[token] = session
+	return token, session, true
+}
+
+/*
+rewriteForms changes the destination of forms in the HTML document to the proxy. A form that uses GET method loses
+the query string of its action URL upon submission, therefore the target URL is carried by an additional hidden input.
+*/
+func rewriteForms(strBody string, pageURL *url.URL, proxyHandlePath string) string {
+	return RegexProxyFormTag.ReplaceAllStringFunc(strBody, func(formTag string) string {
+		action := ""
+		actionMatch := RegexProxyFormAction.FindStringSubmatchIndex(formTag)
+		if actionMatch != nil {
+			action = html.UnescapeString(strings.Trim(formTag[actionMatch[2]:actionMatch[3]], `"'`))
+		}
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(action)), "javascript") {
+			return formTag
+		}
+		targetURL, err := pageURL.Parse(strings.TrimSpace(action))
+		if err != nil {
+			return formTag
+		}
+		targetURL.Fragment = ""
+		isGet := true
+		if methodMatch := RegexProxyFormMethod.FindStringSubmatch(formTag); methodMatch != nil && !strings.EqualFold(methodMatch[1], "get") {
+			isGet = false
+		}
+		if isGet {
+			targetURL.RawQuery = ""
+		}
+		newAction := fmt.Sprintf(` action="%s"`, html.EscapeString(proxyHandlePath+"?u="+url.QueryEscape(targetURL.String())))
+		if actionMatch == nil {
+			formTag = formTag[:5] + newAction + formTag[5:]
+		} else {
+			formTag = formTag[:actionMatch[0]] + newAction + formTag[actionMatch[1]:]
+		}
+		if isGet {
+			formTag += fmt.Sprintf(`<input type="hidden" name="u" value="%s">`, html.EscapeString(targetURL.String()))
+		}
+		return formTag
+	})
+}
+
 func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 	// Figure out where proxy endpoint is located
 	proxySchemeHost := r.Host
@@ -163,8 +320,12 @@ func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 		proxySchemeHost = "https://" + proxySchemeHost
 	}
 	proxyHandlePath := proxySchemeHost + strings.TrimPrefix(xy.OwnEndpoint, xy.stripURLPrefixFromResponse)
-	// Figure out where user wants to go
-	browseURL := r.FormValue("u")
+	/*
+		Figure out where user wants to go. The parameter comes from query string alone, so that the request body of a
+		form submission stays intact for the proxy target.
+	*/
+	query := r.URL.Query()
+	browseURL := query.Get("u")
 	if browseURL == "" {
 		http.Error(w, "URL is empty", http.StatusInternalServerError)
 		return
@@ -180,6 +341,16 @@ func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to parse proxy URL", http.StatusInternalServerError)
 		return
 	}
+	// A form submitted via GET method carries its input in the query string along with the target URL
+	if r.Method == http.MethodGet && len(query) > 1 {
+		targetQuery := urlParts.Query()
+		for name, values := range query {
+			if name != "u" {
+				targetQuery[name] = values
+			}
+		}
+		urlParts.RawQuery = targetQuery.Encode()
+	}
 
 	browseSchemeHost := fmt.Sprintf("%s://%s", urlParts.Scheme, urlParts.Host)
 	browseSchemeHostPath := fmt.Sprintf("%s://%s%s", urlParts.Scheme, urlParts.Host, urlParts.Path)
@@ -194,13 +365,35 @@ func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to create request to URL", http.StatusInternalServerError)
 		return
 	}
+	// Visitor's cookies are kept on the server side, the session cookie must be read before request headers are removed.
+	sessionToken, session, isNewSession := xy.getSession(r)
+	visitorAcceptsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
 	// Remove request headers that are not necessary
 	myReq.Header = r.Header
 	for _, name := range ProxyRemoveRequestHeaders {
 		myReq.Header.Del(name)
 	}
-	// Retrieve resource from remote
-	client := http.Client{Timeout: ProxyTargetTimeoutSec * time.Second}
+	// Web sites may check referer and origin to prevent cross site request forgery
+	if referer := myReq.Header.Get("Referer"); referer != "" {
+		myReq.Header.Del("Referer")
+		if refererURL, err := url.Parse(referer); err == nil && refererURL.Query().Get("u") != "" {
+			myReq.Header.Set("Referer", refererURL.Query().Get("u"))
+		}
+	}
+	if myReq.Header.Get("Origin") != "" {
+		myReq.Header.Set("Origin", browseSchemeHost)
+	}
+	/*
+		Retrieve resource from remote. Redirects are not followed, instead the visitor's browser follows the rewritten
+		location and the proxy will visit the destination with the up-to-date cookies.
+	*/
+	client := http.Client{
+		Timeout: ProxyTargetTimeoutSec * time.Second,
+		Jar:     session.jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 	remoteResp, err := client.Do(myReq)
 	if err != nil {
 		xy.logger.Warning("HandleWebProxy", browseSchemeHostPathQuery, err, "failed to send request")
@@ -232,11 +425,37 @@ func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Authorization")
 	NoCache(w)
-	// Rewrite HTML response to insert javascript
-	w.WriteHeader(remoteResp.StatusCode)
-	if strings.HasPrefix(remoteResp.Header.Get("Content-Type"), "text/html") {
+	// Redirect visitor to the proxy rather than the proxy target
+	if location := remoteResp.Header.Get("Location"); location != "" {
+		if locationURL, err := urlParts.Parse(location); err == nil {
+			w.Header().Set("Location", proxyHandlePath+"?u="+url.QueryEscape(locationURL.String()))
+		}
+	}
+	if isNewSession {
+		http.SetCookie(w, &http.Cookie{
+			Name:     ProxySessionCookieName,
+			Value:    sessionToken,
+			Path:     strings.TrimPrefix(xy.OwnEndpoint, xy.stripURLPrefixFromResponse),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	respBody := remoteRespBody
+	statusCode := remoteResp.StatusCode
+	contentType := remoteResp.Header.Get("Content-Type")
+	if xy.LiteMode && isProxyLiteStripped(contentType) {
+		// Scripts, fonts, and video are not delivered in lite mode
+		respBody = []byte{}
+		statusCode = http.StatusNoContent
+		w.Header().Del("Content-Type")
+	} else if strings.HasPrefix(contentType, "text/html") {
+		// Rewrite HTML response to insert javascript
 		injectedJS := fmt.Sprintf(ProxyInjectJS, proxySchemeHost, proxyHandlePath, browseSchemeHost, browseSchemeHostPath)
-		strBody := string(remoteRespBody)
+		strBody := rewriteForms(string(remoteRespBody), urlParts, proxyHandlePath)
+		if xy.LiteMode {
+			strBody = stripProxyLiteHTML(strBody)
+		}
 		headIndex := strings.Index(strBody, "<head>")
 		if headIndex == -1 {
 			bodyIndex := strings.Index(strBody, "<body")
@@ -248,11 +467,28 @@ func (xy *HandleWebProxy) Handle(w http.ResponseWriter, r *http.Request) {
 		} else {
 			strBody = strBody[0:headIndex+6] + injectedJS + strBody[headIndex+6:]
 		}
-		_, _ = w.Write([]byte(strBody))
+		respBody = []byte(strBody)
 		xy.logger.Info("HandleWebProxy", browseSchemeHostPathQuery, nil, "served modified HTML")
-	} else {
-		_, _ = w.Write(remoteRespBody)
+	} else if xy.LiteMode && statusCode == http.StatusOK && strings.HasPrefix(contentType, "image/") {
+		if shrunk, ok := shrinkProxyLiteImage(respBody, xy.LiteModeMaxImageDimension, xy.LiteModeImageQuality); ok {
+			respBody = shrunk
+			w.Header().Set("Content-Type", "image/jpeg")
+		}
+	}
+	if xy.LiteMode {
+		if visitorAcceptsGzip && remoteResp.Header.Get("Content-Encoding") == "" &&
+			len(respBody) >= ProxyLiteMinCompressionSize && isProxyLiteCompressible(w.Header().Get("Content-Type")) {
+			respBody = gzipProxyLiteBody(respBody)
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		// Let visitor know how much data lite mode saved
+		w.Header().Set("X-Laitos-Lite-Bytes-Saved", strconv.Itoa(len(remoteRespBody)-len(respBody)))
+		xy.logger.Info("HandleWebProxy", browseSchemeHostPathQuery, nil, "lite mode delivered %d bytes instead of %d bytes (saved %d bytes)",
+			len(respBody), len(remoteRespBody), len(remoteRespBody)-len(respBody))
 	}
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(respBody)
 }
 
 func (xy *HandleWebProxy) GetRateLimitFactor() int {
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register GIF decoder for shrinking images
+	"image/jpeg"
+	_ "image/png" // Register PNG decoder for shrinking images
+	"regexp"
+	"strings"
+)
+
+const (
+	ProxyLiteDefaultMaxImageDimension = 640 // ProxyLiteDefaultMaxImageDimension is the default maximum width and height of images in lite mode.
+	ProxyLiteDefaultImageQuality      = 40  // ProxyLiteDefaultImageQuality is the default JPEG quality of images in lite mode.
+	ProxyLiteMinCompressionSize       = 512 // ProxyLiteMinCompressionSize is the minimum size of response body worth compressing.
+	// ProxyLiteMaxImagePixels is the maximum number of pixels (width * height) of an image decoded for shrinking.
+	// A small image file may declare an enormous resolution, decoding it would exhaust the memory.
+	ProxyLiteMaxImagePixels = 16 * 1024 * 1024
+)
+
+var (
+	// RegexProxyLiteStripElements matches the HTML elements removed by lite mode, along with their content.
+	RegexProxyLiteStripElements = regexp.MustCompile(`(?is)<script\b[^>]*>.*?</script\s*>|<video\b[^>]*>.*?</video\s*>|<audio\b[^>]*>.*?</audio\s*>`)
+	// RegexProxyLiteStripTags matches the stand-alone HTML tags removed by lite mode, such as those of font preload.
+	RegexProxyLiteStripTags = regexp.MustCompile(`(?is)<script\b[^>]*/>|<(?:video|audio|source|track)\b[^>]*>|<link\b[^>]*\bas\s*=\s*["']?font[^>]*>|</?noscript\b[^>]*>`)
+)
+
+/*
+isProxyLiteStripped returns true only if the content type is a script, font, or video (audio), which are not delivered
+to visitor in lite mode.
+*/
+func isProxyLiteStripped(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range []string{"font/", "video/", "audio/", "application/font", "application/x-font", "application/vnd.ms-fontobject"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript")
+}
+
+// isProxyLiteCompressible returns true only if the content type is text that usually compresses well.
+func isProxyLiteCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	for _, keyword := range []string{"json", "xml", "svg", "javascript"} {
+		if strings.Contains(contentType, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+stripProxyLiteHTML removes scripts, video, audio, and font preload from the HTML document. The content of noscript
+elements is revealed since the scripts are gone.
+*/
+func stripProxyLiteHTML(strBody string) string {
+	return RegexProxyLiteStripTags.ReplaceAllString(RegexProxyLiteStripElements.ReplaceAllString(strBody, ""), "")
+}
+
+/*
+shrinkProxyLiteImage decodes a JPEG, PNG, or GIF image, scales it down to fit into the maximum dimension, and encodes
+it in JPEG of the quality. Transparent areas turn white. If the image cannot be decoded or the result is not smaller
+than the original, or the image has more pixels than ProxyLiteMaxImagePixels, the function returns false.
+*/
+func shrinkProxyLiteImage(original []byte, maxDimension, quality int) ([]byte, bool) {
+	// Check the declared resolution before decoding the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err != nil || config.Width < 1 || config.Height < 1 || config.Width > ProxyLiteMaxImagePixels/config.Height {
+		return nil, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, false
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, false
+	}
+	if width > maxDimension || height > maxDimension {
+		if width > height {
+			height = height * maxDimension / width
+			width = maxDimension
+		} else {
+			width = width * maxDimension / height
+			height = maxDimension
+		}
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+	// Average the source pixels covered by each destination pixel
+	shrunk := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(shrunk, shrunk.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < height; y++ {
+		srcY0, srcY1 := bounds.Min.Y+y*bounds.Dy()/height, bounds.Min.Y+(y+1)*bounds.Dy()/height
+		if srcY1 == srcY0 {
+			srcY1++
+		}
+		for x := 0; x < width; x++ {
+			srcX0, srcX1 := bounds.Min.X+x*bounds.Dx()/width, bounds.Min.X+(x+1)*bounds.Dx()/width
+			if srcX1 == srcX0 {
+				srcX1++
+			}
+			var r, g, b, a, count uint64
+			for srcY := srcY0; srcY < srcY1; srcY++ {
+				for srcX := srcX0; srcX < srcX1; srcX++ {
+					pr, pg, pb, pa := img.At(srcX, srcY).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			// Colours are alpha-premultiplied, blend them over the white background.
+			white := 0xffff*count - a
+			shrunk.Set(x, y, color.RGBA64{
+				R: uint16((r + white) / count),
+				G: uint16((g + white) / count),
+				B: uint16((b + white) / count),
+				A: 0xffff,
+			})
+		}
+	}
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, shrunk, &jpeg.Options{Quality: quality}); err != nil || out.Len() >= len(original) {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+/*
+gzipProxyLiteBody compresses the response body using gzip at the best compression level. Lite mode does not offer brotli,
+for Go standard library does not come with a brotli encoder and laitos avoids the extra dependency; besides, all web
+browsers accept gzip, and brotli would save merely a further 15-20% of the already compressed text.
+*/
+func gzipProxyLiteBody(body []byte) []byte {
+	var out bytes.Buffer
+	gzipWriter, _ := gzip.NewWriterLevel(&out, gzip.BestCompression)
+	_, _ = gzipWriter.Write(body)
+	_ = gzipWriter.Close()
+	return out.Bytes()
+}
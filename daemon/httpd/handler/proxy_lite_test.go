@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+// getTestPNG returns a PNG image of the size, the left half is red and the right half is transparent.
+func getTestPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			// A little noise makes the image harder to compress
+			img.Set(x, y, color.NRGBA{R: 255, G: uint8((x * y) % 7), B: uint8((x + y) % 5), A: 255})
+		}
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestProxyLiteContentTypes(t *testing.T) {
+	for _, contentType := range []string{"application/javascript", "text/javascript; charset=utf-8", "font/woff2", "application/font-woff", "video/mp4", "audio/ogg"} {
+		if !isProxyLiteStripped(contentType) {
+			t.Fatal(contentType)
+		}
+	}
+	for _, contentType := range []string{"text/html", "text/css", "image/png", "application/json", ""} {
+		if isProxyLiteStripped(contentType) {
+			t.Fatal(contentType)
+		}
+	}
+	for _, contentType := range []string{"text/html", "text/css", "application/json", "image/svg+xml", "application/xml"} {
+		if !isProxyLiteCompressible(contentType) {
+			t.Fatal(contentType)
+		}
+	}
+	for _, contentType := range []string{"image/png", "application/octet-stream", ""} {
+		if isProxyLiteCompressible(contentType) {
+			t.Fatal(contentType)
+		}
+	}
+}
+
+func TestStripProxyLiteHTML(t *testing.T) {
+	in := `<head><script src="a.js"></script><SCRIPT type="text/javascript">
+var a = "<b>";
+</SCRIPT><link rel="preload" href="f.woff2" as="font"><link rel="stylesheet" href="a.css"></head>
+<body><noscript><p>enable js</p></noscript><video controls><source src="a.mp4"></video><audio src="a.ogg"/><p>text</p></body>`
+	expected := `<head><link rel="stylesheet" href="a.css"></head>
+<body><p>enable js</p><p>text</p></body>`
+	if out := stripProxyLiteHTML(in); out != expected {
+		t.Fatal(out)
+	}
+}
+
+func TestShrinkProxyLiteImage(t *testing.T) {
+	if _, ok := shrinkProxyLiteImage([]byte("not an image"), 100, 50); ok {
+		t.Fatal("should not have succeeded")
+	}
+	shrunk, ok := shrinkProxyLiteImage(getTestPNG(t, 800, 400), 100, 50)
+	if !ok {
+		t.Fatal("did not shrink")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(shrunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 50 {
+		t.Fatal(img.Bounds())
+	}
+	// The left half remains red and the transparent right half becomes white
+	if r, g, b, _ := img.At(20, 25).RGBA(); r < 0xe000 || g > 0x2000 || b > 0x2000 {
+		t.Fatal(r, g, b)
+	}
+	if r, g, b, _ := img.At(80, 25).RGBA(); r < 0xe000 || g < 0xe000 || b < 0xe000 {
+		t.Fatal(r, g, b)
+	}
+	// A tiny image does not become smaller
+	if _, ok := shrinkProxyLiteImage(getTestPNG(t, 2, 2), 100, 50); ok {
+		t.Fatal("should not have succeeded")
+	}
+	// A small file that declares an enormous resolution is not decoded
+	bomb := getTestPNG(t, 2, 2)
+	binary.BigEndian.PutUint32(bomb[16:20], 100000)
+	binary.BigEndian.PutUint32(bomb[20:24], 100000)
+	binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+	if config, err := png.DecodeConfig(bytes.NewReader(bomb)); err != nil || config.Width != 100000 || config.Height != 100000 {
+		t.Fatal(config, err)
+	}
+	if _, ok := shrinkProxyLiteImage(bomb, 100, 50); ok {
+		t.Fatal("should not have succeeded")
+	}
+}
+
+func TestHandleWebProxy_LiteMode(t *testing.T) {
+	pngImage := getTestPNG(t, 1600, 1600)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><script>alert(1)</script></head><body>` + strings.Repeat("<p>lite mode</p>", 1000) + `</body></html>`))
+	})
+	mux.HandleFunc("/a.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		_, _ = w.Write([]byte("alert(1)"))
+	})
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(pngImage)
+	})
+	target := httptest.NewServer(mux)
+	defer target.Close()
+
+	proxy := &HandleWebProxy{OwnEndpoint: "/proxy", LiteMode: true}
+	if err := proxy.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if proxy.LiteModeMaxImageDimension != ProxyLiteDefaultMaxImageDimension || proxy.LiteModeImageQuality != ProxyLiteDefaultImageQuality {
+		t.Fatalf("%+v", proxy)
+	}
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxy.Handle))
+	defer proxyServer.Close()
+	get := func(u string) (*http.Response, []byte) {
+		// Ask for gzip explicitly so that the client does not decompress transparently
+		req, err := http.NewRequest(http.MethodGet, proxyServer.URL+"/proxy?u="+url.QueryEscape(target.URL+u), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+	// HTML is stripped of scripts and compressed
+	resp, body := get("/page")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatal(resp)
+	}
+	if saved, err := strconv.Atoi(resp.Header.Get("X-Laitos-Lite-Bytes-Saved")); err != nil || saved < 10000 || len(body) > 2000 {
+		t.Fatal(err, resp.Header, len(body))
+	}
+	// Scripts are not delivered
+	if resp, body := get("/a.js"); resp.StatusCode != http.StatusNoContent || len(body) != 0 || resp.Header.Get("X-Laitos-Lite-Bytes-Saved") != "8" {
+		t.Fatal(resp, string(body))
+	}
+	// Images are shrunk
+	resp, body = get("/a.png")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal(resp)
+	}
+	if img, err := jpeg.Decode(bytes.NewReader(body)); err != nil || img.Bounds().Dx() != ProxyLiteDefaultMaxImageDimension {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("X-Laitos-Lite-Bytes-Saved") != strconv.Itoa(len(pngImage)-len(body)) {
+		t.Fatal(resp.Header)
+	}
+	// Without lite mode the content is delivered as-is
+	proxy.LiteMode = false
+	if resp, body := get("/a.js"); resp.StatusCode != http.StatusOK || string(body) != "alert(1)" || resp.Header.Get("X-Laitos-Lite-Bytes-Saved") != "" {
+		t.Fatal(resp, string(body))
+	}
+}
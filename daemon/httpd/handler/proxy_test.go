@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+func TestRewriteForms(t *testing.T) {
+	pageURL, err := url.Parse("http://example.com/dir/page.html?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `<FORM class=x action='../search?x=1#frag'><input name="q"></FORM>
+<form method="post" action="login">
+<form Method=POST>
+<form action="javascript:void(0)">`
+	expected := `<FORM class=x action="/proxy?u=http%3A%2F%2Fexample.com%2Fsearch"><input type="hidden" name="u" value="http://example.com/search"><input name="q"></FORM>
+<form method="post" action="/proxy?u=http%3A%2F%2Fexample.com%2Fdir%2Flogin">
+<form action="/proxy?u=http%3A%2F%2Fexample.com%2Fdir%2Fpage.html%3Fa%3Db" Method=POST>
+<form action="javascript:void(0)">`
+	if rewritten := rewriteForms(body, pageURL, "/proxy"); rewritten != expected {
+		t.Fatal(rewritten)
+	}
+}
+
+func TestHandleWebProxy_Session(t *testing.T) {
+	// The proxy target requires visitor to log in before revealing the private page
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("password") != "pass" {
+			http.Error(w, "bad login", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "auth", Value: "ok", Path: "/"})
+		http.Redirect(w, r, "/private", http.StatusFound)
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("auth"); err != nil || cookie.Value != "ok" {
+			http.Error(w, "please log in", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body>private page<form action="search"><input name="q"></form></body></html>`))
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("auth"); err != nil || cookie.Value != "ok" {
+			http.Error(w, "please log in", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("searched " + r.URL.Query().Get("q")))
+	})
+	target := httptest.NewServer(mux)
+	defer target.Close()
+
+	proxy := &HandleWebProxy{OwnEndpoint: "/proxy"}
+	if err := proxy.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxy.Handle))
+	defer proxyServer.Close()
+	proxyURL := func(u string) string {
+		return proxyServer.URL + "/proxy?u=" + url.QueryEscape(u)
+	}
+
+	// The visitor's browser only ever sees the session cookie
+	visitorJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	visitor := http.Client{Jar: visitorJar, Timeout: 10 * time.Second}
+	if resp, err := visitor.Get(proxyURL(target.URL + "/private")); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatal(err, resp)
+	}
+	// Log in, the redirect to private page goes through proxy.
+	resp, err := visitor.PostForm(proxyURL(target.URL+"/login"), url.Values{"password": {"pass"}})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.Request.URL.Query().Get("u") != target.URL+"/private" || !strings.Contains(string(body), "private page") {
+		t.Fatal(err, resp.Request.URL, string(body))
+	}
+	// The form posts its query to the proxy
+	searchAction := fmt.Sprintf(`action="%s/proxy?u=%s"><input type="hidden" name="u" value="%s">`, proxyServer.URL, url.QueryEscape(target.URL+"/search"), target.URL+"/search")
+	if !strings.Contains(string(body), searchAction) {
+		t.Fatal(string(body))
+	}
+	resp, err = visitor.Get(proxyServer.URL + "/proxy?u=" + url.QueryEscape(target.URL+"/search") + "&q=hello")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, resp)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "searched hello" {
+		t.Fatal(err, string(body))
+	}
+	proxyServerURL, _ := url.Parse(proxyServer.URL + "/proxy")
+	cookies := visitorJar.Cookies(proxyServerURL)
+	if len(cookies) != 1 || cookies[0].Name != ProxySessionCookieName {
+		t.Fatal(cookies)
+	}
+	// Another visitor does not share the session
+	if resp, err := http.Get(proxyURL(target.URL + "/private")); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatal(err, resp)
+	}
+	if len(proxy.sessions) != 2 {
+		t.Fatal(proxy.sessions)
+	}
+	// Idle sessions are discarded
+	proxy.sessions[cookies[0].Value].lastUsed = time.Now().Add(-(ProxySessionIdleTimeoutSec + 1) * time.Second)
+	proxy.lastSessionCleanup = time.Time{}
+	if resp, err := visitor.Get(proxyURL(target.URL + "/private")); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatal(err, resp)
+	}
+	if _, exists := proxy.sessions[cookies[0].Value]; exists || len(proxy.sessions) != 2 {
+		t.Fatal(proxy.sessions)
+	}
+}
+
+func TestHandleWebProxy_SessionLimit(t *testing.T) {
+	proxy := &HandleWebProxy{OwnEndpoint: "/proxy"}
+	if err := proxy.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	// A visitor keeps a session
+	visitorReq := httptest.NewRequest(http.MethodGet, "/proxy", nil)
+	visitorReq.RemoteAddr = "192.0.2.1:1234"
+	visitorToken, _, isNew := proxy.getSession(visitorReq)
+	if !isNew {
+		t.Fatal("should have created a session")
+	}
+	// Cookie-less requests from another client only discard that client's own sessions
+	var lastToken string
+	for i := 0; i < ProxyMaxSessionsPerIP*2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/proxy", nil)
+		req.RemoteAddr = "192.0.2.2:1234"
+		lastToken, _, _ = proxy.getSession(req)
+	}
+	if len(proxy.sessions) != ProxyMaxSessionsPerIP+1 {
+		t.Fatal(len(proxy.sessions))
+	}
+	if _, exists := proxy.sessions[visitorToken]; !exists {
+		t.Fatal("visitor's session should have been kept")
+	}
+	if _, exists := proxy.sessions[lastToken]; !exists {
+		t.Fatal("the latest session should have been kept")
+	}
+	visitorReq.AddCookie(&http.Cookie{Name: ProxySessionCookieName, Value: visitorToken})
+	token, session, isNew := proxy.getSession(visitorReq)
+	if token != visitorToken || isNew {
+		t.Fatal(token, isNew)
+	}
+	// A site cannot set cookie for a public suffix
+	siteURL, _ := url.Parse("http://a.example.co.uk/")
+	otherSiteURL, _ := url.Parse("http://b.other.co.uk/")
+	session.jar.SetCookies(siteURL, []*http.Cookie{{Name: "suffix", Value: "x", Domain: "co.uk", Path: "/"}})
+	if cookies := session.jar.Cookies(otherSiteURL); len(cookies) != 0 {
+		t.Fatal(cookies)
+	}
+}
@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
@@ -26,6 +29,9 @@ const (
 	*/
 	DefaultLinuxDistributionURL = "http://distro.ibiblio.org/puppylinux/puppy-fossa/fossapup64-9.5.iso"
 
+	// HandleVirtualMachineDefaultProfileName is the name of the only virtual machine when there are no profiles in configuration.
+	HandleVirtualMachineDefaultProfileName = "default"
+
 	// HandleVirtualMachinePage is the web template of the virtual machine remote control.
 	HandleVirtualMachinePage = `<html>
 <head>
@@ -50,12 +56,22 @@ const (
 	</p>
 	<p>
 		Virtual machine:
+		<select name="vm">%s</select>
 		<input type="submit" name="action" value="Refresh Screen"/>
 		<input type="submit" name="action" value="Download OS"/>
 		ISO URL:<input type="text" name="iso_url" value="%s"/>
-		<input type="submit" name="action" value="Start"/>
+		<input type="submit" name="action" value="Start from CD"/>
+		<input type="submit" name="action" value="Start from disk"/>
 		<input type="submit" name="action" value="Kill"/>
 	</p>
+	<p>
+		Snapshot:
+		<input type="text" name="snapshot" value="%s" size="16"/>
+		<input type="submit" name="action" value="Save Snapshot"/>
+		<input type="submit" name="action" value="Load Snapshot"/>
+		<input type="submit" name="action" value="Delete Snapshot"/>
+		<input type="submit" name="action" value="List Snapshots"/>
+	</p>
 	<p>
 		Mouse:
 		<input type="submit" name="action" value="LHold"/>
@@ -79,7 +95,7 @@ const (
 		semicolon, apostrophe, comma, dot, slash, esc, backspace, tab, ret, spc<br/>
 		ctrl, shift, alt, up, down, left, right, home, end, pgup, pgdn, insert, delete<br/>
 	</p>
-	<p><img id="render" src="%s?rand=%d" alt="virtual machine screen" onclick="set_pointer_coord(event);"/></p>
+	<p><img id="render" src="%s?vm=%s&rand=%d" alt="virtual machine screen" onclick="set_pointer_coord(event);"/></p>
 </form>
 </body>
 </html>`
@@ -88,9 +104,14 @@ const (
 // HandleVirtualMachine is an HTTP handler that offers remote virtual machine controls, excluding the screenshot itself.
 type HandleVirtualMachine struct {
 	LocalUtilityPortNumber     int                             `json:"LocalUtilityPortNumber"`
+	VNCPortNumber              int                             `json:"VNCPortNumber"`
+	VNCPassword                string                          `json:"VNCPassword"`
+	VNCListenAddress           string                          `json:"VNCListenAddress"`
+	Profiles                   []remotevm.Profile              `json:"Profiles"`
+	DiskDir                    string                          `json:"DiskDir"`
 	ScreenshotEndpoint         string                          `json:"-"`
 	ScreenshotHandlerInstance  *HandleVirtualMachineScreenshot `json:"-"`
-	VM                         *remotevm.VM                    `json:"-"`
+	VMs                        *remotevm.Manager               `json:"-"`
 	stripURLPrefixFromResponse string
 	logger                     lalog.Logger
 }
@@ -98,6 +119,9 @@ type HandleVirtualMachine struct {
 // Initialise internal state of the HTTP handler.
 func (handler *HandleVirtualMachine) Initialise(logger lalog.Logger, _ *toolbox.CommandProcessor, stripURLPrefixFromResponse string) error {
 	handler.logger = logger
+	if handler.VNCPortNumber > 0 && handler.VNCPassword == "" {
+		return errors.New("HandleVirtualMachine.Initialise: VNCPassword must not be empty when VNCPortNumber is set")
+	}
 	// Calculate the number of CPUs and amount of memory to be granted to virtual machine
 	// Give the virtual machine half of the system CPUs
 	numCPUs := (runtime.NumCPU() + 1) / 2
@@ -108,144 +132,227 @@ func (handler *HandleVirtualMachine) Initialise(logger lalog.Logger, _ *toolbox.
 			memSizeMB = quarterOfMainMB
 		}
 	}
-
-	// Create virtual machine instance with adequate amount of RAM and CPU
-	handler.VM = &remotevm.VM{
-		NumCPU:    numCPUs,
-		MemSizeMB: memSizeMB,
-		// The TCP port for interacting with emulator comes from user configuration input
-		QMPPort: handler.LocalUtilityPortNumber,
+	profiles := make([]remotevm.Profile, len(handler.Profiles))
+	copy(profiles, handler.Profiles)
+	if len(profiles) == 0 {
+		// Without profiles, there is a single live virtual machine without disk.
+		profiles = []remotevm.Profile{{
+			Name: HandleVirtualMachineDefaultProfileName,
+			// The TCP port for interacting with emulator comes from user configuration input
+			QMPPort: handler.LocalUtilityPortNumber,
+			// The built-in VNC server offers a more responsive desktop than the web page
+			VNCPort: handler.VNCPortNumber,
+		}}
+	}
+	// Virtual machines that do not specify CPU and memory get an adequate amount of both
+	for i := range profiles {
+		if profiles[i].NumCPU < 1 {
+			profiles[i].NumCPU = numCPUs
+		}
+		if profiles[i].MemSizeMB < 1 {
+			profiles[i].MemSizeMB = memSizeMB
+		}
+	}
+	diskDir := handler.DiskDir
+	if diskDir == "" {
+		// Prefer to use user's home directory over temp directory so that disks won't be deleted when laitos restarts.
+		parentDir, _ := os.UserHomeDir()
+		if parentDir == "" {
+			parentDir = os.TempDir()
+		}
+		diskDir = path.Join(parentDir, ".laitos-remote-vm-disks")
+	}
+	handler.VMs = &remotevm.Manager{
+		Profiles:      profiles,
+		DiskDir:       diskDir,
+		VNCPassword:   handler.VNCPassword,
+		VNCListenAddr: handler.VNCListenAddress,
 	}
-	if err := handler.VM.Initialise(); err != nil {
-		return err
+	if err := handler.VMs.Initialise(); err != nil {
+		return fmt.Errorf("HandleVirtualMachine.Initialise: %v", err)
 	}
-	// Screenshots are taken from the same VM
-	handler.ScreenshotHandlerInstance.VM = handler.VM
+	// Screenshots are taken from the same VMs
+	handler.ScreenshotHandlerInstance.VMs = handler.VMs
 	handler.stripURLPrefixFromResponse = stripURLPrefixFromResponse
 	return nil
 }
 
+// getVMOptions returns the HTML options of virtual machine selection, along with their status.
+func (handler *HandleVirtualMachine) getVMOptions(selectedName string) string {
+	var options bytes.Buffer
+	for _, status := range handler.VMs.List() {
+		state := "stopped"
+		if status.Running {
+			state = "running"
+		}
+		if status.VNCPort > 0 {
+			state += fmt.Sprintf(", VNC port %d", status.VNCPort)
+		}
+		selected := ""
+		if status.Name == selectedName {
+			selected = ` selected="selected"`
+		}
+		options.WriteString(fmt.Sprintf(`<option value="%s"%s>%s (%s)</option>`, html.EscapeString(status.Name), selected, html.EscapeString(status.Name), state))
+	}
+	return options.String()
+}
+
 /*
 renderRemoteVMPage renders the HTML page that offers virtual machine control.
 Virtual machine screenshot sits in a <img> tag, though the image data is served by a differe, dedicated handler.
 */
-func (handler *HandleVirtualMachine) renderRemoteVMPage(requestURL string, err error, isoURL string, pointerX, pointerY int, pressKeys string) []byte {
+func (handler *HandleVirtualMachine) renderRemoteVMPage(requestURL string, err error, vm *remotevm.VM, vmName string, info string, isoURL, snapshot string, pointerX, pointerY int, pressKeys string) []byte {
 	var errStr string
 	if err != nil {
 		errStr = err.Error()
 	}
+	if info == "" {
+		info = vm.GetDebugOutput()
+	}
 	return []byte(fmt.Sprintf(HandleVirtualMachinePage,
-		requestURL, errStr, handler.VM.GetDebugOutput(),
+		requestURL, errStr, html.EscapeString(info),
+		handler.getVMOptions(vmName),
 		isoURL,
+		html.EscapeString(snapshot),
 		pointerX, pointerY,
 		pressKeys,
-		strings.TrimPrefix(handler.ScreenshotEndpoint, handler.stripURLPrefixFromResponse), time.Now().UnixNano()))
+		strings.TrimPrefix(handler.ScreenshotEndpoint, handler.stripURLPrefixFromResponse), url.QueryEscape(vmName), time.Now().UnixNano()))
 }
 
 // parseSubmission reads form action (button) and form text fields input.
-func (handler *HandleVirtualMachine) parseSubmission(r *http.Request) (button, isoURL string, pointerX, pointerY int, pressKeys string) {
+func (handler *HandleVirtualMachine) parseSubmission(r *http.Request) (button, isoURL, snapshot string, pointerX, pointerY int, pressKeys string) {
 	button = r.FormValue("action")
 	isoURL = r.FormValue("iso_url")
+	snapshot = r.FormValue("snapshot")
 	pointerX, _ = strconv.Atoi(r.FormValue("pointer_x"))
 	pointerY, _ = strconv.Atoi(r.FormValue("pointer_y"))
 	pressKeys = r.FormValue("press_keys")
 	return
 }
 
-// getISODownloadLocation returns the file system location where downloaded OS ISO file is kept,.
-func (handler *HandleVirtualMachine) getISODownloadLocation() string {
+/*
+getISODownloadLocation returns the file system location where the downloaded OS ISO file of the virtual machine is kept.
+Each virtual machine has its own ISO file, so that downloading an OS for one does not overwrite the ISO file of another.
+*/
+func (handler *HandleVirtualMachine) getISODownloadLocation(vmName string) string {
 	// Prefer to use user's home directory over temp directory so that it won't be deleted when laitos restarts.
 	parentDir, _ := os.UserHomeDir()
 	if parentDir == "" {
 		parentDir = os.TempDir()
 	}
-	return path.Join(parentDir, ".laitos-remote-vm-iso-download.iso")
+	// Profile names may only consist of letters, numbers, underscore, and dash.
+	return path.Join(parentDir, ".laitos-remote-vm-iso-download-"+vmName+".iso")
 }
 
 // Handle renders HTML page, reads user input from HTML form submission, and carries out corresponding VM control operations.
 func (handler *HandleVirtualMachine) Handle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	NoCache(w)
+	// Operate on the selected virtual machine, or the first one by default
+	vmName := r.FormValue("vm")
+	vm, err := handler.VMs.GetVM(vmName)
+	if err != nil {
+		vmName = handler.VMs.List()[0].Name
+		vm, _ = handler.VMs.GetVM(vmName)
+	}
 	if r.Method == http.MethodGet {
 		// Display the web page. Suggest user to download the default Linux distribution.
-		_, _ = w.Write(handler.renderRemoteVMPage(strings.TrimPrefix(r.RequestURI, handler.stripURLPrefixFromResponse), nil, DefaultLinuxDistributionURL, 0, 0, ""))
+		_, _ = w.Write(handler.renderRemoteVMPage(strings.TrimPrefix(r.RequestURI, handler.stripURLPrefixFromResponse), nil, vm, vmName, "", DefaultLinuxDistributionURL, "", 0, 0, ""))
 	} else if r.Method == http.MethodPost {
 		// Handle buttons
-		button, isoURL, pointerX, pointerY, pressKeys := handler.parseSubmission(r)
+		button, isoURL, snapshot, pointerX, pointerY, pressKeys := handler.parseSubmission(r)
 		var actionErr error
+		var info string
 		switch button {
 		case "Refresh Screen":
 			// Simply re-render the page, including the screenshot. No extra action is required.
 		case "Download OS":
 			go func() {
-				_ = handler.VM.DownloadISO(isoURL, handler.getISODownloadLocation())
+				_ = vm.DownloadISO(isoURL, handler.getISODownloadLocation(vmName))
 			}()
 			actionErr = errors.New(`Download is in progress, use "Refresh Screen" button to monitor the progress from Info output.`)
-		case "Start":
-			// Kill the older VM (if it exists) and then start a new VM
-			handler.VM.Kill()
-			if _, isoErr := os.Stat(handler.getISODownloadLocation()); os.IsNotExist(isoErr) {
+		case "Start from CD":
+			isoPath := handler.getISODownloadLocation(vmName)
+			if _, isoErr := os.Stat(isoPath); isoErr == nil {
+				// Kill the older VM (if it exists) and then start a new VM, the disk (if any) is attached too.
+				vm.Kill()
+				actionErr = handler.VMs.Start(vmName, isoPath)
+			} else {
 				// If an ISO file does not yet exist, download the default Linux distribution.
-				actionErr = errors.New(`Downloading Linux distribution, use "Refresh Screen" to monitor the progress from Info output, and then press "Start" again.`)
+				actionErr = errors.New(`Downloading Linux distribution, use "Refresh Screen" to monitor the progress from Info output, and then press "Start from CD" again.`)
 				go func() {
-					_ = handler.VM.DownloadISO(isoURL, handler.getISODownloadLocation())
+					_ = vm.DownloadISO(isoURL, isoPath)
 				}()
+			}
+		case "Start from disk":
+			if vm.DiskFilePath == "" {
+				actionErr = errors.New(`This virtual machine does not have a disk, use "Start from CD" instead.`)
 			} else {
-				actionErr = handler.VM.Start(handler.getISODownloadLocation())
+				// Boot from the disk that was prepared earlier, the disk is created upon the first start.
+				vm.Kill()
+				actionErr = handler.VMs.Start(vmName, "")
 			}
 		case "Kill":
-			handler.VM.Kill()
+			vm.Kill()
+		case "Save Snapshot":
+			actionErr = vm.SaveSnapshot(snapshot)
+		case "Load Snapshot":
+			actionErr = vm.LoadSnapshot(snapshot)
+		case "Delete Snapshot":
+			actionErr = vm.DeleteSnapshot(snapshot)
+		case "List Snapshots":
+			info, actionErr = vm.ListSnapshots()
 		case "LHold":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.HoldMouse(true, true)
+				actionErr = vm.HoldMouse(true, true)
 			}
 		case "LRelease":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.HoldMouse(true, false)
+				actionErr = vm.HoldMouse(true, false)
 			}
 		case "LDouble":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.DoubleClickMouse(true)
+				actionErr = vm.DoubleClickMouse(true)
 			}
 		case "LClick":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.ClickMouse(true)
+				actionErr = vm.ClickMouse(true)
 			}
 		case "RHold":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.HoldMouse(false, true)
+				actionErr = vm.HoldMouse(false, true)
 			}
 		case "RRelease":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.HoldMouse(false, false)
+				actionErr = vm.HoldMouse(false, false)
 			}
 		case "RDouble":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.DoubleClickMouse(false)
+				actionErr = vm.DoubleClickMouse(false)
 			}
 		case "RClick":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 			if actionErr == nil {
-				actionErr = handler.VM.ClickMouse(false)
+				actionErr = vm.ClickMouse(false)
 			}
 		case "Move To":
-			actionErr = handler.VM.MoveMouse(pointerX, pointerY)
+			actionErr = vm.MoveMouse(pointerX, pointerY)
 		case "Press Simultaneously":
 			keys := regexp.MustCompile(`[a-zA-Z0-9_]+`).FindAllString(pressKeys, -1)
 			if len(keys) > 0 {
-				actionErr = handler.VM.ClickKeyboard(keys...)
+				actionErr = vm.ClickKeyboard(keys...)
 			}
 		default:
 			actionErr = fmt.Errorf("Unknown button action: %s", button)
 		}
-		_, _ = w.Write(handler.renderRemoteVMPage(strings.TrimPrefix(r.RequestURI, handler.stripURLPrefixFromResponse), actionErr, isoURL, pointerX, pointerY, pressKeys))
+		_, _ = w.Write(handler.renderRemoteVMPage(strings.TrimPrefix(r.RequestURI, handler.stripURLPrefixFromResponse), actionErr, vm, vmName, info, isoURL, snapshot, pointerX, pointerY, pressKeys))
 	}
 }
 
@@ -261,7 +368,7 @@ func (_ *HandleVirtualMachine) SelfTest() error {
 
 // HandleVirtualMachineScreenshot is an HTTP handler that takes a screenshot of remote virtual machine and serves it in JPEG.
 type HandleVirtualMachineScreenshot struct {
-	VM *remotevm.VM `json:"-"`
+	VMs *remotevm.Manager `json:"-"`
 }
 
 // Initialise is not applicable to this HTTP handler, as its internal
@@ -273,6 +380,11 @@ func (_ *HandleVirtualMachineScreenshot) Initialise(lalog.Logger, *toolbox.Comma
 // Handle takes a virtual machine screenshot and responds with JPEG image data completed with appropriate HTTP headers.
 func (handler *HandleVirtualMachineScreenshot) Handle(w http.ResponseWriter, r *http.Request) {
 	NoCache(w)
+	vm, err := handler.VMs.GetVM(r.FormValue("vm"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	// Store screenshot picture in a temporary file
 	screenshot, err := ioutil.TempFile("", "laitos-handle-vm-screenshot")
 	if err != nil {
@@ -281,7 +393,7 @@ func (handler *HandleVirtualMachineScreenshot) Handle(w http.ResponseWriter, r *
 	}
 	_ = screenshot.Close()
 	defer os.Remove(screenshot.Name())
-	if err := handler.VM.TakeScreenshot(screenshot.Name()); err != nil {
+	if err := vm.TakeScreenshot(screenshot.Name()); err != nil {
 		http.Error(w, "Failed to create temporary file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
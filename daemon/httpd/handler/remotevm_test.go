@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/remotevm"
+)
+
+func TestHandleVirtualMachine(t *testing.T) {
+	// Without profiles, there is a single VM
+	vmHandler := HandleVirtualMachine{LocalUtilityPortNumber: 23451, VNCPortNumber: 23452, ScreenshotHandlerInstance: &HandleVirtualMachineScreenshot{}}
+	if err := vmHandler.Initialise(lalog.Logger{}, nil, ""); err == nil {
+		t.Fatal("should have required a VNC password")
+	}
+	vmHandler.VNCPassword = "pass"
+	if err := vmHandler.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if list := vmHandler.VMs.List(); len(list) != 1 || list[0].Name != HandleVirtualMachineDefaultProfileName || list[0].QMPPort != 23451 || list[0].VNCPort != 23452 || list[0].NumCPU < 1 {
+		t.Fatalf("%+v", list)
+	}
+
+	diskDir, err := ioutil.TempDir("", "laitos-test-handle-vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(diskDir)
+	vmHandler = HandleVirtualMachine{
+		Profiles: []remotevm.Profile{
+			{Name: "work", QMPPort: 23453, DiskSizeGB: 1},
+			{Name: "live", QMPPort: 23454},
+		},
+		DiskDir:                   diskDir,
+		ScreenshotEndpoint:        "/screenshot",
+		ScreenshotHandlerInstance: &HandleVirtualMachineScreenshot{},
+	}
+	if err := vmHandler.Initialise(lalog.Logger{}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	// The page lists all VMs and selects the first one by default
+	rec := httptest.NewRecorder()
+	vmHandler.Handle(rec, httptest.NewRequest(http.MethodGet, "/vm", nil))
+	page := rec.Body.String()
+	if !strings.Contains(page, `<option value="live" selected="selected">live (stopped)</option><option value="work">work (stopped)</option>`) ||
+		!strings.Contains(page, `src="/screenshot?vm=live&rand=`) {
+		t.Fatal(page)
+	}
+	// Snapshots are only available to VMs with a disk
+	form := url.Values{"vm": {"live"}, "action": {"List Snapshots"}}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/vm", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	vmHandler.Handle(rec, req)
+	if page := rec.Body.String(); !strings.Contains(page, "snapshots require a disk") {
+		t.Fatal(page)
+	}
+	form = url.Values{"vm": {"work"}, "action": {"Save Snapshot"}, "snapshot": {"<b>"}}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/vm", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	vmHandler.Handle(rec, req)
+	if page := rec.Body.String(); !strings.Contains(page, "snapshot name may only contain") || !strings.Contains(page, `<option value="work" selected="selected">`) || strings.Contains(page, "<b>") {
+		t.Fatal(page)
+	}
+	// Only VMs with a disk may start from disk
+	form = url.Values{"vm": {"live"}, "action": {"Start from disk"}}
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/vm", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	vmHandler.Handle(rec, req)
+	if page := rec.Body.String(); !strings.Contains(page, "does not have a disk") {
+		t.Fatal(page)
+	}
+	// Each VM downloads its own ISO file
+	if live, work := vmHandler.getISODownloadLocation("live"), vmHandler.getISODownloadLocation("work"); live == work || !strings.HasSuffix(live, "-live.iso") {
+		t.Fatal(live, work)
+	}
+	// Screenshot of an unknown VM
+	rec = httptest.NewRecorder()
+	vmHandler.ScreenshotHandlerInstance.Handle(rec, httptest.NewRequest(http.MethodGet, "/screenshot?vm=nothing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+}
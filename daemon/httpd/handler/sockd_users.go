@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/HouzuoGuo/laitos/daemon/sockd"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+/*
+HandleSockdUsers reports the traffic usage of sockd users, and disables or enables a user at runtime. Changing a user
+requires a POST request that carries a password PIN of the command processor, so that the endpoint URL alone - which
+may leak via logs, browser history, or referrer - is not sufficient for changing a user.
+*/
+type HandleSockdUsers struct {
+	Daemon *sockd.Daemon `json:"-"`
+
+	cmdProc *toolbox.CommandProcessor
+	logger  lalog.Logger
+}
+
+func (hand *HandleSockdUsers) Initialise(logger lalog.Logger, cmdProc *toolbox.CommandProcessor, _ string) error {
+	if hand.Daemon == nil {
+		return errors.New("HandleSockdUsers.Initialise: sockd daemon must not be nil")
+	}
+	if cmdProc == nil {
+		return errors.New("HandleSockdUsers.Initialise: command processor must not be nil")
+	}
+	hand.cmdProc = cmdProc
+	hand.logger = logger
+	return nil
+}
+
+func (hand *HandleSockdUsers) Handle(w http.ResponseWriter, r *http.Request) {
+	NoCache(w)
+	// POST endpoint with form pin=PIN&disable=name or pin=PIN&enable=name
+	for _, action := range []struct {
+		name     string
+		disabled bool
+	}{{"disable", true}, {"enable", false}} {
+		userName := r.PostFormValue(action.name)
+		if userName == "" {
+			if r.URL.Query().Get(action.name) != "" {
+				http.Error(w, "changing a user requires a POST request", http.StatusMethodNotAllowed)
+				return
+			}
+			continue
+		}
+		if !hand.cmdProc.MatchPIN(r.PostFormValue("pin")) {
+			hand.logger.Warning("HandleSockdUsers", GetRealClientIP(r), nil, "refused to %s user \"%s\" due to incorrect PIN", action.name, userName)
+			http.Error(w, "incorrect PIN", http.StatusUnauthorized)
+			return
+		}
+		if err := hand.Daemon.SetUserDisabled(userName, action.disabled); err != nil {
+			http.Error(w, fmt.Sprintf("failed to %s user \"%s\" - %v", action.name, userName, err), http.StatusNotFound)
+			return
+		}
+		hand.logger.Info("HandleSockdUsers", GetRealClientIP(r), nil, "%s user \"%s\"", action.name, userName)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	jsonWriter := json.NewEncoder(w)
+	jsonWriter.SetIndent("", "  ")
+	if err := jsonWriter.Encode(hand.Daemon.GetUserUsage()); err != nil {
+		hand.logger.Warning("HandleSockdUsers", r.Host, err, "failed to serialise JSON response")
+	}
+}
+
+func (hand *HandleSockdUsers) GetRateLimitFactor() int {
+	return 1
+}
+
+func (_ *HandleSockdUsers) SelfTest() error {
+	return nil
+}
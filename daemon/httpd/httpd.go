@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/HouzuoGuo/laitos/daemon/httpd/handler"
+	"github.com/HouzuoGuo/laitos/daemon/sockd"
 	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/misc"
@@ -74,6 +75,12 @@ type Daemon struct {
 	TLSKeyPath       string            `json:"TLSKeyPath"`       // (Optional) serve HTTPS via this certificate (key)
 	PerIPLimit       int               `json:"PerIPLimit"`       // PerIPLimit is approximately how many concurrent users are expected to be using the server from same IP address
 	ServeDirectories map[string]string `json:"ServeDirectories"` // Serve directories (value) on prefix paths (key)
+	/*
+		WebDAVDirectories lets WebDAV clients mount directories of ServeDirectories at the same location. The key is a
+		location among ServeDirectories, and the value is either "ro" (read-only) or "rw" (read-write). WebDAV clients
+		authenticate with the command processor's password PIN or TOTP.
+	*/
+	WebDAVDirectories map[string]string `json:"WebDAVDirectories"`
 
 	HandlerCollection HandlerCollection          `json:"-"` // Specialised handlers that implement handler.HandlerFactory interface
 	Processor         *toolbox.CommandProcessor  `json:"-"` // Feature command processor
@@ -85,6 +92,17 @@ type Daemon struct {
 	logger        lalog.Logger
 }
 
+// normaliseDirectoryLocation returns the URL location of a served directory, which begins and ends with a slash.
+func normaliseDirectoryLocation(urlLocation string) string {
+	if urlLocation == "" || urlLocation[0] != '/' {
+		urlLocation = "/" + urlLocation
+	}
+	if urlLocation[len(urlLocation)-1] != '/' {
+		urlLocation += "/"
+	}
+	return urlLocation
+}
+
 // Return path to Handler among special handlers that matches the specified type. Primarily used by test case code.
 func (daemon *Daemon) GetHandlerByFactoryType(match handler.Handler) string {
 	matchTypeString := reflect.TypeOf(match).String()
@@ -125,6 +143,9 @@ func (daemon *Daemon) Initialise(stripURLPrefixFromRequest string, stripURLPrefi
 	if daemon.PerIPLimit < 1 {
 		daemon.PerIPLimit = 12 // reasonable for couple of users that use advanced API endpoints in parallel
 	}
+	if len(daemon.WebDAVDirectories) > 0 && (daemon.Processor == nil || daemon.Processor.IsEmpty()) {
+		return errors.New("httpd.Initialise: WebDAV requires a command processor with password PIN for authentication")
+	}
 	if daemon.Processor == nil || daemon.Processor.IsEmpty() {
 		daemon.logger.Info("Initialise", "", nil, "daemon will not be able to execute toolbox commands due to lack of command processor filter configuration")
 		daemon.Processor = toolbox.GetEmptyCommandProcessor()
@@ -143,25 +164,49 @@ func (daemon *Daemon) Initialise(stripURLPrefixFromRequest string, stripURLPrefi
 	// Install handlers with rate-limiting middleware
 	daemon.mux = new(http.ServeMux)
 	daemon.AllRateLimits = map[string]*misc.RateLimit{}
+	// WebDAV directories are identified by their location among ServeDirectories
+	webDAVModes := make(map[string]string)
+	for urlLocation, mode := range daemon.WebDAVDirectories {
+		if mode != WebDAVReadOnly && mode != WebDAVReadWrite {
+			return fmt.Errorf("httpd.Initialise: WebDAV mode of \"%s\" must be either \"%s\" or \"%s\"", urlLocation, WebDAVReadOnly, WebDAVReadWrite)
+		}
+		webDAVModes[normaliseDirectoryLocation(urlLocation)] = mode
+	}
+	for urlLocation := range webDAVModes {
+		var found bool
+		for dirLocation, dirPath := range daemon.ServeDirectories {
+			if dirPath != "" && normaliseDirectoryLocation(dirLocation) == urlLocation {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("httpd.Initialise: WebDAV location \"%s\" is not among ServeDirectories", urlLocation)
+		}
+	}
 	// Install directory handlers
 	if daemon.ServeDirectories != nil {
 		for urlLocation, dirPath := range daemon.ServeDirectories {
 			if urlLocation == "" || dirPath == "" {
 				continue
 			}
-			if urlLocation[0] != '/' {
-				urlLocation = "/" + urlLocation
-			}
-			if urlLocation[len(urlLocation)-1] != '/' {
-				urlLocation += "/"
-			}
-			urlLocation = stripURLPrefixFromRequest + urlLocation
+			mode, webDAVEnabled := webDAVModes[normaliseDirectoryLocation(urlLocation)]
+			urlLocation = stripURLPrefixFromRequest + normaliseDirectoryLocation(urlLocation)
 			rl := &misc.RateLimit{
 				UnitSecs: RateLimitIntervalSec,
 				MaxCount: DirectoryHandlerRateLimitFactor * daemon.PerIPLimit,
 				Logger:   daemon.logger,
 			}
 			daemon.AllRateLimits[urlLocation] = rl
+			if webDAVEnabled {
+				dav := &WebDAV{DirPath: dirPath, URLLocation: urlLocation, ReadOnly: mode == WebDAVReadOnly, Processor: daemon.Processor}
+				if err := dav.Initialise(daemon.logger); err != nil {
+					return err
+				}
+				// Files uploaded by WebDAV clients are not subject to the limited request size
+				daemon.mux.Handle(urlLocation, daemon.DecorateWithMiddleware(rl, dav.ReadOnly, dav.Handle))
+				daemon.logger.Info("Initialise", "", nil, "installed directory listing and WebDAV (%s) handler at location %s", mode, urlLocation)
+				continue
+			}
 			daemon.mux.Handle(urlLocation, daemon.DecorateWithMiddleware(rl, true, http.StripPrefix(urlLocation, http.FileServer(http.Dir(dirPath))).(http.HandlerFunc)))
 			daemon.logger.Info("Initialise", "", nil, "installed directory listing handler at location %s", urlLocation)
 		}
@@ -178,8 +223,11 @@ func (daemon *Daemon) Initialise(stripURLPrefixFromRequest string, stripURLPrefi
 		}
 		urlLocation = stripURLPrefixFromRequest + urlLocation
 		daemon.AllRateLimits[urlLocation] = rl
-		// With the exception of file upload handler, all handlers will be subject to a limited request size.
+		// With the exception of file upload and file store handlers, all handlers will be subject to a limited request size.
 		_, unrestrictedRequestSize := hand.(*handler.HandleFileUpload)
+		if _, isFileStore := hand.(*handler.HandleFileStore); isFileStore {
+			unrestrictedRequestSize = true
+		}
 		daemon.mux.Handle(urlLocation, daemon.DecorateWithMiddleware(rl, !unrestrictedRequestSize, hand.Handle))
 		daemon.logger.Info("Initialise", "", nil, "installed web service at location %s", urlLocation)
 	}
@@ -653,6 +701,57 @@ over.
 	if cmd := httpd.Processor.Features.MessageProcessor.OutgoingAppCommands["subject-host-name"]; cmd != "test123" {
 		t.Fatal(cmd)
 	}
+
+	// Test sockd users endpoint, changing a user requires POST and PIN.
+	var usage []sockd.UserUsage
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{})+"?disable=howard&pin="+toolbox.TestCommandProcessorPIN)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal(err, string(resp.Body))
+	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		Method: http.MethodPost,
+		Body:   strings.NewReader(url.Values{"disable": {"howard"}, "pin": {"wrong"}}.Encode()),
+	}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{}))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(err, string(resp.Body))
+	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{}))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, string(resp.Body))
+	}
+	if err := json.Unmarshal(resp.Body, &usage); err != nil || len(usage) != 1 || usage[0].Disabled {
+		t.Fatal(err, usage)
+	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		Method: http.MethodPost,
+		Body:   strings.NewReader(url.Values{"disable": {"howard"}, "pin": {toolbox.TestCommandProcessorPIN}}.Encode()),
+	}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{}))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, string(resp.Body))
+	}
+	if err := json.Unmarshal(resp.Body, &usage); err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Name != "howard" || !usage[0].Disabled {
+		t.Fatalf("%+v", usage)
+	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		Method: http.MethodPost,
+		Body:   strings.NewReader(url.Values{"enable": {"howard"}, "pin": {toolbox.TestCommandProcessorPIN}}.Encode()),
+	}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{}))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(err, string(resp.Body))
+	}
+	if err := json.Unmarshal(resp.Body, &usage); err != nil || len(usage) != 1 || usage[0].Disabled {
+		t.Fatal(err, usage)
+	}
+	resp, err = inet.DoHTTP(context.Background(), inet.HTTPRequest{
+		Method: http.MethodPost,
+		Body:   strings.NewReader(url.Values{"disable": {"does-not-exist"}, "pin": {toolbox.TestCommandProcessorPIN}}.Encode()),
+	}, addr+httpd.GetHandlerByFactoryType(&handler.HandleSockdUsers{}))
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatal(err, string(resp.Body))
+	}
 }
 
 const (
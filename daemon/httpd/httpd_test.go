@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
 	"github.com/HouzuoGuo/laitos/daemon/httpd/handler"
+	"github.com/HouzuoGuo/laitos/daemon/sockd"
 	"github.com/HouzuoGuo/laitos/inet"
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
@@ -130,6 +132,17 @@ func TestHTTPD_StartAndBlock(t *testing.T) {
 	}
 	daemon.HandlerCollection["/cmd"] = &handler.HandleAppCommand{}
 	daemon.HandlerCollection["/reports"] = &handler.HandleReportsRetrieval{}
+	sockDaemon := &sockd.Daemon{
+		DNSDaemon:   &dnsd.Daemon{},
+		Password:    "1234567",
+		TCPPorts:    []int{8837},
+		PortCiphers: map[int]string{8837: sockd.CipherAES256GCM},
+		Users:       []sockd.User{{Name: "howard", Password: "7654321"}},
+	}
+	if err := sockDaemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	daemon.HandlerCollection["/sockd_users"] = &handler.HandleSockdUsers{Daemon: sockDaemon}
 
 	if err := daemon.Initialise("", ""); err != nil {
 		t.Fatal(err)
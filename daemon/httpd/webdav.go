@@ -0,0 +1,642 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/httpd/handler"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+const (
+	WebDAVReadOnly  = "ro" // WebDAVReadOnly lets WebDAV clients read files and list directories.
+	WebDAVReadWrite = "rw" // WebDAVReadWrite additionally lets WebDAV clients create, modify, and delete files and directories.
+
+	/*
+		WebDAVTOTPSessionSec is the duration in which a TOTP code continues to authenticate a WebDAV client after its
+		first use. The session belongs to the client IP that first used the code, and no other client may use the code.
+	*/
+	WebDAVTOTPSessionSec = 3600
+	/*
+		WebDAVMaxAuthFailures is the maximum number of failed authentication attempts a client IP may make in
+		WebDAVAuthFailureIntervalSec, after which the client may not authenticate until the interval passes.
+	*/
+	WebDAVMaxAuthFailures        = 5
+	WebDAVAuthFailureIntervalSec = 600
+	// WebDAVLockTimeoutSec is the timeout of a lock granted to WebDAV clients.
+	WebDAVLockTimeoutSec = 3600
+
+	webDAVNamespace = "DAV:"
+)
+
+// regexWebDAVLockToken finds the lock token in an If header, such as "(<opaquelocktoken:1234>)".
+var regexWebDAVLockToken = regexp.MustCompile(`<(opaquelocktoken:[^>]+)>`)
+
+/*
+WebDAV serves a directory to WebDAV clients, which mount the directory as a network drive. It implements WebDAV class
+1 operations, and grants class 2 locks without enforcing them, which satisfies desktop clients that insist on locking
+files before writing them.
+Clients authenticate via HTTP basic authentication, the user name is ignored and the password is either one of the
+command processor's password PINs or a 12-digit TOTP code derived from the PINs. Because basic authentication sends the
+password in plain text, the password PINs are only accepted over HTTPS, and plain HTTP clients must use TOTP codes, each
+of which is only accepted from the first client IP that uses it. Failed authentication attempts are limited per client IP
+independently from the rate limit of the directory.
+GET and HEAD requests do not require authentication, just like the directory listing of ServeDirectories.
+*/
+type WebDAV struct {
+	DirPath     string                    // DirPath is the directory shared with WebDAV clients.
+	URLLocation string                    // URLLocation is the location of the share, it begins and ends with a slash.
+	ReadOnly    bool                      // ReadOnly prevents WebDAV clients from making changes to the directory.
+	Processor   *toolbox.CommandProcessor // Processor authenticates WebDAV clients.
+
+	fileServer    http.Handler
+	totpSessions  map[string]time.Time // totpSessions are hashes of client IPs and recently used TOTP codes, and their expiry.
+	usedTOTPCodes map[string]time.Time // usedTOTPCodes are hashes of recently used TOTP codes, and their expiry.
+	authFailures  *misc.RateLimit      // authFailures counts failed authentication attempts of each client IP.
+	mutex         *sync.Mutex
+	logger        lalog.Logger
+}
+
+// Initialise prepares internal states.
+func (dav *WebDAV) Initialise(logger lalog.Logger) error {
+	if dav.DirPath == "" || dav.Processor == nil {
+		return fmt.Errorf("httpd.WebDAV: directory and command processor must be present")
+	}
+	if !strings.HasPrefix(dav.URLLocation, "/") || !strings.HasSuffix(dav.URLLocation, "/") {
+		return fmt.Errorf("httpd.WebDAV: URL location \"%s\" must begin and end with a slash", dav.URLLocation)
+	}
+	dav.logger = logger
+	dav.fileServer = http.StripPrefix(dav.URLLocation, http.FileServer(http.Dir(dav.DirPath)))
+	dav.totpSessions = make(map[string]time.Time)
+	dav.usedTOTPCodes = make(map[string]time.Time)
+	dav.authFailures = &misc.RateLimit{
+		UnitSecs: WebDAVAuthFailureIntervalSec,
+		MaxCount: WebDAVMaxAuthFailures,
+		Logger:   logger,
+	}
+	dav.authFailures.Initialise()
+	dav.mutex = new(sync.Mutex)
+	return nil
+}
+
+// totpSessionKey returns the key of a TOTP session that belongs to the client IP.
+func totpSessionKey(clientIP, code string) string {
+	hash := sha256.Sum256([]byte(clientIP + "\x00" + code))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+authenticate returns true if the request carries a valid TOTP code, or a valid password PIN over HTTPS. A TOTP code
+is only valid in the TOTP interval or the session that began in the interval from the same client IP. The first use of
+a TOTP code begins the session, after which the code no longer authenticates other client IPs, this prevents an
+eavesdropper from reusing a code seen in plain HTTP traffic.
+*/
+func (dav *WebDAV) authenticate(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	if !ok || password == "" {
+		return false
+	}
+	/*
+		WebDAV clients send the same credentials with every request, therefore a TOTP code must remain valid for a while
+		after it is first used, instead of expiring along with the TOTP interval.
+	*/
+	clientIP := handler.GetRealClientIP(r)
+	sessionKey := totpSessionKey(clientIP, password)
+	dav.mutex.Lock()
+	defer dav.mutex.Unlock()
+	now := time.Now()
+	for _, sessions := range []map[string]time.Time{dav.totpSessions, dav.usedTOTPCodes} {
+		for key, expiry := range sessions {
+			if now.After(expiry) {
+				delete(sessions, key)
+			}
+		}
+	}
+	if _, exists := dav.totpSessions[sessionKey]; exists {
+		return true
+	}
+	if dav.authFailures.Exceeded(clientIP) {
+		dav.logger.Warning("WebDAV", clientIP, nil, "refused authentication after too many failed attempts")
+		return false
+	}
+	if dav.Processor.MatchPIN(password) {
+		if r.TLS == nil {
+			dav.logger.Warning("WebDAV", clientIP, nil, "refused password PIN over plain HTTP, use HTTPS or a TOTP code instead")
+			return false
+		}
+		return true
+	}
+	if dav.Processor.MatchTOTP(password) {
+		codeKey := totpSessionKey("", password)
+		if _, used := dav.usedTOTPCodes[codeKey]; used {
+			dav.logger.Warning("WebDAV", clientIP, nil, "refused a TOTP code that was already used by another client")
+			dav.authFailures.Add(clientIP, true)
+			return false
+		}
+		dav.usedTOTPCodes[codeKey] = now.Add(WebDAVTOTPSessionSec * time.Second)
+		dav.totpSessions[sessionKey] = now.Add(WebDAVTOTPSessionSec * time.Second)
+		return true
+	}
+	dav.authFailures.Add(clientIP, true)
+	return false
+}
+
+// resolve returns the path in the shared directory that corresponds to the URL path, and the cleaned URL path relative to the share.
+func (dav *WebDAV) resolve(urlPath string) (string, string, bool) {
+	if !strings.HasPrefix(urlPath+"/", dav.URLLocation) {
+		return "", "", false
+	}
+	relPath := path.Clean("/" + strings.TrimPrefix(urlPath, dav.URLLocation))
+	return filepath.Join(dav.DirPath, filepath.FromSlash(relPath)), relPath, true
+}
+
+// Handle serves a WebDAV request.
+func (dav *WebDAV) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		dav.fileServer.ServeHTTP(w, r)
+		return
+	}
+	if !dav.authenticate(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="laitos WebDAV"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	fsPath, relPath, ok := dav.resolve(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		allow := "OPTIONS, GET, HEAD, PROPFIND"
+		if !dav.ReadOnly {
+			allow += ", PUT, DELETE, MKCOL, COPY, MOVE, PROPPATCH, LOCK, UNLOCK"
+			w.Header().Set("DAV", "1, 2")
+		} else {
+			w.Header().Set("DAV", "1")
+		}
+		w.Header().Set("Allow", allow)
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.WriteHeader(http.StatusOK)
+		return
+	case "PROPFIND":
+		dav.propfind(w, r, fsPath, relPath)
+		return
+	}
+	if dav.ReadOnly {
+		http.Error(w, "the directory is read-only", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		dav.put(w, r, fsPath)
+	case http.MethodDelete:
+		if relPath == "/" {
+			http.Error(w, "cannot delete the shared directory", http.StatusForbidden)
+			return
+		}
+		if _, err := os.Stat(fsPath); os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := os.RemoveAll(fsPath); err != nil {
+			dav.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "MKCOL":
+		if r.ContentLength > 0 {
+			http.Error(w, "MKCOL does not accept a request body", http.StatusUnsupportedMediaType)
+			return
+		}
+		if _, err := os.Stat(fsPath); err == nil {
+			http.Error(w, "the resource already exists", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := os.Mkdir(fsPath, 0755); err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "the parent collection does not exist", http.StatusConflict)
+				return
+			}
+			dav.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "COPY", "MOVE":
+		dav.copyOrMove(w, r, fsPath, relPath)
+	case "PROPPATCH":
+		dav.proppatch(w, r, fsPath, relPath)
+	case "LOCK":
+		dav.lock(w, r, fsPath, relPath)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method is not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeError responds to the client with an internal server error and logs the error.
+func (dav *WebDAV) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	dav.logger.Warning("WebDAV", handler.GetRealClientIP(r), err, "failed to handle %s %s", r.Method, r.URL.Path)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// put writes the request body into a file.
+func (dav *WebDAV) put(w http.ResponseWriter, r *http.Request, fsPath string) {
+	status := http.StatusCreated
+	if info, err := os.Stat(fsPath); err == nil {
+		if info.IsDir() {
+			http.Error(w, "cannot overwrite a collection", http.StatusMethodNotAllowed)
+			return
+		}
+		status = http.StatusNoContent
+	}
+	if _, err := os.Stat(filepath.Dir(fsPath)); err != nil {
+		http.Error(w, "the parent collection does not exist", http.StatusConflict)
+		return
+	}
+	// Write into a temporary file first so that an interrupted upload does not destroy the existing file
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fsPath), ".laitos-webdav-")
+	if err != nil {
+		dav.writeError(w, r, err)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = io.Copy(tmpFile, r.Body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if err = os.Chmod(tmpFile.Name(), 0644); err == nil {
+			err = os.Rename(tmpFile.Name(), fsPath)
+		}
+	}
+	if err != nil {
+		dav.writeError(w, r, err)
+		return
+	}
+	if info, err := os.Stat(fsPath); err == nil {
+		w.Header().Set("ETag", webDAVETag(info))
+	}
+	w.WriteHeader(status)
+}
+
+// copyOrMove copies or moves the resource to the location told by the Destination header.
+func (dav *WebDAV) copyOrMove(w http.ResponseWriter, r *http.Request, fsPath, relPath string) {
+	destURL, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || destURL.Path == "" {
+		http.Error(w, "Destination header is missing or malformed", http.StatusBadRequest)
+		return
+	}
+	if destURL.Host != "" && destURL.Host != r.Host {
+		http.Error(w, "Destination must be on the same server", http.StatusBadGateway)
+		return
+	}
+	destFSPath, destRelPath, ok := dav.resolve(destURL.Path)
+	if !ok {
+		http.Error(w, "Destination must be in the same shared directory", http.StatusBadGateway)
+		return
+	}
+	srcInfo, err := os.Stat(fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if relPath == "/" || destRelPath == "/" || destRelPath == relPath || strings.HasPrefix(destRelPath, relPath+"/") {
+		http.Error(w, "source and destination overlap", http.StatusForbidden)
+		return
+	}
+	if _, err := os.Stat(filepath.Dir(destFSPath)); err != nil {
+		http.Error(w, "the parent collection of destination does not exist", http.StatusConflict)
+		return
+	}
+	status := http.StatusCreated
+	if _, err := os.Stat(destFSPath); err == nil {
+		if strings.ToUpper(r.Header.Get("Overwrite")) == "F" {
+			http.Error(w, "destination already exists", http.StatusPreconditionFailed)
+			return
+		}
+		if err := os.RemoveAll(destFSPath); err != nil {
+			dav.writeError(w, r, err)
+			return
+		}
+		status = http.StatusNoContent
+	}
+	if r.Method == "MOVE" {
+		err = os.Rename(fsPath, destFSPath)
+	} else {
+		err = webDAVCopy(fsPath, destFSPath, srcInfo, r.Header.Get("Depth") != "0")
+	}
+	if err != nil {
+		dav.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// webDAVCopy copies a file, or a directory and optionally all of its content.
+func webDAVCopy(src, dest string, srcInfo os.FileInfo, recursive bool) error {
+	if !srcInfo.IsDir() {
+		srcFile, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer srcFile.Close()
+		destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, srcInfo.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(destFile, srcFile); err != nil {
+			_ = destFile.Close()
+			return err
+		}
+		return destFile.Close()
+	}
+	if err := os.Mkdir(dest, srcInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	if !recursive {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := webDAVCopy(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name()), entry, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webDAVETag returns an entity tag derived from the file's modification time and size.
+func webDAVETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// webDAVPropNames is a prop element of a request, it has the names of properties.
+type webDAVPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// webDAVPropfindRequest is the body of a PROPFIND request.
+type webDAVPropfindRequest struct {
+	AllProp  *struct{}        `xml:"DAV: allprop"`
+	PropName *struct{}        `xml:"DAV: propname"`
+	Prop     *webDAVPropNames `xml:"DAV: prop"`
+}
+
+// webDAVProperty is a property name and its value, which is XML text.
+type webDAVProperty struct {
+	Name  xml.Name
+	Value string
+}
+
+// liveProperties returns all properties of the file.
+func (dav *WebDAV) liveProperties(info os.FileInfo, relPath string) []webDAVProperty {
+	name := path.Base(relPath)
+	if relPath == "/" {
+		name = filepath.Base(dav.DirPath)
+	}
+	props := []webDAVProperty{
+		{Name: xml.Name{Space: webDAVNamespace, Local: "displayname"}, Value: webDAVEscape(name)},
+		{Name: xml.Name{Space: webDAVNamespace, Local: "getlastmodified"}, Value: info.ModTime().UTC().Format(http.TimeFormat)},
+		{Name: xml.Name{Space: webDAVNamespace, Local: "supportedlock"}},
+		{Name: xml.Name{Space: webDAVNamespace, Local: "lockdiscovery"}},
+	}
+	if !dav.ReadOnly {
+		props[2].Value = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`
+	}
+	if info.IsDir() {
+		props = append(props, webDAVProperty{Name: xml.Name{Space: webDAVNamespace, Local: "resourcetype"}, Value: "<D:collection/>"})
+	} else {
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		props = append(props,
+			webDAVProperty{Name: xml.Name{Space: webDAVNamespace, Local: "resourcetype"}},
+			webDAVProperty{Name: xml.Name{Space: webDAVNamespace, Local: "getcontentlength"}, Value: strconv.FormatInt(info.Size(), 10)},
+			webDAVProperty{Name: xml.Name{Space: webDAVNamespace, Local: "getcontenttype"}, Value: webDAVEscape(contentType)},
+			webDAVProperty{Name: xml.Name{Space: webDAVNamespace, Local: "getetag"}, Value: webDAVEscape(webDAVETag(info))})
+	}
+	return props
+}
+
+// webDAVEscape returns the text escaped for XML.
+func webDAVEscape(text string) string {
+	var out bytes.Buffer
+	_ = xml.EscapeText(&out, []byte(text))
+	return out.String()
+}
+
+// writeWebDAVProperties writes the properties and their values (unless namesOnly is true) into a propstat element.
+func writeWebDAVProperties(out *bytes.Buffer, props []webDAVProperty, status int, namesOnly bool) {
+	if len(props) == 0 {
+		return
+	}
+	out.WriteString("<D:propstat><D:prop>")
+	for i, prop := range props {
+		// Properties outside of DAV namespace are written with their own namespace declaration
+		tag := "D:" + prop.Name.Local
+		nsDecl := ""
+		if prop.Name.Space != webDAVNamespace {
+			tag = fmt.Sprintf("ns%d:%s", i, prop.Name.Local)
+			nsDecl = fmt.Sprintf(` xmlns:ns%d="%s"`, i, webDAVEscape(prop.Name.Space))
+		}
+		if namesOnly || prop.Value == "" {
+			out.WriteString(fmt.Sprintf("<%s%s/>", tag, nsDecl))
+		} else {
+			out.WriteString(fmt.Sprintf("<%s%s>%s</%s>", tag, nsDecl, prop.Value, tag))
+		}
+	}
+	out.WriteString(fmt.Sprintf("</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", status, http.StatusText(status)))
+}
+
+// propfind responds with the properties of the resource, and of its children if the depth is 1.
+func (dav *WebDAV) propfind(w http.ResponseWriter, r *http.Request, fsPath, relPath string) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		// Listing an entire directory tree is expensive, the specification allows servers to refuse it.
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(xml.Header + `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`))
+		return
+	}
+	var req webDAVPropfindRequest
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "malformed PROPFIND request", http.StatusBadRequest)
+			return
+		}
+	}
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	type resource struct {
+		info    os.FileInfo
+		relPath string
+	}
+	resources := []resource{{info: info, relPath: relPath}}
+	if info.IsDir() && depth == "1" {
+		entries, err := ioutil.ReadDir(fsPath)
+		if err != nil {
+			dav.writeError(w, r, err)
+			return
+		}
+		for _, entry := range entries {
+			resources = append(resources, resource{info: entry, relPath: path.Join(relPath, entry.Name())})
+		}
+	}
+	var out bytes.Buffer
+	out.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">`)
+	for _, res := range resources {
+		href := path.Join(dav.URLLocation, res.relPath)
+		if res.info.IsDir() {
+			href += "/"
+		}
+		out.WriteString("<D:response><D:href>" + webDAVEscape((&url.URL{Path: href}).EscapedPath()) + "</D:href>")
+		props := dav.liveProperties(res.info, res.relPath)
+		if req.Prop == nil {
+			writeWebDAVProperties(&out, props, http.StatusOK, req.PropName != nil)
+		} else {
+			// Respond with the requested properties, and tell which of them do not exist.
+			found := make([]webDAVProperty, 0)
+			missing := make([]webDAVProperty, 0)
+			for _, requested := range req.Prop.Names {
+				var match *webDAVProperty
+				for i := range props {
+					if props[i].Name == requested.XMLName {
+						match = &props[i]
+					}
+				}
+				if match == nil {
+					missing = append(missing, webDAVProperty{Name: requested.XMLName})
+				} else {
+					found = append(found, *match)
+				}
+			}
+			writeWebDAVProperties(&out, found, http.StatusOK, false)
+			writeWebDAVProperties(&out, missing, http.StatusNotFound, true)
+		}
+		out.WriteString("</D:response>")
+	}
+	out.WriteString("</D:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write(out.Bytes())
+}
+
+// webDAVProppatchRequest is the body of a PROPPATCH request.
+type webDAVProppatchRequest struct {
+	Set []struct {
+		Prop webDAVPropNames `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+	Remove []struct {
+		Prop webDAVPropNames `xml:"DAV: prop"`
+	} `xml:"DAV: remove"`
+}
+
+/*
+proppatch refuses to change any property. Live properties are computed from the file system, and arbitrary (dead)
+properties are not stored. Clients such as Windows Explorer tolerate the refusal.
+*/
+func (dav *WebDAV) proppatch(w http.ResponseWriter, r *http.Request, fsPath, relPath string) {
+	if _, err := os.Stat(fsPath); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var req webDAVProppatchRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "malformed PROPPATCH request", http.StatusBadRequest)
+		return
+	}
+	props := make([]webDAVProperty, 0)
+	for _, set := range req.Set {
+		for _, name := range set.Prop.Names {
+			props = append(props, webDAVProperty{Name: name.XMLName})
+		}
+	}
+	for _, remove := range req.Remove {
+		for _, name := range remove.Prop.Names {
+			props = append(props, webDAVProperty{Name: name.XMLName})
+		}
+	}
+	var out bytes.Buffer
+	out.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:"><D:response><D:href>`)
+	out.WriteString(webDAVEscape((&url.URL{Path: path.Join(dav.URLLocation, relPath)}).EscapedPath()) + "</D:href>")
+	writeWebDAVProperties(&out, props, http.StatusForbidden, true)
+	out.WriteString("</D:response></D:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write(out.Bytes())
+}
+
+// lock grants an exclusive write lock, creating an empty file if the resource does not yet exist. Locks are not enforced.
+func (dav *WebDAV) lock(w http.ResponseWriter, r *http.Request, fsPath, relPath string) {
+	// A request without body refreshes an existing lock, whose token is in the If header.
+	var token string
+	if match := regexWebDAVLockToken.FindStringSubmatch(r.Header.Get("If")); len(match) == 2 {
+		token = match[1]
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	if len(bytes.TrimSpace(body)) > 0 || token == "" {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			dav.writeError(w, r, err)
+			return
+		}
+		token = "opaquelocktoken:" + hex.EncodeToString(tokenBytes)
+		if _, err := os.Stat(fsPath); os.IsNotExist(err) {
+			if err := ioutil.WriteFile(fsPath, []byte{}, 0644); err != nil {
+				http.Error(w, "the parent collection does not exist", http.StatusConflict)
+				return
+			}
+			status = http.StatusCreated
+		}
+	}
+	depth := "infinity"
+	if r.Header.Get("Depth") == "0" {
+		depth = "0"
+	}
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(fmt.Sprintf(xml.Header+`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope><D:depth>%s</D:depth>`+
+		`<D:timeout>Second-%d</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken>`+
+		`<D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock></D:lockdiscovery></D:prop>`,
+		depth, WebDAVLockTimeoutSec, webDAVEscape(token), webDAVEscape((&url.URL{Path: path.Join(dav.URLLocation, relPath)}).EscapedPath()))))
+}
@@ -0,0 +1,224 @@
+package httpd
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/httpd/handler"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+func TestWebDAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-webdav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a & b.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proc := &toolbox.CommandProcessor{CommandFilters: []toolbox.CommandFilter{&toolbox.PINAndShortcuts{Passwords: []string{toolbox.TestCommandProcessorPIN}}}}
+	dav := &WebDAV{DirPath: dir, URLLocation: "/dav/", Processor: proc}
+	if err := dav.Initialise(lalog.Logger{}); err != nil {
+		t.Fatal(err)
+	}
+	// Requests arrive via HTTPS from the same client, unless the test case says otherwise.
+	plainHTTP, clientAddr := false, "192.0.2.1:1234"
+	serve := func(method, target, body string, header map[string]string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = clientAddr
+		if !plainHTTP {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		if password != "" {
+			req.SetBasicAuth("anyone", password)
+		}
+		rec := httptest.NewRecorder()
+		dav.Handle(rec, req)
+		return rec
+	}
+	pin := toolbox.TestCommandProcessorPIN
+
+	// Reading files does not require authentication, other methods do.
+	if rec := serve(http.MethodGet, "/dav/a%20&%20b.txt", "", nil, ""); rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec := serve("PROPFIND", "/dav/", "", map[string]string{"Depth": "1"}, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("PROPFIND", "/dav/", "", map[string]string{"Depth": "1"}, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	// A TOTP code remains valid after its first use
+	_, code1, _, _ := toolbox.GetTwoFACodes(pin)
+	_, code2, _, _ := toolbox.GetTwoFACodes("tercesyrev")
+	for i := 0; i < 2; i++ {
+		if rec := serve(http.MethodOptions, "/dav/", "", nil, code1+code2); rec.Code != http.StatusOK || rec.Header().Get("DAV") != "1, 2" {
+			t.Fatal(rec.Code, rec.Header())
+		}
+	}
+	// The TOTP session belongs to the client IP that began it
+	dav.totpSessions[totpSessionKey("192.0.2.1", "000000000000")] = time.Now().Add(time.Minute)
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, "000000000000"); rec.Code != http.StatusOK {
+		t.Fatal(rec.Code)
+	}
+	clientAddr = "192.0.2.2:1234"
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, "000000000000"); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	// A TOTP code used by one client IP does not authenticate another
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, code1+code2); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	// Plain HTTP clients may only use TOTP codes
+	plainHTTP = true
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, pin); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	_, _, nextCode1, _ := toolbox.GetTwoFACodes(pin)
+	_, _, nextCode2, _ := toolbox.GetTwoFACodes("tercesyrev")
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, nextCode1+nextCode2); rec.Code != http.StatusOK {
+		t.Fatal(rec.Code)
+	}
+	// After too many failed attempts, the client IP may not authenticate even with the correct password
+	plainHTTP, clientAddr = false, "192.0.2.3:1234"
+	for i := 0; i < WebDAVMaxAuthFailures; i++ {
+		if rec := serve(http.MethodOptions, "/dav/", "", nil, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatal(rec.Code)
+		}
+	}
+	if rec := serve(http.MethodOptions, "/dav/", "", nil, pin); rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code)
+	}
+	clientAddr = "192.0.2.1:1234"
+
+	// List the directory
+	rec := serve("PROPFIND", "/dav/", "", map[string]string{"Depth": "1"}, pin)
+	if rec.Code != http.StatusMultiStatus ||
+		!strings.Contains(rec.Body.String(), "<D:href>/dav/</D:href>") ||
+		!strings.Contains(rec.Body.String(), "<D:resourcetype><D:collection/></D:resourcetype>") ||
+		!strings.Contains(rec.Body.String(), "<D:href>/dav/a%20&amp;%20b.txt</D:href>") ||
+		!strings.Contains(rec.Body.String(), "<D:displayname>a &amp; b.txt</D:displayname>") ||
+		!strings.Contains(rec.Body.String(), "<D:getcontentlength>5</D:getcontentlength>") {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec := serve("PROPFIND", "/dav/", "", map[string]string{"Depth": "infinity"}, pin); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "propfind-finite-depth") {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Request specific properties, the unknown property is not found.
+	rec = serve("PROPFIND", "/dav/a%20&%20b.txt", `<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:x="urn:x"><prop><getcontentlength/><x:color/></prop></propfind>`,
+		map[string]string{"Depth": "0"}, pin)
+	if body := rec.Body.String(); rec.Code != http.StatusMultiStatus || strings.Contains(body, "displayname") ||
+		!strings.Contains(body, "<D:getcontentlength>5</D:getcontentlength></D:prop><D:status>HTTP/1.1 200 OK</D:status>") ||
+		!strings.Contains(body, `<ns0:color xmlns:ns0="urn:x"/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status>`) {
+		t.Fatal(rec.Code, body)
+	}
+
+	// Create a directory and a file in it
+	if rec := serve("MKCOL", "/dav/sub", "", nil, pin); rec.Code != http.StatusCreated {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("MKCOL", "/dav/sub", "", nil, pin); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("MKCOL", "/dav/missing/sub", "", nil, pin); rec.Code != http.StatusConflict {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("LOCK", "/dav/sub/new.txt", `<?xml version="1.0"?><lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope><locktype><write/></locktype></lockinfo>`, nil, pin); rec.Code != http.StatusCreated ||
+		!strings.HasPrefix(rec.Header().Get("Lock-Token"), "<opaquelocktoken:") {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	if rec := serve(http.MethodPut, "/dav/sub/new.txt", "new content", nil, pin); rec.Code != http.StatusNoContent {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodPut, "/dav/missing/new.txt", "new content", nil, pin); rec.Code != http.StatusConflict {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("UNLOCK", "/dav/sub/new.txt", "", nil, pin); rec.Code != http.StatusNoContent {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("PROPPATCH", "/dav/sub/new.txt", `<?xml version="1.0"?><propertyupdate xmlns="DAV:" xmlns:Z="urn:schemas-microsoft-com:"><set><prop><Z:Win32FileAttributes>00000020</Z:Win32FileAttributes></prop></set></propertyupdate>`, nil, pin); rec.Code != http.StatusMultiStatus ||
+		!strings.Contains(rec.Body.String(), "Win32FileAttributes") || !strings.Contains(rec.Body.String(), "403 Forbidden") {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+
+	// Copy and move
+	if rec := serve("COPY", "/dav/sub", "", map[string]string{"Destination": "http://example.com/dav/copy"}, pin); rec.Code != http.StatusCreated {
+		t.Fatal(rec.Code)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "copy", "new.txt")); err != nil || string(content) != "new content" {
+		t.Fatal(string(content), err)
+	}
+	if rec := serve("MOVE", "/dav/copy/new.txt", "", map[string]string{"Destination": "/dav/a%20&%20b.txt", "Overwrite": "F"}, pin); rec.Code != http.StatusPreconditionFailed {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("MOVE", "/dav/copy/new.txt", "", map[string]string{"Destination": "/dav/a%20&%20b.txt"}, pin); rec.Code != http.StatusNoContent {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("MOVE", "/dav/sub", "", map[string]string{"Destination": "/dav/sub/inside"}, pin); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("COPY", "/dav/sub", "", map[string]string{"Destination": "/elsewhere/sub"}, pin); rec.Code != http.StatusBadGateway {
+		t.Fatal(rec.Code)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "a & b.txt")); err != nil || string(content) != "new content" {
+		t.Fatal(string(content), err)
+	}
+
+	// Delete
+	if rec := serve(http.MethodDelete, "/dav/copy", "", nil, pin); rec.Code != http.StatusNoContent {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/dav/copy", "", nil, pin); rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/dav/../", "", nil, pin); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+
+	// A read-only directory refuses changes
+	dav.ReadOnly = true
+	if rec := serve(http.MethodPut, "/dav/sub/new.txt", "changed", nil, pin); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code)
+	}
+	if rec := serve("PROPFIND", "/dav/sub/", "", map[string]string{"Depth": "1"}, pin); rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "<D:href>/dav/sub/new.txt</D:href>") {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPD_WebDAVConfig(t *testing.T) {
+	daemon := Daemon{
+		Address:           "127.0.0.1",
+		Port:              16252,
+		ServeDirectories:  map[string]string{"my/dir": "/tmp/test-laitos-dir"},
+		WebDAVDirectories: map[string]string{"/my/dir/": WebDAVReadWrite},
+		HandlerCollection: map[string]handler.Handler{},
+	}
+	if err := daemon.Initialise("", ""); err == nil || !strings.Contains(err.Error(), "password PIN") {
+		t.Fatal(err)
+	}
+	daemon.Processor = toolbox.GetTestCommandProcessor()
+	daemon.WebDAVDirectories = map[string]string{"my/dir": "write"}
+	if err := daemon.Initialise("", ""); err == nil || !strings.Contains(err.Error(), "mode") {
+		t.Fatal(err)
+	}
+	daemon.WebDAVDirectories = map[string]string{"other/dir": WebDAVReadOnly}
+	if err := daemon.Initialise("", ""); err == nil || !strings.Contains(err.Error(), "not among ServeDirectories") {
+		t.Fatal(err)
+	}
+	daemon.WebDAVDirectories = map[string]string{"/my/dir": WebDAVReadOnly}
+	if err := daemon.Initialise("", ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -29,6 +29,8 @@ const (
 	IOTimeoutSec         = 60               // If a conversation goes silent for this many seconds, the connection is terminated.
 	CommandTimeoutSec    = IOTimeoutSec - 1 // Command execution times out after this manys econds
 	RateLimitIntervalSec = 1                // Rate limit is calculated at 1 second interval
+
+	DefaultSessionIdleTimeoutSec = 10 * 60 // A TCP console session is disconnected after this many seconds of inactivity by default
 )
 
 // Daemon implements a Telnet-compatible service to provide unencrypted, plain-text access to all toolbox features, via both TCP and UDP.
@@ -39,6 +41,15 @@ type Daemon struct {
 	PerIPLimit int                       `json:"PerIPLimit"` // PerIPLimit is approximately how many concurrent users are expected to be using the server from same IP address
 	Processor  *toolbox.CommandProcessor `json:"-"`          // Feature command processor
 
+	/*
+		ConsoleMode offers TCP clients an interactive console that asks for password PIN once upon login, and then
+		accepts toolbox commands without PIN. The console puts telnet clients into character mode, echoes input, and
+		handles backspace. UDP conversations are not affected.
+	*/
+	ConsoleMode bool `json:"ConsoleMode"`
+	// SessionIdleTimeoutSec is the number of seconds of inactivity after which a TCP console session is disconnected.
+	SessionIdleTimeoutSec int `json:"SessionIdleTimeoutSec"`
+
 	tcpServer *common.TCPServer
 	udpServer *common.UDPServer
 }
@@ -57,6 +68,9 @@ func (daemon *Daemon) Initialise() error {
 	if daemon.PerIPLimit < 1 {
 		daemon.PerIPLimit = 3 // reasonable for personal use
 	}
+	if daemon.SessionIdleTimeoutSec < 1 {
+		daemon.SessionIdleTimeoutSec = DefaultSessionIdleTimeoutSec
+	}
 	if daemon.UDPPort < 1 && daemon.TCPPort < 1 {
 		// No reasonable defaults for these two, sorry.
 		return errors.New("plainsocket.Initialise: either or both TCP and UDP ports must be specified and be greater than 0")
@@ -74,6 +88,10 @@ func (daemon *Daemon) GetTCPStatsCollector() *misc.Stats {
 // HandleConnection converses with a TCP client.
 func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, conn *net.TCPConn) {
 	daemon.Processor.SetLogger(logger)
+	if daemon.ConsoleMode {
+		daemon.converseInConsole(logger, ip, conn)
+		return
+	}
 	// Allow up to 1MB of commands to be received per connection
 	reader := textproto.NewReader(bufio.NewReader(io.LimitReader(conn, 1*1048576)))
 	for {
@@ -118,6 +136,34 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, conn *
 	}
 }
 
+// converseInConsole converses with a TCP client in console mode.
+func (daemon *Daemon) converseInConsole(logger lalog.Logger, ip string, conn *net.TCPConn) {
+	if err := conn.SetWriteDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
+		return
+	} else if _, err := conn.Write(common.TelnetCharacterMode); err != nil {
+		return
+	}
+	session := common.ConsoleSession{
+		DaemonName:        "plainsocket",
+		ClientID:          ip,
+		Processor:         daemon.Processor,
+		Reader:            conn,
+		Writer:            conn,
+		Echo:              true,
+		CommandTimeoutSec: CommandTimeoutSec,
+		IdleTimeoutSec:    daemon.SessionIdleTimeoutSec,
+		SetReadDeadline:   conn.SetReadDeadline,
+		SetWriteDeadline:  conn.SetWriteDeadline,
+		AddAndCheckRateLimit: func() bool {
+			return daemon.tcpServer.AddAndCheckRateLimit(ip)
+		},
+		Logger: logger,
+	}
+	if err := session.Converse(); err != nil && err != io.EOF {
+		logger.Info("converseInConsole", ip, err, "console session ended")
+	}
+}
+
 // GetUDPStatsCollector returns stats collector for the UDP server of this daemon.
 func (daemon *Daemon) GetUDPStatsCollector() *misc.Stats {
 	return misc.PlainSocketStatsUDP
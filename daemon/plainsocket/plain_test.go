@@ -1,8 +1,14 @@
 package plainsocket
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/common"
 
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
@@ -35,3 +41,48 @@ func TestPlainTextDaemon(t *testing.T) {
 	}
 	TestServer(&daemon, t)
 }
+
+func TestPlainTextDaemon_ConsoleMode(t *testing.T) {
+	daemon := Daemon{
+		Address:     "127.0.0.1",
+		TCPPort:     32790,
+		PerIPLimit:  5,
+		ConsoleMode: true,
+		Processor:   toolbox.GetTestCommandProcessor(),
+	}
+	if err := daemon.Initialise(); err != nil || daemon.SessionIdleTimeoutSec != DefaultSessionIdleTimeoutSec {
+		t.Fatal(err)
+	}
+	daemon.SessionIdleTimeoutSec = 2
+	go func() {
+		if err := daemon.StartAndBlock(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer daemon.Stop()
+	time.Sleep(2 * time.Second)
+
+	client, err := net.Dial("tcp", "127.0.0.1:32790")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	reader := bufio.NewReader(client)
+	expect := func(expected string) {
+		buf := make([]byte, len(expected))
+		if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != expected {
+			t.Fatalf("expected %q, got %q - %v", expected, string(buf), err)
+		}
+	}
+	expect(string(common.TelnetCharacterMode) + common.ConsolePINPrompt)
+	if _, err := client.Write([]byte("verysecret\r\n.s echo hi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expect("**********\r\n" + common.ConsoleCommandPrompt + ".s echo hi\r\nhi\r\n" + common.ConsoleCommandPrompt)
+	// The idle session is disconnected
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("did not disconnect")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/daemon/common"
 	"github.com/HouzuoGuo/laitos/lalog"
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/testingstub"
@@ -27,8 +28,17 @@ const (
 	RateLimitIntervalSec = 1
 	// CommandTimeoutSec is the maximum duration allowed for a toolbox command to execute.
 	CommandTimeoutSec = 10 * 60
+	// DefaultSessionIdleTimeoutSec is the default number of seconds of inactivity after which a console session ends.
+	DefaultSessionIdleTimeoutSec = 10 * 60
 )
 
+// DeviceSettings are the communication parameters of serial devices. Each character is always made of 8 data bits.
+type DeviceSettings struct {
+	BaudRate int    `json:"BaudRate"` // BaudRate is the speed of communication, e.g. 9600 or 115200.
+	Parity   string `json:"Parity"`   // Parity is "none" (default), "odd", or "even".
+	StopBits int    `json:"StopBits"` // StopBits is either 1 (default) or 2.
+}
+
 // Daemon implements a server-side program to serve toolbox commands over serial communication made via the eligible devices.
 type Daemon struct {
 	/*
@@ -37,6 +47,19 @@ type Daemon struct {
 	*/
 	DeviceGlobPatterns []string `json:"DeviceGlobPatterns"`
 
+	/*
+		DeviceSettings maps some of the glob patterns to the communication parameters applied to the devices that match
+		them. Devices that match a pattern without settings are used as they are.
+	*/
+	DeviceSettings map[string]DeviceSettings `json:"DeviceSettings"`
+	/*
+		ConsoleMode offers an interactive console that echoes input, handles backspace, and asks for password PIN once
+		upon login, instead of reading a toolbox command (prefixed with PIN) from each line.
+	*/
+	ConsoleMode bool `json:"ConsoleMode"`
+	// SessionIdleTimeoutSec is the number of seconds of inactivity after which the console asks for password PIN again.
+	SessionIdleTimeoutSec int `json:"SessionIdleTimeoutSec"`
+
 	// PerDeviceLimit is the approximate number of requests allowed from a serial device within a designated interval.
 	PerDeviceLimit int `json:"PerDeviceLimit"`
 	// Processor is the toolbox command processor.
@@ -61,6 +84,9 @@ func (daemon *Daemon) Initialise() error {
 	if daemon.PerDeviceLimit < 1 {
 		daemon.PerDeviceLimit = 3 // reasonable for interactive usage
 	}
+	if daemon.SessionIdleTimeoutSec < 1 {
+		daemon.SessionIdleTimeoutSec = DefaultSessionIdleTimeoutSec
+	}
 
 	// Validate all patterns
 	for _, pattern := range daemon.DeviceGlobPatterns {
@@ -69,6 +95,24 @@ func (daemon *Daemon) Initialise() error {
 			return fmt.Errorf("serialport.Initialise: device glob pattern \"%s\" is malformed", pattern)
 		}
 	}
+	for pattern, settings := range daemon.DeviceSettings {
+		var found bool
+		for _, globPattern := range daemon.DeviceGlobPatterns {
+			found = found || globPattern == pattern
+		}
+		if !found {
+			return fmt.Errorf("serialport.Initialise: device settings refer to pattern \"%s\" that is not among the device glob patterns", pattern)
+		}
+		if settings.BaudRate < 1 {
+			return fmt.Errorf("serialport.Initialise: baud rate of pattern \"%s\" must be specified", pattern)
+		}
+		if settings.Parity != "" && settings.Parity != "none" && settings.Parity != "odd" && settings.Parity != "even" {
+			return fmt.Errorf("serialport.Initialise: parity of pattern \"%s\" must be none, odd, or even", pattern)
+		}
+		if settings.StopBits < 0 || settings.StopBits > 2 {
+			return fmt.Errorf("serialport.Initialise: stop bits of pattern \"%s\" must be either 1 or 2", pattern)
+		}
+	}
 
 	daemon.connectedDevices = make(map[string]chan bool)
 	daemon.connectedDevicesMutex = new(sync.Mutex)
@@ -109,7 +153,7 @@ func (daemon *Daemon) StartAndBlock() error {
 				daemon.logger.Warning("StartAndBlock", pattern, err, "failed to use the pattern to scan for serial devices")
 				continue // next pattern
 			}
-			daemon.connectToDevices(matches)
+			daemon.connectToDevices(pattern, matches)
 		}
 		// Sleep for the interval and continue scanning
 		select {
@@ -120,8 +164,11 @@ func (daemon *Daemon) StartAndBlock() error {
 	}
 }
 
-// connectToDevices looks for new device paths yet to be connected among the input array and start a processing loop dedicated to each new device.
-func (daemon *Daemon) connectToDevices(devicePaths []string) {
+/*
+connectToDevices looks for new device paths yet to be connected among the input array and start a processing loop dedicated to each new device.
+The device paths are the matches of the glob pattern.
+*/
+func (daemon *Daemon) connectToDevices(pattern string, devicePaths []string) {
 	daemon.connectedDevicesMutex.Lock()
 	defer daemon.connectedDevicesMutex.Unlock()
 	for _, dev := range devicePaths {
@@ -129,7 +176,7 @@ func (daemon *Daemon) connectToDevices(devicePaths []string) {
 			// Conversation may be stopped by either explicit daemon termination or IO error, hence there are maximum of two bufferd stop signals.
 			stopChan := make(chan bool, 2)
 			daemon.connectedDevices[dev] = stopChan
-			go daemon.converseWithDevice(dev, stopChan)
+			go daemon.converseWithDevice(pattern, dev, stopChan)
 		}
 	}
 }
@@ -138,7 +185,7 @@ func (daemon *Daemon) connectToDevices(devicePaths []string) {
 converseWithDevice continuously proceses toolbox commands input from the serial device in a loop, and terminates when the channel is notified by
 either termination of daemon or device IO error.
 */
-func (daemon *Daemon) converseWithDevice(devPath string, stopChan chan bool) {
+func (daemon *Daemon) converseWithDevice(pattern, devPath string, stopChan chan bool) {
 	// Put processing duration (including IO time) into statistics
 	beginTimeNano := time.Now().UnixNano()
 	daemon.logger.Info("converseWithDevice", devPath, nil, "beginning conversation")
@@ -161,6 +208,17 @@ func (daemon *Daemon) converseWithDevice(devPath string, stopChan chan bool) {
 	defer func() {
 		daemon.logger.MaybeMinorError(devFile.Close())
 	}()
+	if settings, found := daemon.DeviceSettings[pattern]; found {
+		if err := setDeviceParameters(devFile, settings); err != nil {
+			daemon.logger.Warning("converseWithDevice", devPath, err, "failed to configure communication parameters")
+			return
+		}
+	}
+	if daemon.ConsoleMode {
+		go daemon.runConsole(devPath, devFile, stopChan)
+		<-stopChan
+		return
+	}
 	// Converse with the device in a background routine, signal stopChan to terminate the conversation in case of IO error.
 	go func() {
 		for {
@@ -204,6 +262,29 @@ func (daemon *Daemon) converseWithDevice(devPath string, stopChan chan bool) {
 	<-stopChan
 }
 
+// runConsole converses with the device in console mode, and signals stopChan when the conversation ends.
+func (daemon *Daemon) runConsole(devPath string, devFile *os.File, stopChan chan bool) {
+	session := common.ConsoleSession{
+		DaemonName: "serialport",
+		ClientID:   devPath,
+		Processor:  daemon.Processor,
+		Reader:     devFile,
+		Writer:     slowWriter{devFile},
+		// Serial terminals rarely echo input by themselves
+		Echo:              true,
+		CommandTimeoutSec: CommandTimeoutSec,
+		IdleTimeoutSec:    daemon.SessionIdleTimeoutSec,
+		AddAndCheckRateLimit: func() bool {
+			return daemon.rateLimit.Add(devPath, true)
+		},
+		MaxLineLength: MaxCommandLength,
+		Logger:        daemon.logger,
+	}
+	err := session.Converse()
+	daemon.logger.Warning("runConsole", devPath, err, "console session ended")
+	stopChan <- true
+}
+
 // Stop stops accepting new device connections and then disconnects all ongoing conversations with connected serial devices.
 func (daemon *Daemon) Stop() {
 	if !daemon.loopIsRunning {
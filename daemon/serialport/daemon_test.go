@@ -12,6 +12,26 @@ func TestSerialPortDaemon(t *testing.T) {
 	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), toolbox.ErrBadProcessorConfig) {
 		t.Fatal(err)
 	}
+	// Device settings must refer to a glob pattern and carry valid parameters
+	daemon.Processor = toolbox.GetTestCommandProcessor()
+	daemon.DeviceGlobPatterns = []string{"/dev/ttyS*"}
+	for _, settings := range []map[string]DeviceSettings{
+		{"/dev/ttyUSB*": {BaudRate: 9600}},
+		{"/dev/ttyS*": {}},
+		{"/dev/ttyS*": {BaudRate: 9600, Parity: "mark"}},
+		{"/dev/ttyS*": {BaudRate: 9600, StopBits: 3}},
+	} {
+		daemon.DeviceSettings = settings
+		if err := daemon.Initialise(); err == nil {
+			t.Fatal("did not error", settings)
+		}
+	}
+	daemon.DeviceSettings = map[string]DeviceSettings{"/dev/ttyS*": {BaudRate: 9600, Parity: "even", StopBits: 2}}
+	if err := daemon.Initialise(); err != nil || daemon.SessionIdleTimeoutSec != DefaultSessionIdleTimeoutSec {
+		t.Fatal(err)
+	}
+	daemon.DeviceGlobPatterns = nil
+	daemon.DeviceSettings = nil
 	// Good processor but empty patterns is acceptable
 	daemon.Processor = toolbox.GetTestCommandProcessor()
 	if err := daemon.Initialise(); err != nil || daemon.PerDeviceLimit != 3 {
@@ -5,6 +5,8 @@ import (
 	"io"
 	"os"
 	"time"
+
+	"github.com/HouzuoGuo/laitos/platform"
 )
 
 /*
@@ -62,3 +64,31 @@ func writeSlowly(dst *os.File, data []byte) (err error) {
 	}
 	return nil
 }
+
+// slowWriter is an io.Writer that writes to the file slowly, see writeSlowly.
+type slowWriter struct {
+	file *os.File
+}
+
+func (writer slowWriter) Write(data []byte) (int, error) {
+	if err := writeSlowly(writer.file, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// setDeviceParameters configures baud rate, parity, and stop bits of the serial device.
+func setDeviceParameters(devFile *os.File, settings DeviceSettings) error {
+	// Unlike Fd, SyscallConn does not put the file into blocking mode, hence the file can still be closed during a read.
+	rawConn, err := devFile.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		setErr = platform.SetSerialParameters(fd, settings.BaudRate, settings.Parity, settings.StopBits)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
@@ -48,3 +48,21 @@ func TestWriteSlowly(t *testing.T) {
 		t.Fatal(durationSec)
 	}
 }
+
+func TestSetDeviceParameters(t *testing.T) {
+	fh, err := ioutil.TempFile("", "laitos-TestSetDeviceParameters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fh.Close()
+		_ = os.Remove(fh.Name())
+	}()
+	// An ordinary file is not a serial device
+	if err := setDeviceParameters(fh, DeviceSettings{BaudRate: 9600}); err == nil {
+		t.Fatal("did not error")
+	}
+	if err := setDeviceParameters(fh, DeviceSettings{BaudRate: 1234}); err == nil {
+		t.Fatal("did not error")
+	}
+}
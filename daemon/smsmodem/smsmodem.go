@@ -0,0 +1,217 @@
+/*
+smsmodem runs toolbox commands sent via SMS to a GSM/LTE modem connected to a serial device, and replies to the
+senders with command results via SMS.
+*/
+package smsmodem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+	"github.com/HouzuoGuo/laitos/testingstub"
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+const (
+	// CommandTimeoutSec is the maximum duration allowed for a toolbox command to execute.
+	CommandTimeoutSec = 30
+	/*
+		PollIntervalSec is the interval at which the daemon looks for new messages. The modem also notifies arrival of
+		new messages, the polling makes sure that messages will not be missed if the notification does not arrive.
+	*/
+	PollIntervalSec = 30
+	// RateLimitIntervalSec is the interval at which the number of messages from each sender is measured.
+	RateLimitIntervalSec = 60
+)
+
+// Daemon runs toolbox commands sent via SMS to a modem, and replies to the senders with command results.
+type Daemon struct {
+	DevicePath     string                    `json:"DevicePath"`     // DevicePath is the serial device of the modem, e.g. /dev/ttyUSB0.
+	BaudRate       int                       `json:"BaudRate"`       // BaudRate is the serial communication speed, it is 115200 by default.
+	PerNumberLimit int                       `json:"PerNumberLimit"` // PerNumberLimit is the number of messages processed from each sender in a minute.
+	Processor      *toolbox.CommandProcessor `json:"-"`              // Processor is the toolbox command processor.
+
+	rateLimit     *misc.RateLimit
+	loopIsRunning int32     // Value is 1 only when message loop is running
+	stop          chan bool // Signal message loop to stop
+	logger        lalog.Logger
+}
+
+// Initialise validates the daemon configuration and initialises internal states.
+func (daemon *Daemon) Initialise() error {
+	if daemon.PerNumberLimit < 1 {
+		daemon.PerNumberLimit = 3 // reasonable for personal use
+	}
+	daemon.logger = lalog.Logger{ComponentName: "smsmodem", ComponentID: []lalog.LoggerIDField{{Key: "Dev", Value: daemon.DevicePath}}}
+	if daemon.Processor == nil || daemon.Processor.IsEmpty() {
+		return fmt.Errorf("smsmodem.Initialise: command processor and its filters must be configured")
+	}
+	daemon.Processor.SetLogger(daemon.logger)
+	if errs := daemon.Processor.IsSaneForInternet(); len(errs) > 0 {
+		return fmt.Errorf("smsmodem.Initialise: %+v", errs)
+	}
+	if daemon.DevicePath == "" {
+		return errors.New("smsmodem.Initialise: DevicePath must not be empty")
+	}
+	daemon.rateLimit = &misc.RateLimit{
+		UnitSecs: RateLimitIntervalSec,
+		MaxCount: daemon.PerNumberLimit,
+		Logger:   daemon.logger,
+	}
+	daemon.rateLimit.Initialise()
+	daemon.stop = make(chan bool)
+	return nil
+}
+
+/*
+processMessages runs toolbox commands from the messages stored on modem and replies to the senders. Each message is
+deleted before its command runs, so that the command will not run again even if the processing fails.
+*/
+func (daemon *Daemon) processMessages(ctx context.Context, modem *toolbox.Modem) error {
+	messages, err := modem.ListSMS()
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		// Only process received messages, leave the outgoing messages stored on modem alone.
+		if !strings.HasPrefix(msg.Status, "REC") {
+			continue
+		}
+		beginTimeNano := time.Now().UnixNano()
+		if err := modem.DeleteSMS(msg.Index); err != nil {
+			daemon.logger.Warning("processMessages", msg.Sender, err, "failed to delete message %d", msg.Index)
+			continue
+		}
+		if !daemon.rateLimit.Add(msg.Sender, true) {
+			continue
+		}
+		text := strings.TrimSpace(msg.Text)
+		if text == "" {
+			continue
+		}
+		result := daemon.Processor.Process(ctx, toolbox.Command{
+			DaemonName: "smsmodem",
+			ClientID:   msg.Sender,
+			Content:    text,
+			TimeoutSec: CommandTimeoutSec,
+		}, true)
+		// Do not spend money on replying to strangers, nor let them know of the existence of this service.
+		if result.Error == toolbox.ErrPINAndShortcutNotFound {
+			daemon.logger.Info("processMessages", msg.Sender, nil, "ignored message without a PIN or shortcut match")
+			continue
+		}
+		if _, err := modem.SendSMS(msg.Sender, truncateSMS(result.CombinedOutput)); err != nil {
+			daemon.logger.Warning("processMessages", msg.Sender, err, "failed to reply")
+		}
+		misc.SMSModemStats.Trigger(float64(time.Now().UnixNano() - beginTimeNano))
+	}
+	return nil
+}
+
+// truncateSMS returns the longest beginning of the text that fits into an SMS, without cutting a character in half.
+func truncateSMS(text string) string {
+	if len(text) <= toolbox.MaxSMSLength {
+		return text
+	}
+	cut := toolbox.MaxSMSLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+// StartAndBlock opens the modem and processes incoming messages until the daemon is stopped.
+func (daemon *Daemon) StartAndBlock() error {
+	modem, err := toolbox.OpenModem(daemon.DevicePath, daemon.BaudRate)
+	if err != nil {
+		return fmt.Errorf("smsmodem.StartAndBlock: %v", err)
+	}
+	defer func() {
+		atomic.StoreInt32(&daemon.loopIsRunning, 0)
+		daemon.logger.MaybeMinorError(modem.Close())
+	}()
+	daemon.logger.Info("StartAndBlock", "", nil, "going to process messages")
+	atomic.StoreInt32(&daemon.loopIsRunning, 1)
+	for {
+		if misc.EmergencyLockDown {
+			daemon.logger.Warning("StartAndBlock", "", misc.ErrEmergencyLockDown, "")
+			return misc.ErrEmergencyLockDown
+		}
+		if err := daemon.processMessages(context.Background(), modem); err == toolbox.ErrModemClosed {
+			return fmt.Errorf("smsmodem.StartAndBlock: %v", err)
+		} else if err != nil {
+			daemon.logger.Warning("StartAndBlock", "", err, "failed to process messages")
+		}
+		select {
+		case <-daemon.stop:
+			return nil
+		case <-modem.NewMessage():
+		case <-time.After(PollIntervalSec * time.Second):
+		}
+	}
+}
+
+// Stop previously started message processing loop.
+func (daemon *Daemon) Stop() {
+	if atomic.CompareAndSwapInt32(&daemon.loopIsRunning, 1, 0) {
+		daemon.stop <- true
+	}
+}
+
+// TestSMSModem runs unit tests on the daemon against a fake modem.
+func TestSMSModem(daemon *Daemon, t testingstub.T) {
+	fake, err := toolbox.StartFakeModem()
+	if err != nil {
+		t.Skip(err)
+		return
+	}
+	defer func() {
+		_ = fake.Close()
+	}()
+	// Reinitialise daemon to use the fake modem
+	oldDevicePath := daemon.DevicePath
+	daemon.DevicePath = fake.DevicePath
+	defer func() {
+		daemon.DevicePath = oldDevicePath
+	}()
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// A message that arrives before the daemon starts should be processed too
+	fake.ReceiveSMS("+123", toolbox.TestCommandProcessorPIN+".s echo hi")
+	var stoppedNormally bool
+	go func() {
+		if err := daemon.StartAndBlock(); err != nil {
+			t.Fatal(err)
+		}
+		stoppedNormally = true
+	}()
+	time.Sleep(2 * time.Second)
+	// A message without PIN does not get a reply
+	fake.ReceiveSMS("+456", "pin mismatch")
+	fake.ReceiveSMS("+789", toolbox.TestCommandProcessorPIN+".s echo bye")
+	time.Sleep(2 * time.Second)
+	sent := fake.GetSentSMS()
+	if len(sent) != 2 || sent[0].Sender != "+123" || sent[0].Text != "hi" || sent[1].Sender != "+789" || sent[1].Text != "bye" {
+		t.Fatalf("%+v", sent)
+	}
+	if stored := fake.GetStoredSMS(); len(stored) != 0 {
+		t.Fatalf("%+v", stored)
+	}
+	// Daemon should stop within a second
+	daemon.Stop()
+	time.Sleep(1 * time.Second)
+	if !stoppedNormally {
+		t.Fatal("did not stop")
+	}
+	// Repeatedly stopping the daemon should have no negative consequence
+	daemon.Stop()
+	daemon.Stop()
+}
@@ -0,0 +1,41 @@
+package smsmodem
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/toolbox"
+)
+
+func TestSMSModemDaemon(t *testing.T) {
+	daemon := Daemon{}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "filters must be configured") {
+		t.Fatal(err)
+	}
+	daemon.Processor = toolbox.GetInsaneCommandProcessor()
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), toolbox.ErrBadProcessorConfig) {
+		t.Fatal(err)
+	}
+	daemon.Processor = toolbox.GetTestCommandProcessor()
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "DevicePath") {
+		t.Fatal(err)
+	}
+	daemon.DevicePath = "/dev/ttyUSB0"
+	if err := daemon.Initialise(); err != nil || daemon.PerNumberLimit != 3 {
+		t.Fatal(err)
+	}
+	TestSMSModem(&daemon, t)
+}
+
+func TestTruncateSMS(t *testing.T) {
+	if text := truncateSMS("hello"); text != "hello" {
+		t.Fatal(text)
+	}
+	// A multi-byte character is not cut in half
+	if text := truncateSMS("a" + strings.Repeat("é", 100)); text != "a"+strings.Repeat("é", 79) {
+		t.Fatal(text)
+	}
+	if text := truncateSMS(strings.Repeat("é", 100)); text != strings.Repeat("é", 80) {
+		t.Fatal(text)
+	}
+}
@@ -1,6 +1,7 @@
 package mailcmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -13,23 +14,32 @@ import (
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/testingstub"
 	"github.com/HouzuoGuo/laitos/toolbox"
+	"golang.org/x/crypto/openpgp"
 )
 
-const CommandTimeoutSec = 120 // CommandTimeoutSec is the default command timeout in seconds
+const (
+	CommandTimeoutSec  = 120 // CommandTimeoutSec is the default command timeout in seconds
+	MaxCommandsPerMail = 10  // MaxCommandsPerMail is the maximum number of commands to run from a single incoming mail
+)
 
 /*
-CommandRunner looks for exactly one feature command from an incoming mail, runs it and reply the sender with command
-results. Usually used in combination of laitos' own SMTP daemon, but it can also work independently with another MTA
+CommandRunner looks for feature commands among the lines of an incoming mail, runs them in sequence and reply the sender
+with command results, along with files (such as screenshots) produced by the commands. Files attached to the incoming
+mail are kept in the file upload storage. Usually used in combination of laitos' own SMTP daemon, but it can also work independently with another MTA
 such as the forwarding-mail-to-program mechanism from postfix.
 */
 type CommandRunner struct {
 	Undocumented1   Undocumented1             `json:"Undocumented1"` // Intentionally undocumented he he he he
 	Undocumented2   Undocumented2             `json:"Undocumented2"` // Intentionally undocumented he he he he
 	Undocumented3   Undocumented3             `json:"Undocumented3"` // Intentionally undocumented he he he he
+	PGP             PGP                       `json:"PGP"`           // PGP signature verification, decryption, and reply encryption
 	Processor       *toolbox.CommandProcessor `json:"-"`             // Feature configuration
 	ReplyMailClient inet.MailClient           `json:"-"`             // To deliver Email replies
 	logger          lalog.Logger
 
+	// signedMailProcessor runs commands from mails signed by a trusted PGP key, it does not expect a password PIN.
+	signedMailProcessor *toolbox.CommandProcessor
+
 	// processTestCaseFunc works along side of command processing routine, it offers execution result to test case for inspection.
 	processTestCaseFunc func(*toolbox.Result)
 }
@@ -49,6 +59,23 @@ func (runner *CommandRunner) Initialise() error {
 	if errs := runner.Processor.IsSaneForInternet(); len(errs) > 0 {
 		return fmt.Errorf("mailcmd.Process: %+v", errs)
 	}
+	if runner.PGP.IsConfigured() {
+		if err := runner.PGP.Initialise(); err != nil {
+			return fmt.Errorf("mailcmd.Initialise: %v", err)
+		}
+		// The PGP signature replaces password PIN, the remaining filters stay in place.
+		runner.signedMailProcessor = &toolbox.CommandProcessor{
+			Features:      runner.Processor.Features,
+			ResultFilters: runner.Processor.ResultFilters,
+			MaxCmdPerSec:  runner.Processor.MaxCmdPerSec,
+		}
+		for _, filter := range runner.Processor.CommandFilters {
+			if _, isPIN := filter.(*toolbox.PINAndShortcuts); !isPIN {
+				runner.signedMailProcessor.CommandFilters = append(runner.signedMailProcessor.CommandFilters, filter)
+			}
+		}
+		runner.signedMailProcessor.SetLogger(runner.logger)
+	}
 	return nil
 }
 
@@ -108,86 +135,215 @@ func (runner *CommandRunner) SelfTest() error {
 	return errors.New(strings.Join(ret, " | "))
 }
 
+// getCommandLines returns the non-empty lines of mail text parts, among which app commands are to be found.
+func getCommandLines(parts []inet.MailPart) (lines []string) {
+	// Prefer plain text parts, for an HTML alternative usually repeats the same text with markup.
+	for _, wantPlainText := range []bool{true, false} {
+		for _, part := range parts {
+			if part.IsAttachment() || !strings.HasPrefix(part.ContentType, "text/") || (part.ContentType == "text/plain") != wantPlainText {
+				continue
+			}
+			for _, line := range strings.Split(string(part.Body), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					lines = append(lines, line)
+				}
+			}
+		}
+		if len(lines) > 0 {
+			return
+		}
+	}
+	return
+}
+
+// formatReply returns the text of reply made of the results of all commands and the names of stored attachments.
+func formatReply(results []*toolbox.Result, storedFiles []string) string {
+	var reply strings.Builder
+	if len(results) == 1 {
+		reply.WriteString(results[0].CombinedOutput)
+	} else {
+		for i, result := range results {
+			if i > 0 {
+				reply.WriteString("\n\n")
+			}
+			reply.WriteString(fmt.Sprintf("%d. %s\n%s", i+1, result.Command.Content, result.CombinedOutput))
+		}
+	}
+	if len(storedFiles) > 0 {
+		reply.WriteString(fmt.Sprintf("\n\nStored attachments for %d hours: %s", misc.FileUploadExpireInSec/3600, strings.Join(storedFiles, ", ")))
+	}
+	return reply.String()
+}
+
+// storeAttachments saves attached files of the incoming mail in file upload storage and returns descriptions of outcome.
+func (runner *CommandRunner) storeAttachments(prop inet.BasicMail, parts []inet.MailPart) (storedFiles []string) {
+	for _, part := range parts {
+		if !part.IsAttachment() {
+			continue
+		}
+		storedName, err := misc.StoreUploadedFile(part.FileName, bytes.NewReader(part.Body))
+		runner.logger.Info("storeAttachments", prop.FromAddress, err, "store attachment \"%s\" as \"%s\"", part.FileName, storedName)
+		if err == nil {
+			storedFiles = append(storedFiles, fmt.Sprintf("%s => %s", part.FileName, storedName))
+		} else {
+			storedFiles = append(storedFiles, fmt.Sprintf("%s => %v", part.FileName, err))
+		}
+	}
+	return
+}
+
+// getResultAttachments returns the files produced by commands as mail attachments.
+func getResultAttachments(results []*toolbox.Result) []inet.MailPart {
+	attachments := make([]inet.MailPart, 0)
+	for _, result := range results {
+		for _, attachment := range result.Attachments {
+			attachments = append(attachments, inet.MailPart{ContentType: attachment.ContentType, FileName: attachment.FileName, Body: attachment.Content})
+		}
+	}
+	return attachments
+}
+
+/*
+sendEncryptedReply encrypts the reply to the public key of mail signer or sender, and then sends the reply. The reply
+subject does not reveal the commands.
+*/
+func (runner *CommandRunner) sendEncryptedReply(prop inet.BasicMail, signer *openpgp.Entity, replyText string, results []*toolbox.Result, replyAddresses []string) error {
+	recipientKey := runner.PGP.findRecipientKey(signer, prop.ReplyAddress)
+	if recipientKey == nil {
+		return ErrPGPRecipientKeyNotFound
+	}
+	if !runner.ReplyMailClient.IsConfigured() {
+		return errors.New("the reply has to be sent via Email but configuration is missing")
+	}
+	recipients := replyAddresses
+	if len(recipients) == 0 {
+		recipients = []string{prop.ReplyAddress}
+	}
+	entity, err := inet.BuildMailEntity(replyText, getResultAttachments(results))
+	if err != nil {
+		return err
+	}
+	encryptedMail, err := runner.PGP.encryptReply(runner.ReplyMailClient.MailFrom, inet.OutgoingMailSubjectKeyword+"-reply", recipients, recipientKey, entity)
+	if err != nil {
+		return fmt.Errorf("mailcmd.sendEncryptedReply: failed to encrypt reply - %v", err)
+	}
+	return runner.ReplyMailClient.SendRaw(runner.ReplyMailClient.MailFrom, encryptedMail, recipients...)
+}
+
 /*
 Make sure mail processor is sane before processing the incoming mail.
-Process only one command (if found) in the incoming mail. If reply addresses are specified, send command result
-to the specified addresses. If they are not specified, use the incoming mail sender's address as reply address.
+Process up to MaxCommandsPerMail commands found among the lines of text parts of the incoming mail, one command per
+line, and in the order of appearance. If PGP is configured, the mail may be encrypted, and a mail signed by a trusted
+key runs the commands without password PIN - in which case each command line starts with a trigger prefix. If a
+command is found, the files attached to the mail are stored in the file
+upload storage. If reply addresses are specified, send command results and files produced by commands to the specified
+addresses. If they are not specified, use the incoming mail sender's address as reply address.
 */
 func (runner *CommandRunner) Process(clientIP string, mailContent []byte, replyAddresses ...string) error {
 	if misc.EmergencyLockDown {
 		return misc.ErrEmergencyLockDown
 	}
-	var commandIsProcessed bool
-	walkErr := inet.WalkMailMessage(mailContent, func(prop inet.BasicMail, body []byte) (bool, error) {
-		// Avoid recursive processing
-		if strings.Contains(prop.Subject, inet.OutgoingMailSubjectKeyword) {
-			return false, errors.New("ignore email sent by this program itself")
+	prop, _, err := inet.ReadMailMessage(mailContent)
+	if err != nil {
+		return err
+	}
+	// Avoid recursive processing
+	if strings.Contains(prop.Subject, inet.OutgoingMailSubjectKeyword) {
+		return errors.New("ignore email sent by this program itself")
+	}
+	runner.logger.Info("Process", prop.FromAddress, nil, "process message of type %s, subject \"%s\"", prop.ContentType, prop.Subject)
+	// Verify signature and decrypt the mail content
+	processor := runner.Processor
+	var signer *openpgp.Entity
+	if runner.PGP.IsConfigured() {
+		if mailContent, signer, err = runner.PGP.unwrap(mailContent, 0); err != nil {
+			runner.logger.Warning("Process", prop.FromAddress, err, "failed to process PGP content")
+			return err
+		}
+		if runner.PGP.TrustedKeyringFile != "" {
+			if signer == nil {
+				return ErrPGPSignatureRequired
+			}
+			processor = runner.signedMailProcessor
+			runner.logger.Info("Process", prop.FromAddress, nil, "the mail is signed by PGP key %s", signer.PrimaryKey.KeyIdString())
+			// Whoever is able to alter the unsigned headers must not be able to redirect the reply
+			if prop.ReplyAddress = signerAddress(signer, prop.ReplyAddress); prop.ReplyAddress == "" {
+				return ErrPGPSignerAddressNotFound
+			}
+		}
+	}
+	_, parts, err := inet.ReadMailParts(mailContent)
+	if err != nil {
+		return err
+	}
+	results := make([]*toolbox.Result, 0, 1)
+	for _, line := range getCommandLines(parts) {
+		// Without password PIN, a command line must begin with a trigger prefix.
+		if signer != nil && !strings.HasPrefix(line, ".") {
+			continue
 		}
-		runner.logger.Info("Process", prop.FromAddress, nil, "process message of type %s, subject \"%s\"", prop.ContentType, prop.Subject)
 		// By contract, PIN processor finds command among input lines.
-		result := runner.Processor.Process(context.TODO(), toolbox.Command{
+		result := processor.Process(context.TODO(), toolbox.Command{
 			DaemonName: "smtpd",
 			ClientID:   clientIP,
-			Content:    string(body),
+			Content:    line,
 			TimeoutSec: CommandTimeoutSec,
 		}, true)
 		// Offer execution result to test case for inspection
 		if runner.processTestCaseFunc != nil {
 			runner.processTestCaseFunc(result)
 		}
-		// If this part does not have a PIN/shortcut match, simply move on to the next part.
+		// If this line does not have a PIN/shortcut match, simply move on to the next line.
 		if result.Error == toolbox.ErrPINAndShortcutNotFound {
-			// Move on, do not return error.
-			return true, nil
-		} else if result.Error != nil {
-			// In case of command processing error, do not move on, return the error.
-			return false, result.Error
+			continue
+		} else if result.Error == toolbox.ErrRateLimitExceeded || result.Error == misc.ErrEmergencyLockDown {
+			return result.Error
 		}
-		// A command has been processed, now work on the reply.
-		commandIsProcessed = true
-		// Normally the result should be sent as Email reply, but there are undocumented scenarios.
-		if runner.Undocumented1.MayReplyTo(prop) {
-			runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented1")
-			if undocErr := runner.Undocumented1.SendMessage(result.CombinedOutput); undocErr == nil {
-				return false, nil
-			} else {
-				return false, undocErr
-			}
+		results = append(results, result)
+		if len(results) >= MaxCommandsPerMail {
+			runner.logger.Info("Process", prop.FromAddress, nil, "ignore the remaining lines after running %d commands", len(results))
+			break
 		}
-		if runner.Undocumented2.MayReplyTo(prop) {
-			runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented2")
-			if undocErr := runner.Undocumented2.SendMessage(result.CombinedOutput); undocErr == nil {
-				return false, nil
-			} else {
-				return false, undocErr
-			}
-		}
-		if runner.Undocumented3.MayReplyTo(prop) {
-			runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented3")
-			if undocErr := runner.Undocumented3.SendMessage(prop, result.CombinedOutput); undocErr == nil {
-				return false, nil
-			} else {
-				return false, undocErr
-			}
-		}
-		// The Email address suffix did not satisfy undocumented scenario, so send the result as a normal Email reply.
-		if !runner.ReplyMailClient.IsConfigured() {
-			return false, errors.New("the reply has to be sent via Email but configuration is missing")
-		}
-		recipients := replyAddresses
-		if len(recipients) == 0 {
-			recipients = []string{prop.ReplyAddress}
-		}
-		return false, runner.ReplyMailClient.Send(inet.OutgoingMailSubjectKeyword+"-reply-"+result.Command.Content, result.CombinedOutput, recipients...)
-	})
-	if walkErr != nil {
-		return walkErr
 	}
-	// If all parts have been visited but no command is found, return the PIN mismatch error.
-	if !commandIsProcessed {
+	// If all lines have been visited but no command is found, return the PIN mismatch error.
+	if len(results) == 0 {
 		return toolbox.ErrPINAndShortcutNotFound
 	}
-	return nil
+	// Commands have been processed, now the sender is trusted to store attachments.
+	replyText := formatReply(results, runner.storeAttachments(prop, parts))
+	if runner.PGP.EncryptReply {
+		return runner.sendEncryptedReply(prop, signer, replyText, results, replyAddresses)
+	}
+	// Normally the result should be sent as Email reply, but there are undocumented scenarios.
+	if runner.Undocumented1.MayReplyTo(prop) {
+		runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented1")
+		return runner.Undocumented1.SendMessage(replyText)
+	}
+	if runner.Undocumented2.MayReplyTo(prop) {
+		runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented2")
+		return runner.Undocumented2.SendMessage(replyText)
+	}
+	if runner.Undocumented3.MayReplyTo(prop) {
+		runner.logger.Info("Process", prop.FromAddress, nil, "invoke Undocumented3")
+		return runner.Undocumented3.SendMessage(prop, replyText)
+	}
+	// The Email address suffix did not satisfy undocumented scenario, so send the result as a normal Email reply.
+	if !runner.ReplyMailClient.IsConfigured() {
+		return errors.New("the reply has to be sent via Email but configuration is missing")
+	}
+	recipients := replyAddresses
+	if len(recipients) == 0 {
+		recipients = []string{prop.ReplyAddress}
+	}
+	subject := inet.OutgoingMailSubjectKeyword + "-reply-" + results[0].Command.Content
+	if len(results) > 1 {
+		subject = fmt.Sprintf("%s-reply-%d-commands", inet.OutgoingMailSubjectKeyword, len(results))
+	}
+	attachments := getResultAttachments(results)
+	if len(attachments) == 0 {
+		return runner.ReplyMailClient.Send(subject, replyText, recipients...)
+	}
+	return runner.ReplyMailClient.SendWithAttachments(subject, replyText, attachments, recipients...)
 }
 
 var (
@@ -1,11 +1,14 @@
 package mailcmd
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/toolbox"
 )
 
@@ -32,6 +35,77 @@ func TestMailProcessor_Process(t *testing.T) {
 	TestCommandRunner(&runner, t)
 }
 
+func TestMailProcessor_Process_MultipleCommands(t *testing.T) {
+	runner := CommandRunner{
+		Processor: toolbox.GetTestCommandProcessor(),
+		ReplyMailClient: inet.MailClient{
+			MTAHost:  "127.0.0.1",
+			MTAPort:  25,
+			MailFrom: "howard@localhost",
+		},
+	}
+	if err := runner.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	var results []*toolbox.Result
+	runner.processTestCaseFunc = func(result *toolbox.Result) {
+		results = append(results, result)
+	}
+	mail := "From: howard@localhost\r\n" +
+		"Subject: hi howard\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"PIN mismatch\r\n" +
+		"verysecret.s echo first\r\n" +
+		"\r\n" +
+		"verysecret.s echo second\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>verysecret.s echo html</p>\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=\"a.txt\"\r\n" +
+		"\r\n" +
+		"verysecret.s echo attachment\r\n" +
+		"--outer--\r\n"
+	if err := runner.Process("", []byte(mail)); err != nil {
+		t.Fatal(err)
+	}
+	// The HTML alternative and the attachment must not be looked into for commands
+	if len(results) != 3 || results[0].Error != toolbox.ErrPINAndShortcutNotFound ||
+		strings.TrimSpace(results[1].CombinedOutput) != "first" || strings.TrimSpace(results[2].CombinedOutput) != "second" {
+		t.Fatalf("%+v", results)
+	}
+	// Both command results are in the reply
+	reply := formatReply(results[1:], nil)
+	if !strings.Contains(reply, "1. .s echo first\nfirst") || !strings.Contains(reply, "2. .s echo second\nsecond") {
+		t.Fatal(reply)
+	}
+	// A single command result is replied as-is
+	if reply := formatReply(results[1:2], nil); strings.TrimSpace(reply) != "first" {
+		t.Fatal(reply)
+	}
+
+	// Store the attachment
+	stored := runner.storeAttachments(inet.BasicMail{}, []inet.MailPart{{ContentType: "text/plain", FileName: "a.txt", Body: []byte("content")}})
+	if len(stored) != 1 || !strings.HasPrefix(stored[0], "a.txt => ") || !strings.HasSuffix(stored[0], ".txt") {
+		t.Fatal(stored)
+	}
+	storedPath, err := misc.GetUploadedFilePath(strings.TrimPrefix(stored[0], "a.txt => "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(storedPath)
+	if content, err := ioutil.ReadFile(storedPath); err != nil || string(content) != "content" {
+		t.Fatal(err, string(content))
+	}
+}
+
 func TestMailProcessor_Process_Undocumented1Reply(t *testing.T) {
 	if TestUndocumented1Message == "" {
 		t.Log("skip because TestUndocumented1Message is empty")
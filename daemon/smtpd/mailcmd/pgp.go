@@ -0,0 +1,455 @@
+package mailcmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/misc"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const (
+	// MaxPGPNestingDepth is the maximum number of PGP/MIME layers (e.g. signed inside encrypted) that will be unwrapped.
+	MaxPGPNestingDepth = 3
+	// PGPSignatureMaxAgeSec is the maximum age of a signature, mails signed earlier than this are rejected as replays.
+	PGPSignatureMaxAgeSec = 24 * 3600
+	// PGPSignatureMaxSkewSec tolerates the difference between the clocks of mail sender and laitos server.
+	PGPSignatureMaxSkewSec = 10 * 60
+)
+
+var (
+	// ErrPGPSignatureRequired is returned when an incoming mail is not signed by any of the trusted keys.
+	ErrPGPSignatureRequired = errors.New("the mail must be signed by a trusted PGP key")
+	// ErrPGPRecipientKeyNotFound is returned when a reply is to be encrypted but the recipient's public key is unknown.
+	ErrPGPRecipientKeyNotFound = errors.New("cannot find a PGP key to encrypt the reply with")
+	// ErrPGPSignatureReplayed is returned when a signed mail has been processed before.
+	ErrPGPSignatureReplayed = errors.New("the signed mail has been processed before")
+	// ErrPGPSignerAddressNotFound is returned when the key that signed an incoming mail does not carry a mail address.
+	ErrPGPSignerAddressNotFound = errors.New("the PGP key of the signer does not carry a mail address to reply to")
+)
+
+/*
+PGP enables the mail command channel to verify PGP/MIME signature of incoming mails, decrypt PGP encrypted incoming
+mails, and encrypt replies.
+
+A signed mail is only accepted within PGPSignatureMaxAgeSec of its signature creation time, and only once during that
+period, so that an eavesdropper on the mail relays cannot replay the commands.
+
+The implementation uses golang.org/x/crypto/openpgp, which is frozen and only receives security fixes. It remains
+adequate for the purpose, as the mails come from the owner's own keys, and the package verifies and decrypts the
+RSA, DSA, and ECDSA keys produced by GnuPG. It is also the only OpenPGP implementation among laitos dependencies.
+*/
+type PGP struct {
+	/*
+		TrustedKeyringFile is the path to a keyring file of public keys of trusted senders. When configured, incoming
+		mails must be signed by one of the keys, and in return the commands run without having to present a password PIN.
+	*/
+	TrustedKeyringFile string `json:"TrustedKeyringFile"`
+	// PrivateKeyringFile is the path to a keyring file of private keys that decrypt incoming mails and sign replies.
+	PrivateKeyringFile string `json:"PrivateKeyringFile"`
+	// PrivateKeyPassword decrypts the private keys, leave it empty if the private keys are not protected by password.
+	PrivateKeyPassword string `json:"PrivateKeyPassword"`
+	// EncryptReply encrypts replies to sender's public key found in the trusted keyring.
+	EncryptReply bool `json:"EncryptReply"`
+
+	trustedKeys    openpgp.EntityList
+	privateKeys    openpgp.EntityList
+	seenSignatures map[[sha256.Size]byte]time.Time // seenSignatures are the recently accepted signatures and their expiry.
+	mutex          *sync.Mutex                     // mutex protects seenSignatures.
+}
+
+// IsConfigured returns true only if PGP mail processing is configured.
+func (pgp *PGP) IsConfigured() bool {
+	return pgp.TrustedKeyringFile != "" || pgp.PrivateKeyringFile != "" || pgp.EncryptReply
+}
+
+// readKeyring reads a keyring file, which may be either ASCII armored or binary.
+func readKeyring(filePath string) (openpgp.EntityList, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if err != nil {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err == nil && len(keys) == 0 {
+		err = errors.New("the keyring is empty")
+	}
+	return keys, err
+}
+
+// Initialise reads the keyrings and decrypts private keys.
+func (pgp *PGP) Initialise() (err error) {
+	if pgp.EncryptReply && pgp.TrustedKeyringFile == "" {
+		return errors.New("PGP.Initialise: TrustedKeyringFile must be configured to encrypt replies")
+	}
+	pgp.trustedKeys, pgp.privateKeys = nil, nil
+	pgp.seenSignatures = make(map[[sha256.Size]byte]time.Time)
+	pgp.mutex = new(sync.Mutex)
+	if pgp.TrustedKeyringFile != "" {
+		if pgp.trustedKeys, err = readKeyring(pgp.TrustedKeyringFile); err != nil {
+			return fmt.Errorf("PGP.Initialise: failed to read trusted keyring \"%s\" - %v", pgp.TrustedKeyringFile, err)
+		}
+	}
+	if pgp.PrivateKeyringFile != "" {
+		if pgp.privateKeys, err = readKeyring(pgp.PrivateKeyringFile); err != nil {
+			return fmt.Errorf("PGP.Initialise: failed to read private keyring \"%s\" - %v", pgp.PrivateKeyringFile, err)
+		}
+		for _, key := range pgp.privateKeys {
+			if key.PrivateKey == nil {
+				return fmt.Errorf("PGP.Initialise: the private keyring \"%s\" contains a public key", pgp.PrivateKeyringFile)
+			}
+			if key.PrivateKey.Encrypted {
+				if err := key.PrivateKey.Decrypt([]byte(pgp.PrivateKeyPassword)); err != nil {
+					return fmt.Errorf("PGP.Initialise: failed to decrypt private key - %v", err)
+				}
+			}
+			for _, subkey := range key.Subkeys {
+				if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+					if err := subkey.PrivateKey.Decrypt([]byte(pgp.PrivateKeyPassword)); err != nil {
+						return fmt.Errorf("PGP.Initialise: failed to decrypt private subkey - %v", err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isTrusted returns true only if the key belongs to the trusted keyring.
+func (pgp *PGP) isTrusted(key *openpgp.Entity) bool {
+	return key != nil && len(pgp.trustedKeys.KeysById(key.PrimaryKey.KeyId)) > 0
+}
+
+/*
+checkReplay returns an error if the signature was created outside of the acceptable time window, or the same signature
+of the content has been accepted before. Otherwise, it remembers the signature until it expires.
+*/
+func (pgp *PGP) checkReplay(issuerKeyID uint64, createdAt time.Time, content []byte) error {
+	now := time.Now()
+	if createdAt.Before(now.Add(-PGPSignatureMaxAgeSec*time.Second)) || createdAt.After(now.Add(PGPSignatureMaxSkewSec*time.Second)) {
+		return fmt.Errorf("the signature created at %s is outside of the acceptable time window", createdAt.Format(time.RFC3339))
+	}
+	hash := sha256.New()
+	_ = binary.Write(hash, binary.BigEndian, issuerKeyID)
+	_ = binary.Write(hash, binary.BigEndian, createdAt.Unix())
+	_, _ = hash.Write(content)
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	pgp.mutex.Lock()
+	defer pgp.mutex.Unlock()
+	for seenKey, expiry := range pgp.seenSignatures {
+		if now.After(expiry) {
+			delete(pgp.seenSignatures, seenKey)
+		}
+	}
+	if _, seen := pgp.seenSignatures[key]; seen {
+		return ErrPGPSignatureReplayed
+	}
+	pgp.seenSignatures[key] = createdAt.Add((PGPSignatureMaxAgeSec + PGPSignatureMaxSkewSec) * time.Second)
+	return nil
+}
+
+/*
+readDetachedSignature returns the issuer and creation time of the detached signature that openpgp.CheckDetachedSignature
+verifies, which is the first signature issued by a trusted key that is capable of signing.
+*/
+func (pgp *PGP) readDetachedSignature(signature []byte) (issuerKeyID uint64, createdAt time.Time, err error) {
+	var reader io.Reader = bytes.NewReader(signature)
+	if block, armorErr := armor.Decode(bytes.NewReader(signature)); armorErr == nil {
+		reader = block.Body
+	}
+	packets := packet.NewReader(reader)
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("failed to read signature - %v", err)
+		}
+		switch sig := p.(type) {
+		case *packet.Signature:
+			if sig.IssuerKeyId == nil {
+				return 0, time.Time{}, errors.New("signature does not specify its issuer")
+			}
+			issuerKeyID, createdAt = *sig.IssuerKeyId, sig.CreationTime
+		case *packet.SignatureV3:
+			issuerKeyID, createdAt = sig.IssuerKeyId, sig.CreationTime
+		default:
+			return 0, time.Time{}, errors.New("non signature packet found")
+		}
+		if len(pgp.trustedKeys.KeysByIdUsage(issuerKeyID, packet.KeyFlagSign)) > 0 {
+			return issuerKeyID, createdAt, nil
+		}
+	}
+}
+
+// findRecipientKey returns the trusted key of the signer, or otherwise a trusted key that carries the mail address.
+func (pgp *PGP) findRecipientKey(signer *openpgp.Entity, mailAddress string) *openpgp.Entity {
+	if pgp.isTrusted(signer) {
+		return signer
+	}
+	for _, key := range pgp.trustedKeys {
+		for _, identity := range key.Identities {
+			if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, mailAddress) {
+				return key
+			}
+		}
+	}
+	return nil
+}
+
+/*
+signerAddress returns a mail address bound to an identity of the signer key. The mail headers are not covered by the
+signature, hence the address claimed by the mail is only used if it belongs to the key. Otherwise, the address of the
+primary identity is preferred. It returns an empty string if the key does not carry a mail address.
+*/
+func signerAddress(signer *openpgp.Entity, claimedAddress string) string {
+	var primary string
+	var others []string
+	for _, identity := range signer.Identities {
+		if identity.UserId == nil || identity.UserId.Email == "" {
+			continue
+		}
+		if strings.EqualFold(identity.UserId.Email, claimedAddress) {
+			return identity.UserId.Email
+		}
+		if identity.SelfSignature != nil && identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId {
+			primary = identity.UserId.Email
+		} else {
+			others = append(others, identity.UserId.Email)
+		}
+	}
+	if primary != "" || len(others) == 0 {
+		return primary
+	}
+	sort.Strings(others)
+	return others[0]
+}
+
+// replaceMailEntity returns a mail made of the headers of the original mail and the replacement MIME entity.
+func replaceMailEntity(header mail.Header, entity []byte) []byte {
+	var ret bytes.Buffer
+	for name, values := range header {
+		// The content headers are given by the replacement entity
+		if strings.HasPrefix(strings.ToLower(name), "content-") {
+			continue
+		}
+		for _, value := range values {
+			ret.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+		}
+	}
+	ret.Write(entity)
+	return ret.Bytes()
+}
+
+// toCRLF converts line endings of the input to CRLF, which is the canonical form of signed content (RFC 3156).
+func toCRLF(content []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// decrypt decrypts an OpenPGP message and returns the plain text along with the trusted key that signed it (if any).
+func (pgp *PGP) decrypt(message []byte) (plainText []byte, signer *openpgp.Entity, err error) {
+	if len(pgp.privateKeys) == 0 {
+		return nil, nil, errors.New("PrivateKeyringFile must be configured to decrypt the mail")
+	}
+	var messageReader io.Reader = bytes.NewReader(message)
+	if block, armorErr := armor.Decode(bytes.NewReader(message)); armorErr == nil {
+		messageReader = block.Body
+	}
+	keyring := append(append(openpgp.EntityList{}, pgp.privateKeys...), pgp.trustedKeys...)
+	details, err := openpgp.ReadMessage(messageReader, keyring, nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt the mail - %v", err)
+	}
+	if plainText, err = misc.ReadAllUpTo(details.UnverifiedBody, inet.MaxMailBodySize); err != nil {
+		return nil, nil, err
+	}
+	// Signature is checked after the entire message body has been read
+	if details.IsSigned {
+		if details.SignatureError != nil {
+			return nil, nil, fmt.Errorf("invalid signature - %v", details.SignatureError)
+		}
+		if details.SignedBy != nil && pgp.isTrusted(details.SignedBy.Entity) {
+			// Without a creation time, the signature is considered outside of the acceptable time window.
+			var createdAt time.Time
+			if details.Signature != nil {
+				createdAt = details.Signature.CreationTime
+			} else if details.SignatureV3 != nil {
+				createdAt = details.SignatureV3.CreationTime
+			}
+			if err := pgp.checkReplay(details.SignedByKeyId, createdAt, plainText); err != nil {
+				return nil, nil, err
+			}
+			signer = details.SignedBy.Entity
+		}
+	}
+	return
+}
+
+/*
+unwrap verifies and decrypts PGP/MIME (RFC 3156) signed and encrypted mails, as well as mails carrying an inline
+encrypted text body. It returns the mail in which the signed or encrypted content is replaced by the content itself,
+along with the trusted key that signed the content. Mails without PGP content are returned as-is.
+*/
+func (pgp *PGP) unwrap(mailContent []byte, depth int) (unwrapped []byte, signer *openpgp.Entity, err error) {
+	if depth > MaxPGPNestingDepth {
+		return nil, nil, errors.New("PGP content is nested too deeply")
+	}
+	_, parsedMail, err := inet.ReadMailMessage(mailContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(parsedMail.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	body, err := misc.ReadAllUpTo(parsedMail.Body, inet.MaxMailBodySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case mediaType == "multipart/signed":
+		if len(pgp.trustedKeys) == 0 {
+			return mailContent, nil, nil
+		}
+		body = toCRLF(body)
+		// The signed entity is the verbatim content of the first part
+		delimiter := []byte("--" + params["boundary"] + "\r\n")
+		begin := bytes.Index(body, delimiter)
+		if begin == -1 || params["boundary"] == "" {
+			return nil, nil, errors.New("malformed signed mail")
+		}
+		begin += len(delimiter)
+		end := bytes.Index(body[begin:], []byte("\r\n--"+params["boundary"]))
+		if end == -1 {
+			return nil, nil, errors.New("malformed signed mail")
+		}
+		signedEntity := body[begin : begin+end]
+		// The signature is in the second part
+		partReader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		var signature []byte
+		for i := 0; i < 2; i++ {
+			part, err := partReader.NextPart()
+			if err != nil {
+				return nil, nil, fmt.Errorf("malformed signed mail - %v", err)
+			}
+			if signature, err = misc.ReadAllUpTo(part, inet.MaxMailBodySize); err != nil {
+				return nil, nil, err
+			}
+		}
+		if signer, err = openpgp.CheckArmoredDetachedSignature(pgp.trustedKeys, bytes.NewReader(signedEntity), bytes.NewReader(signature)); err != nil {
+			return nil, nil, fmt.Errorf("invalid signature - %v", err)
+		}
+		issuerKeyID, createdAt, sigErr := pgp.readDetachedSignature(signature)
+		if sigErr != nil {
+			return nil, nil, sigErr
+		}
+		if err := pgp.checkReplay(issuerKeyID, createdAt, signedEntity); err != nil {
+			return nil, nil, err
+		}
+		unwrapped, _, err = pgp.unwrap(replaceMailEntity(parsedMail.Header, signedEntity), depth+1)
+		return
+	case mediaType == "multipart/encrypted":
+		// The encrypted content is in the second part, following the version identification.
+		partReader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		var encrypted []byte
+		for i := 0; i < 2; i++ {
+			part, err := partReader.NextPart()
+			if err != nil {
+				return nil, nil, fmt.Errorf("malformed encrypted mail - %v", err)
+			}
+			if encrypted, err = misc.ReadAllUpTo(part, inet.MaxMailBodySize); err != nil {
+				return nil, nil, err
+			}
+		}
+		entity, encryptedBy, err := pgp.decrypt(encrypted)
+		if err != nil {
+			return nil, nil, err
+		}
+		unwrapped, signer, err = pgp.unwrap(replaceMailEntity(parsedMail.Header, toCRLF(entity)), depth+1)
+		if signer == nil {
+			signer = encryptedBy
+		}
+		return unwrapped, signer, err
+	case mediaType == "text/plain" && bytes.HasPrefix(bytes.TrimSpace(body), []byte("-----BEGIN PGP MESSAGE-----")):
+		// Inline encrypted text
+		plainText, encryptedBy, err := pgp.decrypt(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return replaceMailEntity(parsedMail.Header, append([]byte("Content-Type: text/plain; charset=utf-8\r\n\r\n"), plainText...)), encryptedBy, nil
+	}
+	return mailContent, nil, nil
+}
+
+/*
+encryptReply encrypts the MIME entity to the recipient's key, signs it with the private key (if configured), and
+returns a complete PGP/MIME (RFC 3156) encrypted mail.
+*/
+func (pgp *PGP) encryptReply(from, subject string, recipients []string, recipientKey *openpgp.Entity, entity []byte) ([]byte, error) {
+	var encrypted bytes.Buffer
+	armorWriter, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	var signer *openpgp.Entity
+	if len(pgp.privateKeys) > 0 {
+		signer = pgp.privateKeys[0]
+	}
+	plainWriter, err := openpgp.Encrypt(armorWriter, openpgp.EntityList{recipientKey}, signer, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := plainWriter.Write(entity); err != nil {
+		return nil, err
+	}
+	if err := plainWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	var mailBody bytes.Buffer
+	multipartWriter := multipart.NewWriter(&mailBody)
+	mailBody.WriteString(fmt.Sprintf("MIME-Version: 1.0\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: %s\r\n\r\n",
+		from, strings.Join(recipients, ", "), subject,
+		mime.FormatMediaType("multipart/encrypted", map[string]string{"protocol": "application/pgp-encrypted", "boundary": multipartWriter.Boundary()})))
+	versionPart, err := multipartWriter.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/pgp-encrypted"}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := versionPart.Write([]byte("Version: 1\r\n")); err != nil {
+		return nil, err
+	}
+	encryptedPart, err := multipartWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {`application/octet-stream; name="encrypted.asc"`},
+		"Content-Disposition": {`inline; filename="encrypted.asc"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := encryptedPart.Write(encrypted.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := multipartWriter.Close(); err != nil {
+		return nil, err
+	}
+	return mailBody.Bytes(), nil
+}
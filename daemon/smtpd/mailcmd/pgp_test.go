@@ -0,0 +1,223 @@
+package mailcmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/toolbox"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// writePGPKey generates a new PGP key and writes its public and private keyring files into the directory.
+func writePGPKey(t *testing.T, dir, name, email string) (key *openpgp.Entity, publicKeyring, privateKeyring string) {
+	key, err := openpgp.NewEntity(name, "", email, &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like keys generated by GnuPG, declare the preference of SHA256 hash algorithm.
+	for _, identity := range key.Identities {
+		identity.SelfSignature.PreferredHash = []uint8{8}
+		if err := identity.SelfSignature.SignUserId(identity.UserId.Id, key.PrimaryKey, key.PrivateKey, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, keyring := range []struct {
+		path    string
+		private bool
+	}{{filepath.Join(dir, name+".pub"), false}, {filepath.Join(dir, name+".key"), true}} {
+		var buf bytes.Buffer
+		blockType := openpgp.PublicKeyType
+		if keyring.private {
+			blockType = openpgp.PrivateKeyType
+		}
+		armorWriter, err := armor.Encode(&buf, blockType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyring.private {
+			err = key.SerializePrivate(armorWriter, nil)
+		} else {
+			err = key.Serialize(armorWriter)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := armorWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyring.path, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key, filepath.Join(dir, name+".pub"), filepath.Join(dir, name+".key")
+}
+
+// signMail returns a PGP/MIME signed mail that carries the text body, the optional config determines the signature time.
+func signMail(t *testing.T, signer *openpgp.Entity, text string, config *packet.Config) []byte {
+	entity := "Content-Type: text/plain; charset=utf-8\r\n\r\n" + text
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer, strings.NewReader(entity), config); err != nil {
+		t.Fatal(err)
+	}
+	return []byte("From: howard@localhost\r\nSubject: hi\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/signed; micalg=pgp-sha256; protocol=\"application/pgp-signature\"; boundary=sig\r\n\r\n" +
+		"--sig\r\n" + entity + "\r\n--sig\r\nContent-Type: application/pgp-signature\r\n\r\n" + signature.String() + "\r\n--sig--\r\n")
+}
+
+func TestCommandRunner_PGP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestCommandRunner_PGP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userKey, userPublic, _ := writePGPKey(t, dir, "user", "howard@localhost")
+	strangerKey, _, _ := writePGPKey(t, dir, "stranger", "stranger@localhost")
+	serverKey, _, serverPrivate := writePGPKey(t, dir, "server", "laitos@localhost")
+
+	runner := CommandRunner{
+		Processor: toolbox.GetTestCommandProcessor(),
+		ReplyMailClient: inet.MailClient{
+			MTAHost:  "127.0.0.1",
+			MTAPort:  25,
+			MailFrom: "laitos@localhost",
+		},
+		PGP: PGP{EncryptReply: true},
+	}
+	if err := runner.Initialise(); err == nil || !strings.Contains(err.Error(), "TrustedKeyringFile") {
+		t.Fatal(err)
+	}
+	runner.PGP = PGP{TrustedKeyringFile: userPublic, PrivateKeyringFile: serverPrivate, EncryptReply: true}
+	if err := runner.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	var lastResult *toolbox.Result
+	runner.processTestCaseFunc = func(result *toolbox.Result) {
+		lastResult = result
+	}
+
+	// Unsigned mail is rejected even if it carries the correct PIN
+	if err := runner.Process("", []byte("From: howard@localhost\r\nSubject: hi\r\n\r\nverysecret.s echo hi")); err != ErrPGPSignatureRequired {
+		t.Fatal(err)
+	}
+	// Mail signed by an untrusted key is rejected
+	if err := runner.Process("", signMail(t, strangerKey, ".s echo hi", nil)); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatal(err)
+	}
+	// Mail signed by the trusted key runs commands without PIN
+	lastResult = nil
+	signedMail := signMail(t, userKey, "hello\r\n.s echo hi\r\n", nil)
+	if err := runner.Process("", signedMail); err != nil {
+		t.Fatal(err)
+	} else if lastResult == nil || strings.TrimSpace(lastResult.CombinedOutput) != "hi" {
+		t.Fatalf("%+v", lastResult)
+	}
+	// Replayed mail is rejected
+	if err := runner.Process("", signedMail); err != ErrPGPSignatureReplayed {
+		t.Fatal(err)
+	}
+	// Mail signed too long ago or in the future is rejected
+	for _, signTime := range []time.Time{time.Now().Add(-(PGPSignatureMaxAgeSec + 60) * time.Second), time.Now().Add((PGPSignatureMaxSkewSec + 60) * time.Second)} {
+		signTime := signTime
+		if err := runner.Process("", signMail(t, userKey, ".s echo hi", &packet.Config{Time: func() time.Time { return signTime }})); err == nil || !strings.Contains(err.Error(), "time window") {
+			t.Fatal(err)
+		}
+	}
+	// Tampered mail is rejected
+	if err := runner.Process("", bytes.Replace(signMail(t, userKey, ".s echo hi", nil), []byte("echo hi"), []byte("echo ha"), 1)); err == nil {
+		t.Fatal("did not reject tampered mail")
+	}
+
+	// Encrypted and signed mail runs commands without PIN
+	var encrypted bytes.Buffer
+	armorWriter, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainWriter, err := openpgp.Encrypt(armorWriter, openpgp.EntityList{serverKey}, userKey, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plainWriter.Write([]byte("Content-Type: text/plain\r\n\r\n.s echo encrypted\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := plainWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encryptedMail := "From: howard@localhost\r\nSubject: hi\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=enc\r\n\r\n" +
+		"--enc\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--enc\r\nContent-Type: application/octet-stream\r\n\r\n" + encrypted.String() + "\r\n--enc--\r\n"
+	lastResult = nil
+	if err := runner.Process("", []byte(encryptedMail)); err != nil {
+		t.Fatal(err)
+	} else if lastResult == nil || strings.TrimSpace(lastResult.CombinedOutput) != "encrypted" {
+		t.Fatalf("%+v", lastResult)
+	}
+	if err := runner.Process("", []byte(encryptedMail)); err != ErrPGPSignatureReplayed {
+		t.Fatal(err)
+	}
+
+	// The reply is encrypted to the user's key
+	reply, err := runner.PGP.encryptReply("laitos@localhost", "reply", []string{"howard@localhost"}, runner.PGP.findRecipientKey(nil, "howard@localhost"), []byte("Content-Type: text/plain\r\n\r\nsecret reply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(reply, []byte("secret reply")) {
+		t.Fatal("reply is not encrypted")
+	}
+	userRunner := CommandRunner{PGP: PGP{
+		trustedKeys:    openpgp.EntityList{serverKey},
+		privateKeys:    openpgp.EntityList{userKey},
+		seenSignatures: make(map[[sha256.Size]byte]time.Time),
+		mutex:          new(sync.Mutex),
+	}}
+	decrypted, signer, err := userRunner.PGP.unwrap(reply, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil || signer.PrimaryKey.KeyId != serverKey.PrimaryKey.KeyId {
+		t.Fatal("reply is not signed by server")
+	}
+	if _, parts, err := inet.ReadMailParts(decrypted); err != nil || len(parts) != 1 || string(parts[0].Body) != "secret reply" {
+		t.Fatal(err, parts)
+	}
+	if runner.PGP.findRecipientKey(nil, "stranger@localhost") != nil {
+		t.Fatal("should not have found a key for stranger")
+	}
+}
+
+func TestSignerAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-TestSignerAddress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userKey, _, _ := writePGPKey(t, dir, "user", "howard@localhost")
+	// The claimed address is only used if it belongs to the key
+	if addr := signerAddress(userKey, "HOWARD@localhost"); addr != "howard@localhost" {
+		t.Fatal(addr)
+	}
+	if addr := signerAddress(userKey, "attacker@example.com"); addr != "howard@localhost" {
+		t.Fatal(addr)
+	}
+	noAddrKey, err := openpgp.NewEntity("noaddr", "", "", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := signerAddress(noAddrKey, "attacker@example.com"); addr != "" {
+		t.Fatal(addr)
+	}
+}
@@ -8,10 +8,12 @@ package smtp
 import (
 	"bufio"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/HouzuoGuo/laitos/lalog"
@@ -23,6 +25,9 @@ The maximum length does not apply to mail message and attachments.
 */
 const MaxCommandLength = 4096
 
+// MaxAuthAttempts is the maximum number of failed authentication attempts to tolerate before closing the connection.
+const MaxAuthAttempts = 3
+
 /*
 commandStage is an enumeration of stages of an SMTP conversation. The stages determine what kind of protocol verbs are
 anticipated for the upcoming protocol command.
@@ -84,6 +89,13 @@ type Config struct {
 		use the greeting to further establish authenticity of the mail server.
 	*/
 	ServerName string
+	/*
+		Authenticate turns the conversation into a mail submission conversation. The function returns true only if the
+		user name and password are correct.
+		StartTLS and a successful authentication (AUTH PLAIN or AUTH LOGIN) become mandatory prior to MAIL FROM, and the
+		server only advertises AUTH capability after StartTLS.
+	*/
+	Authenticate func(username, password string) bool
 }
 
 /*
@@ -100,6 +112,8 @@ type Connection struct {
 	TLSState tls.ConnectionState
 	// TLSHelp contains a text description that explains the latest TLS error from SMTP conversation's perspective.
 	TLSHelp string
+	// AuthenticatedUser is the user name that has successfully authenticated in a mail submission conversation.
+	AuthenticatedUser string
 
 	// netConn is the underlying TCP connection
 	netConn net.Conn
@@ -109,6 +123,8 @@ type Connection struct {
 	textReader *textproto.Reader
 	// consecutiveUnrecognisedCommands counts the number of consecutive unrecognised commands.
 	consecutiveUnrecognisedCommands int
+	// failedAuthAttempts counts the number of failed authentication attempts.
+	failedAuthAttempts int
 	// latestProtocolVerb is the protocol verb received from the latest protocol command.
 	latestProtocolVerb ProtocolVerb
 	// state memorises the latest stage of the ongoing SMTP conversation.
@@ -201,6 +217,9 @@ func (conn *Connection) answerOK() {
 		if conn.Config.TLSConfig != nil && !conn.TLSAttempted {
 			conn.reply("250-STARTTLS")
 		}
+		if conn.Config.Authenticate != nil && conn.TLSAttempted && conn.AuthenticatedUser == "" {
+			conn.reply("250-AUTH PLAIN LOGIN")
+		}
 		conn.reply("250 OK")
 	case VerbMAILFROM:
 		conn.reply("250 2.1.0 OK")
@@ -242,6 +261,101 @@ func (conn *Connection) AnswerRateLimited() {
 	conn.stage = StageAbort
 }
 
+/*
+authenticate carries on an SMTP AUTH exchange using either PLAIN or LOGIN mechanism. Upon successful authentication,
+the user name is memorised in AuthenticatedUser.
+*/
+func (conn *Connection) authenticate(param string) {
+	if conn.Config.Authenticate == nil {
+		conn.reply("502 Command not implemented")
+		return
+	}
+	if !conn.TLSAttempted {
+		conn.reply("538 5.7.11 Encryption required for requested authentication mechanism")
+		return
+	}
+	if conn.stage != StageHello {
+		conn.reply("503 Bad sequence of commands")
+		return
+	}
+	if conn.AuthenticatedUser != "" {
+		conn.reply("503 5.5.1 Already authenticated")
+		return
+	}
+	mechanism, response := param, ""
+	if space := strings.IndexByte(param, ' '); space != -1 {
+		mechanism, response = param[:space], strings.TrimSpace(param[space+1:])
+	}
+	// readResponse sends a challenge and returns the decoded response from client
+	readResponse := func(challenge string) (string, bool) {
+		conn.reply("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+		line := conn.readCommand()
+		if conn.stage == StageAbort || line == "*" {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return string(decoded), err == nil
+	}
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		var credentials string
+		if response == "" {
+			var ok bool
+			if credentials, ok = readResponse(""); !ok {
+				conn.reply("501 5.5.2 Authentication aborted")
+				return
+			}
+		} else {
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				conn.reply("501 5.5.2 Malformed authentication response")
+				return
+			}
+			credentials = string(decoded)
+		}
+		// The credentials look like: authorization-identity NUL authentication-identity NUL password
+		fields := strings.Split(credentials, "\x00")
+		if len(fields) != 3 {
+			conn.reply("501 5.5.2 Malformed authentication response")
+			return
+		}
+		username, password = fields[1], fields[2]
+	case "LOGIN":
+		var ok bool
+		if response == "" {
+			if username, ok = readResponse("Username:"); !ok {
+				conn.reply("501 5.5.2 Authentication aborted")
+				return
+			}
+		} else {
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				conn.reply("501 5.5.2 Malformed authentication response")
+				return
+			}
+			username = string(decoded)
+		}
+		if password, ok = readResponse("Password:"); !ok {
+			conn.reply("501 5.5.2 Authentication aborted")
+			return
+		}
+	default:
+		conn.reply("504 5.5.4 Unrecognised authentication mechanism")
+		return
+	}
+	if username == "" || !conn.Config.Authenticate(username, password) {
+		conn.failedAuthAttempts++
+		conn.reply("535 5.7.8 Authentication credentials invalid")
+		if conn.failedAuthAttempts >= MaxAuthAttempts {
+			conn.stage = StageAbort
+		}
+		return
+	}
+	conn.AuthenticatedUser = username
+	conn.reply("235 2.7.0 Authentication successful")
+}
+
 // setupReaders initialises text reader and limit reader to operate on the underlying network connection.
 func (conn *Connection) setupReaders(netConn net.Conn) {
 	conn.netConn = netConn
@@ -311,6 +425,11 @@ func (conn *Connection) CarryOn() Command {
 			conn.reply("503 Bad sequence of commands")
 			continue
 		}
+		// Mail submission requires the client to authenticate prior to sending mails
+		if thisCmd.Verb == VerbMAILFROM && conn.Config.Authenticate != nil && conn.AuthenticatedUser == "" {
+			conn.reply("530 5.7.0 Authentication required")
+			continue
+		}
 		if verbStage.ValidInStages == 0 {
 			switch thisCmd.Verb {
 			case VerbRSET:
@@ -325,6 +444,8 @@ func (conn *Connection) CarryOn() Command {
 			case VerbQUIT:
 				conn.stage = StageQuit
 				conn.reply("221 2.0.0 Bye")
+			case VerbAUTH:
+				conn.authenticate(thisCmd.Parameter)
 			case VerbSTARTTLS:
 				if conn.Config.TLSConfig == nil || conn.TLSAttempted {
 					conn.reply("502 Command not implemented")
@@ -22,6 +22,7 @@ const (
 	VerbQUIT
 	VerbRSET
 	VerbNOOP
+	VerbAUTH
 )
 
 // String returns a descriptive string representation of an SMTP Verb.
@@ -72,6 +73,7 @@ var protocolCommands = []struct {
 	{VerbQUIT, "QUIT", expectOptionalParameter},
 	{VerbRSET, "RSET", expectOptionalParameter},
 	{VerbNOOP, "NOOP", expectOptionalParameter},
+	{VerbAUTH, "AUTH", expectOptionalParameter},
 }
 
 // contains7BitAsciiOnly returns true only if the input byte array only contains byte value <=127.
@@ -1,11 +1,13 @@
 package smtpd
 
 import (
+	"crypto/subtle"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/mail"
 	netSMTP "net/smtp"
 	"strconv"
 	"strings"
@@ -37,18 +39,35 @@ type Daemon struct {
 	MyDomains []string `json:"MyDomains"`
 	// ForwardTo are the recipients (email addresses) to receive emails that are delivered to this SMTP server.
 	ForwardTo []string `json:"ForwardTo"`
+	/*
+		SubmissionPort is the port number of the optional mail submission listener (usually 587), which relays mails from
+		authenticated users to recipients of any domain. The submission listener requires TLS certificate and key.
+	*/
+	SubmissionPort int `json:"SubmissionPort"`
+	// SubmissionUsers are the user names (keys) and passwords (values) permitted to relay mails via the submission listener.
+	SubmissionUsers map[string]string `json:"SubmissionUsers"`
+	/*
+		SubmissionUserAddresses are the user names (keys) and the mail addresses (values) each user may send mails as.
+		In addition, a user may send mails as its user name if the name is a mail address, or otherwise as the user name
+		at any of my domains.
+	*/
+	SubmissionUserAddresses map[string][]string `json:"SubmissionUserAddresses"`
 
 	CommandRunner     *mailcmd.CommandRunner `json:"-"` // Process feature commands from incoming mails
 	ForwardMailClient inet.MailClient        `json:"-"` // ForwardMailClient is used to forward arriving emails.
 
-	myDomainsHash map[string]struct{} // myDomainHash has "MyDomains" in map keys
-	smtpConfig    smtp.Config
-	tlsCert       tls.Certificate
-	tcpServer     *common.TCPServer
-	logger        lalog.Logger
+	myDomainsHash       map[string]struct{} // myDomainHash has "MyDomains" in map keys
+	smtpConfig          smtp.Config
+	submissionConfig    smtp.Config
+	tlsCert             tls.Certificate
+	tcpServer           *common.TCPServer
+	submissionTCPServer *common.TCPServer
+	logger              lalog.Logger
 
 	// processMailTestCaseFunc works along side normal delivery routine, it offers mail message to test case for inspection.
 	processMailTestCaseFunc func(string, string)
+	// relayMailTestCaseFunc works along side mail submission routine, it offers relayed mail to test case for inspection.
+	relayMailTestCaseFunc func(string, string, []string, string)
 }
 
 // Check configuration and initialise internal states.
@@ -105,8 +124,40 @@ func (daemon *Daemon) Initialise() error {
 		daemon.ForwardMailClient.MTAHost == "::1" ||
 		daemon.ForwardMailClient.MTAHost == "0.0.0.0" ||
 		daemon.ForwardMailClient.MTAHost == myPublicIP) &&
-		daemon.ForwardMailClient.MTAPort == daemon.Port {
-		return fmt.Errorf("smtpd.Initialise: forward MTA must not be myself or localhost on port %d", daemon.Port)
+		(daemon.ForwardMailClient.MTAPort == daemon.Port || daemon.ForwardMailClient.MTAPort == daemon.SubmissionPort) {
+		return fmt.Errorf("smtpd.Initialise: forward MTA must not be myself or localhost on port %d", daemon.ForwardMailClient.MTAPort)
+	}
+	// Initialise the optional mail submission listener
+	if daemon.SubmissionPort > 0 {
+		if daemon.SubmissionPort == daemon.Port {
+			return errors.New("smtpd.Initialise: submission port must be different from the ordinary SMTP port")
+		}
+		if daemon.TLSCertPath == "" {
+			return errors.New("smtpd.Initialise: submission port requires TLS certificate and key")
+		}
+		if len(daemon.SubmissionUsers) == 0 {
+			return errors.New("smtpd.Initialise: submission port requires at least one user")
+		}
+		for user, password := range daemon.SubmissionUsers {
+			if user == "" || password == "" {
+				return errors.New("smtpd.Initialise: submission user name and password must not be empty")
+			}
+		}
+		for user := range daemon.SubmissionUserAddresses {
+			if _, exists := daemon.SubmissionUsers[user]; !exists {
+				return fmt.Errorf("smtpd.Initialise: submission user addresses refer to an unknown user \"%s\"", user)
+			}
+		}
+		daemon.submissionConfig = daemon.smtpConfig
+		daemon.submissionConfig.Authenticate = daemon.authenticateSubmissionUser
+		daemon.submissionTCPServer = &common.TCPServer{
+			ListenAddr:  daemon.Address,
+			ListenPort:  daemon.SubmissionPort,
+			AppName:     "smtpd-submission",
+			App:         &submissionApp{daemon: daemon},
+			LimitPerSec: daemon.PerIPLimit,
+		}
+		daemon.submissionTCPServer.Initialise()
 	}
 	// Construct a hash of MyDomains addresses for fast lookup
 	daemon.myDomainsHash = map[string]struct{}{}
@@ -183,6 +234,72 @@ func (daemon *Daemon) ProcessMail(clientIP, fromAddr, mailBody string) {
 	}
 }
 
+// RelayMail delivers a mail submitted by an authenticated user to its recipients.
+func (daemon *Daemon) RelayMail(clientIP, username, fromAddr string, toAddrs []string, mailBody string) {
+	if err := daemon.ForwardMailClient.SendRaw(fromAddr, []byte(mailBody), toAddrs...); err == nil {
+		daemon.logger.Info("RelayMail", clientIP, nil, "user \"%s\" successfully relayed mail from \"%s\" to %v", username, fromAddr, toAddrs)
+	} else {
+		daemon.logger.Warning("RelayMail", clientIP, err, "failed to relay mail for user \"%s\"", username)
+	}
+	// Offer the relayed mail to test case
+	if daemon.relayMailTestCaseFunc != nil {
+		daemon.relayMailTestCaseFunc(username, fromAddr, toAddrs, mailBody)
+	}
+}
+
+// authenticateSubmissionUser returns true only if the user name and password match one of the submission users.
+func (daemon *Daemon) authenticateSubmissionUser(username, password string) bool {
+	expectedPassword, exists := daemon.SubmissionUsers[username]
+	return exists && subtle.ConstantTimeCompare([]byte(expectedPassword), []byte(password)) == 1
+}
+
+/*
+mayUseAddress returns true only if the submission user may send mails as the address. A user may use the addresses
+listed in SubmissionUserAddresses, as well as the user name itself if it is a mail address, or otherwise the user name
+at any of my domains.
+*/
+func (daemon *Daemon) mayUseAddress(username, addr string) bool {
+	if username == "" {
+		return false
+	}
+	for _, userAddr := range daemon.SubmissionUserAddresses[username] {
+		if strings.EqualFold(userAddr, addr) {
+			return true
+		}
+	}
+	if strings.ContainsRune(username, '@') {
+		return strings.EqualFold(username, addr)
+	}
+	atSign := strings.LastIndexByte(addr, '@')
+	if atSign < 1 || !strings.EqualFold(addr[:atSign], username) {
+		return false
+	}
+	for _, domain := range daemon.MyDomains {
+		if strings.EqualFold(addr[atSign+1:], domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromHeaderBelongsTo returns true only if the mail has a From header, and all of its addresses belong to the submission user.
+func (daemon *Daemon) fromHeaderBelongsTo(username, mailBody string) bool {
+	msg, err := mail.ReadMessage(strings.NewReader(mailBody))
+	if err != nil {
+		return false
+	}
+	addrs, err := msg.Header.AddressList("From")
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !daemon.mayUseAddress(username, addr.Address) {
+			return false
+		}
+	}
+	return true
+}
+
 // GetTCPStatsCollector returns the stats collector that counts and times client connections for the TCP application.
 func (daemon *Daemon) GetTCPStatsCollector() *misc.Stats {
 	return misc.SMTPDStats
@@ -190,6 +307,15 @@ func (daemon *Daemon) GetTCPStatsCollector() *misc.Stats {
 
 // HandleTCPConnection converses with the SMTP client. The client connection is closed by server upon returning from the implementation.
 func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client *net.TCPConn) {
+	daemon.converse(ip, client, false)
+}
+
+/*
+converse carries on an SMTP conversation with the client. If the conversation takes place on the submission listener,
+the client must authenticate itself before its mail is relayed to recipients of any domain; otherwise, the mail must
+be addressed to one of my domains and it will be forwarded to the forward addresses.
+*/
+func (daemon *Daemon) converse(ip string, client *net.TCPConn, submission bool) {
 	var numCommands int
 	// The status string is only used for logging
 	var completionStatus string
@@ -199,7 +325,11 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client
 	var fromAddr, mailBody string
 	toAddrs := make([]string, 0, 4)
 
-	smtpConn := smtp.NewConnection(client, daemon.smtpConfig, nil)
+	smtpConfig := daemon.smtpConfig
+	if submission {
+		smtpConfig = daemon.submissionConfig
+	}
+	smtpConn := smtp.NewConnection(client, smtpConfig, nil)
 	for {
 		if misc.EmergencyLockDown {
 			daemon.logger.Warning("HandleConnection", "", misc.ErrEmergencyLockDown, "")
@@ -229,10 +359,21 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client
 		case smtp.ConvReceivedCommand:
 			switch ev.Verb {
 			case smtp.VerbMAILFROM:
+				// Authenticated users may only send mails as their own addresses
+				if submission && !daemon.mayUseAddress(smtpConn.AuthenticatedUser, ev.Parameter) {
+					completionStatus = fmt.Sprintf("rejected sender \"%s\" that does not belong to user \"%s\"", ev.Parameter, smtpConn.AuthenticatedUser)
+					smtpConn.AnswerNegative()
+					goto done
+				}
 				fromAddr = ev.Parameter
 			case smtp.VerbRCPTTO:
 				atSign := strings.IndexRune(ev.Parameter, '@')
-				if atSign > 0 {
+				if submission {
+					// Authenticated users may send mails to recipients of any domain
+					if atSign > 0 && len(toAddrs) < MaxNumRecipients {
+						toAddrs = append(toAddrs, ev.Parameter)
+					}
+				} else if atSign > 0 {
 					if domain, exists := daemon.myDomainsHash[ev.Parameter[atSign+1:]]; exists {
 						if len(toAddrs) < MaxNumRecipients {
 							toAddrs = append(toAddrs, ev.Parameter)
@@ -245,20 +386,44 @@ func (daemon *Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client
 				}
 			}
 		case smtp.ConvReceivedData:
+			if submission && !daemon.fromHeaderBelongsTo(smtpConn.AuthenticatedUser, ev.Parameter) {
+				completionStatus = fmt.Sprintf("rejected mail whose From header does not belong to user \"%s\"", smtpConn.AuthenticatedUser)
+				smtpConn.AnswerNegative()
+				goto done
+			}
 			mailBody = ev.Parameter
 		}
 	}
 done:
 	if fromAddr != "" && len(toAddrs) > 0 && mailBody != "" {
 		daemon.logger.Info("HandleTCPConnection", ip, nil, "received mail from \"%s\" addressed to %s", fromAddr, strings.Join(toAddrs, ", "))
-		// Forward the mail to forward-recipients, hence the original To-Addresses are not relevant.
-		daemon.ProcessMail(ip, fromAddr, mailBody)
+		if submission {
+			daemon.RelayMail(ip, smtpConn.AuthenticatedUser, fromAddr, toAddrs, mailBody)
+		} else {
+			// Forward the mail to forward-recipients, hence the original To-Addresses are not relevant.
+			daemon.ProcessMail(ip, fromAddr, mailBody)
+		}
 	} else {
 		smtpConn.AnswerNegative()
 		completionStatus += " & rejected mail due to missing parameters"
 	}
-	daemon.logger.Info("HandleTCPConnection", ip, nil, "%s after %d conversations (TLS: %s), last commands: %s",
-		completionStatus, numCommands, smtpConn.TLSHelp, strings.Join(latestConv.GetAll(), " | "))
+	daemon.logger.Info("HandleTCPConnection", ip, nil, "%s after %d conversations (TLS: %s, submission: %v), last commands: %s",
+		completionStatus, numCommands, smtpConn.TLSHelp, submission, strings.Join(latestConv.GetAll(), " | "))
+}
+
+// submissionApp is the TCP application of mail submission listener, it relays mails from authenticated users.
+type submissionApp struct {
+	daemon *Daemon
+}
+
+// GetTCPStatsCollector returns the stats collector that counts and times client connections for the TCP application.
+func (app *submissionApp) GetTCPStatsCollector() *misc.Stats {
+	return misc.SMTPDStats
+}
+
+// HandleTCPConnection converses with the mail submission client. The client connection is closed by server upon returning from the implementation.
+func (app *submissionApp) HandleTCPConnection(logger lalog.Logger, ip string, client *net.TCPConn) {
+	app.daemon.converse(ip, client, true)
 }
 
 /*
@@ -266,12 +431,31 @@ You may call this function only after having called Initialise()!
 Start SMTP daemon and block until daemon is told to stop.
 */
 func (daemon *Daemon) StartAndBlock() (err error) {
-	return daemon.tcpServer.StartAndBlock()
+	if daemon.submissionTCPServer == nil {
+		return daemon.tcpServer.StartAndBlock()
+	}
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- daemon.tcpServer.StartAndBlock()
+	}()
+	go func() {
+		errChan <- daemon.submissionTCPServer.StartAndBlock()
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errChan; err != nil {
+			daemon.Stop()
+			return err
+		}
+	}
+	return nil
 }
 
 // If SMTP daemon has started (i.e. listener is set), close the listener so that its connection loop will terminate.
 func (daemon *Daemon) Stop() {
 	daemon.tcpServer.Stop()
+	if daemon.submissionTCPServer != nil {
+		daemon.submissionTCPServer.Stop()
+	}
 }
 
 // Run unit tests on Daemon. See TestSMTPD_StartAndBlock for daemon setup.
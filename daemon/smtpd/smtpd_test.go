@@ -1,8 +1,23 @@
 package smtpd
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	netSMTP "net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/HouzuoGuo/laitos/daemon/smtpd/mailcmd"
 	"github.com/HouzuoGuo/laitos/inet"
@@ -120,3 +135,181 @@ func TestSMTPD_StartAndBlock(t *testing.T) {
 
 	TestSMTPD(&daemon, t)
 }
+
+// writeSelfSignedCert writes a self-signed certificate and its key for localhost into the directory.
+func writeSelfSignedCert(t *testing.T, dir string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSMTPD_Submission(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "laitos-TestSMTPD_Submission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	daemon := Daemon{
+		Address:        "127.0.0.1",
+		Port:           61359,
+		SubmissionPort: 61360,
+		MyDomains:      []string{"example.com"},
+		ForwardTo:      []string{"howard@forward-to.example.com"},
+		ForwardMailClient: inet.MailClient{
+			MailFrom: "howard@localhost",
+			MTAHost:  "smtp.example.com",
+			MTAPort:  25,
+		},
+	}
+	// Submission requires TLS and users
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "TLS") {
+		t.Fatal(err)
+	}
+	daemon.TLSCertPath, daemon.TLSKeyPath = writeSelfSignedCert(t, tmpDir)
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "at least one user") {
+		t.Fatal(err)
+	}
+	daemon.SubmissionUsers = map[string]string{"howard": "verysecret"}
+	daemon.SubmissionUserAddresses = map[string][]string{"alice": {"alice@example.com"}}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "unknown user") {
+		t.Fatal(err)
+	}
+	daemon.SubmissionUserAddresses = map[string][]string{"howard": {"howard@elsewhere.example.org"}}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := daemon.StartAndBlock(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer daemon.Stop()
+	time.Sleep(1 * time.Second)
+
+	var relayedUser, relayedFrom, relayedBody string
+	var relayedTo []string
+	daemon.relayMailTestCaseFunc = func(user, from string, to []string, body string) {
+		relayedUser, relayedFrom, relayedTo, relayedBody = user, from, to, body
+	}
+	submit := func(password, mailFrom, fromHeader string) error {
+		client, err := netSMTP.Dial("127.0.0.1:" + strconv.Itoa(daemon.SubmissionPort))
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		// Sending a mail without authentication must fail
+		if err := client.Mail("howard@example.com"); err == nil || !strings.Contains(err.Error(), "Authentication required") {
+			t.Fatal(err)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: "localhost", InsecureSkipVerify: true}); err != nil {
+			return err
+		}
+		if err := client.Auth(netSMTP.PlainAuth("", "howard", password, "127.0.0.1")); err != nil {
+			return err
+		}
+		if err := client.Mail(mailFrom); err != nil {
+			return err
+		}
+		for _, to := range []string{"a@not-my-domain.com", "b@not-my-domain.com"} {
+			if err := client.Rcpt(to); err != nil {
+				return err
+			}
+		}
+		data, err := client.Data()
+		if err != nil {
+			return err
+		}
+		if _, err := data.Write([]byte("From: " + fromHeader + "\r\nSubject: relay subject\r\n\r\nrelay body\r\n")); err != nil {
+			return err
+		}
+		if err := data.Close(); err != nil {
+			return err
+		}
+		return client.Quit()
+	}
+	// Wrong password must not relay the mail
+	if err := submit("wrong", "howard@example.com", "howard@example.com"); err == nil || !strings.Contains(err.Error(), "credentials invalid") {
+		t.Fatal(err)
+	}
+	if relayedUser != "" {
+		t.Fatal(relayedUser)
+	}
+	// Authenticated user may only send mails as its own addresses
+	if err := submit("verysecret", "alice@example.com", "howard@example.com"); err == nil || !strings.Contains(err.Error(), "Bad address") {
+		t.Fatal(err)
+	}
+	if err := submit("verysecret", "howard@example.com", "Alice <alice@example.com>"); err == nil || !strings.Contains(err.Error(), "Not accepted") {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Second)
+	if relayedUser != "" {
+		t.Fatal(relayedUser)
+	}
+	// Authenticated user may relay mails to any domain
+	if err := submit("verysecret", "howard@example.com", "Howard <howard@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Second)
+	if relayedUser != "howard" || relayedFrom != "howard@example.com" ||
+		!reflect.DeepEqual(relayedTo, []string{"a@not-my-domain.com", "b@not-my-domain.com"}) ||
+		relayedBody != "From: Howard <howard@example.com>\nSubject: relay subject\n\nrelay body\n" {
+		t.Fatal(relayedUser, relayedFrom, relayedTo, relayedBody)
+	}
+}
+
+func TestDaemon_MayUseAddress(t *testing.T) {
+	daemon := Daemon{
+		MyDomains:               []string{"example.com", "example.net"},
+		SubmissionUserAddresses: map[string][]string{"my-phone": {"howard@example.org"}},
+	}
+	for _, test := range []struct {
+		user, addr string
+		ok         bool
+	}{
+		{"howard", "howard@example.com", true},
+		{"howard", "Howard@EXAMPLE.net", true},
+		{"howard", "howard@example.org", false},
+		{"howard", "alice@example.com", false},
+		{"howard", "howard", false},
+		{"howard@example.org", "howard@example.org", true},
+		{"howard@example.org", "howard@example.com", false},
+		{"my-phone", "howard@example.org", true},
+		{"my-phone", "my-phone@example.com", true},
+		{"my-phone", "howard@example.com", false},
+		{"", "@example.com", false},
+	} {
+		if ok := daemon.mayUseAddress(test.user, test.addr); ok != test.ok {
+			t.Fatal(test.user, test.addr, ok)
+		}
+	}
+	if !daemon.fromHeaderBelongsTo("howard", "From: Howard <howard@example.com>\r\n\r\nbody") ||
+		daemon.fromHeaderBelongsTo("howard", "From: howard@example.com, alice@example.com\r\n\r\nbody") ||
+		daemon.fromHeaderBelongsTo("howard", "Subject: no from\r\n\r\nbody") {
+		t.Fatal("wrong From header verdict")
+	}
+}
@@ -0,0 +1,249 @@
+package sockd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// CipherLegacy is the name of the unauthenticated stream cipher (AES-256-CTR), it is used on ports without an explicit cipher choice.
+	CipherLegacy = "aes-256-ctr"
+	// CipherAES256GCM is the name of AEAD cipher AES-256-GCM.
+	CipherAES256GCM = "aes-256-gcm"
+	// CipherChaCha20Poly1305 is the name of AEAD cipher ChaCha20-Poly1305 (IETF variant).
+	CipherChaCha20Poly1305 = "chacha20-ietf-poly1305"
+
+	// AEADKeyLength is the length of master key and session subkey of all supported AEAD ciphers.
+	AEADKeyLength = 32
+	// AEADTagLength is the length of authentication tag of all supported AEAD ciphers.
+	AEADTagLength = 16
+	// AEADMaxPayloadSize is the maximum size of payload carried by a single chunk of AEAD stream.
+	AEADMaxPayloadSize = 0x3FFF
+	// AEADSubkeyInfo is the HKDF info string that derives session subkey from the master key.
+	AEADSubkeyInfo = "ss-subkey"
+	// AEADSaltCacheSize is the number of recently seen salts remembered by a daemon for detecting replayed data.
+	AEADSaltCacheSize = 32768
+)
+
+var (
+	// ErrAEADPacketTooShort is returned when an AEAD packet is too short to carry a salt and an authentication tag.
+	ErrAEADPacketTooShort = errors.New("AEAD packet is too short")
+	// ErrAEADKeyNotFound is returned when none of the keys can authenticate the incoming data.
+	ErrAEADKeyNotFound = errors.New("none of the keys can authenticate the data")
+	// ErrAEADPayloadTooLarge is returned when the length chunk of an AEAD stream exceeds AEADMaxPayloadSize.
+	ErrAEADPayloadTooLarge = errors.New("AEAD payload is too large")
+	// ErrAEADSaltReplayed is returned when the salt of incoming data has been seen recently, the data is probably replayed.
+	ErrAEADSaltReplayed = errors.New("AEAD salt has been used recently")
+)
+
+/*
+AEADCipher encrypts and authenticates data using the shadowsocks AEAD construction: a random salt is derived into a
+session subkey via HKDF-SHA1, the TCP stream is made of length-prefixed encrypted chunks, and each UDP packet is
+encrypted individually.
+*/
+type AEADCipher struct {
+	Name    string
+	Key     []byte
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+// newAESGCM returns an AES-GCM AEAD that uses the key.
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewAEADCipher returns an AEAD cipher of the name, its master key is derived from the password.
+func NewAEADCipher(name, password string) (*AEADCipher, error) {
+	ret := &AEADCipher{Name: name, Key: deriveKey(password, AEADKeyLength)}
+	switch name {
+	case CipherAES256GCM:
+		ret.newAEAD = newAESGCM
+	case CipherChaCha20Poly1305:
+		ret.newAEAD = chacha20poly1305.New
+	default:
+		return nil, fmt.Errorf("NewAEADCipher: unknown cipher \"%s\"", name)
+	}
+	return ret, nil
+}
+
+// SaltLength returns the length of random salt that precedes each TCP stream and UDP packet.
+func (cip *AEADCipher) SaltLength() int {
+	return AEADKeyLength
+}
+
+// NewSession returns an AEAD that uses the session subkey derived from master key and the salt.
+func (cip *AEADCipher) NewSession(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, AEADKeyLength)
+	if _, err := io.ReadFull(hkdf.New(sha1.New, cip.Key, salt, []byte(AEADSubkeyInfo)), subkey); err != nil {
+		return nil, err
+	}
+	return cip.newAEAD(subkey)
+}
+
+// NewSalt returns a new random salt.
+func (cip *AEADCipher) NewSalt() []byte {
+	salt := make([]byte, cip.SaltLength())
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		panic(err)
+	}
+	return salt
+}
+
+// SealPacket returns an encrypted UDP packet made of a random salt, and the encrypted plain text with authentication tag.
+func (cip *AEADCipher) SealPacket(plainText []byte) ([]byte, error) {
+	salt := cip.NewSalt()
+	session, err := cip.NewSession(salt)
+	if err != nil {
+		return nil, err
+	}
+	// Each packet uses its own subkey, hence the nonce is always zero.
+	return session.Seal(salt, make([]byte, session.NonceSize()), plainText, nil), nil
+}
+
+// OpenPacket decrypts and authenticates a UDP packet and returns the plain text.
+func (cip *AEADCipher) OpenPacket(packet []byte) ([]byte, error) {
+	if len(packet) < cip.SaltLength() {
+		return nil, ErrAEADPacketTooShort
+	}
+	session, err := cip.NewSession(packet[:cip.SaltLength()])
+	if err != nil {
+		return nil, err
+	}
+	if len(packet) < cip.SaltLength()+session.Overhead() {
+		return nil, ErrAEADPacketTooShort
+	}
+	return session.Open(nil, make([]byte, session.NonceSize()), packet[cip.SaltLength():], nil)
+}
+
+/*
+AEADSaltCache remembers the salts of recently authenticated TCP streams and UDP packets, so that data recorded and
+replayed by an eavesdropper is rejected. The cache keeps two generations of salts, each generation holds up to half of
+the capacity, the older generation is discarded when the newer generation is full.
+*/
+type AEADSaltCache struct {
+	capacity          int
+	current, previous map[string]struct{}
+	mutex             *sync.Mutex
+}
+
+// NewAEADSaltCache returns an empty salt cache that remembers up to the number of salts.
+func NewAEADSaltCache(capacity int) *AEADSaltCache {
+	return &AEADSaltCache{
+		capacity: capacity,
+		current:  make(map[string]struct{}),
+		previous: make(map[string]struct{}),
+		mutex:    new(sync.Mutex),
+	}
+}
+
+// Add remembers the salt and returns true, or returns false if the salt is already remembered.
+func (cache *AEADSaltCache) Add(salt []byte) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	key := string(salt)
+	if _, exists := cache.current[key]; exists {
+		return false
+	}
+	if _, exists := cache.previous[key]; exists {
+		return false
+	}
+	if len(cache.current) >= cache.capacity/2 {
+		cache.previous = cache.current
+		cache.current = make(map[string]struct{})
+	}
+	cache.current[key] = struct{}{}
+	return true
+}
+
+// incrementNonce increments the nonce as a little endian unsigned integer.
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// AEADStream holds the states of encrypting or decrypting one direction of a TCP stream.
+type AEADStream struct {
+	session cipher.AEAD
+	nonce   []byte
+	pending []byte // pending holds decrypted payload not yet consumed by reader
+	buf     []byte
+}
+
+// initialise prepares the stream to encrypt or decrypt using the session AEAD.
+func (stream *AEADStream) initialise(session cipher.AEAD) {
+	stream.session = session
+	stream.nonce = make([]byte, stream.session.NonceSize())
+	stream.buf = make([]byte, 2+AEADMaxPayloadSize+2*stream.session.Overhead())
+}
+
+// readChunk reads an encrypted chunk of the expected plain text size from the reader, and decrypts the chunk in-place.
+func (stream *AEADStream) readChunk(reader io.Reader, size int) ([]byte, error) {
+	chunk := stream.buf[:size+stream.session.Overhead()]
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		return nil, err
+	}
+	plainText, err := stream.session.Open(chunk[:0], stream.nonce, chunk, nil)
+	incrementNonce(stream.nonce)
+	return plainText, err
+}
+
+// readPayload reads and decrypts the payload chunk that follows a length chunk.
+func (stream *AEADStream) readPayload(reader io.Reader, lengthBytes []byte) (err error) {
+	length := binary.BigEndian.Uint16(lengthBytes)
+	if length > AEADMaxPayloadSize {
+		return ErrAEADPayloadTooLarge
+	}
+	stream.pending, err = stream.readChunk(reader, int(length))
+	return
+}
+
+// Read reads and decrypts the next chunk from the reader if there is no pending payload, and copies payload into b.
+func (stream *AEADStream) Read(reader io.Reader, b []byte) (n int, err error) {
+	if len(stream.pending) == 0 {
+		var lengthBytes []byte
+		if lengthBytes, err = stream.readChunk(reader, 2); err != nil {
+			return
+		}
+		if err = stream.readPayload(reader, lengthBytes); err != nil {
+			return
+		}
+	}
+	n = copy(b, stream.pending)
+	stream.pending = stream.pending[n:]
+	return
+}
+
+// Seal encrypts the payload into length-prefixed chunks and appends them to dest.
+func (stream *AEADStream) Seal(dest, payload []byte) []byte {
+	for len(payload) > 0 {
+		size := len(payload)
+		if size > AEADMaxPayloadSize {
+			size = AEADMaxPayloadSize
+		}
+		lengthBytes := []byte{byte(size >> 8), byte(size)}
+		dest = stream.session.Seal(dest, stream.nonce, lengthBytes, nil)
+		incrementNonce(stream.nonce)
+		dest = stream.session.Seal(dest, stream.nonce, payload[:size], nil)
+		incrementNonce(stream.nonce)
+		payload = payload[size:]
+	}
+	return dest
+}
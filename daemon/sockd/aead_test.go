@@ -0,0 +1,158 @@
+package sockd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+func TestAEADCipher_Packet(t *testing.T) {
+	if _, err := NewAEADCipher("rot13", "abcdefg"); err == nil {
+		t.Fatal("should have rejected unknown cipher")
+	}
+	for _, name := range []string{CipherAES256GCM, CipherChaCha20Poly1305} {
+		cip, err := NewAEADCipher(name, "abcdefg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := cip.SealPacket([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packet) != cip.SaltLength()+len("hello")+16 {
+			t.Fatal(name, len(packet))
+		}
+		if plainText, err := cip.OpenPacket(packet); err != nil || string(plainText) != "hello" {
+			t.Fatal(name, err, plainText)
+		}
+		// Tampered packet must be rejected
+		packet[len(packet)-1]++
+		if _, err := cip.OpenPacket(packet); err == nil {
+			t.Fatal(name, "did not reject tampered packet")
+		}
+		if _, err := cip.OpenPacket(packet[:10]); err != ErrAEADPacketTooShort {
+			t.Fatal(name, err)
+		}
+		// Packet encrypted by a different password must be rejected
+		otherCip, err := NewAEADCipher(name, "gfedcba")
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err = otherCip.SealPacket([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cip.OpenPacket(packet); err == nil {
+			t.Fatal(name, "did not reject packet of different password")
+		}
+	}
+}
+
+func TestTCPCipherConnection_AEAD(t *testing.T) {
+	for _, name := range []string{CipherAES256GCM, CipherChaCha20Poly1305} {
+		daemon := &TCPDaemon{Password: "abcdefg", Cipher: name, TCPPort: 1, PerIPLimit: 10}
+		if err := daemon.Initialise(); err != nil {
+			t.Fatal(err)
+		}
+		clientConn, serverConn := net.Pipe()
+		client := NewTCPCipherConnection(daemon, clientConn, daemon.cipher.Copy(), lalog.Logger{})
+		server := NewTCPCipherConnection(daemon, serverConn, daemon.cipher.Copy(), lalog.Logger{})
+		// The data spans across several chunks
+		data := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 3*AEADMaxPayloadSize)
+		go func() {
+			if n, err := client.Write(data); err != nil || n != len(data) {
+				t.Error(err, n)
+			}
+			if n, err := client.Write([]byte("end")); err != nil || n != 3 {
+				t.Error(err, n)
+			}
+		}()
+		received := make([]byte, len(data)+3)
+		if _, err := io.ReadFull(server, received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received[:len(data)], data) || string(received[len(data):]) != "end" {
+			t.Fatal(name, "data mismatch")
+		}
+		// Reply in the opposite direction
+		go func() {
+			if _, err := server.Write([]byte("reply")); err != nil {
+				t.Error(err)
+			}
+		}()
+		reply := make([]byte, 5)
+		if _, err := io.ReadFull(client, reply); err != nil || string(reply) != "reply" {
+			t.Fatal(name, err, reply)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestAEADSaltCache(t *testing.T) {
+	cache := NewAEADSaltCache(4)
+	for _, salt := range []string{"a", "b", "c"} {
+		if !cache.Add([]byte(salt)) {
+			t.Fatal(salt)
+		}
+	}
+	if cache.Add([]byte("a")) || cache.Add([]byte("c")) {
+		t.Fatal("should have rejected a recent salt")
+	}
+	// The oldest generation is forgotten as new salts arrive
+	for _, salt := range []string{"d", "e"} {
+		if !cache.Add([]byte(salt)) {
+			t.Fatal(salt)
+		}
+	}
+	if !cache.Add([]byte("a")) || cache.Add([]byte("e")) {
+		t.Fatal("wrong generations")
+	}
+}
+
+func TestTCPCipherConnection_AEADReplayAndLength(t *testing.T) {
+	daemon := &TCPDaemon{Password: "abcdefg", Cipher: CipherChaCha20Poly1305, TCPPort: 1, PerIPLimit: 10}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	cip := daemon.aeadKeys[0].Cipher
+	// readFromServer sends the data to a server connection and reads 5 bytes from it
+	readFromServer := func(data []byte) ([]byte, error) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+		go func() {
+			_, _ = clientConn.Write(data)
+		}()
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(NewTCPCipherConnection(daemon, serverConn, daemon.cipher.Copy(), lalog.Logger{}), buf)
+		return buf, err
+	}
+	salt := cip.NewSalt()
+	session, err := cip.NewSession(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream AEADStream
+	stream.initialise(session)
+	recorded := stream.Seal(append([]byte{}, salt...), []byte("hello"))
+	if buf, err := readFromServer(recorded); err != nil || string(buf) != "hello" {
+		t.Fatal(err, buf)
+	}
+	// The recorded data is rejected when replayed
+	if _, err := readFromServer(recorded); err != ErrAEADSaltReplayed {
+		t.Fatal(err)
+	}
+	// A length beyond the maximum payload size is rejected rather than truncated
+	salt = cip.NewSalt()
+	if session, err = cip.NewSession(salt); err != nil {
+		t.Fatal(err)
+	}
+	oversize := session.Seal(append([]byte{}, salt...), make([]byte, session.NonceSize()), []byte{0x40, 0x05}, nil)
+	if _, err := readFromServer(oversize); err != ErrAEADPayloadTooLarge {
+		t.Fatal(err)
+	}
+}
@@ -25,11 +25,9 @@ func md5Sum(d []byte) []byte {
 	return md5Digest.Sum(nil)
 }
 
-func (cip *Cipher) Initialise(password string) {
-	cip.KeyLength = 32
-	cip.IVLength = 16
-
-	segmentLength := (cip.KeyLength-1)/MD5SumLength + 1
+// deriveKey derives a key of the length from the password using a chain of MD5 digests (EVP_BytesToKey).
+func deriveKey(password string, keyLength int) []byte {
+	segmentLength := (keyLength-1)/MD5SumLength + 1
 	buf := make([]byte, segmentLength*MD5SumLength)
 	copy(buf, md5Sum([]byte(password)))
 	destinationBuf := make([]byte, MD5SumLength+len(password))
@@ -40,7 +38,13 @@ func (cip *Cipher) Initialise(password string) {
 		copy(destinationBuf[MD5SumLength:], password)
 		copy(buf[start:], md5Sum(destinationBuf))
 	}
-	cip.Key = buf[:cip.KeyLength]
+	return buf[:keyLength]
+}
+
+func (cip *Cipher) Initialise(password string) {
+	cip.KeyLength = 32
+	cip.IVLength = 16
+	cip.Key = deriveKey(password, cip.KeyLength)
 }
 
 func (cip *Cipher) GetCipherStream(key, iv []byte) (cipher.Stream, error) {
@@ -0,0 +1,129 @@
+package sockd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+/*
+HTTPConnectDaemon is a plain (unencrypted) HTTP proxy server that only serves the CONNECT method, which is sufficient
+for browsing HTTPS websites and tunneling other TCP protocols. Clients authenticate with Proxy-Authorization basic
+authentication of a named user of sockd who is allowed to use the port.
+*/
+type HTTPConnectDaemon struct {
+	Address    string `json:"Address"`
+	PerIPLimit int    `json:"PerIPLimit"`
+	TCPPort    int    `json:"TCPPort"`
+
+	DNSDaemon *dnsd.Daemon `json:"-"` // it is assumed to be already initialised
+
+	userAccounts []*UserAccount
+	policy       *DestinationPolicy
+	tcpServer    *common.TCPServer
+}
+
+func (daemon *HTTPConnectDaemon) Initialise() error {
+	if len(daemon.userAccounts) == 0 {
+		return fmt.Errorf("sockd.HTTPConnectDaemon.Initialise: there is no user for port %d", daemon.TCPPort)
+	}
+	var err error
+	if daemon.policy, err = getDefaultPolicy(daemon.policy, daemon.DNSDaemon); err != nil {
+		return err
+	}
+	daemon.tcpServer = &common.TCPServer{
+		ListenAddr:  daemon.Address,
+		ListenPort:  daemon.TCPPort,
+		AppName:     "sockd",
+		App:         daemon,
+		LimitPerSec: daemon.PerIPLimit,
+	}
+	daemon.tcpServer.Initialise()
+	return nil
+}
+
+func (daemon *HTTPConnectDaemon) GetTCPStatsCollector() *misc.Stats {
+	return misc.SOCKDStatsTCP
+}
+
+func (daemon *HTTPConnectDaemon) HandleTCPConnection(logger lalog.Logger, ip string, client *net.TCPConn) {
+	defer func() {
+		_ = client.Close()
+	}()
+	if err := client.SetDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
+		return
+	}
+	reader := bufio.NewReader(client)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		logger.Warning("HandleTCPConnection", ip, err, "failed to read request")
+		return
+	}
+	if req.Method != http.MethodConnect {
+		logger.Info("HandleTCPConnection", ip, nil, "unsupported method %s", req.Method)
+		_ = writeHTTPStatus(client, http.StatusMethodNotAllowed, "Allow: CONNECT\r\n")
+		return
+	}
+	// Decode the proxy authorization header like an ordinary authorization header
+	name, password, _ := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth()
+	user, ok := authenticate(daemon.userAccounts, daemon.TCPPort, name, password)
+	if !ok {
+		logger.Info("HandleTCPConnection", ip, ErrBadCredentials, "failed to authenticate user \"%s\"", name)
+		_ = writeHTTPStatus(client, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"sockd\"\r\n")
+		return
+	}
+	destNoPort, portStr, err := net.SplitHostPort(req.Host)
+	if port, portErr := strconv.Atoi(portStr); err != nil || portErr != nil || port < 1 || port > 65535 || strings.ContainsRune(destNoPort, 0) {
+		logger.Warning("HandleTCPConnection", ip, err, "invalid destination \"%s\"", req.Host)
+		_ = writeHTTPStatus(client, http.StatusBadRequest, "")
+		return
+	}
+	// Data that arrives right after the request header should be relayed too
+	bufferedClient := &bufferedConn{Conn: client, reader: reader}
+	relayTCP(logger, ip, daemon.policy, user, bufferedClient, net.JoinHostPort(destNoPort, portStr), func(_ net.Conn, err error) error {
+		switch {
+		case err == nil:
+			return writeHTTPStatus(client, http.StatusOK, "")
+		case IsDeniedDestination(err), err == ErrUserMayNotTransfer, err == ErrUserTooManyConnections:
+			return writeHTTPStatus(client, http.StatusForbidden, "")
+		default:
+			return writeHTTPStatus(client, http.StatusBadGateway, "")
+		}
+	})
+}
+
+// writeHTTPStatus writes a response status line along with additional header lines (each ends with CRLF), and no body.
+func writeHTTPStatus(client net.Conn, code int, header string) error {
+	if code != http.StatusOK {
+		header += "Content-Length: 0\r\n"
+	}
+	_, err := fmt.Fprintf(client, "HTTP/1.1 %d %s\r\n%s\r\n", code, http.StatusText(code), header)
+	return err
+}
+
+// bufferedConn is a connection that reads from a buffered reader, which holds data not yet consumed from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func (daemon *HTTPConnectDaemon) StartAndBlock() error {
+	return daemon.tcpServer.StartAndBlock()
+}
+
+func (daemon *HTTPConnectDaemon) Stop() {
+	daemon.tcpServer.Stop()
+}
@@ -0,0 +1,69 @@
+package sockd
+
+import (
+	"net"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+/*
+getDefaultPolicy returns the policy if it is not nil, or otherwise a policy that applies the default restrictions -
+reserved addresses and names blacklisted by the DNS daemon are denied and the rest are allowed.
+*/
+func getDefaultPolicy(policy *DestinationPolicy, dnsDaemon *dnsd.Daemon) (*DestinationPolicy, error) {
+	if policy != nil {
+		return policy, nil
+	}
+	policy = &DestinationPolicy{}
+	return policy, policy.Initialise(lalog.Logger{ComponentName: "sockd"}, dnsDaemon)
+}
+
+/*
+relayTCP serves a TCP destination requested by an authenticated client of the plain (unencrypted) proxy modes.
+It checks the destination and user's status, connects to the destination, and invokes respond to inform the client of
+the outcome - either the connected destination or the reason of failure. If the destination is connected and respond
+succeeds, the function pipes data between client and destination until either side closes the connection.
+*/
+func relayTCP(logger lalog.Logger, ip string, policy *DestinationPolicy, user *UserAccount, client net.Conn,
+	destWithPort string, respond func(dest net.Conn, err error) error) {
+	dialAddr, err := policy.Evaluate("tcp", ip, destWithPort)
+	if err != nil {
+		logger.Info("relayTCP", ip, err, "will not serve %s", destWithPort)
+		_ = respond(nil, err)
+		return
+	}
+	var countBytesIn, countBytesOut func(int) bool
+	if user != nil {
+		if !user.MayTransfer() {
+			logger.Info("relayTCP", ip, nil, "user %s is disabled or has exceeded data quota", user.Name)
+			_ = respond(nil, ErrUserMayNotTransfer)
+			return
+		}
+		if !user.BeginTCPConnection() {
+			logger.Info("relayTCP", ip, nil, "user %s has too many connections", user.Name)
+			_ = respond(nil, ErrUserTooManyConnections)
+			return
+		}
+		defer user.EndTCPConnection()
+		countBytesIn, countBytesOut = user.CountBytesIn, user.CountBytesOut
+	}
+	dest, err := net.DialTimeout("tcp", dialAddr, IOTimeoutSec*time.Second)
+	if err != nil {
+		logger.Warning("relayTCP", ip, err, "failed to connect to destination \"%s\"", destWithPort)
+		_ = respond(nil, err)
+		return
+	}
+	if err := respond(dest, nil); err != nil {
+		logger.Warning("relayTCP", ip, err, "failed to respond to client")
+		_ = dest.Close()
+		return
+	}
+	if tcpConn, ok := client.(*net.TCPConn); ok {
+		TweakTCPConnection(tcpConn)
+	}
+	TweakTCPConnection(dest.(*net.TCPConn))
+	go PipeTCPConnection(client, dest, false, countBytesIn)
+	PipeTCPConnection(dest, client, false, countBytesOut)
+}
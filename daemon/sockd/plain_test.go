@@ -0,0 +1,280 @@
+package sockd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+)
+
+// getTestDNSDaemon returns an initialised DNS daemon with an empty black list.
+func getTestDNSDaemon(t *testing.T) *dnsd.Daemon {
+	dnsDaemon := &dnsd.Daemon{}
+	if err := dnsDaemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	return dnsDaemon
+}
+
+// startEchoServers starts TCP and UDP servers on localhost that respond with whatever they receive.
+func startEchoServers(t *testing.T) (tcpListener net.Listener, udpServer net.PacketConn) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	udpServer, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, addr, err := udpServer.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udpServer.WriteTo(buf[:n], addr)
+		}
+	}()
+	return
+}
+
+// socks5Request authenticates with the SOCKS5 server and sends a request, it returns the reply code and bound address.
+func socks5Request(t *testing.T, port int, name, password string, cmd byte, dest net.Addr) (conn net.Conn, authOK bool, code byte, bound *net.UDPAddr) {
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, 2)
+	if _, err := conn.Write([]byte{SOCKS5Version, 2, 0, SOCKS5MethodUserPass}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != SOCKS5MethodUserPass {
+		t.Fatal(err, resp)
+	}
+	auth := append(append([]byte{SOCKS5AuthVersion, byte(len(name))}, name...), byte(len(password)))
+	if _, err := conn.Write(append(auth, password...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp[1] != 0 {
+		return conn, false, 0, nil
+	}
+	header, headerLength := MakeUDPRequestHeader(dest)
+	if _, err := conn.Write(append([]byte{SOCKS5Version, cmd, 0}, header[:headerLength]...)); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 3+UDPIPv4PacketLength)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	bound = &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+	return conn, true, reply[1], bound
+}
+
+func TestSOCKS5Daemon(t *testing.T) {
+	tcpEcho, udpEcho := startEchoServers(t)
+	defer tcpEcho.Close()
+	defer udpEcho.Close()
+	daemon := Daemon{
+		Address:     "127.0.0.1",
+		Password:    "abcdefg",
+		PerIPLimit:  100,
+		SOCKS5Ports: []int{27103},
+		Users:       []User{{Name: "alice", Password: "alicepass", Ports: []int{27103}}},
+		Policy:      DestinationPolicy{AllowLANCIDRs: []string{"127.0.0.0/8"}},
+		DNSDaemon:   getTestDNSDaemon(t),
+	}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := daemon.StartAndBlock(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer daemon.Stop()
+	time.Sleep(2 * time.Second)
+
+	// Incorrect password
+	if conn, authOK, _, _ := socks5Request(t, 27103, "alice", "wrong", SOCKS5CmdConnect, tcpEcho.Addr()); authOK {
+		t.Fatal("should have failed authentication")
+	} else {
+		_ = conn.Close()
+	}
+	// Reserved destination that is not opted in as LAN destination
+	if conn, authOK, code, _ := socks5Request(t, 27103, "alice", "alicepass", SOCKS5CmdConnect, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}); !authOK || code != SOCKS5ReplyNotAllowed {
+		t.Fatal(authOK, code)
+	} else {
+		_ = conn.Close()
+	}
+	// The shared password is not accepted by plain proxy
+	if conn, authOK, _, _ := socks5Request(t, 27103, "anyone", "abcdefg", SOCKS5CmdConnect, tcpEcho.Addr()); authOK {
+		t.Fatal("should have refused the shared password")
+	} else {
+		_ = conn.Close()
+	}
+	// Unsupported command (BIND)
+	if conn, authOK, code, _ := socks5Request(t, 27103, "alice", "alicepass", 2, tcpEcho.Addr()); !authOK || code != SOCKS5ReplyCommandNotSupported {
+		t.Fatal(authOK, code)
+	} else {
+		_ = conn.Close()
+	}
+
+	conn, authOK, code, _ := socks5Request(t, 27103, "alice", "alicepass", SOCKS5CmdConnect, tcpEcho.Addr())
+	if !authOK || code != SOCKS5ReplySucceeded {
+		t.Fatal(authOK, code)
+	}
+	echo := make([]byte, 5)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(conn, echo); err != nil || string(echo) != "hello" {
+		t.Fatal(err, string(echo))
+	}
+	_ = conn.Close()
+
+	// UDP association
+	conn, authOK, code, relayAddr := socks5Request(t, 27103, "alice", "alicepass", SOCKS5CmdUDPAssociate, &net.UDPAddr{IP: net.IPv4zero})
+	if !authOK || code != SOCKS5ReplySucceeded || relayAddr.Port == 0 {
+		t.Fatal(authOK, code, relayAddr)
+	}
+	udpClient, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, headerLength := MakeUDPRequestHeader(udpEcho.LocalAddr())
+	if _, err := udpClient.Write(append(append(make([]byte, SOCKS5UDPHeaderLength), header[:headerLength]...), "hello"...)); err != nil {
+		t.Fatal(err)
+	}
+	datagram := make([]byte, MaxPacketSize)
+	_ = udpClient.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := udpClient.Read(datagram)
+	if err != nil || string(datagram[:n]) != string(append(append(make([]byte, SOCKS5UDPHeaderLength), header[:headerLength]...), "hello"...)) {
+		t.Fatal(err, datagram[:n])
+	}
+	_ = udpClient.Close()
+	_ = conn.Close()
+
+	if usage := daemon.GetUserUsage(); usage[0].TCPConnections != 1 || usage[0].UDPPackets != 1 || usage[0].BytesIn != 10 || usage[0].BytesOut != 10 {
+		t.Fatalf("%+v", usage)
+	}
+}
+
+func TestHTTPConnectDaemon(t *testing.T) {
+	tcpEcho, udpEcho := startEchoServers(t)
+	defer tcpEcho.Close()
+	defer udpEcho.Close()
+	daemon := Daemon{
+		Address:          "127.0.0.1",
+		Password:         "abcdefg",
+		PerIPLimit:       100,
+		HTTPConnectPorts: []int{27104},
+		Users:            []User{{Name: "bob", Password: "bobpass"}},
+		Policy:           DestinationPolicy{AllowLANCIDRs: []string{"127.0.0.0/8"}},
+		DNSDaemon:        getTestDNSDaemon(t),
+	}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := daemon.StartAndBlock(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer daemon.Stop()
+	time.Sleep(2 * time.Second)
+
+	request := func(method, auth, dest string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", "127.0.0.1:27104")
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := dest
+		if method != http.MethodConnect {
+			target = "http://" + target + "/"
+		}
+		req := method + " " + target + " HTTP/1.1\r\nHost: " + dest + "\r\n"
+		if auth != "" {
+			req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(auth)) + "\r\n"
+		}
+		if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, reader, resp
+	}
+	for _, test := range []struct {
+		method, auth, dest string
+		status             int
+	}{
+		{http.MethodGet, "bob:bobpass", tcpEcho.Addr().String(), http.StatusMethodNotAllowed},
+		{http.MethodConnect, "", tcpEcho.Addr().String(), http.StatusProxyAuthRequired},
+		{http.MethodConnect, "anyone:wrong", tcpEcho.Addr().String(), http.StatusProxyAuthRequired},
+		{http.MethodConnect, "anyone:abcdefg", tcpEcho.Addr().String(), http.StatusProxyAuthRequired},
+		{http.MethodConnect, "bob:bobpass", "10.0.0.1:80", http.StatusForbidden},
+	} {
+		conn, _, resp := request(test.method, test.auth, test.dest)
+		if resp.StatusCode != test.status {
+			t.Fatal(test.method, test.auth, resp.StatusCode)
+		}
+		_ = conn.Close()
+	}
+
+	conn, reader, resp := request(http.MethodConnect, "bob:bobpass", tcpEcho.Addr().String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.StatusCode)
+	}
+	echo := make([]byte, 5)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(reader, echo); err != nil || string(echo) != "hello" {
+		t.Fatal(err, string(echo))
+	}
+	_ = conn.Close()
+}
+
+func TestUDPDestinations(t *testing.T) {
+	dests := make(udpDestinations)
+	now := time.Now()
+	for i := 0; i < SOCKS5MaxUDPDestinations; i++ {
+		dests.use("192.0.2.1:"+strconv.Itoa(i), now.Add(time.Duration(i)*time.Second))
+	}
+	if !dests.has("192.0.2.1:0", now) || dests.has("192.0.2.2:0", now) {
+		t.Fatal("wrong destinations")
+	}
+	// Using the first destination again makes the second one the least recently used
+	dests.use("192.0.2.1:0", now.Add(time.Hour))
+	dests.use("192.0.2.2:0", now.Add(time.Hour))
+	if len(dests) != SOCKS5MaxUDPDestinations || !dests.has("192.0.2.1:0", now) || dests.has("192.0.2.1:1", now) || !dests.has("192.0.2.2:0", now) {
+		t.Fatal("did not forget the least recently used destination")
+	}
+	// Idle destinations are no longer relayed
+	if dests.has("192.0.2.2:0", now.Add(time.Hour+IOTimeoutSec*time.Second)) {
+		t.Fatal("should have expired")
+	}
+}
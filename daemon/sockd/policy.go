@@ -0,0 +1,312 @@
+package sockd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+const (
+	PolicyActionAllow = "allow"
+	PolicyActionDeny  = "deny"
+)
+
+var (
+	// ErrReservedDestination is returned when a proxy client asks for a reserved address that is not opted in as a LAN destination.
+	ErrReservedDestination = errors.New("destination is a reserved address")
+	// ErrBlacklistedDestination is returned when a proxy client asks for an address blacklisted by DNS daemon.
+	ErrBlacklistedDestination = errors.New("destination is blacklisted")
+	// ErrPolicyDeniedDestination is returned when the destination is denied by a policy rule or the default action.
+	ErrPolicyDeniedDestination = errors.New("destination is denied by policy")
+)
+
+// IsDeniedDestination returns true only if the error is a result of destination policy decision.
+func IsDeniedDestination(err error) bool {
+	return err == ErrReservedDestination || err == ErrBlacklistedDestination || err == ErrPolicyDeniedDestination
+}
+
+// portRange is an inclusive range of port numbers.
+type portRange struct {
+	from, to int
+}
+
+// timeWindow is a range of minutes since midnight, it wraps around midnight if the beginning is later than the end.
+type timeWindow struct {
+	from, to int
+}
+
+// contains returns true only if the time of day falls into the window.
+func (window timeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if window.from <= window.to {
+		return minute >= window.from && minute < window.to
+	}
+	return minute >= window.from || minute < window.to
+}
+
+/*
+PolicyRule matches destinations by their attributes and decides whether they are allowed or denied. A destination
+matches the rule only if it matches all of the attributes specified by the rule, attributes left empty match any
+destination.
+*/
+type PolicyRule struct {
+	// Action is either "allow" or "deny".
+	Action string `json:"Action"`
+	// Protocols is a list of "tcp" and "udp".
+	Protocols []string `json:"Protocols"`
+	// CIDRs match the destination IP address, domain name destinations are matched by their resolved address.
+	CIDRs []string `json:"CIDRs"`
+	/*
+		Domains are domain name patterns that match the destination requested by its domain name. A pattern matches the
+		name itself and all of its sub-domains, and may contain wildcards "*" and "?".
+	*/
+	Domains []string `json:"Domains"`
+	// Ports is a list of port numbers (e.g. "443") and port ranges (e.g. "8000-8999").
+	Ports []string `json:"Ports"`
+	// TimeWindows is a list of time of day ranges (e.g. "08:00-18:00") in local time zone, a range may wrap around midnight.
+	TimeWindows []string `json:"TimeWindows"`
+
+	cidrs       []*net.IPNet
+	portRanges  []portRange
+	timeWindows []timeWindow
+}
+
+// initialise validates and parses the rule attributes.
+func (rule *PolicyRule) initialise() error {
+	if rule.Action != PolicyActionAllow && rule.Action != PolicyActionDeny {
+		return fmt.Errorf("action must be either \"%s\" or \"%s\"", PolicyActionAllow, PolicyActionDeny)
+	}
+	for _, protocol := range rule.Protocols {
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("unknown protocol \"%s\"", protocol)
+		}
+	}
+	rule.cidrs = make([]*net.IPNet, 0, len(rule.CIDRs))
+	for _, cidr := range rule.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		rule.cidrs = append(rule.cidrs, ipNet)
+	}
+	for i, domain := range rule.Domains {
+		if _, err := path.Match(domain, ""); err != nil || domain == "" {
+			return fmt.Errorf("malformed domain name pattern \"%s\"", domain)
+		}
+		rule.Domains[i] = strings.ToLower(domain)
+	}
+	rule.portRanges = make([]portRange, 0, len(rule.Ports))
+	for _, port := range rule.Ports {
+		fromStr, toStr := port, port
+		if dash := strings.IndexRune(port, '-'); dash != -1 {
+			fromStr, toStr = port[:dash], port[dash+1:]
+		}
+		from, fromErr := strconv.Atoi(strings.TrimSpace(fromStr))
+		to, toErr := strconv.Atoi(strings.TrimSpace(toStr))
+		if fromErr != nil || toErr != nil || from < 1 || to > 65535 || from > to {
+			return fmt.Errorf("malformed port range \"%s\"", port)
+		}
+		rule.portRanges = append(rule.portRanges, portRange{from: from, to: to})
+	}
+	rule.timeWindows = make([]timeWindow, 0, len(rule.TimeWindows))
+	for _, window := range rule.TimeWindows {
+		fromTo := strings.Split(window, "-")
+		if len(fromTo) != 2 {
+			return fmt.Errorf("malformed time window \"%s\"", window)
+		}
+		from, fromErr := time.Parse("15:04", strings.TrimSpace(fromTo[0]))
+		to, toErr := time.Parse("15:04", strings.TrimSpace(fromTo[1]))
+		if fromErr != nil || toErr != nil {
+			return fmt.Errorf("malformed time window \"%s\"", window)
+		}
+		rule.timeWindows = append(rule.timeWindows, timeWindow{from: from.Hour()*60 + from.Minute(), to: to.Hour()*60 + to.Minute()})
+	}
+	return nil
+}
+
+// matchDomain returns true only if the domain name matches any of the patterns.
+func (rule *PolicyRule) matchDomain(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, pattern := range rule.Domains {
+		for candidate := name; ; {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+			dot := strings.IndexRune(candidate, '.')
+			if dot == -1 {
+				break
+			}
+			candidate = candidate[dot+1:]
+		}
+	}
+	return false
+}
+
+// match returns true only if the destination matches all attributes specified by the rule.
+func (rule *PolicyRule) match(protocol string, destIP net.IP, destName string, port int, now time.Time) bool {
+	if len(rule.Protocols) > 0 {
+		var matched bool
+		for _, ruleProtocol := range rule.Protocols {
+			matched = matched || ruleProtocol == protocol
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.cidrs) > 0 {
+		var matched bool
+		for _, cidr := range rule.cidrs {
+			matched = matched || cidr.Contains(destIP)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.Domains) > 0 && (destName == "" || !rule.matchDomain(destName)) {
+		return false
+	}
+	if len(rule.portRanges) > 0 {
+		var matched bool
+		for _, portRange := range rule.portRanges {
+			matched = matched || port >= portRange.from && port <= portRange.to
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.timeWindows) > 0 {
+		var matched bool
+		for _, window := range rule.timeWindows {
+			matched = matched || window.contains(now)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+DestinationPolicy decides whether a proxy client may reach a destination. Reserved addresses (BlockedReservedCIDR)
+are always denied unless they are explicitly opted in as LAN destinations, and so are the names and addresses
+blacklisted by the DNS daemon. The remaining destinations are evaluated by the rules in order, the first matching
+rule decides the outcome, and the default action applies to destinations that match no rule.
+*/
+type DestinationPolicy struct {
+	Rules []PolicyRule `json:"Rules"`
+	// DefaultAction is either "allow" (default) or "deny".
+	DefaultAction string `json:"DefaultAction"`
+	// AllowLANCIDRs are the reserved (e.g. private LAN) address ranges that proxy clients may reach.
+	AllowLANCIDRs []string `json:"AllowLANCIDRs"`
+	// LogDecisions logs the outcome of each evaluation for troubleshooting.
+	LogDecisions bool `json:"LogDecisions"`
+
+	allowLANCIDRs []*net.IPNet
+	dnsDaemon     *dnsd.Daemon
+	logger        lalog.Logger
+	timeNow       func() time.Time
+}
+
+// Initialise validates the policy configuration. The DNS daemon is used to look up black list.
+func (policy *DestinationPolicy) Initialise(logger lalog.Logger, dnsDaemon *dnsd.Daemon) error {
+	if policy.DefaultAction == "" {
+		policy.DefaultAction = PolicyActionAllow
+	}
+	if policy.DefaultAction != PolicyActionAllow && policy.DefaultAction != PolicyActionDeny {
+		return fmt.Errorf("DestinationPolicy.Initialise: default action must be either \"%s\" or \"%s\"", PolicyActionAllow, PolicyActionDeny)
+	}
+	policy.allowLANCIDRs = make([]*net.IPNet, 0, len(policy.AllowLANCIDRs))
+	for _, cidr := range policy.AllowLANCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("DestinationPolicy.Initialise: malformed LAN CIDR - %v", err)
+		}
+		policy.allowLANCIDRs = append(policy.allowLANCIDRs, ipNet)
+	}
+	for i := range policy.Rules {
+		if err := policy.Rules[i].initialise(); err != nil {
+			return fmt.Errorf("DestinationPolicy.Initialise: rule #%d - %v", i+1, err)
+		}
+	}
+	if policy.timeNow == nil {
+		policy.timeNow = time.Now
+	}
+	policy.dnsDaemon = dnsDaemon
+	policy.logger = logger
+	return nil
+}
+
+// isLANAllowed returns true only if the reserved address is opted in as a LAN destination.
+func (policy *DestinationPolicy) isLANAllowed(ip net.IP) bool {
+	for _, cidr := range policy.allowLANCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Evaluate decides whether the client may reach the destination (host:port) over the protocol ("tcp" or "udp"). If
+the destination is allowed, the function returns the address (IP:port) to connect to - a domain name destination is
+resolved only once here so that it cannot be resolved into a different address later on.
+*/
+func (policy *DestinationPolicy) Evaluate(protocol, clientIP, destWithPort string) (dialAddr string, err error) {
+	destNoPort, portStr, err := net.SplitHostPort(destWithPort)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", err
+	}
+	destIP := net.ParseIP(destNoPort)
+	destName := ""
+	decision := ""
+	defer func() {
+		if policy.LogDecisions {
+			policy.logger.Info("Evaluate", clientIP, err, "%s destination %s (%v) - %s", protocol, destWithPort, destIP, decision)
+		}
+	}()
+	if destIP == nil {
+		destName = destNoPort
+		if policy.dnsDaemon.IsInBlacklist(destName) {
+			decision = "deny by black list"
+			return "", ErrBlacklistedDestination
+		}
+		resolvedAddr, resolveErr := net.ResolveIPAddr("ip", destName)
+		if resolveErr != nil {
+			decision = "failed to resolve name"
+			return "", resolveErr
+		}
+		destIP = resolvedAddr.IP
+	}
+	if policy.dnsDaemon.IsInBlacklist(destIP.String()) {
+		decision = "deny by black list"
+		return "", ErrBlacklistedDestination
+	}
+	if IsReservedAddr(destIP) && !policy.isLANAllowed(destIP) {
+		decision = "deny by reserved address"
+		return "", ErrReservedDestination
+	}
+	action, matchedBy := policy.DefaultAction, "default action"
+	now := policy.timeNow()
+	for i, rule := range policy.Rules {
+		if rule.match(protocol, destIP, destName, port, now) {
+			action, matchedBy = rule.Action, fmt.Sprintf("rule #%d", i+1)
+			break
+		}
+	}
+	decision = action + " by " + matchedBy
+	if action == PolicyActionDeny {
+		return "", ErrPolicyDeniedDestination
+	}
+	return net.JoinHostPort(destIP.String(), portStr), nil
+}
@@ -0,0 +1,111 @@
+package sockd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+func TestDestinationPolicy_Initialise(t *testing.T) {
+	for _, policy := range []DestinationPolicy{
+		{DefaultAction: "maybe"},
+		{AllowLANCIDRs: []string{"192.168.0.0"}},
+		{Rules: []PolicyRule{{Action: ""}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, Protocols: []string{"icmp"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, CIDRs: []string{"1.2.3.4"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, Domains: []string{"[a"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, Ports: []string{"0"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, Ports: []string{"90-80"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, Ports: []string{"80-65536"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, TimeWindows: []string{"08:00"}}}},
+		{Rules: []PolicyRule{{Action: PolicyActionDeny, TimeWindows: []string{"08:00-25:00"}}}},
+	} {
+		if err := policy.Initialise(lalog.Logger{}, nil); err == nil {
+			t.Fatalf("did not error: %+v", policy)
+		}
+	}
+	policy := DestinationPolicy{}
+	if err := policy.Initialise(lalog.Logger{}, nil); err != nil || policy.DefaultAction != PolicyActionAllow {
+		t.Fatal(err, policy.DefaultAction)
+	}
+}
+
+func TestDestinationPolicy_Evaluate(t *testing.T) {
+	policy := DestinationPolicy{
+		Rules: []PolicyRule{
+			{Action: PolicyActionDeny, Domains: []string{"example.com"}, Ports: []string{"8000-8999"}},
+			{Action: PolicyActionAllow, Domains: []string{"*.example.com"}},
+			{Action: PolicyActionDeny, Protocols: []string{"udp"}, CIDRs: []string{"8.8.0.0/16"}},
+			{Action: PolicyActionAllow, CIDRs: []string{"8.8.0.0/16", "10.0.0.0/8"}},
+			{Action: PolicyActionAllow, Ports: []string{"443"}, TimeWindows: []string{"22:00-06:00"}},
+		},
+		DefaultAction: PolicyActionDeny,
+		AllowLANCIDRs: []string{"10.0.0.0/8"},
+		LogDecisions:  true,
+	}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	policy.timeNow = func() time.Time {
+		return now
+	}
+	if err := policy.Initialise(lalog.Logger{}, getTestDNSDaemon(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		protocol, dest string
+		err            error
+	}{
+		// Reserved addresses are denied unless opted in as LAN destinations
+		{"tcp", "127.0.0.1:80", ErrReservedDestination},
+		{"tcp", "localhost:80", ErrReservedDestination},
+		{"tcp", "192.168.1.1:80", ErrReservedDestination},
+		{"tcp", "10.1.2.3:80", nil},
+		// CIDR and protocol
+		{"tcp", "8.8.8.8:53", nil},
+		{"udp", "8.8.8.8:53", ErrPolicyDeniedDestination},
+		// Default action
+		{"tcp", "1.1.1.1:53", ErrPolicyDeniedDestination},
+		// Time window does not cover noon
+		{"tcp", "1.1.1.1:443", ErrPolicyDeniedDestination},
+	} {
+		if _, err := policy.Evaluate(test.protocol, "1.2.3.4", test.dest); err != test.err {
+			t.Fatal(test.protocol, test.dest, err)
+		}
+	}
+	// Time window wraps around midnight
+	now = time.Date(2020, 1, 1, 23, 0, 0, 0, time.Local)
+	if dialAddr, err := policy.Evaluate("tcp", "1.2.3.4", "1.1.1.1:443"); err != nil || dialAddr != "1.1.1.1:443" {
+		t.Fatal(dialAddr, err)
+	}
+	now = time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local)
+	if _, err := policy.Evaluate("tcp", "1.2.3.4", "1.1.1.1:443"); err != ErrPolicyDeniedDestination {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyRule_Match(t *testing.T) {
+	rule := PolicyRule{Action: PolicyActionDeny, Domains: []string{"Example.com", "*.test", "a?c.org"}, Ports: []string{"80", "8000-8080"}}
+	if err := rule.initialise(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, test := range []struct {
+		name  string
+		port  int
+		match bool
+	}{
+		{"example.com", 80, true},
+		{"WWW.EXAMPLE.COM.", 8080, true},
+		{"example.com", 81, false},
+		{"notexample.com", 80, false},
+		{"a.b.test", 8000, true},
+		{"abc.org", 80, true},
+		{"www.abc.org", 80, true},
+		{"abbc.org", 80, false},
+		{"", 80, false},
+	} {
+		if rule.match("tcp", nil, test.name, test.port, now) != test.match {
+			t.Fatal(test.name, test.port)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -108,6 +110,28 @@ func TestSockd(sockd *Daemon, t testingstub.T) {
 			t.Fatal(err, n)
 		}
 	}
+	// Plain proxy modes refuse clients that do not authenticate
+	for _, port := range sockd.SOCKS5Ports {
+		fmt.Println("knocking on SOCKS5 port", port)
+		resp := make([]byte, 2)
+		if conn, err := net.Dial("tcp", sockd.PlainProxyAddress+":"+strconv.Itoa(port)); err != nil {
+			t.Fatal(err)
+		} else if _, err := conn.Write([]byte{SOCKS5Version, 1, 0}); err != nil {
+			t.Fatal(err)
+		} else if _, err := io.ReadFull(conn, resp); err != nil || resp[0] != SOCKS5Version || resp[1] != SOCKS5MethodNoneAcceptable {
+			t.Fatal(err, resp)
+		}
+	}
+	for _, port := range sockd.HTTPConnectPorts {
+		fmt.Println("knocking on HTTP CONNECT port", port)
+		if conn, err := net.Dial("tcp", sockd.PlainProxyAddress+":"+strconv.Itoa(port)); err != nil {
+			t.Fatal(err)
+		} else if _, err := conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		} else if resp, err := ioutil.ReadAll(conn); err != nil || !strings.HasPrefix(string(resp), "HTTP/1.1 407") {
+			t.Fatal(err, string(resp))
+		}
+	}
 	// Daemon should stop within a second
 	sockd.Stop()
 	time.Sleep(1 * time.Second)
@@ -126,11 +150,28 @@ type Daemon struct {
 	PerIPLimit int    `json:"PerIPLimit"`
 	TCPPorts   []int  `json:"TCPPorts"`
 	UDPPorts   []int  `json:"UDPPorts"`
+	// PortCiphers maps TCP and UDP port numbers to the names of ciphers used on them, other ports use the legacy cipher.
+	PortCiphers map[int]string `json:"PortCiphers"`
+	/*
+		SOCKS5Ports and HTTPConnectPorts serve plain (unencrypted) SOCKS5 and HTTP CONNECT proxy to ordinary programs on
+		the LAN. Only named users may use them, the shared password is not accepted.
+	*/
+	SOCKS5Ports      []int `json:"SOCKS5Ports"`
+	HTTPConnectPorts []int `json:"HTTPConnectPorts"`
+	// PlainProxyAddress is the address that SOCKS5 and HTTP CONNECT ports listen on. It defaults to 127.0.0.1.
+	PlainProxyAddress string `json:"PlainProxyAddress"`
+	// Users have individual passwords, usage counters and quotas. They may only use ports of AEAD ciphers and plain proxy modes.
+	Users []User `json:"Users"`
+	// Policy decides which destinations proxy clients may reach, it applies to all ports and modes.
+	Policy DestinationPolicy `json:"Policy"`
 
 	DNSDaemon *dnsd.Daemon `json:"-"` // it is assumed to be already initialised
 
-	tcpDaemons []*TCPDaemon
-	udpDaemons []*UDPDaemon
+	tcpDaemons         []*TCPDaemon
+	udpDaemons         []*UDPDaemon
+	socks5Daemons      []*SOCKS5Daemon
+	httpConnectDaemons []*HTTPConnectDaemon
+	userAccounts       []*UserAccount
 
 	logger lalog.Logger
 }
@@ -139,6 +180,10 @@ func (daemon *Daemon) Initialise() error {
 	if daemon.Address == "" {
 		daemon.Address = "0.0.0.0"
 	}
+	if daemon.PlainProxyAddress == "" {
+		// Plain proxy modes are unencrypted, they should only be reachable from the computer itself or a trusted LAN.
+		daemon.PlainProxyAddress = "127.0.0.1"
+	}
 	if daemon.PerIPLimit < 1 {
 		daemon.PerIPLimit = 96
 	}
@@ -149,17 +194,81 @@ func (daemon *Daemon) Initialise() error {
 	if daemon.DNSDaemon == nil {
 		return errors.New("sockd.Initialise: dns daemon must be assigned")
 	}
-	if daemon.TCPPorts == nil || len(daemon.TCPPorts) == 0 || daemon.TCPPorts[0] < 1 {
+	if len(daemon.TCPPorts) == 0 && len(daemon.SOCKS5Ports) == 0 && len(daemon.HTTPConnectPorts) == 0 ||
+		len(daemon.TCPPorts) > 0 && daemon.TCPPorts[0] < 1 {
 		return errors.New("sockd.Initialise: there has to be at least one TCP listen port")
 	}
-	if len(daemon.Password) < 7 {
+	// The shared password may be left empty only if all ports are used by named users
+	if len(daemon.Password) < 7 && (len(daemon.Users) == 0 || daemon.Password != "") {
 		return errors.New("sockd.Initialise: password must be at least 7 characters long")
 	}
+	for port, cipherName := range daemon.PortCiphers {
+		if cipherName == CipherLegacy {
+			continue
+		}
+		if _, err := NewAEADCipher(cipherName, daemon.Password); err != nil {
+			return fmt.Errorf("sockd.Initialise: port %d uses unsupported cipher \"%s\"", port, cipherName)
+		}
+	}
+	daemon.userAccounts = make([]*UserAccount, 0, len(daemon.Users))
+	userNames := make(map[string]bool)
+	for _, user := range daemon.Users {
+		if user.Name == "" {
+			return errors.New("sockd.Initialise: user name must not be empty")
+		}
+		if userNames[user.Name] {
+			return fmt.Errorf("sockd.Initialise: user name \"%s\" is duplicated", user.Name)
+		}
+		userNames[user.Name] = true
+		if len(user.Password) < 7 {
+			return fmt.Errorf("sockd.Initialise: password of user \"%s\" must be at least 7 characters long", user.Name)
+		}
+		for _, port := range user.Ports {
+			if daemon.isPlainProxyPort(port) {
+				continue
+			}
+			if cipherName := daemon.PortCiphers[port]; cipherName == "" || cipherName == CipherLegacy {
+				return fmt.Errorf("sockd.Initialise: user \"%s\" may only use ports of AEAD ciphers and plain proxy modes, but port %d is not one of them", user.Name, port)
+			}
+		}
+		daemon.userAccounts = append(daemon.userAccounts, NewUserAccount(user))
+	}
+	for _, port := range append(append([]int{}, daemon.SOCKS5Ports...), daemon.HTTPConnectPorts...) {
+		var hasUser bool
+		for _, account := range daemon.userAccounts {
+			hasUser = hasUser || account.MayUsePort(port)
+		}
+		if !hasUser {
+			return fmt.Errorf("sockd.Initialise: plain proxy port %d requires a user, it does not accept the shared password", port)
+		}
+	}
+	if daemon.Password == "" {
+		for _, port := range append(append([]int{}, daemon.TCPPorts...), daemon.UDPPorts...) {
+			if cipherName := daemon.PortCiphers[port]; cipherName == "" || cipherName == CipherLegacy {
+				return fmt.Errorf("sockd.Initialise: port %d uses the legacy cipher that requires a password", port)
+			}
+		}
+	}
+	if err := daemon.Policy.Initialise(daemon.logger, daemon.DNSDaemon); err != nil {
+		return fmt.Errorf("sockd.Initialise: %v", err)
+	}
 	daemon.tcpDaemons = make([]*TCPDaemon, 0)
 	daemon.udpDaemons = make([]*UDPDaemon, 0)
+	daemon.socks5Daemons = make([]*SOCKS5Daemon, 0)
+	daemon.httpConnectDaemons = make([]*HTTPConnectDaemon, 0)
 	return nil
 }
 
+// isPlainProxyPort returns true only if the port serves SOCKS5 or HTTP CONNECT proxy.
+func (daemon *Daemon) isPlainProxyPort(port int) bool {
+	for _, plainPort := range append(append([]int{}, daemon.SOCKS5Ports...), daemon.HTTPConnectPorts...) {
+		if plainPort == port {
+			return true
+		}
+	}
+	return false
+}
+
 func (daemon *Daemon) StartAndBlock() error {
 	wg := new(sync.WaitGroup)
 
@@ -170,7 +279,11 @@ func (daemon *Daemon) StartAndBlock() error {
 				Password:   daemon.Password,
 				PerIPLimit: daemon.PerIPLimit,
 				TCPPort:    tcpPort,
+				Cipher:     daemon.PortCiphers[tcpPort],
 				DNSDaemon:  daemon.DNSDaemon,
+
+				userAccounts: daemon.userAccounts,
+				policy:       &daemon.Policy,
 			}
 			if err := tcpDaemon.Initialise(); err != nil {
 				daemon.Stop()
@@ -194,7 +307,11 @@ func (daemon *Daemon) StartAndBlock() error {
 				Password:   daemon.Password,
 				PerIPLimit: daemon.PerIPLimit,
 				UDPPort:    udpPort,
+				Cipher:     daemon.PortCiphers[udpPort],
 				DNSDaemon:  daemon.DNSDaemon,
+
+				userAccounts: daemon.userAccounts,
+				policy:       &daemon.Policy,
 			}
 			if err := udpDaemon.Initialise(); err != nil {
 				daemon.Stop()
@@ -211,6 +328,54 @@ func (daemon *Daemon) StartAndBlock() error {
 			}(udpDaemon)
 		}
 	}
+	for _, socks5Port := range daemon.SOCKS5Ports {
+		socks5Daemon := &SOCKS5Daemon{
+			Address:    daemon.PlainProxyAddress,
+			PerIPLimit: daemon.PerIPLimit,
+			TCPPort:    socks5Port,
+			DNSDaemon:  daemon.DNSDaemon,
+
+			userAccounts: daemon.userAccounts,
+			policy:       &daemon.Policy,
+		}
+		if err := socks5Daemon.Initialise(); err != nil {
+			daemon.Stop()
+			return err
+		}
+		wg.Add(1)
+		daemon.socks5Daemons = append(daemon.socks5Daemons, socks5Daemon)
+		go func(socks5Daemon *SOCKS5Daemon) {
+			if err := socks5Daemon.StartAndBlock(); err != nil {
+				daemon.logger.Warning("StartAndBlock", fmt.Sprintf("SOCKS5-%d", socks5Daemon.TCPPort), err, "failed to start SOCKS5 daemon")
+				daemon.Stop()
+			}
+			wg.Done()
+		}(socks5Daemon)
+	}
+	for _, httpConnectPort := range daemon.HTTPConnectPorts {
+		httpConnectDaemon := &HTTPConnectDaemon{
+			Address:    daemon.PlainProxyAddress,
+			PerIPLimit: daemon.PerIPLimit,
+			TCPPort:    httpConnectPort,
+			DNSDaemon:  daemon.DNSDaemon,
+
+			userAccounts: daemon.userAccounts,
+			policy:       &daemon.Policy,
+		}
+		if err := httpConnectDaemon.Initialise(); err != nil {
+			daemon.Stop()
+			return err
+		}
+		wg.Add(1)
+		daemon.httpConnectDaemons = append(daemon.httpConnectDaemons, httpConnectDaemon)
+		go func(httpConnectDaemon *HTTPConnectDaemon) {
+			if err := httpConnectDaemon.StartAndBlock(); err != nil {
+				daemon.logger.Warning("StartAndBlock", fmt.Sprintf("HTTPConnect-%d", httpConnectDaemon.TCPPort), err, "failed to start HTTP CONNECT daemon")
+				daemon.Stop()
+			}
+			wg.Done()
+		}(httpConnectDaemon)
+	}
 	wg.Wait()
 	return nil
 }
@@ -222,6 +387,14 @@ func (daemon *Daemon) Stop() {
 	for _, udpDaemon := range daemon.udpDaemons {
 		udpDaemon.Stop()
 	}
+	for _, socks5Daemon := range daemon.socks5Daemons {
+		socks5Daemon.Stop()
+	}
+	for _, httpConnectDaemon := range daemon.httpConnectDaemons {
+		httpConnectDaemon.Stop()
+	}
 	daemon.tcpDaemons = make([]*TCPDaemon, 0)
 	daemon.udpDaemons = make([]*UDPDaemon, 0)
+	daemon.socks5Daemons = make([]*SOCKS5Daemon, 0)
+	daemon.httpConnectDaemons = make([]*HTTPConnectDaemon, 0)
 }
@@ -26,11 +26,24 @@ func TestSockd_StartAndBlock(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	daemon.PortCiphers = map[int]string{27101: "rot13"}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "unsupported cipher") {
+		t.Fatal(err)
+	}
+
 	daemon.Address = "127.0.0.1"
-	daemon.TCPPorts = []int{27101, 23990}
-	daemon.UDPPorts = []int{13781, 38191}
+	daemon.TCPPorts = []int{27101, 23990, 27102}
+	daemon.UDPPorts = []int{13781, 38191, 13782}
+	daemon.SOCKS5Ports = []int{27105}
+	daemon.HTTPConnectPorts = []int{27106}
+	daemon.PortCiphers = map[int]string{23990: CipherAES256GCM, 27102: CipherChaCha20Poly1305, 38191: CipherAES256GCM, 13782: CipherChaCha20Poly1305}
 	daemon.Password = "abcdefg"
 	daemon.PerIPLimit = 10
+	// Plain proxy ports do not accept the shared password
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "requires a user") {
+		t.Fatal(err)
+	}
+	daemon.Users = []User{{Name: "alice", Password: "alicepass"}}
 	if err := daemon.Initialise(); err != nil {
 		t.Fatal(err)
 	}
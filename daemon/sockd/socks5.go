@@ -0,0 +1,339 @@
+package sockd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	SOCKS5Version              = 5
+	SOCKS5AuthVersion          = 1
+	SOCKS5MethodUserPass       = 2
+	SOCKS5MethodNoneAcceptable = 0xff
+	SOCKS5CmdConnect           = 1
+	SOCKS5CmdUDPAssociate      = 3
+
+	SOCKS5ReplySucceeded           = 0
+	SOCKS5ReplyGeneralFailure      = 1
+	SOCKS5ReplyNotAllowed          = 2
+	SOCKS5ReplyHostUnreachable     = 4
+	SOCKS5ReplyCommandNotSupported = 7
+
+	// SOCKS5UDPHeaderLength is the length of reserved bytes and fragment number that precede destination address in a UDP datagram.
+	SOCKS5UDPHeaderLength = 3
+	// SOCKS5MaxUDPDestinations is the maximum number of destinations remembered by a UDP association for relaying their responses.
+	SOCKS5MaxUDPDestinations = 256
+)
+
+var (
+	// ErrBadCredentials is returned when a client of the plain proxy modes presents incorrect user name or password.
+	ErrBadCredentials = errors.New("incorrect user name or password")
+)
+
+/*
+SOCKS5Daemon is a plain (unencrypted) SOCKS5 proxy server (RFC 1928) intended for ordinary programs on the LAN. It
+supports CONNECT and UDP ASSOCIATE commands, and requires clients to authenticate with user name and password
+(RFC 1929) of a named user of sockd who is allowed to use the port.
+*/
+type SOCKS5Daemon struct {
+	Address    string `json:"Address"`
+	PerIPLimit int    `json:"PerIPLimit"`
+	TCPPort    int    `json:"TCPPort"`
+
+	DNSDaemon *dnsd.Daemon `json:"-"` // it is assumed to be already initialised
+
+	userAccounts []*UserAccount
+	policy       *DestinationPolicy
+	tcpServer    *common.TCPServer
+}
+
+func (daemon *SOCKS5Daemon) Initialise() error {
+	if len(daemon.userAccounts) == 0 {
+		return fmt.Errorf("sockd.SOCKS5Daemon.Initialise: there is no user for port %d", daemon.TCPPort)
+	}
+	var err error
+	if daemon.policy, err = getDefaultPolicy(daemon.policy, daemon.DNSDaemon); err != nil {
+		return err
+	}
+	daemon.tcpServer = &common.TCPServer{
+		ListenAddr:  daemon.Address,
+		ListenPort:  daemon.TCPPort,
+		AppName:     "sockd",
+		App:         daemon,
+		LimitPerSec: daemon.PerIPLimit,
+	}
+	daemon.tcpServer.Initialise()
+	return nil
+}
+
+func (daemon *SOCKS5Daemon) GetTCPStatsCollector() *misc.Stats {
+	return misc.SOCKDStatsTCP
+}
+
+func (daemon *SOCKS5Daemon) HandleTCPConnection(logger lalog.Logger, ip string, client *net.TCPConn) {
+	defer func() {
+		_ = client.Close()
+	}()
+	if err := client.SetDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
+		return
+	}
+	user, err := daemon.negotiate(client)
+	if err != nil {
+		logger.Warning("HandleTCPConnection", ip, err, "failed to negotiate authentication")
+		return
+	}
+	// The request begins with version, command, and a reserved byte, followed by the destination address.
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(client, header); err != nil {
+		logger.Warning("HandleTCPConnection", ip, err, "failed to read request")
+		return
+	}
+	if header[0] != SOCKS5Version {
+		logger.Warning("HandleTCPConnection", ip, nil, "unsupported version %d", header[0])
+		return
+	}
+	switch header[1] {
+	case SOCKS5CmdConnect:
+		_, _, destWithPort, err := ReadSOCKSAddress(client)
+		if err != nil {
+			logger.Warning("HandleTCPConnection", ip, err, "failed to get destination address")
+			_ = writeSOCKS5Reply(client, SOCKS5ReplyGeneralFailure, nil)
+			return
+		}
+		relayTCP(logger, ip, daemon.policy, user, client, destWithPort, func(dest net.Conn, err error) error {
+			if err != nil {
+				return writeSOCKS5Reply(client, getSOCKS5ReplyCode(err), nil)
+			}
+			return writeSOCKS5Reply(client, SOCKS5ReplySucceeded, dest.LocalAddr())
+		})
+	case SOCKS5CmdUDPAssociate:
+		// The client may tell the address it will send datagrams from, though it is often left empty.
+		if _, _, _, err := readSOCKSAddress(client, true); err != nil {
+			logger.Warning("HandleTCPConnection", ip, err, "failed to get client address of UDP association")
+			_ = writeSOCKS5Reply(client, SOCKS5ReplyGeneralFailure, nil)
+			return
+		}
+		daemon.associateUDP(logger, ip, client, user)
+	default:
+		logger.Info("HandleTCPConnection", ip, nil, "unsupported command %d", header[1])
+		_ = writeSOCKS5Reply(client, SOCKS5ReplyCommandNotSupported, nil)
+	}
+}
+
+// negotiate carries out method selection and user name/password authentication, and returns the authenticated user.
+func (daemon *SOCKS5Daemon) negotiate(client net.Conn) (*UserAccount, error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != SOCKS5Version {
+		return nil, fmt.Errorf("SOCKS5Daemon.negotiate: unsupported version %d", buf[0])
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(methods, SOCKS5MethodUserPass) == -1 {
+		_, _ = client.Write([]byte{SOCKS5Version, SOCKS5MethodNoneAcceptable})
+		return nil, errors.New("SOCKS5Daemon.negotiate: client does not offer user name/password authentication")
+	}
+	if _, err := client.Write([]byte{SOCKS5Version, SOCKS5MethodUserPass}); err != nil {
+		return nil, err
+	}
+	// The authentication request is made of version, user name length, user name, password length, and password.
+	if _, err := io.ReadFull(client, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != SOCKS5AuthVersion {
+		return nil, fmt.Errorf("SOCKS5Daemon.negotiate: unsupported authentication version %d", buf[0])
+	}
+	name := make([]byte, buf[1])
+	if _, err := io.ReadFull(client, name); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(client, buf[:1]); err != nil {
+		return nil, err
+	}
+	password := make([]byte, buf[0])
+	if _, err := io.ReadFull(client, password); err != nil {
+		return nil, err
+	}
+	user, ok := authenticate(daemon.userAccounts, daemon.TCPPort, string(name), string(password))
+	if !ok {
+		_, _ = client.Write([]byte{SOCKS5AuthVersion, 1})
+		return nil, ErrBadCredentials
+	}
+	_, err := client.Write([]byte{SOCKS5AuthVersion, 0})
+	return user, err
+}
+
+// associateUDP relays UDP datagrams for the client until the control connection is closed.
+func (daemon *SOCKS5Daemon) associateUDP(logger lalog.Logger, ip string, client *net.TCPConn, user *UserAccount) {
+	if user != nil && !user.MayTransfer() {
+		logger.Info("associateUDP", ip, nil, "user %s is disabled or has exceeded data quota", user.Name)
+		_ = writeSOCKS5Reply(client, SOCKS5ReplyNotAllowed, nil)
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: client.LocalAddr().(*net.TCPAddr).IP})
+	if err != nil {
+		logger.Warning("associateUDP", ip, err, "failed to listen for UDP datagrams")
+		_ = writeSOCKS5Reply(client, SOCKS5ReplyGeneralFailure, nil)
+		return
+	}
+	defer func() {
+		_ = relay.Close()
+	}()
+	if err := writeSOCKS5Reply(client, SOCKS5ReplySucceeded, relay.LocalAddr()); err != nil {
+		return
+	}
+	/*
+		The association lasts as long as the control connection, which carries no data and hence should not time out.
+		Instead, the association ends when the relay has not seen a datagram for IOTimeoutSec.
+	*/
+	if err := client.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	go func() {
+		daemon.relayUDP(logger, ip, relay, user)
+		_ = client.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, client)
+}
+
+/*
+udpDestinations are the destinations that a UDP association has recently sent datagrams to, along with the time each
+destination was last used. Datagrams from these destinations are relayed back to the client.
+*/
+type udpDestinations map[string]time.Time
+
+// has returns true if the destination has been used within IOTimeoutSec.
+func (dests udpDestinations) has(addr string, now time.Time) bool {
+	lastUsed, exists := dests[addr]
+	return exists && now.Sub(lastUsed) < IOTimeoutSec*time.Second
+}
+
+// use records the time a destination is used. If there are too many destinations, the least recently used one is forgotten.
+func (dests udpDestinations) use(addr string, now time.Time) {
+	if _, exists := dests[addr]; !exists && len(dests) >= SOCKS5MaxUDPDestinations {
+		var oldestAddr string
+		var oldest time.Time
+		for candidate, lastUsed := range dests {
+			if oldestAddr == "" || lastUsed.Before(oldest) {
+				oldestAddr, oldest = candidate, lastUsed
+			}
+		}
+		delete(dests, oldestAddr)
+	}
+	dests[addr] = now
+}
+
+/*
+relayUDP forwards datagrams that come from the client's IP address to their destinations, and forwards datagrams
+from those destinations back to the client. Datagrams from elsewhere are dropped. It returns when the relay
+connection is closed.
+*/
+func (daemon *SOCKS5Daemon) relayUDP(logger lalog.Logger, ip string, relay *net.UDPConn, user *UserAccount) {
+	clientIP := net.ParseIP(ip)
+	var clientAddr *net.UDPAddr
+	destinations := make(udpDestinations)
+	packet := make([]byte, MaxPacketSize)
+	for {
+		if misc.EmergencyLockDown {
+			lalog.DefaultLogger.Warning("relayUDP", "", misc.ErrEmergencyLockDown, "")
+			return
+		} else if err := relay.SetReadDeadline(time.Now().Add(IOTimeoutSec * time.Second)); err != nil {
+			return
+		}
+		n, from, err := relay.ReadFromUDP(packet)
+		if err != nil {
+			return
+		}
+		if destinations.has(from.String(), time.Now()) && clientAddr != nil {
+			// Forward the response from a destination back to the client
+			if user != nil && !user.CountBytesOut(n) {
+				continue
+			}
+			header, headerLength := MakeUDPRequestHeader(from)
+			response := append(append(make([]byte, SOCKS5UDPHeaderLength), header[:headerLength]...), packet[:n]...)
+			if _, err := relay.WriteToUDP(response, clientAddr); err != nil {
+				logger.Warning("relayUDP", ip, err, "failed to respond to client")
+			}
+			continue
+		} else if !from.IP.Equal(clientIP) {
+			continue
+		}
+		clientAddr = from
+		// Fragmented datagrams are not supported
+		if n < SOCKS5UDPHeaderLength || packet[2] != 0 {
+			continue
+		}
+		reader := bytes.NewReader(packet[SOCKS5UDPHeaderLength:n])
+		_, _, destWithPort, err := ReadSOCKSAddress(reader)
+		if err != nil {
+			logger.Warning("relayUDP", ip, err, "failed to get destination address")
+			continue
+		}
+		dialAddr, err := daemon.policy.Evaluate("udp", ip, destWithPort)
+		if err != nil {
+			logger.Info("relayUDP", ip, err, "will not serve %s", destWithPort)
+			continue
+		}
+		destAddr, err := net.ResolveUDPAddr("udp", dialAddr)
+		if err != nil {
+			logger.Warning("relayUDP", ip, err, "failed to parse destination \"%s\"", dialAddr)
+			continue
+		}
+		payload := packet[n-reader.Len() : n]
+		if user != nil {
+			if !user.MayTransfer() {
+				continue
+			}
+			user.CountUDPPacket()
+			user.CountBytesIn(len(payload))
+		}
+		destinations.use(destAddr.String(), time.Now())
+		if _, err := relay.WriteToUDP(payload, destAddr); err != nil {
+			logger.Warning("relayUDP", ip, err, "failed to forward datagram to \"%s\"", destWithPort)
+		}
+	}
+}
+
+// getSOCKS5ReplyCode returns the reply code that tells the client the reason of failure.
+func getSOCKS5ReplyCode(err error) byte {
+	switch {
+	case err == nil:
+		return SOCKS5ReplySucceeded
+	case IsDeniedDestination(err), err == ErrUserMayNotTransfer, err == ErrUserTooManyConnections:
+		return SOCKS5ReplyNotAllowed
+	default:
+		return SOCKS5ReplyHostUnreachable
+	}
+}
+
+// writeSOCKS5Reply writes a reply to the client's request. If the bound address is nil, the reply carries 0.0.0.0:0.
+func writeSOCKS5Reply(client net.Conn, code byte, boundAddr net.Addr) error {
+	if boundAddr == nil {
+		boundAddr = &net.TCPAddr{IP: net.IPv4zero}
+	}
+	header, headerLength := MakeUDPRequestHeader(boundAddr)
+	_, err := client.Write(append([]byte{SOCKS5Version, code, 0}, header[:headerLength]...))
+	return err
+}
+
+func (daemon *SOCKS5Daemon) StartAndBlock() error {
+	return daemon.tcpServer.StartAndBlock()
+}
+
+func (daemon *SOCKS5Daemon) Stop() {
+	daemon.tcpServer.Stop()
+}
@@ -30,8 +30,10 @@ PipeTCPConnection receives data from the first connection and copies the data in
 The function returns after the first connection is closed or other IO error occurs, and before returning
 the function closes the second connection and optionally writes a random amount of data into the supposedly
 already terminated first connection.
+If countBytes is not nil, it is given the amount of data received each time, and the function returns as soon as
+countBytes returns false (e.g. user has used up the data quota).
 */
-func PipeTCPConnection(fromConn, toConn net.Conn, doWriteRand bool) {
+func PipeTCPConnection(fromConn, toConn net.Conn, doWriteRand bool, countBytes func(int) bool) {
 	defer func() {
 		_ = toConn.Close()
 	}()
@@ -50,6 +52,9 @@ func PipeTCPConnection(fromConn, toConn net.Conn, doWriteRand bool) {
 			} else if _, err := toConn.Write(buf[:length]); err != nil {
 				return
 			}
+			if countBytes != nil && !countBytes(length) {
+				return
+			}
 		}
 		if err != nil {
 			if doWriteRand {
@@ -65,16 +70,33 @@ type TCPDaemon struct {
 	Password   string `json:"Password"`
 	PerIPLimit int    `json:"PerIPLimit"`
 	TCPPort    int    `json:"TCPPort"`
+	// Cipher is the name of cipher used on the port, it defaults to the legacy stream cipher.
+	Cipher string `json:"Cipher"`
 
 	DNSDaemon *dnsd.Daemon `json:"-"` // it is assumed to be already initialised
 
-	cipher    *Cipher
-	tcpServer *common.TCPServer
+	cipher       *Cipher
+	aeadKeys     []AEADKey
+	saltCache    *AEADSaltCache
+	userAccounts []*UserAccount
+	policy       *DestinationPolicy
+	tcpServer    *common.TCPServer
 }
 
 func (daemon *TCPDaemon) Initialise() error {
+	var err error
+	if daemon.policy, err = getDefaultPolicy(daemon.policy, daemon.DNSDaemon); err != nil {
+		return err
+	}
 	daemon.cipher = &Cipher{}
 	daemon.cipher.Initialise(daemon.Password)
+	daemon.aeadKeys = nil
+	if daemon.Cipher != "" && daemon.Cipher != CipherLegacy {
+		if daemon.aeadKeys, err = getAEADKeys(daemon.Cipher, daemon.Password, daemon.TCPPort, daemon.userAccounts); err != nil {
+			return fmt.Errorf("sockd.TCPDaemon.Initialise: %v", err)
+		}
+		daemon.saltCache = NewAEADSaltCache(AEADSaltCacheSize)
+	}
 	daemon.tcpServer = &common.TCPServer{
 		ListenAddr:  daemon.Address,
 		ListenPort:  daemon.TCPPort,
@@ -109,10 +131,24 @@ type TCPCipherConnection struct {
 	mutex             sync.Mutex
 	readBuf, writeBuf []byte
 	logger            lalog.Logger
+
+	/*
+		aeadKeys are the candidate AEAD keys that take place of the legacy stream cipher, the key that authenticates the
+		first chunk of incoming data becomes the AEAD cipher of the connection, and identifies the user.
+	*/
+	aeadKeys                []AEADKey
+	aead                    *AEADCipher
+	user                    *UserAccount
+	aeadRead, aeadWrite     AEADStream
+	aeadReadInit, aeadWrote bool
 }
 
+/*
+NewTCPCipherConnection returns a connection that encrypts and decrypts data using the daemon's AEAD cipher if the daemon
+has one, or the legacy stream cipher otherwise.
+*/
 func NewTCPCipherConnection(daemon *TCPDaemon, netConn net.Conn, cip *Cipher, logger lalog.Logger) *TCPCipherConnection {
-	return &TCPCipherConnection{
+	conn := &TCPCipherConnection{
 		Conn:     netConn,
 		daemon:   daemon,
 		Cipher:   cip,
@@ -120,6 +156,77 @@ func NewTCPCipherConnection(daemon *TCPDaemon, netConn net.Conn, cip *Cipher, lo
 		writeBuf: make([]byte, MaxPacketSize),
 		logger:   logger,
 	}
+	if daemon != nil && len(daemon.aeadKeys) > 0 {
+		conn.aeadKeys = daemon.aeadKeys
+		// Until a key is identified, random data written to the client is encrypted with the first key.
+		conn.aead = daemon.aeadKeys[0].Cipher
+	}
+	return conn
+}
+
+/*
+identifyAEADKey reads the salt and the first length chunk, and finds the key that authenticates the chunk. A salt that
+has been recently authenticated is rejected as replayed data.
+*/
+func (conn *TCPCipherConnection) identifyAEADKey() error {
+	salt := make([]byte, conn.aead.SaltLength())
+	if _, err := io.ReadFull(conn.Conn, salt); err != nil {
+		return err
+	}
+	lengthChunk := make([]byte, 2+AEADTagLength)
+	if _, err := io.ReadFull(conn.Conn, lengthChunk); err != nil {
+		return err
+	}
+	for _, key := range conn.aeadKeys {
+		session, err := key.Cipher.NewSession(salt)
+		if err != nil {
+			return err
+		}
+		lengthBytes, err := session.Open(nil, make([]byte, session.NonceSize()), lengthChunk, nil)
+		if err != nil {
+			continue
+		}
+		if !conn.daemon.saltCache.Add(salt) {
+			return ErrAEADSaltReplayed
+		}
+		conn.aead, conn.user = key.Cipher, key.User
+		conn.aeadRead.initialise(session)
+		incrementNonce(conn.aeadRead.nonce)
+		return conn.aeadRead.readPayload(conn.Conn, lengthBytes)
+	}
+	return ErrAEADKeyNotFound
+}
+
+// readAEAD identifies the AEAD key (only once) and then reads and decrypts AEAD chunks.
+func (conn *TCPCipherConnection) readAEAD(b []byte) (n int, err error) {
+	if !conn.aeadReadInit {
+		if err = conn.identifyAEADKey(); err != nil {
+			return
+		}
+		conn.aeadReadInit = true
+	}
+	return conn.aeadRead.Read(conn.Conn, b)
+}
+
+// writeAEAD encrypts the data into AEAD chunks, the first write is preceded by a random salt.
+func (conn *TCPCipherConnection) writeAEAD(buf []byte) (n int, err error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	cipherData := conn.writeBuf[:0]
+	if !conn.aeadWrote {
+		salt := conn.aead.NewSalt()
+		session, err := conn.aead.NewSession(salt)
+		if err != nil {
+			return 0, err
+		}
+		conn.aeadWrite.initialise(session)
+		cipherData = append(cipherData, salt...)
+		conn.aeadWrote = true
+	}
+	if _, err = WriteWithRetry(conn.Conn, conn.aeadWrite.Seal(cipherData, buf)); err != nil {
+		return
+	}
+	return len(buf), nil
 }
 
 func (conn *TCPCipherConnection) Close() error {
@@ -127,6 +234,9 @@ func (conn *TCPCipherConnection) Close() error {
 }
 
 func (conn *TCPCipherConnection) Read(b []byte) (n int, err error) {
+	if conn.aead != nil {
+		return conn.readAEAD(b)
+	}
 	if conn.DecryptionStream == nil {
 		iv := make([]byte, conn.IVLength)
 		if _, err = io.ReadFull(conn.Conn, iv); err != nil {
@@ -153,6 +263,9 @@ func (conn *TCPCipherConnection) Read(b []byte) (n int, err error) {
 }
 
 func (conn *TCPCipherConnection) Write(buf []byte) (n int, err error) {
+	if conn.aead != nil {
+		return conn.writeAEAD(buf)
+	}
 	conn.mutex.Lock()
 	bufSize := len(buf)
 	headerLen := len(buf) - bufSize
@@ -189,9 +302,21 @@ func (conn *TCPCipherConnection) ParseRequest() (destIP net.IP, destNoPort, dest
 		conn.logger.MaybeMinorError(err)
 		return
 	}
+	return ReadSOCKSAddress(conn)
+}
+
+/*
+ReadSOCKSAddress reads a destination address made of address type, IPv4/IPv6 address or domain name, and port number.
+The format is shared by the encrypted requests, as well as SOCKS5 requests and UDP datagram headers.
+*/
+func ReadSOCKSAddress(reader io.Reader) (destIP net.IP, destNoPort, destWithPort string, err error) {
+	return readSOCKSAddress(reader, false)
+}
 
+// readSOCKSAddress reads a destination address, port number 0 is only acceptable if allowZeroPort is true.
+func readSOCKSAddress(reader io.Reader, allowZeroPort bool) (destIP net.IP, destNoPort, destWithPort string, err error) {
 	buf := make([]byte, 269)
-	if _, err = io.ReadFull(conn, buf[:AddressTypeIndex+1]); err != nil {
+	if _, err = io.ReadFull(reader, buf[:AddressTypeIndex+1]); err != nil {
 		return
 	}
 
@@ -204,21 +329,21 @@ func (conn *TCPCipherConnection) ParseRequest() (destIP net.IP, destNoPort, dest
 	case AddressTypeIPv6:
 		reqStart, reqEnd = IPPacketIndex, IPPacketIndex+IPv6PacketLength
 	case AddressTypeDM:
-		if _, err = io.ReadFull(conn, buf[AddressTypeIndex+1:DMAddrLengthIndex+1]); err != nil {
+		if _, err = io.ReadFull(reader, buf[AddressTypeIndex+1:DMAddrLengthIndex+1]); err != nil {
 			return
 		}
 		reqStart, reqEnd = DMAddrIndex, DMAddrIndex+int(buf[DMAddrLengthIndex])+DMAddrHeaderLength
 	default:
-		err = fmt.Errorf("TCPCipherConnection.ParseRequest: unknown mask type %d", maskedType)
+		err = fmt.Errorf("ReadSOCKSAddress: unknown mask type %d", maskedType)
 		return
 	}
 
-	if _, err = io.ReadFull(conn, buf[reqStart:reqEnd]); err != nil {
+	if _, err = io.ReadFull(reader, buf[reqStart:reqEnd]); err != nil {
 		return
 	}
 	port := binary.BigEndian.Uint16(buf[reqEnd-2 : reqEnd])
-	if port < 1 {
-		err = fmt.Errorf("TCPCipherConnection.ParseRequest: invalid destination port %d", port)
+	if port < 1 && !allowZeroPort {
+		err = fmt.Errorf("ReadSOCKSAddress: invalid destination port %d", port)
 		return
 	}
 
@@ -238,7 +363,7 @@ func (conn *TCPCipherConnection) ParseRequest() (destIP net.IP, destNoPort, dest
 		destWithPort = net.JoinHostPort(dest, strconv.Itoa(int(port)))
 	}
 	if strings.ContainsRune(destNoPort, 0) || strings.ContainsRune(destWithPort, 0) {
-		err = fmt.Errorf("TCPCipherConnection.ParseRequest: destination must not contain NULL byte")
+		err = fmt.Errorf("ReadSOCKSAddress: destination must not contain NULL byte")
 	}
 	return
 }
@@ -252,7 +377,7 @@ func (conn *TCPCipherConnection) WriteRandAndClose() {
 
 func (conn *TCPCipherConnection) HandleTCPConnection() {
 	remoteAddr := conn.RemoteAddr().String()
-	destIP, destNoPort, destWithPort, err := conn.ParseRequest()
+	_, _, destWithPort, err := conn.ParseRequest()
 	if err != nil {
 		conn.logger.Warning("HandleTCPConnection", remoteAddr, err, "failed to get destination address")
 		conn.WriteRandAndClose()
@@ -263,17 +388,28 @@ func (conn *TCPCipherConnection) HandleTCPConnection() {
 		conn.WriteRandAndClose()
 		return
 	}
-	if destIP != nil && IsReservedAddr(destIP) {
-		conn.logger.Info("HandleTCPConnection", remoteAddr, nil, "will not serve reserved address %s", destNoPort)
+	dialAddr, err := conn.daemon.policy.Evaluate("tcp", remoteAddr, destWithPort)
+	if err != nil {
+		conn.logger.Info("HandleTCPConnection", remoteAddr, err, "will not serve %s", destWithPort)
 		_ = conn.Close()
 		return
 	}
-	if conn.daemon.DNSDaemon.IsInBlacklist(destNoPort) {
-		conn.logger.Info("HandleTCPConnection", remoteAddr, nil, "will not serve blacklisted address %s", destNoPort)
-		_ = conn.Close()
-		return
+	var countBytesIn, countBytesOut func(int) bool
+	if conn.user != nil {
+		if !conn.user.MayTransfer() {
+			conn.logger.Info("HandleTCPConnection", remoteAddr, nil, "user %s is disabled or has exceeded data quota", conn.user.Name)
+			_ = conn.Close()
+			return
+		}
+		if !conn.user.BeginTCPConnection() {
+			conn.logger.Info("HandleTCPConnection", remoteAddr, nil, "user %s has too many connections", conn.user.Name)
+			_ = conn.Close()
+			return
+		}
+		defer conn.user.EndTCPConnection()
+		countBytesIn, countBytesOut = conn.user.CountBytesIn, conn.user.CountBytesOut
 	}
-	dest, err := net.DialTimeout("tcp", destWithPort, IOTimeoutSec*time.Second)
+	dest, err := net.DialTimeout("tcp", dialAddr, IOTimeoutSec*time.Second)
 	if err != nil {
 		conn.logger.Warning("HandleTCPConnection", remoteAddr, err, "failed to connect to destination \"%s\"", destWithPort)
 		_ = conn.Close()
@@ -281,6 +417,6 @@ func (conn *TCPCipherConnection) HandleTCPConnection() {
 	}
 	TweakTCPConnection(conn.Conn.(*net.TCPConn))
 	TweakTCPConnection(dest.(*net.TCPConn))
-	go PipeTCPConnection(conn, dest, true)
-	PipeTCPConnection(dest, conn, false)
+	go PipeTCPConnection(conn, dest, true, countBytesIn)
+	PipeTCPConnection(dest, conn, false, countBytesOut)
 }
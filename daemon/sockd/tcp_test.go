@@ -54,7 +54,7 @@ func TestPipeTCPConnection(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	PipeTCPConnection(client1, client2, true)
+	PipeTCPConnection(client1, client2, true, nil)
 	<-receiverDone
 
 	// Should have received the correct data in full
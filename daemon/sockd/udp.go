@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
@@ -56,19 +57,36 @@ type UDPDaemon struct {
 	Password   string
 	PerIPLimit int
 	UDPPort    int
+	// Cipher is the name of cipher used on the port, it defaults to the legacy stream cipher.
+	Cipher string
 
 	DNSDaemon *dnsd.Daemon
 
-	logger     lalog.Logger
-	udpBackLog *UDPBackLog
-	udpTable   *UDPTable
-	cipher     *Cipher
-	udpServer  *common.UDPServer
+	logger       lalog.Logger
+	udpBackLog   *UDPBackLog
+	udpTable     *UDPTable
+	cipher       *Cipher
+	aeadKeys     []AEADKey
+	saltCache    *AEADSaltCache
+	userAccounts []*UserAccount
+	policy       *DestinationPolicy
+	udpServer    *common.UDPServer
 }
 
 func (daemon *UDPDaemon) Initialise() error {
+	var err error
+	if daemon.policy, err = getDefaultPolicy(daemon.policy, daemon.DNSDaemon); err != nil {
+		return err
+	}
 	daemon.cipher = &Cipher{}
 	daemon.cipher.Initialise(daemon.Password)
+	daemon.aeadKeys = nil
+	if daemon.Cipher != "" && daemon.Cipher != CipherLegacy {
+		if daemon.aeadKeys, err = getAEADKeys(daemon.Cipher, daemon.Password, daemon.UDPPort, daemon.userAccounts); err != nil {
+			return fmt.Errorf("sockd.UDPDaemon.Initialise: %v", err)
+		}
+		daemon.saltCache = NewAEADSaltCache(AEADSaltCacheSize)
+	}
 	daemon.udpServer = &common.UDPServer{
 		ListenAddr:  daemon.Address,
 		ListenPort:  daemon.UDPPort,
@@ -90,6 +108,36 @@ func (daemon *UDPDaemon) GetUDPStatsCollector() *misc.Stats {
 
 func (daemon *UDPDaemon) HandleUDPClient(logger lalog.Logger, ip string, client *net.UDPAddr, packet []byte, srv *net.UDPConn) {
 	udpEncryptedServer := &UDPCipherConnection{PacketConn: srv, Cipher: daemon.cipher.Copy(), logger: logger}
+	if len(daemon.aeadKeys) > 0 {
+		// Find the key that authenticates the packet, the key also identifies the user.
+		var plainText []byte
+		for _, key := range daemon.aeadKeys {
+			var err error
+			if plainText, err = key.Cipher.OpenPacket(packet); err == nil {
+				udpEncryptedServer.aead, udpEncryptedServer.user = key.Cipher, key.User
+				break
+			}
+		}
+		if udpEncryptedServer.aead == nil {
+			logger.Warning("HandleUDPClient", ip, ErrAEADKeyNotFound, "failed to decrypt packet")
+			udpEncryptedServer.aead = daemon.aeadKeys[0].Cipher
+			udpEncryptedServer.WriteRand(client)
+			return
+		}
+		if !daemon.saltCache.Add(packet[:udpEncryptedServer.aead.SaltLength()]) {
+			logger.Warning("HandleUDPClient", ip, ErrAEADSaltReplayed, "dropped replayed packet")
+			return
+		}
+		if user := udpEncryptedServer.user; user != nil {
+			if !user.MayTransfer() {
+				logger.Info("HandleUDPClient", ip, nil, "user %s is disabled or has exceeded data quota", user.Name)
+				return
+			}
+			user.CountUDPPacket()
+			user.CountBytesIn(len(plainText))
+		}
+		packet = plainText
+	}
 	daemon.HandleUDPConnection(logger, udpEncryptedServer, len(packet), client, packet)
 }
 
@@ -194,6 +242,10 @@ func (table *UDPTable) Len() (ret int) {
 type UDPCipherConnection struct {
 	net.PacketConn
 	*Cipher
+	// aead is the AEAD cipher that takes place of the legacy stream cipher when it is not nil.
+	aead *AEADCipher
+	// user is the owner of AEAD key, data sent to the user is counted toward the user's usage.
+	user   *UserAccount
 	logger lalog.Logger
 }
 
@@ -208,6 +260,13 @@ func (conn *UDPCipherConnection) ReadFrom(b []byte) (n int, src net.Addr, err er
 	if err != nil {
 		return
 	}
+	if conn.aead != nil {
+		var plainText []byte
+		if plainText, err = conn.aead.OpenPacket(buf[:n]); err != nil {
+			return 0, nil, err
+		}
+		return copy(b, plainText), src, nil
+	}
 	if n < conn.IVLength {
 		return 0, nil, ErrMalformedUDPPacket
 	}
@@ -222,6 +281,16 @@ func (conn *UDPCipherConnection) ReadFrom(b []byte) (n int, src net.Addr, err er
 }
 
 func (conn *UDPCipherConnection) WriteTo(b []byte, dest net.Addr) (n int, err error) {
+	if conn.aead != nil {
+		if conn.user != nil && !conn.user.CountBytesOut(len(b)) {
+			return 0, ErrUserMayNotTransfer
+		}
+		var packet []byte
+		if packet, err = conn.aead.SealPacket(b); err != nil {
+			return
+		}
+		return conn.PacketConn.WriteTo(packet, dest)
+	}
 	cipher := conn.Copy()
 	iv := cipher.InitEncryptionStream()
 	packetLen := len(b) + len(iv)
@@ -263,7 +332,7 @@ func (daemon *UDPDaemon) HandleUDPConnection(logger lalog.Logger, server *UDPCip
 		return
 	}
 
-	var destIP net.IP
+	var destNoPort string
 	var packetLen int
 	addrType := packet[AddressTypeIndex]
 
@@ -276,7 +345,7 @@ func (daemon *UDPDaemon) HandleUDPConnection(logger lalog.Logger, server *UDPCip
 			server.WriteRand(clientAddr)
 			return
 		}
-		destIP = packet[UDPIPAddrIndex : UDPIPAddrIndex+net.IPv4len]
+		destNoPort = net.IP(packet[UDPIPAddrIndex : UDPIPAddrIndex+net.IPv4len]).String()
 	case AddressTypeIPv6:
 		packetLen = UDPIPv6PacketLength
 		if len(packet) < packetLen {
@@ -284,7 +353,7 @@ func (daemon *UDPDaemon) HandleUDPConnection(logger lalog.Logger, server *UDPCip
 			server.WriteRand(clientAddr)
 			return
 		}
-		destIP = packet[UDPIPAddrIndex : UDPIPAddrIndex+net.IPv6len]
+		destNoPort = net.IP(packet[UDPIPAddrIndex : UDPIPAddrIndex+net.IPv6len]).String()
 	case AddressTypeDM:
 		packetLen = int(packet[DMAddrLengthIndex]) + DMHeaderLength
 		if len(packet) < packetLen {
@@ -292,46 +361,31 @@ func (daemon *UDPDaemon) HandleUDPConnection(logger lalog.Logger, server *UDPCip
 			server.WriteRand(clientAddr)
 			return
 		}
-		dest := string(packet[DMAddrHeaderLength : DMAddrHeaderLength+int(packet[DMAddrLengthIndex])])
-		if strings.ContainsRune(dest, 0) {
+		destNoPort = string(packet[DMAddrHeaderLength : DMAddrHeaderLength+int(packet[DMAddrLengthIndex])])
+		if strings.ContainsRune(destNoPort, 0) {
 			logger.Warning("HandleUDPConnection", clientAddr.IP.String(), nil, "will not serve destination that contains NULL byte")
 			return
 		}
-		destIP = net.ParseIP(dest)
-		if destIP != nil && IsReservedAddr(destIP) {
-			logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not serve reserved address %s", dest)
-			return
-		}
-		if daemon.DNSDaemon.IsInBlacklist(dest) {
-			logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not serve blacklisted domain name %s", dest)
-			return
-		}
-		resolveDestIP, err := net.ResolveIPAddr("ip", dest)
-		if err != nil {
-			logger.Warning("HandleUDPConnection", clientAddr.IP.String(), nil, "failed to resolve domain name \"%s\"", dest)
-			return
-		}
-		destIP = resolveDestIP.IP
 	default:
 		logger.Warning("HandleUDPConnection", clientAddr.IP.String(), nil, "unknown mask type %d", maskedType)
 		server.WriteRand(clientAddr)
 		return
 	}
-	if IsReservedAddr(destIP) {
-		logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not serve reserved address %s", destIP.String())
+	destPort := int(binary.BigEndian.Uint16(packet[packetLen-2 : packetLen]))
+	if destPort < 1 {
+		logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not connect to invalid destination port %s:%d", destNoPort, destPort)
+		server.WriteRand(clientAddr)
 		return
 	}
-	if daemon.DNSDaemon.IsInBlacklist(destIP.String()) {
-		logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not serve blacklisted address %s", destIP.String())
+	destWithPort := net.JoinHostPort(destNoPort, strconv.Itoa(destPort))
+	dialAddr, err := daemon.policy.Evaluate("udp", clientAddr.IP.String(), destWithPort)
+	if err != nil {
+		logger.Info("HandleUDPConnection", clientAddr.IP.String(), err, "will not serve %s", destWithPort)
 		return
 	}
-	destAddr := &net.UDPAddr{
-		IP:   destIP,
-		Port: int(binary.BigEndian.Uint16(packet[packetLen-2 : packetLen])),
-	}
-	if destAddr.Port < 1 {
-		logger.Info("HandleUDPConnection", clientAddr.IP.String(), nil, "will not connect to invalid destination port %s:%d", destIP.String(), destAddr.Port)
-		server.WriteRand(clientAddr)
+	destAddr, err := net.ResolveUDPAddr("udp", dialAddr)
+	if err != nil {
+		logger.Warning("HandleUDPConnection", clientAddr.IP.String(), err, "failed to parse destination \"%s\"", dialAddr)
 		return
 	}
 	if _, found := daemon.udpBackLog.Get(destAddr.String()); !found {
@@ -0,0 +1,211 @@
+package sockd
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+var (
+	// ErrUserNotFound is returned when a user name does not belong to any of the configured users.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserMayNotTransfer is returned when a user is disabled or has used up the data quota.
+	ErrUserMayNotTransfer = errors.New("user is disabled or has exceeded data quota")
+	// ErrUserTooManyConnections is returned when a user has reached the limit of concurrent TCP connections.
+	ErrUserTooManyConnections = errors.New("user has too many connections")
+)
+
+// User is a named user of sockd who has an individual password, it is only usable on ports that use an AEAD cipher.
+type User struct {
+	Name     string `json:"Name"`
+	Password string `json:"Password"`
+	// Ports restricts the user to these TCP and UDP ports. The user may use all AEAD cipher ports if it is empty.
+	Ports []int `json:"Ports"`
+	// QuotaBytes is the maximum amount of data the user may transfer in both directions. 0 means unlimited.
+	QuotaBytes int64 `json:"QuotaBytes"`
+	// MaxConnections is the maximum number of concurrent TCP connections of the user. 0 means unlimited.
+	MaxConnections int `json:"MaxConnections"`
+	// Disabled prevents the user from using sockd. Users may also be disabled and enabled at runtime.
+	Disabled bool `json:"Disabled"`
+}
+
+// UserUsage is a report of traffic and connection usage of a user.
+type UserUsage struct {
+	Name              string `json:"Name"`
+	BytesIn           int64  `json:"BytesIn"`  // BytesIn is the amount of data received from the user
+	BytesOut          int64  `json:"BytesOut"` // BytesOut is the amount of data sent to the user
+	TCPConnections    int64  `json:"TCPConnections"`
+	ActiveConnections int64  `json:"ActiveConnections"`
+	UDPPackets        int64  `json:"UDPPackets"`
+	QuotaBytes        int64  `json:"QuotaBytes"`
+	QuotaExceeded     bool   `json:"QuotaExceeded"`
+	Disabled          bool   `json:"Disabled"`
+}
+
+// UserAccount keeps track of the usage and runtime status of a user.
+type UserAccount struct {
+	User
+	bytesIn, bytesOut, tcpConnections, activeConnections, udpPackets int64
+	disabled                                                         int32
+}
+
+// NewUserAccount returns a new account for the user, the usage counters start at zero.
+func NewUserAccount(user User) *UserAccount {
+	account := &UserAccount{User: user}
+	account.SetDisabled(user.Disabled)
+	return account
+}
+
+// MayUsePort returns true only if the user is permitted to use the port.
+func (account *UserAccount) MayUsePort(port int) bool {
+	if len(account.Ports) == 0 {
+		return true
+	}
+	for _, allowedPort := range account.Ports {
+		if allowedPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisabled returns true only if the user has been disabled.
+func (account *UserAccount) IsDisabled() bool {
+	return atomic.LoadInt32(&account.disabled) != 0
+}
+
+// SetDisabled disables or enables the user.
+func (account *UserAccount) SetDisabled(disabled bool) {
+	if disabled {
+		atomic.StoreInt32(&account.disabled, 1)
+	} else {
+		atomic.StoreInt32(&account.disabled, 0)
+	}
+}
+
+// IsQuotaExceeded returns true only if the user has used up its data quota.
+func (account *UserAccount) IsQuotaExceeded() bool {
+	return account.QuotaBytes > 0 && atomic.LoadInt64(&account.bytesIn)+atomic.LoadInt64(&account.bytesOut) >= account.QuotaBytes
+}
+
+// MayTransfer returns true only if the user is neither disabled nor has used up the data quota.
+func (account *UserAccount) MayTransfer() bool {
+	return !account.IsDisabled() && !account.IsQuotaExceeded()
+}
+
+// CountBytesIn adds to the amount of data received from the user, and returns true only if the user may continue to transfer.
+func (account *UserAccount) CountBytesIn(n int) bool {
+	atomic.AddInt64(&account.bytesIn, int64(n))
+	return account.MayTransfer()
+}
+
+// CountBytesOut adds to the amount of data sent to the user, and returns true only if the user may continue to transfer.
+func (account *UserAccount) CountBytesOut(n int) bool {
+	atomic.AddInt64(&account.bytesOut, int64(n))
+	return account.MayTransfer()
+}
+
+// BeginTCPConnection counts a new TCP connection. It returns false if the user has reached the connection limit.
+func (account *UserAccount) BeginTCPConnection() bool {
+	if active := atomic.AddInt64(&account.activeConnections, 1); account.MaxConnections > 0 && active > int64(account.MaxConnections) {
+		atomic.AddInt64(&account.activeConnections, -1)
+		return false
+	}
+	atomic.AddInt64(&account.tcpConnections, 1)
+	return true
+}
+
+// EndTCPConnection counts the closure of a TCP connection that began successfully.
+func (account *UserAccount) EndTCPConnection() {
+	atomic.AddInt64(&account.activeConnections, -1)
+}
+
+// CountUDPPacket counts a UDP packet received from the user.
+func (account *UserAccount) CountUDPPacket() {
+	atomic.AddInt64(&account.udpPackets, 1)
+}
+
+// GetUsage returns a report of the user's usage.
+func (account *UserAccount) GetUsage() UserUsage {
+	return UserUsage{
+		Name:              account.Name,
+		BytesIn:           atomic.LoadInt64(&account.bytesIn),
+		BytesOut:          atomic.LoadInt64(&account.bytesOut),
+		TCPConnections:    atomic.LoadInt64(&account.tcpConnections),
+		ActiveConnections: atomic.LoadInt64(&account.activeConnections),
+		UDPPackets:        atomic.LoadInt64(&account.udpPackets),
+		QuotaBytes:        account.QuotaBytes,
+		QuotaExceeded:     account.IsQuotaExceeded(),
+		Disabled:          account.IsDisabled(),
+	}
+}
+
+// AEADKey is an AEAD cipher derived from the password of a user, or from the shared password in which case User is nil.
+type AEADKey struct {
+	Cipher *AEADCipher
+	User   *UserAccount
+}
+
+// getAEADKeys returns the keys acceptable on the port, beginning with the one derived from the shared password.
+func getAEADKeys(cipherName, password string, port int, accounts []*UserAccount) (keys []AEADKey, err error) {
+	if password != "" {
+		cip, err := NewAEADCipher(cipherName, password)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, AEADKey{Cipher: cip})
+	}
+	for _, account := range accounts {
+		if !account.MayUsePort(port) {
+			continue
+		}
+		cip, err := NewAEADCipher(cipherName, account.Password)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, AEADKey{Cipher: cip, User: account})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("there is no password or user for port %d", port)
+	}
+	return
+}
+
+/*
+authenticate returns the user who owns the name and password and may use the port. The shared password is never
+accepted, because plain proxy modes transmit the password in clear text.
+*/
+func authenticate(accounts []*UserAccount, port int, name, password string) (user *UserAccount, ok bool) {
+	for _, account := range accounts {
+		if account.Name == name && account.MayUsePort(port) && subtle.ConstantTimeCompare([]byte(account.Password), []byte(password)) == 1 {
+			return account, true
+		}
+	}
+	return nil, false
+}
+
+// GetUserUsage returns usage reports of all users sorted by name.
+func (daemon *Daemon) GetUserUsage() []UserUsage {
+	ret := make([]UserUsage, 0, len(daemon.userAccounts))
+	for _, account := range daemon.userAccounts {
+		ret = append(ret, account.GetUsage())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// SetUserDisabled disables or enables a user at runtime. Connections of a disabled user stop shortly.
+func (daemon *Daemon) SetUserDisabled(name string, disabled bool) error {
+	for _, account := range daemon.userAccounts {
+		if account.Name == name {
+			account.SetDisabled(disabled)
+			daemon.logger.Info("SetUserDisabled", name, nil, "user is disabled? %v", disabled)
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
@@ -0,0 +1,161 @@
+package sockd
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/daemon/dnsd"
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+func TestSockd_InitialiseUsers(t *testing.T) {
+	daemon := Daemon{
+		DNSDaemon:   &dnsd.Daemon{},
+		TCPPorts:    []int{27101, 23990},
+		PortCiphers: map[int]string{23990: CipherAES256GCM},
+		Users:       []User{{Name: "", Password: "alicepass"}},
+	}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "name must not be empty") {
+		t.Fatal(err)
+	}
+	daemon.Users = []User{{Name: "alice", Password: "alicepass"}, {Name: "alice", Password: "alicepass"}}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "duplicated") {
+		t.Fatal(err)
+	}
+	daemon.Users = []User{{Name: "alice", Password: "short"}}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "at least 7") {
+		t.Fatal(err)
+	}
+	daemon.Users = []User{{Name: "alice", Password: "alicepass", Ports: []int{27101}}}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "AEAD") {
+		t.Fatal(err)
+	}
+	// Without a shared password, the legacy cipher port cannot be used
+	daemon.Users = []User{{Name: "alice", Password: "alicepass", Ports: []int{23990}}}
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "legacy cipher") {
+		t.Fatal(err)
+	}
+	daemon.TCPPorts = []int{23990}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	daemon.Password = "short"
+	if err := daemon.Initialise(); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Fatal(err)
+	}
+	daemon.Password = "abcdefg"
+	daemon.Users = []User{{Name: "bob", Password: "bobpassword"}, {Name: "alice", Password: "alicepass"}}
+	if err := daemon.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if usage := daemon.GetUserUsage(); len(usage) != 2 || usage[0].Name != "alice" || usage[1].Name != "bob" || usage[0].Disabled {
+		t.Fatalf("%+v", usage)
+	}
+	if err := daemon.SetUserDisabled("charlie", true); err != ErrUserNotFound {
+		t.Fatal(err)
+	}
+	if err := daemon.SetUserDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if usage := daemon.GetUserUsage(); !usage[0].Disabled || usage[1].Disabled {
+		t.Fatalf("%+v", usage)
+	}
+}
+
+func TestUserAccount(t *testing.T) {
+	account := NewUserAccount(User{Name: "alice", Password: "alicepass", Ports: []int{1, 2}, QuotaBytes: 100, MaxConnections: 2})
+	if !account.MayUsePort(1) || !account.MayUsePort(2) || account.MayUsePort(3) {
+		t.Fatal("wrong port permission")
+	}
+	if !account.BeginTCPConnection() || !account.BeginTCPConnection() || account.BeginTCPConnection() {
+		t.Fatal("wrong connection limit")
+	}
+	account.EndTCPConnection()
+	if !account.BeginTCPConnection() {
+		t.Fatal("should have allowed a new connection")
+	}
+	if !account.CountBytesIn(40) || !account.CountBytesOut(40) || account.CountBytesOut(20) || account.MayTransfer() {
+		t.Fatal("wrong quota")
+	}
+	account.CountUDPPacket()
+	usage := account.GetUsage()
+	if usage.BytesIn != 40 || usage.BytesOut != 60 || usage.TCPConnections != 3 || usage.ActiveConnections != 2 ||
+		usage.UDPPackets != 1 || !usage.QuotaExceeded || usage.Disabled {
+		t.Fatalf("%+v", usage)
+	}
+	unlimited := NewUserAccount(User{Name: "bob", Disabled: true})
+	if !unlimited.MayUsePort(12345) || unlimited.MayTransfer() {
+		t.Fatal("wrong permission")
+	}
+	unlimited.SetDisabled(false)
+	if !unlimited.CountBytesIn(1<<40) || !unlimited.BeginTCPConnection() {
+		t.Fatal("should not have been limited")
+	}
+}
+
+func TestTCPCipherConnection_IdentifyUser(t *testing.T) {
+	alice := NewUserAccount(User{Name: "alice", Password: "alicepass"})
+	bob := NewUserAccount(User{Name: "bob", Password: "bobpassword", Ports: []int{2}})
+	server := &TCPDaemon{Password: "abcdefg", Cipher: CipherChaCha20Poly1305, TCPPort: 1, PerIPLimit: 10, userAccounts: []*UserAccount{alice, bob}}
+	if err := server.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Bob is not permitted to use port 1
+	if len(server.aeadKeys) != 2 || server.aeadKeys[0].User != nil || server.aeadKeys[1].User != alice {
+		t.Fatalf("%+v", server.aeadKeys)
+	}
+	for _, test := range []struct {
+		password string
+		user     *UserAccount
+		err      error
+	}{{"abcdefg", nil, nil}, {"alicepass", alice, nil}, {"bobpassword", nil, ErrAEADKeyNotFound}} {
+		client := &TCPDaemon{Password: test.password, Cipher: CipherChaCha20Poly1305, TCPPort: 1, PerIPLimit: 10}
+		if err := client.Initialise(); err != nil {
+			t.Fatal(err)
+		}
+		clientConn, serverConn := net.Pipe()
+		clientCipherConn := NewTCPCipherConnection(client, clientConn, client.cipher.Copy(), lalog.Logger{})
+		serverCipherConn := NewTCPCipherConnection(server, serverConn, server.cipher.Copy(), lalog.Logger{})
+		go func() {
+			_, _ = clientCipherConn.Write([]byte("hello"))
+		}()
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(serverCipherConn, buf); err != test.err {
+			t.Fatal(test.password, err)
+		}
+		if test.err == nil && string(buf) != "hello" {
+			t.Fatal(test.password, string(buf))
+		}
+		if serverCipherConn.user != test.user {
+			t.Fatal(test.password, serverCipherConn.user)
+		}
+		_ = clientCipherConn.Close()
+		_ = serverCipherConn.Close()
+	}
+}
+
+func TestPipeTCPConnection_CountBytes(t *testing.T) {
+	account := NewUserAccount(User{Name: "alice", QuotaBytes: 3})
+	fromClient, fromServer := net.Pipe()
+	toClient, toServer := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		PipeTCPConnection(fromServer, toClient, false, account.CountBytesIn)
+		close(done)
+	}()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, toServer)
+	}()
+	if _, err := fromClient.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// The pipe stops as soon as the quota is used up
+	<-done
+	if usage := account.GetUsage(); usage.BytesIn != 5 || !usage.QuotaExceeded {
+		t.Fatalf("%+v", usage)
+	}
+	_ = fromClient.Close()
+}
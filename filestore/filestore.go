@@ -0,0 +1,599 @@
+package filestore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// DefaultExpireSec is the expiration of stored files when neither the file nor the store specifies one (7 days).
+	DefaultExpireSec = 7 * 24 * 3600
+	/*
+		DefaultEncryptedUploadExpireSec is the time an unfinished upload may take in a store that encrypts files (24
+		hours). Content of an unfinished upload is kept in plain until the upload completes, the limit makes sure that
+		plain content does not linger on disk.
+	*/
+	DefaultEncryptedUploadExpireSec = 24 * 3600
+	// SweepIntervalSec is the interval at which expired files are deleted in the background.
+	SweepIntervalSec = 10 * 60
+	// LinkKeyFileName is the file under store directory that keeps the random key used for signing share links.
+	LinkKeyFileName = ".link-key"
+
+	metadataFileSuffix = ".json" // metadataFileSuffix is the file name suffix of file metadata.
+	partialFileSuffix  = ".part" // partialFileSuffix is the file name suffix of content that is still being uploaded.
+	dataFileSuffix     = ".data" // dataFileSuffix is the file name suffix of completely uploaded content.
+	idSize             = 12      // idSize is the number of random bytes in a file ID.
+	linkKeySize        = 32      // linkKeySize is the number of random bytes in the link signing key.
+)
+
+var (
+	// sweepInterval is the interval of background deletion of expired files, test cases may shorten it.
+	sweepInterval = SweepIntervalSec * time.Second
+
+	// RegexFileID matches a valid file ID.
+	RegexFileID = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+	ErrFileNotFound        = errors.New("file does not exist or has expired")
+	ErrFileTooLarge        = errors.New("file size exceeds the limit")
+	ErrQuotaExceeded       = errors.New("storage quota is exceeded")
+	ErrOffsetMismatch      = errors.New("upload offset does not match the size of content received so far")
+	ErrUploadIncomplete    = errors.New("file upload is incomplete")
+	ErrUploadInProgress    = errors.New("another upload of the file is in progress")
+	ErrDownloadsExhausted  = errors.New("file has reached its download limit")
+	ErrBadLinkSignature    = errors.New("link signature is invalid")
+	ErrLinkExpired         = errors.New("link has expired")
+	ErrStoreNotInitialised = errors.New("file store is not initialised")
+)
+
+// dirState is shared among stores of the same directory, so that the web service and app commands may safely use the same files.
+type dirState struct {
+	mutex     *sync.Mutex
+	uploading map[string]bool // uploading are IDs of files that are receiving content at the moment.
+	sweeping  bool            // sweeping is true if a store is periodically deleting expired files of the directory.
+}
+
+var (
+	dirStates      = make(map[string]*dirState)
+	dirStatesMutex = new(sync.Mutex)
+)
+
+// getDirState returns the state shared by all stores of the directory.
+func getDirState(dir string) *dirState {
+	dirStatesMutex.Lock()
+	defer dirStatesMutex.Unlock()
+	state, exists := dirStates[dir]
+	if !exists {
+		state = &dirState{mutex: new(sync.Mutex), uploading: make(map[string]bool)}
+		dirStates[dir] = state
+	}
+	return state
+}
+
+// FileInfo is the metadata of a stored file.
+type FileInfo struct {
+	ID              string    `json:"ID"`              // ID is the random identifier of the file.
+	Name            string    `json:"Name"`            // Name is the original name of the file.
+	Size            int64     `json:"Size"`            // Size is the declared size of the file content.
+	Offset          int64     `json:"Offset"`          // Offset is the size of content received so far.
+	Encrypted       bool      `json:"Encrypted"`       // Encrypted is true if the file content is encrypted at rest.
+	CreatedAt       time.Time `json:"CreatedAt"`       // CreatedAt is the time the upload started.
+	ExpiresAt       time.Time `json:"ExpiresAt"`       // ExpiresAt is the time the file is deleted.
+	UploadExpiresAt time.Time `json:"UploadExpiresAt"` // UploadExpiresAt is the time an unfinished upload is deleted, zero means ExpiresAt.
+	MaxDownloads    int       `json:"MaxDownloads"`    // MaxDownloads is the number of downloads allowed via share links, 0 means unlimited.
+	Downloads       int       `json:"Downloads"`       // Downloads is the number of downloads made via share links so far.
+}
+
+// Complete returns true if all of the file content has been received.
+func (info *FileInfo) Complete() bool {
+	return info.Offset == info.Size
+}
+
+// Exhausted returns true if the file has reached its download limit.
+func (info *FileInfo) Exhausted() bool {
+	return info.MaxDownloads > 0 && info.Downloads >= info.MaxDownloads
+}
+
+// Expired returns true if the file has passed its expiration time, or the unfinished upload has taken too long.
+func (info *FileInfo) Expired() bool {
+	return time.Now().After(info.GetUploadExpiresAt())
+}
+
+// GetUploadExpiresAt returns the time by which the file is deleted unless its upload completes.
+func (info *FileInfo) GetUploadExpiresAt() time.Time {
+	if !info.Complete() && !info.UploadExpiresAt.IsZero() && info.UploadExpiresAt.Before(info.ExpiresAt) {
+		return info.UploadExpiresAt
+	}
+	return info.ExpiresAt
+}
+
+/*
+Store keeps uploaded files in a directory along with their metadata. Files are received in one or more chunks so that
+an interrupted upload may resume, and each file expires after a while or after a number of downloads. Optionally the
+content of complete files is encrypted at rest. Expired files are periodically deleted in the background.
+*/
+type Store struct {
+	Dir                      string // Dir is the directory where files and their metadata are kept.
+	EncryptionPassword       string // EncryptionPassword encrypts the content of complete files, leave empty to store them in plain.
+	QuotaBytes               int64  // QuotaBytes is the maximum total size of all files, 0 means unlimited.
+	MaxFileSizeBytes         int64  // MaxFileSizeBytes is the maximum size of an individual file, 0 means unlimited.
+	DefaultExpireSec         int    // DefaultExpireSec is the expiration of files that do not specify one.
+	EncryptedUploadExpireSec int    // EncryptedUploadExpireSec is the time an unfinished upload may take when files are encrypted at rest.
+
+	linkKey []byte
+	state   *dirState
+	logger  lalog.Logger
+}
+
+// Initialise creates the store directory and prepares the key for signing share links.
+func (store *Store) Initialise() error {
+	if store.Dir == "" {
+		return errors.New("Store.Initialise: Dir must not be empty")
+	}
+	absDir, err := filepath.Abs(store.Dir)
+	if err != nil {
+		return fmt.Errorf("Store.Initialise: failed to determine absolute path of \"%s\" - %v", store.Dir, err)
+	}
+	store.Dir = absDir
+	if store.DefaultExpireSec < 1 {
+		store.DefaultExpireSec = DefaultExpireSec
+	}
+	if store.EncryptedUploadExpireSec < 1 {
+		store.EncryptedUploadExpireSec = DefaultEncryptedUploadExpireSec
+	}
+	store.logger = lalog.Logger{ComponentName: "filestore", ComponentID: []lalog.LoggerIDField{{Key: "Dir", Value: store.Dir}}}
+	if err := os.MkdirAll(store.Dir, 0700); err != nil {
+		return fmt.Errorf("Store.Initialise: failed to create directory - %v", err)
+	}
+	store.state = getDirState(store.Dir)
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	// The link key is generated once and kept in the directory, so that share links remain valid across restarts.
+	keyPath := filepath.Join(store.Dir, LinkKeyFileName)
+	key, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		key = make([]byte, linkKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("Store.Initialise: failed to generate link key - %v", err)
+		}
+		if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+			return fmt.Errorf("Store.Initialise: failed to write link key - %v", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("Store.Initialise: failed to read link key - %v", err)
+	} else if len(key) != linkKeySize {
+		return fmt.Errorf("Store.Initialise: link key file \"%s\" is malformed", keyPath)
+	}
+	store.linkKey = key
+	// Expiration should not depend on someone to list or upload files
+	if !store.state.sweeping {
+		store.state.sweeping = true
+		go store.sweep(sweepInterval)
+	}
+	return nil
+}
+
+// sweep periodically deletes expired files until the store directory disappears.
+func (store *Store) sweep(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := os.Stat(store.Dir); os.IsNotExist(err) {
+			store.state.mutex.Lock()
+			store.state.sweeping = false
+			store.state.mutex.Unlock()
+			return
+		}
+		if _, err := store.List(); err != nil {
+			store.logger.Warning("sweep", "", err, "failed to delete expired files")
+		}
+	}
+}
+
+// filePath returns the path to a file of the ID with the suffix.
+func (store *Store) filePath(id, suffix string) string {
+	return filepath.Join(store.Dir, id+suffix)
+}
+
+// load reads the metadata of a file. The caller must hold the directory mutex.
+func (store *Store) load(id string) (*FileInfo, error) {
+	if !RegexFileID.MatchString(id) {
+		return nil, ErrFileNotFound
+	}
+	content, err := ioutil.ReadFile(store.filePath(id, metadataFileSuffix))
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Store.load: failed to read metadata of %s - %v", id, err)
+	}
+	var info FileInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("Store.load: failed to parse metadata of %s - %v", id, err)
+	}
+	return &info, nil
+}
+
+// save writes the metadata of a file. The caller must hold the directory mutex.
+func (store *Store) save(info *FileInfo) error {
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a crash does not leave behind half-written metadata
+	tmpPath := store.filePath(info.ID, metadataFileSuffix+".tmp")
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return fmt.Errorf("Store.save: failed to write metadata of %s - %v", info.ID, err)
+	}
+	return os.Rename(tmpPath, store.filePath(info.ID, metadataFileSuffix))
+}
+
+// remove deletes a file and its metadata. The caller must hold the directory mutex.
+func (store *Store) remove(id string) {
+	for _, suffix := range []string{dataFileSuffix, partialFileSuffix, metadataFileSuffix} {
+		if err := os.Remove(store.filePath(id, suffix)); err != nil && !os.IsNotExist(err) {
+			store.logger.Warning("remove", id, err, "failed to delete file")
+		}
+	}
+}
+
+/*
+list reads the metadata of all files and deletes those that have expired or reached their download limit. The caller
+must hold the directory mutex.
+*/
+func (store *Store) list() ([]*FileInfo, error) {
+	entries, err := ioutil.ReadDir(store.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("Store.list: failed to read directory - %v", err)
+	}
+	ret := make([]*FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), metadataFileSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), metadataFileSuffix)
+		info, err := store.load(id)
+		if err != nil {
+			store.logger.Warning("list", id, err, "failed to read file metadata")
+			continue
+		}
+		if (info.Expired() || info.Exhausted()) && !store.state.uploading[id] {
+			store.logger.Info("list", id, nil, "deleting file \"%s\" that has expired or reached its download limit", info.Name)
+			store.remove(id)
+			continue
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].CreatedAt.After(ret[j].CreatedAt)
+	})
+	return ret, nil
+}
+
+// List returns the metadata of all files, the most recent upload comes first. Expired files are deleted along the way.
+func (store *Store) List() ([]*FileInfo, error) {
+	if store.state == nil {
+		return nil, ErrStoreNotInitialised
+	}
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	return store.list()
+}
+
+// Get returns the metadata of a file.
+func (store *Store) Get(id string) (*FileInfo, error) {
+	if store.state == nil {
+		return nil, ErrStoreNotInitialised
+	}
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	info, err := store.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Expired() || info.Exhausted() {
+		return nil, ErrFileNotFound
+	}
+	return info, nil
+}
+
+/*
+Create starts a new file of the declared size and returns its metadata. The file content is subsequently received via
+Append. If expireSec is 0, the store's default expiration applies. If maxDownloads is 0, the file may be downloaded via
+share links for an unlimited number of times.
+*/
+func (store *Store) Create(name string, size int64, expireSec, maxDownloads int) (*FileInfo, error) {
+	if store.state == nil {
+		return nil, ErrStoreNotInitialised
+	}
+	// Only keep the base name of the original file
+	name = filepath.Base(strings.Replace(strings.TrimSpace(name), `\`, "/", -1))
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	if size < 0 || expireSec < 0 || maxDownloads < 0 {
+		return nil, errors.New("Store.Create: size, expiration, and download limit must not be negative")
+	}
+	if store.MaxFileSizeBytes > 0 && size > store.MaxFileSizeBytes {
+		return nil, ErrFileTooLarge
+	}
+	if expireSec == 0 {
+		expireSec = store.DefaultExpireSec
+	}
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	if store.QuotaBytes > 0 {
+		files, err := store.list()
+		if err != nil {
+			return nil, err
+		}
+		total := size
+		for _, file := range files {
+			total += file.Size
+		}
+		if total > store.QuotaBytes {
+			return nil, ErrQuotaExceeded
+		}
+	}
+	idBytes := make([]byte, idSize)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("Store.Create: failed to generate file ID - %v", err)
+	}
+	now := time.Now()
+	info := &FileInfo{
+		ID:           hex.EncodeToString(idBytes),
+		Name:         name,
+		Size:         size,
+		Encrypted:    store.EncryptionPassword != "",
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Duration(expireSec) * time.Second),
+		MaxDownloads: maxDownloads,
+	}
+	if info.Encrypted {
+		info.UploadExpiresAt = now.Add(time.Duration(store.EncryptedUploadExpireSec) * time.Second)
+	}
+	if err := ioutil.WriteFile(store.filePath(info.ID, partialFileSuffix), []byte{}, 0600); err != nil {
+		return nil, fmt.Errorf("Store.Create: failed to create file - %v", err)
+	}
+	if size == 0 {
+		// An empty file is complete right away
+		if err := store.finalise(info); err != nil {
+			store.remove(info.ID)
+			return nil, err
+		}
+	}
+	if err := store.save(info); err != nil {
+		store.remove(info.ID)
+		return nil, err
+	}
+	return info, nil
+}
+
+/*
+Append writes content to the file at the offset, which must match the size of content received so far. Content in
+excess of the declared file size is not read. It returns the size of content received so far, which increases even if
+the content reader fails midway, so that the client may resume the upload from there.
+*/
+func (store *Store) Append(id string, offset int64, content io.Reader) (int64, error) {
+	if store.state == nil {
+		return 0, ErrStoreNotInitialised
+	}
+	// Check the offset and mark the upload in progress, then release the mutex while receiving content.
+	store.state.mutex.Lock()
+	info, err := store.load(id)
+	if err == nil && info.Expired() {
+		err = ErrFileNotFound
+	} else if err == nil && store.state.uploading[id] {
+		err = ErrUploadInProgress
+	} else if err == nil && offset != info.Offset {
+		err = ErrOffsetMismatch
+	}
+	if err != nil {
+		store.state.mutex.Unlock()
+		if info != nil {
+			return info.Offset, err
+		}
+		return 0, err
+	}
+	if info.Complete() {
+		store.state.mutex.Unlock()
+		return info.Offset, nil
+	}
+	store.state.uploading[id] = true
+	store.state.mutex.Unlock()
+
+	written, copyErr := store.writePartial(info, content)
+
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	delete(store.state.uploading, id)
+	// The file may have been deleted by its owner in the meantime
+	current, err := store.load(id)
+	if err != nil {
+		store.remove(id)
+		return 0, err
+	}
+	current.Offset += written
+	if current.Complete() {
+		if err := store.finalise(current); err != nil {
+			store.remove(id)
+			return 0, err
+		}
+	}
+	if err := store.save(current); err != nil {
+		return info.Offset, err
+	}
+	return current.Offset, copyErr
+}
+
+// writePartial writes content to the end of partially uploaded file and returns the number of bytes written.
+func (store *Store) writePartial(info *FileInfo, content io.Reader) (int64, error) {
+	partial, err := os.OpenFile(store.filePath(info.ID, partialFileSuffix), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("Store.Append: failed to open file - %v", err)
+	}
+	defer partial.Close()
+	// Discard content left behind by an earlier upload that failed to record its progress
+	if err := partial.Truncate(info.Offset); err != nil {
+		return 0, fmt.Errorf("Store.Append: failed to truncate file - %v", err)
+	}
+	if _, err := partial.Seek(info.Offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("Store.Append: failed to seek in file - %v", err)
+	}
+	written, err := io.Copy(partial, io.LimitReader(content, info.Size-info.Offset))
+	if syncErr := partial.Sync(); err == nil && syncErr != nil {
+		return 0, fmt.Errorf("Store.Append: failed to save file - %v", syncErr)
+	}
+	return written, err
+}
+
+// finalise turns the partially uploaded file into a complete file, encrypting its content if needed.
+func (store *Store) finalise(info *FileInfo) error {
+	partialPath := store.filePath(info.ID, partialFileSuffix)
+	dataPath := store.filePath(info.ID, dataFileSuffix)
+	if !info.Encrypted {
+		if err := os.Rename(partialPath, dataPath); err != nil {
+			return fmt.Errorf("Store.finalise: failed to rename file - %v", err)
+		}
+		return nil
+	}
+	src, err := os.Open(partialPath)
+	if err != nil {
+		return fmt.Errorf("Store.finalise: failed to open file - %v", err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Store.finalise: failed to create file - %v", err)
+	}
+	defer dst.Close()
+	if err := misc.EncryptStream(dst, src, []byte(store.EncryptionPassword)); err != nil {
+		return fmt.Errorf("Store.finalise: failed to encrypt file - %v", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("Store.finalise: failed to save file - %v", err)
+	}
+	return os.Remove(partialPath)
+}
+
+// Delete deletes a file, including the one that is still being uploaded.
+func (store *Store) Delete(id string) error {
+	if store.state == nil {
+		return ErrStoreNotInitialised
+	}
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	if _, err := store.load(id); err != nil {
+		return err
+	}
+	store.remove(id)
+	return nil
+}
+
+/*
+Open returns the metadata and content of a complete file. If countDownload is true, the download counts towards the
+file's download limit. The caller must close the content, which is an *os.File if the file is not encrypted.
+*/
+func (store *Store) Open(id string, countDownload bool) (*FileInfo, io.ReadCloser, error) {
+	if store.state == nil {
+		return nil, nil, ErrStoreNotInitialised
+	}
+	store.state.mutex.Lock()
+	defer store.state.mutex.Unlock()
+	info, err := store.load(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Expired() {
+		return nil, nil, ErrFileNotFound
+	} else if !info.Complete() {
+		return nil, nil, ErrUploadIncomplete
+	} else if info.Exhausted() {
+		return nil, nil, ErrDownloadsExhausted
+	}
+	dataFile, err := os.Open(store.filePath(id, dataFileSuffix))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Store.Open: failed to open file - %v", err)
+	}
+	if countDownload {
+		info.Downloads++
+		if err := store.save(info); err != nil {
+			_ = dataFile.Close()
+			return nil, nil, err
+		}
+	}
+	if !info.Encrypted {
+		return info, dataFile, nil
+	}
+	if store.EncryptionPassword == "" {
+		_ = dataFile.Close()
+		return nil, nil, errors.New("Store.Open: the file is encrypted but the store does not have the encryption password")
+	}
+	// Decrypt in the background, the reader sees an error if the content fails authentication.
+	reader, writer := io.Pipe()
+	go func() {
+		err := misc.DecryptStream(writer, dataFile, []byte(store.EncryptionPassword))
+		_ = dataFile.Close()
+		_ = writer.CloseWithError(err)
+	}()
+	return info, reader, nil
+}
+
+// linkSignature returns the signature of a share link of the file that expires at the unix timestamp.
+func (store *Store) linkSignature(id string, expiresUnix int64) string {
+	mac := hmac.New(sha256.New, store.linkKey)
+	_, _ = mac.Write([]byte(id + "/" + strconv.FormatInt(expiresUnix, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+SignLink returns the query string of a link that lets anyone download the complete file without authentication. The
+link expires after the validity period or along with the file, whichever comes first.
+*/
+func (store *Store) SignLink(id string, validity time.Duration) (string, error) {
+	info, err := store.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if !info.Complete() {
+		return "", ErrUploadIncomplete
+	}
+	expires := time.Now().Add(validity)
+	if expires.After(info.ExpiresAt) {
+		expires = info.ExpiresAt
+	}
+	return fmt.Sprintf("id=%s&exp=%d&sig=%s", id, expires.Unix(), store.linkSignature(id, expires.Unix())), nil
+}
+
+// VerifyLink returns an error if the share link parameters are not signed by this store or the link has expired.
+func (store *Store) VerifyLink(id, expires, signature string) error {
+	if store.state == nil {
+		return ErrStoreNotInitialised
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrBadLinkSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(store.linkSignature(id, expiresUnix))) {
+		return ErrBadLinkSignature
+	}
+	if time.Now().Unix() > expiresUnix {
+		return ErrLinkExpired
+	}
+	return nil
+}
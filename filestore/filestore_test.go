@@ -0,0 +1,243 @@
+package filestore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll opens the file and returns its content.
+func readAll(t *testing.T, store *Store, id string, countDownload bool) (string, error) {
+	_, content, err := store.Open(id, countDownload)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	data, err := ioutil.ReadAll(content)
+	return string(data), err
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &Store{Dir: dir, QuotaBytes: 20, MaxFileSizeBytes: 15}
+	if _, err := store.List(); err != ErrStoreNotInitialised {
+		t.Fatal(err)
+	}
+	if err := store.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("big", 16, 0, 0); err != ErrFileTooLarge {
+		t.Fatal(err)
+	}
+	// Upload a file in two chunks
+	info, err := store.Create(`C:\dir/hello.txt`, 11, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "hello.txt" || info.Complete() || info.Encrypted || !RegexFileID.MatchString(info.ID) {
+		t.Fatalf("%+v", info)
+	}
+	if _, err := store.Create("a", 10, 0, 0); err != ErrQuotaExceeded {
+		t.Fatal(err)
+	}
+	if offset, err := store.Append(info.ID, 1, strings.NewReader("hello")); err != ErrOffsetMismatch || offset != 0 {
+		t.Fatal(offset, err)
+	}
+	if offset, err := store.Append(info.ID, 0, strings.NewReader("hello")); err != nil || offset != 5 {
+		t.Fatal(offset, err)
+	}
+	if _, err := readAll(t, store, info.ID, false); err != ErrUploadIncomplete {
+		t.Fatal(err)
+	}
+	if _, err := store.SignLink(info.ID, time.Hour); err != ErrUploadIncomplete {
+		t.Fatal(err)
+	}
+	// Excess content is not read
+	if offset, err := store.Append(info.ID, 5, strings.NewReader(" world, and more")); err != nil || offset != 11 {
+		t.Fatal(offset, err)
+	}
+	if content, err := readAll(t, store, info.ID, false); err != nil || content != "hello world" {
+		t.Fatal(content, err)
+	}
+
+	// A share link is valid for the file it was signed for
+	link, err := store.SignLink(info.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(link, "&") {
+		keyValue := strings.SplitN(param, "=", 2)
+		params[keyValue[0]] = keyValue[1]
+	}
+	if err := store.VerifyLink(params["id"], params["exp"], params["sig"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.VerifyLink(strings.Repeat("0", 24), params["exp"], params["sig"]); err != ErrBadLinkSignature {
+		t.Fatal(err)
+	}
+	if err := store.VerifyLink(info.ID, "1", store.linkSignature(info.ID, 1)); err != ErrLinkExpired {
+		t.Fatal(err)
+	}
+	// Another store of the same directory shares the link key
+	another := &Store{Dir: dir}
+	if err := another.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := another.VerifyLink(params["id"], params["exp"], params["sig"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is deleted after reaching its download limit
+	for i := 0; i < 2; i++ {
+		if content, err := readAll(t, store, info.ID, true); err != nil || content != "hello world" {
+			t.Fatal(content, err)
+		}
+	}
+	if _, err := readAll(t, store, info.ID, true); err != ErrDownloadsExhausted {
+		t.Fatal(err)
+	}
+	if files, err := store.List(); err != nil || len(files) != 0 {
+		t.Fatal(files, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, info.ID+dataFileSuffix)); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// Empty files are complete right away
+	empty, err := store.Create("empty", 0, 1, 0)
+	if err != nil || !empty.Complete() {
+		t.Fatal(empty, err)
+	}
+	if content, err := readAll(t, store, empty.ID, true); err != nil || content != "" {
+		t.Fatal(content, err)
+	}
+	// Expired files are deleted
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := store.Get(empty.ID); err != ErrFileNotFound {
+		t.Fatal(err)
+	}
+	if files, err := store.List(); err != nil || len(files) != 0 {
+		t.Fatal(files, err)
+	}
+
+	// Delete an incomplete file
+	partial, err := store.Create("partial", 3, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(partial.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(partial.ID, 0, strings.NewReader("abc")); err != ErrFileNotFound {
+		t.Fatal(err)
+	}
+	if err := store.Delete("../" + LinkKeyFileName); err != ErrFileNotFound {
+		t.Fatal(err)
+	}
+}
+
+// failingReader returns its content followed by an error.
+type failingReader struct {
+	content *bytes.Reader
+}
+
+func (reader *failingReader) Read(p []byte) (int, error) {
+	if reader.content.Len() == 0 {
+		return 0, errors.New("connection lost")
+	}
+	return reader.content.Read(p)
+}
+
+func TestStore_Encryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &Store{Dir: dir, EncryptionPassword: "pass"}
+	if err := store.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Create("secret.txt", 9, 0, 0)
+	if err != nil || !info.Encrypted {
+		t.Fatal(info, err)
+	}
+	// An interrupted upload keeps the content received so far
+	if offset, err := store.Append(info.ID, 0, &failingReader{content: bytes.NewReader([]byte("top"))}); err == nil || offset != 3 {
+		t.Fatal(offset, err)
+	}
+	if offset, err := store.Append(info.ID, 3, strings.NewReader("secret")); err != nil || offset != 9 {
+		t.Fatal(offset, err)
+	}
+	// The content is encrypted at rest
+	atRest, err := ioutil.ReadFile(filepath.Join(dir, info.ID+dataFileSuffix))
+	if err != nil || bytes.Contains(atRest, []byte("secret")) {
+		t.Fatal(string(atRest), err)
+	}
+	if content, err := readAll(t, store, info.ID, false); err != nil || content != "topsecret" {
+		t.Fatal(content, err)
+	}
+	// A store without the password cannot read the content
+	another := &Store{Dir: dir}
+	if err := another.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(t, another, info.ID, false); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestStore_Sweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sweepInterval = 500 * time.Millisecond
+	defer func() {
+		sweepInterval = SweepIntervalSec * time.Second
+	}()
+
+	store := &Store{Dir: dir, EncryptionPassword: "pass", EncryptedUploadExpireSec: 1}
+	if err := store.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// An unfinished upload of an encrypted file expires sooner than the file
+	partial, err := store.Create("partial.txt", 9, 3600, 0)
+	if err != nil || partial.GetUploadExpiresAt().After(time.Now().Add(time.Second)) {
+		t.Fatal(partial, err)
+	}
+	if offset, err := store.Append(partial.ID, 0, strings.NewReader("top")); err != nil || offset != 3 {
+		t.Fatal(offset, err)
+	}
+	complete, err := store.Create("complete.txt", 9, 3600, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := store.Append(complete.ID, 0, strings.NewReader("topsecret")); err != nil || offset != 9 {
+		t.Fatal(offset, err)
+	}
+	// The expired upload is deleted in the background without anyone listing the files
+	time.Sleep(2 * time.Second)
+	if _, err := os.Stat(filepath.Join(dir, partial.ID+partialFileSuffix)); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(partial.ID, 3, strings.NewReader("secret")); err != ErrFileNotFound {
+		t.Fatal(err)
+	}
+	if content, err := readAll(t, store, complete.ID, false); err != nil || content != "topsecret" {
+		t.Fatal(content, err)
+	}
+}
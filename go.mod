@@ -7,4 +7,6 @@ require (
 	github.com/aws/aws-xray-sdk-go v1.1.0
 	github.com/go-sql-driver/mysql v1.5.0 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 )
@@ -1,13 +1,19 @@
 package inet
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -26,6 +32,9 @@ const (
 		after this limit is reached will cause earlier mails to be dropped permanently.
 	*/
 	MaxOutstandingMailSize = 200 * 1048576
+
+	// MXPort is the well-known port number of SMTP service on mail exchanges that receive mails for their domains.
+	MXPort = 25
 )
 
 /*
@@ -64,8 +73,11 @@ func checkNoCRLF(line string) error {
 	return nil
 }
 
-// sendMail connects to MTA, optionally presents client credentials for authentication, and then send a mail.
-func sendMail(smtpClient *smtp.Client, serverTLSName string, auth smtp.Auth, from string, recipients []string, message []byte) error {
+/*
+sendMail connects to MTA, optionally presents client credentials for authentication, and then send a mail.
+If tryStartTLS is true, the connection will be upgraded via StartTLS if the MTA supports it.
+*/
+func sendMail(smtpClient *smtp.Client, serverTLSName string, auth smtp.Auth, tryStartTLS bool, from string, recipients []string, message []byte) error {
 	if err := checkNoCRLF(from); err != nil {
 		return err
 	}
@@ -75,7 +87,7 @@ func sendMail(smtpClient *smtp.Client, serverTLSName string, auth smtp.Auth, fro
 		}
 	}
 	defer smtpClient.Close()
-	if canStartTLS, _ := smtpClient.Extension("STARTTLS"); canStartTLS {
+	if canStartTLS, _ := smtpClient.Extension("STARTTLS"); canStartTLS && tryStartTLS {
 		if err := smtpClient.StartTLS(&tls.Config{ServerName: serverTLSName}); err != nil {
 			return err
 		}
@@ -121,11 +133,178 @@ type MailClient struct {
 	MTAPort      int    `json:"MTAPort"`      // Port number of SMTP service on mail transportation agent
 	AuthUsername string `json:"AuthUsername"` // (Optional) Username for plain authentication, if the SMTP server requires it.
 	AuthPassword string `json:"AuthPassword"` // (Optional) Password for plain authentication, if the SMTP server requires it.
+
+	/*
+		DeliverDirectly delivers mails straight to the mail exchanges (MX) of recipients' domains, without relying on a
+		mail transportation agent. If the MTA host is also configured, it will be used as a fallback should the direct
+		delivery fail.
+	*/
+	DeliverDirectly bool `json:"DeliverDirectly"`
+	/*
+		MXResolverAddr is the (optional) address of DNS resolver, such as laitos DNS daemon "127.0.0.1:53", for looking up
+		MX records of recipients' domains. The system resolver is used by default.
+	*/
+	MXResolverAddr string `json:"MXResolverAddr"`
+
+	// mxPort is the port number of SMTP service on mail exchanges, test cases use it in place of MXPort.
+	mxPort int
 }
 
 // Return true only if all mail parameters are present.
 func (client *MailClient) IsConfigured() bool {
-	return client.MailFrom != "" && client.MTAHost != "" && client.MTAPort != 0
+	return client.MailFrom != "" && (client.DeliverDirectly || client.MTAHost != "" && client.MTAPort != 0)
+}
+
+// getMXResolver returns the DNS resolver that looks up MX records of recipients' domains.
+func (client *MailClient) getMXResolver() *net.Resolver {
+	if client.MXResolverAddr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, client.MXResolverAddr)
+		},
+	}
+}
+
+// getMXPort returns the port number of SMTP service on mail exchanges.
+func (client *MailClient) getMXPort() int {
+	if client.mxPort == 0 {
+		return MXPort
+	}
+	return client.mxPort
+}
+
+// groupRecipientsByDomain returns recipient addresses grouped by their domain name (in lower case).
+func groupRecipientsByDomain(recipients []string) (domains []string, recipientsOfDomain map[string][]string) {
+	recipientsOfDomain = make(map[string][]string)
+	for _, recipient := range recipients {
+		domain := strings.ToLower(recipient[strings.LastIndexByte(recipient, '@')+1:])
+		if _, exists := recipientsOfDomain[domain]; !exists {
+			domains = append(domains, domain)
+		}
+		recipientsOfDomain[domain] = append(recipientsOfDomain[domain], recipient)
+	}
+	return
+}
+
+/*
+lookupMX returns the host names of mail exchanges of the domain, ordered by their preference. If the domain does not
+have an MX record, the domain name itself is returned as the implicit mail exchange (RFC 5321).
+*/
+func (client *MailClient) lookupMX(domain string) ([]string, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), MailIOTimeoutSec*time.Second)
+	defer cancel()
+	mxRecords, err := client.getMXResolver().LookupMX(timeout, domain)
+	if err != nil {
+		if dnsErr, isDNSErr := err.(*net.DNSError); isDNSErr && dnsErr.IsNotFound {
+			return []string{domain}, nil
+		}
+		return nil, err
+	}
+	if len(mxRecords) == 0 {
+		return []string{domain}, nil
+	}
+	// The resolver has already sorted the records by preference
+	hosts := make([]string, 0, len(mxRecords))
+	for _, mx := range mxRecords {
+		hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+	}
+	return hosts, nil
+}
+
+/*
+deliverToMX delivers the mail to each recipient domain's mail exchanges, while using StartTLS opportunistically. The
+function returns recipients of the domains that failed to accept the mail, and the latest delivery error.
+*/
+func (client *MailClient) deliverToMX(from string, recipients []string, message []byte) (failedRecipients []string, err error) {
+	helloName := client.MailFrom[strings.LastIndexByte(client.MailFrom, '@')+1:]
+	domains, recipientsOfDomain := groupRecipientsByDomain(recipients)
+	for _, domain := range domains {
+		var mxHosts []string
+		mxHosts, err = client.lookupMX(domain)
+		if err != nil {
+			failedRecipients = append(failedRecipients, recipientsOfDomain[domain]...)
+			continue
+		}
+		delivered := false
+		for _, mxHost := range mxHosts {
+			/*
+				Try StartTLS first, if the handshake fails then deliver the mail in plain text. As customary for mail
+				exchanges, the StartTLS is opportunistic and does not verify the MX certificate.
+			*/
+			for _, tryStartTLS := range []bool{true, false} {
+				var conn net.Conn
+				conn, err = net.DialTimeout("tcp", net.JoinHostPort(mxHost, strconv.Itoa(client.getMXPort())), MailIOTimeoutSec*time.Second)
+				if err != nil {
+					break
+				}
+				var smtpClient *smtp.Client
+				if smtpClient, err = smtp.NewClient(conn, mxHost); err != nil {
+					conn.Close()
+					break
+				}
+				if helloName != "" {
+					if err = smtpClient.Hello(helloName); err != nil {
+						smtpClient.Close()
+						break
+					}
+				}
+				if canStartTLS, _ := smtpClient.Extension("STARTTLS"); canStartTLS && tryStartTLS {
+					if err = smtpClient.StartTLS(&tls.Config{ServerName: mxHost, InsecureSkipVerify: true}); err != nil {
+						smtpClient.Close()
+						continue
+					}
+				}
+				err = sendMail(smtpClient, mxHost, nil, false, from, recipientsOfDomain[domain], message)
+				delivered = err == nil
+				break
+			}
+			if delivered {
+				CommonMailLogger.Info("deliverToMX", from, nil, "delivered mail to %v via MX %s", recipientsOfDomain[domain], mxHost)
+				break
+			}
+			CommonMailLogger.Warning("deliverToMX", from, err, "failed to deliver mail to %v via MX %s", recipientsOfDomain[domain], mxHost)
+		}
+		if !delivered {
+			failedRecipients = append(failedRecipients, recipientsOfDomain[domain]...)
+		}
+	}
+	if len(failedRecipients) == 0 {
+		err = nil
+	} else if err == nil {
+		err = fmt.Errorf("failed to deliver mail to %v", failedRecipients)
+	}
+	return
+}
+
+/*
+deliverViaMTA delivers the mail via the MTA host. The attempt number determines which one of the MTA host's IP
+addresses is used.
+*/
+func (client *MailClient) deliverViaMTA(attempt int, from string, recipients []string, message []byte) (tlsErr, err error) {
+	var auth smtp.Auth
+	// Find the latest set of IP addresses belonging to the MTA
+	timeout, cancel := context.WithTimeout(context.Background(), MailIOTimeoutSec*time.Second)
+	defer cancel()
+	mtaIPs, err := net.DefaultResolver.LookupIPAddr(timeout, client.MTAHost)
+	if err != nil {
+		return
+	}
+	// Try connecting to one of the MTA's IP addresses to deliver the mail
+	mtaIP := mtaIPs[attempt%len(mtaIPs)].IP.String()
+	if client.AuthUsername != "" {
+		auth = smtp.PlainAuth("", client.AuthUsername, client.AuthPassword, mtaIP)
+	}
+	smtpClient, tlsErr, err := dialMTA(mtaIP, client.MTAHost, client.MTAPort)
+	if err != nil {
+		return
+	}
+	defer smtpClient.Close()
+	err = sendMail(smtpClient, client.MTAHost, auth, true, from, recipients, message)
+	return
 }
 
 /*
@@ -134,8 +313,6 @@ randomly selected MTA IP for up to 12 times within couple of days. The function
 all delivery attempts.
 */
 func (client *MailClient) sendMailWithRetry(from string, recipients []string, message []byte) {
-	var auth smtp.Auth
-
 	// Count the size of this Email
 	atomic.AddInt64(&misc.OutstandingMailBytes, int64(len(message)))
 	defer func() {
@@ -146,36 +323,22 @@ func (client *MailClient) sendMailWithRetry(from string, recipients []string, me
 	// Retry mail delivery up to couple of days, introduce a random initial delay to avoid triggering MTA's rate limit.
 	sleep := time.Duration(30+rand.Intn(30)) * time.Second
 	for i := 0; i < 12; i++ {
-		var smtpClient *smtp.Client
-		var mtaIP string
-		var tlsErr error
-
-		// Find the latest set of IP addresses belonging to the MTA
-		timeout, cancel := context.WithTimeout(context.Background(), MailIOTimeoutSec*time.Second)
-		mtaIPs, err := net.DefaultResolver.LookupIPAddr(timeout, client.MTAHost)
-		if err != nil {
-			goto sleepAndRetry
-		}
-		// Try connecting to one of the MTA's IP addresses to deliver the mail
-		mtaIP = mtaIPs[i%len(mtaIPs)].IP.String()
-		if client.AuthUsername != "" {
-			auth = smtp.PlainAuth("", client.AuthUsername, client.AuthPassword, mtaIP)
-		}
-		smtpClient, tlsErr, err = dialMTA(mtaIP, client.MTAHost, client.MTAPort)
-		if err != nil {
-			goto sleepAndRetry
+		var tlsErr, err error
+		if client.DeliverDirectly {
+			// Recipients that have received the mail will not receive it again in the next attempt
+			recipients, err = client.deliverToMX(from, recipients, message)
+			if err != nil && client.MTAHost != "" {
+				CommonMailLogger.Info("sendMailWithRetry", from, err, "falling back to MTA host to deliver mail to %v", recipients)
+				tlsErr, err = client.deliverViaMTA(i, from, recipients, message)
+			}
+		} else {
+			tlsErr, err = client.deliverViaMTA(i, from, recipients, message)
 		}
-		if err = sendMail(smtpClient, client.MTAHost, auth, from, recipients, message); err != nil {
-			smtpClient.Close()
-			goto sleepAndRetry
+		if err == nil {
+			// Success!
+			CommonMailLogger.Info("sendMailWithRetry", from, nil, "successfully delivered mail to %v", recipients)
+			return
 		}
-		// Success!
-		cancel()
-		CommonMailLogger.Info("sendMailWithRetry", from, nil, "successfully delivered mail to %v", recipients)
-		smtpClient.Close()
-		return
-	sleepAndRetry:
-		cancel()
 		CommonMailLogger.Warning("sendMailWithRetry", from, err, "failed to deliver mail to %v in the attempt %d (tls error? %v)", recipients, i, tlsErr)
 		// At least one attempt of mail delivery must have been made in order to consider dropping the mail
 		if atomic.LoadInt64(&misc.OutstandingMailBytes) > MaxOutstandingMailSize {
@@ -194,10 +357,105 @@ func (client *MailClient) Send(subject string, textBody string, recipients ...st
 	if len(recipients) == 0 {
 		return fmt.Errorf("no recipient specified for mail \"%s\"", subject)
 	}
-	// Construct appropriate mail headers
-	mailBody := fmt.Sprintf("MIME-Version: 1.0\r\nContent-type: text/plain; charset=utf-8\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
-		client.MailFrom, strings.Join(recipients, ", "), subject, textBody)
-	go client.sendMailWithRetry(client.MailFrom, recipients, []byte(mailBody))
+	entity, err := BuildMailEntity(textBody, nil)
+	if err != nil {
+		return err
+	}
+	go client.sendMailWithRetry(client.MailFrom, recipients, append(client.buildMailHeaders(subject, recipients), entity...))
+	return nil
+}
+
+/*
+buildMailHeaders returns the MIME version, sender, recipient, and subject headers of an outgoing mail. The subject is
+encoded according to RFC 2047 should it contain characters other than printable ASCII.
+*/
+func (client *MailClient) buildMailHeaders(subject string, recipients []string) []byte {
+	return []byte(fmt.Sprintf("MIME-Version: 1.0\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\n",
+		client.MailFrom, strings.Join(recipients, ", "), mime.QEncoding.Encode("utf-8", subject)))
+}
+
+// encodeQuotedPrintable returns the text encoded in quoted-printable (RFC 2045), which keeps lines short and 7-bit clean.
+func encodeQuotedPrintable(text string) ([]byte, error) {
+	var encoded bytes.Buffer
+	writer := quotedprintable.NewWriter(&encoded)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+/*
+BuildMailEntity returns a MIME entity, made of headers and body, that carries the text body and file attachments. Without
+attachments the entity is a plain text, otherwise it is a multipart entity. The text body is encoded in quoted-printable.
+*/
+func BuildMailEntity(textBody string, attachments []MailPart) ([]byte, error) {
+	encodedText, err := encodeQuotedPrintable(textBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return append([]byte("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"), encodedText...), nil
+	}
+	var entity bytes.Buffer
+	multipartWriter := multipart.NewWriter(&entity)
+	entity.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%s\r\n\r\n", multipartWriter.Boundary()))
+	textPart, err := multipartWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := textPart.Write(encodedText); err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attachmentPart, err := multipartWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": attachment.FileName})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Wrap base64 lines at 76 characters (RFC 2045)
+		encoded := base64.StdEncoding.EncodeToString(attachment.Body)
+		for len(encoded) > 76 {
+			if _, err := attachmentPart.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := attachmentPart.Write([]byte(encoded + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+	if err := multipartWriter.Close(); err != nil {
+		return nil, err
+	}
+	return entity.Bytes(), nil
+}
+
+/*
+SendWithAttachments delivers a multipart mail made of the text body and file attachments to all recipients. The
+function does not block caller, the mail is delivered in the background.
+*/
+func (client *MailClient) SendWithAttachments(subject string, textBody string, attachments []MailPart, recipients ...string) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipient specified for mail \"%s\"", subject)
+	}
+	entity, err := BuildMailEntity(textBody, attachments)
+	if err != nil {
+		return err
+	}
+	go client.sendMailWithRetry(client.MailFrom, recipients, append(client.buildMailHeaders(subject, recipients), entity...))
 	return nil
 }
 
@@ -210,8 +468,23 @@ func (client *MailClient) SendRaw(fromAddr string, rawMailBody []byte, recipient
 	return nil
 }
 
-// Try to contact MTA and see if connection is possible.
+/*
+Try to contact MTA and see if connection is possible. If the client delivers mails directly without an MTA host, the
+test will contact the mail exchange of the sender's domain instead.
+*/
 func (client *MailClient) SelfTest() error {
+	if client.DeliverDirectly && client.MTAHost == "" {
+		mxHosts, err := client.lookupMX(client.MailFrom[strings.LastIndexByte(client.MailFrom, '@')+1:])
+		if err != nil {
+			return fmt.Errorf("MailClient.SelfTest: failed to look up MX of sender's domain - %v", err)
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(mxHosts[0], strconv.Itoa(client.getMXPort())), MailIOTimeoutSec*time.Second)
+		if err != nil {
+			return fmt.Errorf("MailClient.SelfTest: connection test failed - %v", err)
+		}
+		conn.Close()
+		return nil
+	}
 	smtpClient, tlsErr, err := dialMTA(client.MTAHost, client.MTAHost, client.MTAPort)
 	if err != nil {
 		return fmt.Errorf("MailClient.SelfTest: connection test failed - %v (TLS error? %v)", err, tlsErr)
@@ -1,7 +1,13 @@
 package inet
 
 import (
+	"bytes"
+	"mime"
 	"net"
+	"net/mail"
+	"net/textproto"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +41,186 @@ func TestMailer_Send(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupRecipientsByDomain(t *testing.T) {
+	domains, recipients := groupRecipientsByDomain([]string{"a@example.com", "b@Example.COM", "c@example.org", "d@sub@example.org"})
+	if !reflect.DeepEqual(domains, []string{"example.com", "example.org"}) {
+		t.Fatal(domains)
+	}
+	if !reflect.DeepEqual(recipients, map[string][]string{
+		"example.com": {"a@example.com", "b@Example.COM"},
+		"example.org": {"c@example.org", "d@sub@example.org"},
+	}) {
+		t.Fatal(recipients)
+	}
+}
+
+func TestMailClient_DeliverToMX(t *testing.T) {
+	m := MailClient{MailFrom: "howard@localhost"}
+	if m.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	m.DeliverDirectly = true
+	if !m.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	// The resolver does not respond, hence none of the recipients will receive the mail.
+	m.MXResolverAddr = "127.0.0.1:1"
+	failed, err := m.deliverToMX(m.MailFrom, []string{"a@example.com", "b@example.org"}, []byte("Subject: test\r\n\r\ntest"))
+	if err == nil || !reflect.DeepEqual(failed, []string{"a@example.com", "b@example.org"}) {
+		t.Fatal(failed, err)
+	}
+}
+
+// startNXDomainResolver starts a DNS server that answers NXDOMAIN to all queries and returns its address.
+func startNXDomainResolver(t *testing.T) (addr string, stop func()) {
+	udpServer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, client, err := udpServer.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// The answer carries the query ID, the question, and nothing else
+			if n < 12 {
+				continue
+			}
+			questionEnd := bytes.IndexByte(buf[12:n], 0)
+			if questionEnd == -1 || 12+questionEnd+5 > n {
+				continue
+			}
+			answer := append([]byte{buf[0], buf[1], 0x81, 0x83, 0, 1, 0, 0, 0, 0, 0, 0}, buf[12:12+questionEnd+5]...)
+			_, _ = udpServer.WriteTo(answer, client)
+		}
+	}()
+	return udpServer.LocalAddr().String(), func() { _ = udpServer.Close() }
+}
+
+func TestMailClient_DeliverToMXSuccess(t *testing.T) {
+	resolverAddr, stopResolver := startNXDomainResolver(t)
+	defer stopResolver()
+	// The fake MX records the envelope and data of the mail it receives
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var mailFrom string
+	var rcptTo []string
+	var data []byte
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		smtpConn := textproto.NewConn(conn)
+		_ = smtpConn.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := smtpConn.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case verb == "EHLO" || verb == "HELO":
+				_ = smtpConn.PrintfLine("250-localhost")
+				_ = smtpConn.PrintfLine("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				mailFrom = line[len("MAIL FROM:"):]
+				_ = smtpConn.PrintfLine("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				rcptTo = append(rcptTo, line[len("RCPT TO:"):])
+				_ = smtpConn.PrintfLine("250 OK")
+			case verb == "DATA":
+				_ = smtpConn.PrintfLine("354 Go ahead")
+				if data, err = smtpConn.ReadDotBytes(); err != nil {
+					t.Error(err)
+					return
+				}
+				_ = smtpConn.PrintfLine("250 OK")
+			case verb == "QUIT":
+				_ = smtpConn.PrintfLine("221 Bye")
+				return
+			default:
+				_ = smtpConn.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	m := MailClient{MailFrom: "howard@localhost", DeliverDirectly: true, MXResolverAddr: resolverAddr}
+	m.mxPort = listener.Addr().(*net.TCPAddr).Port
+	// Without an MX record, the recipient domain itself is the mail exchange
+	failed, err := m.deliverToMX(m.MailFrom, []string{"a@localhost", "b@LOCALHOST"}, []byte("Subject: test\r\n\r\n.test"))
+	if err != nil || len(failed) != 0 {
+		t.Fatal(failed, err)
+	}
+	<-done
+	if mailFrom != "<howard@localhost>" || !reflect.DeepEqual(rcptTo, []string{"<a@localhost>", "<b@LOCALHOST>"}) {
+		t.Fatal(mailFrom, rcptTo)
+	}
+	// The line that begins with a dot arrives intact
+	if string(data) != "Subject: test\n\n.test\n" {
+		t.Fatalf("%q", data)
+	}
+}
+
+func TestBuildMailEntity(t *testing.T) {
+	// The text has non-ASCII characters, a line longer than 76 characters, and an equal sign
+	text := "héllo 你好 a=b\r\n" + strings.Repeat("x", 200)
+	attachments := []MailPart{{ContentType: "image/png", FileName: "a.png", Body: bytes.Repeat([]byte{1, 2, 3}, 100)}}
+	entity, err := BuildMailEntity(text, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts, err := ReadMailParts(append([]byte("Subject: hi\r\n"), entity...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MailPart{{ContentType: "text/plain", Body: []byte(text)}, attachments[0]}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Fatalf("%+v", parts)
+	}
+	// Without attachments the entity is plain text
+	entity, err = BuildMailEntity(text, nil)
+	if err != nil || !bytes.HasPrefix(entity, []byte("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")) {
+		t.Fatal(err, string(entity))
+	}
+	for _, line := range strings.Split(string(entity), "\r\n") {
+		if len(line) > 76 {
+			t.Fatal("line is too long", line)
+		}
+		for _, c := range line {
+			if c > 127 {
+				t.Fatal("line is not 7-bit", line)
+			}
+		}
+	}
+	if _, parts, err = ReadMailParts(append([]byte("Subject: hi\r\n"), entity...)); err != nil || len(parts) != 1 || string(parts[0].Body) != text {
+		t.Fatalf("%v %+v", err, parts)
+	}
+	// Subject of non-ASCII characters is encoded
+	client := MailClient{MailFrom: "from@example.com"}
+	headers := client.buildMailHeaders("laitos 你好", []string{"a@example.com", "b@example.com"})
+	msg, err := mail.ReadMessage(bytes.NewReader(append(headers, "\r\nbody"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != "laitos 你好" || !strings.HasPrefix(msg.Header.Get("Subject"), "=?utf-8?q?") {
+		t.Fatal(err, msg.Header.Get("Subject"))
+	}
+	if msg.Header.Get("To") != "a@example.com, b@example.com" || msg.Header.Get("From") != "from@example.com" {
+		t.Fatal(msg.Header)
+	}
+	// ASCII subject remains as it is
+	if headers := client.buildMailHeaders("laitos subject", []string{"a@example.com"}); !bytes.Contains(headers, []byte("\r\nSubject: laitos subject\r\n")) {
+		t.Fatal(string(headers))
+	}
+}
@@ -2,7 +2,8 @@ package inet
 
 import (
 	"bytes"
-	"github.com/HouzuoGuo/laitos/misc"
+	"encoding/base64"
+	"errors"
 	"io"
 	"mime"
 	"mime/multipart"
@@ -10,6 +11,8 @@ import (
 	"net/mail"
 	"regexp"
 	"strings"
+
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 const (
@@ -18,6 +21,9 @@ const (
 		The number defined here is slightly more generous than the norm.
 	*/
 	MaxMailBodySize = 32 * 1048576
+
+	// MaxMailPartDepth is the maximum depth of nested multipart content that ReadMailParts will descend into.
+	MaxMailPartDepth = 8
 )
 
 // RegexMailAddress finds *@*.* that looks much like an Email address
@@ -133,3 +139,80 @@ func WalkMailMessage(mailMessage []byte, fun func(BasicMail, []byte) (bool, erro
 		return err
 	}
 }
+
+// MailPart is a leaf part of a (possibly nested) multipart mail message, or the entire body of a non-multipart message.
+type MailPart struct {
+	ContentType string // ContentType is the media type of the part, such as "text/plain", without parameters.
+	FileName    string // FileName is the name of attached file, it is empty for ordinary text parts.
+	Body        []byte // Body is the content of the part, decoded from its transfer encoding.
+}
+
+// IsAttachment returns true only if the mail part is an attached file.
+func (part MailPart) IsAttachment() bool {
+	return part.FileName != ""
+}
+
+// decodeTransferEncoding returns a reader that decodes the content according to its transfer encoding.
+func decodeTransferEncoding(encoding string, content io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(content)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, content)
+	default:
+		return content
+	}
+}
+
+// readMailPart reads a part of mail message, descends into nested multipart content, and appends leaf parts to the slice.
+func readMailPart(contentType, transferEncoding, fileName string, content io.Reader, depth int, parts *[]MailPart) error {
+	if depth > MaxMailPartDepth {
+		return errors.New("readMailPart: multipart content is nested too deeply")
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// The content type is optional and defaults to plain text (RFC 2045)
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if fileName == "" {
+		fileName = params["name"]
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		partReader := multipart.NewReader(content, params["boundary"])
+		for {
+			part, err := partReader.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := readMailPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.FileName(), part, depth+1, parts); err != nil {
+				return err
+			}
+		}
+	}
+	body, err := misc.ReadAllUpTo(decodeTransferEncoding(transferEncoding, content), MaxMailBodySize)
+	if err != nil {
+		return err
+	}
+	*parts = append(*parts, MailPart{ContentType: mediaType, FileName: fileName, Body: body})
+	return nil
+}
+
+/*
+ReadMailParts dissects input mail message and returns its basic properties and all of its parts. Nested multipart
+content is flattened, and the content of each part is decoded from its transfer encoding.
+*/
+func ReadMailParts(mailMessage []byte) (prop BasicMail, parts []MailPart, err error) {
+	prop, parsedMail, err := ReadMailMessage(mailMessage)
+	if err != nil {
+		return
+	}
+	var fileName string
+	if _, dispositionParams, dispositionErr := mime.ParseMediaType(parsedMail.Header.Get("Content-Disposition")); dispositionErr == nil {
+		fileName = dispositionParams["filename"]
+	}
+	parts = make([]MailPart, 0, 4)
+	err = readMailPart(prop.ContentType, parsedMail.Header.Get("Content-Transfer-Encoding"), fileName, parsedMail.Body, 0, &parts)
+	return
+}
@@ -219,3 +219,56 @@ hoho` {
 		t.Fatal(err)
 	}
 }
+
+var NestedMultipartMail = []byte("From: howard@localhost\r\n" +
+	"To: root@localhost\r\n" +
+	"Subject: hi\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"hello =3D world\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>hello</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"a.bin\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAEC\r\n" +
+	"--outer--\r\n")
+
+func TestReadMailParts(t *testing.T) {
+	prop, parts, err := ReadMailParts(NestedMultipartMail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prop.Subject != "hi" || prop.FromAddress != "howard@localhost" {
+		t.Fatalf("%+v", prop)
+	}
+	expected := []MailPart{
+		{ContentType: "text/plain", Body: []byte("hello = world")},
+		{ContentType: "text/html", Body: []byte("<p>hello</p>")},
+		{ContentType: "application/octet-stream", FileName: "a.bin", Body: []byte{0, 1, 2}},
+	}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Fatalf("%+v", parts)
+	}
+	if parts[0].IsAttachment() || !parts[2].IsAttachment() {
+		t.Fatal("wrong attachment")
+	}
+	// A message that is not multipart is a single part
+	_, parts, err = ReadMailParts([]byte("Subject: hi\r\n\r\nbody"))
+	if err != nil || len(parts) != 1 || parts[0].ContentType != "text/plain" || string(parts[0].Body) != "body" {
+		t.Fatal(err, parts)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/HouzuoGuo/laitos/daemon/maintenance"
 	"github.com/HouzuoGuo/laitos/daemon/plainsocket"
 	"github.com/HouzuoGuo/laitos/daemon/simpleipsvcd"
+	"github.com/HouzuoGuo/laitos/daemon/smsmodem"
 	"github.com/HouzuoGuo/laitos/daemon/smtpd"
 	"github.com/HouzuoGuo/laitos/daemon/smtpd/mailcmd"
 	"github.com/HouzuoGuo/laitos/daemon/snmpd"
@@ -82,6 +83,9 @@ type HTTPHandlers struct {
 	CommandFormEndpoint string `json:"CommandFormEndpoint"`
 	FileUploadEndpoint  string `json:"FileUploadEndpoint"`
 
+	FileStoreEndpoint       string                  `json:"FileStoreEndpoint"`
+	FileStoreEndpointConfig handler.HandleFileStore `json:"FileStoreEndpointConfig"`
+
 	GitlabBrowserEndpoint       string                      `json:"GitlabBrowserEndpoint"`
 	GitlabBrowserEndpointConfig handler.HandleGitlabBrowser `json:"GitlabBrowserEndpointConfig"`
 
@@ -101,7 +105,8 @@ type HTTPHandlers struct {
 	RecurringCommandsEndpoint       string                          `json:"RecurringCommandsEndpoint"`
 	RecurringCommandsEndpointConfig handler.HandleRecurringCommands `json:"RecurringCommandsEndpointConfig"`
 
-	WebProxyEndpoint string `json:"WebProxyEndpoint"`
+	WebProxyEndpoint       string                 `json:"WebProxyEndpoint"`
+	WebProxyEndpointConfig handler.HandleWebProxy `json:"WebProxyEndpointConfig"`
 
 	TheThingsNetworkEndpoint string `json:"TheThingsNetworkEndpoint"`
 
@@ -111,6 +116,7 @@ type HTTPHandlers struct {
 
 	AppCommandEndpoint       string `json:"AppCommandEndpoint"`
 	ReportsRetrievalEndpoint string `json:"ReportsRetrievalEndpoint"`
+	SockdUsersEndpoint       string `json:"SockdUsersEndpoint"`
 }
 
 // The structure is JSON-compatible and capable of setting up all features and front-end services.
@@ -150,6 +156,9 @@ type Config struct {
 	SerialPortDaemon  *serialport.Daemon `json:"SerialPortDaemon"` // SerialPortDaemon serves toolbox commands over devices connected to serial ports
 	SerialPortFilters StandardFilters    `json:"SerialPortFilters"`
 
+	SMSModemDaemon  *smsmodem.Daemon `json:"SMSModemDaemon"`  // SMSModemDaemon runs toolbox commands sent via SMS to a GSM modem
+	SMSModemFilters StandardFilters  `json:"SMSModemFilters"` // SMSModemFilters configure command processor for SMS modem daemon
+
 	SockDaemon *sockd.Daemon `json:"SockDaemon"` // Intentionally undocumented
 
 	SNMPDaemon *snmpd.Daemon `json:"SNMPDaemon"` // SNMPDaemon configuration and instance
@@ -174,6 +183,7 @@ type Config struct {
 	phoneHomeDaemonInit   *sync.Once
 	plainSocketDaemonInit *sync.Once
 	serialPortDaemonInit  *sync.Once
+	smsModemDaemonInit    *sync.Once
 	sockDaemonInit        *sync.Once
 	telegramBotInit       *sync.Once
 	autoUnlockInit        *sync.Once
@@ -267,6 +277,10 @@ func (config *Config) Initialise() error {
 	if config.SNMPDaemon == nil {
 		config.SNMPDaemon = &snmpd.Daemon{}
 	}
+	config.smsModemDaemonInit = new(sync.Once)
+	if config.SMSModemDaemon == nil {
+		config.SMSModemDaemon = &smsmodem.Daemon{}
+	}
 	config.sockDaemonInit = new(sync.Once)
 	if config.SockDaemon == nil {
 		config.SockDaemon = &sockd.Daemon{}
@@ -286,6 +300,7 @@ func (config *Config) Initialise() error {
 	config.MailFilters.NotifyViaEmail.MailClient = config.MailClient
 	config.PhoneHomeFilters.NotifyViaEmail.MailClient = config.MailClient
 	config.PlainSocketFilters.NotifyViaEmail.MailClient = config.MailClient
+	config.SMSModemFilters.NotifyViaEmail.MailClient = config.MailClient
 	config.TelegramFilters.NotifyViaEmail.MailClient = config.MailClient
 	// SendMail feature also shares the common mail client
 	config.Features.SendMail.MailClient = config.MailClient
@@ -439,7 +454,7 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 			handlers[imageEndpoint] = browserImageHandler
 			// Browser handler needs to use image handler's path
 			browserHandler.ImageEndpoint = imageEndpoint
-			browserImageHandler.Browsers = &browserHandler.Browsers
+			browserImageHandler.Browsers = browserHandler.Renderers()
 			handlers[config.HTTPHandlers.BrowserPhantomJSEndpoint] = &browserHandler
 		}
 		// Configure a browser (SlimerJS) render image endpoint at a randomly generated endpoint name
@@ -457,7 +472,7 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 			handlers[imageEndpoint] = browserImageHandler
 			// Browser handler needs to use image handler's path
 			browserHandler.ImageEndpoint = imageEndpoint
-			browserImageHandler.Browsers = &browserHandler.Browsers
+			browserImageHandler.Browsers = browserHandler.Renderers()
 			handlers[config.HTTPHandlers.BrowserSlimerJSEndpoint] = &browserHandler
 		}
 
@@ -486,11 +501,17 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 		if config.HTTPHandlers.FileUploadEndpoint != "" {
 			handlers[config.HTTPHandlers.FileUploadEndpoint] = &handler.HandleFileUpload{}
 		}
+		if config.HTTPHandlers.FileStoreEndpoint != "" {
+			hand := config.HTTPHandlers.FileStoreEndpointConfig
+			handlers[config.HTTPHandlers.FileStoreEndpoint] = &hand
+		}
 		if config.HTTPHandlers.GitlabBrowserEndpoint != "" {
 			config.HTTPHandlers.GitlabBrowserEndpointConfig.MailClient = config.MailClient
 			handlers[config.HTTPHandlers.GitlabBrowserEndpoint] = &config.HTTPHandlers.GitlabBrowserEndpointConfig
 		}
 		if config.HTTPHandlers.IndexEndpoints != nil {
+			// The home page template may present the latest results of recurring commands
+			config.HTTPHandlers.IndexEndpointConfig.RecurringCommands = config.HTTPHandlers.RecurringCommandsEndpointConfig.RecurringCommands
 			for _, location := range config.HTTPHandlers.IndexEndpoints {
 				handlers[location] = &config.HTTPHandlers.IndexEndpointConfig
 			}
@@ -517,7 +538,9 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 			handlers[config.HTTPHandlers.RecurringCommandsEndpoint] = &config.HTTPHandlers.RecurringCommandsEndpointConfig
 		}
 		if proxyEndpoint := config.HTTPHandlers.WebProxyEndpoint; proxyEndpoint != "" {
-			handlers[proxyEndpoint] = &handler.HandleWebProxy{OwnEndpoint: proxyEndpoint}
+			hand := config.HTTPHandlers.WebProxyEndpointConfig
+			hand.OwnEndpoint = proxyEndpoint
+			handlers[proxyEndpoint] = &hand
 		}
 		if ttnEndpoint := config.HTTPHandlers.TheThingsNetworkEndpoint; ttnEndpoint != "" {
 			handlers[ttnEndpoint] = &handler.HandleTheThingsNetworkHTTPIntegration{}
@@ -551,6 +574,9 @@ func (config *Config) GetHTTPD() *httpd.Daemon {
 		if config.HTTPHandlers.ReportsRetrievalEndpoint != "" {
 			handlers[config.HTTPHandlers.ReportsRetrievalEndpoint] = &handler.HandleReportsRetrieval{}
 		}
+		if config.HTTPHandlers.SockdUsersEndpoint != "" {
+			handlers[config.HTTPHandlers.SockdUsersEndpoint] = &handler.HandleSockdUsers{Daemon: config.GetSockDaemon()}
+		}
 		config.HTTPDaemon.HandlerCollection = handlers
 		stripURLPrefixFromRequest := os.Getenv(EnvironmentStripURLPrefixFromRequest)
 		stripURLPrefixFromResponse := os.Getenv(EnvironmentStripURLPrefixFromResponse)
@@ -657,6 +683,29 @@ func (config *Config) GetPlainSocketDaemon() *plainsocket.Daemon {
 	return config.PlainSocketDaemon
 }
 
+// GetSMSModemDaemon constructs the SMS modem daemon from configuration and returns.
+func (config *Config) GetSMSModemDaemon() *smsmodem.Daemon {
+	config.smsModemDaemonInit.Do(func() {
+		config.SMSModemDaemon.Processor = &toolbox.CommandProcessor{
+			Features: config.Features,
+			CommandFilters: []toolbox.CommandFilter{
+				&config.SMSModemFilters.PINAndShortcuts,
+				&config.SMSModemFilters.TranslateSequences,
+			},
+			ResultFilters: []toolbox.ResultFilter{
+				&config.SMSModemFilters.LintText,
+				&toolbox.SayEmptyOutput{}, // this is mandatory but not configured by user's config file
+				&config.SMSModemFilters.NotifyViaEmail,
+			},
+		}
+		if err := config.SMSModemDaemon.Initialise(); err != nil {
+			config.logger.Abort("GetSMSModemDaemon", "", err, "the daemon failed to initialise")
+			return
+		}
+	})
+	return config.SMSModemDaemon
+}
+
 // Intentionally undocumented
 func (config *Config) GetSockDaemon() *sockd.Daemon {
 	config.sockDaemonInit.Do(func() {
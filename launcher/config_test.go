@@ -8,6 +8,7 @@ import (
 	"github.com/HouzuoGuo/laitos/daemon/maintenance"
 	"github.com/HouzuoGuo/laitos/daemon/plainsocket"
 	"github.com/HouzuoGuo/laitos/daemon/serialport"
+	"github.com/HouzuoGuo/laitos/daemon/smsmodem"
 	"github.com/HouzuoGuo/laitos/daemon/smtpd"
 	"github.com/HouzuoGuo/laitos/daemon/smtpd/mailcmd"
 
@@ -25,7 +26,11 @@ var sampleConfigJSON = `
     "IntervalSec": 30,
     "URLAndPassword": {
       "http://example.com/does-not-matter": "password does not matter"
-    }
+    },
+    "URLAndDataFingerprint": {
+      "http://example.com/does-not-matter": "0000000000000000000000000000000000000000000000000000000000000000"
+    },
+    "PrivateKey": "jMdeGTiELDatuamQrmP7o3jr6i5lFsbvin/Ww8B9UIA="
   },
   "DNSDaemon": {
     "Address": "127.0.0.1",
@@ -152,7 +157,8 @@ var sampleConfigJSON = `
     "TwilioSMSEndpoint": "/sms",
     "WebProxyEndpoint": "/proxy",
 		"AppCommandEndpoint": "/cmd",
-		"ReportsRetrievalEndpoint": "/reports"
+		"ReportsRetrievalEndpoint": "/reports",
+		"SockdUsersEndpoint": "/sockd_users"
   },
   "MailClient": {
     "MTAHost": "127.0.0.1",
@@ -341,7 +347,46 @@ var sampleConfigJSON = `
     "UDPPorts": [
       9122,
       24899
-    ]
+    ],
+    "PortCiphers": {
+      "8837": "aes-256-gcm"
+    },
+    "SOCKS5Ports": [
+      6892
+    ],
+    "HTTPConnectPorts": [
+      6893
+    ],
+    "Users": [
+      {
+        "Name": "howard",
+        "Password": "7654321"
+      }
+    ],
+    "Policy": {
+      "Rules": [
+        {
+          "Action": "deny",
+          "Ports": [
+            "25"
+          ]
+        }
+      ]
+    }
+  },
+  "SMSModemDaemon": {
+    "DevicePath": "/dev/ttyUSB0",
+    "PerNumberLimit": 5
+  },
+  "SMSModemFilters": {
+    "LintText": {
+      "CompressToSingleLine": true,
+      "MaxLength": 160,
+      "TrimSpaces": true
+    },
+    "PINAndShortcuts": {
+      "Passwords": ["verysecret"]
+    }
   },
   "SupervisorNotificationRecipients": [
     "howard@localhost"
@@ -411,6 +456,8 @@ func TestConfig(t *testing.T) {
 
 	serialport.TestDaemon(config.GetSerialPortDaemon(), t)
 
+	smsmodem.TestSMSModem(config.GetSMSModemDaemon(), t)
+
 	sockd.TestSockd(config.GetSockDaemon(), t)
 
 	simpleipsvcd.TestSimpleIPSvcD(config.GetSimpleIPSvcD(), t)
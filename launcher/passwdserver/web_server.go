@@ -2,6 +2,9 @@ package passwdserver
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -41,6 +44,16 @@ const (
 		down the password input web server.
 	*/
 	ShutdownTimeout = 10 * time.Second
+	/*
+		ShareCollectionTimeout is the maximum duration to keep the collected shares of the decryption password. If not
+		enough shares have arrived in time, the collected shares are discarded.
+	*/
+	ShareCollectionTimeout = 30 * time.Minute
+	/*
+		MaxShareCollections is the maximum number of share sets (each split from a different password) collected at
+		the same time. When a share of yet another set arrives, the oldest set is discarded to make room.
+	*/
+	MaxShareCollections = 16
 	// CLIFlag is the command line flag that enables this password input web server to launch.
 	CLIFlag = `pwdserver`
 	// PageHTML is the content of HTML page that asks for a password input.
@@ -51,7 +64,7 @@ const (
 <body>
 	<pre>%s</pre>
     <form action="%s" method="post">
-        <p>Enter password to launch main program: <input type="password" name="` + PasswordInputName + `"/></p>
+        <p>Enter password or a share of password to launch main program: <input type="password" name="` + PasswordInputName + `"/></p>
         <p><input type="submit" value="Launch"/></p>
         <p>%s</p>
     </form>
@@ -88,14 +101,33 @@ with daemons using decrypted data.
 type WebServer struct {
 	Port int    // Port is the TCP port to listen on.
 	URL  string // URL is the secretive URL that serves the unlock page. The URL must include leading slash.
+	/*
+		PeerPublicKeys are the base64 Ed25519 public keys of the auto-unlocking peers that are authorised to submit the
+		password via unlock handshake. The handshake is unavailable if there is none.
+	*/
+	PeerPublicKeys []string
+
+	unlockServer *misc.UnlockServer // unlockServer carries out unlock handshakes with auto-unlocking peers.
 
 	server          *http.Server // server is the HTTP server after it is started.
 	handlerMutex    *sync.Mutex  // handlerMutex prevents concurrent unlocking attempts from being made at once.
 	alreadyUnlocked bool         // alreadyUnlocked is set to true after a successful unlocking attempt has been made
 
+	/*
+		shareCollections are the shares of decryption password submitted so far, keyed by share set ID. Visitors are not
+		authenticated, hence the shares of a different set do not interfere with the collection of the genuine set.
+	*/
+	shareCollections map[string]*shareCollection
+
 	logger lalog.Logger
 }
 
+// shareCollection is the distinct shares of the same set collected so far.
+type shareCollection struct {
+	shares map[int]misc.SecretShare // shares are keyed by share index.
+	since  time.Time                // since is the time at which the first of the shares arrived.
+}
+
 /*
 pageHandler serves an HTML page that allows visitor to decrypt a program data archive via a correct password.
 If successful, the web server will stop, and then launches laitos supervisor program along with daemons using
@@ -116,15 +148,41 @@ func (ws *WebServer) pageHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		ws.logger.Info("pageHandler", r.RemoteAddr, nil, "an unlock attempt has been made")
 
-		var err error
-		// Try decrypting program configuration JSON file using the input password
+		if clientNonce := r.FormValue(misc.UnlockChallengeInputName); clientNonce != "" {
+			ws.challengeHandler(w, r, clientNonce)
+			return
+		}
 		key := strings.TrimSpace(r.FormValue(PasswordInputName))
+		if sealedResp := r.FormValue(misc.UnlockResponseInputName); sealedResp != "" {
+			// The password comes from an auto-unlocking peer via unlock handshake
+			var resp misc.UnlockResponse
+			var err error
+			if ws.unlockServer == nil {
+				err = errors.New("unlock handshake is not enabled")
+			} else if err = json.Unmarshal([]byte(sealedResp), &resp); err == nil {
+				key, err = ws.unlockServer.Open(&resp)
+			}
+			if err != nil {
+				ws.logger.Warning("pageHandler", r.RemoteAddr, err, "failed to open the password sealed by auto-unlocking peer")
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if misc.IsSecretShare(key) {
+			// Collect the share, and proceed only when enough shares have arrived to recover the password.
+			var status string
+			if key, status = ws.collectShare(r.RemoteAddr, key); key == "" {
+				_, _ = w.Write([]byte(fmt.Sprintf(PageHTML, GetSysInfoText(), r.RequestURI, status)))
+				return
+			}
+		}
+		// Try decrypting program configuration JSON file using the input password
 		decryptedConfig, err := misc.Decrypt(misc.ConfigFilePath, key)
 		if err != nil {
 			_, _ = w.Write([]byte(fmt.Sprintf(PageHTML, GetSysInfoText(), r.RequestURI, err.Error())))
 			return
 		}
-		if decryptedConfig[0] != '{' {
+		if len(decryptedConfig) == 0 || decryptedConfig[0] != '{' {
 			_, _ = w.Write([]byte(fmt.Sprintf(PageHTML, GetSysInfoText(), r.RequestURI, "wrong key or malformed config file")))
 			return
 		}
@@ -132,7 +190,7 @@ func (ws *WebServer) pageHandler(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(fmt.Sprintf(PageHTML, GetSysInfoText(), r.RequestURI, "success")))
 		ws.alreadyUnlocked = true
 		// A short moment later, the function will launch laitos supervisor along with daemons.
-		go ws.LaunchMainProgram(strings.TrimSpace(r.FormValue("password")))
+		go ws.LaunchMainProgram(key)
 		return
 	default:
 		ws.logger.Info("pageHandler", r.RemoteAddr, nil, "just visiting")
@@ -141,6 +199,90 @@ func (ws *WebServer) pageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// challengeHandler replies to the client nonce of an auto-unlocking peer with a challenge of unlock handshake.
+func (ws *WebServer) challengeHandler(w http.ResponseWriter, r *http.Request, encodedNonce string) {
+	if ws.unlockServer == nil {
+		http.Error(w, "unlock handshake is not enabled", http.StatusForbidden)
+		return
+	}
+	clientNonce, err := base64.StdEncoding.DecodeString(encodedNonce)
+	if err != nil {
+		http.Error(w, "malformed client nonce", http.StatusBadRequest)
+		return
+	}
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	challenge, err := ws.unlockServer.Challenge(clientIP, clientNonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws.logger.Info("challengeHandler", r.RemoteAddr, nil, "an auto-unlocking peer has begun an unlock handshake")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(challenge)
+}
+
+/*
+collectShare memorises a share of the decryption password. Once the threshold number of distinct shares of the same set
+have been collected, the function returns the recovered password and forgets the set. Otherwise, it returns an empty
+password and a status text that tells the progress of share collection. Caller must lock handler mutex.
+*/
+func (ws *WebServer) collectShare(clientID, text string) (password, status string) {
+	share, err := misc.ParseSecretShare(text)
+	if err != nil {
+		return "", err.Error()
+	}
+	if ws.shareCollections == nil {
+		ws.shareCollections = make(map[string]*shareCollection)
+	}
+	var oldestSetID string
+	for setID, collection := range ws.shareCollections {
+		if time.Since(collection.since) > ShareCollectionTimeout {
+			ws.logger.Info("collectShare", clientID, nil, "discarding %d shares of set %s that have been collected for too long", len(collection.shares), setID)
+			delete(ws.shareCollections, setID)
+		} else if oldestSetID == "" || collection.since.Before(ws.shareCollections[oldestSetID].since) {
+			oldestSetID = setID
+		}
+	}
+	collection, exists := ws.shareCollections[share.SetID]
+	if exists {
+		// The share carries the set ID yet its parameters differ, the set cannot be trusted anymore.
+		for _, collected := range collection.shares {
+			if !collected.SameSetAs(share) {
+				ws.logger.Warning("collectShare", clientID, misc.ErrSecretSharesMismatch, "discarding %d shares of set %s and starting over", len(collection.shares), share.SetID)
+				exists = false
+				break
+			}
+		}
+	}
+	if !exists {
+		if len(ws.shareCollections) >= MaxShareCollections && oldestSetID != share.SetID {
+			ws.logger.Info("collectShare", clientID, nil, "discarding shares of the oldest set %s to make room", oldestSetID)
+			delete(ws.shareCollections, oldestSetID)
+		}
+		collection = &shareCollection{shares: make(map[int]misc.SecretShare), since: time.Now()}
+		ws.shareCollections[share.SetID] = collection
+	}
+	collection.shares[share.Index] = share
+	ws.logger.Info("collectShare", clientID, nil, "collected share #%d of set %s, %d of %d shares have arrived", share.Index, share.SetID, len(collection.shares), share.Threshold)
+	if len(collection.shares) < share.Threshold {
+		return "", fmt.Sprintf("received %d of %d shares, waiting for more", len(collection.shares), share.Threshold)
+	}
+	texts := make([]string, 0, len(collection.shares))
+	for _, collected := range collection.shares {
+		texts = append(texts, collected.String())
+	}
+	// Start over with a fresh collection if the recovered password turns out to be wrong
+	delete(ws.shareCollections, share.SetID)
+	secret, err := misc.CombineShares(texts)
+	if err != nil {
+		return "", err.Error()
+	}
+	return string(secret), ""
+}
+
 // Start runs the web server and blocks until the server shuts down from a successful unlocking attempt.
 func (ws *WebServer) Start() error {
 	ws.logger = lalog.Logger{
@@ -148,6 +290,16 @@ func (ws *WebServer) Start() error {
 		ComponentID:   []lalog.LoggerIDField{{Key: "Port", Value: ws.Port}},
 	}
 	ws.handlerMutex = new(sync.Mutex)
+	if len(ws.PeerPublicKeys) > 0 {
+		// Prove the possession of encrypted program data to auto-unlocking peers
+		fingerprint, err := misc.DataFingerprint(misc.ConfigFilePath)
+		if err != nil {
+			return fmt.Errorf("passwdserver.Start: failed to read program data - %v", err)
+		}
+		if ws.unlockServer, err = misc.NewUnlockServer(fingerprint, ws.PeerPublicKeys); err != nil {
+			return fmt.Errorf("passwdserver.Start: %v", err)
+		}
+	}
 	mux := http.NewServeMux()
 	// Visitor must visit the pre-configured URL for a meaningful response
 	mux.HandleFunc(ws.URL, ws.pageHandler)
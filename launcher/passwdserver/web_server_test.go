@@ -2,13 +2,21 @@ package passwdserver
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/HouzuoGuo/laitos/inet"
+	"github.com/HouzuoGuo/laitos/misc"
 )
 
 func TestGetSysInfoText(t *testing.T) {
@@ -60,3 +68,144 @@ func TestWebServer(t *testing.T) {
 		t.Fatal(err, shutdown)
 	}
 }
+
+func TestWebServer_CollectShare(t *testing.T) {
+	ws := WebServer{}
+	shares, err := misc.SplitSecret([]byte("very secret password"), 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, status := ws.collectShare("test", "laitosshare-bad"); password != "" || !strings.Contains(status, "not a share") {
+		t.Fatal(password, status)
+	}
+	// Submitting the same share repeatedly does not count towards the threshold
+	for i := 0; i < 2; i++ {
+		if password, status := ws.collectShare("test", shares[0]); password != "" || status != "received 1 of 3 shares, waiting for more" {
+			t.Fatal(password, status)
+		}
+	}
+	// Shares of another password do not interfere
+	otherShares, err := misc.SplitSecret([]byte("another password"), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, status := ws.collectShare("test", otherShares[0]); password != "" || status != "received 1 of 2 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	if password, status := ws.collectShare("test", shares[3]); password != "" || status != "received 2 of 3 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	// A share that carries the set ID but different parameters discards the set
+	genuine, err := misc.ParseSecretShare(shares[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := misc.SecretShare{Threshold: 2, Index: 9, SetID: genuine.SetID, Data: []byte{1}}
+	if password, status := ws.collectShare("test", forged.String()); password != "" || status != "received 1 of 2 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	if password, status := ws.collectShare("test", shares[3]); password != "" || status != "received 1 of 3 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	// Collected shares expire after a while
+	ws.shareCollections[genuine.SetID].since = time.Now().Add(-ShareCollectionTimeout - time.Second)
+	if password, status := ws.collectShare("test", shares[1]); password != "" || status != "received 1 of 3 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	if password, status := ws.collectShare("test", shares[2]); password != "" || status != "received 2 of 3 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	if password, status := ws.collectShare("test", shares[0]); password != "very secret password" || status != "" {
+		t.Fatal(password, status)
+	}
+	// The collection starts over after the password has been recovered
+	if password, status := ws.collectShare("test", shares[0]); password != "" || status != "received 1 of 3 shares, waiting for more" {
+		t.Fatal(password, status)
+	}
+	// Shares of too many sets push out the oldest set
+	ws.shareCollections[genuine.SetID].since = time.Now().Add(-time.Minute)
+	for i := 0; i < MaxShareCollections; i++ {
+		flood := misc.SecretShare{Threshold: 2, Index: 1, SetID: fmt.Sprintf("%08x", i), Data: []byte{1}}
+		ws.collectShare("test", flood.String())
+	}
+	if _, exists := ws.shareCollections[genuine.SetID]; exists || len(ws.shareCollections) != MaxShareCollections {
+		t.Fatal(len(ws.shareCollections))
+	}
+}
+
+func TestWebServer_UnlockHandshake(t *testing.T) {
+	encryptedFile, err := ioutil.TempFile("", "laitos-TestWebServer_UnlockHandshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(encryptedFile.Name())
+	if err := ioutil.WriteFile(encryptedFile.Name(), []byte(`{"a": "b"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := misc.Encrypt(encryptedFile.Name(), []byte("correct password")); err != nil {
+		t.Fatal(err)
+	}
+	misc.ConfigFilePath = encryptedFile.Name()
+	defer func() {
+		misc.ConfigFilePath = ""
+	}()
+	privateKey, publicKey, err := misc.GenerateUnlockPeerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := misc.ParseUnlockPeerPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := misc.DataFingerprint(encryptedFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := WebServer{handlerMutex: new(sync.Mutex)}
+	if ws.unlockServer, err = misc.NewUnlockServer(fingerprint, []string{publicKey}); err != nil {
+		t.Fatal(err)
+	}
+	post := func(values url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		ws.pageHandler(rec, req)
+		return rec
+	}
+	handshake := func(password string) *httptest.ResponseRecorder {
+		clientNonce, err := misc.NewUnlockClientNonce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := post(url.Values{misc.UnlockChallengeInputName: {base64.StdEncoding.EncodeToString(clientNonce)}})
+		var challenge misc.UnlockChallenge
+		if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+			t.Fatal(err, rec.Body.String())
+		}
+		resp, err := misc.RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, &challenge, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		respJSON, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post(url.Values{misc.UnlockResponseInputName: {string(respJSON)}})
+	}
+	// The handshake delivers the password, which is then used to decrypt data
+	if rec := handshake("wrong password"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), misc.ErrDecryptionFailed.Error()) {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// A malformed client nonce or a bogus response is rejected
+	if rec := post(url.Values{misc.UnlockChallengeInputName: {"AAAA"}}); rec.Code != http.StatusBadRequest {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	if rec := post(url.Values{misc.UnlockResponseInputName: {"{}"}}); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	// Handshake is unavailable without authorised peers
+	ws.unlockServer = nil
+	if rec := post(url.Values{misc.UnlockChallengeInputName: {"AAAA"}}); rec.Code != http.StatusForbidden {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
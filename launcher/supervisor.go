@@ -29,6 +29,7 @@ const (
 	SerialPortDaemonName = "serialport"
 	SimpleIPSvcName      = "simpleipsvcd"
 	SMTPDName            = "smtpd"
+	SMSModemName         = "smsmodem"
 	SNMPDName            = "snmpd"
 	SOCKDName            = "sockd"
 	TelegramName         = "telegram"
@@ -49,7 +50,8 @@ const (
 // AllDaemons is an unsorted list of string daemon names.
 var AllDaemons = []string{
 	AutoUnlockName, DNSDName, HTTPDName, InsecureHTTPDName, MaintenanceName, PhoneHomeName,
-	PlainSocketName, SerialPortDaemonName, SimpleIPSvcName, SMTPDName, SNMPDName, SOCKDName, TelegramName,
+	PlainSocketName, SerialPortDaemonName, SimpleIPSvcName, SMTPDName, SMSModemName, SNMPDName, SOCKDName,
+	TelegramName,
 }
 
 /*
@@ -73,7 +75,7 @@ var ShedOrder = []string{
 	SerialPortDaemonName, SimpleIPSvcName, // 2
 	SNMPDName, DNSDName, // 3
 	SOCKDName, SMTPDName, HTTPDName, // 4
-	InsecureHTTPDName, PlainSocketName, TelegramName, PhoneHomeName, // 5
+	InsecureHTTPDName, PlainSocketName, SMSModemName, TelegramName, PhoneHomeName, // 5
 	// Never shed - AutoUnlockName
 }
 
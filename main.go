@@ -16,9 +16,9 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -35,6 +35,7 @@ import (
 	"github.com/HouzuoGuo/laitos/launcher/passwdserver"
 	"github.com/HouzuoGuo/laitos/misc"
 	"github.com/HouzuoGuo/laitos/platform"
+	"github.com/HouzuoGuo/laitos/toolbox"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/beanstalk"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/ec2"
 	"github.com/aws/aws-xray-sdk-go/awsplugins/ecs"
@@ -63,12 +64,7 @@ func DecryptFile(filePath string) {
 		lalog.DefaultLogger.Abort("DecryptFile", "main", err, "failed to read password")
 		return
 	}
-	content, err := misc.Decrypt(filePath, strings.TrimSpace(string(password)))
-	if err != nil {
-		lalog.DefaultLogger.Abort("DecryptFile", "main", err, "failed to decrypt file")
-		return
-	}
-	if err := ioutil.WriteFile(filePath, content, 0600); err != nil {
+	if err := misc.DecryptInPlace(filePath, strings.TrimSpace(string(password))); err != nil {
 		lalog.DefaultLogger.Abort("DecryptFile", "main", err, "failed to decrypt file")
 		return
 	}
@@ -96,14 +92,142 @@ func EncryptFile(filePath string) {
 	}
 }
 
+/*
+UpgradeFile is a distinct routine of laitos main program, it reads password from standard input and uses it to
+re-encrypt a file of the legacy encryption format in the current format.
+*/
+func UpgradeFile(filePath string) {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Please enter the password that was used to encrypt the file (no echo):")
+	platform.SetTermEcho(false)
+	password, _, err := reader.ReadLine()
+	platform.SetTermEcho(true)
+	if err != nil {
+		lalog.DefaultLogger.Abort("UpgradeFile", "main", err, "failed to read password")
+		return
+	}
+	backupPath, err := misc.UpgradeEncryption(filePath, strings.TrimSpace(string(password)))
+	if err != nil {
+		lalog.DefaultLogger.Abort("UpgradeFile", "main", err, "failed to upgrade file")
+		return
+	}
+	lalog.DefaultLogger.Info("UpgradeFile", "main", nil, "successfully upgraded the file, please delete the original file \"%s\" after verifying that the upgraded file decrypts correctly", backupPath)
+}
+
+/*
+SplitKey is a distinct routine of laitos main program, it reads the password of an encrypted file from standard input,
+and splits the password into shares, any threshold number of which may be submitted to the password input web server
+to unlock the program data.
+*/
+func SplitKey(filePath string, threshold, numShares int) {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Please enter the password that was used to encrypt the file (no echo):")
+	platform.SetTermEcho(false)
+	password, _, err := reader.ReadLine()
+	platform.SetTermEcho(true)
+	if err != nil {
+		lalog.DefaultLogger.Abort("SplitKey", "main", err, "failed to read password")
+		return
+	}
+	password = []byte(strings.TrimSpace(string(password)))
+	// Make sure the file was encrypted by laitos, so that the shares will be useful.
+	if _, err := misc.Decrypt(filePath, string(password)); err != nil {
+		lalog.DefaultLogger.Abort("SplitKey", "main", err, "failed to decrypt file")
+		return
+	}
+	shares, err := misc.SplitSecret(password, threshold, numShares)
+	if err != nil {
+		lalog.DefaultLogger.Abort("SplitKey", "main", err, "failed to split password")
+		return
+	}
+	fmt.Printf("Any %d of the following %d shares unlock the file, give each share to a different operator or autounlock peer:\n", threshold, numShares)
+	for _, share := range shares {
+		fmt.Println(share)
+	}
+}
+
+/*
+PrintDataFingerprint is a distinct routine of laitos main program, it prints the fingerprint of an encrypted file. An
+auto-unlocking peer uses the fingerprint to verify that a password input web server possesses the encrypted file.
+*/
+func PrintDataFingerprint(filePath string) {
+	fingerprint, err := misc.DataFingerprint(filePath)
+	if err != nil {
+		lalog.DefaultLogger.Abort("PrintDataFingerprint", "main", err, "failed to read file")
+		return
+	}
+	fmt.Println("Data fingerprint for autounlock configuration (URLAndDataFingerprint):")
+	fmt.Println(hex.EncodeToString(fingerprint))
+}
+
+/*
+GenerateUnlockPeerKey is a distinct routine of laitos main program, it generates a key pair for an auto-unlocking peer.
+The private key belongs to autounlock configuration, and the public key goes to password input web server.
+*/
+func GenerateUnlockPeerKey() {
+	privateKey, publicKey, err := misc.GenerateUnlockPeerKey()
+	if err != nil {
+		lalog.DefaultLogger.Abort("GenerateUnlockPeerKey", "main", err, "failed to generate key pair")
+		return
+	}
+	fmt.Println("Private key for autounlock configuration (PrivateKey):")
+	fmt.Println(privateKey)
+	fmt.Printf("Public key for password input web server (-%speerkeys):\n", passwdserver.CLIFlag)
+	fmt.Println(publicKey)
+}
+
+/*
+ImportTwoFAAccounts is a distinct routine of laitos main program, it reads two factor authentication accounts from a QR
+code image or a text file of "otpauth://" URIs, and imports them into the encrypted secret file of 2FA code generator
+app. The AES key and IV of the secret file are read from standard input.
+*/
+func ImportTwoFAAccounts(secretFilePath, importFilePath string) {
+	accounts, err := toolbox.ReadOTPAccountsFromFile(importFilePath)
+	if err != nil {
+		lalog.DefaultLogger.Abort("ImportTwoFAAccounts", "main", err, "failed to read accounts")
+		return
+	}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("Please enter the hex-encoded IV of the 2FA secret file:")
+	hexIV, _, err := reader.ReadLine()
+	if err != nil {
+		lalog.DefaultLogger.Abort("ImportTwoFAAccounts", "main", err, "failed to read IV")
+		return
+	}
+	fmt.Println("Please enter the entire hex-encoded key (key prefix and suffix together) of the 2FA secret file (no echo):")
+	platform.SetTermEcho(false)
+	hexKey, _, err := reader.ReadLine()
+	platform.SetTermEcho(true)
+	if err != nil {
+		lalog.DefaultLogger.Abort("ImportTwoFAAccounts", "main", err, "failed to read key")
+		return
+	}
+	secretFile := &toolbox.AESEncryptedFile{
+		FilePath:     secretFilePath,
+		HexIV:        strings.TrimSpace(string(hexIV)),
+		HexKeyPrefix: strings.TrimSpace(string(hexKey)),
+	}
+	if err := secretFile.Initialise(); err != nil {
+		lalog.DefaultLogger.Abort("ImportTwoFAAccounts", "main", err, "failed to read 2FA secret file")
+		return
+	}
+	imported, err := toolbox.ImportTwoFAAccounts(secretFile, []byte{}, accounts)
+	if err != nil {
+		lalog.DefaultLogger.Abort("ImportTwoFAAccounts", "main", err, "failed to import accounts")
+		return
+	}
+	lalog.DefaultLogger.Info("ImportTwoFAAccounts", "main", nil, "imported %d out of %d accounts, the rest were already present", imported, len(accounts))
+}
+
 /*
 StartPasswordWebServer is a distinct routine of laitos main program, it starts a simple web server to accept a password
 input in order to decrypt laitos program data and launch the daemons.
 */
-func StartPasswordWebServer(port int, url string) {
+func StartPasswordWebServer(port int, url string, peerPublicKeys []string) {
 	ws := passwdserver.WebServer{
-		Port: port,
-		URL:  url,
+		Port:           port,
+		URL:            url,
+		PeerPublicKeys: peerPublicKeys,
 	}
 	/*
 		On Amazon ElasitcBeanstalk, application update cannot reliably kill the old program prior to launching the new
@@ -118,7 +242,10 @@ func StartPasswordWebServer(port int, url string) {
 /*
 main runs one of several distinct routines according to the presented combination of command line flags:
 
-- Maintain encrypted program data files: -datautil=encrypt|decrypt
+- Maintain encrypted program data files: -datautil=encrypt|decrypt|upgrade
+  Split the password into shares, any N of M shares unlock the data: -datautil=splitkey -datautilthreshold=N -datautilshares=M
+  Prepare unlock handshake between password input web server and autounlock peers: -datautil=fingerprint|peerkey
+  Import 2FA accounts from a QR code image or otpauth URIs into the encrypted 2FA secret file: -datautil=import2fa -datautilimport=file
 
 - Launch a simple web server to collect program data decryption password, and proceeds to launch laitos with supervisor:
   -pwdserver -pwdserverport=12345 -pwdserverurl=/my-password-input-page
@@ -144,7 +271,7 @@ func main() {
 	var disableConflicts, debug, benchmark, awsLambda bool
 	var gomaxprocs int
 	flag.StringVar(&misc.ConfigFilePath, launcher.ConfigFlagName, "", "(Mandatory) path to configuration file in JSON syntax")
-	flag.StringVar(&daemonList, launcher.DaemonsFlagName, "", "(Mandatory) comma-separated daemons to start (autounlock, dnsd, httpd, insecurehttpd, maintenance, plainsocket, serialport, simpleipsvcd, smtpd, snmpd, sockd, telegram)")
+	flag.StringVar(&daemonList, launcher.DaemonsFlagName, "", "(Mandatory) comma-separated daemons to start (autounlock, dnsd, httpd, insecurehttpd, maintenance, plainsocket, serialport, simpleipsvcd, smsmodem, smtpd, snmpd, sockd, telegram)")
 	flag.BoolVar(&disableConflicts, "disableconflicts", false, "(Optional) automatically stop and disable other daemon programs that may cause port usage conflicts")
 	flag.BoolVar(&awsLambda, launcher.LambdaFlagName, false, "(Optional) run AWS Lambda handler to proxy HTTP requests to laitos web server")
 	flag.BoolVar(&misc.EnableAWSIntegration, "awsinteg", false, "(Optional) activate AWS integration feature if their configuration has been given in environment variable")
@@ -154,14 +281,19 @@ func main() {
 	// Data unlocker (password input server) flags
 	var pwdServer bool
 	var pwdServerPort int
-	var pwdServerURL string
+	var pwdServerURL, pwdServerPeerKeys string
 	flag.BoolVar(&pwdServer, passwdserver.CLIFlag, false, "(Optional) launch web server to accept password for decrypting encrypted program data")
 	flag.IntVar(&pwdServerPort, passwdserver.CLIFlag+"port", 80, "(Optional) port number of the password web server")
 	flag.StringVar(&pwdServerURL, passwdserver.CLIFlag+"url", "", "(Optional) password input URL")
+	flag.StringVar(&pwdServerPeerKeys, passwdserver.CLIFlag+"peerkeys", "", "(Optional) comma-separated public keys of auto-unlocking peers allowed to submit password via unlock handshake")
 	// Data encryption utility flags
-	var dataUtil, dataUtilFile string
-	flag.StringVar(&dataUtil, "datautil", "", "(Optional) program data encryption utility: encrypt|decrypt")
+	var dataUtil, dataUtilFile, dataUtilImport string
+	var dataUtilThreshold, dataUtilShares int
+	flag.StringVar(&dataUtil, "datautil", "", "(Optional) program data encryption utility: encrypt|decrypt|upgrade|splitkey|fingerprint|peerkey|import2fa")
 	flag.StringVar(&dataUtilFile, "datautilfile", "", "(Optional) program data encryption utility: encrypt/decrypt file location")
+	flag.StringVar(&dataUtilImport, "datautilimport", "", "(Optional) program data encryption utility: QR code image or text file of otpauth URIs to import into 2FA secret file")
+	flag.IntVar(&dataUtilThreshold, "datautilthreshold", 2, "(Optional) program data encryption utility: number of password shares required to unlock data")
+	flag.IntVar(&dataUtilShares, "datautilshares", 3, "(Optional) program data encryption utility: number of password shares to split the password into")
 	// Internal supervisor flag
 	var isSupervisor = true
 	flag.BoolVar(&isSupervisor, launcher.SupervisorFlagName, true, "(Internal use only) launch a supervisor process to auto-restart laitos main process in case of crash")
@@ -179,6 +311,10 @@ func main() {
 	// Utility routines - maintain encrypted laitos program data, no need to run any daemon.
 	// ========================================================================
 	if dataUtil != "" {
+		if dataUtil == "peerkey" {
+			GenerateUnlockPeerKey()
+			return
+		}
 		if dataUtilFile == "" {
 			logger.Abort("main", "", nil, "please provide data utility target file in parameter \"-datautilfile\"")
 			return
@@ -188,8 +324,20 @@ func main() {
 			EncryptFile(dataUtilFile)
 		case "decrypt":
 			DecryptFile(dataUtilFile)
+		case "upgrade":
+			UpgradeFile(dataUtilFile)
+		case "splitkey":
+			SplitKey(dataUtilFile, dataUtilThreshold, dataUtilShares)
+		case "fingerprint":
+			PrintDataFingerprint(dataUtilFile)
+		case "import2fa":
+			if dataUtilImport == "" {
+				logger.Abort("main", "", nil, "please provide QR code image or text file of otpauth URIs in parameter \"-datautilimport\"")
+				return
+			}
+			ImportTwoFAAccounts(dataUtilFile, dataUtilImport)
 		default:
-			logger.Abort("main", "", nil, "please provide mode of operation (encrypt|decrypt) for parameter \"-datautil\"")
+			logger.Abort("main", "", nil, "please provide mode of operation (encrypt|decrypt|upgrade|splitkey|fingerprint|peerkey|import2fa) for parameter \"-datautil\"")
 		}
 		return
 	}
@@ -210,7 +358,13 @@ func main() {
 	// Password input web server - start the web server to accept password input for decrypting program data.
 	// ========================================================================
 	if pwdServer {
-		StartPasswordWebServer(pwdServerPort, pwdServerURL)
+		var peerPublicKeys []string
+		for _, key := range strings.Split(pwdServerPeerKeys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				peerPublicKeys = append(peerPublicKeys, key)
+			}
+		}
+		StartPasswordWebServer(pwdServerPort, pwdServerURL, peerPublicKeys)
 		return
 	}
 	/*
@@ -364,6 +518,8 @@ func main() {
 			go AutoRestart(logger, daemonName, config.GetSNMPD().StartAndBlock)
 		case launcher.SOCKDName:
 			go AutoRestart(logger, daemonName, config.GetSockDaemon().StartAndBlock)
+		case launcher.SMSModemName:
+			go AutoRestart(logger, daemonName, config.GetSMSModemDaemon().StartAndBlock)
 		case launcher.TelegramName:
 			go AutoRestart(logger, daemonName, config.GetTelegramBot().StartAndBlock)
 		case launcher.AutoUnlockName:
@@ -0,0 +1,409 @@
+package misc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	/*
+		EncryptionFormatMagic follows EncryptionFileHeader in files encrypted in the current format. Files of the legacy
+		format have random IV in its place instead.
+	*/
+	EncryptionFormatMagic = "\x00laitos2"
+	// EncryptionChunkSize is the size of each chunk of plain text that is individually encrypted and authenticated.
+	EncryptionChunkSize = 64 * 1024
+
+	// EncryptionFormatNone, EncryptionFormatLegacy, and EncryptionFormatCurrent are the formats told by EncryptionFormat.
+	EncryptionFormatNone    = 0
+	EncryptionFormatLegacy  = 1
+	EncryptionFormatCurrent = 2
+
+	// The parameters of Argon2id key derivation, they are recorded in each encrypted file.
+	encryptionKDFTime    = 3
+	encryptionKDFMemory  = 64 * 1024 // in KiB
+	encryptionKDFThreads = 4
+	/*
+		The upper limits of parameters accepted from an encrypted file, they prevent a malicious file from exhausting
+		resources. They are small multiples of the parameters used by encryption.
+	*/
+	encryptionKDFMaxTime    = 4 * encryptionKDFTime
+	encryptionKDFMaxMemory  = 4 * encryptionKDFMemory // in KiB
+	encryptionKDFMaxThreads = 4 * encryptionKDFThreads
+	encryptionMaxChunkSize  = 16 * 1024 * 1024
+
+	encryptionSaltSize        = 16
+	encryptionNoncePrefixSize = 7 // the nonce prefix is followed by 4 bytes of chunk counter and 1 byte of last-chunk flag
+	encryptionLegacyKeySize   = 32
+)
+
+var (
+	// ErrDecryptionFailed is returned when the encrypted data fails authentication, due to wrong key or data corruption.
+	ErrDecryptionFailed = errors.New("wrong key or corrupted data")
+	// ErrNotEncrypted is returned when the data to decrypt was not encrypted by laitos.
+	ErrNotEncrypted = errors.New("data does not appear to have been encrypted by laitos")
+)
+
+// encryptionParameters are recorded in the header of an encrypted file of the current format.
+type encryptionParameters struct {
+	KDFTime     uint32
+	KDFMemory   uint32
+	KDFThreads  uint8
+	Salt        [encryptionSaltSize]byte
+	ChunkSize   uint32
+	NoncePrefix [encryptionNoncePrefixSize]byte
+}
+
+// newAEAD derives the encryption key from password and returns the AES-GCM cipher.
+func (params *encryptionParameters) newAEAD(password []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(password, params.Salt[:], params.KDFTime, params.KDFMemory, params.KDFThreads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the chunk at the counter.
+func (params *encryptionParameters) nonce(counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, encryptionNoncePrefixSize+5)
+	nonce = append(nonce, params.NoncePrefix[:]...)
+	nonce = append(nonce, byte(counter>>24), byte(counter>>16), byte(counter>>8), byte(counter))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+/*
+EncryptStream encrypts data from the reader and writes the encrypted data to the writer. The encryption key is derived
+from the password by Argon2id, data is encrypted in chunks by AES-GCM, and the header of encrypted data is authenticated
+along with each chunk. Reordering, truncation, and modification of the chunks are detected upon decryption.
+*/
+func EncryptStream(dst io.Writer, src io.Reader, password []byte) error {
+	params := encryptionParameters{
+		KDFTime:    encryptionKDFTime,
+		KDFMemory:  encryptionKDFMemory,
+		KDFThreads: encryptionKDFThreads,
+		ChunkSize:  EncryptionChunkSize,
+	}
+	if _, err := rand.Read(params.Salt[:]); err != nil {
+		return fmt.Errorf("EncryptStream: failed to acquire random numbers - %v", err)
+	}
+	if _, err := rand.Read(params.NoncePrefix[:]); err != nil {
+		return fmt.Errorf("EncryptStream: failed to acquire random numbers - %v", err)
+	}
+	header := new(bytes.Buffer)
+	header.WriteString(EncryptionFileHeader)
+	header.WriteString(EncryptionFormatMagic)
+	if err := binary.Write(header, binary.BigEndian, params); err != nil {
+		return err
+	}
+	aead, err := params.newAEAD(password)
+	if err != nil {
+		return fmt.Errorf("EncryptStream: failed to initialise cipher - %v", err)
+	}
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(src, int(params.ChunkSize))
+	chunk := make([]byte, params.ChunkSize)
+	sealed := make([]byte, 0, int(params.ChunkSize)+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// The chunk is the last one if there is no more data to follow
+		last := err != nil
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return peekErr
+			}
+		}
+		sealed = aead.Seal(sealed[:0], params.nonce(counter, last), chunk[:n], header.Bytes())
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == math.MaxUint32 {
+			return errors.New("EncryptStream: input data is too large")
+		}
+	}
+}
+
+/*
+DecryptStream decrypts data from the reader and writes the decrypted data to the writer. Both the current and legacy
+formats are understood. Data of the current format is written only after it has been authenticated, though the writer
+may have received a portion of the data by the time an authentication error occurs.
+Data of the legacy format cannot be authenticated, hence a wrong password leads to garbage output rather than an error.
+*/
+func DecryptStream(dst io.Writer, src io.Reader, password []byte) error {
+	reader := bufio.NewReaderSize(src, EncryptionChunkSize)
+	header := make([]byte, len(EncryptionFileHeader)+len(EncryptionFormatMagic))
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(EncryptionFileHeader)]) != EncryptionFileHeader {
+		return ErrNotEncrypted
+	}
+	if string(header[len(EncryptionFileHeader):]) != EncryptionFormatMagic {
+		return decryptLegacyStream(dst, io.MultiReader(bytes.NewReader(header[len(EncryptionFileHeader):]), reader), password)
+	}
+	var params encryptionParameters
+	if err := binary.Read(reader, binary.BigEndian, &params); err != nil {
+		return ErrNotEncrypted
+	}
+	if params.KDFTime < 1 || params.KDFTime > encryptionKDFMaxTime || params.KDFMemory < 8 || params.KDFMemory > encryptionKDFMaxMemory ||
+		params.KDFThreads < 1 || params.KDFThreads > encryptionKDFMaxThreads || params.ChunkSize < 1 || params.ChunkSize > encryptionMaxChunkSize {
+		return ErrDecryptionFailed
+	}
+	paramsBuf := new(bytes.Buffer)
+	if err := binary.Write(paramsBuf, binary.BigEndian, params); err != nil {
+		return err
+	}
+	header = append(header, paramsBuf.Bytes()...)
+	aead, err := params.newAEAD(password)
+	if err != nil {
+		return fmt.Errorf("DecryptStream: failed to initialise cipher - %v", err)
+	}
+	sealed := make([]byte, int(params.ChunkSize)+aead.Overhead())
+	chunk := make([]byte, 0, params.ChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return peekErr
+			}
+		}
+		// A truncated stream fails authentication because its final chunk was not sealed as the last
+		if chunk, err = aead.Open(chunk[:0], params.nonce(counter, last), sealed[:n], header); err != nil {
+			return ErrDecryptionFailed
+		}
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == math.MaxUint32 {
+			return ErrDecryptionFailed
+		}
+	}
+}
+
+// decryptLegacyStream decrypts data of the legacy format (AES-CTR using zero-padded password as key) that follows the header.
+func decryptLegacyStream(dst io.Writer, src io.Reader, password []byte) error {
+	iv := make([]byte, EncryptionIVSizeBytes)
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return ErrNotEncrypted
+	}
+	key := append([]byte{}, password...)
+	if len(key) < encryptionLegacyKeySize {
+		key = append(key, bytes.Repeat([]byte{0}, encryptionLegacyKeySize-len(key))...)
+	}
+	keyCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to initialise cipher - %v", err)
+	}
+	_, err = io.Copy(dst, &cipher.StreamReader{S: cipher.NewCTR(keyCipher, iv), R: src})
+	return err
+}
+
+// EncryptionFormat returns the format of the file - EncryptionFormatNone, EncryptionFormatLegacy, or EncryptionFormatCurrent.
+func EncryptionFormat(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return EncryptionFormatNone, err
+	}
+	defer file.Close()
+	header := make([]byte, len(EncryptionFileHeader)+len(EncryptionFormatMagic))
+	if n, err := io.ReadFull(file, header); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return EncryptionFormatNone, err
+	} else if n <= len(EncryptionFileHeader) || string(header[:len(EncryptionFileHeader)]) != EncryptionFileHeader {
+		return EncryptionFormatNone, nil
+	} else if n == len(header) && string(header[len(EncryptionFileHeader):]) == EncryptionFormatMagic {
+		return EncryptionFormatCurrent, nil
+	}
+	return EncryptionFormatLegacy, nil
+}
+
+/*
+replaceFile writes the content produced by the function into a temporary file in the same directory, and then renames
+the temporary file to the destination path. The destination file is left intact if the function fails.
+*/
+func replaceFile(filePath string, produce func(io.Writer) error) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".laitos-tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+	writer := bufio.NewWriter(tmpFile)
+	if err := produce(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filePath)
+}
+
+/*
+Encrypt encrypts the input file in-place using the current format. Data is processed in a stream, hence the operation
+is suitable for files of any size.
+*/
+func Encrypt(filePath string, key []byte) error {
+	format, err := EncryptionFormat(filePath)
+	if err != nil {
+		return err
+	}
+	// Make sure input file is not already encrypted
+	if format != EncryptionFormatNone {
+		return fmt.Errorf("Encrypt: input file \"%s\" is already encrypted", filePath)
+	}
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return replaceFile(filePath, func(dst io.Writer) error {
+		return EncryptStream(dst, src, key)
+	})
+}
+
+/*
+Decrypt decrypts the input file and returns its content. The entire operation is conducted in memory. A wrong key
+results in ErrDecryptionFailed, unless the file is of the legacy format, which cannot tell a wrong key.
+*/
+func Decrypt(filePath string, key string) (content []byte, err error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	buf := new(bytes.Buffer)
+	if err := DecryptStream(buf, src, []byte(key)); err == ErrNotEncrypted {
+		return nil, fmt.Errorf("Decrypt: input file \"%s\" does not appear to have been encrypted by laitos", filePath)
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+DecryptInPlace decrypts the input file in-place. Data is processed in a stream, hence the operation is suitable for files
+of any size. The input file is left intact if decryption fails.
+*/
+func DecryptInPlace(filePath string, key string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return replaceFile(filePath, func(dst io.Writer) error {
+		return DecryptStream(dst, src, []byte(key))
+	})
+}
+
+/*
+UpgradeEncryption re-encrypts a file of the legacy format in the current format. Because the legacy format cannot tell a
+wrong key, the original file is copied to the returned backup path, which should be deleted after the upgraded file has
+been verified to decrypt correctly. An existing backup is never overwritten, instead the backup path gets a number suffix.
+*/
+func UpgradeEncryption(filePath string, key string) (backupPath string, err error) {
+	format, err := EncryptionFormat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if format != EncryptionFormatLegacy {
+		return "", fmt.Errorf("UpgradeEncryption: input file \"%s\" is not encrypted in the legacy format", filePath)
+	}
+	if backupPath, err = backUpFile(filePath, filePath+".legacy-encrypted"); err != nil {
+		return "", fmt.Errorf("UpgradeEncryption: failed to back up the original file - %v", err)
+	}
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	err = replaceFile(filePath, func(dst io.Writer) error {
+		plainReader, plainWriter := io.Pipe()
+		go func() {
+			_ = plainWriter.CloseWithError(DecryptStream(plainWriter, src, []byte(key)))
+		}()
+		defer plainReader.Close()
+		return EncryptStream(dst, plainReader, []byte(key))
+	})
+	if err != nil {
+		_ = os.Remove(backupPath)
+		return "", err
+	}
+	return backupPath, nil
+}
+
+/*
+backUpFile copies the file to the backup path, or to the backup path followed by a number suffix should the backup path
+already exist. It returns the path of the backup copy.
+*/
+func backUpFile(filePath, backupPath string) (string, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	var dst *os.File
+	for i := 0; ; i++ {
+		candidate := backupPath
+		if i > 0 {
+			candidate = fmt.Sprintf("%s.%d", backupPath, i)
+		}
+		dst, err = os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			backupPath = candidate
+			break
+		} else if !os.IsExist(err) || i >= 100 {
+			return "", err
+		}
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(backupPath)
+		return "", err
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(backupPath)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(backupPath)
+		return "", err
+	}
+	return backupPath, nil
+}
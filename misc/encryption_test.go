@@ -0,0 +1,158 @@
+package misc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// encryptLegacy encrypts the content in the legacy format.
+func encryptLegacy(t *testing.T, content []byte, key string) []byte {
+	iv := bytes.Repeat([]byte{1}, EncryptionIVSizeBytes)
+	keyCipher, err := aes.NewCipher(append([]byte(key), bytes.Repeat([]byte{0}, 32-len(key))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, len(content))
+	cipher.NewCTR(keyCipher, iv).XORKeyStream(encrypted, content)
+	return append(append([]byte(EncryptionFileHeader), iv...), encrypted...)
+}
+
+func TestEncryptStream(t *testing.T) {
+	password := []byte("this is a key")
+	for _, size := range []int{0, 1, EncryptionChunkSize - 1, EncryptionChunkSize, 3*EncryptionChunkSize + 5} {
+		content := bytes.Repeat([]byte{'a'}, size)
+		encrypted := new(bytes.Buffer)
+		if err := EncryptStream(encrypted, bytes.NewReader(content), password); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(encrypted.Bytes(), []byte(EncryptionFileHeader+EncryptionFormatMagic)) || bytes.Contains(encrypted.Bytes(), []byte("aaaa")) {
+			t.Fatal(size)
+		}
+		decrypted := new(bytes.Buffer)
+		if err := DecryptStream(decrypted, bytes.NewReader(encrypted.Bytes()), password); err != nil || !bytes.Equal(decrypted.Bytes(), content) {
+			t.Fatal(size, err, decrypted.Len())
+		}
+		// Wrong password
+		if err := DecryptStream(ioutil.Discard, bytes.NewReader(encrypted.Bytes()), []byte("wrong key")); err != ErrDecryptionFailed {
+			t.Fatal(size, err)
+		}
+		// Modification of header or data
+		for _, pos := range []int{len(EncryptionFileHeader) + len(EncryptionFormatMagic) + 20, encrypted.Len() - 1} {
+			tampered := append([]byte{}, encrypted.Bytes()...)
+			tampered[pos]++
+			if err := DecryptStream(ioutil.Discard, bytes.NewReader(tampered), password); err != ErrDecryptionFailed {
+				t.Fatal(size, pos, err)
+			}
+		}
+		// Truncation
+		if size > EncryptionChunkSize {
+			truncated := encrypted.Bytes()[:encrypted.Len()-21]
+			if err := DecryptStream(ioutil.Discard, bytes.NewReader(truncated), password); err != ErrDecryptionFailed {
+				t.Fatal(size, err)
+			}
+		}
+	}
+	// Excessive key derivation parameters are rejected before deriving the key
+	encrypted := new(bytes.Buffer)
+	if err := EncryptStream(encrypted, strings.NewReader("content"), password); err != nil {
+		t.Fatal(err)
+	}
+	paramsOffset := len(EncryptionFileHeader) + len(EncryptionFormatMagic)
+	for _, tamper := range []func([]byte){
+		func(b []byte) { binary.BigEndian.PutUint32(b[paramsOffset:], encryptionKDFMaxTime+1) },
+		func(b []byte) { binary.BigEndian.PutUint32(b[paramsOffset+4:], encryptionKDFMaxMemory+1) },
+		func(b []byte) { b[paramsOffset+8] = encryptionKDFMaxThreads + 1 },
+	} {
+		tampered := append([]byte{}, encrypted.Bytes()...)
+		tamper(tampered)
+		if err := DecryptStream(ioutil.Discard, bytes.NewReader(tampered), password); err != ErrDecryptionFailed {
+			t.Fatal(err)
+		}
+	}
+	if err := DecryptStream(ioutil.Discard, strings.NewReader("not encrypted"), password); err != ErrNotEncrypted {
+		t.Fatal(err)
+	}
+	// Legacy format
+	legacy := encryptLegacy(t, []byte("legacy content"), "this is a key")
+	decrypted := new(bytes.Buffer)
+	if err := DecryptStream(decrypted, bytes.NewReader(legacy), password); err != nil || decrypted.String() != "legacy content" {
+		t.Fatal(err, decrypted.String())
+	}
+}
+
+func TestEncryptionFormatAndUpgrade(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "laitos-TestEncryptionFormatAndUpgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := ioutil.WriteFile(tmp.Name(), encryptLegacy(t, []byte("legacy content"), "this is a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := EncryptionFormat(tmp.Name()); err != nil || format != EncryptionFormatLegacy {
+		t.Fatal(format, err)
+	}
+	if content, err := Decrypt(tmp.Name(), "this is a key"); err != nil || string(content) != "legacy content" {
+		t.Fatal(err, string(content))
+	}
+	// Upgrade the legacy file and keep a backup
+	backupPath, err := UpgradeEncryption(tmp.Name(), "this is a key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(backupPath)
+	if format, err := EncryptionFormat(backupPath); err != nil || format != EncryptionFormatLegacy {
+		t.Fatal(format, err)
+	}
+	if format, err := EncryptionFormat(tmp.Name()); err != nil || format != EncryptionFormatCurrent {
+		t.Fatal(format, err)
+	}
+	if content, err := Decrypt(tmp.Name(), "this is a key"); err != nil || string(content) != "legacy content" {
+		t.Fatal(err, string(content))
+	}
+	// An existing backup is not overwritten
+	if err := ioutil.WriteFile(tmp.Name(), encryptLegacy(t, []byte("legacy content 2"), "this is a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backupPath2, err := UpgradeEncryption(tmp.Name(), "this is a key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(backupPath2)
+	if backupPath2 != backupPath+".1" {
+		t.Fatal(backupPath2)
+	}
+	if content, err := Decrypt(backupPath, "this is a key"); err != nil || string(content) != "legacy content" {
+		t.Fatal(err, string(content))
+	}
+	if content, err := Decrypt(tmp.Name(), "this is a key"); err != nil || string(content) != "legacy content 2" {
+		t.Fatal(err, string(content))
+	}
+	// The current format does not need an upgrade
+	if _, err := UpgradeEncryption(tmp.Name(), "this is a key"); err == nil {
+		t.Fatal("did not error")
+	}
+	// A wrong key leaves the file intact
+	if err := DecryptInPlace(tmp.Name(), "wrong key"); err != ErrDecryptionFailed {
+		t.Fatal(err)
+	}
+	if err := DecryptInPlace(tmp.Name(), "this is a key"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(tmp.Name()); err != nil || string(content) != "legacy content 2" {
+		t.Fatal(err, string(content))
+	}
+	if format, err := EncryptionFormat(tmp.Name()); err != nil || format != EncryptionFormatNone {
+		t.Fatal(format, err)
+	}
+	// Decrypting a file that is not encrypted should fail
+	if err := DecryptInPlace(tmp.Name(), "this is a key"); err != ErrNotEncrypted {
+		t.Fatal(err)
+	}
+}
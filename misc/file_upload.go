@@ -0,0 +1,119 @@
+package misc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+const (
+	// FileUploadMaxSizeBytes is the approximate maximum size of file acceptable for upload (~64MB).
+	FileUploadMaxSizeBytes = 64 * 1024 * 1024
+	// FileUploadCleanUpIntervalSec is the interval at which uploaded files are gone through one by one and outdated ones are deleted
+	FileUploadCleanUpIntervalSec = 180
+	// FileUploadExpireInSec is the expiration of uploaded files measured in seconds.
+	FileUploadExpireInSec = 24 * 3600
+)
+
+// FileUploadStorage is the parent directory in which uploaded files are temporarily stored.
+var FileUploadStorage = filepath.Join(os.TempDir(), "laitos-HandleFileUpload")
+
+// ErrFileUploadTooLarge is returned when the size of an uploaded file exceeds FileUploadMaxSizeBytes.
+var ErrFileUploadTooLarge = fmt.Errorf("file size exceeds the maximum of %d bytes", FileUploadMaxSizeBytes)
+
+// fileUploadCleanUpStartOnce ensures that a background routine that removes expired files periodically is started exactly once.
+var fileUploadCleanUpStartOnce = new(sync.Once)
+
+// fileUploadLogger is used by the file upload storage to log the storage and clean-up of uploaded files.
+var fileUploadLogger = lalog.Logger{ComponentName: "FileUploadStorage", ComponentID: []lalog.LoggerIDField{{Key: "Dir", Value: FileUploadStorage}}}
+
+// periodicallyDeleteExpiredFiles deletes expired files at regular interval. This function never returns.
+func periodicallyDeleteExpiredFiles() {
+	for {
+		time.Sleep(FileUploadCleanUpIntervalSec * time.Second)
+		files, err := ioutil.ReadDir(FileUploadStorage)
+		if err != nil {
+			fileUploadLogger.Warning("periodicallyDeleteExpiredFiles", "", err, "failed to read file upload directory")
+			continue
+		}
+		var anyFileExpired bool
+		for _, fileInfo := range files {
+			if fileInfo.ModTime().Before(time.Now().Add(-(FileUploadExpireInSec * time.Second))) {
+				anyFileExpired = true
+				fileUploadLogger.Info("periodicallyDeleteExpiredFiles", "", os.Remove(filepath.Join(FileUploadStorage, fileInfo.Name())), "delete expired file")
+			}
+		}
+		if !anyFileExpired {
+			fileUploadLogger.Info("periodicallyDeleteExpiredFiles", "", nil, "did not find an expired file")
+		}
+	}
+}
+
+// StartFileUploadCleanUp starts a background routine that periodically deletes expired files. Repeated calls have no effect.
+func StartFileUploadCleanUp() {
+	fileUploadCleanUpStartOnce.Do(func() {
+		go periodicallyDeleteExpiredFiles()
+	})
+}
+
+/*
+StoreUploadedFile copies content of an uploaded file into the file upload storage under a random name that preserves the
+original name's extension. It returns the random file name, under which the file may be retrieved within 24 hours.
+*/
+func StoreUploadedFile(originalName string, content io.Reader) (string, error) {
+	StartFileUploadCleanUp()
+	// Generate a random file name
+	randName := make([]byte, 5)
+	if _, err := rand.Read(randName); err != nil {
+		return "", fmt.Errorf("StoreUploadedFile: failed to generate random file name - %v", err)
+	}
+	// Generate a temporary file that preserves extension name of the original
+	tmpFileName := hex.EncodeToString(randName) + filepath.Ext(originalName)
+	if strings.ContainsAny(tmpFileName, `/\`) {
+		tmpFileName = hex.EncodeToString(randName)
+	}
+	if err := os.MkdirAll(FileUploadStorage, 0700); err != nil {
+		return "", fmt.Errorf("StoreUploadedFile: failed to create storage directory - %v", err)
+	}
+	tmpFile, err := os.OpenFile(filepath.Join(FileUploadStorage, tmpFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("StoreUploadedFile: failed to create file - %v", err)
+	}
+	defer tmpFile.Close()
+	// Copy the uploaded file while observing the size limit
+	written, err := io.Copy(tmpFile, io.LimitReader(content, FileUploadMaxSizeBytes+1))
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("StoreUploadedFile: failed to copy file content - %v", err)
+	}
+	if written > FileUploadMaxSizeBytes {
+		_ = os.Remove(tmpFile.Name())
+		return "", ErrFileUploadTooLarge
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return "", fmt.Errorf("StoreUploadedFile: failed to save file - %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", fmt.Errorf("StoreUploadedFile: failed to close file - %v", err)
+	}
+	return tmpFileName, nil
+}
+
+// GetUploadedFilePath returns the path to an uploaded file that has been stored under the input name.
+func GetUploadedFilePath(name string) (string, error) {
+	// Remember to prevent traversal attack
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", errors.New("GetUploadedFilePath: invalid file name")
+	}
+	return filepath.Join(FileUploadStorage, name), nil
+}
@@ -15,6 +15,7 @@ var (
 	SerialDevicesStats  = NewStats()
 	SimpleIPStatsTCP    = NewStats()
 	SimpleIPStatsUDP    = NewStats()
+	SMSModemStats       = NewStats()
 	SMTPDStats          = NewStats()
 	SNMPStats           = NewStats()
 	SOCKDStatsTCP       = NewStats()
@@ -36,6 +37,7 @@ HTTP/S server             %s
 Plain text server TCP|UDP %s | %s
 Serial port devices       %s
 Simple IP servers         %s | %s
+SMS modem commands:       %s
 SMTP server:              %s
 SNMP server:              %s
 Sock server TCP|UDP:      %s | %s
@@ -49,6 +51,7 @@ Mail to deliver:          %d KiloBytes
 		PlainSocketStatsTCP.Format(factor, numDecimals), PlainSocketStatsUDP.Format(factor, numDecimals),
 		SerialDevicesStats.Format(factor, numDecimals),
 		SimpleIPStatsTCP.Format(factor, numDecimals), SimpleIPStatsUDP.Format(factor, numDecimals),
+		SMSModemStats.Format(factor, numDecimals),
 		SMTPDStats.Format(factor, numDecimals),
 		SNMPStats.Format(factor, numDecimals),
 		SOCKDStatsTCP.Format(factor, numDecimals), SOCKDStatsUDP.Format(factor, numDecimals),
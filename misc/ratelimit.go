@@ -71,3 +71,16 @@ func (limit *RateLimit) Add(actor string, logIfLimitHit bool) bool {
 	limit.counterMutex.Unlock()
 	return true
 }
+
+/*
+Exceeded returns true if the actor has already reached the max count in the current time interval. Unlike Add, it does
+not increase the actor's counter.
+*/
+func (limit *RateLimit) Exceeded(actor string) bool {
+	limit.counterMutex.Lock()
+	defer limit.counterMutex.Unlock()
+	if time.Now().Unix()-limit.lastTimestamp >= limit.UnitSecs {
+		return false
+	}
+	return limit.counter[actor] >= limit.MaxCount
+}
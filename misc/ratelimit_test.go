@@ -66,3 +66,23 @@ func TestRateLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimit_Exceeded(t *testing.T) {
+	limit := RateLimit{UnitSecs: 10, MaxCount: 2}
+	limit.Initialise()
+	for i := 0; i < 2; i++ {
+		if limit.Exceeded("a") {
+			t.Fatal(i)
+		}
+		if !limit.Add("a", true) {
+			t.Fatal(i)
+		}
+	}
+	if !limit.Exceeded("a") || limit.Exceeded("b") {
+		t.Fatal("wrong result")
+	}
+	// Exceeded does not count as a hit
+	if limit.Exceeded("b") || !limit.Add("b", true) || !limit.Add("b", true) {
+		t.Fatal("wrong result")
+	}
+}
@@ -0,0 +1,199 @@
+package misc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SecretSharePrefix is the text prepended to each share of a secret, it tells a share apart from an ordinary password.
+	SecretSharePrefix = "laitosshare"
+	// MaxSecretShares is the maximum number of shares a secret may be split into.
+	MaxSecretShares = 255
+	// SecretShareSetIDLen is the number of random bytes that identify the shares split from the same secret.
+	SecretShareSetIDLen = 4
+)
+
+var (
+	// ErrSecretSharesInsufficient is returned when the number of shares is fewer than the threshold to recover the secret.
+	ErrSecretSharesInsufficient = errors.New("not enough shares to recover the secret")
+	// ErrSecretSharesMismatch is returned when the shares to be combined do not belong to the same secret.
+	ErrSecretSharesMismatch = errors.New("the shares do not belong to the same secret")
+
+	// gf256Exp and gf256Log are the exponent and logarithm tables of GF(2^8) with generator 3.
+	gf256Exp [510]byte
+	gf256Log [256]byte
+)
+
+func init() {
+	// Use the same reduction polynomial (x^8 + x^4 + x^3 + x + 1) as AES
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gf256Exp[i] = x
+		gf256Exp[i+255] = x
+		gf256Log[x] = byte(i)
+		// Multiply x by the generator 3
+		product := x << 1
+		if x&0x80 != 0 {
+			product ^= 0x1b
+		}
+		x ^= product
+	}
+}
+
+// gf256Mul returns the product of a and b in GF(2^8).
+func gf256Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+int(gf256Log[b])]
+}
+
+// gf256Div returns the quotient of a divided by b in GF(2^8), b must not be 0.
+func gf256Div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+255-int(gf256Log[b])]
+}
+
+/*
+SecretShare is a single share of a secret split by Shamir's secret sharing scheme. Any Threshold number of shares of
+the same secret recover the secret, whereas fewer shares reveal nothing about it.
+*/
+type SecretShare struct {
+	Threshold int    // Threshold is the number of shares required to recover the secret.
+	Index     int    // Index is the x coordinate (1-255) of the share.
+	SetID     string // SetID is the random hex string shared by all shares split from the same secret.
+	Data      []byte // Data is the y coordinate of the share, one byte for each byte of the secret.
+}
+
+// String returns the share in text form "laitosshare-Threshold-Index-SetID-DataHex-Checksum".
+func (share SecretShare) String() string {
+	body := fmt.Sprintf("%s-%d-%d-%s-%s", SecretSharePrefix, share.Threshold, share.Index, share.SetID, hex.EncodeToString(share.Data))
+	return fmt.Sprintf("%s-%08x", body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+// SameSetAs returns true only if both shares carry the same set ID and parameters, i.e. they are split from the same secret.
+func (share SecretShare) SameSetAs(other SecretShare) bool {
+	return share.SetID == other.SetID && share.Threshold == other.Threshold && len(share.Data) == len(other.Data)
+}
+
+// IsSecretShare returns true only if the text appears to be a share of a secret rather than an ordinary password.
+func IsSecretShare(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), SecretSharePrefix+"-")
+}
+
+// ParseSecretShare decodes a share from its text form and verifies its checksum.
+func ParseSecretShare(text string) (share SecretShare, err error) {
+	fields := strings.Split(strings.TrimSpace(text), "-")
+	if len(fields) != 6 || fields[0] != SecretSharePrefix {
+		return share, errors.New("ParseSecretShare: the text is not a share of secret")
+	}
+	body := strings.Join(fields[:5], "-")
+	if checksum := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body))); checksum != strings.ToLower(fields[5]) {
+		return share, errors.New("ParseSecretShare: checksum mismatch, the share may contain a typo")
+	}
+	if share.Threshold, err = strconv.Atoi(fields[1]); err != nil || share.Threshold < 2 || share.Threshold > MaxSecretShares {
+		return share, fmt.Errorf("ParseSecretShare: malformed threshold \"%s\"", fields[1])
+	}
+	if share.Index, err = strconv.Atoi(fields[2]); err != nil || share.Index < 1 || share.Index > MaxSecretShares {
+		return share, fmt.Errorf("ParseSecretShare: malformed index \"%s\"", fields[2])
+	}
+	if setID, err := hex.DecodeString(fields[3]); err != nil || len(setID) != SecretShareSetIDLen {
+		return share, fmt.Errorf("ParseSecretShare: malformed set ID \"%s\"", fields[3])
+	}
+	share.SetID = strings.ToLower(fields[3])
+	if share.Data, err = hex.DecodeString(fields[4]); err != nil || len(share.Data) == 0 {
+		return share, fmt.Errorf("ParseSecretShare: malformed data - %v", err)
+	}
+	return share, nil
+}
+
+/*
+SplitSecret splits the secret into the number of shares, any threshold number of which recover the secret. The shares
+are returned in their text form.
+*/
+func SplitSecret(secret []byte, threshold, numShares int) ([]string, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("SplitSecret: secret must not be empty")
+	}
+	if threshold < 2 || numShares < threshold || numShares > MaxSecretShares {
+		return nil, fmt.Errorf("SplitSecret: threshold must be at least 2, and number of shares must be between threshold and %d", MaxSecretShares)
+	}
+	// The random set ID tells the shares of this secret apart from those of other secrets
+	setID := make([]byte, SecretShareSetIDLen)
+	if _, err := rand.Read(setID); err != nil {
+		return nil, fmt.Errorf("SplitSecret: failed to acquire random numbers - %v", err)
+	}
+	shares := make([]SecretShare, numShares)
+	for i := range shares {
+		shares[i] = SecretShare{Threshold: threshold, Index: i + 1, SetID: hex.EncodeToString(setID), Data: make([]byte, len(secret))}
+	}
+	// Each byte of the secret is the constant term of a random polynomial of degree threshold-1
+	coefficients := make([]byte, threshold)
+	for byteIndex, secretByte := range secret {
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("SplitSecret: failed to acquire random numbers - %v", err)
+		}
+		for i := range shares {
+			// Evaluate the polynomial at x = index using Horner's method
+			x := byte(shares[i].Index)
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gf256Mul(y, x) ^ coefficients[c]
+			}
+			shares[i].Data[byteIndex] = y
+		}
+	}
+	ret := make([]string, numShares)
+	for i, share := range shares {
+		ret[i] = share.String()
+	}
+	return ret, nil
+}
+
+// CombineShares recovers the secret from at least threshold number of distinct shares given in their text form.
+func CombineShares(texts []string) ([]byte, error) {
+	shares := make([]SecretShare, 0, len(texts))
+	seenIndex := make(map[int]bool)
+	for _, text := range texts {
+		share, err := ParseSecretShare(text)
+		if err != nil {
+			return nil, err
+		}
+		if len(shares) > 0 && !share.SameSetAs(shares[0]) {
+			return nil, ErrSecretSharesMismatch
+		}
+		// Ignore duplicated shares
+		if !seenIndex[share.Index] {
+			seenIndex[share.Index] = true
+			shares = append(shares, share)
+		}
+	}
+	if len(shares) == 0 || len(shares) < shares[0].Threshold {
+		return nil, ErrSecretSharesInsufficient
+	}
+	shares = shares[:shares[0].Threshold]
+	// Interpolate the polynomials at x = 0 to recover the constant terms
+	secret := make([]byte, len(shares[0].Data))
+	for i, share := range shares {
+		// Lagrange basis polynomial of the share evaluated at x = 0. Subtraction in GF(2^8) is XOR.
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gf256Mul(basis, gf256Div(byte(other.Index), byte(other.Index)^byte(share.Index)))
+			}
+		}
+		for byteIndex, y := range share.Data {
+			secret[byteIndex] ^= gf256Mul(basis, y)
+		}
+	}
+	return secret, nil
+}
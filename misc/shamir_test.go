@@ -0,0 +1,93 @@
+package misc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if product := gf256Mul(byte(a), byte(b)); gf256Div(product, byte(b)) != byte(a) {
+				t.Fatal(a, b, product)
+			}
+		}
+	}
+	// 0x53 and 0xca are multiplicative inverses of each other in the AES field
+	if gf256Mul(0x53, 0xca) != 1 {
+		t.Fatal(gf256Mul(0x53, 0xca))
+	}
+}
+
+func TestSplitSecretAndCombineShares(t *testing.T) {
+	if _, err := SplitSecret(nil, 2, 3); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := SplitSecret([]byte("a"), 1, 3); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := SplitSecret([]byte("a"), 3, 2); err == nil {
+		t.Fatal("did not error")
+	}
+	secret := []byte("this is a very secret password")
+	shares, err := SplitSecret(secret, 3, 5)
+	if err != nil || len(shares) != 5 {
+		t.Fatal(err, shares)
+	}
+	for _, share := range shares {
+		if !IsSecretShare(share) {
+			t.Fatal(share)
+		}
+	}
+	// Any 3 shares recover the secret
+	for _, combination := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		texts := make([]string, 0, len(combination))
+		for _, i := range combination {
+			texts = append(texts, shares[i])
+		}
+		if recovered, err := CombineShares(texts); err != nil || string(recovered) != string(secret) {
+			t.Fatal(combination, err, string(recovered))
+		}
+	}
+	// Fewer than 3 distinct shares are insufficient
+	if _, err := CombineShares([]string{shares[0], shares[1], shares[1]}); err != ErrSecretSharesInsufficient {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares(nil); err != ErrSecretSharesInsufficient {
+		t.Fatal(err)
+	}
+	// Shares of different secrets cannot be combined
+	otherShares, err := SplitSecret([]byte("another"), 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([]string{shares[0], shares[1], otherShares[2]}); err != ErrSecretSharesMismatch {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSecretShare(t *testing.T) {
+	share := SecretShare{Threshold: 2, Index: 3, SetID: "a1b2c3d4", Data: []byte{1, 2, 255}}
+	text := share.String()
+	if !strings.HasPrefix(text, "laitosshare-2-3-a1b2c3d4-0102ff-") {
+		t.Fatal(text)
+	}
+	if parsed, err := ParseSecretShare(" " + text + "\n"); err != nil || parsed.Threshold != 2 || parsed.Index != 3 || parsed.SetID != "a1b2c3d4" || string(parsed.Data) != string(share.Data) {
+		t.Fatal(err, parsed)
+	}
+	for _, bad := range []string{
+		"",
+		"password",
+		"laitosshare-2-3-a1b2c3d4-0102ff",
+		strings.Replace(text, "0102ff", "0102fe", 1),
+		SecretShare{Threshold: 1, Index: 1, SetID: "a1b2c3d4", Data: []byte{1}}.String(),
+		SecretShare{Threshold: 2, Index: 0, SetID: "a1b2c3d4", Data: []byte{1}}.String(),
+		SecretShare{Threshold: 2, Index: 1, SetID: "a1b2c3d4"}.String(),
+		SecretShare{Threshold: 2, Index: 1, SetID: "a1b2", Data: []byte{1}}.String(),
+		SecretShare{Threshold: 2, Index: 1, Data: []byte{1}}.String(),
+	} {
+		if _, err := ParseSecretShare(bad); err == nil {
+			t.Fatal("did not error", bad)
+		}
+	}
+}
@@ -163,8 +163,8 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Fatal(err, string(content))
 	}
 
-	// Decrypt with wrong key should not yield any useful content
-	if content, err := Decrypt(tmp.Name(), "wrong key"); err != nil || strings.Contains(string(content), "123") {
+	// Decrypt with wrong key should not yield any content
+	if content, err := Decrypt(tmp.Name(), "wrong key"); err != ErrDecryptionFailed || len(content) != 0 {
 		t.Fatal(err, string(content))
 	}
 
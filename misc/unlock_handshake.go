@@ -0,0 +1,319 @@
+package misc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+The unlock handshake lets an auto-unlocking peer and a password input web server authenticate each other before the
+peer releases the decryption password:
+1. Peer sends a random client nonce.
+2. Server replies with a random server nonce, an ephemeral X25519 public key, and a proof - HMAC of the nonces and key
+   using the fingerprint of the encrypted data as key. Only a server that possesses the encrypted data can make the
+   proof, and the peer verifies it using the fingerprint memorised in its configuration.
+3. Peer seals the password using a key agreed via X25519, and signs the entire conversation using its Ed25519 private
+   key. The server opens the password only if the signature comes from one of the authorised peers.
+*/
+
+const (
+	// UnlockChallengeInputName is the form field that carries the client nonce of an unlock handshake.
+	UnlockChallengeInputName = "unlockchallenge"
+	// UnlockResponseInputName is the form field that carries the sealed password (UnlockResponse) of an unlock handshake.
+	UnlockResponseInputName = "unlockresponse"
+	// UnlockHandshakeTimeout is the maximum duration between a server's challenge and the peer's response.
+	UnlockHandshakeTimeout = 1 * time.Minute
+	// UnlockNonceSize is the size of both client and server nonces.
+	UnlockNonceSize = 32
+	/*
+		MaxUnlockSessions is the maximum number of handshakes a server may simultaneously carry out. When a new handshake
+		begins, the oldest one is abandoned to make room, so that a flood of handshakes cannot lock out the genuine peers.
+	*/
+	MaxUnlockSessions = 100
+	// MaxUnlockSessionsPerClient is the maximum number of handshakes a server carries out for each client IP.
+	MaxUnlockSessionsPerClient = 3
+
+	unlockServerProofLabel = "laitos-unlock-server\x00"
+	unlockPeerSigLabel     = "laitos-unlock-peer\x00"
+	unlockSealKeyInfo      = "laitos-unlock-password"
+	unlockFingerprintLabel = "laitos-unlock-fingerprint\x00"
+)
+
+var (
+	// ErrUnlockProofMismatch is returned when the server fails to prove possession of the encrypted data.
+	ErrUnlockProofMismatch = errors.New("server failed to prove possession of the encrypted data")
+	// ErrUnlockPeerUnauthorised is returned when the peer is not authorised or its signature is invalid.
+	ErrUnlockPeerUnauthorised = errors.New("peer is not authorised or its signature is invalid")
+	// ErrUnlockSessionNotFound is returned when the peer responds to an unknown or expired challenge.
+	ErrUnlockSessionNotFound = errors.New("the challenge does not exist or has expired")
+)
+
+/*
+DataFingerprint returns the fingerprint of the (encrypted) file. An unlock handshake server proves the possession of
+encrypted data by using the fingerprint as a key.
+*/
+func DataFingerprint(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	hash.Write([]byte(unlockFingerprintLabel))
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// GenerateUnlockPeerKey generates a new Ed25519 key pair for an auto-unlocking peer, both keys are encoded in base64.
+func GenerateUnlockPeerKey() (privateKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// ParseUnlockPeerPrivateKey decodes the base64 private key seed of an auto-unlocking peer.
+func ParseUnlockPeerPrivateKey(privateKey string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("ParseUnlockPeerPrivateKey: private key must be a base64-encoded Ed25519 seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// UnlockChallenge is the server's reply to a client nonce.
+type UnlockChallenge struct {
+	ServerNonce []byte `json:"ServerNonce"` // ServerNonce identifies the handshake session.
+	ServerKey   []byte `json:"ServerKey"`   // ServerKey is the server's ephemeral X25519 public key.
+	Proof       []byte `json:"Proof"`       // Proof is the HMAC of nonces and server key using data fingerprint as key.
+}
+
+// UnlockResponse is the peer's reply to a server's challenge.
+type UnlockResponse struct {
+	ServerNonce    []byte `json:"ServerNonce"`    // ServerNonce identifies the handshake session.
+	PeerIdentity   []byte `json:"PeerIdentity"`   // PeerIdentity is the peer's Ed25519 public key.
+	PeerKey        []byte `json:"PeerKey"`        // PeerKey is the peer's ephemeral X25519 public key.
+	SealedPassword []byte `json:"SealedPassword"` // SealedPassword is the password encrypted by AES-GCM using the agreed key.
+	Signature      []byte `json:"Signature"`      // Signature is the peer's Ed25519 signature of the conversation.
+}
+
+// unlockServerProof returns the HMAC that proves possession of the data fingerprint.
+func unlockServerProof(fingerprint, clientNonce, serverNonce, serverKey []byte) []byte {
+	mac := hmac.New(sha256.New, fingerprint)
+	mac.Write([]byte(unlockServerProofLabel))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	mac.Write(serverKey)
+	return mac.Sum(nil)
+}
+
+// unlockPeerSignedContent returns the conversation content signed by peer.
+func unlockPeerSignedContent(clientNonce []byte, resp *UnlockResponse, serverKey []byte) []byte {
+	return bytes.Join([][]byte{[]byte(unlockPeerSigLabel), clientNonce, resp.ServerNonce, serverKey, resp.PeerKey, resp.SealedPassword}, nil)
+}
+
+// unlockSealCipher derives the password sealing cipher from the X25519 shared secret.
+func unlockSealCipher(privateKey, publicKey, clientNonce, serverNonce []byte) (cipher.AEAD, error) {
+	shared, err := curve25519.X25519(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, append(append([]byte{}, clientNonce...), serverNonce...), []byte(unlockSealKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// unlockSession is a handshake in progress on the server.
+type unlockSession struct {
+	clientID    string
+	clientNonce []byte
+	privateKey  []byte
+	publicKey   []byte
+	expiry      time.Time
+}
+
+// UnlockServer carries out the server side of unlock handshakes.
+type UnlockServer struct {
+	fingerprint []byte
+	peerKeys    []ed25519.PublicKey
+	sessions    map[string]*unlockSession
+	mutex       *sync.Mutex
+}
+
+// NewUnlockServer returns an unlock handshake server that accepts passwords from the peers of the base64 public keys.
+func NewUnlockServer(fingerprint []byte, peerPublicKeys []string) (*UnlockServer, error) {
+	if len(fingerprint) == 0 {
+		return nil, errors.New("NewUnlockServer: data fingerprint must not be empty")
+	}
+	if len(peerPublicKeys) == 0 {
+		return nil, errors.New("NewUnlockServer: there must be at least one authorised peer")
+	}
+	server := &UnlockServer{
+		fingerprint: fingerprint,
+		peerKeys:    make([]ed25519.PublicKey, 0, len(peerPublicKeys)),
+		sessions:    make(map[string]*unlockSession),
+		mutex:       new(sync.Mutex),
+	}
+	for _, encoded := range peerPublicKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("NewUnlockServer: \"%s\" is not a base64-encoded Ed25519 public key", encoded)
+		}
+		server.peerKeys = append(server.peerKeys, key)
+	}
+	return server, nil
+}
+
+/*
+Challenge starts a handshake session in reply to the client nonce. The client ID (IP address) limits the number of
+handshakes each client may carry out at a time.
+*/
+func (server *UnlockServer) Challenge(clientID string, clientNonce []byte) (*UnlockChallenge, error) {
+	if len(clientNonce) != UnlockNonceSize {
+		return nil, fmt.Errorf("UnlockServer.Challenge: client nonce must be %d bytes long", UnlockNonceSize)
+	}
+	session := &unlockSession{clientID: clientID, clientNonce: clientNonce, privateKey: make([]byte, curve25519.ScalarSize), expiry: time.Now().Add(UnlockHandshakeTimeout)}
+	serverNonce := make([]byte, UnlockNonceSize)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(session.privateKey); err != nil {
+		return nil, err
+	}
+	var err error
+	if session.publicKey, err = curve25519.X25519(session.privateKey, curve25519.Basepoint); err != nil {
+		return nil, err
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for nonce, existing := range server.sessions {
+		if time.Now().After(existing.expiry) {
+			delete(server.sessions, nonce)
+		}
+	}
+	server.abandonOldestSession(clientID, MaxUnlockSessionsPerClient)
+	server.abandonOldestSession("", MaxUnlockSessions)
+	server.sessions[hex.EncodeToString(serverNonce)] = session
+	return &UnlockChallenge{
+		ServerNonce: serverNonce,
+		ServerKey:   session.publicKey,
+		Proof:       unlockServerProof(server.fingerprint, clientNonce, serverNonce, session.publicKey),
+	}, nil
+}
+
+/*
+abandonOldestSession abandons the oldest handshake sessions of the client until there is room for a new one under the
+limit. An empty client ID stands for all clients. Caller must lock the server mutex.
+*/
+func (server *UnlockServer) abandonOldestSession(clientID string, limit int) {
+	for {
+		var count int
+		var oldestNonce string
+		for nonce, session := range server.sessions {
+			if clientID == "" || session.clientID == clientID {
+				count++
+				if oldestNonce == "" || session.expiry.Before(server.sessions[oldestNonce].expiry) {
+					oldestNonce = nonce
+				}
+			}
+		}
+		if count < limit {
+			return
+		}
+		delete(server.sessions, oldestNonce)
+	}
+}
+
+// Open ends the handshake session and returns the password sealed by an authorised peer.
+func (server *UnlockServer) Open(resp *UnlockResponse) (string, error) {
+	server.mutex.Lock()
+	session, exists := server.sessions[hex.EncodeToString(resp.ServerNonce)]
+	// Each challenge may be responded to only once
+	delete(server.sessions, hex.EncodeToString(resp.ServerNonce))
+	server.mutex.Unlock()
+	if !exists || time.Now().After(session.expiry) {
+		return "", ErrUnlockSessionNotFound
+	}
+	var authorised bool
+	for _, peerKey := range server.peerKeys {
+		if bytes.Equal(peerKey, resp.PeerIdentity) {
+			authorised = ed25519.Verify(peerKey, unlockPeerSignedContent(session.clientNonce, resp, session.publicKey), resp.Signature)
+			break
+		}
+	}
+	if !authorised {
+		return "", ErrUnlockPeerUnauthorised
+	}
+	aead, err := unlockSealCipher(session.privateKey, resp.PeerKey, session.clientNonce, resp.ServerNonce)
+	if err != nil {
+		return "", fmt.Errorf("UnlockServer.Open: %v", err)
+	}
+	// The sealing key is used only once, hence the nonce is all zeros.
+	password, err := aead.Open(nil, make([]byte, aead.NonceSize()), resp.SealedPassword, nil)
+	if err != nil {
+		return "", ErrUnlockPeerUnauthorised
+	}
+	return string(password), nil
+}
+
+// NewUnlockClientNonce returns a random client nonce that begins a handshake.
+func NewUnlockClientNonce() ([]byte, error) {
+	nonce := make([]byte, UnlockNonceSize)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+/*
+RespondToUnlockChallenge verifies that the server possesses the encrypted data of the fingerprint, and then returns
+the password sealed for the server and signed by the peer's private key.
+*/
+func RespondToUnlockChallenge(peerKey ed25519.PrivateKey, fingerprint, clientNonce []byte, challenge *UnlockChallenge, password string) (*UnlockResponse, error) {
+	if !hmac.Equal(challenge.Proof, unlockServerProof(fingerprint, clientNonce, challenge.ServerNonce, challenge.ServerKey)) {
+		return nil, ErrUnlockProofMismatch
+	}
+	ephemeralKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeralKey); err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey, err := curve25519.X25519(ephemeralKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := unlockSealCipher(ephemeralKey, challenge.ServerKey, clientNonce, challenge.ServerNonce)
+	if err != nil {
+		return nil, fmt.Errorf("RespondToUnlockChallenge: %v", err)
+	}
+	resp := &UnlockResponse{
+		ServerNonce:    challenge.ServerNonce,
+		PeerIdentity:   peerKey.Public().(ed25519.PublicKey),
+		PeerKey:        ephemeralPublicKey,
+		SealedPassword: aead.Seal(nil, make([]byte, aead.NonceSize()), []byte(password), nil),
+	}
+	resp.Signature = ed25519.Sign(peerKey, unlockPeerSignedContent(clientNonce, resp, challenge.ServerKey))
+	return resp, nil
+}
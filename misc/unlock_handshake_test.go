@@ -0,0 +1,153 @@
+package misc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUnlockHandshake(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "laitos-TestUnlockHandshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := ioutil.WriteFile(tmp.Name(), []byte("encrypted data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := DataFingerprint(tmp.Name())
+	if err != nil || len(fingerprint) != 32 {
+		t.Fatal(err, fingerprint)
+	}
+	privateKey, publicKey, err := GenerateUnlockPeerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := ParseUnlockPeerPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseUnlockPeerPrivateKey(publicKey + "a"); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := NewUnlockServer(fingerprint, []string{"bad key"}); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := NewUnlockServer(fingerprint, nil); err == nil {
+		t.Fatal("did not error")
+	}
+	server, err := NewUnlockServer(fingerprint, []string{publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Successful handshake
+	clientNonce, err := NewUnlockClientNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := server.Challenge("192.0.2.1", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, challenge, "the password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := server.Open(resp); err != nil || password != "the password" {
+		t.Fatal(err, password)
+	}
+	// The response cannot be replayed
+	if _, err := server.Open(resp); err != ErrUnlockSessionNotFound {
+		t.Fatal(err)
+	}
+
+	// A server without the encrypted data cannot prove its possession
+	impostor, err := NewUnlockServer([]byte("wrong fingerprint"), []string{publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err = impostor.Challenge("192.0.2.1", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, challenge, "the password"); err != ErrUnlockProofMismatch {
+		t.Fatal(err)
+	}
+
+	// An unauthorised peer or a tampered response cannot release the password
+	otherPrivateKey, _, err := GenerateUnlockPeerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPeerKey, err := ParseUnlockPeerPrivateKey(otherPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err = server.Challenge("192.0.2.1", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = RespondToUnlockChallenge(otherPeerKey, fingerprint, clientNonce, challenge, "the password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Open(resp); err != ErrUnlockPeerUnauthorised {
+		t.Fatal(err)
+	}
+	challenge, err = server.Challenge("192.0.2.1", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, challenge, "the password"); err != nil {
+		t.Fatal(err)
+	}
+	resp.SealedPassword[0]++
+	if _, err := server.Open(resp); err != ErrUnlockPeerUnauthorised {
+		t.Fatal(err)
+	}
+
+	// A flood of handshakes from one client abandons only its own oldest handshakes
+	challenge, err = server.Challenge("192.0.2.9", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxUnlockSessions; i++ {
+		if _, err := server.Challenge("192.0.2.1", clientNonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(server.sessions) != MaxUnlockSessionsPerClient+1 {
+		t.Fatal(len(server.sessions))
+	}
+	if resp, err = RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, challenge, "the password"); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := server.Open(resp); err != nil || password != "the password" {
+		t.Fatal(password, err)
+	}
+	// A flood of handshakes from many clients abandons the oldest handshakes
+	challenge, err = server.Challenge("192.0.2.9", clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxUnlockSessions; i++ {
+		if _, err := server.Challenge(fmt.Sprintf("198.51.100.%d", i), clientNonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(server.sessions) != MaxUnlockSessions {
+		t.Fatal(len(server.sessions))
+	}
+	if resp, err = RespondToUnlockChallenge(peerKey, fingerprint, clientNonce, challenge, "the password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Open(resp); err != ErrUnlockSessionNotFound {
+		t.Fatal(err)
+	}
+
+	// Malformed client nonce
+	if _, err := server.Challenge("192.0.2.1", []byte{1}); err == nil {
+		t.Fatal("did not error")
+	}
+}
@@ -1,8 +1,22 @@
 package platform
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"os"
+)
 
 // Enable or disable terminal echo.
 func SetTermEcho(echo bool) {
 	fmt.Println("(Terminal echo control is not supported on MacOS, your password input will show in plain!)")
 }
+
+// SetSerialParameters is not supported on MacOS.
+func SetSerialParameters(fd uintptr, baudRate int, parity string, stopBits int) error {
+	return errors.New("SetSerialParameters: serial port parameters cannot be configured on MacOS")
+}
+
+// OpenPseudoTerminal is not supported on MacOS.
+func OpenPseudoTerminal() (master *os.File, slavePath string, err error) {
+	return nil, "", errors.New("OpenPseudoTerminal: pseudo terminal is not supported on MacOS")
+}
@@ -1,6 +1,7 @@
 package platform
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 	"unsafe"
@@ -26,3 +27,94 @@ func SetTermEcho(echo bool) {
 		return
 	}
 }
+
+// termCBAUD is the mask of baud rate bits in termios control flags.
+const termCBAUD = 0x100f
+
+// serialBaudRates maps the supported serial baud rates to their termios speed constants.
+var serialBaudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+/*
+SetSerialParameters puts the serial device into raw mode (no line discipline, no echo) and configures its baud rate,
+parity ("none", "odd", or "even"), and number of stop bits (1 or 2). Each character is always made of 8 data bits.
+*/
+func SetSerialParameters(fd uintptr, baudRate int, parity string, stopBits int) error {
+	speed, found := serialBaudRates[baudRate]
+	if !found {
+		return fmt.Errorf("SetSerialParameters: unsupported baud rate %d", baudRate)
+	}
+	term := &syscall.Termios{}
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(term))); err != 0 {
+		return fmt.Errorf("SetSerialParameters: failed to get terminal attributes - %v", err)
+	}
+	term.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	term.Oflag &^= syscall.OPOST
+	term.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	term.Cflag &^= termCBAUD | syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB
+	term.Cflag |= speed | syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+	switch parity {
+	case "", "none":
+	case "odd":
+		term.Cflag |= syscall.PARENB | syscall.PARODD
+	case "even":
+		term.Cflag |= syscall.PARENB
+	default:
+		return fmt.Errorf("SetSerialParameters: unknown parity \"%s\"", parity)
+	}
+	switch stopBits {
+	case 0, 1:
+	case 2:
+		term.Cflag |= syscall.CSTOPB
+	default:
+		return fmt.Errorf("SetSerialParameters: unsupported number of stop bits %d", stopBits)
+	}
+	term.Ispeed = speed
+	term.Ospeed = speed
+	// Each read returns as soon as a character is available
+	term.Cc[syscall.VMIN] = 1
+	term.Cc[syscall.VTIME] = 0
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(term))); err != 0 {
+		return fmt.Errorf("SetSerialParameters: failed to set terminal attributes - %v", err)
+	}
+	return nil
+}
+
+/*
+OpenPseudoTerminal opens a new pseudo terminal and returns its master side along with the device path of its slave
+side. Test cases use it to emulate a serial device.
+*/
+func OpenPseudoTerminal() (master *os.File, slavePath string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	rawConn, err := master.SyscallConn()
+	if err != nil {
+		_ = master.Close()
+		return nil, "", err
+	}
+	var unlock, ptyNum uint32
+	var ioctlErr syscall.Errno
+	if err := rawConn.Control(func(fd uintptr) {
+		if _, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); ioctlErr != 0 {
+			return
+		}
+		_, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum)))
+	}); err != nil || ioctlErr != 0 {
+		_ = master.Close()
+		return nil, "", fmt.Errorf("OpenPseudoTerminal: failed to unlock pseudo terminal - %v %v", err, ioctlErr)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", ptyNum), nil
+}
@@ -1,9 +1,47 @@
 package platform
 
-import "testing"
+import (
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
 
 func TestSetTermEcho(t *testing.T) {
 	// just make sure it does not panic
 	SetTermEcho(false)
 	SetTermEcho(true)
 }
+
+func TestOpenPseudoTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo terminal is only supported on linux")
+	}
+	master, slavePath, err := OpenPseudoTerminal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	slave, err := os.OpenFile(slavePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	rawConn, err := slave.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		setErr = SetSerialParameters(fd, 115200, "even", 2)
+	}); err != nil || setErr != nil {
+		t.Fatal(err, setErr)
+	}
+	if _, err := slave.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(master, buf); err != nil || string(buf) != "hello\n" {
+		t.Fatal(err, string(buf))
+	}
+}
@@ -1,8 +1,22 @@
 package platform
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"os"
+)
 
 // Enable or disable terminal echo.
 func SetTermEcho(echo bool) {
 	fmt.Println("(Terminal echo control is not supported on Windows, your password input will show in plain!)")
 }
+
+// SetSerialParameters is not supported on Windows.
+func SetSerialParameters(fd uintptr, baudRate int, parity string, stopBits int) error {
+	return errors.New("SetSerialParameters: serial port parameters cannot be configured on Windows")
+}
+
+// OpenPseudoTerminal is not supported on Windows.
+func OpenPseudoTerminal() (master *os.File, slavePath string, err error) {
+	return nil, "", errors.New("OpenPseudoTerminal: pseudo terminal is not supported on Windows")
+}
@@ -0,0 +1,182 @@
+package remotevm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+)
+
+// Profile is the named specification of a virtual machine managed by Manager.
+type Profile struct {
+	Name      string `json:"Name"`      // Name identifies the virtual machine.
+	NumCPU    int    `json:"NumCPU"`    // NumCPU is the number of CPU cores allocated to emulator.
+	MemSizeMB int    `json:"MemSizeMB"` // MemSizeMB is the amount of memory allocated to emulator.
+	QMPPort   int    `json:"QMPPort"`   // QMPPort is the TCP port number used for interacting with emulator.
+	VNCPort   int    `json:"VNCPort"`   // VNCPort is the TCP port number of the built-in VNC server, 0 disables the VNC server.
+	/*
+		DiskSizeGB is the size of the qcow2 disk created for the virtual machine upon its first start. The disk keeps
+		its content and snapshots across restarts. If it is 0 and there is no base image, the virtual machine runs
+		without a disk.
+	*/
+	DiskSizeGB int `json:"DiskSizeGB"`
+	// BaseImagePath is an optional qcow2 image, the disk of the virtual machine becomes an overlay on top of it.
+	BaseImagePath string `json:"BaseImagePath"`
+}
+
+// VMStatus describes a virtual machine managed by Manager.
+type VMStatus struct {
+	Profile
+	Running      bool   // Running is true if the emulator has been started and not yet killed.
+	DiskFilePath string // DiskFilePath is the location of the virtual machine's disk, it is empty if there is no disk.
+}
+
+// Manager starts, stops, and keeps track of multiple virtual machines, each described by a profile.
+type Manager struct {
+	Profiles    []Profile // Profiles describe the virtual machines, their names and port numbers must be unique.
+	DiskDir     string    // DiskDir is the directory where disk files of virtual machines are kept.
+	VNCPassword string    // VNCPassword authenticates VNC clients of all virtual machines.
+	// VNCListenAddr is the IP address the VNC servers of all virtual machines listen on, it defaults to 127.0.0.1.
+	VNCListenAddr string
+
+	vms    map[string]*VM
+	mutex  *sync.Mutex
+	logger lalog.Logger
+}
+
+// Initialise validates the profiles and prepares a virtual machine for each of them.
+func (man *Manager) Initialise() error {
+	man.logger = lalog.Logger{ComponentName: "remotevm.Manager"}
+	man.mutex = new(sync.Mutex)
+	man.vms = make(map[string]*VM)
+	if len(man.Profiles) == 0 {
+		return errors.New("Manager.Initialise: there must be at least one profile")
+	}
+	ports := make(map[int]bool)
+	for _, profile := range man.Profiles {
+		if !RegexSnapshotName.MatchString(profile.Name) {
+			return fmt.Errorf("Manager.Initialise: profile name \"%s\" may only contain letters, numbers, underscore, and dash", profile.Name)
+		}
+		if _, exists := man.vms[profile.Name]; exists {
+			return fmt.Errorf("Manager.Initialise: profile name \"%s\" is not unique", profile.Name)
+		}
+		if profile.NumCPU < 1 || profile.MemSizeMB < 1 {
+			return fmt.Errorf("Manager.Initialise: profile \"%s\" must have CPU and memory", profile.Name)
+		}
+		for _, port := range []int{profile.QMPPort, profile.VNCPort} {
+			if port == 0 {
+				continue
+			}
+			if ports[port] {
+				return fmt.Errorf("Manager.Initialise: port number %d of profile \"%s\" is not unique", port, profile.Name)
+			}
+			ports[port] = true
+		}
+		if profile.QMPPort < 1 {
+			return fmt.Errorf("Manager.Initialise: profile \"%s\" must have a QMP port", profile.Name)
+		}
+		if profile.VNCPort > 0 && man.VNCPassword == "" {
+			return fmt.Errorf("Manager.Initialise: profile \"%s\" uses VNC, the VNC password must not be empty", profile.Name)
+		}
+		vm := &VM{
+			NumCPU:      profile.NumCPU,
+			MemSizeMB:   profile.MemSizeMB,
+			QMPPort:     profile.QMPPort,
+			VNCPort:     profile.VNCPort,
+			VNCPassword: man.VNCPassword,
+			// VM defaults the listen address to 127.0.0.1
+			VNCListenAddr: man.VNCListenAddr,
+		}
+		if profile.DiskSizeGB > 0 || profile.BaseImagePath != "" {
+			if man.DiskDir == "" {
+				return fmt.Errorf("Manager.Initialise: profile \"%s\" uses a disk, the disk directory must not be empty", profile.Name)
+			}
+			vm.DiskFilePath = filepath.Join(man.DiskDir, profile.Name+".qcow2")
+		}
+		if err := vm.Initialise(); err != nil {
+			return err
+		}
+		man.vms[profile.Name] = vm
+	}
+	return nil
+}
+
+// GetVM returns the virtual machine of the profile.
+func (man *Manager) GetVM(name string) (*VM, error) {
+	vm, exists := man.vms[name]
+	if !exists {
+		return nil, fmt.Errorf("Manager.GetVM: cannot find profile \"%s\"", name)
+	}
+	return vm, nil
+}
+
+// getProfile returns the profile of the name.
+func (man *Manager) getProfile(name string) Profile {
+	for _, profile := range man.Profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return Profile{}
+}
+
+/*
+Start starts the virtual machine of the profile. If the profile uses a disk that does not yet exist, the disk is
+created first. The ISO file is optional if the virtual machine boots from its disk.
+*/
+func (man *Manager) Start(name, isoFilePath string) error {
+	vm, err := man.GetVM(name)
+	if err != nil {
+		return err
+	}
+	// Serialise the disk creation and emulator start-up
+	man.mutex.Lock()
+	defer man.mutex.Unlock()
+	if vm.DiskFilePath != "" {
+		if _, err := os.Stat(vm.DiskFilePath); os.IsNotExist(err) {
+			if err := os.MkdirAll(man.DiskDir, 0700); err != nil {
+				return fmt.Errorf("Manager.Start: failed to create disk directory - %v", err)
+			}
+			profile := man.getProfile(name)
+			if err := vm.CreateDisk(profile.DiskSizeGB, profile.BaseImagePath); err != nil {
+				return err
+			}
+		}
+	}
+	man.logger.Info("Start", name, nil, "starting virtual machine")
+	return vm.Start(isoFilePath)
+}
+
+// Kill stops the virtual machine of the profile. The disk of the virtual machine is kept.
+func (man *Manager) Kill(name string) error {
+	vm, err := man.GetVM(name)
+	if err != nil {
+		return err
+	}
+	vm.Kill()
+	return nil
+}
+
+// KillAll stops all virtual machines.
+func (man *Manager) KillAll() {
+	for _, vm := range man.vms {
+		vm.Kill()
+	}
+}
+
+// List returns the status of all virtual machines sorted by profile name.
+func (man *Manager) List() []VMStatus {
+	ret := make([]VMStatus, 0, len(man.Profiles))
+	for _, profile := range man.Profiles {
+		vm := man.vms[profile.Name]
+		ret = append(ret, VMStatus{Profile: profile, Running: vm.IsRunning(), DiskFilePath: vm.DiskFilePath})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
@@ -0,0 +1,73 @@
+package remotevm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManager(t *testing.T) {
+	diskDir, err := ioutil.TempDir("", "laitos-test-remotevm-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(diskDir)
+
+	for _, bad := range []Manager{
+		{},
+		{Profiles: []Profile{{Name: "bad name", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431}}},
+		{Profiles: []Profile{{Name: "a", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431}, {Name: "a", NumCPU: 1, MemSizeMB: 128, QMPPort: 23432}}},
+		{Profiles: []Profile{{Name: "a", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431}, {Name: "b", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431}}},
+		{Profiles: []Profile{{Name: "a", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431, VNCPort: 23432}}},
+		{Profiles: []Profile{{Name: "a", NumCPU: 1, MemSizeMB: 128, QMPPort: 23431, DiskSizeGB: 1}}},
+	} {
+		if err := bad.Initialise(); err == nil {
+			t.Fatalf("did not error: %+v", bad)
+		}
+	}
+
+	man := Manager{
+		Profiles: []Profile{
+			{Name: "work", NumCPU: 1, MemSizeMB: 256, QMPPort: 23433, VNCPort: 23434, DiskSizeGB: 2},
+			{Name: "live", NumCPU: 1, MemSizeMB: 256, QMPPort: 23435},
+		},
+		DiskDir:     diskDir,
+		VNCPassword: "pass",
+	}
+	if err := man.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	list := man.List()
+	if len(list) != 2 || list[0].Name != "live" || list[0].DiskFilePath != "" || list[0].Running ||
+		list[1].Name != "work" || list[1].DiskFilePath != filepath.Join(diskDir, "work.qcow2") || list[1].Running {
+		t.Fatalf("%+v", list)
+	}
+	if _, err := man.GetVM("nothing"); err == nil {
+		t.Fatal("did not error")
+	}
+	// A live VM without disk cannot boot without an ISO file, nor does it support snapshots.
+	if err := man.Start("live", ""); err == nil {
+		t.Fatal("did not error")
+	}
+	live, err := man.GetVM("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The VNC server is not exposed to the network unless configured to do so
+	if live.VNCListenAddr != "127.0.0.1" {
+		t.Fatal(live.VNCListenAddr)
+	}
+	if err := live.SaveSnapshot("a"); err == nil || !strings.Contains(err.Error(), "disk") {
+		t.Fatal(err)
+	}
+	work, err := man.GetVM("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := work.LoadSnapshot("bad name;quit"); err == nil || !strings.Contains(err.Error(), "snapshot name") {
+		t.Fatal(err)
+	}
+	man.KillAll()
+}
@@ -11,7 +11,7 @@ import (
 	"strconv"
 )
 
-func readPPM(in io.Reader) (image.Image, error) {
+func readPPM(in io.Reader) (*image.RGBA, error) {
 	buf := bufio.NewReader(in)
 	var err error
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"image/jpeg"
 	"io"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,8 +30,17 @@ const (
 	QEMUExecutableName = "qemu-system-x86_64"
 	// QMPCommandResponseTimeoutSec is the number of seconds after which an outstanding QMP command is aborted due to timeout.
 	QMPCommandResponseTimeoutSec = 10
+	// QMPSnapshotTimeoutSec is the number of seconds to wait for a snapshot operation, which saves or restores the entire memory.
+	QMPSnapshotTimeoutSec = 10 * 60
+	// QEMUImgExecutableName is the QEMU disk image utility's executable name, without the prefix path.
+	QEMUImgExecutableName = "qemu-img"
+	// QEMUImgTimeoutSec is the maximum number of seconds for the disk image utility to create a disk.
+	QEMUImgTimeoutSec = 60
 )
 
+// RegexSnapshotName matches a valid snapshot name.
+var RegexSnapshotName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
 /*
 VM launches a virtual machine of lightweight Linux distribution via KVM (preferred) or QEMU (fall-back) and offers
 remote mouse and keyboard control, as well as screenshot capability.
@@ -38,17 +49,31 @@ type VM struct {
 	NumCPU    int // NumCPU is the number of CPU cores allocated to emulator
 	MemSizeMB int // MemSizeMB is the amount of memory allocated to emulator
 	QMPPort   int // QMPPort is the TCP port number used for interacting with emulator
+	// VNCPort is the TCP port number of the built-in VNC server. The VNC server is disabled if the port number is 0.
+	VNCPort int
+	// VNCPassword authenticates VNC clients, only the first 8 characters are significant.
+	VNCPassword string
+	/*
+		VNCListenAddr is the IP address the built-in VNC server listens on. It defaults to 127.0.0.1, because the VNC
+		password authentication scheme is weak - use an SSH tunnel or a trusted network address to reach it.
+	*/
+	VNCListenAddr string
+	// DiskFilePath is the optional qcow2 disk attached to the emulator. Snapshots are stored in the disk file.
+	DiskFilePath string
 
 	emulatorExecutable  string
 	emulatorCmd         *exec.Cmd
 	emulatorDebugOutput *lalog.ByteLogWriter
 	qmpConn             *net.TCPConn
 	qmpClient           *textproto.Conn
+	vncServer           *RFBServer
 
 	lastScreenWidth, lastScreenHeight int
+	lastButtonMask                    byte
 
 	emulatorMutex *sync.Mutex
 	qmpMutex      *sync.Mutex
+	screenMutex   *sync.Mutex // screenMutex protects the latest screen resolution.
 	logger        lalog.Logger
 }
 
@@ -65,6 +90,10 @@ func (vm *VM) Initialise() error {
 	vm.emulatorDebugOutput = lalog.NewByteLogWriter(ioutil.Discard, 1024)
 	vm.emulatorMutex = new(sync.Mutex)
 	vm.qmpMutex = new(sync.Mutex)
+	vm.screenMutex = new(sync.Mutex)
+	if vm.VNCListenAddr == "" {
+		vm.VNCListenAddr = "127.0.0.1"
+	}
 	return nil
 }
 
@@ -113,16 +142,29 @@ func (vm *VM) Start(isoFilePath string) error {
 	vm.emulatorExecutable = findEmulatorExecutable()
 	vm.emulatorMutex.Lock()
 	defer vm.emulatorMutex.Unlock()
-	if _, err := os.Stat(isoFilePath); err != nil {
-		return fmt.Errorf("VM.Start: failed to read OS ISO file \"%s\" - %v", isoFilePath, err)
+	if isoFilePath == "" && vm.DiskFilePath == "" {
+		return errors.New("VM.Start: there is neither an ISO file nor a disk to boot from")
+	}
+	if isoFilePath != "" {
+		if _, err := os.Stat(isoFilePath); err != nil {
+			return fmt.Errorf("VM.Start: failed to read OS ISO file \"%s\" - %v", isoFilePath, err)
+		}
+	}
+	if vm.DiskFilePath != "" {
+		if _, err := os.Stat(vm.DiskFilePath); err != nil {
+			return fmt.Errorf("VM.Start: failed to read disk file \"%s\" - %v", vm.DiskFilePath, err)
+		}
 	}
 	// Prevent repeated startup of the same VM
 	if vm.emulatorCmd != nil {
 		return errors.New("VM.Start: already started")
 	}
+	if vm.VNCPort > 0 && vm.VNCPassword == "" {
+		return errors.New("VM.Start: VNC password must not be empty")
+	}
 	vm.logger.Info("Start", isoFilePath, nil, "starting emulator %s, this may take a minute", vm.emulatorExecutable)
-	fmt.Fprintf(vm.emulatorDebugOutput, "Starting emulator %s for ISO file %s, this may take a minute.\n", vm.emulatorExecutable, isoFilePath)
-	vm.emulatorCmd = exec.Command(vm.emulatorExecutable,
+	fmt.Fprintf(vm.emulatorDebugOutput, "Starting emulator %s for ISO file %s and disk %s, this may take a minute.\n", vm.emulatorExecutable, isoFilePath, vm.DiskFilePath)
+	args := []string{
 		"-smp", strconv.Itoa(vm.NumCPU), "-m", fmt.Sprintf("%dM", vm.MemSizeMB),
 		/*
 			"nographic" tells emulator not to create a GUI window for interacting with VM. The emulator still gets a graphics card.
@@ -136,10 +178,19 @@ func (vm *VM) Start(isoFilePath string) error {
 			Without a "tablet" mouse, we cannot position mouse pointer using absolute X&Y coordinates.
 		*/
 		"-usb", "-device", "usb-tablet",
-		// Boot from CD which is an ISO file, usually that of a live Linux distribution.
-		"-boot", "order=d", "-cdrom", isoFilePath,
 		// Start command server
-		"-qmp", fmt.Sprintf("tcp:127.0.0.1:%d,server,nowait", vm.QMPPort))
+		"-qmp", fmt.Sprintf("tcp:127.0.0.1:%d,server,nowait", vm.QMPPort),
+	}
+	if vm.DiskFilePath != "" {
+		args = append(args, "-drive", fmt.Sprintf("file=%s,format=qcow2", strings.ReplaceAll(vm.DiskFilePath, ",", ",,")))
+	}
+	if isoFilePath != "" {
+		// Boot from CD which is an ISO file, usually that of a live Linux distribution, and then from the disk.
+		args = append(args, "-boot", "order=dc", "-cdrom", isoFilePath)
+	} else {
+		args = append(args, "-boot", "order=c")
+	}
+	vm.emulatorCmd = exec.Command(vm.emulatorExecutable, args...)
 	vm.emulatorCmd.Stdout = vm.emulatorDebugOutput
 	vm.emulatorCmd.Stderr = vm.emulatorDebugOutput
 	if err := vm.emulatorCmd.Start(); err != nil {
@@ -147,6 +198,20 @@ func (vm *VM) Start(isoFilePath string) error {
 	}
 	vm.logger.Info("Start", vm.emulatorExecutable, nil, "emulator successfully started %s", isoFilePath)
 	fmt.Fprintf(vm.emulatorDebugOutput, "emulator %s successfully started %s\n", vm.emulatorExecutable, isoFilePath)
+	// Present the emulator display to VNC clients
+	if vm.VNCPort > 0 {
+		vm.vncServer = &RFBServer{ListenAddr: vm.VNCListenAddr, ListenPort: vm.VNCPort, Password: vm.VNCPassword, Display: vm}
+		if err := vm.vncServer.Initialise(); err != nil {
+			return err
+		}
+		go func(vncServer *RFBServer) {
+			if err := vncServer.StartAndBlock(); err != nil {
+				vm.logger.Warning("Start", strconv.Itoa(vm.VNCPort), err, "failed to start VNC server")
+				fmt.Fprintf(vm.emulatorDebugOutput, "failed to start VNC server on port %d - %v\n", vm.VNCPort, err)
+			}
+		}(vm.vncServer)
+		fmt.Fprintf(vm.emulatorDebugOutput, "VNC server is listening on %s:%d\n", vm.VNCListenAddr, vm.VNCPort)
+	}
 	return nil
 }
 
@@ -186,7 +251,7 @@ func (vm *VM) connectToQMP() error {
 	if err := vm.qmpClient.PrintfLine(`{"execute":"qmp_capabilities"}`); err != nil {
 		return fmt.Errorf("Failed to exchange initialisation QMP command - %w", err)
 	}
-	if _, err := vm.qmpClient.ReadLine(); err != nil {
+	if _, err := vm.readQMPReply(vm.qmpClient); err != nil {
 		return fmt.Errorf("Failed to exchange initialisation QMP command - %w", err)
 	}
 	vm.logger.Info("connectToQMP", strconv.Itoa(vm.QMPPort), nil, "successfully connected to emulator QMP")
@@ -205,6 +270,10 @@ func (vm *VM) Kill() {
 		_ = conn.Close()
 	}
 	vm.qmpConn = nil
+	if vncServer := vm.vncServer; vncServer != nil {
+		vncServer.Stop()
+	}
+	vm.vncServer = nil
 	if cmd := vm.emulatorCmd; cmd != nil {
 		if proc := cmd.Process; proc != nil {
 			vm.logger.Info("Kill", "", nil, "killing emulator process PID %d", proc.Pid)
@@ -216,6 +285,109 @@ func (vm *VM) Kill() {
 	vm.emulatorCmd = nil
 }
 
+// IsRunning returns true if the emulator has been started and not yet killed.
+func (vm *VM) IsRunning() bool {
+	vm.emulatorMutex.Lock()
+	defer vm.emulatorMutex.Unlock()
+	return vm.emulatorCmd != nil
+}
+
+/*
+CreateDisk creates the qcow2 disk file at DiskFilePath. If a base image is given, the disk becomes an overlay that
+records only the changes made on top of the base image, which itself remains unchanged.
+*/
+func (vm *VM) CreateDisk(sizeGB int, baseImagePath string) error {
+	if vm.DiskFilePath == "" {
+		return errors.New("VM.CreateDisk: DiskFilePath is empty")
+	}
+	args := []string{"create", "-f", "qcow2"}
+	if baseImagePath != "" {
+		if _, err := os.Stat(baseImagePath); err != nil {
+			return fmt.Errorf("VM.CreateDisk: failed to read base image \"%s\" - %v", baseImagePath, err)
+		}
+		args = append(args, "-b", baseImagePath, "-F", "qcow2")
+	}
+	args = append(args, vm.DiskFilePath)
+	// The size of an overlay disk may be omitted to inherit the size of base image
+	if sizeGB > 0 {
+		args = append(args, fmt.Sprintf("%dG", sizeGB))
+	} else if baseImagePath == "" {
+		return errors.New("VM.CreateDisk: disk size must be greater than 0")
+	}
+	out, err := platform.InvokeProgram(nil, QEMUImgTimeoutSec, findQEMUImgExecutable(), args...)
+	fmt.Fprintf(vm.emulatorDebugOutput, "CreateDisk: %v %s\n", err, out)
+	if err != nil {
+		return fmt.Errorf("VM.CreateDisk: failed to create disk \"%s\" - %v %s", vm.DiskFilePath, err, out)
+	}
+	vm.logger.Info("CreateDisk", vm.DiskFilePath, nil, "successfully created disk")
+	return nil
+}
+
+/*
+executeHMP is an internal function that executes a human monitor command via QMP and returns the command's text output.
+Snapshot operations are only available as human monitor commands in older versions of QEMU.
+*/
+func (vm *VM) executeHMP(cmd string, timeoutSec int) (string, error) {
+	resp, err := vm.executeQMPWithTimeout(timeoutSec, map[string]interface{}{
+		"execute": "human-monitor-command",
+		"arguments": map[string]interface{}{
+			"command-line": cmd,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	var output struct {
+		Return string `json:"return"`
+	}
+	if err := json.Unmarshal([]byte(resp), &output); err != nil {
+		return "", fmt.Errorf("VM.executeHMP: failed to decode response - %v", err)
+	}
+	return output.Return, nil
+}
+
+// snapshotHMP is an internal function that runs a human monitor command on a snapshot and expects no output in return.
+func (vm *VM) snapshotHMP(funcName, cmd, snapshotName string) error {
+	if vm.DiskFilePath == "" {
+		return fmt.Errorf("VM.%s: snapshots require a disk", funcName)
+	}
+	if !RegexSnapshotName.MatchString(snapshotName) {
+		return fmt.Errorf("VM.%s: snapshot name may only contain letters, numbers, underscore, and dash", funcName)
+	}
+	out, err := vm.executeHMP(cmd+" "+snapshotName, QMPSnapshotTimeoutSec)
+	if err != nil {
+		return err
+	}
+	// The command is successful only if it does not output anything
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("VM.%s: %s", funcName, out)
+	}
+	return nil
+}
+
+// SaveSnapshot saves the state of emulator, including its memory and disk content, into a snapshot of the name.
+func (vm *VM) SaveSnapshot(name string) error {
+	return vm.snapshotHMP("SaveSnapshot", "savevm", name)
+}
+
+// LoadSnapshot restores the emulator to the state saved in the snapshot.
+func (vm *VM) LoadSnapshot(name string) error {
+	return vm.snapshotHMP("LoadSnapshot", "loadvm", name)
+}
+
+// DeleteSnapshot deletes the snapshot from disk.
+func (vm *VM) DeleteSnapshot(name string) error {
+	return vm.snapshotHMP("DeleteSnapshot", "delvm", name)
+}
+
+// ListSnapshots returns a text table of snapshots saved in the disk.
+func (vm *VM) ListSnapshots() (string, error) {
+	if vm.DiskFilePath == "" {
+		return "", errors.New("VM.ListSnapshots: snapshots require a disk")
+	}
+	return vm.executeHMP("info snapshots", QMPCommandResponseTimeoutSec)
+}
+
 // GetDebugOutput returns the QEMU/KVM emulator output along with recent QMP command and responses.
 func (vm *VM) GetDebugOutput() string {
 	if vm.emulatorDebugOutput != nil {
@@ -225,14 +397,14 @@ func (vm *VM) GetDebugOutput() string {
 }
 
 /*
-TakeScreenshot takes a screenshot of the emulator video display, the screenshot image format is JPEG.
+CaptureScreen takes a screenshot of the emulator video display and returns the decoded picture.
 The function also updates the screen total resolution tracked internally for calculating mouse movement coordinates.
 */
-func (vm *VM) TakeScreenshot(outputFileName string) error {
+func (vm *VM) CaptureScreen() (*image.RGBA, error) {
 	// Create a temporary file to store the screenshot output
 	tmpFile, err := ioutil.TempFile("", "laitos-vm-take-screenshot*.ppm")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_ = tmpFile.Close()
 	defer os.Remove(tmpFile.Name())
@@ -244,7 +416,7 @@ func (vm *VM) TakeScreenshot(outputFileName string) error {
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// QEMU takes a short while to finish taking the screenshot even if the positive response comes instantenously
 	var fileSize int64
@@ -267,21 +439,35 @@ anticiateGrowingFile:
 		time.Sleep(50 * time.Millisecond)
 	}
 	if fileSize == 0 {
-		return errors.New("VM.TakeScreenshot: screenshot command was sent, however the result screenshot file is empty.")
+		return nil, errors.New("VM.CaptureScreen: screenshot command was sent, however the result screenshot file is empty.")
 	}
 	// Decode screenshot in PPM format
 	ppmFile, err := os.Open(tmpFile.Name())
 	if err != nil {
-		return fmt.Errorf("VM.TakeScreenshot: failed to open screenshot file - %w", err)
+		return nil, fmt.Errorf("VM.CaptureScreen: failed to open screenshot file - %w", err)
 	}
 	ppmImage, err := readPPM(ppmFile)
+	_ = ppmFile.Close()
 	if err != nil {
-		return fmt.Errorf("VM.TakeScreenshot: failed to decode screenshot file - %w", err)
+		return nil, fmt.Errorf("VM.CaptureScreen: failed to decode screenshot file - %w", err)
 	}
-	_ = ppmFile.Close()
 	// Memorise the latest screen resolution to help calculating mouse movement coordinates
+	vm.screenMutex.Lock()
 	vm.lastScreenWidth = ppmImage.Bounds().Size().X
 	vm.lastScreenHeight = ppmImage.Bounds().Size().Y
+	vm.screenMutex.Unlock()
+	return ppmImage, nil
+}
+
+/*
+TakeScreenshot takes a screenshot of the emulator video display, the screenshot image format is JPEG.
+The function also updates the screen total resolution tracked internally for calculating mouse movement coordinates.
+*/
+func (vm *VM) TakeScreenshot(outputFileName string) error {
+	ppmImage, err := vm.CaptureScreen()
+	if err != nil {
+		return err
+	}
 	// Encode the screenshot in JPEG and save to output file
 	jpegFile, err := os.OpenFile(outputFileName, os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
@@ -320,22 +506,7 @@ func (vm *VM) MoveMouse(x, y int) error {
 	_, err := vm.executeQMP(map[string]interface{}{
 		"execute": "input-send-event",
 		"arguments": map[string]interface{}{
-			"events": []interface{}{
-				map[string]interface{}{
-					"type": "abs",
-					"data": map[string]interface{}{
-						"axis":  "x",
-						"value": int(float64(x) * (32 * (1 / (float64(vm.lastScreenWidth) / 1024)))),
-					},
-				},
-				map[string]interface{}{
-					"type": "abs",
-					"data": map[string]interface{}{
-						"axis":  "y",
-						"value": int(float64(y) * (42.68 * (1 / (float64(vm.lastScreenHeight) / 768)))),
-					},
-				},
-			},
+			"events": vm.absPointerEvents(x, y),
 		},
 	})
 	if err != nil {
@@ -344,6 +515,34 @@ func (vm *VM) MoveMouse(x, y int) error {
 	return nil
 }
 
+// getScreenSize returns the screen resolution memorised by the latest screenshot.
+func (vm *VM) getScreenSize() (width, height int) {
+	vm.screenMutex.Lock()
+	defer vm.screenMutex.Unlock()
+	return vm.lastScreenWidth, vm.lastScreenHeight
+}
+
+// absPointerEvents returns the QMP input events that position mouse pointer at the screen coordinates, see MoveMouse for the formula.
+func (vm *VM) absPointerEvents(x, y int) []interface{} {
+	width, height := vm.getScreenSize()
+	return []interface{}{
+		map[string]interface{}{
+			"type": "abs",
+			"data": map[string]interface{}{
+				"axis":  "x",
+				"value": int(float64(x) * (32 * (1 / (float64(width) / 1024)))),
+			},
+		},
+		map[string]interface{}{
+			"type": "abs",
+			"data": map[string]interface{}{
+				"axis":  "y",
+				"value": int(float64(y) * (42.68 * (1 / (float64(height) / 768)))),
+			},
+		},
+	}
+}
+
 /*
 ClickKeyboard pushes and releases the keys given in the input sequence all at once.
 Keys are identified by "QCode", which is a string that indicates key's name.
@@ -456,12 +655,97 @@ func (vm *VM) DoubleClickMouse(leftButton bool) error {
 	return nil
 }
 
+/*
+PointerEvent moves the mouse cursor to the input location, and then presses or releases mouse buttons so that only
+the buttons in the mask remain held down. The mask bits are, from the lowest: left, middle, right, wheel up, and wheel down.
+*/
+func (vm *VM) PointerEvent(buttonMask byte, x, y int) error {
+	if width, height := vm.getScreenSize(); width == 0 || height == 0 {
+		return errors.New("VM.PointerEvent: take a screenshot first to determine screen resolution")
+	}
+	events := vm.absPointerEvents(x, y)
+	vm.qmpMutex.Lock()
+	changedButtons := vm.lastButtonMask ^ buttonMask
+	vm.lastButtonMask = buttonMask
+	vm.qmpMutex.Unlock()
+	for i, button := range []string{"left", "middle", "right", "wheel-up", "wheel-down"} {
+		if bit := byte(1 << uint(i)); changedButtons&bit != 0 {
+			events = append(events, map[string]interface{}{
+				"type": "btn",
+				"data": map[string]interface{}{
+					"down":   buttonMask&bit != 0,
+					"button": button,
+				},
+			})
+		}
+	}
+	_, err := vm.executeQMP(map[string]interface{}{
+		"execute": "input-send-event",
+		"arguments": map[string]interface{}{
+			"events": events,
+		},
+	})
+	return err
+}
+
+// KeyEvent presses or releases the keyboard key identified by an X11 keysym. Keys that do not have a QEMU key code are ignored.
+func (vm *VM) KeyEvent(down bool, keysym uint32) error {
+	code, found := KeysymToQCode(keysym)
+	if !found {
+		return nil
+	}
+	_, err := vm.executeQMP(map[string]interface{}{
+		"execute": "input-send-event",
+		"arguments": map[string]interface{}{
+			"events": []interface{}{
+				map[string]interface{}{
+					"type": "key",
+					"data": map[string]interface{}{
+						"down": down,
+						"key": map[string]interface{}{
+							"type": "qcode",
+							"data": code,
+						},
+					},
+				},
+			},
+		},
+	})
+	return err
+}
+
 /*
 executeQMP is an internal function that serialises the input QMP command and sends it to the emulator, and then awaits
 emulator's response.
 For the simplicity of implementation, each command makes a new TCP connection to the emulator's TCP server.
 */
 func (vm *VM) executeQMP(in interface{}) (resp string, err error) {
+	return vm.executeQMPWithTimeout(QMPCommandResponseTimeoutSec, in)
+}
+
+/*
+readQMPReply reads the reply to the latest QMP command. Asynchronous events, such as STOP and RESUME that precede the
+reply to a snapshot command, are skipped.
+*/
+func (vm *VM) readQMPReply(qmpClient *textproto.Conn) (string, error) {
+	for {
+		line, err := qmpClient.ReadLine()
+		if err != nil {
+			return line, err
+		}
+		var msg map[string]json.RawMessage
+		if json.Unmarshal([]byte(line), &msg) == nil {
+			if _, isEvent := msg["event"]; isEvent {
+				fmt.Fprintf(vm.emulatorDebugOutput, "Debug: event - %s\n", line)
+				continue
+			}
+		}
+		return line, nil
+	}
+}
+
+// executeQMPWithTimeout sends a QMP command to the emulator and waits for the reply for up to the number of seconds.
+func (vm *VM) executeQMPWithTimeout(timeoutSec int, in interface{}) (resp string, err error) {
 	if vm.emulatorCmd == nil {
 		return "", errors.New("emulator is not running yet")
 	}
@@ -486,7 +770,7 @@ func (vm *VM) executeQMP(in interface{}) (resp string, err error) {
 	}
 	// Send the input command
 	fmt.Fprintf(vm.emulatorDebugOutput, "Debug: request - %s\n", string(req))
-	_ = qmpConn.SetDeadline(time.Now().Add(QMPCommandResponseTimeoutSec * time.Second))
+	_ = qmpConn.SetDeadline(time.Now().Add(time.Duration(timeoutSec) * time.Second))
 	if err := qmpClient.PrintfLine(strings.ReplaceAll(string(req), "%", "%%")); err != nil {
 		fmt.Fprintf(vm.emulatorDebugOutput, "Error: failed to send command -  %v %s\n", err, string(resp))
 		// IO error often results in broken request/reply sequence, disconnect and reconnect on next use.
@@ -495,7 +779,7 @@ func (vm *VM) executeQMP(in interface{}) (resp string, err error) {
 		return "", err
 	}
 	// Read the command response. The QMP responses are most often useless.
-	resp, err = qmpClient.ReadLine()
+	resp, err = vm.readQMPReply(qmpClient)
 	fmt.Fprintf(vm.emulatorDebugOutput, "Debug: response - %v %s\n", err, string(resp))
 	if err != nil {
 		// IO error often results in broken request/reply sequence, disconnect and reconnect on next use.
@@ -510,6 +794,23 @@ func (vm *VM) executeQMP(in interface{}) (resp string, err error) {
 	return
 }
 
+// findQEMUImgExecutable is an internal function that helps to determine the executable location of QEMU disk image utility.
+func findQEMUImgExecutable() string {
+	for _, prefixDir := range strings.Split(platform.CommonPATH, ":") {
+		qemuImgPath := path.Join(prefixDir, QEMUImgExecutableName)
+		if _, err := os.Stat(qemuImgPath); err == nil {
+			return qemuImgPath
+		}
+	}
+	if misc.HostIsWindows() {
+		winQEMUImgPath := fmt.Sprintf(`C:\Program Files\qemu\%s.exe`, QEMUImgExecutableName)
+		if _, err := os.Stat(winQEMUImgPath); err == nil {
+			return winQEMUImgPath
+		}
+	}
+	return QEMUImgExecutableName
+}
+
 // findEmulatorExecutable is an internal function that helps to determine the executable location of KVM or QEMU on the host.
 func findEmulatorExecutable() string {
 	// Prefer to use the much-faster KVM if it is available
@@ -1,8 +1,13 @@
 package remotevm
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"net"
+	"net/textproto"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/HouzuoGuo/laitos/misc"
@@ -104,3 +109,83 @@ func TestVMInteractions(t *testing.T) {
 	vm.Kill()
 	vm.Kill()
 }
+
+// startFakeQMP starts a QMP server that answers human monitor commands, sending asynchronous events ahead of snapshot replies.
+func startFakeQMP(t *testing.T) (port int, listener net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(client *textproto.Conn) {
+				defer client.Close()
+				_ = client.PrintfLine(`{"QMP": {"version": {}, "capabilities": []}}`)
+				for {
+					line, err := client.ReadLine()
+					if err != nil {
+						return
+					}
+					var req struct {
+						Execute   string `json:"execute"`
+						Arguments struct {
+							CommandLine string `json:"command-line"`
+						} `json:"arguments"`
+					}
+					if err := json.Unmarshal([]byte(line), &req); err != nil || req.Execute != "human-monitor-command" {
+						_ = client.PrintfLine(`{"return": {}}`)
+						continue
+					}
+					switch cmd := req.Arguments.CommandLine; {
+					case strings.HasPrefix(cmd, "savevm "), strings.HasPrefix(cmd, "loadvm "):
+						_ = client.PrintfLine(`{"timestamp": {"seconds": 1, "microseconds": 2}, "event": "STOP"}`)
+						_ = client.PrintfLine(`{"timestamp": {"seconds": 1, "microseconds": 3}, "event": "RESUME"}`)
+						_ = client.PrintfLine(`{"return": ""}`)
+					case cmd == "delvm does-not-exist":
+						_ = client.PrintfLine(`{"return": "Error: snapshot not found\r\n"}`)
+					case cmd == "info snapshots":
+						_ = client.PrintfLine(`{"return": "ID TAG\r\n1 test-snapshot\r\n"}`)
+					default:
+						_ = client.PrintfLine(`{"error": {"class": "GenericError", "desc": "unknown command"}}`)
+					}
+				}
+			}(textproto.NewConn(conn))
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, listener
+}
+
+func TestVMSnapshotsWithFakeQMP(t *testing.T) {
+	port, listener := startFakeQMP(t)
+	defer listener.Close()
+	vm := VM{NumCPU: 1, MemSizeMB: 128, QMPPort: port, DiskFilePath: "/fake/disk.qcow2"}
+	if err := vm.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// Pretend that the emulator is running, the fake QMP server answers on its behalf.
+	vm.emulatorCmd = exec.Command("true")
+	// The events that precede snapshot replies are skipped
+	if err := vm.SaveSnapshot("test-snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.LoadSnapshot("test-snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := vm.ListSnapshots(); err != nil || !strings.Contains(out, "1 test-snapshot") {
+		t.Fatal(out, err)
+	}
+	if err := vm.DeleteSnapshot("does-not-exist"); err == nil || !strings.Contains(err.Error(), "snapshot not found") {
+		t.Fatal(err)
+	}
+	if _, err := vm.executeHMP("unknown", QMPCommandResponseTimeoutSec); err == nil || !strings.Contains(err.Error(), "protocol error") {
+		t.Fatal(err)
+	}
+	// The request/reply sequence stays intact after the errors
+	if err := vm.SaveSnapshot("test-snapshot"); err != nil {
+		t.Fatal(err)
+	}
+}
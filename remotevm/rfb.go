@@ -0,0 +1,599 @@
+package remotevm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/daemon/common"
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	// RFBProtocolVersion is the version of remote framebuffer protocol spoken by the server.
+	RFBProtocolVersion = "RFB 003.008\n"
+	// RFBDefaultFrameIntervalMilli is the default interval between screen captures while a client awaits an update.
+	RFBDefaultFrameIntervalMilli = 200
+	// RFBTileSize is the width and height of a square tile compared between consecutive frames to find changed areas.
+	RFBTileSize = 64
+	// RFBIOTimeoutSec is the maximum idle duration of an RFB client connection.
+	RFBIOTimeoutSec = 30 * 60
+	// RFBMaxClientCutTextLen is the maximum length of clipboard text accepted from a client.
+	RFBMaxClientCutTextLen = 1048576
+	// RFBDefaultScreenWidth is the width of the blank screen presented before the emulator starts.
+	RFBDefaultScreenWidth = 1024
+	// RFBDefaultScreenHeight is the height of the blank screen presented before the emulator starts.
+	RFBDefaultScreenHeight = 768
+
+	rfbSecurityVNCAuth          = 2
+	rfbEncodingRaw              = 0
+	rfbEncodingDesktopSize      = -223
+	rfbClientSetPixelFormat     = 0
+	rfbClientSetEncodings       = 2
+	rfbClientFramebufferRequest = 3
+	rfbClientKeyEvent           = 4
+	rfbClientPointerEvent       = 5
+	rfbClientCutText            = 6
+)
+
+// ErrRFBAuthFailed is returned when an RFB client fails to answer the authentication challenge.
+var ErrRFBAuthFailed = errors.New("authentication failed")
+
+/*
+RFBDisplay is the screen, keyboard, and pointer presented to RFB clients. VM satisfies the interface, and test cases
+may substitute it with a fake.
+*/
+type RFBDisplay interface {
+	// CaptureScreen returns the current content of the screen.
+	CaptureScreen() (*image.RGBA, error)
+	// PointerEvent moves the pointer to the screen coordinates and presses the buttons in the mask (bit 0 is left button).
+	PointerEvent(buttonMask byte, x, y int) error
+	// KeyEvent presses or releases the key identified by an X11 keysym.
+	KeyEvent(down bool, keysym uint32) error
+}
+
+// RFBPixelFormat describes how pixel colours are represented in framebuffer updates sent to a client.
+type RFBPixelFormat struct {
+	BitsPerPixel, Depth             byte
+	BigEndian, TrueColour           bool
+	RedMax, GreenMax, BlueMax       uint16
+	RedShift, GreenShift, BlueShift byte
+}
+
+// RFBDefaultPixelFormat is the 32-bit true colour format offered by the server, in which each pixel is laid out as B, G, R, 0.
+var RFBDefaultPixelFormat = RFBPixelFormat{
+	BitsPerPixel: 32, Depth: 24, TrueColour: true,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+// marshal returns the 16 bytes wire representation of the pixel format.
+func (format RFBPixelFormat) marshal() []byte {
+	buf := make([]byte, 16)
+	buf[0] = format.BitsPerPixel
+	buf[1] = format.Depth
+	if format.BigEndian {
+		buf[2] = 1
+	}
+	if format.TrueColour {
+		buf[3] = 1
+	}
+	binary.BigEndian.PutUint16(buf[4:], format.RedMax)
+	binary.BigEndian.PutUint16(buf[6:], format.GreenMax)
+	binary.BigEndian.PutUint16(buf[8:], format.BlueMax)
+	buf[10] = format.RedShift
+	buf[11] = format.GreenShift
+	buf[12] = format.BlueShift
+	return buf
+}
+
+// unmarshalRFBPixelFormat decodes a pixel format from its 16 bytes wire representation.
+func unmarshalRFBPixelFormat(buf []byte) RFBPixelFormat {
+	return RFBPixelFormat{
+		BitsPerPixel: buf[0],
+		Depth:        buf[1],
+		BigEndian:    buf[2] != 0,
+		TrueColour:   buf[3] != 0,
+		RedMax:       binary.BigEndian.Uint16(buf[4:]),
+		GreenMax:     binary.BigEndian.Uint16(buf[6:]),
+		BlueMax:      binary.BigEndian.Uint16(buf[8:]),
+		RedShift:     buf[10],
+		GreenShift:   buf[11],
+		BlueShift:    buf[12],
+	}
+}
+
+// validate returns an error if the server cannot produce pixels in the format.
+func (format RFBPixelFormat) validate() error {
+	if !format.TrueColour {
+		return errors.New("colour map is not supported")
+	}
+	if format.BitsPerPixel != 8 && format.BitsPerPixel != 16 && format.BitsPerPixel != 32 {
+		return fmt.Errorf("%d bits per pixel is not supported", format.BitsPerPixel)
+	}
+	return nil
+}
+
+// encodeRect appends the pixels of the rectangle from the image to the buffer in raw encoding.
+func (format RFBPixelFormat) encodeRect(buf []byte, img *image.RGBA, rect image.Rectangle) []byte {
+	bytesPerPixel := int(format.BitsPerPixel / 8)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			pixel := uint32(row[i])*uint32(format.RedMax)/255<<format.RedShift |
+				uint32(row[i+1])*uint32(format.GreenMax)/255<<format.GreenShift |
+				uint32(row[i+2])*uint32(format.BlueMax)/255<<format.BlueShift
+			for b := 0; b < bytesPerPixel; b++ {
+				if format.BigEndian {
+					buf = append(buf, byte(pixel>>(8*uint(bytesPerPixel-1-b))))
+				} else {
+					buf = append(buf, byte(pixel>>(8*uint(b))))
+				}
+			}
+		}
+	}
+	return buf
+}
+
+// rfbVNCAuthResponse returns the expected client response to the challenge of VNC authentication using the password.
+func rfbVNCAuthResponse(password string, challenge []byte) []byte {
+	// The DES key is the password truncated or padded to 8 bytes, the bits in each byte are in reverse order.
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		var reversed byte
+		for bit := 0; bit < 8; bit++ {
+			reversed = reversed<<1 | (b>>uint(bit))&1
+		}
+		key[i] = reversed
+	}
+	cipher, _ := des.NewCipher(key)
+	response := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		cipher.Encrypt(response[i:i+8], challenge[i:i+8])
+	}
+	return response
+}
+
+// findDirtyRects compares the tiles of two frames of identical size and returns the changed areas.
+func findDirtyRects(previous, current *image.RGBA) []image.Rectangle {
+	bounds := current.Bounds()
+	rects := make([]image.Rectangle, 0)
+	for tileY := bounds.Min.Y; tileY < bounds.Max.Y; tileY += RFBTileSize {
+		tileMaxY := tileY + RFBTileSize
+		if tileMaxY > bounds.Max.Y {
+			tileMaxY = bounds.Max.Y
+		}
+		// Adjacent changed tiles in the same row are merged into one rectangle
+		var dirty *image.Rectangle
+		for tileX := bounds.Min.X; tileX < bounds.Max.X; tileX += RFBTileSize {
+			tile := image.Rect(tileX, tileY, tileX+RFBTileSize, tileMaxY).Intersect(bounds)
+			changed := false
+			for y := tile.Min.Y; y < tile.Max.Y; y++ {
+				from, to := current.PixOffset(tile.Min.X, y), current.PixOffset(tile.Max.X, y)
+				if !bytes.Equal(previous.Pix[from:to], current.Pix[from:to]) {
+					changed = true
+					break
+				}
+			}
+			if changed {
+				if dirty == nil {
+					dirty = &tile
+				} else {
+					*dirty = dirty.Union(tile)
+				}
+			} else if dirty != nil {
+				rects = append(rects, *dirty)
+				dirty = nil
+			}
+		}
+		if dirty != nil {
+			rects = append(rects, *dirty)
+		}
+	}
+	return rects
+}
+
+/*
+RFBServer is a remote framebuffer (VNC) server that presents the display of a virtual machine to VNC clients. It sends
+changed areas of the screen to clients, and forwards their keyboard and pointer input into the virtual machine.
+Clients authenticate using the VNC password authentication scheme.
+*/
+type RFBServer struct {
+	ListenAddr         string     // ListenAddr is the IP address to listen on, it defaults to 127.0.0.1.
+	ListenPort         int        // ListenPort is the TCP port number to listen on.
+	Password           string     // Password authenticates clients, only the first 8 characters are significant.
+	FrameIntervalMilli int        // FrameIntervalMilli is the interval between screen captures while a client awaits an update.
+	PerIPLimit         int        // PerIPLimit is the maximum number of connections a client IP may make per second.
+	Display            RFBDisplay // Display is the screen, keyboard, and pointer shared with clients.
+
+	tcpServer *common.TCPServer
+	stats     *misc.Stats
+}
+
+// Initialise validates configuration and prepares internal states.
+func (srv *RFBServer) Initialise() error {
+	if srv.Password == "" {
+		return errors.New("RFBServer.Initialise: password must not be empty")
+	}
+	if srv.ListenAddr == "" {
+		srv.ListenAddr = "127.0.0.1"
+	}
+	if srv.FrameIntervalMilli < 1 {
+		srv.FrameIntervalMilli = RFBDefaultFrameIntervalMilli
+	}
+	if srv.PerIPLimit < 1 {
+		srv.PerIPLimit = 5
+	}
+	srv.stats = misc.NewStats()
+	srv.tcpServer = &common.TCPServer{
+		ListenAddr:  srv.ListenAddr,
+		ListenPort:  srv.ListenPort,
+		AppName:     "rfb",
+		App:         srv,
+		LimitPerSec: srv.PerIPLimit,
+	}
+	srv.tcpServer.Initialise()
+	return nil
+}
+
+// StartAndBlock accepts and serves RFB clients until the server is stopped.
+func (srv *RFBServer) StartAndBlock() error {
+	return srv.tcpServer.StartAndBlock()
+}
+
+// Stop the server from accepting new clients. Connected clients continue to be served.
+func (srv *RFBServer) Stop() {
+	srv.tcpServer.Stop()
+}
+
+func (srv *RFBServer) GetTCPStatsCollector() *misc.Stats {
+	return srv.stats
+}
+
+func (srv *RFBServer) HandleTCPConnection(logger lalog.Logger, ip string, client *net.TCPConn) {
+	session := &rfbSession{
+		server: srv,
+		conn:   client,
+		reader: bufio.NewReader(client),
+		logger: logger,
+		ip:     ip,
+		format: RFBDefaultPixelFormat,
+		mutex:  new(sync.Mutex),
+		wakeUp: make(chan struct{}, 1),
+	}
+	if err := session.handshake(); err != nil {
+		logger.Info("HandleTCPConnection", ip, err, "failed to complete handshake")
+		return
+	}
+	logger.Info("HandleTCPConnection", ip, nil, "client is connected")
+	done := make(chan struct{})
+	go func() {
+		if err := session.sendUpdates(done); err != nil {
+			logger.Info("HandleTCPConnection", ip, err, "stopped sending updates")
+		}
+		// Interrupt the pending read
+		_ = client.Close()
+	}()
+	err := session.readClientMessages()
+	close(done)
+	if err != nil && err != io.EOF {
+		logger.Info("HandleTCPConnection", ip, err, "client is disconnected")
+	}
+}
+
+// rfbSession is the conversation with a connected RFB client.
+type rfbSession struct {
+	server *RFBServer
+	conn   *net.TCPConn
+	reader *bufio.Reader
+	logger lalog.Logger
+	ip     string
+
+	// frame is the most recent screen content sent to the client.
+	frame *image.RGBA
+	// format, desktopSizeSupported, updateRequested, and fullUpdateRequested are shared by reader and writer.
+	format               RFBPixelFormat
+	desktopSizeSupported bool
+	updateRequested      bool
+	fullUpdateRequested  bool
+	mutex                *sync.Mutex
+	// wakeUp tells the writer that an update has been requested.
+	wakeUp chan struct{}
+}
+
+// captureScreen returns the latest screen content. If the display is unavailable, it returns the previous frame or a blank screen.
+func (session *rfbSession) captureScreen() *image.RGBA {
+	screen, err := session.server.Display.CaptureScreen()
+	if err == nil && screen != nil {
+		return screen
+	}
+	if session.frame != nil {
+		return session.frame
+	}
+	return image.NewRGBA(image.Rect(0, 0, RFBDefaultScreenWidth, RFBDefaultScreenHeight))
+}
+
+// handshake negotiates protocol version, authenticates the client, and exchanges initialisation messages.
+func (session *rfbSession) handshake() error {
+	if err := session.conn.SetDeadline(time.Now().Add(RFBIOTimeoutSec * time.Second)); err != nil {
+		return err
+	}
+	if _, err := session.conn.Write([]byte(RFBProtocolVersion)); err != nil {
+		return err
+	}
+	clientVersion := make([]byte, len(RFBProtocolVersion))
+	if _, err := io.ReadFull(session.reader, clientVersion); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(clientVersion), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("unsupported protocol version %q", clientVersion)
+	}
+	// Version 3.3 lets server decide the security type, later versions let client choose from a list.
+	if minor < 7 {
+		if err := binary.Write(session.conn, binary.BigEndian, uint32(rfbSecurityVNCAuth)); err != nil {
+			return err
+		}
+	} else {
+		if _, err := session.conn.Write([]byte{1, rfbSecurityVNCAuth}); err != nil {
+			return err
+		}
+		securityType, err := session.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if securityType != rfbSecurityVNCAuth {
+			return fmt.Errorf("unsupported security type %d", securityType)
+		}
+	}
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	if _, err := session.conn.Write(challenge); err != nil {
+		return err
+	}
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(session.reader, response); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(response, rfbVNCAuthResponse(session.server.Password, challenge)) != 1 {
+		_ = binary.Write(session.conn, binary.BigEndian, uint32(1))
+		if minor >= 8 {
+			reason := ErrRFBAuthFailed.Error()
+			_ = binary.Write(session.conn, binary.BigEndian, uint32(len(reason)))
+			_, _ = session.conn.Write([]byte(reason))
+		}
+		return ErrRFBAuthFailed
+	}
+	if err := binary.Write(session.conn, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	// The shared flag from client initialisation is irrelevant, all clients share the same display.
+	if _, err := session.reader.ReadByte(); err != nil {
+		return err
+	}
+	session.frame = session.captureScreen()
+	size := session.frame.Bounds().Size()
+	name := "laitos remote virtual machine"
+	serverInit := make([]byte, 4, 24+len(name))
+	binary.BigEndian.PutUint16(serverInit[0:], uint16(size.X))
+	binary.BigEndian.PutUint16(serverInit[2:], uint16(size.Y))
+	serverInit = append(serverInit, RFBDefaultPixelFormat.marshal()...)
+	serverInit = append(serverInit, 0, 0, 0, byte(len(name)))
+	serverInit = append(serverInit, name...)
+	_, err := session.conn.Write(serverInit)
+	return err
+}
+
+// readClientMessages reads and handles client messages until the connection is closed.
+func (session *rfbSession) readClientMessages() error {
+	for {
+		if err := session.conn.SetReadDeadline(time.Now().Add(RFBIOTimeoutSec * time.Second)); err != nil {
+			return err
+		}
+		msgType, err := session.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case rfbClientSetPixelFormat:
+			buf := make([]byte, 19)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			format := unmarshalRFBPixelFormat(buf[3:])
+			if err := format.validate(); err != nil {
+				return err
+			}
+			session.mutex.Lock()
+			session.format = format
+			session.mutex.Unlock()
+		case rfbClientSetEncodings:
+			buf := make([]byte, 3)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			encodings := make([]int32, binary.BigEndian.Uint16(buf[1:]))
+			if err := binary.Read(session.reader, binary.BigEndian, encodings); err != nil {
+				return err
+			}
+			session.mutex.Lock()
+			session.desktopSizeSupported = false
+			for _, encoding := range encodings {
+				if encoding == rfbEncodingDesktopSize {
+					session.desktopSizeSupported = true
+				}
+			}
+			session.mutex.Unlock()
+		case rfbClientFramebufferRequest:
+			// The requested area is disregarded, the server always sends changes across the entire screen.
+			buf := make([]byte, 9)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			session.mutex.Lock()
+			session.updateRequested = true
+			if buf[0] == 0 {
+				session.fullUpdateRequested = true
+			}
+			session.mutex.Unlock()
+			select {
+			case session.wakeUp <- struct{}{}:
+			default:
+			}
+		case rfbClientKeyEvent:
+			buf := make([]byte, 7)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			if err := session.server.Display.KeyEvent(buf[0] != 0, binary.BigEndian.Uint32(buf[3:])); err != nil {
+				session.logger.Info("readClientMessages", session.ip, err, "failed to forward key event")
+			}
+		case rfbClientPointerEvent:
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			if err := session.server.Display.PointerEvent(buf[0], int(binary.BigEndian.Uint16(buf[1:])), int(binary.BigEndian.Uint16(buf[3:]))); err != nil {
+				session.logger.Info("readClientMessages", session.ip, err, "failed to forward pointer event")
+			}
+		case rfbClientCutText:
+			// Clipboard text is not shared with the virtual machine
+			buf := make([]byte, 7)
+			if _, err := io.ReadFull(session.reader, buf); err != nil {
+				return err
+			}
+			textLen := binary.BigEndian.Uint32(buf[3:])
+			if textLen > RFBMaxClientCutTextLen {
+				return fmt.Errorf("clipboard text of %d bytes is too long", textLen)
+			}
+			if _, err := io.CopyN(ioutil.Discard, session.reader, int64(textLen)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported client message type %d", msgType)
+		}
+	}
+}
+
+// sendUpdates sends changed areas of the screen to the client whenever the client requests an update.
+func (session *rfbSession) sendUpdates(done <-chan struct{}) error {
+	ticker := time.NewTicker(time.Duration(session.server.FrameIntervalMilli) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-session.wakeUp:
+		case <-ticker.C:
+		}
+		session.mutex.Lock()
+		requested, full, format, desktopSizeSupported := session.updateRequested, session.fullUpdateRequested, session.format, session.desktopSizeSupported
+		session.mutex.Unlock()
+		if !requested {
+			continue
+		}
+		screen := session.captureScreen()
+		var rects []image.Rectangle
+		resized := !screen.Bounds().Eq(session.frame.Bounds())
+		if resized && !desktopSizeSupported {
+			return errors.New("screen size has changed but the client does not support resizing")
+		}
+		if full || resized {
+			rects = []image.Rectangle{screen.Bounds()}
+		} else {
+			rects = findDirtyRects(session.frame, screen)
+		}
+		// Keep the request outstanding until there is something new to show
+		if len(rects) == 0 {
+			continue
+		}
+		numRects := len(rects)
+		if resized {
+			numRects++
+		}
+		msg := make([]byte, 4, 1024)
+		binary.BigEndian.PutUint16(msg[2:], uint16(numRects))
+		if resized {
+			msg = appendRFBRectHeader(msg, screen.Bounds(), rfbEncodingDesktopSize)
+		}
+		for _, rect := range rects {
+			msg = appendRFBRectHeader(msg, rect, rfbEncodingRaw)
+			msg = format.encodeRect(msg, screen, rect)
+		}
+		session.mutex.Lock()
+		session.updateRequested = false
+		session.fullUpdateRequested = false
+		session.mutex.Unlock()
+		session.frame = screen
+		if err := session.conn.SetWriteDeadline(time.Now().Add(RFBIOTimeoutSec * time.Second)); err != nil {
+			return err
+		}
+		if _, err := session.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// appendRFBRectHeader appends the header of a framebuffer update rectangle to the buffer.
+func appendRFBRectHeader(buf []byte, rect image.Rectangle, encoding int32) []byte {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint16(header[0:], uint16(rect.Min.X))
+	binary.BigEndian.PutUint16(header[2:], uint16(rect.Min.Y))
+	binary.BigEndian.PutUint16(header[4:], uint16(rect.Dx()))
+	binary.BigEndian.PutUint16(header[6:], uint16(rect.Dy()))
+	binary.BigEndian.PutUint32(header[8:], uint32(encoding))
+	return append(buf, header...)
+}
+
+// rfbKeysymQCodes maps X11 keysyms of special keys to QEMU key codes.
+var rfbKeysymQCodes = map[uint32]string{
+	0xff08: "backspace", 0xff09: "tab", 0xff0d: "ret", 0xff13: "pause", 0xff14: "scroll_lock", 0xff1b: "esc",
+	0xff50: "home", 0xff51: "left", 0xff52: "up", 0xff53: "right", 0xff54: "down", 0xff55: "pgup", 0xff56: "pgdn",
+	0xff57: "end", 0xff61: "print", 0xff63: "insert", 0xff67: "menu", 0xff7f: "num_lock", 0xff8d: "kp_enter",
+	0xffe1: "shift", 0xffe2: "shift_r", 0xffe3: "ctrl", 0xffe4: "ctrl_r", 0xffe5: "caps_lock", 0xffe9: "alt",
+	0xffea: "alt_r", 0xfe03: "alt_r", 0xffeb: "meta_l", 0xffec: "meta_r", 0xffff: "delete",
+	0xffbe: "f1", 0xffbf: "f2", 0xffc0: "f3", 0xffc1: "f4", 0xffc2: "f5", 0xffc3: "f6",
+	0xffc4: "f7", 0xffc5: "f8", 0xffc6: "f9", 0xffc7: "f10", 0xffc8: "f11", 0xffc9: "f12",
+}
+
+/*
+rfbSymbolQCodes maps printable characters to the QEMU key codes of a US keyboard. Clients send the shift key
+separately, hence a shifted character maps to the key code of its unshifted key.
+*/
+var rfbSymbolQCodes = map[rune]string{
+	' ': "spc", '-': "minus", '_': "minus", '=': "equal", '+': "equal", '[': "bracket_left", '{': "bracket_left",
+	']': "bracket_right", '}': "bracket_right", '\\': "backslash", '|': "backslash", ';': "semicolon", ':': "semicolon",
+	'\'': "apostrophe", '"': "apostrophe", ',': "comma", '<': "comma", '.': "dot", '>': "dot", '/': "slash", '?': "slash",
+	'`': "grave_accent", '~': "grave_accent", '!': "1", '@': "2", '#': "3", '$': "4", '%': "5", '^': "6", '&': "7",
+	'*': "8", '(': "9", ')': "0",
+}
+
+// KeysymToQCode returns the QEMU key code of the X11 keysym, or false if the key is not supported.
+func KeysymToQCode(keysym uint32) (string, bool) {
+	switch {
+	case keysym >= 'a' && keysym <= 'z', keysym >= '0' && keysym <= '9':
+		return string(rune(keysym)), true
+	case keysym >= 'A' && keysym <= 'Z':
+		return string(rune(keysym - 'A' + 'a')), true
+	}
+	if code, found := rfbSymbolQCodes[rune(keysym)]; found && keysym < 0x80 {
+		return code, true
+	}
+	code, found := rfbKeysymQCodes[keysym]
+	return code, found
+}
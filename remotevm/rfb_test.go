@@ -0,0 +1,215 @@
+package remotevm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDisplay is an RFB display of solid colour that records input events.
+type fakeDisplay struct {
+	screen *image.RGBA
+	events []string
+	mutex  sync.Mutex
+}
+
+func (display *fakeDisplay) CaptureScreen() (*image.RGBA, error) {
+	display.mutex.Lock()
+	defer display.mutex.Unlock()
+	clone := image.NewRGBA(display.screen.Bounds())
+	copy(clone.Pix, display.screen.Pix)
+	return clone, nil
+}
+
+func (display *fakeDisplay) PointerEvent(buttonMask byte, x, y int) error {
+	display.mutex.Lock()
+	defer display.mutex.Unlock()
+	display.events = append(display.events, "pointer "+strconv.Itoa(int(buttonMask))+" "+strconv.Itoa(x)+" "+strconv.Itoa(y))
+	return nil
+}
+
+func (display *fakeDisplay) KeyEvent(down bool, keysym uint32) error {
+	display.mutex.Lock()
+	defer display.mutex.Unlock()
+	code, _ := KeysymToQCode(keysym)
+	display.events = append(display.events, "key "+strconv.FormatBool(down)+" "+code)
+	return nil
+}
+
+// testRFBClient connects to the RFB server and authenticates with the password.
+func testRFBClient(t *testing.T, port int, password string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(reader, version); err != nil || string(version) != RFBProtocolVersion {
+		t.Fatal(err, string(version))
+	}
+	_, _ = conn.Write([]byte(RFBProtocolVersion))
+	securityTypes := make([]byte, 2)
+	if _, err := io.ReadFull(reader, securityTypes); err != nil || securityTypes[0] != 1 || securityTypes[1] != rfbSecurityVNCAuth {
+		t.Fatal(err, securityTypes)
+	}
+	_, _ = conn.Write([]byte{rfbSecurityVNCAuth})
+	challenge := make([]byte, 16)
+	if _, err := io.ReadFull(reader, challenge); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write(rfbVNCAuthResponse(password, challenge))
+	var result uint32
+	if err := binary.Read(reader, binary.BigEndian, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != 0 {
+		_ = conn.Close()
+		return nil, nil, ErrRFBAuthFailed
+	}
+	// Client initialisation and server initialisation
+	_, _ = conn.Write([]byte{1})
+	serverInit := make([]byte, 24)
+	if _, err := io.ReadFull(reader, serverInit); err != nil {
+		t.Fatal(err)
+	}
+	if width, height := binary.BigEndian.Uint16(serverInit[0:]), binary.BigEndian.Uint16(serverInit[2:]); width != 100 || height != 80 {
+		t.Fatal(width, height)
+	}
+	name := make([]byte, binary.BigEndian.Uint32(serverInit[20:]))
+	if _, err := io.ReadFull(reader, name); err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, nil
+}
+
+// readTestRFBUpdate reads a framebuffer update and returns its rectangles and the pixel data of each.
+func readTestRFBUpdate(t *testing.T, conn net.Conn, reader *bufio.Reader, bytesPerPixel int) (rects []image.Rectangle, pixels [][]byte) {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != 0 {
+		t.Fatal(err, header)
+	}
+	for i := 0; i < int(binary.BigEndian.Uint16(header[2:])); i++ {
+		rectHeader := make([]byte, 12)
+		if _, err := io.ReadFull(reader, rectHeader); err != nil {
+			t.Fatal(err)
+		}
+		x, y := int(binary.BigEndian.Uint16(rectHeader[0:])), int(binary.BigEndian.Uint16(rectHeader[2:]))
+		rect := image.Rect(x, y, x+int(binary.BigEndian.Uint16(rectHeader[4:])), y+int(binary.BigEndian.Uint16(rectHeader[6:])))
+		if encoding := int32(binary.BigEndian.Uint32(rectHeader[8:])); encoding != rfbEncodingRaw {
+			t.Fatal(encoding)
+		}
+		data := make([]byte, rect.Dx()*rect.Dy()*bytesPerPixel)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			t.Fatal(err)
+		}
+		rects = append(rects, rect)
+		pixels = append(pixels, data)
+	}
+	return
+}
+
+func TestRFBServer(t *testing.T) {
+	display := &fakeDisplay{screen: image.NewRGBA(image.Rect(0, 0, 100, 80))}
+	for i := 0; i < len(display.screen.Pix); i += 4 {
+		copy(display.screen.Pix[i:], []byte{0x10, 0x20, 0x30, 0xff})
+	}
+	srv := RFBServer{ListenAddr: "127.0.0.1", ListenPort: 33891, FrameIntervalMilli: 20, Display: display}
+	if err := srv.Initialise(); err == nil {
+		t.Fatal("should not accept empty password")
+	}
+	srv.Password = "pass"
+	if err := srv.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := srv.StartAndBlock(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer srv.Stop()
+	time.Sleep(500 * time.Millisecond)
+
+	if _, _, err := testRFBClient(t, srv.ListenPort, "wrong"); err != ErrRFBAuthFailed {
+		t.Fatal(err)
+	}
+	conn, reader, err := testRFBClient(t, srv.ListenPort, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The full update uses the default pixel format - B, G, R, 0
+	_, _ = conn.Write([]byte{rfbClientFramebufferRequest, 0, 0, 0, 0, 0, 0, 100, 0, 80})
+	rects, pixels := readTestRFBUpdate(t, conn, reader, 4)
+	if len(rects) != 1 || rects[0] != image.Rect(0, 0, 100, 80) || pixels[0][0] != 0x30 || pixels[0][1] != 0x20 || pixels[0][2] != 0x10 {
+		t.Fatal(rects, pixels[0][:4])
+	}
+
+	// Switch to 16-bit big endian RGB565 and request an incremental update, only the changed tile is sent.
+	format := RFBPixelFormat{BitsPerPixel: 16, Depth: 16, BigEndian: true, TrueColour: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	_, _ = conn.Write(append([]byte{rfbClientSetPixelFormat, 0, 0, 0}, format.marshal()...))
+	_, _ = conn.Write([]byte{rfbClientFramebufferRequest, 1, 0, 0, 0, 0, 0, 100, 0, 80})
+	time.Sleep(200 * time.Millisecond)
+	display.mutex.Lock()
+	display.screen.SetRGBA(70, 70, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	display.mutex.Unlock()
+	rects, pixels = readTestRFBUpdate(t, conn, reader, 2)
+	if len(rects) != 1 || rects[0] != image.Rect(64, 64, 100, 80) {
+		t.Fatal(rects)
+	}
+	// Pixel (70, 70) is at (6, 6) of the rectangle that is 36 pixels wide
+	if offset := (6*36 + 6) * 2; pixels[0][offset] != 0xff || pixels[0][offset+1] != 0xff || pixels[0][0] != 0x08 || pixels[0][1] != 0xe5 {
+		t.Fatal(pixels[0][offset:offset+2], pixels[0][:2])
+	}
+
+	// Input events are forwarded to the display
+	_, _ = conn.Write([]byte{rfbClientPointerEvent, 1, 0, 12, 0, 34})
+	_, _ = conn.Write([]byte{rfbClientKeyEvent, 1, 0, 0, 0, 0, 0, 'A'})
+	_, _ = conn.Write([]byte{rfbClientKeyEvent, 0, 0, 0, 0, 0, 0xff, 0x0d})
+	// Clipboard text is discarded
+	_, _ = conn.Write([]byte{rfbClientCutText, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'})
+	_, _ = conn.Write([]byte{rfbClientPointerEvent, 0, 0, 1, 0, 2})
+	time.Sleep(200 * time.Millisecond)
+	display.mutex.Lock()
+	events := display.events
+	display.mutex.Unlock()
+	if len(events) != 4 || events[0] != "pointer 1 12 34" || events[1] != "key true a" || events[2] != "key false ret" || events[3] != "pointer 0 1 2" {
+		t.Fatal(events)
+	}
+}
+
+func TestFindDirtyRects(t *testing.T) {
+	previous := image.NewRGBA(image.Rect(0, 0, 200, 130))
+	current := image.NewRGBA(previous.Bounds())
+	if rects := findDirtyRects(previous, current); len(rects) != 0 {
+		t.Fatal(rects)
+	}
+	// Adjacent tiles in a row are merged
+	current.SetRGBA(10, 10, color.RGBA{R: 1})
+	current.SetRGBA(70, 10, color.RGBA{R: 1})
+	current.SetRGBA(199, 129, color.RGBA{R: 1})
+	rects := findDirtyRects(previous, current)
+	if len(rects) != 2 || rects[0] != image.Rect(0, 0, 128, 64) || rects[1] != image.Rect(192, 128, 200, 130) {
+		t.Fatal(rects)
+	}
+}
+
+func TestKeysymToQCode(t *testing.T) {
+	for keysym, code := range map[uint32]string{'a': "a", 'Z': "z", '5': "5", '%': "5", '?': "slash", ' ': "spc", 0xff08: "backspace", 0xffc9: "f12", 0xffe3: "ctrl"} {
+		if actual, found := KeysymToQCode(keysym); !found || actual != code {
+			t.Fatal(keysym, actual, found)
+		}
+	}
+	if _, found := KeysymToQCode(0x20ac); found {
+		t.Fatal("should not have found euro sign")
+	}
+	var _ RFBDisplay = &VM{}
+}
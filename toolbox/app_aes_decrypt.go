@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -21,7 +22,15 @@ var (
 	ErrBadAESDecryptParam     = errors.New(`example: shortcut key to_search`)
 )
 
-const OpensslSaltedContentOffset = 16 // openssl writes down irrelevant salt in position 8:16
+const (
+	OpensslSaltedContentOffset = 16 // openssl writes down irrelevant salt in position 8:16
+
+	/*
+		aesRandomBlockMagic begins the random block that Encrypt places in front of the plain content. In CBC mode, the
+		encrypted random block serves as a random IV of the content that follows.
+	*/
+	aesRandomBlockMagic = "\x00laitos\x01"
+)
 
 /*
 Attributes about an AES-256-CBC encrypted file.
@@ -60,6 +69,30 @@ func (file *AESEncryptedFile) Initialise() error {
 
 // Decrypt uses combination of encryption key from configuration and parameter to decrypt the entire file.
 func (file *AESEncryptedFile) Decrypt(keySuffix []byte) (plainContent []byte, err error) {
+	plainContent, _, _, err = file.decrypt(keySuffix)
+	return
+}
+
+/*
+DecryptAndVerifyKey decrypts the entire file like Decrypt, and in addition tells whether the key is known to be correct.
+The key is known to be correct when the decrypted content begins with the random block placed by Encrypt, as the block
+starts with a fixed magic. A file that has only ever been encrypted by openssl does not have the block, in which case
+the caller should verify the decrypted content by itself. An incorrect padding always results in an error.
+*/
+func (file *AESEncryptedFile) DecryptAndVerifyKey(keySuffix []byte) (plainContent []byte, keyVerified bool, err error) {
+	plainContent, paddingOK, keyVerified, err := file.decrypt(keySuffix)
+	if err == nil && !paddingOK {
+		return nil, false, fmt.Errorf("AESEncryptedFile.DecryptAndVerifyKey: the padding of decrypted \"%s\" is incorrect, is the key correct?", file.FilePath)
+	}
+	return
+}
+
+/*
+decrypt decrypts the entire file and returns the plain content without padding and random block. paddingOK is true if
+the content has a correct PKCS#7 padding, and hasRandomBlock is true if the content begins with the random block placed
+by Encrypt.
+*/
+func (file *AESEncryptedFile) decrypt(keySuffix []byte) (plainContent []byte, paddingOK, hasRandomBlock bool, err error) {
 	keyTogether := make([]byte, len(file.KeyPrefix)+len(keySuffix))
 	copy(keyTogether, file.KeyPrefix[:])
 	copy(keyTogether[len(file.KeyPrefix):], keySuffix[:])
@@ -67,12 +100,82 @@ func (file *AESEncryptedFile) Decrypt(keySuffix []byte) (plainContent []byte, er
 	if err != nil {
 		return
 	}
+	if (len(file.FileContent)-OpensslSaltedContentOffset)%aes.BlockSize != 0 {
+		err = fmt.Errorf("AESEncryptedFile.Decrypt: \"%s\" does not appear to be a file encrypted by openssl", file.FilePath)
+		return
+	}
 	decryptor := cipher.NewCBCDecrypter(aesCipher, file.IV)
-	plainContent = make([]byte, len(file.FileContent))
-	decryptor.CryptBlocks(plainContent, file.FileContent[16:])
+	plainContent = make([]byte, len(file.FileContent)-OpensslSaltedContentOffset)
+	decryptor.CryptBlocks(plainContent, file.FileContent[OpensslSaltedContentOffset:])
+	// Remove PKCS#7 padding. An incorrect key results in garbage padding, and the garbage content is left as-is.
+	if padLen := int(plainContent[len(plainContent)-1]); padLen > 0 && padLen <= aes.BlockSize {
+		if bytes.Equal(plainContent[len(plainContent)-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+			plainContent = plainContent[:len(plainContent)-padLen]
+			paddingOK = true
+		}
+	}
+	// Remove the random block placed by Encrypt
+	if len(plainContent) >= aes.BlockSize && bytes.HasPrefix(plainContent, []byte(aesRandomBlockMagic)) {
+		plainContent = plainContent[aes.BlockSize:]
+		hasRandomBlock = true
+	}
 	return
 }
 
+/*
+Encrypt uses combination of encryption key from configuration and parameter to encrypt the content, and overwrites the
+file with the content in the same format as openssl-enc. The original salt is retained so that the file remains
+decryptable by openssl using the original password.
+The IV from configuration is fixed, therefore the content is preceded by a block of random bytes, which makes the
+encrypted content differ every time even if the plain content remains the same. openssl reveals the random block as 16
+bytes of garbage in front of the content.
+The encrypted content is written into a temporary file first, which then replaces the file, so that a failure half way
+does not damage the file.
+*/
+func (file *AESEncryptedFile) Encrypt(keySuffix []byte, plainContent []byte) error {
+	keyTogether := make([]byte, len(file.KeyPrefix)+len(keySuffix))
+	copy(keyTogether, file.KeyPrefix[:])
+	copy(keyTogether[len(file.KeyPrefix):], keySuffix[:])
+	aesCipher, err := aes.NewCipher(keyTogether)
+	if err != nil {
+		return fmt.Errorf("AESEncryptedFile.Encrypt: invalid key - %v", err)
+	}
+	padLen := aes.BlockSize - len(plainContent)%aes.BlockSize
+	padded := make([]byte, aes.BlockSize+len(plainContent)+padLen)
+	copy(padded, aesRandomBlockMagic)
+	if _, err := rand.Read(padded[len(aesRandomBlockMagic):aes.BlockSize]); err != nil {
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to acquire random numbers - %v", err)
+	}
+	copy(padded[aes.BlockSize:], plainContent)
+	copy(padded[aes.BlockSize+len(plainContent):], bytes.Repeat([]byte{byte(padLen)}, padLen))
+	content := make([]byte, OpensslSaltedContentOffset+len(padded))
+	copy(content, file.FileContent[:OpensslSaltedContentOffset])
+	cipher.NewCBCEncrypter(aesCipher, file.IV).CryptBlocks(content[OpensslSaltedContentOffset:], padded)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file.FilePath), filepath.Base(file.FilePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to create temporary file - %v", err)
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to write file \"%s\" - %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to write file \"%s\" - %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to write file \"%s\" - %v", tmpFile.Name(), err)
+	}
+	if err := os.Rename(tmpFile.Name(), file.FilePath); err != nil {
+		return fmt.Errorf("AESEncryptedFile.Encrypt: failed to replace file \"%s\" - %v", file.FilePath, err)
+	}
+	file.FileContent = content
+	return nil
+}
+
 const AESDecryptTrigger = ".a" // AESDecryptTrigger is the trigger prefix string of AESDecrypt feature.
 
 // Decrypt AES-encrypted file and return lines sought by incoming command.
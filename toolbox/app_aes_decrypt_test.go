@@ -1,7 +1,10 @@
 package toolbox
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -64,3 +67,52 @@ func TestAESDecrypt_Execute(t *testing.T) {
 		t.Fatal(ret)
 	}
 }
+
+func TestAESEncryptedFile_Encrypt(t *testing.T) {
+	decrypt := GetTestAESDecrypt()
+	if err := decrypt.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	file := decrypt.EncryptedFiles[TestAESDecryptFileBetaName]
+	keySuffix := []byte{0x44, 0xa4}
+	content := []byte("abc\ndef\nghi\n0123456789abcdef")
+	// Encrypting the same content twice should result in different encrypted content
+	if err := file.Encrypt(keySuffix, content); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ioutil.ReadFile(file.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := file.Decrypt(keySuffix); err != nil || !bytes.Equal(decrypted, content) {
+		t.Fatal(err, string(decrypted))
+	}
+	if err := file.Encrypt(keySuffix, content); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ioutil.ReadFile(file.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != len(second) || bytes.Equal(first, second) {
+		t.Fatal("encrypted content did not change")
+	}
+	// The salt of openssl remains intact
+	if !bytes.Equal(first[:OpensslSaltedContentOffset], second[:OpensslSaltedContentOffset]) {
+		t.Fatal("salt changed")
+	}
+	if decrypted, err := file.Decrypt(keySuffix); err != nil || !bytes.Equal(decrypted, content) {
+		t.Fatal(err, string(decrypted))
+	}
+	// The key is verified by the random block placed by Encrypt
+	if decrypted, verified, err := file.DecryptAndVerifyKey(keySuffix); err != nil || !verified || !bytes.Equal(decrypted, content) {
+		t.Fatal(err, verified, string(decrypted))
+	}
+	if _, verified, err := file.DecryptAndVerifyKey([]byte{0x44, 0xa5}); err == nil && verified {
+		t.Fatal("should not have verified an incorrect key")
+	}
+	// No temporary file is left behind
+	if matches, err := filepath.Glob(file.FilePath + ".tmp*"); err != nil || len(matches) != 0 {
+		t.Fatal(err, matches)
+	}
+}
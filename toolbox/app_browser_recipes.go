@@ -0,0 +1,322 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/HouzuoGuo/laitos/browser/chromium"
+	"github.com/HouzuoGuo/laitos/browser/phantomjs"
+)
+
+const (
+	BrowserRecipesTrigger                = ".bx" // BrowserRecipesTrigger is the trigger prefix string of BrowserRecipes feature.
+	BrowserRecipeDefaultStepTimeoutSec   = 30    // BrowserRecipeDefaultStepTimeoutSec is the default time limit of a recipe step.
+	BrowserRecipeViewWidth               = 1280  // BrowserRecipeViewWidth is the width of browser view port used by recipes.
+	BrowserRecipeViewHeight              = 1024  // BrowserRecipeViewHeight is the height of browser view port used by recipes.
+	BrowserRecipeActionGoTo              = "goto"
+	BrowserRecipeActionFill              = "fill"
+	BrowserRecipeActionClick             = "click"
+	BrowserRecipeActionWait              = "wait"
+	BrowserRecipeActionExtract           = "extract"
+	browserRecipeCredentialFieldUsername = "username"
+	browserRecipeCredentialFieldSecret   = "secret"
+	browserRecipeCredentialFieldNotes    = "notes"
+)
+
+var (
+	// RegexBrowserRecipeCredential finds credential placeholders such as "{{bank.username}}" in recipe steps.
+	RegexBrowserRecipeCredential = regexp.MustCompile(`\{\{\s*([^{}\s]+)\.(username|secret|notes)\s*\}\}`)
+
+	ErrBadBrowserRecipeParam      = errors.New(`example: recipe-name [vault-password]`)
+	ErrBrowserRecipeNotFound      = errors.New("cannot find the recipe")
+	ErrBrowserRecipeNeedsPassword = errors.New("the recipe uses credentials, please give the vault password after recipe name")
+)
+
+// BrowserRecipeStep is a single browser action in a recipe.
+type BrowserRecipeStep struct {
+	// Action is one of: goto, fill, click, wait, extract.
+	Action string `json:"Action"`
+	// URL is the page to visit by "goto" action.
+	URL string `json:"URL"`
+	// Selector is the CSS selector of the element to fill, click, wait for, or extract text from.
+	Selector string `json:"Selector"`
+	// Value is the text to fill into the element, it may use credential placeholders such as "{{bank.secret}}".
+	Value string `json:"Value"`
+	// Label optionally prefixes the extracted text in the output.
+	Label string `json:"Label"`
+	// TimeoutSec is the time limit of this step, including the time spent waiting for page load and the element to appear.
+	TimeoutSec int `json:"TimeoutSec"`
+}
+
+// validate returns an error if the step is missing a parameter required by its action.
+func (step *BrowserRecipeStep) validate() error {
+	switch step.Action {
+	case BrowserRecipeActionGoTo:
+		if !strings.HasPrefix(step.URL, "http://") && !strings.HasPrefix(step.URL, "https://") {
+			return errors.New("goto needs an http or https URL")
+		}
+	case BrowserRecipeActionFill, BrowserRecipeActionClick, BrowserRecipeActionWait, BrowserRecipeActionExtract:
+		if step.Selector == "" {
+			return fmt.Errorf("%s needs a selector", step.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action \"%s\"", step.Action)
+	}
+	return nil
+}
+
+// BrowserRecipe is a named sequence of browser actions that conclude by extracting text from web pages.
+type BrowserRecipe struct {
+	Description string              `json:"Description"` // Description briefly tells what the recipe does.
+	Steps       []BrowserRecipeStep `json:"Steps"`       // Steps are carried out in order.
+}
+
+// credentialPlaceholders returns the vault entry names that the recipe's credential placeholders refer to.
+func (recipe *BrowserRecipe) credentialPlaceholders() []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, step := range recipe.Steps {
+		for _, match := range RegexBrowserRecipeCredential.FindAllStringSubmatch(step.URL+" "+step.Value, -1) {
+			if !seen[strings.ToLower(match[1])] {
+				seen[strings.ToLower(match[1])] = true
+				names = append(names, match[1])
+			}
+		}
+	}
+	return names
+}
+
+/*
+BrowserRecipeDriver is the browser that carries out recipe steps. It is satisfied by chromium.Instance, and test cases
+may substitute it with a fake.
+*/
+type BrowserRecipeDriver interface {
+	GoTo(userAgent, pageURL string, width, height int) error
+	WaitForLoad(ctx context.Context, timeoutSec int) error
+	WaitForSelector(ctx context.Context, selector string, timeoutSec int) error
+	SetValueBySelector(selector, value string) error
+	ClickSelector(selector string) error
+	GetTextBySelector(selector string) (string, error)
+}
+
+/*
+BrowserRecipes runs named, pre-configured sequences of browser actions in a headless Chromium browser and responds with
+only the text extracted along the way. Compared to the interactive browser apps, a recipe saves many round trips over
+slow communication channels. Credentials used by recipes are stored in an encrypted secret vault file.
+*/
+type BrowserRecipes struct {
+	Browsers            *chromium.Instances      `json:"Browsers"`            // Browsers configure and manage headless Chromium processes.
+	Recipes             map[string]BrowserRecipe `json:"Recipes"`             // Recipes are keyed by their name.
+	UserAgent           string                   `json:"UserAgent"`           // UserAgent is the browser user agent used by recipes.
+	VaultFilePath       string                   `json:"VaultFilePath"`       // VaultFilePath is the secret vault file that has the credentials used by recipes.
+	VaultPasswordPrefix string                   `json:"VaultPasswordPrefix"` // VaultPasswordPrefix is prepended to the vault password given in the command.
+
+	recipes map[string]*BrowserRecipe // recipes are keyed by lower case recipe name.
+	mutex   *sync.Mutex               // mutex allows only one recipe to run at a time.
+}
+
+func (bro *BrowserRecipes) IsConfigured() bool {
+	return bro.Browsers != nil && bro.Browsers.BasePortNumber != 0 && len(bro.Recipes) > 0
+}
+
+func (bro *BrowserRecipes) SelfTest() error {
+	if !bro.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	if bro.Browsers.ExecutablePath == "" {
+		if _, err := chromium.FindExecutable(); err != nil {
+			return fmt.Errorf("BrowserRecipes.SelfTest: %v", err)
+		}
+	} else if _, err := os.Stat(bro.Browsers.ExecutablePath); err != nil {
+		return fmt.Errorf("BrowserRecipes.SelfTest: Chromium executable is not accessible - %v", err)
+	}
+	if bro.VaultFilePath != "" {
+		if _, err := os.Stat(bro.VaultFilePath); err != nil {
+			return fmt.Errorf("BrowserRecipes.SelfTest: vault file \"%s\" is not readable - %v", bro.VaultFilePath, err)
+		}
+	}
+	return nil
+}
+
+func (bro *BrowserRecipes) Initialise() error {
+	bro.recipes = make(map[string]*BrowserRecipe)
+	for name, recipe := range bro.Recipes {
+		if strings.ContainsAny(name, " \t\r\n") || name == "" {
+			return fmt.Errorf("BrowserRecipes.Initialise: recipe name \"%s\" must be a single word", name)
+		}
+		if len(recipe.Steps) == 0 {
+			return fmt.Errorf("BrowserRecipes.Initialise: recipe \"%s\" does not have steps", name)
+		}
+		for i := range recipe.Steps {
+			if err := recipe.Steps[i].validate(); err != nil {
+				return fmt.Errorf("BrowserRecipes.Initialise: recipe \"%s\" step %d - %v", name, i+1, err)
+			}
+		}
+		recipe := recipe
+		if len(recipe.credentialPlaceholders()) > 0 && bro.VaultFilePath == "" {
+			return fmt.Errorf("BrowserRecipes.Initialise: recipe \"%s\" uses credentials, VaultFilePath must be configured", name)
+		}
+		bro.recipes[strings.ToLower(name)] = &recipe
+	}
+	if bro.UserAgent == "" {
+		bro.UserAgent = phantomjs.GoodUserAgent
+	}
+	// Each recipe runs in its own browser, which is killed after the recipe finishes.
+	bro.mutex = new(sync.Mutex)
+	bro.Browsers.MaxInstances = 1
+	if err := bro.Browsers.Initialise(); err != nil {
+		return fmt.Errorf("BrowserRecipes.Initialise: failed to initialise Chromium lifecycle manager - %v", err)
+	}
+	return nil
+}
+
+func (bro *BrowserRecipes) Trigger() Trigger {
+	return BrowserRecipesTrigger
+}
+
+// listRecipes returns recipe names and descriptions, one recipe per line.
+func (bro *BrowserRecipes) listRecipes() string {
+	names := make([]string, 0, len(bro.Recipes))
+	for name := range bro.Recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out bytes.Buffer
+	for _, name := range names {
+		recipe := bro.Recipes[name]
+		if len(recipe.credentialPlaceholders()) > 0 {
+			out.WriteString(fmt.Sprintf("%s (needs password) - %s\n", name, recipe.Description))
+		} else {
+			out.WriteString(fmt.Sprintf("%s - %s\n", name, recipe.Description))
+		}
+	}
+	return out.String()
+}
+
+// loadCredentials returns the credential placeholder values of the recipe, read from the secret vault.
+func (bro *BrowserRecipes) loadCredentials(recipe *BrowserRecipe, password string) (map[string]string, error) {
+	credentials := make(map[string]string)
+	entryNames := recipe.credentialPlaceholders()
+	if len(entryNames) == 0 {
+		return credentials, nil
+	}
+	if password == "" {
+		return nil, ErrBrowserRecipeNeedsPassword
+	}
+	vault := SecretVault{FilePath: bro.VaultFilePath}
+	content, err := vault.load([]byte(bro.VaultPasswordPrefix + password))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range entryNames {
+		entry := content.find(name)
+		if entry == nil {
+			return nil, fmt.Errorf("cannot find vault entry \"%s\"", name)
+		}
+		key := strings.ToLower(name)
+		credentials[key+"."+browserRecipeCredentialFieldUsername] = entry.Username
+		credentials[key+"."+browserRecipeCredentialFieldSecret] = entry.Secret
+		credentials[key+"."+browserRecipeCredentialFieldNotes] = entry.Notes
+	}
+	return credentials, nil
+}
+
+// fillCredentials replaces credential placeholders in the text by their values.
+func fillCredentials(text string, credentials map[string]string) string {
+	return RegexBrowserRecipeCredential.ReplaceAllStringFunc(text, func(placeholder string) string {
+		match := RegexBrowserRecipeCredential.FindStringSubmatch(placeholder)
+		return credentials[strings.ToLower(match[1])+"."+match[2]]
+	})
+}
+
+/*
+RunBrowserRecipe carries out recipe steps in the browser and returns the extracted text. The recipe stops at the first
+failed step, and the error tells the step number.
+*/
+func RunBrowserRecipe(ctx context.Context, driver BrowserRecipeDriver, userAgent string, recipe *BrowserRecipe, credentials map[string]string) (string, error) {
+	extracted := make([]string, 0)
+	for i, step := range recipe.Steps {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("recipe did not complete by step %d - %v", i+1, err)
+		}
+		timeoutSec := step.TimeoutSec
+		if timeoutSec < 1 {
+			timeoutSec = BrowserRecipeDefaultStepTimeoutSec
+		}
+		var err error
+		switch step.Action {
+		case BrowserRecipeActionGoTo:
+			if err = driver.GoTo(userAgent, fillCredentials(step.URL, credentials), BrowserRecipeViewWidth, BrowserRecipeViewHeight); err == nil {
+				err = driver.WaitForLoad(ctx, timeoutSec)
+			}
+		case BrowserRecipeActionFill:
+			if err = driver.WaitForSelector(ctx, step.Selector, timeoutSec); err == nil {
+				err = driver.SetValueBySelector(step.Selector, fillCredentials(step.Value, credentials))
+			}
+		case BrowserRecipeActionClick:
+			if err = driver.WaitForSelector(ctx, step.Selector, timeoutSec); err == nil {
+				err = driver.ClickSelector(step.Selector)
+			}
+		case BrowserRecipeActionWait:
+			err = driver.WaitForSelector(ctx, step.Selector, timeoutSec)
+		case BrowserRecipeActionExtract:
+			var text string
+			if err = driver.WaitForSelector(ctx, step.Selector, timeoutSec); err == nil {
+				if text, err = driver.GetTextBySelector(step.Selector); err == nil {
+					if step.Label != "" {
+						text = step.Label + ": " + text
+					}
+					extracted = append(extracted, text)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown action \"%s\"", step.Action)
+		}
+		if err != nil {
+			return "", fmt.Errorf("recipe step %d (%s) failed - %v", i+1, step.Action, err)
+		}
+	}
+	if len(extracted) == 0 {
+		return "done", nil
+	}
+	return strings.Join(extracted, "\n"), nil
+}
+
+func (bro *BrowserRecipes) Execute(ctx context.Context, cmd Command) *Result {
+	if errResult := cmd.Trim(); errResult != nil {
+		return &Result{Output: bro.listRecipes()}
+	}
+	params := strings.Fields(cmd.Content)
+	if len(params) > 2 {
+		return &Result{Error: ErrBadBrowserRecipeParam}
+	}
+	recipe, exists := bro.recipes[strings.ToLower(params[0])]
+	if !exists {
+		return &Result{Error: ErrBrowserRecipeNotFound}
+	}
+	var password string
+	if len(params) == 2 {
+		password = params[1]
+	}
+	credentials, err := bro.loadCredentials(recipe, password)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	// Only one recipe may run at a time
+	bro.mutex.Lock()
+	defer bro.mutex.Unlock()
+	_, instance, err := bro.Browsers.Acquire()
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer instance.Kill()
+	output, err := RunBrowserRecipe(ctx, instance, bro.UserAgent, recipe, credentials)
+	return &Result{Error: err, Output: output}
+}
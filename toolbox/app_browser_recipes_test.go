@@ -0,0 +1,164 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/browser/chromium"
+)
+
+// fakeRecipeDriver records the browser actions carried out by a recipe.
+type fakeRecipeDriver struct {
+	actions []string
+	pages   map[string]string
+}
+
+func (driver *fakeRecipeDriver) GoTo(userAgent, pageURL string, width, height int) error {
+	driver.actions = append(driver.actions, "goto "+pageURL)
+	return nil
+}
+
+func (driver *fakeRecipeDriver) WaitForLoad(ctx context.Context, timeoutSec int) error {
+	return nil
+}
+
+func (driver *fakeRecipeDriver) WaitForSelector(ctx context.Context, selector string, timeoutSec int) error {
+	if selector == "#missing" {
+		return errors.New("did not appear")
+	}
+	return nil
+}
+
+func (driver *fakeRecipeDriver) SetValueBySelector(selector, value string) error {
+	driver.actions = append(driver.actions, "fill "+selector+" "+value)
+	return nil
+}
+
+func (driver *fakeRecipeDriver) ClickSelector(selector string) error {
+	driver.actions = append(driver.actions, "click "+selector)
+	return nil
+}
+
+func (driver *fakeRecipeDriver) GetTextBySelector(selector string) (string, error) {
+	return driver.pages[selector], nil
+}
+
+func TestRunBrowserRecipe(t *testing.T) {
+	recipe := &BrowserRecipe{Steps: []BrowserRecipeStep{
+		{Action: BrowserRecipeActionGoTo, URL: "https://example.com/login?user={{Bank.username}}"},
+		{Action: BrowserRecipeActionFill, Selector: "#password", Value: "{{bank.secret}}"},
+		{Action: BrowserRecipeActionClick, Selector: "#submit"},
+		{Action: BrowserRecipeActionWait, Selector: "#balance"},
+		{Action: BrowserRecipeActionExtract, Selector: "#balance", Label: "Balance"},
+		{Action: BrowserRecipeActionExtract, Selector: ".news"},
+	}}
+	if names := recipe.credentialPlaceholders(); len(names) != 1 || names[0] != "Bank" {
+		t.Fatal(names)
+	}
+	driver := &fakeRecipeDriver{pages: map[string]string{"#balance": "$100", ".news": "a\nb"}}
+	credentials := map[string]string{"bank.username": "alice", "bank.secret": "pass word"}
+	out, err := RunBrowserRecipe(context.Background(), driver, "agent", recipe, credentials)
+	if err != nil || out != "Balance: $100\na\nb" {
+		t.Fatal(out, err)
+	}
+	if actions := strings.Join(driver.actions, ","); actions != "goto https://example.com/login?user=alice,fill #password pass word,click #submit" {
+		t.Fatal(actions)
+	}
+	// A recipe without extraction
+	if out, err := RunBrowserRecipe(context.Background(), driver, "agent", &BrowserRecipe{Steps: recipe.Steps[:3]}, credentials); err != nil || out != "done" {
+		t.Fatal(out, err)
+	}
+	// A failed step stops the recipe
+	driver.actions = nil
+	failing := &BrowserRecipe{Steps: []BrowserRecipeStep{recipe.Steps[2], {Action: BrowserRecipeActionClick, Selector: "#missing"}, recipe.Steps[2]}}
+	if _, err := RunBrowserRecipe(context.Background(), driver, "agent", failing, nil); err == nil || !strings.Contains(err.Error(), "step 2") || len(driver.actions) != 1 {
+		t.Fatal(err, driver.actions)
+	}
+	// Cancelled context stops the recipe
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := RunBrowserRecipe(ctx, driver, "agent", recipe, credentials); err == nil || !strings.Contains(err.Error(), "step 1") {
+		t.Fatal(err)
+	}
+}
+
+func TestBrowserRecipes_Execute(t *testing.T) {
+	bro := BrowserRecipes{}
+	if bro.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	if err := bro.SelfTest(); err != ErrIncompleteConfig {
+		t.Fatal(err)
+	}
+	vault := GetTestSecretVault()
+	defer os.Remove(vault.FilePath)
+	if err := vault.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := vault.Execute(context.Background(), Command{TimeoutSec: 10, Content: "pass add bank username=alice secret=abc"}); ret.Error != nil {
+		t.Fatal(ret)
+	}
+	bro = BrowserRecipes{
+		Browsers: &chromium.Instances{BasePortNumber: 51300},
+		Recipes: map[string]BrowserRecipe{
+			"Balance": {Description: "check balance", Steps: []BrowserRecipeStep{
+				{Action: BrowserRecipeActionGoTo, URL: "https://example.com"},
+				{Action: BrowserRecipeActionFill, Selector: "#user", Value: "{{bank.username}}"},
+				{Action: BrowserRecipeActionExtract, Selector: "#balance"},
+			}},
+			"news": {Description: "read news", Steps: []BrowserRecipeStep{
+				{Action: BrowserRecipeActionExtract, Selector: ".news"},
+			}},
+		},
+		VaultFilePath:       vault.FilePath,
+		VaultPasswordPrefix: vault.PasswordPrefix,
+	}
+	if !bro.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	if err := bro.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	run := func(content string) *Result {
+		return bro.Execute(context.Background(), Command{TimeoutSec: 10, Content: content})
+	}
+	if ret := run(""); ret.Error != nil || ret.Output != "Balance (needs password) - check balance\nnews - read news\n" {
+		t.Fatal(ret)
+	}
+	if ret := run("balance pass extra"); ret.Error != ErrBadBrowserRecipeParam {
+		t.Fatal(ret)
+	}
+	if ret := run("does-not-exist"); ret.Error != ErrBrowserRecipeNotFound {
+		t.Fatal(ret)
+	}
+	if ret := run("balance"); ret.Error != ErrBrowserRecipeNeedsPassword {
+		t.Fatal(ret)
+	}
+	if ret := run("balance wrong-password"); ret.Error == nil {
+		t.Fatal("should not have decrypted vault")
+	}
+	// Resolve credentials using the correct vault password
+	credentials, err := bro.loadCredentials(bro.recipes["balance"], "pass")
+	if err != nil || credentials["bank.username"] != "alice" || credentials["bank.secret"] != "abc" {
+		t.Fatal(credentials, err)
+	}
+	missingEntry := &BrowserRecipe{Steps: []BrowserRecipeStep{{Action: BrowserRecipeActionFill, Selector: "#a", Value: "{{email.secret}}"}}}
+	if _, err := bro.loadCredentials(missingEntry, "pass"); err == nil || !strings.Contains(err.Error(), "email") {
+		t.Fatal(err)
+	}
+
+	// Bad recipes are rejected during initialisation
+	for _, bad := range []BrowserRecipeStep{
+		{Action: BrowserRecipeActionGoTo, URL: "file:///etc/passwd"},
+		{Action: BrowserRecipeActionClick},
+		{Action: "dance", Selector: "#a"},
+	} {
+		bro.Recipes = map[string]BrowserRecipe{"bad": {Steps: []BrowserRecipeStep{bad}}}
+		if err := bro.Initialise(); err == nil {
+			t.Fatal("did not error", bad)
+		}
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/browser/chromium"
 	"github.com/HouzuoGuo/laitos/browser/phantomjs"
 )
 
@@ -33,19 +36,30 @@ func FormatElementInfoArrayPhantomJS(elements []phantomjs.ElementInfo) string {
 
 // BrowserPhantomJS offers remote control to exactly one PhantomJS page.
 type BrowserPhantomJS struct {
-	Renderers *phantomjs.Instances `json:"Browsers"` // Instances configure and manage phantomJS processes.
-	renderer  *phantomjs.Instance  // renderer is the one and only browser instance tied to this feature
-	mutex     *sync.Mutex          // mutex protects renderer from concurrent access.
+	Renderers *phantomjs.Instances `json:"Browsers"` // Instances configure and manage PhantomJS processes.
+	// Chromium replaces PhantomJS by headless Chromium when it is configured.
+	Chromium  *chromium.Instances `json:"Chromium"`
+	renderers browser.Renderers   // renderers is either Renderers or Chromium, whichever is in use.
+	renderer  browser.Renderer    // renderer is the one and only browser instance tied to this feature
+	mutex     *sync.Mutex         // mutex protects renderer from concurrent access.
 }
 
 func (bro *BrowserPhantomJS) IsConfigured() bool {
-	return bro.Renderers != nil && bro.Renderers.BasePortNumber != 0
+	return bro.Renderers != nil && bro.Renderers.BasePortNumber != 0 || bro.usesChromium()
+}
+
+// usesChromium returns true only if headless Chromium is configured to replace PhantomJS.
+func (bro *BrowserPhantomJS) usesChromium() bool {
+	return bro.Chromium != nil && bro.Chromium.BasePortNumber != 0
 }
 
 func (bro *BrowserPhantomJS) SelfTest() error {
 	if !bro.IsConfigured() {
 		return ErrIncompleteConfig
 	}
+	if bro.usesChromium() {
+		return nil
+	}
 	if err := bro.Renderers.TestPhantomJSExecutable(); err != nil {
 		return fmt.Errorf("BrowserPhantomJS.SelfTest: there is an error with PhantomJS executable - %v", err)
 
@@ -59,10 +73,19 @@ func (bro *BrowserPhantomJS) Initialise() error {
 		have one instance.
 	*/
 	bro.mutex = new(sync.Mutex)
+	if bro.usesChromium() {
+		bro.Chromium.MaxInstances = 1
+		if err := bro.Chromium.Initialise(); err != nil {
+			return fmt.Errorf("BrowserPhantomJS.Initialise: failed to initialise Chromium lifecycle manager - %v", err)
+		}
+		bro.renderers = bro.Chromium
+		return nil
+	}
 	bro.Renderers.MaxInstances = 1
 	if err := bro.Renderers.Initialise(); err != nil {
 		return fmt.Errorf("BrowserPhantomJS.Initialise: failed to initialise phantomJS lifecycle manager - %v", err)
 	}
+	bro.renderers = bro.Renderers
 	return nil
 }
 
@@ -79,12 +102,12 @@ func (bro *BrowserPhantomJS) Execute(ctx context.Context, cmd Command) (ret *Res
 	defer bro.mutex.Unlock()
 	if bro.renderer != nil {
 		// The retrieved instance may be nil if it was killed due to timeout.
-		bro.renderer = bro.Renderers.Retrieve(bro.renderer.Index, bro.renderer.Tag)
+		bro.renderer = bro.renderers.RetrieveRenderer(bro.renderer.GetIndex(), bro.renderer.GetTag())
 	}
 	// Start a new instance if the previous instance is gone or was never started
 	if bro.renderer == nil {
 		var err error
-		_, bro.renderer, err = bro.Renderers.Acquire()
+		_, bro.renderer, err = bro.renderers.AcquireRenderer()
 		if err != nil {
 			return &Result{Error: err}
 		}
@@ -96,6 +119,7 @@ func (bro *BrowserPhantomJS) Execute(ctx context.Context, cmd Command) (ret *Res
 	}
 	var output string
 	var err error
+	var attachments []ResultAttachment
 	switch params[1] {
 	case "f":
 		// Go forward
@@ -179,8 +203,13 @@ func (bro *BrowserPhantomJS) Execute(ctx context.Context, cmd Command) (ret *Res
 		// Press backspace key on currently focused element
 		err = bro.renderer.SendKey("", phantomjs.KeyCodeBackspace)
 	case "render":
-		// For debugging purpose, render the page screenshot.
-		err = bro.renderer.RenderPage()
+		// Render the page screenshot, which is delivered to user by daemons that are capable of transporting files.
+		if err = bro.renderer.RenderPage(); err == nil {
+			var screenshot []byte
+			if screenshot, err = ioutil.ReadFile(bro.renderer.GetRenderPageFilePath()); err == nil {
+				attachments = []ResultAttachment{{FileName: "screenshot.png", ContentType: "image/png", Content: screenshot}}
+			}
+		}
 	default:
 		err = ErrBadBrowserParam
 	}
@@ -194,5 +223,5 @@ func (bro *BrowserPhantomJS) Execute(ctx context.Context, cmd Command) (ret *Res
 			err = fmt.Errorf("command was successful, but failed to get page info - %v", err)
 		}
 	}
-	return &Result{Error: err, Output: output}
+	return &Result{Error: err, Output: output, Attachments: attachments}
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/HouzuoGuo/laitos/browser"
+	"github.com/HouzuoGuo/laitos/browser/chromium"
 	"github.com/HouzuoGuo/laitos/browser/phantomjs"
 	"github.com/HouzuoGuo/laitos/browser/slimerjs"
 )
@@ -27,13 +30,21 @@ func FormatElementInfoArraySlimerJS(elements []slimerjs.ElementInfo) string {
 
 // BrowserSlimerJS offers remote control to exactly one SlimerJS page.
 type BrowserSlimerJS struct {
-	Renderers *slimerjs.Instances `json:"Browsers"` // Instances configure and manage phantomJS processes.
-	renderer  *slimerjs.Instance  // renderer is the one and only browser instance tied to this feature
+	Renderers *slimerjs.Instances `json:"Browsers"` // Instances configure and manage SlimerJS processes.
+	// Chromium replaces SlimerJS by headless Chromium when it is configured.
+	Chromium  *chromium.Instances `json:"Chromium"`
+	renderers browser.Renderers   // renderers is either Renderers or Chromium, whichever is in use.
+	renderer  browser.Renderer    // renderer is the one and only browser instance tied to this feature
 	mutex     *sync.Mutex         // mutex protects renderer from concurrent access.
 }
 
 func (bro *BrowserSlimerJS) IsConfigured() bool {
-	return bro.Renderers != nil && bro.Renderers.BasePortNumber != 0
+	return bro.Renderers != nil && bro.Renderers.BasePortNumber != 0 || bro.usesChromium()
+}
+
+// usesChromium returns true only if headless Chromium is configured to replace SlimerJS.
+func (bro *BrowserSlimerJS) usesChromium() bool {
+	return bro.Chromium != nil && bro.Chromium.BasePortNumber != 0
 }
 
 func (bro *BrowserSlimerJS) SelfTest() error {
@@ -49,10 +60,19 @@ func (bro *BrowserSlimerJS) Initialise() error {
 		have one instance.
 	*/
 	bro.mutex = new(sync.Mutex)
+	if bro.usesChromium() {
+		bro.Chromium.MaxInstances = 1
+		if err := bro.Chromium.Initialise(); err != nil {
+			return fmt.Errorf("BrowserSlimerJS.Initialise: failed to initialise Chromium lifecycle manager - %v", err)
+		}
+		bro.renderers = bro.Chromium
+		return nil
+	}
 	bro.Renderers.MaxInstances = 1
 	if err := bro.Renderers.Initialise(); err != nil {
 		return fmt.Errorf("Browser.Initialise: failed to initialise phantomJS lifecycle manager - %v", err)
 	}
+	bro.renderers = bro.Renderers
 	return nil
 }
 
@@ -69,12 +89,12 @@ func (bro *BrowserSlimerJS) Execute(ctx context.Context, cmd Command) (ret *Resu
 	defer bro.mutex.Unlock()
 	if bro.renderer != nil {
 		// The retrieved instance may be nil if it was killed due to timeout.
-		bro.renderer = bro.Renderers.Retrieve(bro.renderer.Index, bro.renderer.Tag)
+		bro.renderer = bro.renderers.RetrieveRenderer(bro.renderer.GetIndex(), bro.renderer.GetTag())
 	}
 	// Start a new instance if the previous instance is gone or was never started
 	if bro.renderer == nil {
 		var err error
-		_, bro.renderer, err = bro.Renderers.Acquire()
+		_, bro.renderer, err = bro.renderers.AcquireRenderer()
 		if err != nil {
 			return &Result{Error: err}
 		}
@@ -86,6 +106,7 @@ func (bro *BrowserSlimerJS) Execute(ctx context.Context, cmd Command) (ret *Resu
 	}
 	var output string
 	var err error
+	var attachments []ResultAttachment
 	switch params[1] {
 	case "f":
 		// Go forward
@@ -169,8 +190,13 @@ func (bro *BrowserSlimerJS) Execute(ctx context.Context, cmd Command) (ret *Resu
 		// Press backspace key on currently focused element
 		err = bro.renderer.SendKey("", slimerjs.KeyCodeBackspace)
 	case "render":
-		// For debugging purpose, render the page screenshot.
-		err = bro.renderer.RenderPage()
+		// Render the page screenshot, which is delivered to user by daemons that are capable of transporting files.
+		if err = bro.renderer.RenderPage(); err == nil {
+			var screenshot []byte
+			if screenshot, err = ioutil.ReadFile(bro.renderer.GetRenderPageFilePath()); err == nil {
+				attachments = []ResultAttachment{{FileName: "screenshot.png", ContentType: "image/png", Content: screenshot}}
+			}
+		}
 	default:
 		err = ErrBadBrowserParam
 	}
@@ -184,5 +210,5 @@ func (bro *BrowserSlimerJS) Execute(ctx context.Context, cmd Command) (ret *Resu
 			err = fmt.Errorf("command was successful, but failed to get page info - %v", err)
 		}
 	}
-	return &Result{Error: err, Output: output}
+	return &Result{Error: err, Output: output, Attachments: attachments}
 }
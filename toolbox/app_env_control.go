@@ -57,9 +57,12 @@ func (info *EnvControl) Execute(ctx context.Context, cmd Command) *Result {
 	case "info":
 		return &Result{Output: GetRuntimeInfo()}
 	case "log":
-		return &Result{Output: GetLatestLog()}
+		// The output is often truncated by result filters, the complete log is delivered as an attachment instead.
+		latestLog := GetLatestLog()
+		return &Result{Output: latestLog, Attachments: []ResultAttachment{{FileName: "log.txt", ContentType: "text/plain", Content: []byte(latestLog)}}}
 	case "warn":
-		return &Result{Output: GetLatestWarnings()}
+		latestWarnings := GetLatestWarnings()
+		return &Result{Output: latestWarnings, Attachments: []ResultAttachment{{FileName: "warnings.txt", ContentType: "text/plain", Content: []byte(latestWarnings)}}}
 	case "stack":
 		return &Result{Output: GetGoroutineStacktraces()}
 	case "tune":
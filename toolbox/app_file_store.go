@@ -0,0 +1,123 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/filestore"
+)
+
+const (
+	FileStoreTrigger           = ".f" // FileStoreTrigger is the trigger prefix string of FileStore feature.
+	FileStoreDefaultShareHours = 24   // FileStoreDefaultShareHours is the validity of a share link when the command does not specify one.
+	FileStoreCommandList       = "list"
+	FileStoreCommandShare      = "share"
+	FileStoreCommandDelete     = "delete"
+	fileStoreTimeFormat        = "2006-01-02 15:04"
+)
+
+var ErrBadFileStoreParam = errors.New(`example: list | share file-id [hours] | delete file-id`)
+
+/*
+FileStore lists, shares, and deletes files kept by the file store web service. A share link lets anyone download the
+file without the owner's password until the link expires.
+*/
+type FileStore struct {
+	Dir         string `json:"Dir"`         // Dir is the directory of file store web service.
+	DownloadURL string `json:"DownloadURL"` // DownloadURL is the full URL of file store web service, e.g. https://example.com/files
+
+	store *filestore.Store
+}
+
+func (fs *FileStore) IsConfigured() bool {
+	return fs.Dir != "" && fs.DownloadURL != ""
+}
+
+func (fs *FileStore) SelfTest() error {
+	if !fs.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	if _, err := os.Stat(fs.Dir); err != nil {
+		return fmt.Errorf("FileStore.SelfTest: directory \"%s\" is not accessible - %v", fs.Dir, err)
+	}
+	return nil
+}
+
+func (fs *FileStore) Initialise() error {
+	if !strings.HasPrefix(fs.DownloadURL, "http://") && !strings.HasPrefix(fs.DownloadURL, "https://") {
+		return errors.New("FileStore.Initialise: DownloadURL must be an http or https URL")
+	}
+	// The app only reads metadata and signs links, hence it does not need the encryption password.
+	fs.store = &filestore.Store{Dir: fs.Dir}
+	if err := fs.store.Initialise(); err != nil {
+		return fmt.Errorf("FileStore.Initialise: %v", err)
+	}
+	return nil
+}
+
+func (fs *FileStore) Trigger() Trigger {
+	return FileStoreTrigger
+}
+
+// list returns the ID, name, size, and expiration of each file, one file per line.
+func (fs *FileStore) list() *Result {
+	files, err := fs.store.List()
+	if err != nil {
+		return &Result{Error: err}
+	}
+	var out bytes.Buffer
+	for _, file := range files {
+		out.WriteString(fmt.Sprintf("%s %s %dB", file.ID, file.Name, file.Size))
+		if !file.Complete() {
+			out.WriteString(fmt.Sprintf(" (uploaded %dB)", file.Offset))
+		}
+		if file.MaxDownloads > 0 {
+			out.WriteString(fmt.Sprintf(" downloads %d/%d", file.Downloads, file.MaxDownloads))
+		}
+		out.WriteString(" expires " + file.ExpiresAt.Format(fileStoreTimeFormat) + "\n")
+	}
+	return &Result{Output: out.String()}
+}
+
+func (fs *FileStore) Execute(ctx context.Context, cmd Command) *Result {
+	if errResult := cmd.Trim(); errResult != nil {
+		return fs.list()
+	}
+	params := strings.Fields(cmd.Content)
+	switch strings.ToLower(params[0]) {
+	case FileStoreCommandList:
+		return fs.list()
+	case FileStoreCommandShare:
+		if len(params) < 2 || len(params) > 3 {
+			return &Result{Error: ErrBadFileStoreParam}
+		}
+		hours := FileStoreDefaultShareHours
+		if len(params) == 3 {
+			var err error
+			if hours, err = strconv.Atoi(params[2]); err != nil || hours < 1 {
+				return &Result{Error: ErrBadFileStoreParam}
+			}
+		}
+		query, err := fs.store.SignLink(params[1], time.Duration(hours)*time.Hour)
+		if err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: fs.DownloadURL + "?" + query}
+	case FileStoreCommandDelete:
+		if len(params) != 2 {
+			return &Result{Error: ErrBadFileStoreParam}
+		}
+		if err := fs.store.Delete(params[1]); err != nil {
+			return &Result{Error: err}
+		}
+		return &Result{Output: "deleted"}
+	default:
+		return &Result{Error: ErrBadFileStoreParam}
+	}
+}
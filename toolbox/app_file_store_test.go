@@ -0,0 +1,90 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/filestore"
+)
+
+func TestFileStore_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "laitos-test-app-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := FileStore{}
+	if app.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	if err := app.SelfTest(); err != ErrIncompleteConfig {
+		t.Fatal(err)
+	}
+	app = FileStore{Dir: dir, DownloadURL: "ftp://example.com"}
+	if err := app.Initialise(); err == nil {
+		t.Fatal("should not accept the URL")
+	}
+	app.DownloadURL = "https://example.com/files"
+	if err := app.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Store a complete file and an incomplete file
+	store := &filestore.Store{Dir: dir}
+	if err := store.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	complete, err := store.Create("a.txt", 3, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(complete.ID, 0, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	partial, err := store.Create("b.txt", 10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list"}); result.Error != nil ||
+		!strings.Contains(result.Output, complete.ID+" a.txt 3B downloads 0/5 expires ") ||
+		!strings.Contains(result.Output, partial.ID+" b.txt 10B (uploaded 0B) expires ") {
+		t.Fatal(result)
+	}
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "share " + complete.ID + " abc"}); result.Error != ErrBadFileStoreParam {
+		t.Fatal(result)
+	}
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "share " + partial.ID}); result.Error != filestore.ErrUploadIncomplete {
+		t.Fatal(result)
+	}
+	// The link is signed by the key shared with the file store web service
+	result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "share " + complete.ID + " 2"})
+	if result.Error != nil || !strings.HasPrefix(result.Output, "https://example.com/files?id="+complete.ID+"&exp=") {
+		t.Fatal(result)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.SplitN(result.Output, "?", 2)[1], "&") {
+		keyValue := strings.SplitN(param, "=", 2)
+		params[keyValue[0]] = keyValue[1]
+	}
+	if err := store.VerifyLink(params["id"], params["exp"], params["sig"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "delete " + partial.ID}); result.Error != nil || result.Output != "deleted" {
+		t.Fatal(result)
+	}
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "delete " + partial.ID}); result.Error != filestore.ErrFileNotFound {
+		t.Fatal(result)
+	}
+	if result := app.Execute(context.Background(), Command{TimeoutSec: 10, Content: "rename"}); result.Error != ErrBadFileStoreParam {
+		t.Fatal(result)
+	}
+}
@@ -0,0 +1,354 @@
+package toolbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+const (
+	SecretVaultTrigger           = ".v" // SecretVaultTrigger is the trigger prefix string of SecretVault feature.
+	SecretVaultDefaultMaxHistory = 10   // SecretVaultDefaultMaxHistory is the number of revisions retained per entry by default.
+)
+
+var (
+	// RegexVaultPasswordActionParams finds the vault password, action, and action parameters.
+	RegexVaultPasswordActionParams = regexp.MustCompile(`^(\S+)\s+(\w+)\s*(.*)$`)
+	// RegexVaultFieldAssignment finds the beginning of field assignments such as "username=john".
+	RegexVaultFieldAssignment = regexp.MustCompile(`(?:^|\s)(username|secret|notes|tags)=`)
+
+	ErrBadSecretVaultParam = errors.New(`example: password find|get|add|update|delete name [username=.. secret=.. notes=.. tags=a,b]`)
+	ErrVaultEntryNotFound  = errors.New("cannot find the entry")
+	ErrVaultEntryExists    = errors.New("the entry already exists")
+)
+
+// SecretVaultFields are the content of a vault entry apart from its name.
+type SecretVaultFields struct {
+	Username string   `json:"Username"`
+	Secret   string   `json:"Secret"`
+	Notes    string   `json:"Notes"`
+	Tags     []string `json:"Tags"`
+}
+
+// String returns the fields in human readable lines.
+func (fields SecretVaultFields) String() string {
+	return fmt.Sprintf("username: %s\nsecret: %s\nnotes: %s\ntags: %s", fields.Username, fields.Secret, fields.Notes, strings.Join(fields.Tags, ","))
+}
+
+// SecretVaultRevision is a previous revision of a vault entry.
+type SecretVaultRevision struct {
+	SecretVaultFields
+	Replaced time.Time `json:"Replaced"` // Replaced is the time the revision was superseded by an update.
+}
+
+// SecretVaultEntry is a named secret in the vault, along with its revision history.
+type SecretVaultEntry struct {
+	Name string `json:"Name"`
+	SecretVaultFields
+	Updated time.Time             `json:"Updated"`
+	History []SecretVaultRevision `json:"History"` // History has the previous revisions, the latest first.
+}
+
+// SecretVaultContent is the plain content of the vault file.
+type SecretVaultContent struct {
+	Entries []*SecretVaultEntry `json:"Entries"`
+}
+
+// find returns the entry of the name (case insensitive), or nil if the entry does not exist.
+func (content *SecretVaultContent) find(name string) *SecretVaultEntry {
+	for _, entry := range content.Entries {
+		if strings.EqualFold(entry.Name, name) {
+			return entry
+		}
+	}
+	return nil
+}
+
+/*
+SecretVault keeps passwords and other secrets in a file encrypted by the program data encryption format. Unlike
+AESDecrypt that only searches an existing file, the vault app adds, updates, and deletes entries, and the file is
+re-encrypted after each change. The vault password is given along with each command.
+*/
+type SecretVault struct {
+	FilePath       string `json:"FilePath"`       // FilePath is the location of encrypted vault, it is created upon adding the first entry.
+	PasswordPrefix string `json:"PasswordPrefix"` // PasswordPrefix is prepended to the password given in the command.
+	MaxHistory     int    `json:"MaxHistory"`     // MaxHistory is the number of previous revisions retained for each entry.
+
+	mutex *sync.Mutex
+}
+
+func (vault *SecretVault) IsConfigured() bool {
+	return vault.FilePath != ""
+}
+
+func (vault *SecretVault) SelfTest() error {
+	if !vault.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	if _, err := os.Stat(vault.FilePath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("SecretVault.SelfTest: file \"%s\" is not readable - %v", vault.FilePath, err)
+	}
+	// The vault is yet to be created, its directory must be ready.
+	if _, err := os.Stat(filepath.Dir(vault.FilePath)); err != nil {
+		return fmt.Errorf("SecretVault.SelfTest: directory of \"%s\" is not accessible - %v", vault.FilePath, err)
+	}
+	return nil
+}
+
+func (vault *SecretVault) Initialise() error {
+	if vault.MaxHistory < 1 {
+		vault.MaxHistory = SecretVaultDefaultMaxHistory
+	}
+	if vault.FilePath != "" {
+		absPath, err := filepath.Abs(vault.FilePath)
+		if err != nil {
+			return fmt.Errorf("SecretVault.Initialise: failed to determine absolute path of \"%s\" - %v", vault.FilePath, err)
+		}
+		vault.FilePath = absPath
+	}
+	vault.mutex = new(sync.Mutex)
+	return nil
+}
+
+func (vault *SecretVault) Trigger() Trigger {
+	return SecretVaultTrigger
+}
+
+// load decrypts and reads the vault content. A vault that does not yet exist is empty.
+func (vault *SecretVault) load(password []byte) (*SecretVaultContent, error) {
+	content := &SecretVaultContent{Entries: make([]*SecretVaultEntry, 0)}
+	file, err := os.Open(vault.FilePath)
+	if os.IsNotExist(err) {
+		return content, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open vault - %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var plain bytes.Buffer
+	if err := misc.DecryptStream(&plain, file, password); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plain.Bytes(), content); err != nil {
+		return nil, fmt.Errorf("failed to read vault content, is the password correct? - %v", err)
+	}
+	return content, nil
+}
+
+// save encrypts the vault content and replaces the vault file.
+func (vault *SecretVault) save(password []byte, content *SecretVaultContent) error {
+	sort.Slice(content.Entries, func(i, j int) bool {
+		return strings.ToLower(content.Entries[i].Name) < strings.ToLower(content.Entries[j].Name)
+	})
+	plain, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	// Write into a temporary file first, so that a failure half way does not damage the vault.
+	tmpFile, err := ioutil.TempFile(filepath.Dir(vault.FilePath), filepath.Base(vault.FilePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file - %v", err)
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if err := misc.EncryptStream(tmpFile, bytes.NewReader(plain), password); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("failed to encrypt vault - %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write vault - %v", err)
+	}
+	if err := os.Chmod(tmpFile.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to write vault - %v", err)
+	}
+	return os.Rename(tmpFile.Name(), vault.FilePath)
+}
+
+/*
+parseFieldAssignments reads field assignments such as "username=john secret=a b c tags=x,y" into a map. Only the first
+assignment of each field counts, hence a later value may contain text such as "secret=" without being cut short.
+*/
+func parseFieldAssignments(text string) map[string]string {
+	ret := make(map[string]string)
+	matches := make([][]int, 0, 4)
+	seen := make(map[string]bool)
+	for _, match := range RegexVaultFieldAssignment.FindAllStringSubmatchIndex(text, -1) {
+		if field := text[match[2]:match[3]]; !seen[field] {
+			seen[field] = true
+			matches = append(matches, match)
+		}
+	}
+	for i, match := range matches {
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		ret[text[match[2]:match[3]]] = strings.TrimSpace(text[match[1]:end])
+	}
+	return ret
+}
+
+// applyFieldAssignments updates the fields using assignment values.
+func applyFieldAssignments(fields *SecretVaultFields, assignments map[string]string) {
+	for name, value := range assignments {
+		switch name {
+		case "username":
+			fields.Username = value
+		case "secret":
+			fields.Secret = value
+		case "notes":
+			fields.Notes = value
+		case "tags":
+			fields.Tags = make([]string, 0)
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					fields.Tags = append(fields.Tags, tag)
+				}
+			}
+		}
+	}
+}
+
+func (vault *SecretVault) Execute(ctx context.Context, cmd Command) *Result {
+	if errResult := cmd.Trim(); errResult != nil {
+		return errResult
+	}
+	params := RegexVaultPasswordActionParams.FindStringSubmatch(cmd.Content)
+	if len(params) != 4 {
+		return &Result{Error: ErrBadSecretVaultParam}
+	}
+	password := []byte(vault.PasswordPrefix + params[1])
+	action := strings.ToLower(params[2])
+	// The entry name is the first word of the parameters, and the rest are field assignments.
+	actionParams := strings.TrimSpace(params[3])
+	var name, assignmentText string
+	if action != "find" {
+		nameAndRest := strings.SplitN(actionParams, " ", 2)
+		name = nameAndRest[0]
+		if len(nameAndRest) > 1 {
+			assignmentText = strings.TrimSpace(nameAndRest[1])
+		}
+		if name == "" {
+			return &Result{Error: ErrBadSecretVaultParam}
+		}
+	}
+	// Only one command may read and write the vault at a time
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+	content, err := vault.load(password)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	entry := content.find(name)
+	switch action {
+	case "find":
+		var out bytes.Buffer
+		search := strings.ToLower(actionParams)
+		for _, candidate := range content.Entries {
+			haystack := strings.ToLower(strings.Join(append([]string{candidate.Name, candidate.Username, candidate.Notes}, candidate.Tags...), " "))
+			if strings.Contains(haystack, search) {
+				// Reveal everything but the secret
+				out.WriteString(fmt.Sprintf("%s (%s) [%s]\n", candidate.Name, candidate.Username, strings.Join(candidate.Tags, ",")))
+			}
+		}
+		if out.Len() == 0 {
+			return &Result{Error: ErrVaultEntryNotFound}
+		}
+		return &Result{Output: out.String()}
+	case "get":
+		if entry == nil {
+			return &Result{Error: ErrVaultEntryNotFound}
+		}
+		switch strings.ToLower(assignmentText) {
+		case "":
+			return &Result{Output: fmt.Sprintf("%s\n%s\nupdated: %s", entry.Name, entry.SecretVaultFields.String(), entry.Updated.Format(time.RFC3339))}
+		case "username":
+			return &Result{Output: entry.Username}
+		case "secret":
+			return &Result{Output: entry.Secret}
+		case "notes":
+			return &Result{Output: entry.Notes}
+		case "tags":
+			return &Result{Output: strings.Join(entry.Tags, ",")}
+		case "history":
+			var out bytes.Buffer
+			for _, revision := range entry.History {
+				out.WriteString(fmt.Sprintf("replaced %s\n%s\n", revision.Replaced.Format(time.RFC3339), revision.SecretVaultFields.String()))
+			}
+			if out.Len() == 0 {
+				return &Result{Output: "no history"}
+			}
+			return &Result{Output: out.String()}
+		default:
+			return &Result{Error: ErrBadSecretVaultParam}
+		}
+	case "add":
+		if entry != nil {
+			return &Result{Error: ErrVaultEntryExists}
+		}
+		assignments := parseFieldAssignments(assignmentText)
+		if len(assignments) == 0 {
+			return &Result{Error: ErrBadSecretVaultParam}
+		}
+		entry = &SecretVaultEntry{Name: name, Updated: time.Now(), History: make([]SecretVaultRevision, 0)}
+		applyFieldAssignments(&entry.SecretVaultFields, assignments)
+		content.Entries = append(content.Entries, entry)
+	case "update":
+		if entry == nil {
+			return &Result{Error: ErrVaultEntryNotFound}
+		}
+		assignments := parseFieldAssignments(assignmentText)
+		if len(assignments) == 0 {
+			return &Result{Error: ErrBadSecretVaultParam}
+		}
+		// Retain the current revision in history, the latest first.
+		entry.History = append([]SecretVaultRevision{{SecretVaultFields: entry.SecretVaultFields, Replaced: time.Now()}}, entry.History...)
+		if len(entry.History) > vault.MaxHistory {
+			entry.History = entry.History[:vault.MaxHistory]
+		}
+		applyFieldAssignments(&entry.SecretVaultFields, assignments)
+		entry.Updated = time.Now()
+	case "delete":
+		if entry == nil {
+			return &Result{Error: ErrVaultEntryNotFound}
+		}
+		for i, candidate := range content.Entries {
+			if candidate == entry {
+				content.Entries = append(content.Entries[:i], content.Entries[i+1:]...)
+				break
+			}
+		}
+	default:
+		return &Result{Error: ErrBadSecretVaultParam}
+	}
+	// Re-encrypt the vault after a change
+	if err := vault.save(password, content); err != nil {
+		return &Result{Error: fmt.Errorf("failed to save vault - %v", err)}
+	}
+	return &Result{Output: fmt.Sprintf("%s %s, vault has %d entries", action, name, len(content.Entries))}
+}
+
+// GetTestSecretVault returns a configured but uninitialised vault, backed by a file that does not yet exist.
+func GetTestSecretVault() SecretVault {
+	filePath := "/tmp/laitos-testsecretvault"
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	return SecretVault{FilePath: filePath, PasswordPrefix: "prefix"}
+}
@@ -0,0 +1,117 @@
+package toolbox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/laitos/misc"
+)
+
+func TestSecretVault_Execute(t *testing.T) {
+	vault := SecretVault{}
+	if vault.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	if err := vault.SelfTest(); err != ErrIncompleteConfig {
+		t.Fatal(err)
+	}
+	vault = GetTestSecretVault()
+	defer os.Remove(vault.FilePath)
+	if !vault.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	if err := vault.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	// The vault does not have to exist prior to adding the first entry
+	if err := vault.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	run := func(content string) *Result {
+		return vault.Execute(context.Background(), Command{TimeoutSec: 10, Content: content})
+	}
+	for _, bad := range []string{"pass", "pass unknown a", "pass add", "pass add bank", "pass add bank nothing=1", "pass get bank bad_field_name"} {
+		if ret := run(bad); ret.Error != ErrBadSecretVaultParam && ret.Error != ErrVaultEntryNotFound {
+			t.Fatal(bad, ret)
+		}
+	}
+	if ret := run("pass find"); ret.Error != ErrVaultEntryNotFound {
+		t.Fatal(ret)
+	}
+	// Add entries
+	if ret := run("pass add Bank username=alice secret=very secret=; pass notes=PIN is 1234 tags=finance, personal"); ret.Error != nil {
+		t.Fatal(ret)
+	}
+	if ret := run("pass add email username=alice@example.com secret=abc"); ret.Error != nil {
+		t.Fatal(ret)
+	}
+	if ret := run("pass add bank secret=abc"); ret.Error != ErrVaultEntryExists {
+		t.Fatal(ret)
+	}
+	// The vault file is encrypted by the vault password along with the prefix
+	if _, err := misc.Decrypt(vault.FilePath, "pass"); err != misc.ErrDecryptionFailed {
+		t.Fatal(err)
+	}
+	if plain, err := misc.Decrypt(vault.FilePath, "prefixpass"); err != nil || !strings.Contains(string(plain), "very secret=; pass") {
+		t.Fatal(err, string(plain))
+	}
+	if ret := run("wrong find"); ret.Error != misc.ErrDecryptionFailed {
+		t.Fatal(ret)
+	}
+	// Find reveals everything but the secret
+	if ret := run("pass find"); ret.Error != nil || ret.Output != "Bank (alice) [finance,personal]\nemail (alice@example.com) []\n" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("pass find FINANCE"); ret.Error != nil || ret.Output != "Bank (alice) [finance,personal]\n" {
+		t.Fatalf("%+v", ret)
+	}
+	// Get individual fields
+	for field, expected := range map[string]string{"username": "alice", "secret": "very secret=; pass", "notes": "PIN is 1234", "tags": "finance,personal", "history": "no history"} {
+		if ret := run("pass get bank " + field); ret.Error != nil || ret.Output != expected {
+			t.Fatalf("%s: %+v", field, ret)
+		}
+	}
+	if ret := run("pass get bank"); ret.Error != nil || !strings.HasPrefix(ret.Output, "Bank\nusername: alice\nsecret: very secret=; pass\nnotes: PIN is 1234\ntags: finance,personal\nupdated: ") {
+		t.Fatalf("%+v", ret)
+	}
+	// Update retains history, the latest revision comes first.
+	vault.MaxHistory = 2
+	for _, newSecret := range []string{"second", "third", "fourth"} {
+		if ret := run("pass update bank secret=" + newSecret); ret.Error != nil {
+			t.Fatal(ret)
+		}
+	}
+	if ret := run("pass get bank secret"); ret.Error != nil || ret.Output != "fourth" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("pass get bank history"); ret.Error != nil || strings.Count(ret.Output, "replaced ") != 2 ||
+		strings.Index(ret.Output, "secret: third") > strings.Index(ret.Output, "secret: second") || strings.Contains(ret.Output, "very secret") {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("pass update nothing secret=a"); ret.Error != ErrVaultEntryNotFound {
+		t.Fatal(ret)
+	}
+	// Delete
+	if ret := run("pass delete email"); ret.Error != nil {
+		t.Fatal(ret)
+	}
+	if ret := run("pass delete email"); ret.Error != ErrVaultEntryNotFound {
+		t.Fatal(ret)
+	}
+	if ret := run("pass find"); ret.Error != nil || ret.Output != "Bank (alice) [finance,personal]\n" {
+		t.Fatalf("%+v", ret)
+	}
+	// A damaged vault is not overwritten
+	if err := ioutil.WriteFile(vault.FilePath, []byte("damaged"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ret := run("pass add new secret=a"); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if content, err := ioutil.ReadFile(vault.FilePath); err != nil || string(content) != "damaged" {
+		t.Fatal(err, string(content))
+	}
+}
@@ -18,18 +18,23 @@ type FeatureSet struct {
 
 	AESDecrypt         AESDecrypt         `json:"AESDecrypt"`
 	BrowserPhantomJS   BrowserPhantomJS   `json:"BrowserPhantomJS"`
+	BrowserRecipes     BrowserRecipes     `json:"BrowserRecipes"`
 	BrowserSlimerJS    BrowserSlimerJS    `json:"BrowserSlimerJS"`
 	PublicContact      PublicContact      `json:"PublicContact"`
 	EnvControl         EnvControl         `json:"EnvControl"`
+	FileStore          FileStore          `json:"FileStore"`
 	IMAPAccounts       IMAPAccounts       `json:"IMAPAccounts"`
 	Joke               Joke               `json:"Joke"`
 	RSS                RSS                `json:"RSS"`
+	SecretVault        SecretVault        `json:"SecretVault"`
 	SendMail           SendMail           `json:"SendMail"`
 	Shell              Shell              `json:"Shell"`
+	SMSModem           SMSModem           `json:"SMSModem"`
 	TextSearch         TextSearch         `json:"TextSearch"`
 	Twilio             Twilio             `json:"Twilio"`
 	Twitter            Twitter            `json:"Twitter"`
 	TwoFACodeGenerator TwoFACodeGenerator `json:"TwoFACodeGenerator"`
+	WebReader          WebReader          `json:"WebReader"`
 	WolframAlpha       WolframAlpha       `json:"WolframAlpha"`
 
 	MessageProcessor MessageProcessor `json:"MessageProcessor"`
@@ -44,17 +49,22 @@ func (fs *FeatureSet) Initialise() error {
 	apps := map[Trigger]Feature{
 		fs.AESDecrypt.Trigger():         &fs.AESDecrypt,         // a
 		fs.BrowserPhantomJS.Trigger():   &fs.BrowserPhantomJS,   // bp
+		fs.WebReader.Trigger():          &fs.WebReader,          // br
 		fs.BrowserSlimerJS.Trigger():    &fs.BrowserSlimerJS,    // bs
+		fs.BrowserRecipes.Trigger():     &fs.BrowserRecipes,     // bx
 		fs.PublicContact.Trigger():      &fs.PublicContact,      // c
 		fs.EnvControl.Trigger():         &fs.EnvControl,         // e
+		fs.FileStore.Trigger():          &fs.FileStore,          // f
 		fs.TextSearch.Trigger():         &fs.TextSearch,         // g
 		fs.IMAPAccounts.Trigger():       &fs.IMAPAccounts,       // i
 		fs.Joke.Trigger():               &fs.Joke,               // j
 		fs.RSS.Trigger():                &fs.RSS,                // r
 		fs.SendMail.Trigger():           &fs.SendMail,           // m
+		fs.SMSModem.Trigger():           &fs.SMSModem,           // n
 		fs.Shell.Trigger():              &fs.Shell,              // s
 		fs.Twilio.Trigger():             &fs.Twilio,             // p
 		fs.Twitter.Trigger():            &fs.Twitter,            // t
+		fs.SecretVault.Trigger():        &fs.SecretVault,        // v
 		fs.TwoFACodeGenerator.Trigger(): &fs.TwoFACodeGenerator, // 2
 		fs.WolframAlpha.Trigger():       &fs.WolframAlpha,       // w
 	}
@@ -123,16 +133,21 @@ func (fs *FeatureSet) DeserialiseFromJSON(configJSON json.RawMessage) error {
 	features := map[string]Feature{
 		"AESDecrypt":         &fs.AESDecrypt,
 		"BrowserPhantomJS":   &fs.BrowserPhantomJS,
+		"BrowserRecipes":     &fs.BrowserRecipes,
 		"BrowserSlimerJS":    &fs.BrowserSlimerJS,
 		"EnvControl":         &fs.EnvControl,
+		"FileStore":          &fs.FileStore,
 		"IMAPAccounts":       &fs.IMAPAccounts,
 		"Joke":               &fs.Joke,
 		"RSS":                &fs.RSS,
+		"SecretVault":        &fs.SecretVault,
 		"SendMail":           &fs.SendMail,
 		"Shell":              &fs.Shell,
+		"SMSModem":           &fs.SMSModem,
 		"Twilio":             &fs.Twilio,
 		"Twitter":            &fs.Twitter,
 		"TwoFACodeGenerator": &fs.TwoFACodeGenerator,
+		"WebReader":          &fs.WebReader,
 		"WolframAlpha":       &fs.WolframAlpha,
 	}
 	for featureKey, featureRef := range features {
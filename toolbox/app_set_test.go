@@ -25,8 +25,9 @@ func TestFeatureSet_InitSelfTest(t *testing.T) {
 	if err := apps.Initialise(); err != nil {
 		t.Fatal(err)
 	}
-	if len(apps.LookupByTrigger) != 6 ||
+	if len(apps.LookupByTrigger) != 7 ||
 		apps.LookupByTrigger[".0m"] == nil || // store&forward command processor
+		apps.LookupByTrigger[".br"] == nil || // web reader
 		apps.LookupByTrigger[".c"] == nil || // public contacts
 		apps.LookupByTrigger[".e"] == nil || // environment control
 		apps.LookupByTrigger[".j"] == nil || // joke
@@ -39,14 +40,14 @@ func TestFeatureSet_InitSelfTest(t *testing.T) {
 	if err := apps.Initialise(); err != nil {
 		t.Fatal(err)
 	}
-	// 6 always-available apps + 2 newly configured features (AES + 2FA)
-	if len(apps.LookupByTrigger) != 8 {
+	// 7 always-available apps + 2 newly configured features (AES + 2FA)
+	if len(apps.LookupByTrigger) != 9 {
 		t.Fatal(apps.LookupByTrigger)
 	}
 	if err := apps.SelfTest(); err != nil {
 		t.Fatal(err)
 	}
-	if triggers := apps.GetTriggers(); !reflect.DeepEqual(triggers, []string{".0m", ".2", ".a", ".c", ".e", ".j", ".r", ".s"}) {
+	if triggers := apps.GetTriggers(); !reflect.DeepEqual(triggers, []string{".0m", ".2", ".a", ".br", ".c", ".e", ".j", ".r", ".s"}) {
 		t.Fatal(triggers)
 	}
 }
@@ -78,7 +79,9 @@ func TestFeatureSet_InitSelfTestErr(t *testing.T) {
 		AuthUsername: "very bad",
 		AuthPassword: "very bad",
 	}
+	apps.SecretVault.FilePath = "/does/not/exist/vault"
 	apps.Shell.InterpreterPath = "very bad"
+	apps.SMSModem.DevicePath = "does not exist"
 	apps.TextSearch.FilePaths = map[string]string{"file": "does notexist"}
 	apps.Twilio = Twilio{
 		PhoneNumber: "very bad",
@@ -116,8 +119,10 @@ func TestFeatureSet_InitSelfTestErr(t *testing.T) {
 	findAllSelfTestErrs := StringContainsAllOf(selfTestErr.Error(), []Trigger{
 		"IMAPAccounts",
 		"RSS",
+		"SecretVault",
 		"SendMail",
 		"Shell",
+		"SMSModem",
 		"Twilio",
 		"Twitter",
 		"WolframAlpha",
@@ -0,0 +1,76 @@
+package toolbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SMSModemTrigger is the trigger prefix string of SMSModem feature.
+const SMSModemTrigger = ".n"
+
+// ErrBadSMSModemParam reminds user of the proper syntax to send an SMS via modem.
+var ErrBadSMSModemParam = fmt.Errorf("example: %s +##number message", SMSModemTrigger)
+
+// SMSModem sends SMS via a GSM/LTE modem connected to a serial device.
+type SMSModem struct {
+	DevicePath string `json:"DevicePath"` // DevicePath is the serial device of the modem, e.g. /dev/ttyUSB0.
+	BaudRate   int    `json:"BaudRate"`   // BaudRate is the serial communication speed, it is 115200 by default.
+}
+
+func (modem *SMSModem) IsConfigured() bool {
+	return modem.DevicePath != ""
+}
+
+func (modem *SMSModem) SelfTest() error {
+	if !modem.IsConfigured() {
+		return ErrIncompleteConfig
+	}
+	dev, err := OpenModem(modem.DevicePath, modem.BaudRate)
+	if err != nil {
+		return fmt.Errorf("SMSModem.SelfTest: %v", err)
+	}
+	defer func() {
+		_ = dev.Close()
+	}()
+	if _, err := dev.Command("AT"); err != nil {
+		return fmt.Errorf("SMSModem.SelfTest: modem does not respond - %v", err)
+	}
+	return nil
+}
+
+func (modem *SMSModem) Initialise() error {
+	return nil
+}
+
+func (modem *SMSModem) Trigger() Trigger {
+	return SMSModemTrigger
+}
+
+func (modem *SMSModem) Execute(ctx context.Context, cmd Command) *Result {
+	if errResult := cmd.Trim(); errResult != nil {
+		return errResult
+	}
+	params := RegexPhoneNumberAndMessage.FindStringSubmatch(cmd.Content)
+	if len(params) < 3 {
+		return &Result{Error: ErrBadSMSModemParam}
+	}
+	toNumber := params[1]
+	message := strings.TrimSpace(params[2])
+	if message == "" {
+		return &Result{Error: ErrBadSMSModemParam}
+	}
+	dev, err := OpenModem(modem.DevicePath, modem.BaudRate)
+	if err != nil {
+		return &Result{Error: err}
+	}
+	defer func() {
+		_ = dev.Close()
+	}()
+	if _, err := dev.SendSMS(toNumber, message); err != nil {
+		return &Result{Error: err}
+	}
+	// The OK output is simply the length of number + message
+	return &Result{Output: strconv.Itoa(len(toNumber) + len(message))}
+}
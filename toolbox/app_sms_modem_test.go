@@ -0,0 +1,39 @@
+package toolbox
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSMSModem_Execute(t *testing.T) {
+	fake, err := StartFakeModem()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer fake.Close()
+	modem := SMSModem{}
+	if modem.IsConfigured() {
+		t.Fatal("should not be configured")
+	}
+	modem.DevicePath = fake.DevicePath
+	if !modem.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	if err := modem.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := modem.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"!@$!@%#%#$@%", "+123456", "+123456, "} {
+		if ret := modem.Execute(context.Background(), Command{TimeoutSec: 10, Content: content}); ret.Error != ErrBadSMSModemParam {
+			t.Fatal(content, ret)
+		}
+	}
+	if ret := modem.Execute(context.Background(), Command{TimeoutSec: 10, Content: "+123456, laitos test"}); ret.Error != nil || ret.Output != "18" {
+		t.Fatal(ret)
+	}
+	if sent := fake.GetSentSMS(); len(sent) != 1 || sent[0].Sender != "+123456" || sent[0].Text != "laitos test" {
+		t.Fatalf("%+v", sent)
+	}
+}
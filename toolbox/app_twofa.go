@@ -3,18 +3,17 @@ package toolbox
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base32"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/big"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -29,35 +28,11 @@ specific time division using SHA1 method. The caller is responsible for linting
 The function is heavily inspired by Pierre Carrier's "gauth" (https://github.com/pcarrier/gauth).
 */
 func GetTwoFACodeForTimeDivision(secret string, time int64) (string, error) {
-	secret = strings.ToUpper(strings.TrimSpace(strings.Replace(secret, " ", "", -1)))
-	// Secret is linted and padded with = to nearest 8 bytes
-	paddingLength := 8 - (len(secret) % 8)
-	if paddingLength < 8 {
-		secret += strings.Repeat("=", paddingLength)
-	}
-	secretBin, err := base32.StdEncoding.DecodeString(secret)
+	secretBin, err := DecodeOTPSecret(secret)
 	if err != nil {
 		return "", err
 	}
-	shaHMAC := hmac.New(sha1.New, secretBin)
-	timeMessage := make([]byte, 8)
-	timeMessage[0] = (byte)(time >> (7 * 8) & 0xff)
-	timeMessage[1] = (byte)(time >> (6 * 8) & 0xff)
-	timeMessage[2] = (byte)(time >> (5 * 8) & 0xff)
-	timeMessage[3] = (byte)(time >> (4 * 8) & 0xff)
-	timeMessage[4] = (byte)(time >> (3 * 8) & 0xff)
-	timeMessage[5] = (byte)(time >> (2 * 8) & 0xff)
-	timeMessage[6] = (byte)(time >> (1 * 8) & 0xff)
-	timeMessage[7] = (byte)(time >> (0 * 8) & 0xff)
-	if _, err := shaHMAC.Write(timeMessage); err != nil {
-		return "", err
-	}
-	hash := shaHMAC.Sum(nil)
-	offset := hash[19] & 0x0f
-	truncated := hash[offset : offset+4]
-	truncated[0] &= 0x7F
-	result := new(big.Int).Mod(new(big.Int).SetBytes(truncated), big.NewInt(1000000))
-	return fmt.Sprintf("%06d", result), nil
+	return GetOTPCode(secretBin, time, OTPDefaultAlgorithm, OTPDefaultDigits)
 }
 
 /*
@@ -74,14 +49,69 @@ func GetTwoFACodes(secret string) (previous, current, next string, err error) {
 	return
 }
 
-const TwoFATrigger = ".2" // TwoFATrigger is the trigger prefix string of TwoFACodeGenerator feature.
+const (
+	TwoFATrigger    = ".2"   // TwoFATrigger is the trigger prefix string of TwoFACodeGenerator feature.
+	TwoFAListPrefix = "list" // TwoFAListPrefix is the subcommand that lists account names without revealing their codes.
+)
 
 /*
 TwoFACodeGenerator generates two factor authentication codes upon request. The generator
 takes an AES encrypted secret seed file as input, that looks like "account_name: secret\n...".
+Instead of a plain secret, an account may also be described by an "otpauth://" URI, which supports
+HOTP, custom hash algorithm, code length, and TOTP period.
 */
 type TwoFACodeGenerator struct {
 	SecretFile *AESEncryptedFile `json:"SecretFile"` // SecretFile has encrypted account name and 2fa secrets
+	// HOTPCounterFile persists HOTP counters across restarts, it defaults to a file next to the secret file.
+	HOTPCounterFile string `json:"HOTPCounterFile"`
+
+	hotpCounters     map[string]int64
+	hotpCounterMutex *sync.Mutex
+}
+
+// TwoFAEntry is an account read from the two factor authentication secret file.
+type TwoFAEntry struct {
+	Name    string     // Name is the account name that the user searches for.
+	Account OTPAccount // Account has the parameters for code generation.
+}
+
+/*
+ParseTwoFAEntries reads the account entries from the decrypted content of two factor authentication secret file.
+Each line is either "account_name: base32_secret", "account_name: otpauth://...", or a sole "otpauth://..." URI.
+*/
+func ParseTwoFAEntries(plainContent []byte) (entries []TwoFAEntry, err error) {
+	entries = make([]TwoFAEntry, 0, 8)
+	for _, line := range strings.Split(string(plainContent), "\n") {
+		line = strings.TrimSpace(line)
+		var entryName, secret string
+		if strings.HasPrefix(line, "otpauth://") {
+			secret = line
+		} else {
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			entryName = strings.TrimSpace(fields[0])
+			secret = strings.TrimSpace(fields[1])
+		}
+		var account OTPAccount
+		if strings.HasPrefix(secret, "otpauth://") {
+			if account, err = ParseOTPAuthURI(secret); err != nil {
+				return nil, err
+			}
+			if entryName == "" {
+				entryName = account.Name
+			}
+		} else {
+			account = OTPAccount{Name: entryName, Type: OTPTypeTOTP, Algorithm: OTPDefaultAlgorithm, Digits: OTPDefaultDigits, Period: OTPDefaultPeriod}
+			if account.Secret, err = DecodeOTPSecret(secret); err != nil {
+				// Tolerate lines that merely look like an entry, or garbage content decrypted using an incorrect key.
+				continue
+			}
+		}
+		entries = append(entries, TwoFAEntry{Name: entryName, Account: account})
+	}
+	return entries, nil
 }
 
 func (codegen *TwoFACodeGenerator) IsConfigured() bool {
@@ -102,6 +132,19 @@ func (codegen *TwoFACodeGenerator) Initialise() error {
 	if err := codegen.SecretFile.Initialise(); err != nil {
 		return fmt.Errorf("TwoFACodeGenerator: failed to initialise encrypted secret file - %w", err)
 	}
+	if codegen.HOTPCounterFile == "" {
+		codegen.HOTPCounterFile = codegen.SecretFile.FilePath + ".hotp-counters"
+	}
+	codegen.hotpCounterMutex = new(sync.Mutex)
+	codegen.hotpCounters = make(map[string]int64)
+	content, err := ioutil.ReadFile(codegen.HOTPCounterFile)
+	if err == nil {
+		if err := json.Unmarshal(content, &codegen.hotpCounters); err != nil {
+			return fmt.Errorf("TwoFACodeGenerator: failed to parse HOTP counter file \"%s\" - %v", codegen.HOTPCounterFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("TwoFACodeGenerator: failed to read HOTP counter file \"%s\" - %v", codegen.HOTPCounterFile, err)
+	}
 	return nil
 }
 
@@ -109,6 +152,30 @@ func (codegen *TwoFACodeGenerator) Trigger() Trigger {
 	return TwoFATrigger
 }
 
+/*
+nextHOTPCounter returns the counter value to be used for the next HOTP code of the account, and persists the counter
+beyond it, so that a code is never generated twice even if the program restarts.
+*/
+func (codegen *TwoFACodeGenerator) nextHOTPCounter(entry TwoFAEntry) (int64, error) {
+	codegen.hotpCounterMutex.Lock()
+	defer codegen.hotpCounterMutex.Unlock()
+	counter := codegen.hotpCounters[entry.Name]
+	if counter < entry.Account.Counter {
+		counter = entry.Account.Counter
+	}
+	codegen.hotpCounters[entry.Name] = counter + 1
+	content, err := json.Marshal(codegen.hotpCounters)
+	if err == nil {
+		err = ioutil.WriteFile(codegen.HOTPCounterFile, content, 0600)
+	}
+	if err != nil {
+		// Without persisting the counter, the same code could be generated again after a restart.
+		codegen.hotpCounters[entry.Name] = counter
+		return 0, fmt.Errorf("failed to persist HOTP counter - %v", err)
+	}
+	return counter, nil
+}
+
 func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (ret *Result) {
 	if errResult := cmd.Trim(); errResult != nil {
 		return errResult
@@ -119,6 +186,11 @@ func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (re
 	}
 	hexKeySuffix := params[1]
 	accountName := params[2]
+	listOnly := hexKeySuffix == TwoFAListPrefix
+	if listOnly {
+		// In the list subcommand, the key follows the subcommand
+		hexKeySuffix = strings.TrimSpace(accountName)
+	}
 	// Use combination of configured key and input suffix key to decrypt the account secret file
 	keySuffix, err := hex.DecodeString(hexKeySuffix)
 	if err != nil {
@@ -128,25 +200,50 @@ func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (re
 	if err != nil {
 		return &Result{Error: err}
 	}
-	var accountFound bool
+	entries, err := ParseTwoFAEntries(plainContent)
+	if err != nil {
+		return &Result{Error: err}
+	}
 	var codeOutput bytes.Buffer
-	// Read the account name and secrets among the lines
-	for _, line := range strings.Split(string(plainContent), "\n") {
-		fields := strings.SplitN(line, ":", 2)
-		if len(fields) != 2 {
-			continue
+	if listOnly {
+		for _, entry := range entries {
+			codeOutput.WriteString(entry.Name)
+			codeOutput.WriteRune('\n')
+		}
+		if len(entries) == 0 {
+			return &Result{Error: errors.New("Cannot find the account")}
 		}
-		entryName := strings.TrimSpace(fields[0])
-		secret := strings.TrimSpace(fields[1])
+		return &Result{Output: codeOutput.String()}
+	}
+	var accountFound bool
+	for _, entry := range entries {
 		// If requested word is among the entry's account name, calculate its code.
-		if strings.Contains(entryName, accountName) {
-			accountFound = true
-			prev, current, next, err := GetTwoFACodes(secret)
+		if !strings.Contains(entry.Name, accountName) {
+			continue
+		}
+		accountFound = true
+		if entry.Account.Type == OTPTypeHOTP {
+			counter, err := codegen.nextHOTPCounter(entry)
+			if err != nil {
+				return &Result{Error: err}
+			}
+			code, err := entry.Account.Code(counter)
 			if err != nil {
 				return &Result{Error: err}
 			}
-			codeOutput.WriteString(fmt.Sprintf("%s: %s %s %s\n", entryName, prev, current, next))
+			codeOutput.WriteString(fmt.Sprintf("%s: %s (counter %d)\n", entry.Name, code, counter))
+			continue
+		}
+		timeDivision := time.Now().Unix() / int64(entry.Account.Period)
+		codes := make([]string, 0, 3)
+		for _, division := range []int64{timeDivision - 1, timeDivision, timeDivision + 1} {
+			code, err := entry.Account.Code(division)
+			if err != nil {
+				return &Result{Error: err}
+			}
+			codes = append(codes, code)
 		}
+		codeOutput.WriteString(fmt.Sprintf("%s: %s\n", entry.Name, strings.Join(codes, " ")))
 	}
 	if !accountFound {
 		return &Result{Error: errors.New("Cannot find the account")}
@@ -155,6 +252,65 @@ func (codegen *TwoFACodeGenerator) Execute(ctx context.Context, cmd Command) (re
 	return &Result{Output: codeOutput.String()}
 }
 
+/*
+ImportTwoFAAccounts decrypts the two factor authentication secret file, appends the accounts that are not yet present
+in the file as "otpauth://" URIs, and encrypts the file again. It returns the number of newly imported accounts.
+*/
+func ImportTwoFAAccounts(file *AESEncryptedFile, keySuffix []byte, accounts []OTPAccount) (int, error) {
+	plainContent, keyVerified, err := file.DecryptAndVerifyKey(keySuffix)
+	if err != nil {
+		return 0, fmt.Errorf("ImportTwoFAAccounts: failed to decrypt - %v", err)
+	}
+	existing, err := ParseTwoFAEntries(plainContent)
+	if err != nil {
+		return 0, fmt.Errorf("ImportTwoFAAccounts: failed to read existing accounts - %v", err)
+	}
+	/*
+		A file that has only been encrypted by openssl does not carry a known plain text for verifying the key. Instead,
+		every line must be an account, which is practically impossible for garbage content decrypted using an incorrect
+		key. Importing using an incorrect key would otherwise encrypt the garbage again and destroy the accounts.
+	*/
+	if !keyVerified {
+		var numLines int
+		for _, line := range strings.Split(string(plainContent), "\n") {
+			if strings.TrimSpace(line) != "" {
+				numLines++
+			}
+		}
+		if !utf8.Valid(plainContent) || numLines != len(existing) {
+			return 0, errors.New("ImportTwoFAAccounts: failed to verify the key, every line of the file must be an account")
+		}
+	}
+	newContent := bytes.NewBuffer(plainContent)
+	if newContent.Len() > 0 && !bytes.HasSuffix(plainContent, []byte("\n")) {
+		newContent.WriteRune('\n')
+	}
+	var imported int
+	for _, account := range accounts {
+		var duplicated bool
+		for _, entry := range existing {
+			if bytes.Equal(entry.Account.Secret, account.Secret) && entry.Account.Type == account.Type {
+				duplicated = true
+				break
+			}
+		}
+		if duplicated {
+			continue
+		}
+		newContent.WriteString(account.URI())
+		newContent.WriteRune('\n')
+		existing = append(existing, TwoFAEntry{Name: account.Name, Account: account})
+		imported++
+	}
+	if imported == 0 {
+		return 0, nil
+	}
+	if err := file.Encrypt(keySuffix, newContent.Bytes()); err != nil {
+		return 0, fmt.Errorf("ImportTwoFAAccounts: failed to encrypt - %v", err)
+	}
+	return imported, nil
+}
+
 // GetTestTwoFACodeGenerator returns a configured but uninitialised code generator.
 func GetTestTwoFACodeGenerator() TwoFACodeGenerator {
 	/*
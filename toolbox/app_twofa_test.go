@@ -2,6 +2,8 @@ package toolbox
 
 import (
 	"context"
+	"os"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -64,4 +66,70 @@ func TestTwoFACodeGenerator_Execute(t *testing.T) {
 	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "5512 test acc"}); ret.Error != nil || !strings.HasPrefix(ret.Output, "test account: ") {
 		t.Fatal(ret)
 	}
+	// List account names without revealing codes
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list 5512"}); ret.Error != nil || ret.Output != "test account\n" {
+		t.Fatal(ret)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list beef"}); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestTwoFACodeGenerator_OTPAuth(t *testing.T) {
+	codegen := GetTestTwoFACodeGenerator()
+	if err := os.Remove(codegen.SecretFile.FilePath + ".hotp-counters"); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	defer os.Remove(codegen.SecretFile.FilePath + ".hotp-counters")
+	if err := codegen.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	hotp, err := ParseOTPAuthURI("otpauth://hotp/Example:hotp%20user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, err := ParseOTPAuthURI("otpauth://totp/Example:totp%20user?secret=JBSWY3DPEHPK3PXP&digits=8&period=60&algorithm=SHA512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Import new accounts, the existing account and duplicated accounts are retained only once.
+	if _, err := ImportTwoFAAccounts(codegen.SecretFile, []byte{0xbe, 0xef}, []OTPAccount{hotp}); err == nil {
+		t.Fatal("did not error")
+	}
+	if imported, err := ImportTwoFAAccounts(codegen.SecretFile, []byte{0x55, 0x12}, []OTPAccount{hotp, totp, hotp}); err != nil || imported != 2 {
+		t.Fatal(err, imported)
+	}
+	if imported, err := ImportTwoFAAccounts(codegen.SecretFile, []byte{0x55, 0x12}, []OTPAccount{totp}); err != nil || imported != 0 {
+		t.Fatal(err, imported)
+	}
+	// The key is verified against the file encrypted by laitos
+	if _, err := ImportTwoFAAccounts(codegen.SecretFile, []byte{0xbe, 0xef}, []OTPAccount{hotp}); err == nil {
+		t.Fatal("did not error")
+	}
+	// The file remains readable after a restart
+	if err := codegen.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "list 5512"}); ret.Error != nil ||
+		ret.Output != "test account\nExample:hotp user\nExample:totp user\n" {
+		t.Fatal(ret)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "5512 totp"}); ret.Error != nil ||
+		!regexp.MustCompile(`^Example:totp user: \d{8} \d{8} \d{8}\n$`).MatchString(ret.Output) {
+		t.Fatal(ret)
+	}
+	// HOTP counter starts from the URI and increments with each code (RFC 4226 test vectors)
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "5512 hotp"}); ret.Error != nil || ret.Output != "Example:hotp user: 287082 (counter 1)\n" {
+		t.Fatal(ret)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "5512 hotp"}); ret.Error != nil || ret.Output != "Example:hotp user: 359152 (counter 2)\n" {
+		t.Fatal(ret)
+	}
+	// The counter persists across restarts
+	if err := codegen.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := codegen.Execute(context.Background(), Command{TimeoutSec: 10, Content: "5512 hotp"}); ret.Error != nil || ret.Output != "Example:hotp user: 969429 (counter 3)\n" {
+		t.Fatal(ret)
+	}
 }
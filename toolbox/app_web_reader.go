@@ -0,0 +1,311 @@
+package toolbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/laitos/inet"
+)
+
+const (
+	WebReaderTrigger           = ".br" // WebReaderTrigger is the trigger prefix string of WebReader feature.
+	WebReaderDefaultPageLength = 1000  // WebReaderDefaultPageLength is the page length used when output length is not limited.
+	WebReaderMinPageLength     = 20    // WebReaderMinPageLength is the minimum length of text on a page.
+	WebReaderMaxHistory        = 10    // WebReaderMaxHistory is the maximum number of visited pages to remember for going back.
+	webReaderPageHeaderLength  = 10    // webReaderPageHeaderLength is the length reserved for page number header, e.g. "[12/345] ".
+)
+
+var (
+	// ErrBadWebReaderParam is the error response for incorrectly entering parameters for the web reader.
+	ErrBadWebReaderParam = errors.New("example: .br example.com | next | prev | page # | links | text | follow # | back")
+	// ErrWebReaderNoPage is the error response for navigating the page before opening one.
+	ErrWebReaderNoPage = errors.New("open a page first, e.g. .br example.com")
+)
+
+/*
+WebReader reads web pages in plain text without relying on a browser. It downloads the page, extracts readable article
+text and numbered links from it, and presents the text one page at a time, each page fits into the output length of
+the daemon that runs the command.
+*/
+type WebReader struct {
+	// DefaultPageLength is the length of each page when the daemon does not limit output length.
+	DefaultPageLength int `json:"DefaultPageLength"`
+
+	currentURL  string       // currentURL is the address of the page being read.
+	current     ReadableHTML // current is the readable text of the page being read.
+	history     []string     // history are the addresses of previously visited pages, the latest comes last.
+	showLinks   bool         // showLinks is true if the user is reading the list of links rather than text.
+	textOffset  int          // textOffset is the position of the page being read in the article text.
+	linksOffset int          // linksOffset is the position of the page being read in the list of links.
+	mutex       *sync.Mutex  // mutex protects the state of reading from concurrent access.
+}
+
+// IsConfigured always returns true because configuration is not required for this feature.
+func (reader *WebReader) IsConfigured() bool {
+	return true
+}
+
+// SelfTest does nothing because the feature does not rely on any particular web site.
+func (reader *WebReader) SelfTest() error {
+	return nil
+}
+
+// Initialise sets default page length and prepares internal state.
+func (reader *WebReader) Initialise() error {
+	if reader.DefaultPageLength < WebReaderMinPageLength {
+		reader.DefaultPageLength = WebReaderDefaultPageLength
+	}
+	reader.mutex = new(sync.Mutex)
+	return nil
+}
+
+// Trigger returns the trigger prefix string ".br".
+func (reader *WebReader) Trigger() Trigger {
+	return WebReaderTrigger
+}
+
+// Execute navigates among web pages and the text within, and then responds with the page of text being read.
+func (reader *WebReader) Execute(ctx context.Context, cmd Command) *Result {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	params := strings.Fields(cmd.Content)
+	if len(params) == 0 {
+		// Show the page being read once again
+		return reader.show(cmd)
+	}
+	action := strings.ToLower(params[0])
+	var num int
+	if len(params) > 1 {
+		var err error
+		if num, err = strconv.Atoi(params[1]); err != nil && action != "go" {
+			return &Result{Error: ErrBadWebReaderParam}
+		}
+	} else if bareNum, err := strconv.Atoi(action); err == nil {
+		// A bare number follows the link
+		action = "follow"
+		num = bareNum
+	}
+	if action != "go" && len(params) > 2 {
+		return &Result{Error: ErrBadWebReaderParam}
+	}
+	switch action {
+	case "go":
+		if len(params) != 2 {
+			return &Result{Error: ErrBadWebReaderParam}
+		}
+		return reader.open(ctx, cmd, params[1], true)
+	case "follow", "f":
+		if reader.currentURL == "" {
+			return &Result{Error: ErrWebReaderNoPage}
+		}
+		if num < 1 || num > len(reader.current.Links) {
+			return &Result{Error: fmt.Errorf("link number must be between 1 and %d", len(reader.current.Links))}
+		}
+		return reader.open(ctx, cmd, reader.current.Links[num-1].URL, true)
+	case "back", "b":
+		if len(reader.history) == 0 {
+			return &Result{Error: errors.New("there is no previous page")}
+		}
+		previous := reader.history[len(reader.history)-1]
+		reader.history = reader.history[:len(reader.history)-1]
+		ret := reader.open(ctx, cmd, previous, false)
+		if ret.Error != nil {
+			// Let user try going back again later
+			reader.history = append(reader.history, previous)
+		}
+		return ret
+	case "next", "n", "prev", "p", "page":
+		if reader.currentURL == "" {
+			return &Result{Error: ErrWebReaderNoPage}
+		}
+		pages := reader.paginate(cmd)
+		pageIndex := reader.pageIndex(pages)
+		switch action {
+		case "next", "n":
+			if pageIndex == len(pages)-1 {
+				return &Result{Error: errors.New("already on the last page")}
+			}
+			pageIndex++
+		case "prev", "p":
+			if pageIndex == 0 {
+				return &Result{Error: errors.New("already on the first page")}
+			}
+			pageIndex--
+		default:
+			if num < 1 || num > len(pages) {
+				return &Result{Error: fmt.Errorf("page number must be between 1 and %d", len(pages))}
+			}
+			pageIndex = num - 1
+		}
+		reader.setOffset(pages[pageIndex].offset)
+		return reader.show(cmd)
+	case "links", "l":
+		reader.showLinks = true
+		reader.linksOffset = 0
+		return reader.show(cmd)
+	case "text", "t":
+		reader.showLinks = false
+		return reader.show(cmd)
+	default:
+		if len(params) != 1 {
+			return &Result{Error: ErrBadWebReaderParam}
+		}
+		return reader.open(ctx, cmd, params[0], true)
+	}
+}
+
+// open downloads the web page and starts reading it from the beginning.
+func (reader *WebReader) open(ctx context.Context, cmd Command, pageURL string, rememberCurrent bool) *Result {
+	if !strings.Contains(pageURL, "://") {
+		pageURL = "https://" + pageURL
+	}
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return &Result{Error: ErrBadWebReaderParam}
+	}
+	resp, err := inet.DoHTTP(ctx, inet.HTTPRequest{TimeoutSec: cmd.TimeoutSec}, strings.Replace(parsedURL.String(), "%", "%%", -1))
+	if err != nil {
+		return &Result{Error: err}
+	}
+	if err := resp.Non2xxToError(); err != nil {
+		return &Result{Error: err}
+	}
+	var readable ReadableHTML
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "" || strings.Contains(mediaType, "html"):
+		readable = ExtractReadableHTML(parsedURL, string(resp.Body))
+	case strings.HasPrefix(mediaType, "text/"):
+		readable = ReadableHTML{Title: parsedURL.String(), Text: strings.TrimSpace(string(resp.Body))}
+	default:
+		return &Result{Error: fmt.Errorf("cannot read content of type \"%s\"", mediaType)}
+	}
+	if rememberCurrent && reader.currentURL != "" {
+		reader.history = append(reader.history, reader.currentURL)
+		if len(reader.history) > WebReaderMaxHistory {
+			reader.history = reader.history[len(reader.history)-WebReaderMaxHistory:]
+		}
+	}
+	reader.currentURL = parsedURL.String()
+	reader.current = readable
+	reader.showLinks = false
+	reader.textOffset = 0
+	reader.linksOffset = 0
+	return reader.show(cmd)
+}
+
+// webReaderPage is a portion of text that fits into the output length.
+type webReaderPage struct {
+	offset int    // offset is the position of the page in the entire text.
+	text   string // text is the content of the page.
+}
+
+// content returns the entire text of the article or the list of links, whichever the user is reading.
+func (reader *WebReader) content() string {
+	if reader.showLinks {
+		if len(reader.current.Links) == 0 {
+			return "(no links)"
+		}
+		var links strings.Builder
+		for i, link := range reader.current.Links {
+			links.WriteString(fmt.Sprintf("%d. %s %s\n", i+1, link.Text, link.URL))
+		}
+		return links.String()
+	}
+	if reader.current.Text == "" {
+		return reader.current.Title + "\n\n(no text)"
+	}
+	return reader.current.Title + "\n\n" + reader.current.Text
+}
+
+// paginate splits the text being read into pages that fit into the output length of the command.
+func (reader *WebReader) paginate(cmd Command) []webReaderPage {
+	pageLen := reader.DefaultPageLength
+	if cmd.MaxOutputLength > 0 {
+		pageLen = cmd.MaxOutputLength - webReaderPageHeaderLength
+	}
+	if pageLen < WebReaderMinPageLength {
+		pageLen = WebReaderMinPageLength
+	}
+	return splitTextIntoPages(reader.content(), pageLen)
+}
+
+// pageIndex returns the index of the page being read.
+func (reader *WebReader) pageIndex(pages []webReaderPage) int {
+	offset := reader.textOffset
+	if reader.showLinks {
+		offset = reader.linksOffset
+	}
+	for i := len(pages) - 1; i > 0; i-- {
+		if pages[i].offset <= offset {
+			return i
+		}
+	}
+	return 0
+}
+
+// setOffset memorises the position of the page being read.
+func (reader *WebReader) setOffset(offset int) {
+	if reader.showLinks {
+		reader.linksOffset = offset
+	} else {
+		reader.textOffset = offset
+	}
+}
+
+// show responds with the page being read and its page number.
+func (reader *WebReader) show(cmd Command) *Result {
+	if reader.currentURL == "" {
+		return &Result{Error: ErrWebReaderNoPage}
+	}
+	pages := reader.paginate(cmd)
+	pageIndex := reader.pageIndex(pages)
+	// The page number also helps a reader to notice a change in page length among daemons
+	reader.setOffset(pages[pageIndex].offset)
+	return &Result{Output: fmt.Sprintf("[%d/%d] %s", pageIndex+1, len(pages), pages[pageIndex].text)}
+}
+
+/*
+splitTextIntoPages splits text into pages of at most pageLen bytes each. Each page ends at a line break or space when
+possible, and never splits a multi-byte character.
+*/
+func splitTextIntoPages(text string, pageLen int) (pages []webReaderPage) {
+	for offset := 0; offset < len(text) || len(pages) == 0; {
+		end := offset + pageLen
+		if end >= len(text) {
+			pages = append(pages, webReaderPage{offset: offset, text: strings.TrimRight(text[offset:], " \n")})
+			break
+		}
+		for end > offset && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		// Prefer to end the page at a line break, or otherwise a space, among the last quarter of page.
+		breakAt := -1
+		minBreak := end - pageLen/4
+		if lineBreak := strings.LastIndexByte(text[offset:end], '\n'); lineBreak != -1 && offset+lineBreak >= minBreak {
+			breakAt = offset + lineBreak + 1
+		} else if space := strings.LastIndexByte(text[offset:end], ' '); space != -1 && offset+space >= minBreak {
+			breakAt = offset + space + 1
+		}
+		if breakAt > offset {
+			end = breakAt
+		}
+		if end == offset {
+			// A single character longer than page cannot be split
+			_, size := utf8.DecodeRuneInString(text[offset:])
+			end = offset + size
+		}
+		pages = append(pages, webReaderPage{offset: offset, text: strings.TrimRight(text[offset:end], " \n")})
+		// Skip the spaces at the beginning of next page
+		for offset = end; offset < len(text) && (text[offset] == ' ' || text[offset] == '\n'); offset++ {
+		}
+	}
+	return
+}
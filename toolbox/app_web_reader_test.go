@@ -0,0 +1,143 @@
+package toolbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitTextIntoPages(t *testing.T) {
+	if pages := splitTextIntoPages("", 10); len(pages) != 1 || pages[0].text != "" {
+		t.Fatalf("%+v", pages)
+	}
+	pages := splitTextIntoPages("aaaa bbbb cccc\ndddd 零一二三四五", 12)
+	var texts []string
+	for _, page := range pages {
+		if len(page.text) > 12 {
+			t.Fatalf("%+v", pages)
+		}
+		texts = append(texts, page.text)
+	}
+	if strings.Join(texts, "|") != "aaaa bbbb|cccc\ndddd|零一二三|四五" {
+		t.Fatalf("%+v", pages)
+	}
+	if pages[1].offset != 10 || pages[3].offset != 32 {
+		t.Fatalf("%+v", pages)
+	}
+}
+
+func TestWebReader_Execute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var article strings.Builder
+		for i := 0; i < 20; i++ {
+			article.WriteString(fmt.Sprintf("<p>Paragraph number %d, it has a few words, and a comma or two.</p>", i))
+		}
+		fmt.Fprintf(w, `<html><head><title>Home</title></head><body><nav><a href="/">Home</a></nav>
+<article>%s<p>Read <a href="/second">the second page</a>, or <a href="/text.txt">plain text</a>.</p></article></body></html>`, article.String())
+	})
+	mux.HandleFunc("/second", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<title>Second</title><p>This is the second page, with fewer words.</p>`))
+	})
+	mux.HandleFunc("/text.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("plain text content"))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("not text"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	reader := WebReader{}
+	if !reader.IsConfigured() {
+		t.Fatal("should be configured")
+	}
+	if err := reader.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	run := func(content string, maxLen int) *Result {
+		return reader.Execute(context.Background(), Command{TimeoutSec: 10, Content: content, MaxOutputLength: maxLen})
+	}
+	for _, content := range []string{"", "next", "links", "follow 1", "1"} {
+		if ret := run(content, 0); ret.Error != ErrWebReaderNoPage && !strings.Contains(ret.ErrText(), "link number") {
+			t.Fatal(content, ret)
+		}
+	}
+	for _, content := range []string{"page x", "go", "ftp://example.com", "next 1 2"} {
+		if ret := run(content, 0); ret.Error != ErrBadWebReaderParam {
+			t.Fatal(content, ret)
+		}
+	}
+	if ret := run(server.URL+"/image.png", 0); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	// Read the home page a page at a time, each page fits into the output length.
+	ret := run(server.URL, 200)
+	if ret.Error != nil || !strings.HasPrefix(ret.Output, "[1/7] Home\n\nParagraph number 0,") || len(ret.Output) > 200 {
+		t.Fatalf("%+v", ret)
+	}
+	for i := 2; i <= 7; i++ {
+		if ret := run("next", 200); ret.Error != nil || !strings.HasPrefix(ret.Output, fmt.Sprintf("[%d/7] ", i)) || len(ret.Output) > 200 {
+			t.Fatalf("%+v", ret)
+		}
+	}
+	if ret := run("next", 200); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if ret := run("", 200); ret.Error != nil || !strings.HasSuffix(ret.Output, "Read the second page[1], or plain text[2].") {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("prev", 200); ret.Error != nil || !strings.HasPrefix(ret.Output, "[6/7] ") {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("page 1", 0); ret.Error != nil || !strings.HasPrefix(ret.Output, "[1/2] Home") || len(ret.Output) > WebReaderDefaultPageLength+10 {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("page 3", 0); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	// List the links, article links come first.
+	expectedLinks := fmt.Sprintf("[1/1] 1. the second page %s/second\n2. plain text %s/text.txt\n3. Home %s/", server.URL, server.URL, server.URL)
+	if ret := run("links", 0); ret.Error != nil || ret.Output != expectedLinks {
+		t.Fatalf("%+v", ret)
+	}
+	// Follow links and go back
+	if ret := run("follow 1", 0); ret.Error != nil || ret.Output != "[1/1] Second\n\nThis is the second page, with fewer words." {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("back", 0); ret.Error != nil || !strings.HasPrefix(ret.Output, "[1/2] Home") {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("2", 0); ret.Error != nil || ret.Output != "[1/1] "+server.URL+"/text.txt\n\nplain text content" {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("f 4", 0); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if ret := run("b", 0); ret.Error != nil || !strings.HasPrefix(ret.Output, "[1/2] Home") {
+		t.Fatalf("%+v", ret)
+	}
+	if ret := run("back", 0); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	// Go back after failing to go back
+	if ret := run("go "+server.URL+"/second", 0); ret.Error != nil {
+		t.Fatalf("%+v", ret)
+	}
+	server.Close()
+	if ret := run("back", 0); ret.Error == nil {
+		t.Fatal("did not error")
+	}
+	if len(reader.history) != 1 {
+		t.Fatal(reader.history)
+	}
+}
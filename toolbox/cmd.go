@@ -29,6 +29,8 @@ type Command struct {
 	TimeoutSec int
 	// Content is the app command input.
 	Content string
+	// MaxOutputLength is the maximum length of output that the daemon presents to user, 0 means unlimited. Apps may use it to paginate output.
+	MaxOutputLength int
 }
 
 // Modify command content to remove leading and trailing white spaces. Return error result if command becomes empty afterwards.
@@ -65,12 +67,20 @@ type Feature interface {
 	Execute(context.Context, Command) *Result // Execute the command with trigger prefix removed, and return execution result.
 }
 
+// ResultAttachment is a file produced by a feature alongside its text output, such as a web page screenshot.
+type ResultAttachment struct {
+	FileName    string // FileName is the name of the file presented to user
+	ContentType string // ContentType is the media type of file content
+	Content     []byte // Content is the file content
+}
+
 // Feature's execution result that includes human readable output and error (if any).
 type Result struct {
-	Command        Command // Help CommandProcessor to keep track of command in execution result
-	Error          error   // Result error if there is any
-	Output         string  // Human readable normal output excluding error text
-	CombinedOutput string  // Human readable error text + normal output. This is set when calling SetCombinedText() function.
+	Command        Command            // Help CommandProcessor to keep track of command in execution result
+	Error          error              // Result error if there is any
+	Output         string             // Human readable normal output excluding error text
+	CombinedOutput string             // Human readable error text + normal output. This is set when calling SetCombinedText() function.
+	Attachments    []ResultAttachment // Files produced by the feature, they are delivered by daemons capable of transporting files.
 }
 
 // Return error text or empty string if error is absent.
@@ -3,6 +3,7 @@ package toolbox
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -118,6 +119,51 @@ func (proc *CommandProcessor) IsEmpty() bool {
 	return false
 }
 
+/*
+MatchPIN returns true only if the input is exactly one of the password PINs of the PIN filter. Interactive sessions
+use it to let user enter the PIN only once upon login. Each attempt counts towards the internal rate limit, which
+slows down an attacker who tries to guess the PIN.
+*/
+func (proc *CommandProcessor) MatchPIN(pin string) bool {
+	proc.initialiseOnce()
+	if !proc.rateLimit.Add("instance", true) {
+		return false
+	}
+	var matched bool
+	for _, cmdFilter := range proc.CommandFilters {
+		if pinFilter, ok := cmdFilter.(*PINAndShortcuts); ok {
+			for _, password := range pinFilter.Passwords {
+				if password != "" && subtle.ConstantTimeCompare([]byte(pin), []byte(password)) == 1 {
+					matched = true
+				}
+			}
+		}
+	}
+	return matched
+}
+
+/*
+MatchTOTP returns true only if the input is one of the TOTP codes derived from the password PINs of the PIN filter,
+which are the same codes accepted in place of password PIN by app commands. Each attempt counts towards the internal
+rate limit.
+*/
+func (proc *CommandProcessor) MatchTOTP(code string) bool {
+	proc.initialiseOnce()
+	if len(code) != 12 || !proc.rateLimit.Add("instance", true) {
+		return false
+	}
+	for _, cmdFilter := range proc.CommandFilters {
+		if pinFilter, ok := cmdFilter.(*PINAndShortcuts); ok {
+			for _, password := range pinFilter.Passwords {
+				if getTOTP(password)[code] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 /*
 From the prospect of Internet-facing mail processor and Twilio hooks, check that parameters are within sane range.
 Return a zero-length slice if everything looks OK.
@@ -276,9 +322,9 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 	// Look for command's prefix among configured features
 	for prefix, configuredFeature := range proc.Features.LookupByTrigger {
 		if cmd.FindAndRemovePrefix(string(prefix)) {
-			// Hacky workaround - do not log content of AES decryption commands as they can reveal encryption key
-			if prefix == AESDecryptTrigger || prefix == TwoFATrigger {
-				logCommandContent = "<hidden due to AESDecryptTrigger or TwoFATrigger>"
+			// Hacky workaround - do not log content of AES decryption and vault commands as they can reveal encryption key
+			if prefix == AESDecryptTrigger || prefix == TwoFATrigger || prefix == SecretVaultTrigger || prefix == BrowserRecipesTrigger {
+				logCommandContent = "<hidden due to AESDecryptTrigger, TwoFATrigger, SecretVaultTrigger, or BrowserRecipesTrigger>"
 			}
 			matchedFeature = configuredFeature
 			break
@@ -294,6 +340,17 @@ func (proc *CommandProcessor) Process(ctx context.Context, cmd Command, runResul
 	defer func() {
 		proc.logger.Info("Process", fmt.Sprintf("%s-%s", cmd.DaemonName, cmd.ClientID), nil, "completed \"%s\" (ok? %v post-process reslt? %v)", logCommandContent, ret.Error == nil, runResultFilters)
 	}()
+	// Let the feature know how much of its output the result filters will retain
+	if runResultFilters {
+		for _, resultFilter := range proc.ResultFilters {
+			if lint, isLintText := resultFilter.(*LintText); isLintText {
+				cmd.MaxOutputLength = lint.MaxLength
+				if hasOverrideLintText {
+					cmd.MaxOutputLength = overrideLintText.MaxLength
+				}
+			}
+		}
+	}
 	ret = matchedFeature.Execute(ctx, cmd)
 result:
 	// Command in the result structure is mainly used for logging purpose
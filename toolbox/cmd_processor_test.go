@@ -45,7 +45,7 @@ func TestCommandProcessor_NonWindows(t *testing.T) {
 	// Run a failing command - be aware of the word substitution conducted by command filter
 	cmd = Command{TimeoutSec: 5, Content: "mypin.secho alpha; does-not-exist"}
 	result = proc.Process(context.Background(), cmd, true)
-	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta; does-not-exist"}) ||
+	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta; does-not-exist", MaxOutputLength: 2}) ||
 		result.Error == nil || !strings.Contains(result.Output, "beta") || result.CombinedOutput != result.Error.Error()[0:2] {
 		t.Fatalf("%+v", result)
 	}
@@ -61,21 +61,21 @@ func TestCommandProcessor_NonWindows(t *testing.T) {
 	// Run a valid command - be aware of the word substitution conducted by command filter
 	cmd = Command{TimeoutSec: 5, Content: "mypin.secho alpha"}
 	result = proc.Process(context.Background(), cmd, true)
-	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta"}) ||
+	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta", MaxOutputLength: 2}) ||
 		result.Error != nil || !strings.Contains(result.Output, "beta") || result.CombinedOutput != "be" {
 		t.Fatalf("%+v", result)
 	}
 	// Run the same command using the alternative & valid password PIN
 	cmd = Command{TimeoutSec: 5, Content: "myaltpin.secho alpha"}
 	result = proc.Process(context.Background(), cmd, true)
-	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta"}) ||
+	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".secho beta", MaxOutputLength: 2}) ||
 		result.Error != nil || !strings.Contains(result.Output, "beta") || result.CombinedOutput != "be" {
 		t.Fatalf("%+v", result)
 	}
 	// Test the tolerance to extra spaces in feature prefix matcher
 	cmd = Command{TimeoutSec: 5, Content: " mypin .s echo alpha "}
 	result = proc.Process(context.Background(), cmd, true)
-	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".s echo beta"}) ||
+	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 5, Content: ".s echo beta", MaxOutputLength: 2}) ||
 		result.Error != nil || !strings.Contains(result.Output, "beta") || result.CombinedOutput != "be" {
 		t.Fatalf("%+v", result)
 	}
@@ -90,7 +90,7 @@ func TestCommandProcessor_NonWindows(t *testing.T) {
 	// Override PLT using good PLT parameter values
 	cmd = Command{TimeoutSec: 1, Content: "mypin  .plt  2, 5. 4  .s  sleep 2 ; echo 0123456789 "}
 	result = proc.Process(context.Background(), cmd, true)
-	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 4, Content: "  .s  sleep 2 ; echo 0123456789"}) ||
+	if !reflect.DeepEqual(result.Command, Command{TimeoutSec: 4, Content: "  .s  sleep 2 ; echo 0123456789", MaxOutputLength: 5}) ||
 		result.Error != nil || !strings.Contains(result.Output, "0123456789") || result.CombinedOutput != "23456" {
 		t.Fatalf("%v | %v | %v | %+v", result.Error, result.Output, result.CombinedOutput, result.Command)
 	}
@@ -236,16 +236,18 @@ func TestGetTestCommandProcessor(t *testing.T) {
 
 func TestConcealedLogMessages(t *testing.T) {
 	proc := GetTestCommandProcessor()
-	// These two features are the ones to be concealed from log
+	// These three features are the ones to be concealed from log
 	proc.Features.AESDecrypt = GetTestAESDecrypt()
 	proc.Features.TwoFACodeGenerator = TwoFACodeGenerator{SecretFile: GetTestAESDecrypt().EncryptedFiles[TestAESDecryptFileAlphaName]}
-	// Reinitialise features so that it understands the two new prefixes
+	proc.Features.SecretVault = GetTestSecretVault()
+	// Reinitialise features so that it understands the three new prefixes
 	if err := proc.Features.Initialise(); err != nil {
 		t.Fatal(err)
 	}
 	proc.Process(context.Background(), Command{Content: "verysecret .a does not matter", TimeoutSec: 10}, true)
 	proc.Process(context.Background(), Command{Content: "verysecret .2 does not matter", TimeoutSec: 10}, true)
-	t.Log("Please observe <hidden due to AESDecryptTrigger or TwoFATrigger> from log output, otherwise consider this test is failed")
+	proc.Process(context.Background(), Command{Content: "verysecret .v does not matter", TimeoutSec: 10}, true)
+	t.Log("Please observe <hidden due to AESDecryptTrigger, TwoFATrigger, or SecretVaultTrigger> from log output, otherwise consider this test is failed")
 }
 
 func TestGetEmptyCommandProcessor(t *testing.T) {
@@ -258,3 +260,38 @@ func TestGetEmptyCommandProcessor(t *testing.T) {
 		t.Fatal("did not error")
 	}
 }
+
+func TestCommandProcessor_MatchPIN(t *testing.T) {
+	proc := GetTestCommandProcessor()
+	if !proc.MatchPIN(TestCommandProcessorPIN) {
+		t.Fatal("did not match")
+	}
+	for _, pin := range []string{"", "verysecre", "verysecret ", TestCommandProcessorPIN + " .s echo"} {
+		if proc.MatchPIN(pin) {
+			t.Fatal("should not have matched", pin)
+		}
+	}
+	if GetEmptyCommandProcessor().MatchPIN("") {
+		t.Fatal("should not have matched")
+	}
+}
+
+func TestCommandProcessor_MatchTOTP(t *testing.T) {
+	proc := &CommandProcessor{CommandFilters: []CommandFilter{&PINAndShortcuts{Passwords: []string{TestCommandProcessorPIN}}}}
+	_, code1, _, err := GetTwoFACodes(TestCommandProcessorPIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code2, _, err := GetTwoFACodes("tercesyrev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proc.MatchTOTP(code1 + code2) {
+		t.Fatal("did not match")
+	}
+	for _, code := range []string{"", code1, code2 + code1, TestCommandProcessorPIN, code1 + code2 + "0"} {
+		if proc.MatchTOTP(code) {
+			t.Fatal("should not have matched", code)
+		}
+	}
+}
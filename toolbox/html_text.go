@@ -0,0 +1,518 @@
+package toolbox
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// htmlVoidElements are the elements that never have content or an end tag.
+	htmlVoidElements = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+		"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+	}
+	// htmlRawTextElements are the elements whose content is not markup.
+	htmlRawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+	// htmlBlockElements are the elements rendered on lines of their own.
+	htmlBlockElements = map[string]bool{
+		"address": true, "article": true, "blockquote": true, "dd": true, "div": true, "dl": true, "dt": true,
+		"figcaption": true, "figure": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"hr": true, "li": true, "main": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+		"tr": true, "ul": true,
+	}
+	// htmlBoilerplateElements are the elements that never contribute to readable text.
+	htmlBoilerplateElements = map[string]bool{
+		"aside": true, "audio": true, "button": true, "canvas": true, "embed": true, "footer": true, "form": true,
+		"head": true, "header": true, "iframe": true, "nav": true, "noscript": true, "object": true, "script": true,
+		"select": true, "style": true, "svg": true, "template": true, "textarea": true, "title": true, "video": true,
+	}
+	// htmlImplicitlyClosedBy maps an element to the opening elements that implicitly end it.
+	htmlImplicitlyClosedBy = map[string]map[string]bool{
+		"p":      htmlBlockElements,
+		"li":     {"li": true},
+		"dt":     {"dt": true, "dd": true},
+		"dd":     {"dt": true, "dd": true},
+		"tr":     {"tr": true},
+		"td":     {"td": true, "th": true, "tr": true},
+		"th":     {"td": true, "th": true, "tr": true},
+		"option": {"option": true},
+	}
+
+	// RegexHTMLUnlikelyContent matches class names and IDs of page elements that are unlikely to be a part of article.
+	RegexHTMLUnlikelyContent = regexp.MustCompile(`(?i)advert|\bads?\b|banner|breadcrumb|comment|cookie|footer|masthead|menu|modal|\bnav|newsletter|popup|promo|related|share|sidebar|social|sponsor|subscribe`)
+	// RegexHTMLLikelyContent matches class names and IDs of page elements that are likely to be a part of article.
+	RegexHTMLLikelyContent = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+	// RegexHTMLHiddenStyle matches inline style that hides an element.
+	RegexHTMLHiddenStyle = regexp.MustCompile(`(?i)display\s*:\s*none|visibility\s*:\s*hidden`)
+	// RegexMultipleBlankLines matches consecutive blank lines.
+	RegexMultipleBlankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLNode is an element or a piece of text in a document parsed by ParseHTML.
+type HTMLNode struct {
+	Tag      string            // Tag is the lower case element name, it is empty for a text node.
+	Attrs    map[string]string // Attrs are the element attributes with lower case names.
+	Text     string            // Text is the unescaped content of a text node.
+	Children []*HTMLNode
+	Parent   *HTMLNode
+}
+
+// appendChild makes the node a child of this element, adjacent text nodes are merged into one.
+func (node *HTMLNode) appendChild(child *HTMLNode) {
+	if last := len(node.Children) - 1; child.Tag == "" && last >= 0 && node.Children[last].Tag == "" {
+		node.Children[last].Text += child.Text
+		return
+	}
+	child.Parent = node
+	node.Children = append(node.Children, child)
+}
+
+// Find returns the first element of the tag name among this node and its descendants, or nil if there is none.
+func (node *HTMLNode) Find(tag string) *HTMLNode {
+	if node.Tag == tag {
+		return node
+	}
+	for _, child := range node.Children {
+		if found := child.Find(tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// InnerText returns the text content of this node and its descendants, with white spaces compressed.
+func (node *HTMLNode) InnerText() string {
+	var text strings.Builder
+	node.walk(func(n *HTMLNode) bool {
+		if n.Tag == "" {
+			text.WriteString(n.Text)
+			text.WriteRune(' ')
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// walk visits this node and its descendants in document order, it skips the descendants of a node if fun returns false.
+func (node *HTMLNode) walk(fun func(*HTMLNode) bool) {
+	if !fun(node) {
+		return
+	}
+	for _, child := range node.Children {
+		child.walk(fun)
+	}
+}
+
+/*
+ParseHTML reads an HTML document into a tree of nodes. The parser is tolerant of malformed documents, it does not
+implement the HTML5 parsing algorithm but does well enough on the documents found in the wild for extracting text.
+The returned root node has an empty tag name.
+*/
+func ParseHTML(doc string) *HTMLNode {
+	root := &HTMLNode{}
+	current := root
+	for pos := 0; pos < len(doc); {
+		if doc[pos] != '<' {
+			// Text continues until the next tag
+			end := strings.IndexByte(doc[pos+1:], '<')
+			if end == -1 {
+				end = len(doc)
+			} else {
+				end += pos + 1
+			}
+			current.appendChild(&HTMLNode{Text: html.UnescapeString(doc[pos:end])})
+			pos = end
+			continue
+		}
+		rest := doc[pos:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			// Skip comment
+			if end := strings.Index(rest[4:], "-->"); end == -1 {
+				pos = len(doc)
+			} else {
+				pos += 4 + end + 3
+			}
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			// Skip doctype and processing instruction
+			if end := strings.IndexByte(rest, '>'); end == -1 {
+				pos = len(doc)
+			} else {
+				pos += end + 1
+			}
+		case strings.HasPrefix(rest, "</"):
+			end := strings.IndexByte(rest, '>')
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			tag := strings.ToLower(strings.TrimSpace(strings.Fields(rest[2:end] + " ")[0]))
+			// Close the nearest open element of the same name, an end tag that closes nothing is ignored.
+			for open := current; open != root; open = open.Parent {
+				if open.Tag == tag {
+					current = open.Parent
+					break
+				}
+			}
+			pos += end + 1
+		case len(rest) > 1 && unicode.IsLetter(rune(rest[1])):
+			elem, selfClosing, length := parseHTMLStartTag(rest)
+			pos += length
+			// Some elements end implicitly when another element opens
+			for current != root {
+				if closedBy, exists := htmlImplicitlyClosedBy[current.Tag]; exists && closedBy[elem.Tag] {
+					current = current.Parent
+					continue
+				}
+				break
+			}
+			current.appendChild(elem)
+			if htmlRawTextElements[elem.Tag] && !selfClosing {
+				// Content of script and alike continues until the end tag
+				end := strings.Index(strings.ToLower(doc[pos:]), "</"+elem.Tag)
+				if end == -1 {
+					end = len(doc) - pos
+				}
+				elem.appendChild(&HTMLNode{Text: doc[pos : pos+end]})
+				if elem.Tag == "textarea" || elem.Tag == "title" {
+					elem.Children[0].Text = html.UnescapeString(elem.Children[0].Text)
+				}
+				pos += end
+				if closing := strings.IndexByte(doc[pos:], '>'); closing == -1 {
+					pos = len(doc)
+				} else {
+					pos += closing + 1
+				}
+			} else if !htmlVoidElements[elem.Tag] && !selfClosing {
+				current = elem
+			}
+		default:
+			// A stray less-than sign is text
+			current.appendChild(&HTMLNode{Text: "<"})
+			pos++
+		}
+	}
+	return root
+}
+
+// parseHTMLStartTag reads the element name and attributes of a start tag, and returns the tag length.
+func parseHTMLStartTag(s string) (elem *HTMLNode, selfClosing bool, length int) {
+	elem = &HTMLNode{Attrs: make(map[string]string)}
+	pos := 1
+	for pos < len(s) && !unicode.IsSpace(rune(s[pos])) && s[pos] != '>' && s[pos] != '/' {
+		pos++
+	}
+	elem.Tag = strings.ToLower(s[1:pos])
+	for pos < len(s) {
+		switch c := s[pos]; {
+		case c == '>':
+			return elem, selfClosing, pos + 1
+		case c == '/':
+			selfClosing = true
+			pos++
+		case unicode.IsSpace(rune(c)):
+			pos++
+		default:
+			selfClosing = false
+			nameStart := pos
+			for pos < len(s) && !unicode.IsSpace(rune(s[pos])) && s[pos] != '=' && s[pos] != '>' && s[pos] != '/' {
+				pos++
+			}
+			name := strings.ToLower(s[nameStart:pos])
+			for pos < len(s) && unicode.IsSpace(rune(s[pos])) {
+				pos++
+			}
+			var value string
+			if pos < len(s) && s[pos] == '=' {
+				pos++
+				for pos < len(s) && unicode.IsSpace(rune(s[pos])) {
+					pos++
+				}
+				if pos < len(s) && (s[pos] == '"' || s[pos] == '\'') {
+					quote := s[pos]
+					end := strings.IndexByte(s[pos+1:], quote)
+					if end == -1 {
+						end = len(s) - pos - 1
+					}
+					value = s[pos+1 : pos+1+end]
+					pos += end + 2
+				} else {
+					valueStart := pos
+					for pos < len(s) && !unicode.IsSpace(rune(s[pos])) && s[pos] != '>' {
+						pos++
+					}
+					value = s[valueStart:pos]
+				}
+			}
+			if _, exists := elem.Attrs[name]; !exists && name != "" {
+				elem.Attrs[name] = html.UnescapeString(value)
+			}
+		}
+	}
+	return elem, selfClosing, len(s)
+}
+
+// HTMLLink is a hyper link found in readable text.
+type HTMLLink struct {
+	Text string // Text is the link text, or the link URL if the link does not have text.
+	URL  string // URL is the absolute link address.
+}
+
+// ReadableHTML is the readable text extracted from an HTML document.
+type ReadableHTML struct {
+	Title string     // Title is the document title.
+	Text  string     // Text is the article text, each link is followed by its number (starting from 1) in square brackets.
+	Links []HTMLLink // Links are the article links followed by the rest of links on the page.
+}
+
+/*
+ExtractReadableHTML removes boilerplate such as navigation, scripts, advertisement, and comments from the HTML
+document, and then finds the element that most likely contains the article using a simple scoring scheme similar to
+that of the readability browser feature. Relative links are resolved against the page URL.
+*/
+func ExtractReadableHTML(pageURL *url.URL, doc string) ReadableHTML {
+	root := ParseHTML(doc)
+	var ret ReadableHTML
+	if title := root.Find("title"); title != nil {
+		ret.Title = title.InnerText()
+	}
+	if ret.Title == "" {
+		ret.Title = pageURL.String()
+	}
+	if base := root.Find("base"); base != nil && base.Attrs["href"] != "" {
+		if baseURL, err := pageURL.Parse(base.Attrs["href"]); err == nil {
+			pageURL = baseURL
+		}
+	}
+	// Remember all links on the page, including those among the boilerplate, before removing the boilerplate.
+	var allLinks []*HTMLNode
+	root.walk(func(n *HTMLNode) bool {
+		if n.Tag == "a" {
+			allLinks = append(allLinks, n)
+			return false
+		}
+		return true
+	})
+	removeHTMLBoilerplate(root)
+	article := findHTMLArticle(root)
+	renderer := &htmlTextRenderer{pageURL: pageURL, linkNumber: make(map[string]int)}
+	renderer.render(article, false)
+	// Number the remaining links on the page after those of the article
+	for _, link := range allLinks {
+		renderer.addLink(link)
+	}
+	ret.Links = renderer.links
+	// Trim spaces around each line and remove excessive blank lines
+	lines := strings.Split(renderer.out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	ret.Text = strings.TrimSpace(RegexMultipleBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+	return ret
+}
+
+// removeHTMLBoilerplate removes the elements that do not contribute to readable text from the tree.
+func removeHTMLBoilerplate(node *HTMLNode) {
+	kept := node.Children[:0]
+	for _, child := range node.Children {
+		if child.Tag != "" {
+			classAndID := child.Attrs["class"] + " " + child.Attrs["id"]
+			if htmlBoilerplateElements[child.Tag] ||
+				RegexHTMLHiddenStyle.MatchString(child.Attrs["style"]) ||
+				strings.EqualFold(child.Attrs["aria-hidden"], "true") ||
+				hasHTMLAttr(child, "hidden") ||
+				(child.Tag != "body" && child.Tag != "html" && child.Tag != "article" && child.Tag != "main" &&
+					RegexHTMLUnlikelyContent.MatchString(classAndID) && !RegexHTMLLikelyContent.MatchString(classAndID)) {
+				continue
+			}
+			removeHTMLBoilerplate(child)
+		}
+		kept = append(kept, child)
+	}
+	node.Children = kept
+}
+
+// hasHTMLAttr returns true only if the element has the attribute, even if the attribute has no value.
+func hasHTMLAttr(node *HTMLNode, name string) bool {
+	_, exists := node.Attrs[name]
+	return exists
+}
+
+// findHTMLArticle returns the element that most likely contains the article, or the document body if nothing stands out.
+func findHTMLArticle(root *HTMLNode) *HTMLNode {
+	scores := make(map[*HTMLNode]float64)
+	root.walk(func(n *HTMLNode) bool {
+		if n.Tag != "p" && n.Tag != "pre" && n.Tag != "td" && n.Tag != "blockquote" {
+			return true
+		}
+		text := n.InnerText()
+		if len(text) < 25 || n.Parent == nil {
+			return false
+		}
+		// Long paragraphs with plenty of commas are likely to be a part of article
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，"))
+		if extra := float64(len(text) / 100); extra < 3 {
+			score += extra
+		} else {
+			score += 3
+		}
+		scores[n.Parent] += score
+		if n.Parent.Parent != nil {
+			scores[n.Parent.Parent] += score / 2
+		}
+		return false
+	})
+	var best *HTMLNode
+	var bestScore float64
+	for candidate, score := range scores {
+		switch candidate.Tag {
+		case "article", "main":
+			score += 5
+		}
+		if RegexHTMLLikelyContent.MatchString(candidate.Attrs["class"] + " " + candidate.Attrs["id"]) {
+			score += 3
+		}
+		// Penalise the candidate that consists mostly of links
+		score *= 1 - htmlLinkDensity(candidate)
+		if best == nil || score > bestScore {
+			best = candidate
+			bestScore = score
+		}
+	}
+	if best != nil && best.Tag != "" {
+		return best
+	}
+	if body := root.Find("body"); body != nil {
+		return body
+	}
+	return root
+}
+
+// htmlLinkDensity returns the ratio between length of link text and length of all text in the element.
+func htmlLinkDensity(node *HTMLNode) float64 {
+	textLen := len(node.InnerText())
+	if textLen == 0 {
+		return 0
+	}
+	var linkLen int
+	node.walk(func(n *HTMLNode) bool {
+		if n.Tag == "a" {
+			linkLen += len(n.InnerText())
+			return false
+		}
+		return true
+	})
+	return float64(linkLen) / float64(textLen)
+}
+
+// htmlTextRenderer turns an element tree into plain text and numbers the links along the way.
+type htmlTextRenderer struct {
+	pageURL    *url.URL
+	out        strings.Builder
+	links      []HTMLLink
+	linkNumber map[string]int // linkNumber finds the link number (starting from 1) by absolute URL.
+}
+
+// addLink numbers the link element and returns the number, or 0 if the link does not lead to another page.
+func (r *htmlTextRenderer) addLink(node *HTMLNode) int {
+	href := strings.TrimSpace(node.Attrs["href"])
+	if href == "" || strings.HasPrefix(href, "#") {
+		return 0
+	}
+	linkURL, err := r.pageURL.Parse(href)
+	if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+		return 0
+	}
+	linkURL.Fragment = ""
+	absURL := linkURL.String()
+	if num, exists := r.linkNumber[absURL]; exists {
+		return num
+	}
+	text := node.InnerText()
+	if text == "" {
+		// Use the description of image as link text
+		node.walk(func(n *HTMLNode) bool {
+			if text == "" {
+				text = strings.TrimSpace(n.Attrs["alt"] + " " + n.Attrs["title"])
+			}
+			return text == ""
+		})
+	}
+	if text == "" {
+		text = absURL
+	}
+	r.links = append(r.links, HTMLLink{Text: text, URL: absURL})
+	r.linkNumber[absURL] = len(r.links)
+	return len(r.links)
+}
+
+// newLine starts a new line unless the output is already at the beginning of a line.
+func (r *htmlTextRenderer) newLine() {
+	if s := r.out.String(); len(s) > 0 && s[len(s)-1] != '\n' {
+		r.out.WriteRune('\n')
+	}
+}
+
+// space separates words unless the output already ends with a white space.
+func (r *htmlTextRenderer) space() {
+	if s := r.out.String(); len(s) > 0 && s[len(s)-1] != ' ' && s[len(s)-1] != '\n' {
+		r.out.WriteRune(' ')
+	}
+}
+
+// render writes the text of the node and its descendants to output.
+func (r *htmlTextRenderer) render(node *HTMLNode, preformatted bool) {
+	if node.Tag == "" && node.Parent != nil {
+		if preformatted {
+			r.out.WriteString(node.Text)
+		} else if len(node.Text) > 0 {
+			if unicode.IsSpace(rune(node.Text[0])) {
+				r.space()
+			}
+			r.out.WriteString(strings.Join(strings.Fields(node.Text), " "))
+			if unicode.IsSpace(rune(node.Text[len(node.Text)-1])) {
+				r.space()
+			}
+		}
+		return
+	}
+	switch node.Tag {
+	case "br":
+		r.out.WriteRune('\n')
+		return
+	case "img":
+		return
+	case "td", "th":
+		r.space()
+	}
+	isBlock := htmlBlockElements[node.Tag]
+	if isBlock {
+		r.newLine()
+		if node.Tag == "p" || node.Tag == "pre" || node.Tag == "blockquote" || node.Tag[0] == 'h' {
+			r.out.WriteRune('\n')
+		}
+	}
+	switch node.Tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		r.out.WriteString("# ")
+	case "li":
+		r.out.WriteString("- ")
+	}
+	for _, child := range node.Children {
+		r.render(child, preformatted || node.Tag == "pre")
+	}
+	if node.Tag == "a" {
+		if num := r.addLink(node); num > 0 {
+			r.out.WriteString("[" + strconv.Itoa(num) + "]")
+		}
+	}
+	if isBlock {
+		r.newLine()
+		if node.Tag == "p" || node.Tag == "pre" || node.Tag == "blockquote" || node.Tag[0] == 'h' {
+			r.out.WriteRune('\n')
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package toolbox
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseHTML(t *testing.T) {
+	root := ParseHTML(`<!DOCTYPE html><html><head><title>a &amp; b</title><script>if (1<2) {document.write("</p>")}</script></head>
+<body><p class=first id='x'>one<p>two<br/>three</div><ul><li>a<li>b</ul><img src="x.png" alt="pic">tail &lt; 3 < 4<!-- comment --></body></html>`)
+	if title := root.Find("title"); title == nil || title.InnerText() != "a & b" {
+		t.Fatalf("%+v", title)
+	}
+	if script := root.Find("script"); script == nil || script.Children[0].Text != `if (1<2) {document.write("</p>")}` {
+		t.Fatalf("%+v", script)
+	}
+	body := root.Find("body")
+	if body == nil {
+		t.Fatal("no body")
+	}
+	var tags []string
+	for _, child := range body.Children {
+		tags = append(tags, child.Tag)
+	}
+	// Paragraphs are closed implicitly, the stray closing div is ignored.
+	if !reflect.DeepEqual(tags, []string{"p", "p", "ul", "img", ""}) {
+		t.Fatal(tags)
+	}
+	if first := body.Children[0]; first.Attrs["class"] != "first" || first.Attrs["id"] != "x" || first.InnerText() != "one" {
+		t.Fatalf("%+v", first)
+	}
+	if second := body.Children[1]; len(second.Children) != 3 || second.Children[1].Tag != "br" {
+		t.Fatalf("%+v", second)
+	}
+	if list := body.Children[2]; len(list.Children) != 2 || list.InnerText() != "a b" {
+		t.Fatalf("%+v", list)
+	}
+	if body.Children[3].Attrs["alt"] != "pic" || body.InnerText() != "one two three a b tail < 3 < 4" {
+		t.Fatal(body.InnerText())
+	}
+}
+
+func TestExtractReadableHTML(t *testing.T) {
+	pageURL, err := url.Parse("http://example.com/news/today.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readable := ExtractReadableHTML(pageURL, `<html><head><title>Today's news</title></head><body>
+<nav><a href="/">Home</a> <a href="/sports">Sports</a></nav>
+<div class="sidebar"><a href="/ad">Buy now, limited offer, while stocks last</a></div>
+<div id="main-content">
+	<h1>Big   news</h1>
+	<p>Something happened today, and it is big, very big, <a href="story2.html">bigger</a> than yesterday.</p>
+	<p style="display: none">Hidden paragraph that must not show up, really, it must not.</p>
+	<p>Another paragraph, followed by a list of facts, and then <a href="#top">top</a>:</p>
+	<ul><li>Fact <a href="/facts/1"><img alt="one"></a></li><li>Fact two</li></ul>
+	<pre>keep   spaces</pre>
+</div>
+<div class="comments"><p>First comment, nobody reads me, I am not a part of article.</p></div>
+<footer>Copyright</footer>
+</body></html>`)
+	if readable.Title != "Today's news" {
+		t.Fatal(readable.Title)
+	}
+	expectedText := `# Big news
+
+Something happened today, and it is big, very big, bigger[1] than yesterday.
+
+Another paragraph, followed by a list of facts, and then top:
+
+- Fact [2]
+- Fact two
+
+keep   spaces`
+	if readable.Text != expectedText {
+		t.Fatalf("\n%s\n", readable.Text)
+	}
+	// Article links come first, followed by the rest of links on the page.
+	expectedLinks := []HTMLLink{
+		{Text: "bigger", URL: "http://example.com/news/story2.html"},
+		{Text: "one", URL: "http://example.com/facts/1"},
+		{Text: "Home", URL: "http://example.com/"},
+		{Text: "Sports", URL: "http://example.com/sports"},
+		{Text: "Buy now, limited offer, while stocks last", URL: "http://example.com/ad"},
+	}
+	if !reflect.DeepEqual(readable.Links, expectedLinks) {
+		t.Fatalf("%+v", readable.Links)
+	}
+	// Without paragraphs the entire body is the article
+	readable = ExtractReadableHTML(pageURL, `<base href="http://example.org/a/"><body>Hello <b>world</b> <a href="b">b</a></body>`)
+	if readable.Title != pageURL.String() || readable.Text != "Hello world b[1]" ||
+		!reflect.DeepEqual(readable.Links, []HTMLLink{{Text: "b", URL: "http://example.org/a/b"}}) {
+		t.Fatalf("%+v", readable)
+	}
+}
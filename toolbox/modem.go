@@ -0,0 +1,354 @@
+package toolbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/lalog"
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+const (
+	// ModemDefaultBaudRate is the serial communication speed used by modem unless otherwise configured.
+	ModemDefaultBaudRate = 115200
+	// ModemCommandTimeoutSec is the maximum number of seconds to wait for the response of an AT command.
+	ModemCommandTimeoutSec = 10
+	// ModemSendSMSTimeoutSec is the maximum number of seconds to wait for the network to accept an outgoing SMS.
+	ModemSendSMSTimeoutSec = 60
+	// MaxSMSLength is the maximum length of a single SMS in text mode.
+	MaxSMSLength = 160
+
+	modemCtrlZ = "\x1a" // Ctrl+Z ends the text of an outgoing SMS
+)
+
+var (
+	// ErrModemTimeout is returned when modem does not respond to an AT command in time.
+	ErrModemTimeout = errors.New("modem did not respond in time")
+	// ErrModemClosed is returned when the modem device is closed or fails to read.
+	ErrModemClosed = errors.New("modem device is closed")
+	// ErrBadSMSRecipient is returned when an outgoing SMS is addressed to a malformed telephone number.
+	ErrBadSMSRecipient = errors.New("telephone number must be made of digits and may begin with +")
+	// ErrSMSTooLong is returned when the text of an outgoing SMS exceeds the maximum length.
+	ErrSMSTooLong = fmt.Errorf("SMS text must not exceed %d characters", MaxSMSLength)
+
+	// RegexSMSListEntry captures message index, status, and sender's number from a header line of AT+CMGL response.
+	RegexSMSListEntry = regexp.MustCompile(`^\+CMGL:\s*(\d+)\s*,\s*"([^"]*)"\s*,\s*"([^"]*)"`)
+	// RegexSMSRecipient matches a telephone number acceptable as an SMS recipient.
+	RegexSMSRecipient = regexp.MustCompile(`^\+?\d+$`)
+
+	// openModems are the modem devices currently open, they are shared among all users of the same device.
+	openModems      = make(map[string]*Modem)
+	openModemsMutex = new(sync.Mutex)
+)
+
+// SMS is a text message stored on the SIM card or in the memory of modem.
+type SMS struct {
+	Index  int    // Index is the storage location of the message.
+	Status string // Status is "REC UNREAD", "REC READ", "STO UNSENT", or "STO SENT".
+	Sender string // Sender is the telephone number of the sender.
+	Text   string // Text is the content of the message.
+}
+
+/*
+Modem speaks AT commands to a GSM/LTE modem connected to a serial device, in order to send and receive SMS in text
+mode. A modem device is shared by all of its users - modem device is opened by the first user and is closed when the
+last user closes it.
+*/
+type Modem struct {
+	DevicePath string
+	BaudRate   int
+
+	device     *os.File
+	refCount   int
+	lines      chan string
+	newMessage chan struct{}
+	// awaitingPrompt is 1 when the modem is expected to prompt for the text of an outgoing SMS.
+	awaitingPrompt int32
+	mutex          *sync.Mutex
+	logger         lalog.Logger
+}
+
+/*
+OpenModem opens the modem connected to the serial device, or returns the modem that is already open. The modem is
+configured to use text mode and to notify new messages. Baud rate of 0 uses the default speed.
+*/
+func OpenModem(devicePath string, baudRate int) (*Modem, error) {
+	openModemsMutex.Lock()
+	defer openModemsMutex.Unlock()
+	if modem, exists := openModems[devicePath]; exists {
+		modem.refCount++
+		return modem, nil
+	}
+	if baudRate < 1 {
+		baudRate = ModemDefaultBaudRate
+	}
+	modem := &Modem{
+		DevicePath: devicePath,
+		BaudRate:   baudRate,
+		refCount:   1,
+		lines:      make(chan string, 64),
+		newMessage: make(chan struct{}, 1),
+		mutex:      new(sync.Mutex),
+		logger:     lalog.Logger{ComponentName: "Modem", ComponentID: []lalog.LoggerIDField{{Key: "Dev", Value: devicePath}}},
+	}
+	var err error
+	if modem.device, err = os.OpenFile(devicePath, os.O_RDWR, 0600); err != nil {
+		return nil, fmt.Errorf("OpenModem: failed to open device - %v", err)
+	}
+	if err := modem.setSerialParameters(); err != nil {
+		_ = modem.device.Close()
+		return nil, fmt.Errorf("OpenModem: failed to set serial communication parameters - %v", err)
+	}
+	go modem.readLines()
+	// Disable echo, use text mode for SMS, and notify new message by +CMTI.
+	for _, cmd := range []string{"ATE0", "AT+CMGF=1", "AT+CNMI=2,1,0,0,0"} {
+		if _, err := modem.command(cmd, ModemCommandTimeoutSec, nil); err != nil {
+			_ = modem.device.Close()
+			return nil, fmt.Errorf("OpenModem: modem failed to carry out %s - %v", cmd, err)
+		}
+	}
+	openModems[devicePath] = modem
+	return modem, nil
+}
+
+// setSerialParameters configures baud rate of the serial device and puts it into raw mode.
+func (modem *Modem) setSerialParameters() error {
+	rawConn, err := modem.device.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		setErr = platform.SetSerialParameters(fd, modem.BaudRate, "none", 1)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
+
+// Close closes the modem device after all of its users have closed it.
+func (modem *Modem) Close() error {
+	openModemsMutex.Lock()
+	defer openModemsMutex.Unlock()
+	if modem.refCount--; modem.refCount > 0 {
+		return nil
+	}
+	delete(openModems, modem.DevicePath)
+	return modem.device.Close()
+}
+
+/*
+readLines continuously reads lines from the modem until the device is closed. Unsolicited notifications of new
+message (+CMTI) are turned into signals of NewMessage channel, the other lines go to the channel of lines.
+*/
+func (modem *Modem) readLines() {
+	defer close(modem.lines)
+	reader := bufio.NewReader(modem.device)
+	line := make([]byte, 0, 256)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			modem.logger.Info("readLines", "", err, "stopped reading from modem")
+			return
+		}
+		switch {
+		case b == '>' && len(line) == 0 && atomic.LoadInt32(&modem.awaitingPrompt) == 1:
+			// The prompt for text of an outgoing SMS does not end with a new line
+			modem.pushLine(">")
+		case b == '\r' || b == '\n':
+			if str := strings.TrimSpace(string(line)); str == "" {
+				// Skip empty lines
+			} else if strings.HasPrefix(str, "+CMTI:") {
+				select {
+				case modem.newMessage <- struct{}{}:
+				default:
+				}
+			} else {
+				modem.pushLine(str)
+			}
+			line = line[:0]
+		default:
+			line = append(line, b)
+		}
+	}
+}
+
+// pushLine places the line into the channel of lines, or discards it if nobody has been reading the lines for a while.
+func (modem *Modem) pushLine(line string) {
+	select {
+	case modem.lines <- line:
+	default:
+		modem.logger.Info("pushLine", "", nil, "discarded unexpected line \"%s\"", line)
+	}
+}
+
+// NewMessage returns a channel that signals the arrival of new messages.
+func (modem *Modem) NewMessage() <-chan struct{} {
+	return modem.newMessage
+}
+
+// waitForLine returns the next line from modem or an error if it does not arrive in time.
+func (modem *Modem) waitForLine(deadline <-chan time.Time) (string, error) {
+	select {
+	case line, ok := <-modem.lines:
+		if !ok {
+			return "", ErrModemClosed
+		}
+		return line, nil
+	case <-deadline:
+		return "", ErrModemTimeout
+	}
+}
+
+/*
+waitForResult collects response lines until the final result code arrives. It returns the lines in between if the
+result is OK, or an error if the result is an error.
+If the header expression is not nil, each line matching it is followed by exactly one line of message text, which is
+collected as-is even if it looks like a final result code - e.g. an SMS that says "OK".
+*/
+func (modem *Modem) waitForResult(cmd string, timeoutSec int, header *regexp.Regexp) ([]string, error) {
+	deadline := time.After(time.Duration(timeoutSec) * time.Second)
+	ret := make([]string, 0, 4)
+	for {
+		line, err := modem.waitForLine(deadline)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "OK":
+			return ret, nil
+		case line == "ERROR" || strings.HasPrefix(line, "+CMS ERROR") || strings.HasPrefix(line, "+CME ERROR"):
+			return nil, fmt.Errorf("modem responded with %s", line)
+		case line == cmd:
+			// Modem echoes command input before echo is turned off
+		default:
+			ret = append(ret, line)
+			if header != nil && header.MatchString(line) {
+				text, err := modem.waitForLine(deadline)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, text)
+			}
+		}
+	}
+}
+
+// drainLines discards leftover of earlier conversations, such as a response that arrived too late.
+func (modem *Modem) drainLines() {
+	for {
+		select {
+		case <-modem.lines:
+		default:
+			return
+		}
+	}
+}
+
+/*
+command sends an AT command to modem and waits for the response. Caller must lock the mutex. See waitForResult for the
+header expression.
+*/
+func (modem *Modem) command(cmd string, timeoutSec int, header *regexp.Regexp) ([]string, error) {
+	modem.drainLines()
+	if _, err := modem.device.Write([]byte(cmd + "\r")); err != nil {
+		return nil, err
+	}
+	return modem.waitForResult(cmd, timeoutSec, header)
+}
+
+// Command sends an AT command to modem and returns the response lines that precede the final result code.
+func (modem *Modem) Command(cmd string) ([]string, error) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.command(cmd, ModemCommandTimeoutSec, nil)
+}
+
+// SendSMS sends a text message to the telephone number, and returns the message reference given by the network.
+func (modem *Modem) SendSMS(number, text string) (string, error) {
+	if !RegexSMSRecipient.MatchString(number) {
+		return "", ErrBadSMSRecipient
+	}
+	if len(text) > MaxSMSLength {
+		return "", ErrSMSTooLong
+	}
+	// The text must not end the message prematurely
+	text = strings.Replace(text, modemCtrlZ, "", -1)
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	atomic.StoreInt32(&modem.awaitingPrompt, 1)
+	defer atomic.StoreInt32(&modem.awaitingPrompt, 0)
+	cmd := fmt.Sprintf(`AT+CMGS="%s"`, number)
+	modem.drainLines()
+	if _, err := modem.device.Write([]byte(cmd + "\r")); err != nil {
+		return "", err
+	}
+	deadline := time.After(ModemCommandTimeoutSec * time.Second)
+	for {
+		line, err := modem.waitForLine(deadline)
+		if err != nil {
+			return "", err
+		}
+		if line == ">" {
+			break
+		} else if line == "ERROR" || strings.HasPrefix(line, "+CMS ERROR") || strings.HasPrefix(line, "+CME ERROR") {
+			return "", fmt.Errorf("modem responded with %s", line)
+		}
+	}
+	atomic.StoreInt32(&modem.awaitingPrompt, 0)
+	if _, err := modem.device.Write([]byte(text + modemCtrlZ)); err != nil {
+		return "", err
+	}
+	lines, err := modem.waitForResult(text, ModemSendSMSTimeoutSec, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "+CMGS:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "+CMGS:")), nil
+		}
+	}
+	return "", nil
+}
+
+// ListSMS returns all text messages stored on modem.
+func (modem *Modem) ListSMS() ([]SMS, error) {
+	modem.mutex.Lock()
+	// The first line of text that follows each message header is never mistaken for the final result code
+	lines, err := modem.command(`AT+CMGL="ALL"`, ModemCommandTimeoutSec, RegexSMSListEntry)
+	modem.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]SMS, 0, len(lines)/2)
+	for i := 0; i < len(lines); i++ {
+		if params := RegexSMSListEntry.FindStringSubmatch(lines[i]); len(params) == 4 {
+			index, _ := strconv.Atoi(params[1])
+			msg := SMS{Index: index, Status: params[2], Sender: params[3]}
+			// The line that follows a header line is the message text, even if it looks like a header.
+			if i+1 < len(lines) {
+				i++
+				msg.Text = lines[i]
+			}
+			ret = append(ret, msg)
+		} else if len(ret) > 0 {
+			// Further lines belong to a message text that spans multiple lines
+			ret[len(ret)-1].Text += "\n" + lines[i]
+		}
+	}
+	return ret, nil
+}
+
+// DeleteSMS deletes the text message at the storage index.
+func (modem *Modem) DeleteSMS(index int) error {
+	_, err := modem.Command(fmt.Sprintf("AT+CMGD=%d", index))
+	return err
+}
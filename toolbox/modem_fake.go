@@ -0,0 +1,171 @@
+package toolbox
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/laitos/platform"
+)
+
+// RegexFakeModemSendSMS captures the recipient's number from AT command that sends an SMS.
+var RegexFakeModemSendSMS = regexp.MustCompile(`^AT\+CMGS="([^"]+)"$`)
+
+/*
+FakeModem emulates a GSM modem on a pseudo terminal to let test cases exercise the modem driver without real hardware.
+It understands the AT commands used by Modem, stores received messages, and memorises sent messages.
+*/
+type FakeModem struct {
+	DevicePath string // DevicePath is the serial device (pseudo terminal slave) for Modem to open.
+
+	master    *os.File
+	mutex     *sync.Mutex
+	stored    []SMS
+	sent      []SMS
+	nextIndex int
+	closed    bool
+}
+
+// StartFakeModem starts a fake modem on a new pseudo terminal.
+func StartFakeModem() (*FakeModem, error) {
+	master, slavePath, err := platform.OpenPseudoTerminal()
+	if err != nil {
+		return nil, err
+	}
+	fake := &FakeModem{
+		DevicePath: slavePath,
+		master:     master,
+		mutex:      new(sync.Mutex),
+		nextIndex:  1,
+	}
+	go fake.serve()
+	return fake, nil
+}
+
+// ReceiveSMS stores a new message from the sender and notifies the modem user.
+func (fake *FakeModem) ReceiveSMS(sender, text string) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.stored = append(fake.stored, SMS{Index: fake.nextIndex, Status: "REC UNREAD", Sender: sender, Text: text})
+	fake.write(fmt.Sprintf("\r\n+CMTI: \"SM\",%d\r\n", fake.nextIndex))
+	fake.nextIndex++
+}
+
+// GetStoredSMS returns the messages received by modem and not yet deleted.
+func (fake *FakeModem) GetStoredSMS() []SMS {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([]SMS{}, fake.stored...)
+}
+
+// GetSentSMS returns the messages sent by modem, the sender of each message is the recipient's number.
+func (fake *FakeModem) GetSentSMS() []SMS {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([]SMS{}, fake.sent...)
+}
+
+// Close stops the fake modem.
+func (fake *FakeModem) Close() error {
+	fake.mutex.Lock()
+	fake.closed = true
+	fake.mutex.Unlock()
+	return fake.master.Close()
+}
+
+// write writes the response to modem user. Caller must lock the mutex.
+func (fake *FakeModem) write(resp string) {
+	_, _ = fake.master.Write([]byte(resp))
+}
+
+// serve reads and responds to AT commands until the fake modem is closed.
+func (fake *FakeModem) serve() {
+	buf := make([]byte, 1024)
+	input := make([]byte, 0, 1024)
+	var recipient string
+	for {
+		n, err := fake.master.Read(buf)
+		if err != nil {
+			fake.mutex.Lock()
+			closed := fake.closed
+			fake.mutex.Unlock()
+			if closed {
+				return
+			}
+			// The read fails while the terminal is not open by any user
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		input = append(input, buf[:n]...)
+		for {
+			if recipient != "" {
+				// Collect SMS text until Ctrl+Z
+				end := strings.IndexByte(string(input), modemCtrlZ[0])
+				if end == -1 {
+					break
+				}
+				fake.mutex.Lock()
+				fake.sent = append(fake.sent, SMS{Index: len(fake.sent) + 1, Status: "STO SENT", Sender: recipient, Text: string(input[:end])})
+				fake.write(fmt.Sprintf("\r\n+CMGS: %d\r\n\r\nOK\r\n", len(fake.sent)))
+				fake.mutex.Unlock()
+				recipient = ""
+				input = input[end+1:]
+				continue
+			}
+			end := strings.IndexByte(string(input), '\r')
+			if end == -1 {
+				break
+			}
+			cmd := strings.TrimSpace(string(input[:end]))
+			input = input[end+1:]
+			if !strings.HasPrefix(strings.ToUpper(cmd), "AT") {
+				/*
+					Ignore the input that is not an AT command, such as the notifications echoed back by the pseudo
+					terminal before the modem user puts it into raw mode.
+				*/
+				continue
+			}
+			if params := RegexFakeModemSendSMS.FindStringSubmatch(cmd); len(params) == 2 {
+				recipient = params[1]
+				fake.mutex.Lock()
+				fake.write("\r\n> ")
+				fake.mutex.Unlock()
+				continue
+			}
+			fake.mutex.Lock()
+			fake.write(fake.respond(cmd))
+			fake.mutex.Unlock()
+		}
+	}
+}
+
+// respond returns the response to an AT command. Caller must lock the mutex.
+func (fake *FakeModem) respond(cmd string) string {
+	switch {
+	case cmd == "AT" || cmd == "ATE0" || cmd == "AT+CMGF=1" || strings.HasPrefix(cmd, "AT+CNMI="):
+		return "\r\nOK\r\n"
+	case cmd == `AT+CMGL="ALL"`:
+		var resp strings.Builder
+		for i, msg := range fake.stored {
+			resp.WriteString(fmt.Sprintf("\r\n+CMGL: %d,\"%s\",\"%s\",,\"20/01/01,00:00:00+00\"\r\n%s", msg.Index, msg.Status, msg.Sender, msg.Text))
+			fake.stored[i].Status = "REC READ"
+		}
+		resp.WriteString("\r\n\r\nOK\r\n")
+		return resp.String()
+	case strings.HasPrefix(cmd, "AT+CMGD="):
+		index, _ := strconv.Atoi(strings.TrimPrefix(cmd, "AT+CMGD="))
+		for i, msg := range fake.stored {
+			if msg.Index == index {
+				fake.stored = append(fake.stored[:i], fake.stored[i+1:]...)
+				return "\r\nOK\r\n"
+			}
+		}
+		return "\r\n+CMS ERROR: 321\r\n"
+	default:
+		return "\r\nERROR\r\n"
+	}
+}
@@ -0,0 +1,79 @@
+package toolbox
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModem(t *testing.T) {
+	fake, err := StartFakeModem()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer fake.Close()
+	modem, err := OpenModem(fake.DevicePath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The modem device is shared by its users
+	if shared, err := OpenModem(fake.DevicePath, 0); err != nil || shared != modem {
+		t.Fatal(err)
+	} else if err := shared.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer modem.Close()
+
+	if _, err := modem.Command("AT+UNKNOWN"); err == nil {
+		t.Fatal("did not error")
+	}
+	// Send SMS
+	if _, err := modem.SendSMS("+1abc", "hi"); err != ErrBadSMSRecipient {
+		t.Fatal(err)
+	}
+	if _, err := modem.SendSMS("+123", strings.Repeat("a", MaxSMSLength+1)); err != ErrSMSTooLong {
+		t.Fatal(err)
+	}
+	if ref, err := modem.SendSMS("+123", "hello > world"); err != nil || ref != "1" {
+		t.Fatal(ref, err)
+	}
+	if sent := fake.GetSentSMS(); len(sent) != 1 || sent[0].Sender != "+123" || sent[0].Text != "hello > world" {
+		t.Fatalf("%+v", sent)
+	}
+	// Receive SMS
+	fake.ReceiveSMS("+456", "first")
+	fake.ReceiveSMS("+789", "second")
+	// Message text that looks like a result code or a header is not mistaken for one
+	fake.ReceiveSMS("+111", "ERROR")
+	fake.ReceiveSMS("+222", `+CMGL: 9,"REC READ","+333"`)
+	fake.ReceiveSMS("+444", "OK")
+	select {
+	case <-modem.NewMessage():
+	case <-time.After(3 * time.Second):
+		t.Fatal("did not notify new message")
+	}
+	messages, err := modem.ListSMS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SMS{
+		{Index: 1, Status: "REC UNREAD", Sender: "+456", Text: "first"},
+		{Index: 2, Status: "REC UNREAD", Sender: "+789", Text: "second"},
+		{Index: 3, Status: "REC UNREAD", Sender: "+111", Text: "ERROR"},
+		{Index: 4, Status: "REC UNREAD", Sender: "+222", Text: `+CMGL: 9,"REC READ","+333"`},
+		{Index: 5, Status: "REC UNREAD", Sender: "+444", Text: "OK"},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("%+v", messages)
+	}
+	if err := modem.DeleteSMS(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := modem.DeleteSMS(1); err == nil {
+		t.Fatal("did not error")
+	}
+	if stored := fake.GetStoredSMS(); len(stored) != 4 || stored[0].Index != 2 {
+		t.Fatalf("%+v", stored)
+	}
+}
@@ -0,0 +1,354 @@
+package toolbox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"image"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	OTPTypeTOTP = "totp" // OTPTypeTOTP is the type of time-based one time password
+	OTPTypeHOTP = "hotp" // OTPTypeHOTP is the type of counter-based one time password
+
+	OTPDefaultAlgorithm = "SHA1" // OTPDefaultAlgorithm is the HMAC algorithm used when URI does not specify one
+	OTPDefaultDigits    = 6      // OTPDefaultDigits is the length of code used when URI does not specify one
+	OTPDefaultPeriod    = 30     // OTPDefaultPeriod is the TOTP interval in seconds used when URI does not specify one
+)
+
+// OTPAccount is an account configured for one time password generation, usually described by an "otpauth://" URI.
+type OTPAccount struct {
+	Name      string // Name is the account label, often in the format of "issuer:account".
+	Issuer    string // Issuer is the optional name of service provider.
+	Type      string // Type is either OTPTypeTOTP or OTPTypeHOTP.
+	Secret    []byte // Secret is the HMAC key.
+	Algorithm string // Algorithm is the HMAC hash algorithm - SHA1, SHA256, or SHA512.
+	Digits    int    // Digits is the length of code, either 6 or 8.
+	Period    int    // Period is the TOTP interval in seconds.
+	Counter   int64  // Counter is the initial HOTP counter value.
+}
+
+// DecodeOTPSecret decodes a base32 encoded secret, the input is tolerant of spaces, lower case, and missing padding.
+func DecodeOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimSpace(strings.Replace(secret, " ", "", -1)))
+	secret = strings.TrimRight(secret, "=")
+	// Secret is linted and padded with = to nearest 8 bytes
+	paddingLength := 8 - (len(secret) % 8)
+	if paddingLength < 8 {
+		secret += strings.Repeat("=", paddingLength)
+	}
+	return base32.StdEncoding.DecodeString(secret)
+}
+
+// newOTPHash returns the hash function constructor of the algorithm name.
+func newOTPHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTP algorithm \"%s\"", algorithm)
+	}
+}
+
+/*
+GetOTPCode returns the one time password calculated from the secret and counter (or TOTP time division), using the
+HMAC hash algorithm and code length. The algorithm is specified by RFC 4226 and RFC 6238.
+*/
+func GetOTPCode(secret []byte, counter int64, algorithm string, digits int) (string, error) {
+	newHash, err := newOTPHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if digits < 6 || digits > 8 {
+		return "", fmt.Errorf("unsupported OTP length %d", digits)
+	}
+	mac := hmac.New(newHash, secret)
+	counterMessage := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterMessage, uint64(counter))
+	if _, err := mac.Write(counterMessage); err != nil {
+		return "", err
+	}
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, truncated%modulo), nil
+}
+
+// Code returns the one time password of the account at the HOTP counter or TOTP time division.
+func (account OTPAccount) Code(counter int64) (string, error) {
+	return GetOTPCode(account.Secret, counter, account.Algorithm, account.Digits)
+}
+
+// URI returns the "otpauth://" URI that describes the account.
+func (account OTPAccount) URI() string {
+	params := url.Values{}
+	params.Set("secret", strings.TrimRight(base32.StdEncoding.EncodeToString(account.Secret), "="))
+	if account.Issuer != "" {
+		params.Set("issuer", account.Issuer)
+	}
+	if account.Algorithm != "" && account.Algorithm != OTPDefaultAlgorithm {
+		params.Set("algorithm", account.Algorithm)
+	}
+	if account.Digits != 0 && account.Digits != OTPDefaultDigits {
+		params.Set("digits", strconv.Itoa(account.Digits))
+	}
+	if account.Type == OTPTypeHOTP {
+		params.Set("counter", strconv.FormatInt(account.Counter, 10))
+	} else if account.Period != 0 && account.Period != OTPDefaultPeriod {
+		params.Set("period", strconv.Itoa(account.Period))
+	}
+	return (&url.URL{Scheme: "otpauth", Host: account.Type, Path: "/" + account.Name, RawQuery: params.Encode()}).String()
+}
+
+// validate fills in default parameters and makes sure that the account is usable for code generation.
+func (account *OTPAccount) validate() error {
+	if account.Type != OTPTypeTOTP && account.Type != OTPTypeHOTP {
+		return fmt.Errorf("unsupported OTP type \"%s\"", account.Type)
+	}
+	if len(account.Secret) == 0 {
+		return errors.New("OTP secret is empty")
+	}
+	if account.Algorithm == "" {
+		account.Algorithm = OTPDefaultAlgorithm
+	}
+	account.Algorithm = strings.ToUpper(account.Algorithm)
+	if _, err := newOTPHash(account.Algorithm); err != nil {
+		return err
+	}
+	if account.Digits == 0 {
+		account.Digits = OTPDefaultDigits
+	}
+	if account.Digits < 6 || account.Digits > 8 {
+		return fmt.Errorf("unsupported OTP length %d", account.Digits)
+	}
+	if account.Period == 0 {
+		account.Period = OTPDefaultPeriod
+	}
+	if account.Period < 0 || account.Counter < 0 {
+		return errors.New("OTP period and counter must not be negative")
+	}
+	return nil
+}
+
+// ParseOTPAuthURI parses an "otpauth://" URI, which is the format used by QR codes of two factor authentication.
+func ParseOTPAuthURI(uri string) (account OTPAccount, err error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return account, fmt.Errorf("ParseOTPAuthURI: failed to parse URI - %v", err)
+	}
+	if parsed.Scheme != "otpauth" {
+		return account, errors.New("ParseOTPAuthURI: URI does not begin with otpauth://")
+	}
+	params := parsed.Query()
+	account.Type = strings.ToLower(parsed.Host)
+	account.Name = strings.TrimPrefix(parsed.Path, "/")
+	account.Issuer = params.Get("issuer")
+	account.Algorithm = params.Get("algorithm")
+	if account.Secret, err = DecodeOTPSecret(params.Get("secret")); err != nil {
+		return account, fmt.Errorf("ParseOTPAuthURI: failed to decode secret - %v", err)
+	}
+	if digits := params.Get("digits"); digits != "" {
+		if account.Digits, err = strconv.Atoi(digits); err != nil {
+			return account, fmt.Errorf("ParseOTPAuthURI: failed to parse digits - %v", err)
+		}
+	}
+	if period := params.Get("period"); period != "" {
+		if account.Period, err = strconv.Atoi(period); err != nil {
+			return account, fmt.Errorf("ParseOTPAuthURI: failed to parse period - %v", err)
+		}
+	}
+	if counter := params.Get("counter"); counter != "" {
+		if account.Counter, err = strconv.ParseInt(counter, 10, 64); err != nil {
+			return account, fmt.Errorf("ParseOTPAuthURI: failed to parse counter - %v", err)
+		}
+	}
+	if err = account.validate(); err != nil {
+		return account, fmt.Errorf("ParseOTPAuthURI: %v", err)
+	}
+	return account, nil
+}
+
+// otpProtobufField is a field decoded from protocol buffer wire format.
+type otpProtobufField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// parseOTPProtobuf decodes the top level fields of a protocol buffer message.
+func parseOTPProtobuf(message []byte) ([]otpProtobufField, error) {
+	fields := make([]otpProtobufField, 0, 8)
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, errors.New("malformed field key")
+		}
+		message = message[n:]
+		field := otpProtobufField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if field.varint, n = binary.Uvarint(message); n <= 0 {
+				return nil, errors.New("malformed varint")
+			}
+			message = message[n:]
+		case 1:
+			if len(message) < 8 {
+				return nil, errors.New("malformed fixed64")
+			}
+			message = message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return nil, errors.New("malformed length-delimited field")
+			}
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		case 5:
+			if len(message) < 4 {
+				return nil, errors.New("malformed fixed32")
+			}
+			message = message[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+/*
+ParseOTPMigrationURI parses an "otpauth-migration://" URI, which is the format used by the QR codes that Google
+Authenticator exports accounts with. Each URI carries one or more accounts.
+*/
+func ParseOTPMigrationURI(uri string) ([]OTPAccount, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("ParseOTPMigrationURI: failed to parse URI - %v", err)
+	}
+	if parsed.Scheme != "otpauth-migration" {
+		return nil, errors.New("ParseOTPMigrationURI: URI does not begin with otpauth-migration://")
+	}
+	// The payload is standard base64 in which "+" may have been left unescaped
+	data := strings.Replace(parsed.Query().Get("data"), " ", "+", -1)
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if payload, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "=")); err != nil {
+			return nil, fmt.Errorf("ParseOTPMigrationURI: failed to decode payload - %v", err)
+		}
+	}
+	payloadFields, err := parseOTPProtobuf(payload)
+	if err != nil {
+		return nil, fmt.Errorf("ParseOTPMigrationURI: failed to decode payload - %v", err)
+	}
+	accounts := make([]OTPAccount, 0, len(payloadFields))
+	for _, payloadField := range payloadFields {
+		// Field 1 is the repeated OTP parameters
+		if payloadField.number != 1 {
+			continue
+		}
+		paramFields, err := parseOTPProtobuf(payloadField.bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ParseOTPMigrationURI: failed to decode account - %v", err)
+		}
+		account := OTPAccount{Type: OTPTypeTOTP}
+		for _, field := range paramFields {
+			switch field.number {
+			case 1:
+				account.Secret = field.bytes
+			case 2:
+				account.Name = string(field.bytes)
+			case 3:
+				account.Issuer = string(field.bytes)
+			case 4:
+				account.Algorithm = map[uint64]string{2: "SHA256", 3: "SHA512", 4: "MD5"}[field.varint]
+			case 5:
+				if field.varint == 2 {
+					account.Digits = 8
+				}
+			case 6:
+				if field.varint == 1 {
+					account.Type = OTPTypeHOTP
+				}
+			case 7:
+				account.Counter = int64(field.varint)
+			}
+		}
+		if err := account.validate(); err != nil {
+			return nil, fmt.Errorf("ParseOTPMigrationURI: account \"%s\" is unusable - %v", account.Name, err)
+		}
+		accounts = append(accounts, account)
+	}
+	if len(accounts) == 0 {
+		return nil, errors.New("ParseOTPMigrationURI: the payload does not contain an account")
+	}
+	return accounts, nil
+}
+
+// ParseOTPAccounts parses accounts from either an "otpauth://" or an "otpauth-migration://" URI.
+func ParseOTPAccounts(uri string) ([]OTPAccount, error) {
+	if strings.HasPrefix(strings.TrimSpace(uri), "otpauth-migration:") {
+		return ParseOTPMigrationURI(uri)
+	}
+	account, err := ParseOTPAuthURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return []OTPAccount{account}, nil
+}
+
+/*
+ReadOTPAccountsFromFile reads accounts from either an image of QR code (e.g. an export from authenticator app), or a
+text file that has one "otpauth://" or "otpauth-migration://" URI per line.
+*/
+func ReadOTPAccountsFromFile(filePath string) ([]OTPAccount, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadOTPAccountsFromFile: failed to read file - %v", err)
+	}
+	var uris []string
+	if _, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		uri, err := DecodeQRCodeImage(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("ReadOTPAccountsFromFile: failed to read QR code from \"%s\" - %v", filePath, err)
+		}
+		uris = []string{uri}
+	} else {
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "otpauth") {
+				uris = append(uris, line)
+			}
+		}
+	}
+	accounts := make([]OTPAccount, 0, len(uris))
+	for _, uri := range uris {
+		parsed, err := ParseOTPAccounts(uri)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, parsed...)
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("ReadOTPAccountsFromFile: \"%s\" does not contain an account", filePath)
+	}
+	return accounts, nil
+}
@@ -0,0 +1,136 @@
+package toolbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestGetOTPCode(t *testing.T) {
+	// Test vectors from RFC 4226 and RFC 6238
+	for _, tc := range []struct {
+		secret    string
+		counter   int64
+		algorithm string
+		digits    int
+		code      string
+	}{
+		{"12345678901234567890", 0, "SHA1", 6, "755224"},
+		{"12345678901234567890", 9, "SHA1", 6, "520489"},
+		{"12345678901234567890", 59 / 30, "SHA1", 8, "94287082"},
+		{"12345678901234567890123456789012", 59 / 30, "SHA256", 8, "46119246"},
+		{"1234567890123456789012345678901234567890123456789012345678901234", 59 / 30, "SHA512", 8, "90693936"},
+		{"1234567890123456789012345678901234567890123456789012345678901234", 1111111109 / 30, "sha512", 8, "25091201"},
+	} {
+		if code, err := GetOTPCode([]byte(tc.secret), tc.counter, tc.algorithm, tc.digits); err != nil || code != tc.code {
+			t.Fatal(tc, code, err)
+		}
+	}
+	if _, err := GetOTPCode([]byte("a"), 0, "MD5", 6); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := GetOTPCode([]byte("a"), 0, "SHA1", 5); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestParseOTPAuthURI(t *testing.T) {
+	account, err := ParseOTPAuthURI("otpauth://hotp/Example:alice@example.com?secret=jbswy3dpehpk3pxp&issuer=Example&algorithm=sha256&digits=8&counter=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "Example:alice@example.com" || account.Issuer != "Example" || account.Type != OTPTypeHOTP ||
+		string(account.Secret) != "Hello!\xde\xad\xbe\xef" || account.Algorithm != "SHA256" || account.Digits != 8 || account.Counter != 5 {
+		t.Fatalf("%+v", account)
+	}
+	// The URI round trip retains all parameters
+	if reparsed, err := ParseOTPAuthURI(account.URI()); err != nil || reparsed.Name != account.Name || reparsed.Counter != 5 ||
+		reparsed.Digits != 8 || reparsed.Algorithm != "SHA256" || string(reparsed.Secret) != string(account.Secret) {
+		t.Fatalf("%v %+v", err, reparsed)
+	}
+	// Default parameters
+	account, err = ParseOTPAuthURI("otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP&period=60")
+	if err != nil || account.Algorithm != "SHA1" || account.Digits != 6 || account.Period != 60 {
+		t.Fatalf("%v %+v", err, account)
+	}
+	for _, bad := range []string{
+		"http://totp/bob?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/bob?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/bob",
+		"otpauth://totp/bob?secret=1",
+		"otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP&digits=10",
+		"otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP&period=-1",
+	} {
+		if _, err := ParseOTPAuthURI(bad); err == nil {
+			t.Fatal("did not error", bad)
+		}
+	}
+}
+
+func TestParseOTPMigrationURI(t *testing.T) {
+	// Construct the protocol buffer payload of two accounts
+	param1 := []byte{0x0a, 4, 'a', 'b', 'c', 'd', 0x12, 5, 'a', 'l', 'i', 'c', 'e', 0x1a, 2, 'E', 'x', 0x20, 2, 0x28, 2, 0x30, 2}
+	param2 := []byte{0x0a, 2, 'x', 'y', 0x12, 3, 'b', 'o', 'b', 0x20, 1, 0x28, 1, 0x30, 1, 0x38, 7}
+	payload := append([]byte{0x0a, byte(len(param1))}, param1...)
+	payload = append(payload, 0x0a, byte(len(param2)))
+	payload = append(payload, param2...)
+	// Unknown fields such as version and batch size are skipped
+	payload = append(payload, 0x10, 1, 0x18, 1)
+	uri := "otpauth-migration://offline?data=" + url.QueryEscape(base64.StdEncoding.EncodeToString(payload))
+	accounts, err := ParseOTPAccounts(uri)
+	if err != nil || len(accounts) != 2 {
+		t.Fatal(err, accounts)
+	}
+	if a := accounts[0]; a.Name != "alice" || a.Issuer != "Ex" || string(a.Secret) != "abcd" || a.Type != OTPTypeTOTP || a.Algorithm != "SHA256" || a.Digits != 8 || a.Period != 30 {
+		t.Fatalf("%+v", a)
+	}
+	if b := accounts[1]; b.Name != "bob" || string(b.Secret) != "xy" || b.Type != OTPTypeHOTP || b.Algorithm != "SHA1" || b.Digits != 6 || b.Counter != 7 {
+		t.Fatalf("%+v", b)
+	}
+	for _, bad := range []string{
+		"otpauth-migration://offline?data=",
+		"otpauth-migration://offline?data=" + base64.StdEncoding.EncodeToString([]byte{0x0a, 10, 1}),
+		"otpauth://offline?data=" + base64.StdEncoding.EncodeToString(payload),
+	} {
+		if _, err := ParseOTPMigrationURI(bad); err == nil {
+			t.Fatal("did not error", bad)
+		}
+	}
+}
+
+func TestReadOTPAccountsFromFile(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "laitos-TestReadOTPAccountsFromFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	content := "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP\n\n  otpauth://hotp/bob?secret=JBSWY3DPEHPK3PXP&counter=1  \n"
+	if err := ioutil.WriteFile(tmp.Name(), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if accounts, err := ReadOTPAccountsFromFile(tmp.Name()); err != nil || len(accounts) != 2 || accounts[1].Name != "bob" {
+		t.Fatal(err, accounts)
+	}
+	// Read from a QR code image
+	var pngImage bytes.Buffer
+	if err := png.Encode(&pngImage, qrTestRender(qrTestEncode(t, "otpauth://totp/carol?secret=JBSWY3DPEHPK3PXP", 4, qrECLevelM, 1), 3, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tmp.Name(), pngImage.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if accounts, err := ReadOTPAccountsFromFile(tmp.Name()); err != nil || len(accounts) != 1 || accounts[0].Name != "carol" {
+		t.Fatal(err, accounts)
+	}
+	if err := ioutil.WriteFile(tmp.Name(), []byte("nothing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadOTPAccountsFromFile(tmp.Name()); err == nil {
+		t.Fatal("did not error")
+	}
+}
@@ -0,0 +1,869 @@
+package toolbox
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	// Register PNG decoder for DecodeQRCodeImage
+	_ "image/png"
+)
+
+/*
+This file implements a QR code decoder that reads the QR codes exported by authenticator apps as images, such as a
+screenshot or an image file. The decoder locates the three finder patterns to work out position, size, and rotation of
+the code, hence the code may be of any scale and rotation, but it should not be distorted by perspective. All versions
+(1-40) and error correction levels are supported, however the Kanji mode is not.
+*/
+
+var (
+	// ErrQRCodeNotFound is returned when the image does not appear to contain a QR code.
+	ErrQRCodeNotFound = errors.New("cannot find a QR code in the image")
+	// ErrQRCodeUnreadable is returned when a QR code is found but its content cannot be decoded.
+	ErrQRCodeUnreadable = errors.New("the QR code is damaged or unreadable")
+)
+
+// qrECLevel is the error correction level of a QR code, in the order of their format bits (M, L, H, Q).
+type qrECLevel int
+
+const (
+	qrECLevelL qrECLevel = iota
+	qrECLevelM
+	qrECLevelQ
+	qrECLevelH
+)
+
+// qrECLevelFormatBits are the two bits that represent each error correction level in format information.
+var qrECLevelFormatBits = [4]int{qrECLevelL: 1, qrECLevelM: 0, qrECLevelQ: 3, qrECLevelH: 2}
+
+// qrECCodewordsPerBlock and qrNumECBlocks are indexed by error correction level and version.
+var qrECCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrNumECBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrSize returns the number of modules along each side of a QR code of the version.
+func qrSize(version int) int {
+	return version*4 + 17
+}
+
+// qrNumRawDataModules returns the number of modules available for data and error correction codewords.
+func qrNumRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// qrAlignmentPatternPositions returns the row/column coordinates of alignment pattern centres.
+func qrAlignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	}
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, qrSize(version)-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// qrFormatBits returns the 15-bit format information of the error correction level and mask pattern.
+func qrFormatBits(level qrECLevel, mask int) int {
+	data := qrECLevelFormatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits returns the 18-bit version information of a version 7 or higher.
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	return version<<12 | rem
+}
+
+// qrMask returns true if the module at the column (x) and row (y) is to be inverted by the mask pattern.
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// qrFunctionModules returns a matrix (row, column) that tells the modules reserved for function patterns.
+func qrFunctionModules(version int) [][]bool {
+	size := qrSize(version)
+	ret := make([][]bool, size)
+	for i := range ret {
+		ret[i] = make([]bool, size)
+	}
+	fill := func(x, y, width, height int) {
+		for row := y; row < y+height; row++ {
+			for col := x; col < x+width; col++ {
+				if row >= 0 && row < size && col >= 0 && col < size {
+					ret[row][col] = true
+				}
+			}
+		}
+	}
+	// Timing patterns
+	fill(6, 0, 1, size)
+	fill(0, 6, size, 1)
+	// Finder patterns along with their separators and format information
+	fill(0, 0, 9, 9)
+	fill(size-8, 0, 8, 9)
+	fill(0, size-8, 9, 8)
+	// Alignment patterns that do not overlap the finder patterns
+	positions := qrAlignmentPatternPositions(version)
+	for i, row := range positions {
+		for j, col := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			fill(col-2, row-2, 5, 5)
+		}
+	}
+	// Version information
+	if version >= 7 {
+		fill(size-11, 0, 3, 6)
+		fill(0, size-11, 6, 3)
+	}
+	return ret
+}
+
+// qrDataCodewords returns the data codewords from the codewords read in placement order, after correcting errors.
+func qrDataCodewords(version int, level qrECLevel, codewords []byte) ([]byte, error) {
+	numBlocks := qrNumECBlocks[level][version]
+	ecLen := qrECCodewordsPerBlock[level][version]
+	rawCodewords := qrNumRawDataModules(version) / 8
+	if len(codewords) != rawCodewords {
+		return nil, ErrQRCodeUnreadable
+	}
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+	// De-interleave the codewords into blocks. Short blocks have one less data codeword than the long blocks.
+	blocks := make([][]byte, numBlocks)
+	for i := range blocks {
+		blockLen := shortBlockLen
+		if i >= numShortBlocks {
+			blockLen++
+		}
+		blocks[i] = make([]byte, 0, blockLen)
+	}
+	next := 0
+	for i := 0; i <= shortBlockLen; i++ {
+		for j := range blocks {
+			if i == shortBlockLen-ecLen && j < numShortBlocks {
+				// Only long blocks have the extra data codeword
+				continue
+			}
+			blocks[j] = append(blocks[j], codewords[next])
+			next++
+		}
+	}
+	ret := make([]byte, 0, rawCodewords)
+	for _, block := range blocks {
+		if err := qrCorrectErrors(block, ecLen); err != nil {
+			return nil, err
+		}
+		ret = append(ret, block[:len(block)-ecLen]...)
+	}
+	return ret, nil
+}
+
+// qrGFExp and qrGFLog are exponent and logarithm tables of GF(2^8) with reduction polynomial 0x11d used by QR codes.
+var (
+	qrGFExp [512]byte
+	qrGFLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		qrGFExp[i] = byte(x)
+		qrGFExp[i+255] = byte(x)
+		qrGFLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func qrGFMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return qrGFExp[qrGFLog[a]+qrGFLog[b]]
+}
+
+func qrGFDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return qrGFExp[qrGFLog[a]+255-qrGFLog[b]]
+}
+
+// qrGFPow returns alpha raised to the power.
+func qrGFPow(power int) byte {
+	power %= 255
+	if power < 0 {
+		power += 255
+	}
+	return qrGFExp[power]
+}
+
+// qrPolyEval evaluates the polynomial (coefficients of the lowest degree first) at x.
+func qrPolyEval(poly []byte, x byte) byte {
+	var y byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		y = qrGFMul(y, x) ^ poly[i]
+	}
+	return y
+}
+
+/*
+qrCorrectErrors corrects errors of a Reed-Solomon block in-place. The block consists of data codewords followed by
+ecLen error correction codewords, the first codeword is the coefficient of the highest degree.
+*/
+func qrCorrectErrors(block []byte, ecLen int) error {
+	n := len(block)
+	// Syndromes S_i = r(alpha^i)
+	syndromes := make([]byte, ecLen)
+	var hasError bool
+	for i := range syndromes {
+		var s byte
+		x := qrGFPow(i)
+		for _, c := range block {
+			s = qrGFMul(s, x) ^ c
+		}
+		syndromes[i] = s
+		hasError = hasError || s != 0
+	}
+	if !hasError {
+		return nil
+	}
+	// Berlekamp-Massey finds the error locator polynomial
+	locator := []byte{1}
+	prev := []byte{1}
+	numErrs, shift := 0, 1
+	prevDiscrepancy := byte(1)
+	for i := 0; i < ecLen; i++ {
+		discrepancy := syndromes[i]
+		for j := 1; j <= numErrs && j < len(locator); j++ {
+			discrepancy ^= qrGFMul(locator[j], syndromes[i-j])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		coef := qrGFDiv(discrepancy, prevDiscrepancy)
+		updated := make([]byte, int(math.Max(float64(len(locator)), float64(len(prev)+shift))))
+		copy(updated, locator)
+		for j, p := range prev {
+			updated[j+shift] ^= qrGFMul(coef, p)
+		}
+		if 2*numErrs <= i {
+			prev = locator
+			numErrs = i + 1 - numErrs
+			prevDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = updated
+	}
+	if 2*numErrs > ecLen {
+		return ErrQRCodeUnreadable
+	}
+	// Error evaluator polynomial Omega(x) = S(x) * Lambda(x) mod x^ecLen
+	evaluator := make([]byte, ecLen)
+	for i := 0; i < ecLen; i++ {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= qrGFMul(locator[j], syndromes[i-j])
+		}
+	}
+	// Formal derivative of the locator keeps the terms of odd degrees
+	derivative := make([]byte, len(locator))
+	for j := 1; j < len(locator); j += 2 {
+		derivative[j-1] = locator[j]
+	}
+	// Chien search finds the error positions, and Forney algorithm finds the error values.
+	var found int
+	for pos := 0; pos < n; pos++ {
+		xInverse := qrGFPow(-(n - 1 - pos))
+		if qrPolyEval(locator, xInverse) != 0 {
+			continue
+		}
+		denominator := qrPolyEval(derivative, xInverse)
+		if denominator == 0 {
+			return ErrQRCodeUnreadable
+		}
+		// With the first consecutive root being alpha^0, the error value is X * Omega(X^-1) / Lambda'(X^-1)
+		block[pos] ^= qrGFMul(qrGFPow(n-1-pos), qrGFDiv(qrPolyEval(evaluator, xInverse), denominator))
+		found++
+	}
+	if found != numErrs {
+		return ErrQRCodeUnreadable
+	}
+	return nil
+}
+
+// qrBitReader reads bits from a byte slice, most significant bit first.
+type qrBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (reader *qrBitReader) remaining() int {
+	return len(reader.data)*8 - reader.pos
+}
+
+func (reader *qrBitReader) read(numBits int) int {
+	ret := 0
+	for i := 0; i < numBits; i++ {
+		ret <<= 1
+		if reader.pos < len(reader.data)*8 && reader.data[reader.pos/8]&(0x80>>(reader.pos%8)) != 0 {
+			ret |= 1
+		}
+		reader.pos++
+	}
+	return ret
+}
+
+// qrDecodeSegments decodes the data segments from data codewords.
+func qrDecodeSegments(version int, data []byte) (string, error) {
+	const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+	sizeClass := 0
+	if version >= 27 {
+		sizeClass = 2
+	} else if version >= 10 {
+		sizeClass = 1
+	}
+	reader := &qrBitReader{data: data}
+	var ret strings.Builder
+	for reader.remaining() >= 4 {
+		mode := reader.read(4)
+		switch mode {
+		case 0: // terminator
+			return ret.String(), nil
+		case 1: // numeric
+			count := reader.read([]int{10, 12, 14}[sizeClass])
+			for ; count >= 3; count -= 3 {
+				ret.WriteString(fmt.Sprintf("%03d", reader.read(10)))
+			}
+			if count == 2 {
+				ret.WriteString(fmt.Sprintf("%02d", reader.read(7)))
+			} else if count == 1 {
+				ret.WriteString(fmt.Sprintf("%d", reader.read(4)))
+			}
+		case 2: // alphanumeric
+			count := reader.read([]int{9, 11, 13}[sizeClass])
+			for ; count >= 2; count -= 2 {
+				pair := reader.read(11)
+				if pair/45 >= len(alphanumeric) {
+					return "", ErrQRCodeUnreadable
+				}
+				ret.WriteByte(alphanumeric[pair/45])
+				ret.WriteByte(alphanumeric[pair%45])
+			}
+			if count == 1 {
+				ret.WriteByte(alphanumeric[reader.read(6)%45])
+			}
+		case 4: // byte
+			count := reader.read([]int{8, 16, 16}[sizeClass])
+			if count*8 > reader.remaining() {
+				return "", ErrQRCodeUnreadable
+			}
+			for i := 0; i < count; i++ {
+				ret.WriteByte(byte(reader.read(8)))
+			}
+		case 7: // ECI designator is ignored, the content is assumed to be UTF-8.
+			if reader.read(1) == 1 {
+				if reader.read(1) == 1 {
+					reader.read(22)
+				} else {
+					reader.read(14)
+				}
+			} else {
+				reader.read(7)
+			}
+		default:
+			return "", fmt.Errorf("unsupported QR code data mode %d", mode)
+		}
+	}
+	return ret.String(), nil
+}
+
+// qrImage is a binarised image.
+type qrImage struct {
+	width, height int
+	dark          []bool
+}
+
+// newQRImage binarises the image using the midpoint between the lightest and darkest luminance as threshold.
+func newQRImage(img image.Image) *qrImage {
+	bounds := img.Bounds()
+	ret := &qrImage{width: bounds.Dx(), height: bounds.Dy(), dark: make([]bool, bounds.Dx()*bounds.Dy())}
+	luminance := make([]uint8, len(ret.dark))
+	minLum, maxLum := uint8(255), uint8(0)
+	for y := 0; y < ret.height; y++ {
+		for x := 0; x < ret.width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			lum := color.GrayModel.Convert(color.NRGBA{R: c.R, G: c.G, B: c.B, A: 255}).(color.Gray).Y
+			if c.A < 128 {
+				// Transparent background is considered light
+				lum = 255
+			}
+			luminance[y*ret.width+x] = lum
+			if lum < minLum {
+				minLum = lum
+			}
+			if lum > maxLum {
+				maxLum = lum
+			}
+		}
+	}
+	threshold := (int(minLum) + int(maxLum)) / 2
+	for i, lum := range luminance {
+		ret.dark[i] = int(lum) <= threshold
+	}
+	return ret
+}
+
+func (img *qrImage) isDark(x, y int) bool {
+	if x < 0 || y < 0 || x >= img.width || y >= img.height {
+		return false
+	}
+	return img.dark[y*img.width+x]
+}
+
+// qrPoint is a point in the image.
+type qrPoint struct {
+	x, y float64
+}
+
+// qrFinderCandidate is a possible centre of finder pattern.
+type qrFinderCandidate struct {
+	centre     qrPoint
+	moduleSize float64
+	count      int
+}
+
+// qrIsFinderRatio returns true if the five runs of alternating colours approximate the ratio 1:1:3:1:1.
+func qrIsFinderRatio(runs [5]int) bool {
+	total := 0
+	for _, run := range runs {
+		if run == 0 {
+			return false
+		}
+		total += run
+	}
+	if total < 7 {
+		return false
+	}
+	module := float64(total) / 7
+	tolerance := module / 2
+	return math.Abs(module-float64(runs[0])) < tolerance && math.Abs(module-float64(runs[1])) < tolerance &&
+		math.Abs(3*module-float64(runs[2])) < 3*tolerance && math.Abs(module-float64(runs[3])) < tolerance &&
+		math.Abs(module-float64(runs[4])) < tolerance
+}
+
+/*
+crossCheck scans from the point along the direction (dx, dy) both ways, and returns the centre and total length of the
+finder pattern runs if they approximate the ratio 1:1:3:1:1.
+*/
+func (img *qrImage) crossCheck(x, y, dx, dy int) (centre float64, total int, ok bool) {
+	if !img.isDark(x, y) {
+		return 0, 0, false
+	}
+	var runs [5]int
+	// Walk backwards from the centre through dark, light, dark
+	bx, by := x, y
+	for state := 2; state >= 0; state-- {
+		for bx >= 0 && by >= 0 && bx < img.width && by < img.height && img.isDark(bx, by) == (state != 1) {
+			runs[state]++
+			bx, by = bx-dx, by-dy
+		}
+	}
+	backwardCentreRun := runs[2]
+	// Walk forwards from the centre through dark, light, dark
+	fx, fy := x+dx, y+dy
+	for state := 2; state < 5; state++ {
+		for fx >= 0 && fy >= 0 && fx < img.width && fy < img.height && img.isDark(fx, fy) == (state != 3) {
+			runs[state]++
+			fx, fy = fx+dx, fy+dy
+		}
+	}
+	if !qrIsFinderRatio(runs) {
+		return 0, 0, false
+	}
+	total = runs[0] + runs[1] + runs[2] + runs[3] + runs[4]
+	// The centre is the middle of the third run, measured along the direction of the scan
+	centreRunStart := x*dx + y*dy - backwardCentreRun + 1
+	return float64(centreRunStart) + float64(runs[2]-1)/2, total, true
+}
+
+// findFinderPatterns returns the centres of finder patterns found in the image.
+func (img *qrImage) findFinderPatterns() []qrFinderCandidate {
+	candidates := make([]qrFinderCandidate, 0, 8)
+	for y := 0; y < img.height; y++ {
+		var runs [5]int
+		state := 0
+		for x := 0; x <= img.width; x++ {
+			dark := img.isDark(x, y)
+			// Even states count dark runs, odd states count light runs.
+			if dark == (state%2 == 0) {
+				runs[state]++
+				continue
+			}
+			if state < 4 {
+				state++
+				runs[state] = 1
+				continue
+			}
+			// Five runs are complete when a light module follows the fifth (dark) run
+			if qrIsFinderRatio(runs) {
+				centreX := x - runs[4] - runs[3] - runs[2]/2 - 1
+				if cy, verticalTotal, ok := img.crossCheck(centreX, y, 0, 1); ok {
+					if cx, horizontalTotal, ok := img.crossCheck(centreX, int(cy), 1, 0); ok {
+						img.addCandidate(&candidates, qrPoint{x: cx, y: cy}, float64(verticalTotal+horizontalTotal)/14)
+					}
+				}
+			}
+			// Shift the runs by two to look for a pattern that begins at the third run
+			runs = [5]int{runs[2], runs[3], runs[4], 1, 0}
+			state = 3
+		}
+	}
+	return candidates
+}
+
+// addCandidate merges the finder pattern centre into an existing nearby candidate, or adds a new candidate.
+func (img *qrImage) addCandidate(candidates *[]qrFinderCandidate, centre qrPoint, moduleSize float64) {
+	for i := range *candidates {
+		existing := &(*candidates)[i]
+		if math.Abs(existing.centre.x-centre.x) <= 1.5*moduleSize && math.Abs(existing.centre.y-centre.y) <= 1.5*moduleSize {
+			n := float64(existing.count)
+			existing.centre = qrPoint{x: (existing.centre.x*n + centre.x) / (n + 1), y: (existing.centre.y*n + centre.y) / (n + 1)}
+			existing.moduleSize = (existing.moduleSize*n + moduleSize) / (n + 1)
+			existing.count++
+			return
+		}
+	}
+	*candidates = append(*candidates, qrFinderCandidate{centre: centre, moduleSize: moduleSize, count: 1})
+}
+
+func qrDistance(a, b qrPoint) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// qrGrid maps module coordinates to image coordinates.
+type qrGrid struct {
+	img                 *qrImage
+	size                int
+	topLeft, right, dow qrPoint // right and dow are the image displacements of one module along a row and a column
+}
+
+// isDark returns the colour of the module at the column (x) and row (y).
+func (grid *qrGrid) isDark(x, y int) bool {
+	dx, dy := float64(x-3), float64(y-3)
+	px := grid.topLeft.x + dx*grid.right.x + dy*grid.dow.x
+	py := grid.topLeft.y + dx*grid.right.y + dy*grid.dow.y
+	return grid.img.isDark(int(math.Floor(px+0.5)), int(math.Floor(py+0.5)))
+}
+
+// newQRGrid returns the module grid of the version, positioned by the centres of the three finder patterns.
+func newQRGrid(img *qrImage, version int, topLeft, topRight, bottomLeft qrPoint) *qrGrid {
+	size := qrSize(version)
+	span := float64(size - 7)
+	return &qrGrid{
+		img:     img,
+		size:    size,
+		topLeft: topLeft,
+		right:   qrPoint{x: (topRight.x - topLeft.x) / span, y: (topRight.y - topLeft.y) / span},
+		dow:     qrPoint{x: (bottomLeft.x - topLeft.x) / span, y: (bottomLeft.y - topLeft.y) / span},
+	}
+}
+
+// readVersion reads the version information of a large QR code, it returns 0 if the information is unreadable.
+func (grid *qrGrid) readVersion() int {
+	var bits1, bits2 int
+	for i := 17; i >= 0; i-- {
+		a, b := grid.size-11+i%3, i/3
+		bits1 <<= 1
+		bits2 <<= 1
+		if grid.isDark(a, b) {
+			bits1 |= 1
+		}
+		if grid.isDark(b, a) {
+			bits2 |= 1
+		}
+	}
+	bestVersion, bestDistance := 0, 4
+	for version := 7; version <= 40; version++ {
+		expected := qrVersionBits(version)
+		for _, bits := range []int{bits1, bits2} {
+			if distance := qrBitDistance(bits, expected); distance < bestDistance {
+				bestVersion, bestDistance = version, distance
+			}
+		}
+	}
+	return bestVersion
+}
+
+// readFormat reads the error correction level and mask pattern.
+func (grid *qrGrid) readFormat() (level qrECLevel, mask int, err error) {
+	var bits1, bits2 int
+	for i := 0; i < 15; i++ {
+		var x1, y1, x2, y2 int
+		switch {
+		case i < 6:
+			x1, y1 = 8, i
+		case i < 8:
+			x1, y1 = 8, i+1
+		case i == 8:
+			x1, y1 = 7, 8
+		default:
+			x1, y1 = 14-i, 8
+		}
+		if i < 8 {
+			x2, y2 = grid.size-1-i, 8
+		} else {
+			x2, y2 = 8, grid.size-15+i
+		}
+		if grid.isDark(x1, y1) {
+			bits1 |= 1 << i
+		}
+		if grid.isDark(x2, y2) {
+			bits2 |= 1 << i
+		}
+	}
+	bestDistance := 4
+	for _, candidateLevel := range []qrECLevel{qrECLevelL, qrECLevelM, qrECLevelQ, qrECLevelH} {
+		for candidateMask := 0; candidateMask < 8; candidateMask++ {
+			expected := qrFormatBits(candidateLevel, candidateMask)
+			for _, bits := range []int{bits1, bits2} {
+				if distance := qrBitDistance(bits, expected); distance < bestDistance {
+					level, mask, bestDistance = candidateLevel, candidateMask, distance
+				}
+			}
+		}
+	}
+	if bestDistance > 3 {
+		return 0, 0, ErrQRCodeUnreadable
+	}
+	return level, mask, nil
+}
+
+// readCodewords reads the codewords in placement order after removing the mask.
+func (grid *qrGrid) readCodewords(version, mask int) []byte {
+	function := qrFunctionModules(version)
+	codewords := make([]byte, qrNumRawDataModules(version)/8)
+	i := 0
+	for right := grid.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern
+			right = 5
+		}
+		for vert := 0; vert < grid.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					// Move upwards
+					y = grid.size - 1 - vert
+				}
+				if function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				if grid.isDark(x, y) != qrMask(mask, x, y) {
+					codewords[i/8] |= 0x80 >> (i % 8)
+				}
+				i++
+			}
+		}
+	}
+	return codewords
+}
+
+func qrBitDistance(a, b int) int {
+	distance := 0
+	for diff := a ^ b; diff != 0; diff &= diff - 1 {
+		distance++
+	}
+	return distance
+}
+
+// decodeGrid decodes the content of QR code from its module grid.
+func (grid *qrGrid) decode(version int) (string, error) {
+	level, mask, err := grid.readFormat()
+	if err != nil {
+		return "", err
+	}
+	data, err := qrDataCodewords(version, level, grid.readCodewords(version, mask))
+	if err != nil {
+		return "", err
+	}
+	return qrDecodeSegments(version, data)
+}
+
+// qrFinderTriple is a combination of three finder patterns that may belong to a QR code.
+type qrFinderTriple struct {
+	topLeft, topRight, bottomLeft qrPoint
+	moduleSize                    float64
+	count                         int
+}
+
+/*
+qrFinderTriples returns the combinations of finder patterns that form a right isosceles triangle, which is the
+characteristic of a QR code. The combinations are sorted by how often their finder patterns were detected.
+*/
+func qrFinderTriples(candidates []qrFinderCandidate) []qrFinderTriple {
+	// Data modules occasionally resemble a finder pattern, but genuine ones are detected more often.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].count > candidates[j].count
+	})
+	if len(candidates) > 10 {
+		candidates = candidates[:10]
+	}
+	triples := make([]qrFinderTriple, 0, 8)
+	for i := 0; i < len(candidates); i++ {
+		for j := i + 1; j < len(candidates); j++ {
+			for k := j + 1; k < len(candidates); k++ {
+				a, b, c := candidates[i], candidates[j], candidates[k]
+				minModule := math.Min(a.moduleSize, math.Min(b.moduleSize, c.moduleSize))
+				maxModule := math.Max(a.moduleSize, math.Max(b.moduleSize, c.moduleSize))
+				if maxModule > minModule*1.4 {
+					continue
+				}
+				// The top left finder pattern is at the right angle, which is opposite to the longest side.
+				ab, bc, ac := qrDistance(a.centre, b.centre), qrDistance(b.centre, c.centre), qrDistance(a.centre, c.centre)
+				if ab >= bc && ab >= ac {
+					a, c = c, a
+				} else if ac >= ab && ac >= bc {
+					a, b = b, a
+				}
+				leg1, leg2, hypotenuse := qrDistance(a.centre, b.centre), qrDistance(a.centre, c.centre), qrDistance(b.centre, c.centre)
+				if math.Abs(leg1-leg2) > 0.1*math.Max(leg1, leg2) || math.Abs(hypotenuse-math.Hypot(leg1, leg2)) > 0.1*hypotenuse {
+					continue
+				}
+				triple := qrFinderTriple{
+					topLeft:    a.centre,
+					topRight:   b.centre,
+					bottomLeft: c.centre,
+					moduleSize: (a.moduleSize + b.moduleSize + c.moduleSize) / 3,
+					count:      a.count + b.count + c.count,
+				}
+				// Tell top right and bottom left apart, the y axis of image points downwards.
+				if (triple.topRight.x-triple.topLeft.x)*(triple.bottomLeft.y-triple.topLeft.y)-(triple.topRight.y-triple.topLeft.y)*(triple.bottomLeft.x-triple.topLeft.x) < 0 {
+					triple.topRight, triple.bottomLeft = triple.bottomLeft, triple.topRight
+				}
+				triples = append(triples, triple)
+			}
+		}
+	}
+	sort.SliceStable(triples, func(i, j int) bool {
+		return triples[i].count > triples[j].count
+	})
+	return triples
+}
+
+// decode samples the QR code located by the finder patterns and decodes its content.
+func (triple qrFinderTriple) decode(img *qrImage) (string, error) {
+	// Estimate the version from the distance between finder patterns
+	modules := (qrDistance(triple.topLeft, triple.topRight)+qrDistance(triple.topLeft, triple.bottomLeft))/2/triple.moduleSize + 7
+	version := int(math.Floor((modules-17)/4 + 0.5))
+	if version < 1 || version > 40 {
+		return "", ErrQRCodeNotFound
+	}
+	if version >= 7 {
+		// Large QR codes carry version information, which is more reliable than the estimate.
+		if readVersion := newQRGrid(img, version, triple.topLeft, triple.topRight, triple.bottomLeft).readVersion(); readVersion != 0 {
+			version = readVersion
+		}
+	}
+	// Try the estimated version first, and then the adjacent versions in case of a slight misestimate.
+	var lastErr error
+	for _, candidateVersion := range []int{version, version - 1, version + 1} {
+		if candidateVersion < 1 || candidateVersion > 40 {
+			continue
+		}
+		content, err := newQRGrid(img, candidateVersion, triple.topLeft, triple.topRight, triple.bottomLeft).decode(candidateVersion)
+		if err == nil {
+			return content, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// DecodeQRCode decodes the text content of the QR code found in the image.
+func DecodeQRCode(img image.Image) (string, error) {
+	binarised := newQRImage(img)
+	triples := qrFinderTriples(binarised.findFinderPatterns())
+	if len(triples) == 0 {
+		return "", ErrQRCodeNotFound
+	}
+	var lastErr error
+	for _, triple := range triples {
+		content, err := triple.decode(binarised)
+		if err == nil {
+			return content, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// DecodeQRCodeImage decodes the text content of the QR code found in a PNG image.
+func DecodeQRCodeImage(reader io.Reader) (string, error) {
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return "", fmt.Errorf("DecodeQRCodeImage: failed to decode image - %v", err)
+	}
+	return DecodeQRCode(img)
+}